@@ -1,16 +1,28 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/ajvb/kala/api/middleware"
+	"github.com/ajvb/kala/archive"
 	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/metrics"
+	"github.com/ajvb/kala/utils/logging"
+	"github.com/ajvb/kala/validation"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
 )
@@ -22,10 +34,18 @@ const (
 	JobPath    = "job/"
 	ApiJobPath = ApiUrlPrefix + JobPath
 
+	// Path of the Prometheus exporter
+	MetricsPath = "/metrics"
+
+	// Path of the readiness check
+	ReadyzPath = "/readyz"
+
 	contentType     = "Content-Type"
 	jsonContentType = "application/json;charset=UTF-8"
 )
 
+var log = logging.GetLogger(logging.API)
+
 type KalaStatsResponse struct {
 	Stats *job.KalaStats
 }
@@ -47,6 +67,77 @@ func HandleKalaStatsRequest(cache job.JobCache) func(w http.ResponseWriter, r *h
 	}
 }
 
+type OverviewResponse struct {
+	Overview *job.Overview `json:"overview"`
+}
+
+// HandleOverviewRequest is the handler for getting a summary of the whole scheduler
+// /api/v1/overview
+func HandleOverviewRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := &OverviewResponse{
+			Overview: job.NewOverview(cache),
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+			return
+		}
+	}
+}
+
+type ScheduleLoadResponse struct {
+	ScheduleLoad *job.ScheduleLoad `json:"schedule_load"`
+}
+
+// HandleScheduleLoadRequest is the handler for getting the runs jobs are
+// scheduled to start in every minute of the next 24 hours
+// /api/v1/admin/schedule-load
+func HandleScheduleLoadRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := &ScheduleLoadResponse{
+			ScheduleLoad: job.NewScheduleLoad(cache, time.Now()),
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+			return
+		}
+	}
+}
+
+// HandleRebalanceRequest is the handler for spreading the jobs which start
+// in the same minute over a window, e.g. ?window=10m, proposing the moves of
+// their next runs, or applying them with apply=true
+// /api/v1/admin/schedule-load/rebalance
+func HandleRebalanceRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := job.DefaultSplayWindow
+		if param := r.URL.Query().Get("window"); param != "" {
+			var err error
+			window, err = parseWindow(param)
+			if err != nil {
+				errorEncodeJSON(err, http.StatusBadRequest, w)
+				return
+			}
+		}
+		apply := r.URL.Query().Get("apply") == "true"
+		report := job.Rebalance(cache, window, apply)
+		if apply && len(report.Moves) != 0 {
+			log.Infof("Moved the next runs of %d jobs to spread them over %s", len(report.Moves), window)
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
 type ListJobStatsResponse struct {
 	JobStats []*job.JobStat `json:"job_stats"`
 }
@@ -55,15 +146,45 @@ type ListJobStatsResponse struct {
 // /api/v1/job/stats/{id}
 func HandleListJobStatsRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := mux.Vars(r)["id"]
-		j, err := cache.Get(id)
+		j, err := getJob(cache, r)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			encodeGetJobError(err, w)
 			return
 		}
+		j = j.Copy()
 
 		resp := &ListJobStatsResponse{
-			JobStats: j.Stats,
+			JobStats: j.AllStats(),
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+			return
+		}
+	}
+}
+
+type JobMetricsResponse struct {
+	Metrics metrics.JobCounts `json:"metrics"`
+}
+
+// HandleJobMetricsRequest is the handler for getting aggregated run metrics of a job
+// /api/v1/job/{id}/stats
+func HandleJobMetricsRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+		j = j.Copy()
+
+		jc, _ := metrics.Default().JobCounts(j.Id)
+		jc.Name, jc.Owner = j.Name, j.Owner
+		resp := &JobMetricsResponse{
+			Metrics: jc,
 		}
 
 		w.Header().Set(contentType, jsonContentType)
@@ -75,20 +196,325 @@ func HandleListJobStatsRequest(cache job.JobCache) func(w http.ResponseWriter, r
 	}
 }
 
+const defaultSummaryWindow = 7 * 24 * time.Hour
+
+type JobStatsSummaryResponse struct {
+	Summary *job.JobStatsSummary `json:"summary"`
+}
+
+// HandleJobStatsSummaryRequest is the handler for getting aggregated stats of a job
+// over a window, e.g. /api/v1/job/{id}/stats/summary?window=7d, of the runs
+// by an engine alone with the engine query parameter, e.g. engine=v2.
+func HandleJobStatsSummaryRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+		j = j.Copy()
+
+		window := defaultSummaryWindow
+		if param := r.URL.Query().Get("window"); param != "" {
+			window, err = parseWindow(param)
+			if err != nil {
+				errorEncodeJSON(err, http.StatusBadRequest, w)
+				return
+			}
+		}
+
+		resp := &JobStatsSummaryResponse{
+			Summary: j.StatsSummary(window),
+		}
+		if engine := r.URL.Query().Get("engine"); engine != "" {
+			if engine != job.EngineV1 && engine != job.EngineV2 {
+				errorEncodeJSON(job.ErrInvalidEngine, http.StatusBadRequest, w)
+				return
+			}
+			resp.Summary = j.EngineStatsSummary(engine, window)
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+			return
+		}
+	}
+}
+
+type JobFreshnessResponse struct {
+	Freshness *job.Freshness `json:"freshness"`
+}
+
+// HandleJobFreshnessRequest is the handler for getting how recent the last
+// successful run of a job is, and whether it is within the job's FreshnessSLA.
+func HandleJobFreshnessRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+
+		resp := &JobFreshnessResponse{
+			Freshness: j.Freshness(time.Now()),
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+			return
+		}
+	}
+}
+
+const (
+	defaultExecutionsLimit = 100
+	maxExecutionsLimit     = 1000
+)
+
+type ListExecutionsResponse struct {
+	Executions []*job.JobStat `json:"executions"`
+}
+
+// HandleListExecutionsRequest is the handler for listing the runs of a job,
+// most recent first, filtered with the status (succeeded or failed), since
+// and until (RFC 3339 times), engine (v1 or v2) and limit query parameters, e.g.
+// /api/v1/job/{id}/executions?status=failed&since=2017-06-04T00:00:00Z&limit=10
+func HandleListExecutionsRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+
+		q, err := parseStatsQuery(r)
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		if err := json.NewEncoder(w).Encode(&ListExecutionsResponse{Executions: j.QueryStats(q)}); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+type ReplayExecutionResponse struct {
+	Execution *job.JobStat `json:"execution"`
+}
+
+// HandleReplayExecutionRequest is the handler for running a job again with
+// the template context of one of its runs: the time it ran, the time it was
+// due and the message which triggered it. It responds with the stat of the
+// replay, or 404 Not Found if the job no longer has the stat of the run.
+// /api/v1/job/{id}/executions/{runId}/replay
+func HandleReplayExecutionRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+
+		if wait := job.GetNamespaces().RunQuotaWait(j, time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			errorEncodeJSON(job.ErrMaxRuns, http.StatusTooManyRequests, w)
+			return
+		}
+
+		stat, err := j.Replay(runContext(r), cache, mux.Vars(r)["runId"])
+		switch err {
+		case nil:
+		case job.ErrExecutionNotFound:
+			errorEncodeJSON(err, http.StatusNotFound, w)
+			return
+		default:
+			errorEncodeJSON(err, http.StatusConflict, w)
+			return
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(&ReplayExecutionResponse{Execution: stat}); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+// BackfillRequest is the range of windows a backfill runs a job for.
+type BackfillRequest struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type BackfillResponse struct {
+	Windows []job.Window `json:"windows"`
+}
+
+// HandleBackfillRequest is the handler for running a job for every window of
+// its schedule within a range, e.g. to process past days with a daily job.
+// The runs are enqueued and run one after the other in the background, and
+// the response lists their windows.
+// /api/v1/job/{id}/backfill
+func HandleBackfillRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+
+		req := BackfillRequest{}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+
+		windows, err := j.BackfillWindows(req.Start, req.End)
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+		go j.Backfill(runContext(r), cache, windows)
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(&BackfillResponse{Windows: windows}); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+func parseStatsQuery(r *http.Request) (job.StatsQuery, error) {
+	query := r.URL.Query()
+	q := job.StatsQuery{Limit: defaultExecutionsLimit}
+
+	switch status := query.Get("status"); status {
+	case "":
+	case "succeeded", "failed":
+		success := status == "succeeded"
+		q.Success = &success
+	default:
+		return q, fmt.Errorf("Invalid status %q. Should be succeeded or failed", status)
+	}
+
+	switch q.Engine = query.Get("engine"); q.Engine {
+	case "", job.EngineV1, job.EngineV2:
+	default:
+		return q, fmt.Errorf("Invalid engine %q. Should be v1 or v2", q.Engine)
+	}
+
+	for param, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return q, fmt.Errorf("Invalid %s %q. Should be an RFC 3339 time, e.g. 2017-06-04T00:00:00Z", param, value)
+			}
+			*t = parsed
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxExecutionsLimit {
+			return q, fmt.Errorf("Invalid limit %q. Should be between 1 and %d", value, maxExecutionsLimit)
+		}
+		q.Limit = limit
+	}
+	return q, nil
+}
+
+// maxWindowDays is the longest window, in days, so that windows neither
+// overflow nor take a bucket for each of too many days.
+const maxWindowDays = 366
+
+// parseWindow parses a window such as "7d" or "2w", falling back to
+// time.ParseDuration for units smaller than a day (e.g. "12h").
+func parseWindow(window string) (time.Duration, error) {
+	errInvalid := fmt.Errorf("Invalid window %q. Should look like: 30m, 12h, 7d or 2w, up to %dd", window, maxWindowDays)
+
+	var d time.Duration
+	unit := window[len(window)-1]
+	switch unit {
+	case 'd', 'w':
+		n, err := strconv.Atoi(window[:len(window)-1])
+		if err != nil {
+			return 0, errInvalid
+		}
+		// Checked before multiplying, so that it doesn't overflow.
+		if n > maxWindowDays {
+			return 0, errInvalid
+		}
+		d = time.Duration(n) * 24 * time.Hour
+		if unit == 'w' {
+			d *= 7
+		}
+	default:
+		var err error
+		d, err = time.ParseDuration(window)
+		if err != nil {
+			return 0, errInvalid
+		}
+	}
+	if d <= 0 || d > maxWindowDays*24*time.Hour {
+		return 0, errInvalid
+	}
+	return d, nil
+}
+
 type ListJobsResponse struct {
 	Jobs map[string]*job.Job `json:"jobs"`
 }
 
 // HandleListJobs responds with an array of all Jobs within the server,
-// active or disabled.
+// active or disabled, or of those with the owner, name, tag and namespace
+// given as query parameters. It responds with 304 Not Modified if the jobs didn't
+// change since the ETag given in If-None-Match.
 func HandleListJobsRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		allJobs := cache.GetAll()
-		allJobs.Lock.RLock()
-		defer allJobs.Lock.RUnlock()
+		if notModified(w, r, jobsETag()) {
+			return
+		}
 
-		resp := &ListJobsResponse{
-			Jobs: allJobs.Jobs,
+		query := r.URL.Query()
+		filter := job.JobFilter{
+			Owner:     query.Get("owner"),
+			Name:      query.Get("name"),
+			Tag:       query.Get("tag"),
+			Namespace: query.Get("namespace"),
+			Group:     query.Get("group"),
+		}
+		if ns := namespaceOf(r); ns != "" {
+			filter.Namespace = ns
+		} else if filter.Namespace != "" && !authorizeNamespace(w, r, filter.Namespace) {
+			return
+		}
+		resp := &ListJobsResponse{}
+		if filter == (job.JobFilter{}) {
+			resp.Jobs = cache.GetAllSnapshot()
+			for id, j := range resp.Jobs {
+				if namespaceError(r, j.Namespace) != nil {
+					delete(resp.Jobs, id)
+				}
+				j.RedactSecrets()
+			}
+		} else {
+			resp.Jobs = map[string]*job.Job{}
+			for _, j := range authorizedJobs(r, cache.Find(filter)) {
+				c := j.Copy()
+				c.RedactSecrets()
+				resp.Jobs[j.Id] = c
+			}
 		}
 
 		w.Header().Set(contentType, jsonContentType)
@@ -100,6 +526,87 @@ func HandleListJobsRequest(cache job.JobCache) func(w http.ResponseWriter, r *ht
 	}
 }
 
+type SearchJobsResponse struct {
+	Jobs []*job.Job `json:"jobs"`
+}
+
+// HandleSearchJobsRequest is the handler for searching jobs with a query,
+// see job.Query. It responds with the matching jobs, ordered by id.
+// GET /api/v1/job/search?q=name~"backup" AND owner="data"
+func HandleSearchJobsRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := job.ParseQuery(r.URL.Query().Get("q"))
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+
+		resp := &SearchJobsResponse{Jobs: []*job.Job{}}
+		for _, j := range authorizedJobs(r, cache.Search(q)) {
+			c := j.Copy()
+			c.RedactSecrets()
+			resp.Jobs = append(resp.Jobs, c)
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+type GraphResponse struct {
+	Graph *job.Graph `json:"graph"`
+}
+
+const dotContentType = "text/vnd.graphviz;charset=UTF-8"
+
+// HandleGraphRequest is the handler for getting the graph of the jobs which
+// depend on each other, see job.Graph. It responds in the DOT language of
+// Graphviz with format=dot.
+// GET /api/v1/graph?format=dot
+func HandleGraphRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encodeGraph(job.NewGraph(cache), w, r)
+	}
+}
+
+// HandleJobGraphRequest is the handler for getting the graph of the jobs
+// linked to a job, through parents, children and on failure jobs.
+// GET /api/v1/job/{id}/graph?format=dot
+func HandleJobGraphRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := getJob(cache, r); err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+		g, err := job.NewJobGraph(cache, mux.Vars(r)["id"])
+		if err != nil {
+			errorEncodeJSON(err, http.StatusNotFound, w)
+			return
+		}
+		encodeGraph(g, w, r)
+	}
+}
+
+func encodeGraph(g *job.Graph, w http.ResponseWriter, r *http.Request) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+	case "dot":
+		w.Header().Set(contentType, dotContentType)
+		io.WriteString(w, g.DOT())
+		return
+	default:
+		errorEncodeJSON(fmt.Errorf("Invalid format %q. Should be json or dot", format), http.StatusBadRequest, w)
+		return
+	}
+
+	w.Header().Set(contentType, jsonContentType)
+	if err := json.NewEncoder(w).Encode(&GraphResponse{Graph: g}); err != nil {
+		log.Errorf("Error occured when marshalling response: %s", err)
+	}
+}
+
 type AddJobResponse struct {
 	Id string `json:"id"`
 }
@@ -122,8 +629,16 @@ func unmarshalNewJob(r *http.Request) (*job.Job, error) {
 	return newJob, nil
 }
 
+// runContext returns the context for job runs triggered by the request, so that
+// they can be correlated with it. It deliberately doesn't derive from the request's
+// context, as runs may outlive the request.
+func runContext(r *http.Request) context.Context {
+	return job.WithRequestId(context.Background(), middleware.RequestIDFromContext(r.Context()))
+}
+
 // HandleAddJob takes a job object and unmarshals it to a Job type,
-// and then throws the job in the schedulers.
+// and then throws the job in the schedulers. Jobs created in a namespace
+// are rejected with 403 Forbidden beyond the quota of the namespace.
 func HandleAddJob(cache job.JobCache, defaultOwner string) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		newJob, err := unmarshalNewJob(r)
@@ -135,12 +650,29 @@ func HandleAddJob(cache job.JobCache, defaultOwner string) func(http.ResponseWri
 		if defaultOwner != "" && newJob.Owner == "" {
 			newJob.Owner = defaultOwner
 		}
+		if ns := namespaceOf(r); ns != "" {
+			if newJob.Namespace != "" && newJob.Namespace != ns {
+				errorEncodeJSON(ErrNamespaceMismatch, http.StatusBadRequest, w)
+				return
+			}
+			newJob.Namespace = ns
+		} else if !authorizeNamespace(w, r, newJob.Namespace) {
+			return
+		}
+
+		if err := validation.Job(newJob, cache); err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+		if err := job.GetNamespaces().CheckQuota(cache, newJob); err != nil {
+			errorEncodeJSON(err, http.StatusForbidden, w)
+			return
+		}
 
-		err = newJob.Init(cache)
+		err = newJob.InitWithContext(runContext(r), cache)
 		if err != nil {
-			errStr := "Error occured when initializing the job"
-			log.Errorf(errStr+": %s", err)
-			errorEncodeJSON(errors.New(errStr), http.StatusBadRequest, w)
+			log.Errorf("Error occured when initializing the job: %s", err)
+			jobErrorEncodeJSON(err, w)
 			return
 		}
 
@@ -161,29 +693,80 @@ func HandleAddJob(cache job.JobCache, defaultOwner string) func(http.ResponseWri
 // handleDeleteJob if its a DELETE or handleGetJob if its a GET request.
 func HandleJobRequest(cache job.JobCache, db job.JobDB) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := mux.Vars(r)["id"]
-
-		j, err := cache.Get(id)
+		j, err := getJob(cache, r)
 		if err != nil {
-			log.Errorf("Error occured when trying to get the job you requested.")
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		if j == nil {
-			w.WriteHeader(http.StatusNotFound)
+			encodeGetJobError(err, w)
 			return
 		}
 
 		if r.Method == "DELETE" {
-			err = j.Delete(cache, db)
+			err = j.DeleteWithContext(r.Context(), cache, db)
 			if err != nil {
-				errorEncodeJSON(err, http.StatusInternalServerError, w)
+				errorEncodeJSON(err, dbErrorStatus(err), w)
 			} else {
 				w.WriteHeader(http.StatusNoContent)
 			}
 		} else if r.Method == "GET" {
-			handleGetJob(w, r, j)
+			handleGetJob(w, r, j.Copy())
+		}
+	}
+}
+
+// ErrResourceVersionRequired is returned for updates of jobs without the
+// resource version they were read at.
+var ErrResourceVersionRequired = errors.New("The resource_version of the job is required to update it, get the job for its current one")
+
+// HandleUpdateJobRequest is the handler for replacing the settings of a job,
+// see job.Job.Update. The job must have the resource_version the settings
+// were read at, and if the job changed since, e.g. as another operator
+// updated its schedule, it responds with 409 Conflict rather than clobbering
+// the change. It responds with the updated job.
+// PUT /api/v1/job/{id}/
+func HandleUpdateJobRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
 		}
+		spec, err := unmarshalNewJob(r)
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+		if spec.ResourceVersion == 0 {
+			errorEncodeJSON(ErrResourceVersionRequired, http.StatusBadRequest, w)
+			return
+		}
+		if ns := namespaceOf(r); ns != "" {
+			if spec.Namespace != "" && spec.Namespace != ns {
+				errorEncodeJSON(ErrNamespaceMismatch, http.StatusBadRequest, w)
+				return
+			}
+			spec.Namespace = ns
+		} else if !authorizeNamespace(w, r, spec.Namespace) {
+			return
+		}
+
+		current := j.Copy()
+		if err := validation.Update(spec, current, cache); err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+		if spec.Namespace != current.Namespace {
+			if err := job.GetNamespaces().CheckQuota(cache, spec); err != nil {
+				errorEncodeJSON(err, http.StatusForbidden, w)
+				return
+			}
+		}
+		if err := j.Update(cache, spec, spec.ResourceVersion); err == job.ErrStaleResourceVersion {
+			errorEncodeJSON(err, http.StatusConflict, w)
+			return
+		} else if err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+		handleGetJob(w, r, j.Copy())
 	}
 }
 
@@ -191,8 +774,15 @@ func HandleJobRequest(cache job.JobCache, db job.JobDB) func(w http.ResponseWrit
 // DELETE /api/v1/job/all
 func HandleDeleteAllJobs(cache job.JobCache, db job.JobDB) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := job.DeleteAll(cache, db); err != nil {
-			errorEncodeJSON(err, http.StatusInternalServerError, w)
+		// The jobs of the namespaces the client has no token of aren't
+		// deleted, so nor is any job.
+		for _, j := range cache.GetAllSnapshot() {
+			if !authorizeNamespace(w, r, j.Namespace) {
+				return
+			}
+		}
+		if err := job.DeleteAllWithContext(r.Context(), cache, db); err != nil {
+			errorEncodeJSON(err, dbErrorStatus(err), w)
 		} else {
 			w.WriteHeader(http.StatusNoContent)
 		}
@@ -203,7 +793,10 @@ type JobResponse struct {
 	Job *job.Job `json:"job"`
 }
 
+// handleGetJob responds with the job, which should be a copy, with its
+// secrets redacted.
 func handleGetJob(w http.ResponseWriter, r *http.Request, j *job.Job) {
+	j.RedactSecrets()
 	resp := &JobResponse{
 		Job: j,
 	}
@@ -220,20 +813,20 @@ func handleGetJob(w http.ResponseWriter, r *http.Request, j *job.Job) {
 // /api/v1/job/start/{id}
 func HandleStartJobRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := mux.Vars(r)["id"]
-		j, err := cache.Get(id)
+		j, err := getJob(cache, r)
 		if err != nil {
-			log.Errorf("Error occured when trying to get the job you requested.")
-			w.WriteHeader(http.StatusNotFound)
+			encodeGetJobError(err, w)
 			return
 		}
-		if j == nil {
-			w.WriteHeader(http.StatusNotFound)
+
+		if wait := job.GetNamespaces().RunQuotaWait(j, time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			errorEncodeJSON(job.ErrMaxRuns, http.StatusTooManyRequests, w)
 			return
 		}
 
 		j.StopTimer()
-		j.Run(cache)
+		j.RunWithContext(runContext(r), cache)
 
 		w.WriteHeader(http.StatusNoContent)
 	}
@@ -243,19 +836,14 @@ func HandleStartJobRequest(cache job.JobCache) func(w http.ResponseWriter, r *ht
 // /api/v1/job/disable/{id}
 func HandleDisableJobRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := mux.Vars(r)["id"]
-		j, err := cache.Get(id)
+		j, err := getJob(cache, r)
 		if err != nil {
-			log.Errorf("Error occured when trying to get the job you requested.")
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-		if j == nil {
-			w.WriteHeader(http.StatusNotFound)
+			encodeGetJobError(err, w)
 			return
 		}
 
-		j.Disable()
+		j.DisableWithInfo(job.DisabledInfo{Source: job.DisabledByUser, At: time.Now()})
+		j.NotifyDisabled()
 
 		w.WriteHeader(http.StatusNoContent)
 	}
@@ -265,66 +853,479 @@ func HandleDisableJobRequest(cache job.JobCache) func(w http.ResponseWriter, r *
 // /api/v1/job/enable/{id}
 func HandleEnableJobRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		id := mux.Vars(r)["id"]
-		j, err := cache.Get(id)
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+
+		j.Enable(cache)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DisableJobRequest is the optional body of requests disabling a job, telling
+// who disables it and why.
+type DisableJobRequest struct {
+	By     string `json:"by"`
+	Reason string `json:"reason"`
+}
+
+// readDisableJobRequest reads the optional DisableJobRequest of the request.
+func readDisableJobRequest(r *http.Request) (DisableJobRequest, error) {
+	req := DisableJobRequest{}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+	if err != nil {
+		return req, err
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		err = json.Unmarshal(body, &req)
+	}
+	return req, err
+}
+
+// HandleDisableJobWithReasonRequest is the handler for disabling jobs,
+// recording who disabled them, when and why, and responding with the job.
+// POST /api/v1/job/{id}/disable
+func HandleDisableJobWithReasonRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+
+		req, err := readDisableJobRequest(r)
 		if err != nil {
-			log.Errorf("Error occured when trying to get the job you requested.")
-			w.WriteHeader(http.StatusNotFound)
+			errorEncodeJSON(err, http.StatusBadRequest, w)
 			return
 		}
-		if j == nil {
-			w.WriteHeader(http.StatusNotFound)
+
+		j.DisableWithInfo(job.DisabledInfo{
+			Source: job.DisabledByUser,
+			By:     req.By,
+			At:     time.Now(),
+			Reason: req.Reason,
+		})
+		j.NotifyDisabled()
+
+		handleGetJob(w, r, j.Copy())
+	}
+}
+
+// HandleEnableJobWithResponseRequest is the handler for enabling jobs, which
+// responds with the job.
+// POST /api/v1/job/{id}/enable
+func HandleEnableJobWithResponseRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
 			return
 		}
 
 		j.Enable(cache)
 
+		handleGetJob(w, r, j.Copy())
+	}
+}
+
+var (
+	// ErrNoBackupStore is returned for backups which should be stored without
+	// a backup store.
+	ErrNoBackupStore = errors.New("No backup store is configured, see --backup-url")
+
+	backupArchiver     *archive.Archiver
+	backupArchiverLock sync.RWMutex
+)
+
+// SetBackupArchiver sets where backups requested with ?store=true are stored.
+func SetBackupArchiver(a *archive.Archiver) {
+	backupArchiverLock.Lock()
+	defer backupArchiverLock.Unlock()
+	backupArchiver = a
+}
+
+type BackupResponse struct {
+	Location string `json:"location"`
+	Jobs     int    `json:"jobs"`
+}
+
+// HandleBackupRequest is the handler for backing up all jobs with their stats.
+// The backup is a snapshot of the cache, with every job copied under its
+// lock. It's streamed in the response, or with ?store=true stored in the
+// backup store and its location returned. Restore it with `kala restore`.
+// POST /api/v1/admin/backup
+func HandleBackupRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs := cache.GetAllSnapshot()
+		now := time.Now().UTC()
+		name := "kala-backup-" + now.Format("20060102T150405Z") + ".json"
+
+		if r.URL.Query().Get("store") != "true" {
+			w.Header().Set(contentType, jsonContentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+			// Unlike stored backups, streamed backups leave the server.
+			for _, j := range jobs {
+				j.RedactSecrets()
+			}
+			if err := job.WriteBackup(w, jobs, now); err != nil {
+				log.Errorf("Error occured writing the backup: %s", err)
+			}
+			return
+		}
+
+		backupArchiverLock.RLock()
+		archiver := backupArchiver
+		backupArchiverLock.RUnlock()
+		if archiver == nil {
+			errorEncodeJSON(ErrNoBackupStore, http.StatusBadRequest, w)
+			return
+		}
+		buf := new(bytes.Buffer)
+		if err := job.WriteBackup(buf, jobs, now); err != nil {
+			errorEncodeJSON(err, http.StatusInternalServerError, w)
+			return
+		}
+		location, err := archiver.Put(name, buf.Bytes(), "application/json")
+		if err != nil {
+			log.Errorf("Error occured storing the backup: %s", err)
+			errorEncodeJSON(err, http.StatusBadGateway, w)
+			return
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(&BackupResponse{Location: location, Jobs: len(jobs)}); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+var (
+	// ErrNoReload is returned for reloads when nothing was set to reload the
+	// configuration, e.g. when the API isn't served by `kala run`.
+	ErrNoReload = errors.New("Reloading the configuration isn't supported")
+
+	reloadFunc     func() error
+	reloadFuncLock sync.RWMutex
+)
+
+// SetReloadFunc sets how the configuration is reloaded by the reload route.
+// The configuration should be left unchanged if the function fails.
+func SetReloadFunc(reload func() error) {
+	reloadFuncLock.Lock()
+	defer reloadFuncLock.Unlock()
+	reloadFunc = reload
+}
+
+// HandleReloadRequest is the handler for reloading the configuration, as on
+// SIGHUP, without restarting the scheduler or interrupting running jobs.
+// An invalid configuration is rejected and the current one kept.
+// POST /api/v1/admin/reload
+func HandleReloadRequest() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reloadFuncLock.RLock()
+		reload := reloadFunc
+		reloadFuncLock.RUnlock()
+		if reload == nil {
+			errorEncodeJSON(ErrNoReload, http.StatusNotImplemented, w)
+			return
+		}
+		if err := reload(); err != nil {
+			log.Errorf("Error occured reloading the configuration: %s", err)
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-type apiError struct {
-	Error string `json:"error"`
+// ErrNotPersisting is returned by the admin route persisting the jobs for
+// caches which don't persist them on demand.
+var ErrNotPersisting = errors.New("The job cache doesn't persist the jobs on demand")
+
+// HandlePersistRequest is the handler for saving the jobs which changed since
+// they were last saved right away, or all of them with ?all=true, rather than
+// waiting for the next periodic persist, e.g. before maintenance of the job
+// database. It responds with how many jobs were saved, or with a 500 telling
+// the error of every job which couldn't be saved.
+// POST /api/v1/admin/persist
+func HandlePersistRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		persisting, ok := cache.(job.PersistingCache)
+		if !ok {
+			errorEncodeJSON(ErrNotPersisting, http.StatusNotImplemented, w)
+			return
+		}
+		report, err := persisting.PersistNow(r.Context(), r.URL.Query().Get("all") == "true")
+		if report != nil && len(report.Errors) != 0 {
+			details := []ErrorDetail{}
+			for id, message := range report.Errors {
+				details = append(details, ErrorDetail{Field: "jobs." + id, Message: message})
+			}
+			sort.Slice(details, func(i, j int) bool { return details[i].Field < details[j].Field })
+			encodeAPIError(w, http.StatusInternalServerError, &apiError{Code: CodeInternal, Message: err.Error(), Details: details})
+			return
+		}
+		if err != nil {
+			log.Errorf("Error occured persisting the jobs: %s", err)
+			errorEncodeJSON(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+// PersistStatusResponse tells how the persist cycles went, and which jobs
+// couldn't be saved.
+type PersistStatusResponse struct {
+	metrics.PersistCounts
+	// Jobs which couldn't be saved since Kala started, by id.
+	Failures []JobPersistFailures `json:"failures"`
 }
 
-func errorEncodeJSON(errToEncode error, status int, w http.ResponseWriter) {
-	js, err := json.Marshal(apiError{Error: errToEncode.Error()})
-	if err != nil {
-		log.Errorf("could not encode error message: %v", err)
-		return
+// JobPersistFailures tells how many times a job couldn't be saved.
+type JobPersistFailures struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Owner    string `json:"owner"`
+	Failures uint64 `json:"failures"`
+}
+
+// HandlePersistStatusRequest is the handler for getting the counters of the
+// persist cycles, and how many times each job which couldn't be saved
+// wasn't, e.g. to find the jobs a database rejects.
+// GET /api/v1/admin/persist
+func HandlePersistStatusRequest() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := metrics.Default()
+		resp := &PersistStatusResponse{PersistCounts: m.PersistCounts(), Failures: []JobPersistFailures{}}
+		for _, jc := range m.AllJobCounts() {
+			if jc.PersistFailures != 0 {
+				resp.Failures = append(resp.Failures, JobPersistFailures{Id: jc.Id, Name: jc.Name, Owner: jc.Owner, Failures: jc.PersistFailures})
+			}
+		}
+		sort.Slice(resp.Failures, func(i, j int) bool { return resp.Failures[i].Id < resp.Failures[j].Id })
+
+		w.Header().Set(contentType, jsonContentType)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+// ErrNotCompactable is returned by the admin routes of the job database for
+// databases which aren't stored in a compactable file.
+var ErrNotCompactable = errors.New("The job database doesn't report its size or support compaction")
+
+// HandleDBStatsRequest is the handler for getting the size of the file of the
+// job database, and the stats of its free pages.
+// GET /api/v1/admin/db
+func HandleDBStatsRequest(db job.JobDB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		compactable, ok := job.UnwrapDB(db).(job.CompactableDB)
+		if !ok {
+			errorEncodeJSON(ErrNotCompactable, http.StatusNotImplemented, w)
+			return
+		}
+		stats, err := compactable.DBStats()
+		if err != nil {
+			errorEncodeJSON(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		if err := json.NewEncoder(w).Encode(&stats); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+// HandleCompactDBRequest is the handler for compacting the file of the job
+// database. It responds with the stats of the compacted file.
+// POST /api/v1/admin/db/compact
+func HandleCompactDBRequest(db job.JobDB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		compactable, ok := job.UnwrapDB(db).(job.CompactableDB)
+		if !ok {
+			errorEncodeJSON(ErrNotCompactable, http.StatusNotImplemented, w)
+			return
+		}
+		if err := compactable.Compact(r.Context()); err != nil {
+			log.Errorf("Error occured compacting the job database: %s", err)
+			errorEncodeJSON(err, http.StatusInternalServerError, w)
+			return
+		}
+		stats, err := compactable.DBStats()
+		if err != nil {
+			errorEncodeJSON(err, http.StatusInternalServerError, w)
+			return
+		}
+		metrics.RecordDBStats(stats)
+
+		w.Header().Set(contentType, jsonContentType)
+		if err := json.NewEncoder(w).Encode(&stats); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+// HandleConsistencyRequest is the handler for checking the links between
+// jobs, see job.ConsistencyReport. It responds with the dependency cycles and
+// the broken links, without changing anything.
+// GET /api/v1/admin/consistency
+func HandleConsistencyRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		encodeConsistencyReport(job.CheckConsistency(cache, false), w)
+	}
+}
+
+// HandleFixConsistencyRequest is the handler for fixing the broken links
+// between jobs. It responds like HandleConsistencyRequest, with the links it
+// fixed.
+// POST /api/v1/admin/consistency/fix
+func HandleFixConsistencyRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := job.CheckConsistency(cache, true)
+		if len(report.Problems) != 0 {
+			log.Infof("Fixed %d broken links between jobs", len(report.Problems))
+		}
+		encodeConsistencyReport(report, w)
 	}
+}
+
+func encodeConsistencyReport(report *job.ConsistencyReport, w http.ResponseWriter) {
 	w.Header().Set(contentType, jsonContentType)
-	http.Error(w, string(js), status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Errorf("Error occured when marshalling response: %s", err)
+	}
+}
+
+// warmingCache is implemented by caches which report the progress of loading
+// their jobs, see job.WarmUpStatus.
+type warmingCache interface {
+	WarmUpStatus() job.WarmUpStatus
+}
+
+// healthCheckedDB is implemented by JobDBs which check the health of their
+// connection to the database, see job.HealthCheckedDB.
+type healthCheckedDB interface {
+	Health() job.DBHealth
+}
+
+// ReadyzResponse is the progress of loading the jobs from the db, and the
+// health of the db if it's checked.
+type ReadyzResponse struct {
+	job.WarmUpStatus
+
+	DB *job.DBHealth `json:"db,omitempty"`
 }
 
-// SetupApiRoutes is used within main to initialize all of the routes
+// HandleReadyzRequest is the handler for the readiness check, which responds
+// with a 503 until the cache loaded all jobs from the db, or while the db is
+// unhealthy, and with the progress of loading them and the health of the db.
+// GET /readyz
+func HandleReadyzRequest(cache job.JobCache, db job.JobDB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := ReadyzResponse{WarmUpStatus: job.WarmUpStatus{Ready: true}}
+		if warming, ok := cache.(warmingCache); ok {
+			status.WarmUpStatus = warming.WarmUpStatus()
+		}
+		ready := status.Ready
+		if checked, ok := db.(healthCheckedDB); ok {
+			health := checked.Health()
+			status.DB = &health
+			ready = ready && health.Healthy
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(&status); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+// SetupApiRoutes is used within main to initialize all of the routes, which
+// are listed in apiRoutes.
 func SetupApiRoutes(r *mux.Router, cache job.JobCache, db job.JobDB, defaultOwner string) {
-	// Route for creating a job
-	r.HandleFunc(ApiJobPath, HandleAddJob(cache, defaultOwner)).Methods("POST")
-	// Route for deleting all jobs
-	r.HandleFunc(ApiJobPath+"all/", HandleDeleteAllJobs(cache, db)).Methods("DELETE")
-	// Route for deleting and getting a job
-	r.HandleFunc(ApiJobPath+"{id}/", HandleJobRequest(cache, db)).Methods("DELETE", "GET")
-	// Route for getting job stats
-	r.HandleFunc(ApiJobPath+"stats/{id}/", HandleListJobStatsRequest(cache)).Methods("GET")
-	// Route for listing all jops
-	r.HandleFunc(ApiJobPath, HandleListJobsRequest(cache)).Methods("GET")
-	// Route for manually start a job
-	r.HandleFunc(ApiJobPath+"start/{id}/", HandleStartJobRequest(cache)).Methods("POST")
-	// Route for manually start a job
-	r.HandleFunc(ApiJobPath+"enable/{id}/", HandleEnableJobRequest(cache)).Methods("POST")
-	// Route for manually disable a job
-	r.HandleFunc(ApiJobPath+"disable/{id}/", HandleDisableJobRequest(cache)).Methods("POST")
-	// Route for getting app-level metrics
-	r.HandleFunc(ApiUrlPrefix+"stats/", HandleKalaStatsRequest(cache)).Methods("GET")
+	for _, route := range apiRoutes(cache, db, defaultOwner) {
+		r.HandleFunc(route.path, route.handler).Methods(route.method)
+	}
 }
 
-func StartServer(listenAddr string, cache job.JobCache, db job.JobDB, defaultOwner string) error {
+// Server is the HTTP server of the API, which shuts down gracefully: Drain
+// stops accepting changes to jobs, and Shutdown waits for the requests being
+// served.
+type Server struct {
+	http      *http.Server
+	readOnly  *middleware.ReadOnly
+	cors      *middleware.CORS
+	rateLimit *middleware.RateLimit
+}
+
+func NewServer(listenAddr string, cache job.JobCache, db job.JobDB, defaultOwner string) *Server {
 	r := mux.NewRouter()
 	// Allows for the use for /job as well as /job/
 	r.StrictSlash(true)
+	r.NotFoundHandler = http.HandlerFunc(handleNotFound)
 	SetupApiRoutes(r, cache, db, defaultOwner)
-	n := negroni.New(negroni.NewRecovery(), &middleware.Logger{log.Logger{}})
+	s := &Server{readOnly: &middleware.ReadOnly{}, cors: &middleware.CORS{}, rateLimit: &middleware.RateLimit{
+		// The namespace tokens are the only tokens Kala knows.
+		ValidToken: func(token string) bool { return job.GetNamespaces().ValidToken(token) },
+	}}
+	n := negroni.New(negroni.NewRecovery(), &middleware.RequestID{}, &middleware.Logger{Entry: log},
+		&middleware.SecurityHeaders{}, s.cors, s.rateLimit, s.readOnly, &middleware.Gzip{})
 	n.UseHandler(r)
-	return http.ListenAndServe(listenAddr, n)
+	s.http = &http.Server{Addr: listenAddr, Handler: n}
+	return s
+}
+
+// SetCORS lets browsers on other origins call the API, see middleware.CORS.
+// It should be called before ListenAndServe.
+func (s *Server) SetCORS(cors middleware.CORS) {
+	*s.cors = cors
+}
+
+// SetRateLimit limits the requests per second of every client, see
+// middleware.RateLimit. It may be called while the API is served, e.g. when
+// the configuration is reloaded.
+func (s *Server) SetRateLimit(rate float64, burst int, trustForwardedFor bool) {
+	s.rateLimit.Set(rate, burst, trustForwardedFor)
+}
+
+// ListenAndServe serves the API until the server is shut down, and then
+// returns http.ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	return s.http.ListenAndServe()
+}
+
+// Serve serves the API on the listener, e.g. to pick a free port, until the
+// server is shut down, and then returns http.ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	return s.http.Serve(l)
+}
+
+// Drain rejects the requests which could change jobs from now on.
+func (s *Server) Drain(ctx context.Context) error {
+	s.readOnly.Enable()
+	return nil
+}
+
+// Shutdown stops serving the API, waiting for the requests being served until
+// ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+func StartServer(listenAddr string, cache job.JobCache, db job.JobDB, defaultOwner string) error {
+	return NewServer(listenAddr, cache, db, defaultOwner).ListenAndServe()
 }
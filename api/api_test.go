@@ -2,18 +2,26 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"strings"
 	"time"
 
+	"github.com/ajvb/kala/api/middleware"
+	"github.com/ajvb/kala/archive"
 	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/metrics"
 
 	"testing"
 
+	"github.com/codegangsta/negroni"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -131,11 +139,47 @@ func (a *ApiTestSuite) TestHandleAddJobFailureBadSchedule() {
 	a.NoError(err)
 	w, req := setupTestReq(t, "POST", ApiJobPath, jsonJobMap)
 	handler(w, req)
-	a.Equal(w.Code, http.StatusBadRequest)
+	a.Equal(http.StatusUnprocessableEntity, w.Code)
 	var respErr apiError
 	err = json.Unmarshal(w.Body.Bytes(), &respErr)
 	a.NoError(err)
-	a.True(strings.Contains(respErr.Error, "when initializing"))
+	a.Equal(CodeInvalid, respErr.Code)
+	a.Equal([]ErrorDetail{{Field: "schedule", Message: `should look like R/2014-03-08T20:00:00Z/PT2H, got "asdf"`}}, respErr.Details)
+}
+
+func (a *ApiTestSuite) TestErrorResponses() {
+	cache := job.NewMockCache()
+	ts := httptest.NewServer(NewServer("", cache, &job.MockDB{}, "").http.Handler)
+	defer ts.Close()
+
+	for _, path := range []string{ApiJobPath + "not-a-real-id/", ApiJobPath + "stats/not-a-real-id/", "/not-a-route"} {
+		_, req := setupTestReq(a.T(), "GET", ts.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		a.Equal(http.StatusNotFound, resp.StatusCode, path)
+		var respErr apiError
+		unmarshallRequestBody(a.T(), resp, &respErr)
+		a.Equal(CodeNotFound, respErr.Code, path)
+		a.NotEmpty(respErr.Message, path)
+	}
+
+	// Invalid jobs are told apart from malformed requests.
+	_, req := setupTestReq(a.T(), "POST", ts.URL+ApiJobPath, []byte(`{"name": "mock_job"}`))
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+	var respErr apiError
+	unmarshallRequestBody(a.T(), resp, &respErr)
+	a.Equal("command", respErr.Details[0].Field)
+
+	_, req = setupTestReq(a.T(), "POST", ts.URL+ApiJobPath, []byte(`{"name": `))
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+	respErr = apiError{}
+	unmarshallRequestBody(a.T(), resp, &respErr)
+	a.Equal(CodeBadRequest, respErr.Code)
+	a.Empty(respErr.Details)
 }
 
 func (a *ApiTestSuite) TestDeleteJobSuccess() {
@@ -157,6 +201,30 @@ func (a *ApiTestSuite) TestDeleteJobSuccess() {
 	a.Nil(cache.Get(job.Id))
 }
 
+type conflictingDB struct {
+	job.MockDB
+}
+
+func (d *conflictingDB) Delete(ctx context.Context, id string) error {
+	return job.ErrConflict
+}
+
+func (a *ApiTestSuite) TestDeleteJobConflict() {
+	t := a.T()
+	cache, job := generateJobAndCache()
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}", HandleJobRequest(cache, &conflictingDB{})).Methods("DELETE", "GET")
+	ts := httptest.NewServer(r)
+
+	_, req := setupTestReq(t, "DELETE", ts.URL+ApiJobPath+job.Id, nil)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusConflict, resp.StatusCode)
+}
+
 func (a *ApiTestSuite) TestDeleteAllJobsSuccess() {
 	t := a.T()
 	db := &job.MockDB{}
@@ -225,6 +293,33 @@ func (a *ApiTestSuite) TestGetJobSuccess() {
 	a.Equal(resp.StatusCode, http.StatusOK)
 }
 
+func (a *ApiTestSuite) TestGetJobRedactsSecrets() {
+	t := a.T()
+	db := &job.MockDB{}
+	cache, j := generateJobAndCache()
+	j.JobType = job.RemoteJob
+	j.RemoteProperties = job.RemoteProperties{Url: "http://localhost/", Auth: &job.RemoteAuth{Type: job.BearerAuth, Token: "s3cret"}}
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}", HandleJobRequest(cache, db)).Methods("DELETE", "GET")
+	r.HandleFunc(ApiV2JobPath+"{id}", HandleJobV2Request(cache, db)).Methods("DELETE", "GET")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, url := range []string{ts.URL + ApiJobPath + j.Id, ts.URL + ApiV2JobPath + j.Id} {
+		_, req := setupTestReq(t, "GET", url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		body, err := ioutil.ReadAll(resp.Body)
+		a.NoError(err)
+		resp.Body.Close()
+		a.Equal(http.StatusOK, resp.StatusCode)
+		a.False(strings.Contains(string(body), "s3cret"))
+		a.True(strings.Contains(string(body), `"token":"\u003credacted\u003e"`))
+	}
+	a.Equal("s3cret", j.RemoteProperties.Auth.Token)
+}
+
 func (a *ApiTestSuite) TestHandleListJobStatsRequest() {
 	cache, job := generateJobAndCache()
 	job.Run(cache)
@@ -267,6 +362,242 @@ func (a *ApiTestSuite) TestHandleListJobStatsRequestNotFound() {
 	a.Equal(resp.StatusCode, http.StatusNotFound)
 }
 
+func (a *ApiTestSuite) TestHandleJobMetricsRequest() {
+	cache, job := generateJobAndCache()
+	job.Run(cache)
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}/stats", HandleJobMetricsRequest(cache)).Methods("GET")
+	ts := httptest.NewServer(r)
+
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+job.Id+"/stats", nil)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	a.NoError(err)
+
+	var metricsResp JobMetricsResponse
+	unmarshallRequestBody(a.T(), resp, &metricsResp)
+
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(job.Id, metricsResp.Metrics.Id)
+	a.Equal(job.Name, metricsResp.Metrics.Name)
+	a.Equal(uint64(1), metricsResp.Metrics.Runs)
+	a.Equal(uint64(0), metricsResp.Metrics.Failures)
+}
+
+func (a *ApiTestSuite) TestHandleJobMetricsRequestNotFound() {
+	t := a.T()
+	cache := job.NewMockCache()
+	handler := HandleJobMetricsRequest(cache)
+	w, req := setupTestReq(t, "GET", ApiJobPath+"asdasd/stats", nil)
+	handler(w, req)
+	a.Equal(w.Code, http.StatusNotFound)
+}
+
+func (a *ApiTestSuite) TestHandleJobStatsSummaryRequest() {
+	cache, job := generateJobAndCache()
+	job.Run(cache)
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}/stats/summary", HandleJobStatsSummaryRequest(cache)).Methods("GET")
+	ts := httptest.NewServer(r)
+
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+job.Id+"/stats/summary?window=1d", nil)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	a.NoError(err)
+
+	var summaryResp JobStatsSummaryResponse
+	unmarshallRequestBody(a.T(), resp, &summaryResp)
+
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(job.Id, summaryResp.Summary.JobId)
+	a.Equal(1, summaryResp.Summary.Runs)
+	a.Equal(1.0, summaryResp.Summary.SuccessRate)
+	a.Len(summaryResp.Summary.Trend, 24)
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+job.Id+"/stats/summary?window=1d&engine=v2", nil)
+	resp, err = client.Do(req)
+	a.NoError(err)
+	unmarshallRequestBody(a.T(), resp, &summaryResp)
+	a.Equal(0, summaryResp.Summary.Runs)
+
+	for _, query := range []string{"window=soon", "engine=v3"} {
+		_, req = setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+job.Id+"/stats/summary?"+query, nil)
+		resp, err = client.Do(req)
+		a.NoError(err)
+		a.Equal(http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func (a *ApiTestSuite) TestHandleJobFreshnessRequest() {
+	cache, j := generateJobAndCache()
+	j.FreshnessSLA = "PT1H"
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}/freshness", HandleJobFreshnessRequest(cache)).Methods("GET")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	client := &http.Client{}
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+j.Id+"/freshness", nil)
+	resp, err := client.Do(req)
+	a.NoError(err)
+	var freshnessResp JobFreshnessResponse
+	unmarshallRequestBody(a.T(), resp, &freshnessResp)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Nil(freshnessResp.Freshness.LastSuccess)
+	a.False(freshnessResp.Freshness.Fresh)
+
+	j.Run(cache)
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+j.Id+"/freshness", nil)
+	resp, err = client.Do(req)
+	a.NoError(err)
+	unmarshallRequestBody(a.T(), resp, &freshnessResp)
+	a.Equal(j.Id, freshnessResp.Freshness.JobId)
+	a.NotNil(freshnessResp.Freshness.LastSuccess)
+	a.Equal("PT1H", freshnessResp.Freshness.SLA)
+	a.True(freshnessResp.Freshness.Fresh)
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+"nope/freshness", nil)
+	resp, err = client.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (a *ApiTestSuite) TestHandleListExecutionsRequest() {
+	cache, j := generateJobAndCache()
+	j.Run(cache)
+	j.Command = "asdf"
+	j.Run(cache)
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}/executions", HandleListExecutionsRequest(cache)).Methods("GET")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	list := func(query string) ([]*job.JobStat, int) {
+		_, req := setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+j.Id+"/executions?"+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		if resp.StatusCode != http.StatusOK {
+			return nil, resp.StatusCode
+		}
+		var executionsResp ListExecutionsResponse
+		unmarshallRequestBody(a.T(), resp, &executionsResp)
+		return executionsResp.Executions, resp.StatusCode
+	}
+
+	executions, _ := list("")
+	a.Len(executions, 2)
+	a.False(executions[0].Success)
+
+	executions, _ = list("status=succeeded")
+	a.Len(executions, 1)
+	a.True(executions[0].Success)
+
+	executions, _ = list("limit=1&since=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	a.Len(executions, 1)
+
+	executions, _ = list("until=" + time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+	a.Len(executions, 0)
+
+	executions, _ = list("engine=v2")
+	a.Len(executions, 0)
+
+	for _, query := range []string{"status=running", "since=yesterday", "limit=0", "engine=v3"} {
+		_, status := list(query)
+		a.Equal(http.StatusBadRequest, status, query)
+	}
+}
+
+func (a *ApiTestSuite) TestHandleReplayExecutionRequest() {
+	cache, j := generateJobAndCache()
+	j.Run(cache)
+	original := j.Stats[0]
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}/executions/{runId}/replay", HandleReplayExecutionRequest(cache)).Methods("POST")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	replay := func(runId string) *http.Response {
+		_, req := setupTestReq(a.T(), "POST", ts.URL+ApiJobPath+j.Id+"/executions/"+runId+"/replay", nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		return resp
+	}
+
+	resp := replay(original.RunId)
+	a.Equal(http.StatusCreated, resp.StatusCode)
+	var replayResp ReplayExecutionResponse
+	unmarshallRequestBody(a.T(), resp, &replayResp)
+	a.Equal(original.RunId, replayResp.Execution.ReplayOf)
+	a.True(original.ScheduledAt.Equal(replayResp.Execution.ScheduledAt))
+	a.Len(j.Stats, 2)
+
+	resp = replay("missing")
+	resp.Body.Close()
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	j.Disable()
+	resp = replay(original.RunId)
+	resp.Body.Close()
+	a.Equal(http.StatusConflict, resp.StatusCode)
+}
+
+func (a *ApiTestSuite) TestHandleBackfillRequest() {
+	cache, j := generateJobAndCache()
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}/backfill", HandleBackfillRequest(cache)).Methods("POST")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	backfill := func(body string) *http.Response {
+		_, req := setupTestReq(a.T(), "POST", ts.URL+ApiJobPath+j.Id+"/backfill", []byte(body))
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		return resp
+	}
+
+	from, to := time.Now().Add(-72*time.Hour), time.Now()
+	resp := backfill(`{"start": "` + from.Format(time.RFC3339) + `", "end": "` + to.Format(time.RFC3339) + `"}`)
+	a.Equal(http.StatusAccepted, resp.StatusCode)
+	var backfillResp BackfillResponse
+	unmarshallRequestBody(a.T(), resp, &backfillResp)
+	if a.NotEmpty(backfillResp.Windows) {
+		a.False(backfillResp.Windows[0].Start.Before(from))
+	}
+
+	for _, body := range []string{`{"start": "` + to.Format(time.RFC3339) + `", "end": "` + from.Format(time.RFC3339) + `"}`, `{"start": "yesterday"}`} {
+		resp = backfill(body)
+		resp.Body.Close()
+		a.Equal(http.StatusBadRequest, resp.StatusCode, body)
+	}
+}
+
+func (a *ApiTestSuite) TestParseWindow() {
+	for window, expected := range map[string]time.Duration{
+		"7d":   7 * 24 * time.Hour,
+		"2w":   14 * 24 * time.Hour,
+		"12h":  12 * time.Hour,
+		"30m":  30 * time.Minute,
+		"366d": 366 * 24 * time.Hour,
+	} {
+		d, err := parseWindow(window)
+		a.NoError(err)
+		a.Equal(expected, d)
+	}
+
+	for _, window := range []string{"d", "-1d", "0h", "week", "367d", "53w", "213504d", "2562047h", "9223372036854775807w"} {
+		_, err := parseWindow(window)
+		a.Error(err)
+	}
+}
+
 func (a *ApiTestSuite) TestHandleListJobsRequest() {
 	cache, jobOne := generateJobAndCache()
 	jobTwo := job.GetMockJobWithGenericSchedule()
@@ -297,6 +628,380 @@ func (a *ApiTestSuite) TestHandleListJobsRequest() {
 	a.Equal(jobsResp.Jobs[jobTwo.Id].Command, jobTwo.Command)
 }
 
+func (a *ApiTestSuite) TestHandleListJobsRequestETag() {
+	cache, j := generateJobAndCache()
+	ts := httptest.NewServer(NewServer("", cache, &job.MockDB{}, "").http.Handler)
+	defer ts.Close()
+
+	list := func(etag string) *http.Response {
+		_, req := setupTestReq(a.T(), "GET", ts.URL+ApiJobPath, nil)
+		req.Header.Set("If-None-Match", etag)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		a.NoError(err)
+		resp.Body.Close()
+		return resp
+	}
+
+	generation := job.Generation()
+	resp := list("")
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal("gzip", resp.Header.Get("Content-Encoding"))
+	etag := resp.Header.Get("ETag")
+	a.NotEmpty(etag)
+
+	resp = list(etag)
+	// Jobs of other tests may run meanwhile.
+	if job.Generation() == generation {
+		a.Equal(http.StatusNotModified, resp.StatusCode)
+		a.Equal(etag, resp.Header.Get("ETag"))
+	}
+
+	j.Disable()
+	resp = list(etag)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.NotEqual(etag, resp.Header.Get("ETag"))
+}
+
+func (a *ApiTestSuite) TestHandleSearchJobsRequest() {
+	cache, jobOne := generateJobAndCache()
+	jobTwo := job.GetMockJobWithGenericSchedule()
+	jobTwo.Name = "nightly backup"
+	jobTwo.Init(cache)
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	search := func(query string) (*SearchJobsResponse, int) {
+		_, req := setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+"search/?q="+url.QueryEscape(query), nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		searchResp := &SearchJobsResponse{}
+		if resp.StatusCode == http.StatusOK {
+			unmarshallRequestBody(a.T(), resp, searchResp)
+		}
+		return searchResp, resp.StatusCode
+	}
+
+	resp, status := search(`name~"BACKUP" AND disabled=false`)
+	a.Equal(http.StatusOK, status)
+	a.Len(resp.Jobs, 1)
+	a.Equal(jobTwo.Id, resp.Jobs[0].Id)
+
+	resp, _ = search("")
+	a.Len(resp.Jobs, 2)
+
+	resp, _ = search(`name="` + jobOne.Name + `" AND owner!="nobody"`)
+	a.Len(resp.Jobs, 1)
+	a.Equal(jobOne.Id, resp.Jobs[0].Id)
+
+	_, status = search(`name~`)
+	a.Equal(http.StatusBadRequest, status)
+}
+
+func (a *ApiTestSuite) TestHandleGraphRequests() {
+	cache, parent := generateJobAndCache()
+	child := job.GetMockJob()
+	child.ParentJobs = []string{parent.Id}
+	a.NoError(child.Init(cache))
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+child.Id+"/graph/", nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	graphResp := &GraphResponse{}
+	unmarshallRequestBody(a.T(), resp, graphResp)
+	a.Len(graphResp.Graph.Nodes, 2)
+	a.Equal([]*job.GraphEdge{{From: parent.Id, To: child.Id, Kind: job.DependentEdge}}, graphResp.Graph.Edges)
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiUrlPrefix+"graph/?format=dot", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(dotContentType, resp.Header.Get(contentType))
+	body, err := ioutil.ReadAll(resp.Body)
+	a.NoError(err)
+	a.Contains(string(body), fmt.Sprintf("%q -> %q;", parent.Id, child.Id))
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiUrlPrefix+"graph/?format=svg", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+"not-a-real-id/graph/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (a *ApiTestSuite) TestNamespacedRoutes() {
+	job.SetNamespaces(&job.Namespaces{Namespaces: map[string]*job.NamespaceConfig{"data": {MaxJobs: 1}}})
+	defer job.SetNamespaces(&job.Namespaces{})
+	cache, other := generateJobAndCache()
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	do := func(method, path string, body []byte) *http.Response {
+		_, req := setupTestReq(a.T(), method, ts.URL+path, body)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		return resp
+	}
+	jsonJob, err := json.Marshal(generateNewJobMap())
+	a.NoError(err)
+
+	resp := do("POST", ApiUrlPrefix+"namespaces/data/job/", jsonJob)
+	a.Equal(http.StatusCreated, resp.StatusCode)
+	addResp := &AddJobResponse{}
+	unmarshallRequestBody(a.T(), resp, addResp)
+	j, err := cache.Get(addResp.Id)
+	a.NoError(err)
+	a.Equal("data", j.Namespace)
+
+	// Beyond the quota of the namespace.
+	resp = do("POST", ApiUrlPrefix+"namespaces/data/job/", jsonJob)
+	resp.Body.Close()
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+
+	resp = do("GET", ApiUrlPrefix+"namespaces/data/job/", nil)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	listResp := &ListJobsResponse{}
+	unmarshallRequestBody(a.T(), resp, listResp)
+	a.Len(listResp.Jobs, 1)
+	a.NotNil(listResp.Jobs[j.Id])
+
+	resp = do("GET", ApiUrlPrefix+"namespaces/data/job/"+j.Id+"/", nil)
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode)
+	resp = do("GET", ApiUrlPrefix+"namespaces/data/job/"+other.Id+"/", nil)
+	resp.Body.Close()
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+	resp = do("DELETE", ApiUrlPrefix+"namespaces/web/job/"+j.Id+"/", nil)
+	resp.Body.Close()
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	mismatched := generateNewJobMap()
+	mismatched["namespace"] = "data"
+	jsonJob, err = json.Marshal(mismatched)
+	a.NoError(err)
+	resp = do("POST", ApiUrlPrefix+"namespaces/web/job/", jsonJob)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func (a *ApiTestSuite) TestNamespaceTokens() {
+	namespaces, err := job.ParseNamespaces(strings.NewReader(`{"namespaces": {"data": {"tokens": ["s3cret"]}}}`))
+	a.NoError(err)
+	job.SetNamespaces(namespaces)
+	defer job.SetNamespaces(&job.Namespaces{})
+	cache := job.NewMockCache()
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	list := func(ns, token string) int {
+		_, req := setupTestReq(a.T(), "GET", ts.URL+ApiUrlPrefix+"namespaces/"+ns+"/job/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	a.Equal(http.StatusUnauthorized, list("data", ""))
+	a.Equal(http.StatusForbidden, list("data", "guess"))
+	a.Equal(http.StatusOK, list("data", "s3cret"))
+	a.Equal(http.StatusOK, list("web", ""))
+	a.Equal(http.StatusBadRequest, list("Web", ""))
+}
+
+func (a *ApiTestSuite) TestNamespaceTokensOnUnscopedRoutes() {
+	namespaces, err := job.ParseNamespaces(strings.NewReader(`{"namespaces": {"data": {"tokens": ["s3cret"]}}}`))
+	a.NoError(err)
+	job.SetNamespaces(namespaces)
+	defer job.SetNamespaces(&job.Namespaces{})
+	cache, other := generateJobAndCache()
+	j := job.GetMockJob()
+	j.Namespace = "data"
+	a.NoError(j.Init(cache))
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	do := func(method, path, token string, body []byte) *http.Response {
+		_, req := setupTestReq(a.T(), method, ts.URL+path, body)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		return resp
+	}
+	status := func(method, path, token string) int {
+		resp := do(method, path, token, nil)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	jobPath := ApiUrlPrefix + "job/" + j.Id + "/"
+	a.Equal(http.StatusUnauthorized, status("GET", jobPath, ""))
+	a.Equal(http.StatusForbidden, status("GET", jobPath, "guess"))
+	a.Equal(http.StatusOK, status("GET", jobPath, "s3cret"))
+	a.Equal(http.StatusUnauthorized, status("POST", ApiUrlPrefix+"job/start/"+j.Id+"/", ""))
+	a.Equal(http.StatusUnauthorized, status("GET", ApiUrlPrefix+"job/stats/"+j.Id+"/", ""))
+	a.Equal(http.StatusUnauthorized, status("GET", ApiV2JobPath+j.Id+"/", ""))
+	a.Equal(http.StatusUnauthorized, status("DELETE", jobPath, ""))
+	a.Equal(http.StatusUnauthorized, status("GET", ApiUrlPrefix+"job/?namespace=data", ""))
+	a.Equal(http.StatusOK, status("GET", ApiUrlPrefix+"job/"+other.Id+"/", ""))
+
+	// The jobs of the namespace aren't listed without a token.
+	resp := do("GET", ApiUrlPrefix+"job/", "", nil)
+	listResp := &ListJobsResponse{}
+	unmarshallRequestBody(a.T(), resp, listResp)
+	a.Nil(listResp.Jobs[j.Id])
+	a.NotNil(listResp.Jobs[other.Id])
+	resp = do("GET", ApiUrlPrefix+"job/", "s3cret", nil)
+	listResp = &ListJobsResponse{}
+	unmarshallRequestBody(a.T(), resp, listResp)
+	a.NotNil(listResp.Jobs[j.Id])
+
+	// Nor are jobs created in it.
+	newJob := generateNewJobMap()
+	newJob["namespace"] = "data"
+	jsonJob, err := json.Marshal(newJob)
+	a.NoError(err)
+	resp = do("POST", ApiUrlPrefix+"job/", "", jsonJob)
+	resp.Body.Close()
+	a.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	a.Equal(http.StatusUnauthorized, status("DELETE", ApiUrlPrefix+"job/all/", ""))
+	a.Equal(http.StatusNoContent, status("DELETE", jobPath, "s3cret"))
+}
+
+func (a *ApiTestSuite) TestAdminTokens() {
+	cache, _ := generateJobAndCache()
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	status := func(method, path, token string) int {
+		_, req := setupTestReq(a.T(), method, ts.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	routes := [][2]string{
+		{"POST", ApiUrlPrefix + "admin/backup/"},
+		{"POST", ApiUrlPrefix + "admin/consistency/fix/"},
+		{"GET", ApiUrlPrefix + "admin/schedule-load/"},
+		{"GET", ApiUrlPrefix + "graph/"},
+		{"GET", ApiUrlPrefix + "overview/"},
+		{"GET", ApiUrlPrefix + "stats/"},
+	}
+
+	// The routes across namespaces are open while namespaces have no tokens.
+	for _, route := range routes {
+		a.Equal(http.StatusOK, status(route[0], route[1], ""), route[1])
+	}
+
+	namespaces, err := job.ParseNamespaces(strings.NewReader(`{"namespaces": {"data": {"tokens": ["s3cret"]}}, "admin_tokens": ["admin"]}`))
+	a.NoError(err)
+	job.SetNamespaces(namespaces)
+	defer job.SetNamespaces(&job.Namespaces{})
+	for _, route := range routes {
+		a.Equal(http.StatusUnauthorized, status(route[0], route[1], ""), route[1])
+		a.Equal(http.StatusForbidden, status(route[0], route[1], "s3cret"), route[1])
+		a.Equal(http.StatusOK, status(route[0], route[1], "admin"), route[1])
+	}
+}
+
+func (a *ApiTestSuite) TestHandleConsistencyRequest() {
+	cache, j := generateJobAndCache()
+	j.ParentJobs = []string{"deleted-parent", "other-deleted-parent"}
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	check := func(method, path string) *job.ConsistencyReport {
+		_, req := setupTestReq(a.T(), method, ts.URL+ApiUrlPrefix+"admin/consistency/"+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		a.Equal(http.StatusOK, resp.StatusCode)
+		report := &job.ConsistencyReport{}
+		unmarshallRequestBody(a.T(), resp, report)
+		return report
+	}
+
+	report := check("GET", "")
+	a.False(report.Fixed)
+	a.Equal([]*job.ConsistencyProblem{{Kind: job.OrphanJob, JobId: j.Id}}, report.Problems)
+
+	// A GET doesn't fix anything, even when asked to.
+	report = check("GET", "?fix=true")
+	a.False(report.Fixed)
+	_, err := cache.Get(j.Id)
+	a.NoError(err)
+
+	report = check("POST", "fix/")
+	a.True(report.Fixed)
+	_, err = cache.Get(j.Id)
+	a.Error(err)
+
+	report = check("GET", "")
+	a.Empty(report.Problems)
+	a.Empty(report.Cycles)
+}
+
+func (a *ApiTestSuite) TestHandleListJobsRequestFiltered() {
+	cache, jobOne := generateJobAndCache()
+	jobTwo := job.GetMockJobWithGenericSchedule()
+	jobTwo.Owner = "other@example.com"
+	jobTwo.Tags = []string{"nightly"}
+	a.NoError(jobTwo.Init(cache))
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath, HandleListJobsRequest(cache)).Methods("GET")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for query, id := range map[string]string{
+		"?owner=" + jobOne.Owner:               jobOne.Id,
+		"?tag=nightly":                         jobTwo.Id,
+		"?name=mock_job&owner=" + jobTwo.Owner: jobTwo.Id,
+	} {
+		_, req := setupTestReq(a.T(), "GET", ts.URL+ApiJobPath+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		var jobsResp ListJobsResponse
+		unmarshallRequestBody(a.T(), resp, &jobsResp)
+		if a.Len(jobsResp.Jobs, 1, query) {
+			a.NotNil(jobsResp.Jobs[id], query)
+		}
+	}
+}
+
 func (a *ApiTestSuite) TestHandleStartJobRequest() {
 	t := a.T()
 	cache, job := generateJobAndCache()
@@ -318,6 +1023,26 @@ func (a *ApiTestSuite) TestHandleStartJobRequest() {
 	a.WithinDuration(job.Metadata.LastSuccess, now, 2*time.Second)
 	a.WithinDuration(job.Metadata.LastAttemptedRun, now, 2*time.Second)
 }
+func (a *ApiTestSuite) TestHandleStartJobRequestWithRequestID() {
+	t := a.T()
+	cache, job := generateJobAndCache()
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"start/{id}", HandleStartJobRequest(cache)).Methods("POST")
+	n := negroni.New(&middleware.RequestID{})
+	n.UseHandler(r)
+	ts := httptest.NewServer(n)
+
+	_, req := setupTestReq(t, "POST", ts.URL+ApiJobPath+"start/"+job.Id, nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-1")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	a.NoError(err)
+
+	a.Equal(resp.StatusCode, http.StatusNoContent)
+	a.Equal("req-1", resp.Header.Get(middleware.RequestIDHeader))
+	a.Equal("req-1", job.Stats[0].RequestId)
+}
 func (a *ApiTestSuite) TestHandleStartJobRequestNotFound() {
 	t := a.T()
 	cache := job.NewMockCache()
@@ -381,6 +1106,175 @@ func (a *ApiTestSuite) TestHandleDisableJobRequestNotFound() {
 	a.Equal(w.Code, http.StatusNotFound)
 }
 
+func (a *ApiTestSuite) TestHandleDisableJobWithReasonRequest() {
+	t := a.T()
+	cache, j := generateJobAndCache()
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"{id}/disable/", HandleDisableJobWithReasonRequest(cache)).Methods("POST")
+	r.HandleFunc(ApiJobPath+"{id}/enable/", HandleEnableJobWithResponseRequest(cache)).Methods("POST")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	body := []byte(`{"by": "admin@example.com", "reason": "Maintenance"}`)
+	_, req := setupTestReq(t, "POST", ts.URL+ApiJobPath+j.Id+"/disable/", body)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	jobResp := &JobResponse{}
+	unmarshallRequestBody(t, resp, jobResp)
+	a.True(jobResp.Job.Disabled)
+	a.Equal(job.DisabledByUser, jobResp.Job.DisabledInfo.Source)
+	a.Equal("admin@example.com", jobResp.Job.DisabledInfo.By)
+	a.Equal("Maintenance", jobResp.Job.DisabledInfo.Reason)
+	a.WithinDuration(time.Now(), jobResp.Job.DisabledInfo.At, time.Second)
+
+	_, req = setupTestReq(t, "POST", ts.URL+ApiJobPath+j.Id+"/enable/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	jobResp = &JobResponse{}
+	unmarshallRequestBody(t, resp, jobResp)
+	a.False(jobResp.Job.Disabled)
+	a.Nil(jobResp.Job.DisabledInfo)
+
+	_, req = setupTestReq(t, "POST", ts.URL+ApiJobPath+"not-a-real-id/disable/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (a *ApiTestSuite) TestHandleGroupRequests() {
+	t := a.T()
+	cache := job.NewMockCache()
+	db := &job.MockDB{}
+	groups := []string{"etl", "etl.nightly", "etl.nightly", "web"}
+	jobs := make([]*job.Job, len(groups))
+	for i, group := range groups {
+		jobs[i] = job.GetMockJobWithGenericSchedule()
+		jobs[i].Group = group
+		a.NoError(jobs[i].Init(cache))
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiGroupPath, HandleListGroupsRequest(cache)).Methods("GET")
+	r.HandleFunc(ApiGroupPath+"{id}/", HandleGroupRequest(cache, db)).Methods("GET", "DELETE")
+	r.HandleFunc(ApiGroupPath+"{id}/disable/", HandleDisableGroupRequest(cache)).Methods("POST")
+	r.HandleFunc(ApiGroupPath+"{id}/enable/", HandleEnableGroupRequest(cache)).Methods("POST")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	_, req := setupTestReq(t, "GET", ts.URL+ApiGroupPath, nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	listResp := &ListGroupsResponse{}
+	unmarshallRequestBody(t, resp, listResp)
+	a.Len(listResp.Groups, 3)
+	a.Equal("etl", listResp.Groups[0].Id)
+	a.Equal(1, listResp.Groups[0].Jobs)
+	a.Equal(3, listResp.Groups[0].TotalJobs)
+	a.Equal([]string{"etl.nightly"}, listResp.Groups[0].Subgroups)
+
+	body := []byte(`{"by": "admin@example.com", "reason": "Maintenance"}`)
+	_, req = setupTestReq(t, "POST", ts.URL+ApiGroupPath+"etl/disable/", body)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	opResp := &GroupOperationResponse{}
+	unmarshallRequestBody(t, resp, opResp)
+	a.Len(opResp.Jobs, 3)
+	for _, j := range jobs[:3] {
+		a.True(j.Copy().Disabled)
+		a.Equal("Maintenance", j.Copy().DisabledInfo.Reason)
+	}
+	a.False(jobs[3].Copy().Disabled)
+
+	_, req = setupTestReq(t, "POST", ts.URL+ApiGroupPath+"etl.nightly/enable/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.True(jobs[0].Copy().Disabled)
+	a.False(jobs[1].Copy().Disabled)
+
+	_, req = setupTestReq(t, "GET", ts.URL+ApiGroupPath+"etl.nightly/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	groupResp := &GroupResponse{}
+	unmarshallRequestBody(t, resp, groupResp)
+	a.Equal("etl.nightly", groupResp.Group.Id)
+	a.Equal("etl", groupResp.Group.Parent)
+	a.Equal(2, groupResp.Group.Jobs)
+	a.Len(groupResp.Jobs, 2)
+
+	_, req = setupTestReq(t, "DELETE", ts.URL+ApiGroupPath+"etl/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	for _, j := range jobs[:3] {
+		_, err := cache.Get(j.Id)
+		a.Error(err)
+	}
+	_, err = cache.Get(jobs[3].Id)
+	a.NoError(err)
+
+	_, req = setupTestReq(t, "GET", ts.URL+ApiGroupPath+"etl/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (a *ApiTestSuite) TestHandleCronJobRequests() {
+	t := a.T()
+	cache := job.NewMockCache()
+	r := mux.NewRouter()
+	r.HandleFunc(ApiJobPath+"import/cronjob/", HandleImportCronJobsRequest(cache, "ops@example.com")).Methods("POST")
+	r.HandleFunc(ApiJobPath+"export/cronjob/", HandleExportCronJobsRequest(cache)).Methods("GET")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	manifest := []byte(`apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: cleanup
+spec:
+  schedule: "*/30 * * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: cleanup
+            image: busybox
+            args: ["rm", "-rf", "/tmp/cache"]
+`)
+	_, req := setupTestReq(t, "POST", ts.URL+ApiJobPath+"import/cronjob/", manifest)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusCreated, resp.StatusCode)
+	importResp := &ImportCronJobsResponse{}
+	unmarshallRequestBody(t, resp, importResp)
+	if a.Len(importResp.Ids, 1) {
+		j, err := cache.Get(importResp.Ids[0])
+		a.NoError(err)
+		a.Equal("docker run --rm busybox rm -rf /tmp/cache", j.Command)
+		a.Equal("ops@example.com", j.Owner)
+	}
+
+	_, req = setupTestReq(t, "GET", ts.URL+ApiJobPath+"export/cronjob/?owner=ops@example.com", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(yamlContentType, resp.Header.Get(contentType))
+	body, err := ioutil.ReadAll(resp.Body)
+	a.NoError(err)
+	a.Contains(string(body), `schedule: '*/30 * * * *'`)
+	a.Contains(string(body), "kala/owner: ops@example.com")
+
+	_, req = setupTestReq(t, "POST", ts.URL+ApiJobPath+"import/cronjob/", []byte("kind: ConfigMap\n"))
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
 func (a *ApiTestSuite) TestHandleKalaStatsRequest() {
 	cache, _ := generateJobAndCache()
 	jobTwo := job.GetMockJobWithGenericSchedule()
@@ -413,6 +1307,85 @@ func (a *ApiTestSuite) TestHandleKalaStatsRequest() {
 	a.WithinDuration(statsResp.Stats.CreatedAt, now, 2*time.Second)
 }
 
+func (a *ApiTestSuite) TestHandleOverviewRequest() {
+	cache, jobOne := generateJobAndCache()
+	jobTwo := job.GetMockJobWithGenericSchedule()
+	jobTwo.Init(cache)
+	jobTwo.Disable()
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiUrlPrefix+"overview", HandleOverviewRequest(cache)).Methods("GET")
+	ts := httptest.NewServer(r)
+
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiUrlPrefix+"overview", nil)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	a.NoError(err)
+
+	var overviewResp OverviewResponse
+	unmarshallRequestBody(a.T(), resp, &overviewResp)
+
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(2, overviewResp.Overview.Jobs)
+	a.Equal(1, overviewResp.Overview.ActiveJobs)
+	a.Equal(1, overviewResp.Overview.DisabledJobs)
+	a.Equal(0, overviewResp.Overview.FailingJobs)
+	a.Len(overviewResp.Overview.UpcomingRuns, 1)
+	a.Equal(jobOne.Id, overviewResp.Overview.UpcomingRuns[0].JobId)
+	a.Empty(overviewResp.Overview.RecentFailures)
+}
+
+func (a *ApiTestSuite) TestHandleScheduleLoadRequest() {
+	cache, _ := generateJobAndCache()
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiUrlPrefix+"admin/schedule-load/", nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+
+	var loadResp ScheduleLoadResponse
+	unmarshallRequestBody(a.T(), resp, &loadResp)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Len(loadResp.ScheduleLoad.Minutes, 24*60)
+	a.Equal(1, loadResp.ScheduleLoad.Peak)
+	a.Empty(loadResp.ScheduleLoad.HotSpots)
+}
+
+func (a *ApiTestSuite) TestHandleRebalanceRequest() {
+	cache, j := generateJobAndCache()
+	other := job.GetMockJobWithSchedule(2, j.NextRunAt, "P1DT10M10S")
+	a.NoError(other.Init(cache))
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	rebalance := func(query string) (*http.Response, *job.RebalanceReport) {
+		_, req := setupTestReq(a.T(), "POST", ts.URL+ApiUrlPrefix+"admin/schedule-load/rebalance/"+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		report := &job.RebalanceReport{}
+		unmarshallRequestBody(a.T(), resp, report)
+		return resp, report
+	}
+
+	resp, report := rebalance("?window=4m")
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.False(report.Applied)
+	if a.Len(report.Moves, 1) {
+		a.Equal(2*time.Minute, report.Moves[0].Offset)
+	}
+
+	resp, _ = rebalance("?window=soon")
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
 func (a *ApiTestSuite) TestSetupApiRoutes() {
 	db := &job.MockDB{}
 	cache := job.NewMockCache()
@@ -439,3 +1412,493 @@ func unmarshallRequestBody(t assert.TestingT, resp *http.Response, obj interface
 	err = json.Unmarshal(body, obj)
 	assert.NoError(t, err)
 }
+
+// brokenJobDB fails to save the job with the id "broken".
+type brokenJobDB struct {
+	job.MockDB
+}
+
+func (d *brokenJobDB) Save(ctx context.Context, j *job.Job) error {
+	if j.Id == "broken" {
+		return errors.New("disk full")
+	}
+	return nil
+}
+
+func (d *brokenJobDB) SaveAll(ctx context.Context, jobs []*job.Job) error {
+	for _, j := range jobs {
+		if err := d.Save(ctx, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *ApiTestSuite) TestHandlePersistRequest() {
+	db := &brokenJobDB{}
+	cache := job.NewLockFreeJobCache(db)
+	j := job.GetMockJob()
+	j.Id = "ok"
+	a.NoError(cache.Set(j))
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, db, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	persist := func(query string) *http.Response {
+		_, req := setupTestReq(a.T(), "POST", ts.URL+ApiUrlPrefix+"admin/persist/"+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		return resp
+	}
+
+	resp := persist("")
+	a.Equal(http.StatusOK, resp.StatusCode)
+	report := &job.PersistReport{}
+	unmarshallRequestBody(a.T(), resp, report)
+	a.Equal(&job.PersistReport{Saved: 1}, report)
+
+	broken := job.GetMockJob()
+	broken.Id = "broken"
+	a.NoError(cache.Set(broken))
+	resp = persist("?all=true")
+	a.Equal(http.StatusInternalServerError, resp.StatusCode)
+	apiErr := &apiError{}
+	unmarshallRequestBody(a.T(), resp, apiErr)
+	a.Equal([]ErrorDetail{{Field: "jobs.broken", Message: "disk full"}}, apiErr.Details)
+
+	// The failures are counted per job.
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiUrlPrefix+"admin/persist/", nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	status := &PersistStatusResponse{}
+	unmarshallRequestBody(a.T(), resp, status)
+	a.True(status.JobFailures > 0)
+	a.Contains(status.Failures, JobPersistFailures{Id: "broken", Name: broken.Name, Owner: broken.Owner, Failures: 1})
+}
+
+func (a *ApiTestSuite) TestHandleBackupRequest() {
+	cache, j := generateJobAndCache()
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiUrlPrefix+"admin/backup", HandleBackupRequest(cache)).Methods("POST")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	_, req := setupTestReq(a.T(), "POST", ts.URL+ApiUrlPrefix+"admin/backup", nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Contains(resp.Header.Get("Content-Disposition"), "kala-backup-")
+	jobs, err := job.ReadBackup(resp.Body)
+	resp.Body.Close()
+	a.NoError(err)
+	if a.Len(jobs, 1) {
+		a.Equal(j.Id, jobs[0].Id)
+	}
+
+	// Without a backup store.
+	_, req = setupTestReq(a.T(), "POST", ts.URL+ApiUrlPrefix+"admin/backup?store=true", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	dir, err := ioutil.TempDir("", "kala-backup")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	SetBackupArchiver(&archive.Archiver{Store: &archive.LocalStore{Dir: dir}})
+	defer SetBackupArchiver(nil)
+
+	_, req = setupTestReq(a.T(), "POST", ts.URL+ApiUrlPrefix+"admin/backup?store=true", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusCreated, resp.StatusCode)
+	var backupResp BackupResponse
+	unmarshallRequestBody(a.T(), resp, &backupResp)
+	a.Equal(1, backupResp.Jobs)
+
+	f, err := os.Open(backupResp.Location)
+	if a.NoError(err) {
+		defer f.Close()
+		jobs, err = job.ReadBackup(f)
+		a.NoError(err)
+		a.Len(jobs, 1)
+	}
+}
+
+func (a *ApiTestSuite) TestHandleReloadRequest() {
+	r := mux.NewRouter()
+	r.HandleFunc(ApiUrlPrefix+"admin/reload", HandleReloadRequest()).Methods("POST")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	reload := func() int {
+		_, req := setupTestReq(a.T(), "POST", ts.URL+ApiUrlPrefix+"admin/reload", nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	a.Equal(http.StatusNotImplemented, reload())
+
+	reloads := 0
+	var reloadErr error
+	SetReloadFunc(func() error {
+		reloads++
+		return reloadErr
+	})
+	defer SetReloadFunc(nil)
+	a.Equal(http.StatusNoContent, reload())
+	a.Equal(1, reloads)
+
+	reloadErr = errors.New("Invalid config file kala.yaml")
+	a.Equal(http.StatusBadRequest, reload())
+	a.Equal(2, reloads)
+}
+
+type compactableDB struct {
+	job.MockDB
+	compactions uint64
+}
+
+func (d *compactableDB) DBStats() (metrics.DBStats, error) {
+	return metrics.DBStats{SizeBytes: 65536, Compactions: d.compactions}, nil
+}
+
+func (d *compactableDB) Compact(ctx context.Context) error {
+	d.compactions++
+	return nil
+}
+
+func (a *ApiTestSuite) TestHandleDBRequests() {
+	db := &compactableDB{}
+
+	r := mux.NewRouter()
+	r.HandleFunc(ApiUrlPrefix+"admin/db", HandleDBStatsRequest(db)).Methods("GET")
+	r.HandleFunc(ApiUrlPrefix+"admin/db/compact", HandleCompactDBRequest(db)).Methods("POST")
+	r.HandleFunc(ApiUrlPrefix+"mock/db", HandleDBStatsRequest(&job.MockDB{})).Methods("GET")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiUrlPrefix+"admin/db", nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	var stats metrics.DBStats
+	unmarshallRequestBody(a.T(), resp, &stats)
+	a.Equal(int64(65536), stats.SizeBytes)
+
+	_, req = setupTestReq(a.T(), "POST", ts.URL+ApiUrlPrefix+"admin/db/compact", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	unmarshallRequestBody(a.T(), resp, &stats)
+	a.Equal(uint64(1), stats.Compactions)
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiUrlPrefix+"mock/db", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNotImplemented, resp.StatusCode)
+}
+
+// downJobDB is a job database which can't be reached.
+type downJobDB struct {
+	job.MockDB
+}
+
+func (d *downJobDB) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func (a *ApiTestSuite) TestHandleReadyzRequest() {
+	cache := job.NewMockCache()
+
+	r := mux.NewRouter()
+	r.HandleFunc(ReadyzPath, HandleReadyzRequest(cache, &job.MockDB{})).Methods("GET")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// Not ready until the cache is started.
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ReadyzPath, nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	var status ReadyzResponse
+	unmarshallRequestBody(a.T(), resp, &status)
+	a.False(status.Ready)
+
+	cache.Start(time.Hour)
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ReadyzPath, nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	unmarshallRequestBody(a.T(), resp, &status)
+	a.True(status.Ready)
+	a.Nil(status.DB)
+
+	// Not ready while the db is unhealthy.
+	db := job.NewHealthCheckedDB(&downJobDB{}, nil)
+	db.Check(context.Background())
+	r = mux.NewRouter()
+	r.HandleFunc(ReadyzPath, HandleReadyzRequest(cache, db)).Methods("GET")
+	ts2 := httptest.NewServer(r)
+	defer ts2.Close()
+
+	_, req = setupTestReq(a.T(), "GET", ts2.URL+ReadyzPath, nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	status = ReadyzResponse{}
+	unmarshallRequestBody(a.T(), resp, &status)
+	a.True(status.Ready)
+	if a.NotNil(status.DB) {
+		a.False(status.DB.Healthy)
+		a.Equal("connection refused", status.DB.LastError)
+	}
+}
+
+func (a *ApiTestSuite) TestHandleOpenAPIRequest() {
+	cache := job.NewMockCache()
+	db := &job.MockDB{}
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, db, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	_, req := setupTestReq(a.T(), "GET", ts.URL+OpenAPIPath, nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	var doc struct {
+		OpenAPI    string                                       `json:"openapi"`
+		Paths      map[string]map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	unmarshallRequestBody(a.T(), resp, &doc)
+	a.Equal("3.0.3", doc.OpenAPI)
+
+	// Every route of the router is described.
+	for _, route := range apiRoutes(cache, db, "") {
+		_, ok := doc.Paths[route.path][strings.ToLower(route.method)]
+		a.True(ok, "%s %s", route.method, route.path)
+	}
+
+	getJob := doc.Paths[ApiJobPath+"{id}/"]["get"]
+	a.Equal("Get a job", getJob["summary"])
+	a.Len(getJob["parameters"], 1)
+	a.NotNil(getJob["responses"].(map[string]interface{})["default"])
+
+	properties := func(schema string) map[string]interface{} {
+		return doc.Components.Schemas[schema].Properties
+	}
+	a.NotNil(properties("Job")["disabled_info"])
+	a.Nil(properties("Job")["jobTimer"])
+	a.NotNil(properties("Error")["code"])
+	a.NotNil(properties("Error")["details"])
+	// Embedded structs are flattened.
+	a.NotNil(properties("RecentFailure")["run_id"])
+}
+
+func (a *ApiTestSuite) TestHandleJobV2Requests() {
+	cache := job.NewMockCache()
+	db := &job.MockDB{}
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, db, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	body := []byte(`{"spec": {"name": "ping", "owner": "aj@ajvb.me", "type": "remote", "schedule": "R2/2030-01-01T00:00:00Z/PT1H", "remote": {"url": "http://example.com", "method": "GET"}}}`)
+	_, req := setupTestReq(a.T(), "POST", ts.URL+ApiV2JobPath, body)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusCreated, resp.StatusCode)
+	created := &JobV2{}
+	unmarshallRequestBody(a.T(), resp, created)
+	a.NotEmpty(created.Id)
+	a.Equal("remote", created.Spec.Type)
+	a.Equal("http://example.com", created.Spec.Remote.Url)
+	a.Nil(created.Spec.AMQP)
+	a.Equal("2030-01-01T00:00:00Z", created.Status.NextRunAt)
+	a.Empty(created.Status.LastSuccess)
+
+	// The job is the same in v1.
+	j, err := cache.Get(created.Id)
+	a.NoError(err)
+	a.Equal(job.RemoteJob, j.JobType)
+	a.Equal("ping", j.Name)
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiV2JobPath+created.Id+"/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	got := &JobV2{}
+	unmarshallRequestBody(a.T(), resp, got)
+	a.Equal(created, got)
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiV2JobPath+"?owner=aj@ajvb.me", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	list := &ListJobsV2Response{}
+	unmarshallRequestBody(a.T(), resp, list)
+	a.Len(list.Jobs, 1)
+
+	_, req = setupTestReq(a.T(), "POST", ts.URL+ApiV2JobPath, []byte(`{"spec": {"name": "ping", "type": "ftp"}}`))
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+
+	_, req = setupTestReq(a.T(), "DELETE", ts.URL+ApiV2JobPath+created.Id+"/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+
+	_, req = setupTestReq(a.T(), "GET", ts.URL+ApiV2JobPath+created.Id+"/", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (a *ApiTestSuite) TestHandleUpdateJobRequest() {
+	cache := job.NewMockCache()
+	db := &job.MockDB{}
+	j := job.GetMockJobWithGenericSchedule()
+	a.NoError(j.Init(cache))
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, db, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	put := func(spec *job.Job) *http.Response {
+		body, err := json.Marshal(spec)
+		a.NoError(err)
+		_, req := setupTestReq(a.T(), "PUT", ts.URL+ApiJobPath+j.Id+"/", body)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		return resp
+	}
+
+	// Two operators read the job, and the first one changes its schedule.
+	first, second := j.Copy(), j.Copy()
+	first.Schedule = "R/2030-01-01T00:00:00Z/PT2H"
+	resp := put(first)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	got := &JobResponse{}
+	unmarshallRequestBody(a.T(), resp, got)
+	a.Equal(first.Schedule, got.Job.Schedule)
+	a.Equal(uint64(2), got.Job.ResourceVersion)
+
+	// The second one's change would clobber it.
+	second.Schedule = "R/2030-01-01T00:00:00Z/PT3H"
+	a.Equal(http.StatusConflict, put(second).StatusCode)
+	a.Equal(first.Schedule, j.Copy().Schedule)
+
+	second.ResourceVersion = 0
+	a.Equal(http.StatusBadRequest, put(second).StatusCode)
+}
+
+func (a *ApiTestSuite) TestHandleUpdateJobV2Request() {
+	cache := job.NewMockCache()
+	db := &job.MockDB{}
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, db, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	put := func(id string, version uint64, spec string) (*http.Response, *JobV2) {
+		body := []byte(fmt.Sprintf(`{"resource_version": %d, "spec": %s}`, version, spec))
+		_, req := setupTestReq(a.T(), "PUT", ts.URL+ApiV2JobPath+id+"/", body)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		got := &JobV2{}
+		if resp.StatusCode < 300 {
+			unmarshallRequestBody(a.T(), resp, got)
+		}
+		return resp, got
+	}
+	spec := `{"name": "report", "command": "true", "schedule": "R/2030-01-01T00:00:00Z/PT1H", "tags": ["a"]}`
+
+	// Version 0 creates the job with the id, only once.
+	resp, created := put("nightly-report", 0, spec)
+	a.Equal(http.StatusCreated, resp.StatusCode)
+	a.Equal("nightly-report", created.Id)
+	a.Equal(uint64(1), created.ResourceVersion)
+	resp, _ = put("nightly-report", 0, spec)
+	a.Equal(http.StatusConflict, resp.StatusCode)
+	resp, _ = put("nightly report", 0, spec)
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	// The job is read back as it was written.
+	_, req := setupTestReq(a.T(), "GET", ts.URL+ApiV2JobPath+"nightly-report/", nil)
+	resp, err := http.DefaultClient.Do(req)
+	a.NoError(err)
+	got := &JobV2{}
+	unmarshallRequestBody(a.T(), resp, got)
+	a.Equal(created, got)
+
+	updated := `{"name": "report", "command": "true", "schedule": "R/2030-01-01T00:00:00Z/PT2H", "tags": ["b"]}`
+	resp, got = put("nightly-report", 1, updated)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(uint64(2), got.ResourceVersion)
+	a.Equal("R/2030-01-01T00:00:00Z/PT2H", got.Spec.Schedule)
+	a.Equal([]string{"b"}, got.Spec.Tags)
+	a.Len(cache.Find(job.JobFilter{Tag: "a"}), 0)
+	a.Len(cache.Find(job.JobFilter{Tag: "b"}), 1)
+
+	// A write from the first version is stale.
+	resp, _ = put("nightly-report", 1, spec)
+	a.Equal(http.StatusConflict, resp.StatusCode)
+	resp, _ = put("missing", 1, spec)
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	_, req = setupTestReq(a.T(), "DELETE", ts.URL+ApiV2JobPath+"nightly-report/?resource_version=1", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusConflict, resp.StatusCode)
+	_, req = setupTestReq(a.T(), "DELETE", ts.URL+ApiV2JobPath+"nightly-report/?resource_version=2", nil)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	a.Equal(http.StatusNoContent, resp.StatusCode)
+}
+
+func (a *ApiTestSuite) TestRunQuota() {
+	job.SetNamespaces(&job.Namespaces{Owners: map[string]*job.Quota{"example@example.com": {MaxJobs: 1, MaxRunsPerMinute: 1}}})
+	defer job.SetNamespaces(&job.Namespaces{})
+	cache, j := generateJobAndCache()
+
+	r := mux.NewRouter()
+	SetupApiRoutes(r, cache, &job.MockDB{}, "")
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	start := func() *http.Response {
+		_, req := setupTestReq(a.T(), "POST", ts.URL+ApiJobPath+"start/"+j.Id+"/", nil)
+		resp, err := http.DefaultClient.Do(req)
+		a.NoError(err)
+		resp.Body.Close()
+		return resp
+	}
+	a.Equal(http.StatusNoContent, start().StatusCode)
+	resp := start()
+	a.Equal(http.StatusTooManyRequests, resp.StatusCode)
+	a.NotEmpty(resp.Header.Get("Retry-After"))
+
+	jsonJob, err := json.Marshal(job.GetMockJobWithGenericSchedule())
+	a.NoError(err)
+	_, req := setupTestReq(a.T(), "POST", ts.URL+ApiJobPath, jsonJob)
+	resp, err = http.DefaultClient.Do(req)
+	a.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ajvb/kala/cronjob"
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/validation"
+)
+
+const yamlContentType = "application/yaml;charset=UTF-8"
+
+type ImportCronJobsResponse struct {
+	Ids []string `json:"ids"`
+}
+
+// HandleImportCronJobsRequest is the handler for creating jobs from the
+// CronJobs of a Kubernetes manifest, see cronjob.Import. No job is created
+// unless they're all valid.
+// POST /api/v1/job/import/cronjob/
+func HandleImportCronJobsRequest(cache job.JobCache, defaultOwner string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := cronjob.Import(io.LimitReader(r.Body, 1048576), time.Now())
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+
+		for _, j := range jobs {
+			if defaultOwner != "" && j.Owner == "" {
+				j.Owner = defaultOwner
+			}
+			if !authorizeNamespace(w, r, j.Namespace) {
+				return
+			}
+			if err := validation.Job(j, cache); err != nil {
+				jobErrorEncodeJSON(err, w)
+				return
+			}
+			if err := job.GetNamespaces().CheckQuota(cache, j); err != nil {
+				errorEncodeJSON(err, http.StatusForbidden, w)
+				return
+			}
+		}
+
+		resp := &ImportCronJobsResponse{Ids: []string{}}
+		for _, j := range jobs {
+			if err := j.InitWithContext(runContext(r), cache); err != nil {
+				log.Errorf("Error occured when initializing the job: %s", err)
+				jobErrorEncodeJSON(err, w)
+				return
+			}
+			resp.Ids = append(resp.Ids, j.Id)
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+// HandleExportCronJobsRequest is the handler for exporting the jobs, or those
+// with the owner, name, tag, namespace and group, as a Kubernetes manifest of
+// CronJobs, see cronjob.Export.
+// GET /api/v1/job/export/cronjob/?image=alpine
+func HandleExportCronJobsRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if ns := query.Get("namespace"); ns != "" && !authorizeNamespace(w, r, ns) {
+			return
+		}
+		jobs := authorizedJobs(r, cache.Find(job.JobFilter{
+			Owner:     query.Get("owner"),
+			Name:      query.Get("name"),
+			Tag:       query.Get("tag"),
+			Namespace: query.Get("namespace"),
+			Group:     query.Get("group"),
+		}))
+		out, err := cronjob.Export(jobs, query.Get("image"))
+		if err != nil {
+			errorEncodeJSON(err, http.StatusInternalServerError, w)
+			return
+		}
+
+		w.Header().Set(contentType, yamlContentType)
+		w.Write(out)
+	}
+}
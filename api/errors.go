@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/validation"
+)
+
+// Codes of errors, telling apart errors with the same status, e.g. in clients.
+const (
+	CodeBadRequest     = "bad_request"
+	CodeUnauthorized   = "unauthorized"
+	CodeForbidden      = "forbidden"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeInvalid        = "validation_failed"
+	CodeInternal       = "internal"
+	CodeNotImplemented = "not_implemented"
+	CodeBadGateway     = "bad_gateway"
+	CodeUnavailable    = "unavailable"
+	CodeRateLimited    = "rate_limited"
+)
+
+var statusCodes = map[int]string{
+	http.StatusBadRequest:          CodeBadRequest,
+	http.StatusUnauthorized:        CodeUnauthorized,
+	http.StatusForbidden:           CodeForbidden,
+	http.StatusNotFound:            CodeNotFound,
+	http.StatusConflict:            CodeConflict,
+	http.StatusUnprocessableEntity: CodeInvalid,
+	http.StatusInternalServerError: CodeInternal,
+	http.StatusNotImplemented:      CodeNotImplemented,
+	http.StatusBadGateway:          CodeBadGateway,
+	http.StatusServiceUnavailable:  CodeUnavailable,
+	http.StatusTooManyRequests:     CodeRateLimited,
+}
+
+// apiError is the body of every error response, e.g.
+// {"code": "validation_failed", "message": "...", "details": [{"field": "schedule", "message": "..."}]}.
+type apiError struct {
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Details []ErrorDetail `json:"details,omitempty"`
+}
+
+// ErrorDetail tells which field of the request is invalid, and why.
+type ErrorDetail struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErrRouteNotFound is returned for requests to routes which don't exist.
+var ErrRouteNotFound = errors.New("The route you requested does not exist")
+
+// invalidJobFields are the fields of the errors of invalid jobs, which are
+// responded with a 422.
+var invalidJobFields = map[error]string{
+	job.ErrInvalidJob:                "command",
+	job.ErrInvalidRemoteJob:          "remote_properties",
+	job.ErrInvalidAuth:               "remote_properties.auth",
+	job.ErrSecretNotAllowed:          "remote_properties.auth",
+	job.ErrNoArchive:                 "remote_properties.archive_response",
+	job.ErrInvalidAMQPJob:            "amqp_properties",
+	job.ErrInvalidLambdaJob:          "lambda_properties",
+	job.ErrInvalidPubSubJob:          "pubsub_properties",
+	job.ErrCredentialsFileNotAllowed: "pubsub_properties.credentials_file",
+	job.ErrInvalidSQLJob:             "sql_properties",
+	job.ErrUnknownSQLConnection:      "sql_properties.connection",
+	job.ErrInvalidGRPCJob:            "grpc_properties",
+	job.ErrDescriptorSetNotAllowed:   "grpc_properties.descriptor_set",
+	job.ErrInvalidHandlerJob:         "handler_properties",
+	job.ErrUnknownHandler:            "handler_properties.handler",
+	job.ErrInvalidPluginJob:          "plugin_properties",
+	job.ErrUnknownPlugin:             "plugin_properties.plugin",
+	job.ErrInvalidJobType:            "type",
+	job.ErrInvalidTypeName:           "type",
+	job.ErrInvalidSeverity:           "notifications.severity",
+	job.ErrInvalidMailOn:             "notifications.mail_on",
+	job.ErrNoMessageSubscriber:       "trigger_subject",
+	job.ErrInvalidMaxStats:           "max_stats",
+	job.ErrInvalidExitCodes:          "exit_codes",
+	job.ErrInvalidParseResult:        "parse_result",
+	job.ErrInvalidArtifacts:          "artifacts",
+	job.ErrInvalidLogSinks:           "log_sinks",
+	job.ErrInvalidSlowFactor:         "slow_factor",
+	job.ErrInvalidFreshnessSLA:       "freshness_sla",
+	job.ErrInvalidSchedule:           "schedule",
+	job.ErrJobDoesntExist:            "parent_jobs",
+
+	job.ErrInvalidMisfirePolicy:    "misfire_policy",
+	job.ErrInvalidMisfireTolerance: "misfire_tolerance",
+	job.ErrInvalidEngine:           "engine",
+
+	job.ErrInvalidGroup: "group",
+}
+
+// dbErrorStatus returns the status code of a response for an error of the JobDB,
+// or of deleting a job at a stale resource version.
+func dbErrorStatus(err error) int {
+	switch err {
+	case job.ErrNotFound:
+		return http.StatusNotFound
+	case job.ErrConflict, job.ErrStaleResourceVersion:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// jobErrorEncodeJSON responds with an error validating or initializing a job:
+// a 422 telling the invalid fields for invalid jobs, and a 400 otherwise.
+func jobErrorEncodeJSON(errToEncode error, w http.ResponseWriter) {
+	if violations, ok := errToEncode.(validation.Errors); ok {
+		details := make([]ErrorDetail, len(violations))
+		for i, v := range violations {
+			details[i] = ErrorDetail{Field: v.Field, Message: v.Message}
+		}
+		encodeAPIError(w, http.StatusUnprocessableEntity, &apiError{
+			Code:    CodeInvalid,
+			Message: "The job is invalid",
+			Details: details,
+		})
+		return
+	}
+
+	field, ok := invalidJobFields[errToEncode]
+	if !ok {
+		errorEncodeJSON(errToEncode, http.StatusBadRequest, w)
+		return
+	}
+	encodeAPIError(w, http.StatusUnprocessableEntity, &apiError{
+		Code:    CodeInvalid,
+		Message: "The job is invalid",
+		Details: []ErrorDetail{{Field: field, Message: errToEncode.Error()}},
+	})
+}
+
+func errorEncodeJSON(errToEncode error, status int, w http.ResponseWriter) {
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	encodeAPIError(w, status, &apiError{Code: code, Message: errToEncode.Error()})
+}
+
+func encodeAPIError(w http.ResponseWriter, status int, apiErr *apiError) {
+	js, err := json.Marshal(apiErr)
+	if err != nil {
+		log.Errorf("could not encode error message: %v", err)
+		return
+	}
+	w.Header().Set(contentType, jsonContentType)
+	http.Error(w, string(js), status)
+}
+
+// handleNotFound responds to requests to routes which don't exist.
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	errorEncodeJSON(ErrRouteNotFound, http.StatusNotFound, w)
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/job"
+)
+
+// etagEpoch tells apart the ETags of Kala processes, as the generation of
+// jobs starts over when Kala restarts.
+var etagEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// jobsETag returns the ETag of responses listing jobs, which changes with
+// every change to the jobs, see job.Generation. It must be read before the
+// jobs, so that changes made while reading them change it next time.
+func jobsETag() string {
+	return fmt.Sprintf(`W/"%s-%d"`, etagEpoch, job.Generation())
+}
+
+// notModified sets the response's ETag, and responds with 304 Not Modified if
+// the request's If-None-Match matches it.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	// Clients should check that their copy is still fresh.
+	w.Header().Set("Cache-Control", "no-cache")
+
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		if match == "*" || strings.TrimPrefix(match, "W/") == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
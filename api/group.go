@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ajvb/kala/job"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	GroupPath    = "group/"
+	ApiGroupPath = ApiUrlPrefix + GroupPath
+)
+
+type ListGroupsResponse struct {
+	Groups []*job.Group `json:"groups"`
+}
+
+type GroupResponse struct {
+	Group *job.Group `json:"group"`
+	// Jobs of the group and its subgroups, ordered by id.
+	Jobs []*job.Job `json:"jobs"`
+}
+
+// GroupOperationResponse lists the ids of the jobs of a group an operation
+// was applied to, and those skipped.
+type GroupOperationResponse struct {
+	Jobs []string `json:"jobs"`
+	// Jobs not run, as their namespace or owner ran as many times as their
+	// quota allows.
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// getGroupJobs returns the jobs of the group of the route of the request, and
+// of its subgroups, in the namespace of the route, but those of the
+// namespaces the client has no token of.
+func getGroupJobs(cache job.JobCache, r *http.Request) ([]*job.Job, error) {
+	id := mux.Vars(r)["id"]
+	if id == "" || !job.ValidGroup(id) {
+		return nil, job.ErrGroupDoesntExist
+	}
+	jobs := authorizedJobs(r, job.GroupJobs(cache, id, namespaceOf(r)))
+	if len(jobs) == 0 {
+		return nil, job.ErrGroupDoesntExist
+	}
+	return jobs, nil
+}
+
+func jobIds(jobs []*job.Job) []string {
+	ids := make([]string, len(jobs))
+	for i, j := range jobs {
+		ids[i] = j.Id
+	}
+	return ids
+}
+
+func encodeGroupResponse(w http.ResponseWriter, status int, resp interface{}) {
+	w.Header().Set(contentType, jsonContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error occured when marshalling response: %s", err)
+	}
+}
+
+// HandleListGroupsRequest is the handler for listing the groups of the jobs,
+// with the counts of their jobs, ordered by id.
+// GET /api/v1/group/
+func HandleListGroupsRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs := authorizedJobs(r, cache.Find(job.JobFilter{Namespace: namespaceOf(r)}))
+		encodeGroupResponse(w, http.StatusOK, &ListGroupsResponse{Groups: job.Groups(jobs)})
+	}
+}
+
+// HandleGroupRequest routes requests to /api/v1/group/{id}/ to getting the
+// group and its jobs if it's a GET, or deleting its jobs if it's a DELETE.
+// The jobs of its subgroups are included.
+func HandleGroupRequest(cache job.JobCache, db job.JobDB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := getGroupJobs(cache, r)
+		if err != nil {
+			errorEncodeJSON(err, http.StatusNotFound, w)
+			return
+		}
+
+		if r.Method == "DELETE" {
+			for _, j := range jobs {
+				if err := j.DeleteWithContext(r.Context(), cache, db); err != nil {
+					errorEncodeJSON(err, dbErrorStatus(err), w)
+					return
+				}
+			}
+			encodeGroupResponse(w, http.StatusOK, &GroupOperationResponse{Jobs: jobIds(jobs)})
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		resp := &GroupResponse{Jobs: make([]*job.Job, len(jobs))}
+		for _, group := range job.Groups(jobs) {
+			if group.Id == id {
+				resp.Group = group
+			}
+		}
+		for i, j := range jobs {
+			resp.Jobs[i] = j.Copy()
+			resp.Jobs[i].RedactSecrets()
+		}
+		encodeGroupResponse(w, http.StatusOK, resp)
+	}
+}
+
+// HandleEnableGroupRequest is the handler for enabling the jobs of a group
+// and of its subgroups.
+// POST /api/v1/group/{id}/enable/
+func HandleEnableGroupRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := getGroupJobs(cache, r)
+		if err != nil {
+			errorEncodeJSON(err, http.StatusNotFound, w)
+			return
+		}
+
+		for _, j := range jobs {
+			j.Enable(cache)
+		}
+
+		encodeGroupResponse(w, http.StatusOK, &GroupOperationResponse{Jobs: jobIds(jobs)})
+	}
+}
+
+// HandleDisableGroupRequest is the handler for disabling the jobs of a group
+// and of its subgroups, recording who disabled them and why, as with
+// DisableJobRequest.
+// POST /api/v1/group/{id}/disable/
+func HandleDisableGroupRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := getGroupJobs(cache, r)
+		if err != nil {
+			errorEncodeJSON(err, http.StatusNotFound, w)
+			return
+		}
+
+		req, err := readDisableJobRequest(r)
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+
+		now := time.Now()
+		for _, j := range jobs {
+			j.DisableWithInfo(job.DisabledInfo{
+				Source: job.DisabledByUser,
+				By:     req.By,
+				At:     now,
+				Reason: req.Reason,
+			})
+			j.NotifyDisabled()
+		}
+
+		encodeGroupResponse(w, http.StatusOK, &GroupOperationResponse{Jobs: jobIds(jobs)})
+	}
+}
+
+// HandleStartGroupRequest is the handler for running the jobs of a group and
+// of its subgroups now, in the background. Jobs beyond the run quota of their
+// namespace or owner are skipped.
+// POST /api/v1/group/{id}/start/
+func HandleStartGroupRequest(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := getGroupJobs(cache, r)
+		if err != nil {
+			errorEncodeJSON(err, http.StatusNotFound, w)
+			return
+		}
+
+		resp := &GroupOperationResponse{Jobs: []string{}}
+		ctx := runContext(r)
+		now := time.Now()
+		for _, j := range jobs {
+			if job.GetNamespaces().RunQuotaWait(j, now) > 0 {
+				resp.Skipped = append(resp.Skipped, j.Id)
+				continue
+			}
+			resp.Jobs = append(resp.Jobs, j.Id)
+			j.StopTimer()
+			go j.RunWithContext(ctx, cache)
+		}
+
+		encodeGroupResponse(w, http.StatusAccepted, resp)
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "DELETE"}
+	defaultCORSHeaders = []string{"Content-Type", "Authorization", RequestIDHeader}
+)
+
+// CORS is a middleware handler that lets browsers on the allowed origins call
+// the API, e.g. a dashboard served from another host. It answers preflight
+// requests itself. Without allowed origins it does nothing.
+type CORS struct {
+	// Origins allowed to call the API, e.g. "https://dashboard.example.com",
+	// or "*" for any origin.
+	AllowedOrigins []string
+	// Methods allowed in requests. Defaults to GET, HEAD, POST and DELETE.
+	AllowedMethods []string
+	// Headers allowed in requests. Defaults to Content-Type, Authorization and X-Request-ID.
+	AllowedHeaders []string
+	// How long browsers may cache the answer to a preflight request, in seconds.
+	MaxAge int
+}
+
+func (m *CORS) allowedOrigin(origin string) string {
+	for _, o := range m.AllowedOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func (m *CORS) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	origin := r.Header.Get("Origin")
+	if origin == "" || len(m.AllowedOrigins) == 0 {
+		next(rw, r)
+		return
+	}
+
+	h := rw.Header()
+	h.Add("Vary", "Origin")
+	allowed := m.allowedOrigin(origin)
+	if allowed == "" {
+		next(rw, r)
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", allowed)
+
+	if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+		h.Set("Access-Control-Expose-Headers", RequestIDHeader+", Retry-After")
+		next(rw, r)
+		return
+	}
+
+	// Preflight request
+	methods, headers := m.AllowedMethods, m.AllowedHeaders
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	if m.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(m.MaxAge))
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// SecurityHeaders is a middleware handler that sets the standard security
+// headers on every response. The API only serves JSON, so browsers are told
+// not to sniff, frame or run anything from it.
+type SecurityHeaders struct{}
+
+func (m *SecurityHeaders) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	h := rw.Header()
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+	next(rw, r)
+}
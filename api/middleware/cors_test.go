@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	cors := &CORS{AllowedOrigins: []string{"https://dashboard.example.com"}, MaxAge: 600}
+	n := negroni.New(cors)
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	serve := func(method, origin string, preflight bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest(method, "/api/v1/job/", nil)
+		assert.NoError(t, err)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if preflight {
+			r.Header.Set("Access-Control-Request-Method", "DELETE")
+		}
+		n.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("GET", "https://dashboard.example.com", false)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Equal(t, "https://dashboard.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-ID, Retry-After", w.Header().Get("Access-Control-Expose-Headers"))
+
+	w = serve("OPTIONS", "https://dashboard.example.com", true)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, HEAD, POST, DELETE", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type, Authorization, X-Request-ID", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	// Other origins aren't allowed, and requests without an origin aren't changed.
+	w = serve("GET", "https://evil.example.com", false)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	w = serve("OPTIONS", "https://evil.example.com", true)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	w = serve("GET", "", false)
+	assert.Empty(t, w.Header().Get("Vary"))
+
+	cors.AllowedOrigins = []string{"*"}
+	w = serve("GET", "https://evil.example.com", false)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	n := negroni.New(&SecurityHeaders{})
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/api/v1/job/", nil)
+	assert.NoError(t, err)
+	n.ServeHTTP(w, r)
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+}
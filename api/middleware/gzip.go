@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// Gzip is a middleware handler that compresses responses with gzip for
+// clients which accept it, e.g. large lists of jobs.
+type Gzip struct{}
+
+func (m *Gzip) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	rw.Header().Add("Vary", "Accept-Encoding")
+	if r.Method == "HEAD" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+		next(rw, r)
+		return
+	}
+
+	grw := &gzipResponseWriter{ResponseWriter: rw}
+	defer grw.close()
+	next(grw, r)
+}
+
+// acceptsGzip says if the Accept-Encoding header accepts gzip, which it
+// doesn't with a quality of 0, e.g. "gzip;q=0".
+func acceptsGzip(acceptEncoding string) bool {
+	for _, coding := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(coding, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[len("q="):], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the body of responses which have one, once
+// their status is written.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			// Detect the type of the body, rather than of the compressed body.
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGzip(t *testing.T) {
+	body := strings.Repeat(`{"name": "mock_job"}`, 100)
+	n := negroni.New(&Gzip{})
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", path, nil)
+		assert.NoError(t, err)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		n.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("/api/v1/job/", "deflate, gzip")
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.True(t, w.Body.Len() < len(body))
+	gz, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	uncompressed, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(uncompressed))
+
+	w = serve("/api/v1/job/", "")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, w.Body.String())
+
+	w = serve("/api/v1/job/", "gzip;q=0")
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+	w = serve("/empty", "gzip")
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, 0, w.Body.Len())
+}
@@ -9,17 +9,27 @@ import (
 )
 
 // Logger is a middleware handler that logs the request as it goes in and the response as it goes out.
+// Should be used after the RequestID middleware, so that every line includes the request id.
 type Logger struct {
-	// Logger inherits from log.Logger used to log messages with the Logger middleware
-	log.Logger
+	// Entry is used to log messages with the Logger middleware
+	*log.Entry
 }
 
 func (l *Logger) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 	start := time.Now()
-	l.Infof("Started %s %s", r.Method, r.URL.Path)
+	entry := l.WithFields(log.Fields{
+		"request_id": RequestIDFromContext(r.Context()),
+		"method":     r.Method,
+		"path":       r.URL.Path,
+	})
+	entry.Debugf("Started %s %s", r.Method, r.URL.Path)
 
 	next(rw, r)
 
 	res := rw.(negroni.ResponseWriter)
-	l.Infof("Completed %v %s in %v", res.Status(), http.StatusText(res.Status()), time.Since(start))
+	duration := time.Since(start)
+	entry.WithFields(log.Fields{
+		"status":   res.Status(),
+		"duration": duration.Seconds(),
+	}).Infof("Completed %s %s with %v %s in %v", r.Method, r.URL.Path, res.Status(), http.StatusText(res.Status()), duration)
 }
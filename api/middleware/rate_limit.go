@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often buckets of clients which stopped making requests are dropped.
+const rateLimitPruneInterval = time.Minute
+
+// RateLimit is a middleware handler that limits the requests of every client,
+// keyed by its API token (a valid bearer token of the Authorization header)
+// or else its IP, with a token bucket. Requests beyond the limit are rejected with 429 Too Many
+// Requests and a Retry-After header. Without a rate it does nothing.
+type RateLimit struct {
+	// Requests per second allowed for each client.
+	Rate float64
+	// Requests a client may make at once, on top of the rate. Defaults to 1.
+	Burst int
+	// Key clients by the first address of the X-Forwarded-For header, for
+	// Kala behind a proxy. It shouldn't be set otherwise, as clients could
+	// send any address.
+	TrustForwardedFor bool
+	// Says if a bearer token is valid. Clients are only keyed by valid
+	// tokens, as they could otherwise send a new header with every request to
+	// get a new bucket. Without it clients are keyed by their IP.
+	ValidToken func(token string) bool
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Set changes the limit of the requests while they're being served, e.g. when
+// the configuration is reloaded. Clients keep the tokens left in their
+// buckets.
+func (m *RateLimit) Set(rate float64, burst int, trustForwardedFor bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Rate, m.Burst, m.TrustForwardedFor = rate, burst, trustForwardedFor
+}
+
+// limits returns the fields which Set changes.
+func (m *RateLimit) limits() (float64, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.Rate, m.TrustForwardedFor
+}
+
+func (m *RateLimit) burst() float64 {
+	if m.Burst < 1 {
+		return 1
+	}
+	return float64(m.Burst)
+}
+
+// take takes a token from the client's bucket, or returns how long until the
+// bucket has one.
+func (m *RateLimit) take(key string) (bool, time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	if m.now != nil {
+		now = m.now()
+	}
+	if m.buckets == nil {
+		m.buckets = map[string]*bucket{}
+		m.lastPrune = now
+	}
+	if now.Sub(m.lastPrune) > rateLimitPruneInterval {
+		m.prune(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: m.burst(), last: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(m.burst(), b.tokens+now.Sub(b.last).Seconds()*m.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / m.Rate * float64(time.Second))
+}
+
+// prune drops the buckets which are full again, as their clients stopped
+// making requests, so that they don't pile up.
+func (m *RateLimit) prune(now time.Time) {
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*m.Rate >= m.burst() {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastPrune = now
+}
+
+func (m *RateLimit) clientKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && m.ValidToken != nil {
+		if token := strings.TrimPrefix(auth, "Bearer "); m.ValidToken(token) {
+			// Tokens aren't kept in memory.
+			sum := sha256.Sum256([]byte(token))
+			return "token:" + hex.EncodeToString(sum[:])
+		}
+	}
+	if _, trustForwardedFor := m.limits(); trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return "ip:" + strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func (m *RateLimit) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if rate, _ := m.limits(); rate <= 0 {
+		next(rw, r)
+		return
+	}
+	if ok, wait := m.take(m.clientKey(r)); !ok {
+		// Not http.Error, which would reset the Content-Type to plain text.
+		rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		rw.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(rw, `{"code":"rate_limited","message":"Too many requests"}`+"\n")
+		return
+	}
+	next(rw, r)
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/negroni"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	now := time.Now()
+	limit := &RateLimit{Rate: 0.5, Burst: 2, now: func() time.Time { return now },
+		ValidToken: func(token string) bool { return token == "token" }}
+	n := negroni.New(limit)
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(remoteAddr, auth string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest("GET", "/api/v1/job/", nil)
+		assert.NoError(t, err)
+		r.RemoteAddr = remoteAddr
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		n.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", "").Code)
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:5678", "").Code)
+	w := serve("10.0.0.1:1234", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/json;charset=UTF-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"code":"rate_limited","message":"Too many requests"}`+"\n", w.Body.String())
+
+	// Other clients have their own limit, and clients with a valid token are
+	// keyed by it, but not those with any other header.
+	assert.Equal(t, http.StatusOK, serve("10.0.0.2:1234", "").Code)
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", "Bearer token").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", "Bearer other").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", "Basic dG9rZW4=").Code)
+
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:1234", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("10.0.0.1:1234", "").Code)
+
+	// Buckets of clients which stopped making requests are dropped.
+	now = now.Add(2 * rateLimitPruneInterval)
+	assert.Equal(t, http.StatusOK, serve("10.0.0.3:1234", "").Code)
+	assert.Len(t, limit.buckets, 1)
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	limit := &RateLimit{Rate: 1, TrustForwardedFor: true}
+	r, err := http.NewRequest("GET", "/api/v1/job/", nil)
+	assert.NoError(t, err)
+	r.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "ip:10.0.0.1", limit.clientKey(r))
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	assert.Equal(t, "ip:203.0.113.7", limit.clientKey(r))
+
+	// Without ValidToken, tokens aren't trusted either.
+	r.Header.Set("Authorization", "Bearer token")
+	assert.Equal(t, "ip:203.0.113.7", limit.clientKey(r))
+}
+
+func TestRateLimitSet(t *testing.T) {
+	limit := &RateLimit{}
+	serve := func() int {
+		r, err := http.NewRequest("GET", "/api/v1/job/", nil)
+		assert.NoError(t, err)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		limit.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) {})
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusOK, serve())
+
+	limit.Set(0.001, 1, false)
+	assert.Equal(t, http.StatusOK, serve())
+	assert.Equal(t, http.StatusTooManyRequests, serve())
+
+	limit.Set(0, 0, false)
+	assert.Equal(t, http.StatusOK, serve())
+}
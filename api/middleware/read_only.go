@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// ReadOnly is a middleware handler that, once enabled, rejects the requests
+// which could change jobs with 503 Service Unavailable, e.g. while Kala shuts
+// down. Requests which only read are still served.
+type ReadOnly struct {
+	enabled int32
+}
+
+// Enable starts rejecting requests which could change jobs.
+func (m *ReadOnly) Enable() {
+	atomic.StoreInt32(&m.enabled, 1)
+}
+
+// Enabled says if requests which could change jobs are rejected.
+func (m *ReadOnly) Enabled() bool {
+	return atomic.LoadInt32(&m.enabled) == 1
+}
+
+func (m *ReadOnly) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		if m.Enabled() {
+			rw.Header().Set("Content-Type", "application/json;charset=UTF-8")
+			rw.Header().Set("Retry-After", "5")
+			http.Error(rw, `{"code":"unavailable","message":"Kala is shutting down"}`, http.StatusServiceUnavailable)
+			return
+		}
+	}
+	next(rw, r)
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnly(t *testing.T) {
+	readOnly := &ReadOnly{}
+	n := negroni.New(readOnly)
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(method string) int {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest(method, "/api/v1/job/", nil)
+		assert.NoError(t, err)
+		n.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("POST"))
+
+	readOnly.Enable()
+	assert.Equal(t, http.StatusOK, serve("GET"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve("POST"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve("DELETE"))
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nu7hatch/gouuid"
+)
+
+// RequestIDHeader is the header used to propagate request ids.
+const RequestIDHeader = "X-Request-ID"
+
+// Request ids provided by clients longer than this are replaced.
+const maxRequestIDLength = 128
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+// RequestID is a middleware handler that assigns every request an id, or
+// propagates the one sent in the X-Request-ID header. The id is echoed in
+// the response and stored in the request context.
+type RequestID struct{}
+
+func (m *RequestID) ServeHTTP(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+
+	rw.Header().Set(RequestIDHeader, id)
+	next(rw, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+}
+
+// RequestIDFromContext returns the request id stored by the RequestID middleware,
+// or an empty string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return ""
+	}
+	return u4.String()
+}
+
+// validRequestID guards the logs against overly long or non-printable ids.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
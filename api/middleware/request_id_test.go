@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveWithRequestID(t *testing.T, header string) (*httptest.ResponseRecorder, string) {
+	var seen string
+	n := negroni.New(&RequestID{})
+	n.UseHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	})
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/", nil)
+	assert.NoError(t, err)
+	if header != "" {
+		r.Header.Set(RequestIDHeader, header)
+	}
+	n.ServeHTTP(w, r)
+	return w, seen
+}
+
+func TestRequestIDGenerated(t *testing.T) {
+	w, seen := serveWithRequestID(t, "")
+	assert.NotEmpty(t, seen)
+	assert.Equal(t, seen, w.Header().Get(RequestIDHeader))
+}
+
+func TestRequestIDPropagated(t *testing.T) {
+	w, seen := serveWithRequestID(t, "abc-123")
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", w.Header().Get(RequestIDHeader))
+}
+
+func TestRequestIDInvalidReplaced(t *testing.T) {
+	for _, id := range []string{"has space", strings.Repeat("a", maxRequestIDLength+1)} {
+		_, seen := serveWithRequestID(t, id)
+		assert.NotEqual(t, id, seen)
+		assert.NotEmpty(t, seen)
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ajvb/kala/job"
+
+	"github.com/gorilla/mux"
+)
+
+// Prefix of the routes of the jobs of a namespace, e.g.
+// /api/v1/namespaces/data/job/.
+const ApiNamespacePath = ApiUrlPrefix + "namespaces/{ns}/"
+
+var (
+	ErrNoNamespaceToken        = errors.New("The routes of the namespace require a token, sent as Authorization: Bearer <token>")
+	ErrNamespaceTokenForbidden = errors.New("The token isn't allowed to use the routes of the namespace")
+	ErrNamespaceMismatch       = errors.New("The namespace of the job isn't the namespace of the route")
+	ErrNoAdminToken            = errors.New("The routes across namespaces require an admin token, sent as Authorization: Bearer <token>")
+	ErrAdminTokenForbidden     = errors.New("The token isn't an admin token")
+)
+
+// namespacedRoutes returns the routes of the jobs of a namespace: the routes
+// marked namespaced, under ApiNamespacePath and only for the clients with
+// the tokens of the namespace.
+func namespacedRoutes(routes []apiRoute) []apiRoute {
+	namespaced := []apiRoute{}
+	for _, route := range routes {
+		if !route.namespaced {
+			continue
+		}
+		route.path = ApiNamespacePath + strings.TrimPrefix(route.path, ApiUrlPrefix)
+		route.summary += ", in a namespace"
+		route.handler = requireNamespaceToken(route.handler)
+		namespaced = append(namespaced, route)
+	}
+	return namespaced
+}
+
+// requireNamespaceToken only lets the clients with a token of the namespace
+// of the route call the handler, if the namespace has tokens.
+func requireNamespaceToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ns := namespaceOf(r)
+		if !job.ValidNamespace(ns) {
+			errorEncodeJSON(job.ErrInvalidNamespace, http.StatusBadRequest, w)
+			return
+		}
+		if authorizeNamespace(w, r, ns) {
+			handler(w, r)
+		}
+	}
+}
+
+// requireAdminToken only lets the clients with an admin token call the
+// handler of a route across namespaces, once namespaces have tokens, see
+// job.Namespaces.AuthorizedAdmin. Its responses would otherwise show or
+// change the jobs of namespaces the client has no token of.
+func requireAdminToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		switch {
+		case job.GetNamespaces().AuthorizedAdmin(token):
+			handler(w, r)
+		case token == "":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kala"`)
+			errorEncodeJSON(ErrNoAdminToken, http.StatusUnauthorized, w)
+		default:
+			errorEncodeJSON(ErrAdminTokenForbidden, http.StatusForbidden, w)
+		}
+	}
+}
+
+// bearerToken returns the token the request sent as Authorization: Bearer
+// <token>, or "".
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// namespaceError returns ErrNoNamespaceToken or ErrNamespaceTokenForbidden
+// if the namespace has tokens and the client of the request didn't send one
+// of them, whatever the route: the routes of all jobs must not reach the jobs
+// of a namespace the client can't use.
+func namespaceError(r *http.Request, ns string) error {
+	token := bearerToken(r)
+	if job.GetNamespaces().Authorized(ns, token) {
+		return nil
+	}
+	if token == "" {
+		return ErrNoNamespaceToken
+	}
+	return ErrNamespaceTokenForbidden
+}
+
+// encodeNamespaceError responds with the error of namespaceError: 401
+// Unauthorized without a token, and 403 Forbidden with a wrong one.
+func encodeNamespaceError(err error, w http.ResponseWriter) {
+	if err == ErrNoNamespaceToken {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="kala"`)
+		errorEncodeJSON(err, http.StatusUnauthorized, w)
+		return
+	}
+	errorEncodeJSON(err, http.StatusForbidden, w)
+}
+
+// authorizeNamespace says if the client of the request may use the jobs of
+// the namespace, else responds with the error of namespaceError.
+func authorizeNamespace(w http.ResponseWriter, r *http.Request, ns string) bool {
+	if err := namespaceError(r, ns); err != nil {
+		encodeNamespaceError(err, w)
+		return false
+	}
+	return true
+}
+
+// authorizedJobs returns the jobs the client of the request may use, leaving
+// out those of the namespaces it has no token of.
+func authorizedJobs(r *http.Request, jobs []*job.Job) []*job.Job {
+	authorized := make([]*job.Job, 0, len(jobs))
+	for _, j := range jobs {
+		if namespaceError(r, j.Namespace) == nil {
+			authorized = append(authorized, j)
+		}
+	}
+	return authorized
+}
+
+// namespaceOf returns the namespace of the route of the request, or "" for
+// the routes of all jobs.
+func namespaceOf(r *http.Request) string {
+	return mux.Vars(r)["ns"]
+}
+
+// inNamespace says if the job is in the namespace of the route of the
+// request. Any job is in the routes of all jobs, whose namespace is "", which
+// getJob still checks the client has a token of the namespace of the job
+// for. Namespaces aren't changed by runs, so it's read without the lock of
+// the job.
+func inNamespace(j *job.Job, r *http.Request) bool {
+	ns := namespaceOf(r)
+	return ns == "" || j.Namespace == ns
+}
+
+// getJob returns the job with the id of the route of the request, if it's in
+// the namespace of the route. It returns the error of namespaceError if the
+// client has no token of the namespace of the job, see encodeGetJobError.
+func getJob(cache job.JobCache, r *http.Request) (*job.Job, error) {
+	j, err := cache.Get(mux.Vars(r)["id"])
+	if err != nil || j == nil || !inNamespace(j, r) {
+		return nil, job.ErrJobDoesntExist
+	}
+	if err := namespaceError(r, j.Namespace); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// encodeGetJobError responds with the error of getJob.
+func encodeGetJobError(err error, w http.ResponseWriter) {
+	if err == ErrNoNamespaceToken || err == ErrNamespaceTokenForbidden {
+		encodeNamespaceError(err, w)
+		return
+	}
+	errorEncodeJSON(job.ErrJobDoesntExist, http.StatusNotFound, w)
+}
@@ -0,0 +1,362 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/metrics"
+)
+
+// Path of the OpenAPI document describing the API
+const OpenAPIPath = ApiUrlPrefix + "openapi.json"
+
+// apiRoute is a route of the API. Both the router and the OpenAPI document
+// are built from the routes, so that the document describes every route.
+type apiRoute struct {
+	method  string
+	path    string
+	summary string
+	handler http.HandlerFunc
+
+	// Names of the query parameters, e.g. "owner".
+	query []string
+	// Values of the types of the JSON bodies of the request and of successful
+	// responses, nil if there is none.
+	request  interface{}
+	response interface{}
+	// Status of successful responses, 200 if zero.
+	status int
+	// Set if successful responses are text rather than JSON.
+	text bool
+	// Set if the route is also served for the jobs of a namespace, under
+	// ApiNamespacePath.
+	namespaced bool
+	// Set if the route reaches the jobs of all namespaces, and is only for
+	// the clients with an admin token, see requireAdminToken.
+	admin bool
+}
+
+// apiRoutes returns the routes of the API, in the order they're matched.
+func apiRoutes(cache job.JobCache, db job.JobDB, defaultOwner string) []apiRoute {
+	routes := []apiRoute{
+		{method: "POST", path: ApiJobPath, summary: "Create a job",
+			handler: HandleAddJob(cache, defaultOwner), request: &job.Job{}, response: &AddJobResponse{}, status: http.StatusCreated, namespaced: true},
+		{method: "DELETE", path: ApiJobPath + "all/", summary: "Delete all jobs",
+			handler: HandleDeleteAllJobs(cache, db), status: http.StatusNoContent},
+		{method: "POST", path: ApiJobPath + "import/cronjob/", summary: "Create jobs from the CronJobs of a Kubernetes manifest in YAML",
+			handler: HandleImportCronJobsRequest(cache, defaultOwner), response: &ImportCronJobsResponse{}, status: http.StatusCreated},
+		{method: "GET", path: ApiJobPath + "export/cronjob/", summary: "Export the jobs, or those with the owner, name, tag, namespace and group, as a Kubernetes manifest of CronJobs in YAML",
+			handler: HandleExportCronJobsRequest(cache), query: []string{"owner", "name", "tag", "namespace", "group", "image"}, text: true},
+		{method: "DELETE", path: ApiJobPath + "{id}/", summary: "Delete a job",
+			handler: HandleJobRequest(cache, db), status: http.StatusNoContent, namespaced: true},
+		{method: "GET", path: ApiJobPath + "search/", summary: `Search the jobs with a query, e.g. name~"backup" AND owner="data" AND disabled=false`,
+			handler: HandleSearchJobsRequest(cache), query: []string{"q"}, response: &SearchJobsResponse{}},
+		{method: "GET", path: ApiJobPath + "{id}/", summary: "Get a job",
+			handler: HandleJobRequest(cache, db), response: &JobResponse{}, namespaced: true},
+		{method: "PUT", path: ApiJobPath + "{id}/", summary: "Update a job at its resource_version",
+			handler: HandleUpdateJobRequest(cache), request: &job.Job{}, response: &JobResponse{}, namespaced: true},
+		{method: "GET", path: ApiJobPath + "stats/{id}/", summary: "List the stats of the runs of a job",
+			handler: HandleListJobStatsRequest(cache), response: &ListJobStatsResponse{}, namespaced: true},
+		{method: "GET", path: ApiJobPath + "{id}/stats/", summary: "Get the aggregated run counts of a job",
+			handler: HandleJobMetricsRequest(cache), response: &JobMetricsResponse{}, namespaced: true},
+		{method: "GET", path: ApiJobPath + "{id}/stats/summary/", summary: "Summarize the stats of a job over a window, e.g. 7d",
+			handler: HandleJobStatsSummaryRequest(cache), query: []string{"window"}, response: &JobStatsSummaryResponse{}, namespaced: true},
+		{method: "GET", path: ApiJobPath + "{id}/freshness/", summary: "Get when a job last succeeded, how long ago, and whether that is within its freshness SLA",
+			handler: HandleJobFreshnessRequest(cache), response: &JobFreshnessResponse{}, namespaced: true},
+		{method: "GET", path: ApiJobPath + "{id}/executions/", summary: "List the runs of a job, most recent first, filtered by status and time",
+			handler: HandleListExecutionsRequest(cache), query: []string{"status", "since", "until", "limit"}, response: &ListExecutionsResponse{}, namespaced: true},
+		{method: "POST", path: ApiJobPath + "{id}/executions/{runId}/replay/", summary: "Run a job again with the template context of one of its runs, e.g. to reprocess the window of a failed run",
+			handler: HandleReplayExecutionRequest(cache), response: &ReplayExecutionResponse{}, status: http.StatusCreated, namespaced: true},
+		{method: "POST", path: ApiJobPath + "{id}/backfill/", summary: "Run a job for every window of its schedule within a range, one after the other in the background",
+			handler: HandleBackfillRequest(cache), request: &BackfillRequest{}, response: &BackfillResponse{}, status: http.StatusAccepted, namespaced: true},
+		{method: "GET", path: ApiJobPath + "{id}/graph/", summary: "Get the graph of the jobs linked to a job as parents, children and on failure jobs, in JSON or DOT",
+			handler: HandleJobGraphRequest(cache), query: []string{"format"}, response: &GraphResponse{}},
+		{method: "GET", path: ApiJobPath, summary: "List the jobs, or those with the owner, name, tag, namespace and group",
+			handler: HandleListJobsRequest(cache), query: []string{"owner", "name", "tag", "namespace", "group"}, response: &ListJobsResponse{}, namespaced: true},
+		{method: "POST", path: ApiJobPath + "start/{id}/", summary: "Run a job now",
+			handler: HandleStartJobRequest(cache), status: http.StatusNoContent, namespaced: true},
+		{method: "POST", path: ApiJobPath + "enable/{id}/", summary: "Enable a job",
+			handler: HandleEnableJobRequest(cache), status: http.StatusNoContent, namespaced: true},
+		{method: "POST", path: ApiJobPath + "disable/{id}/", summary: "Disable a job",
+			handler: HandleDisableJobRequest(cache), status: http.StatusNoContent, namespaced: true},
+		{method: "POST", path: ApiJobPath + "{id}/disable/", summary: "Disable a job, recording who disabled it and why",
+			handler: HandleDisableJobWithReasonRequest(cache), request: &DisableJobRequest{}, response: &JobResponse{}, namespaced: true},
+		{method: "POST", path: ApiJobPath + "{id}/enable/", summary: "Enable a job, responding with the job",
+			handler: HandleEnableJobWithResponseRequest(cache), response: &JobResponse{}, namespaced: true},
+		{method: "GET", path: ApiGroupPath, summary: "List the groups of the jobs, with the counts of their jobs",
+			handler: HandleListGroupsRequest(cache), response: &ListGroupsResponse{}, namespaced: true},
+		{method: "GET", path: ApiGroupPath + "{id}/", summary: "Get a group and the jobs of it and its subgroups",
+			handler: HandleGroupRequest(cache, db), response: &GroupResponse{}, namespaced: true},
+		{method: "DELETE", path: ApiGroupPath + "{id}/", summary: "Delete the jobs of a group and its subgroups",
+			handler: HandleGroupRequest(cache, db), response: &GroupOperationResponse{}, namespaced: true},
+		{method: "POST", path: ApiGroupPath + "{id}/start/", summary: "Run the jobs of a group and its subgroups now",
+			handler: HandleStartGroupRequest(cache), response: &GroupOperationResponse{}, status: http.StatusAccepted, namespaced: true},
+		{method: "POST", path: ApiGroupPath + "{id}/enable/", summary: "Enable the jobs of a group and its subgroups",
+			handler: HandleEnableGroupRequest(cache), response: &GroupOperationResponse{}, namespaced: true},
+		{method: "POST", path: ApiGroupPath + "{id}/disable/", summary: "Disable the jobs of a group and its subgroups, recording who disabled them and why",
+			handler: HandleDisableGroupRequest(cache), request: &DisableJobRequest{}, response: &GroupOperationResponse{}, namespaced: true},
+		{method: "POST", path: ApiV2JobPath, summary: "Create a job (v2)",
+			handler: HandleAddJobV2Request(cache, defaultOwner), request: &AddJobV2Request{}, response: &JobV2{}, status: http.StatusCreated},
+		{method: "GET", path: ApiV2JobPath, summary: "List the jobs, or those with the owner, name, tag and group (v2)",
+			handler: HandleListJobsV2Request(cache), query: []string{"owner", "name", "tag", "group"}, response: &ListJobsV2Response{}},
+		{method: "GET", path: ApiV2JobPath + "{id}/", summary: "Get a job (v2)",
+			handler: HandleJobV2Request(cache, db), response: &JobV2{}},
+		{method: "PUT", path: ApiV2JobPath + "{id}/", summary: "Create a job with the id, or update it at its resource version (v2)",
+			handler: HandleUpdateJobV2Request(cache, defaultOwner), request: &UpdateJobV2Request{}, response: &JobV2{}},
+		{method: "DELETE", path: ApiV2JobPath + "{id}/", summary: "Delete a job, at its resource version if given (v2)",
+			handler: HandleJobV2Request(cache, db), query: []string{"resource_version"}, status: http.StatusNoContent},
+		{method: "GET", path: ApiUrlPrefix + "stats/", summary: "Get app-level metrics",
+			handler: HandleKalaStatsRequest(cache), response: &KalaStatsResponse{}, admin: true},
+		{method: "GET", path: ApiUrlPrefix + "graph/", summary: "Get the graph of the jobs which depend on each other, in JSON or DOT",
+			handler: HandleGraphRequest(cache), query: []string{"format"}, response: &GraphResponse{}, admin: true},
+		{method: "GET", path: ApiUrlPrefix + "overview/", summary: "Get an overview of the scheduler",
+			handler: HandleOverviewRequest(cache), response: &OverviewResponse{}, admin: true},
+		{method: "POST", path: ApiUrlPrefix + "admin/backup/", summary: "Back up all jobs, streaming the backup, or storing it with store=true",
+			handler: HandleBackupRequest(cache), query: []string{"store"}, response: &BackupResponse{}, status: http.StatusCreated, admin: true},
+		{method: "POST", path: ApiUrlPrefix + "admin/persist/", summary: "Save the jobs which changed since they were last saved, or all of them with all=true, reporting the jobs which couldn't be saved",
+			handler: HandlePersistRequest(cache), query: []string{"all"}, response: &job.PersistReport{}, admin: true},
+		{method: "GET", path: ApiUrlPrefix + "admin/persist/", summary: "Get the counters of the persist cycles, and how many times each job which couldn't be saved wasn't",
+			handler: HandlePersistStatusRequest(), response: &PersistStatusResponse{}, admin: true},
+		{method: "GET", path: ApiUrlPrefix + "admin/db/", summary: "Get the size of the job database",
+			handler: HandleDBStatsRequest(db), response: &metrics.DBStats{}, admin: true},
+		{method: "POST", path: ApiUrlPrefix + "admin/db/compact/", summary: "Compact the job database",
+			handler: HandleCompactDBRequest(db), response: &metrics.DBStats{}, admin: true},
+		{method: "POST", path: ApiUrlPrefix + "admin/reload/", summary: "Reload the log levels, notification channels, rate limit and namespaces from the config file and the environment",
+			handler: HandleReloadRequest(), status: http.StatusNoContent, admin: true},
+		{method: "GET", path: ApiUrlPrefix + "admin/consistency/", summary: "Check the links between jobs for cycles and broken links",
+			handler: HandleConsistencyRequest(cache), response: &job.ConsistencyReport{}, admin: true},
+		{method: "POST", path: ApiUrlPrefix + "admin/consistency/fix/", summary: "Fix the broken links between jobs",
+			handler: HandleFixConsistencyRequest(cache), response: &job.ConsistencyReport{}, admin: true},
+		{method: "GET", path: ApiUrlPrefix + "admin/schedule-load/", summary: "Get the runs jobs are scheduled to start in every minute of the next 24 hours, and the busiest minutes",
+			handler: HandleScheduleLoadRequest(cache), response: &ScheduleLoadResponse{}, admin: true},
+		{method: "POST", path: ApiUrlPrefix + "admin/schedule-load/rebalance/", summary: "Propose moves of the next runs of the jobs which start in the same minute, spreading them over a window, or apply them with apply=true",
+			handler: HandleRebalanceRequest(cache), query: []string{"window", "apply"}, response: &job.RebalanceReport{}, admin: true},
+		{method: "GET", path: MetricsPath, summary: "Export metrics to Prometheus",
+			handler: metrics.PrometheusHandler, text: true},
+		{method: "GET", path: ReadyzPath, summary: "Check that all jobs are loaded and the job database is healthy",
+			handler: HandleReadyzRequest(cache, db), response: &ReadyzResponse{}},
+	}
+	for i := range routes {
+		if routes[i].admin {
+			routes[i].handler = requireAdminToken(routes[i].handler)
+		}
+	}
+	routes = append(routes, namespacedRoutes(routes)...)
+	routes = append(routes, apiRoute{method: "GET", path: OpenAPIPath, summary: "Get this OpenAPI document",
+		response: map[string]interface{}{}})
+	routes[len(routes)-1].handler = handleOpenAPIRequest(routes)
+	return routes
+}
+
+// handleOpenAPIRequest is the handler for getting the OpenAPI document of the
+// routes.
+// GET /api/v1/openapi.json
+func handleOpenAPIRequest(routes []apiRoute) func(w http.ResponseWriter, r *http.Request) {
+	doc, err := json.Marshal(openAPIDocument(routes))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			errorEncodeJSON(err, http.StatusInternalServerError, w)
+			return
+		}
+		w.Header().Set(contentType, jsonContentType)
+		w.Write(doc)
+	}
+}
+
+var pathParameter = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPIDocument returns the OpenAPI 3 document of the routes, with the
+// schemas of their bodies derived from the JSON encoding of their types.
+func openAPIDocument(routes []apiRoute) map[string]interface{} {
+	b := newSchemaBuilder()
+	b.names[reflect.TypeOf(apiError{})] = "Error"
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content":     jsonContent(b.schema(reflect.TypeOf(apiError{}))),
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range routes {
+		op := map[string]interface{}{"summary": route.summary}
+
+		params := []interface{}{}
+		for _, match := range pathParameter.FindAllStringSubmatch(route.path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, name := range route.query {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.request != nil {
+			op["requestBody"] = map[string]interface{}{
+				"content": jsonContent(b.schema(reflect.TypeOf(route.request))),
+			}
+		}
+
+		status := route.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := map[string]interface{}{"description": http.StatusText(status)}
+		if route.text {
+			response["content"] = map[string]interface{}{
+				"text/plain": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+			}
+		} else if route.response != nil {
+			response["content"] = jsonContent(b.schema(reflect.TypeOf(route.response)))
+		}
+		op["responses"] = map[string]interface{}{
+			strconv.Itoa(status): response,
+			"default":            errorResponse,
+		}
+
+		if paths[route.path] == nil {
+			paths[route.path] = map[string]interface{}{}
+		}
+		paths[route.path][strings.ToLower(route.method)] = op
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Kala",
+			"version": "v1",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.components},
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaBuilder derives the schemas of types from their JSON encoding. Named
+// structs are components, referred to by name.
+type schemaBuilder struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		components: map[string]interface{}{},
+		names:      map[reflect.Type]string{},
+	}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "description": "Nanoseconds"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		return b.ref(t)
+	}
+	// Interfaces may hold any value.
+	return map[string]interface{}{}
+}
+
+// ref returns a reference to the component of a struct, adding it first if
+// needed. Anonymous structs are inlined.
+func (b *schemaBuilder) ref(t reflect.Type) map[string]interface{} {
+	if t.Name() == "" {
+		return b.object(t)
+	}
+	name, ok := b.names[t]
+	if !ok || b.components[name] == nil {
+		if !ok {
+			name = t.Name()
+			if _, taken := b.components[name]; taken {
+				name = path.Base(t.PkgPath()) + name
+			}
+			b.names[t] = name
+		}
+		// Registered before its fields, for types referring to themselves.
+		b.components[name] = map[string]interface{}{}
+		b.components[name] = b.object(t)
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	b.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// addFields adds the properties of the exported fields of a struct, and of the
+// structs it embeds, like encoding/json.
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				b.addFields(embedded, properties)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = b.schema(f.Type)
+	}
+}
@@ -0,0 +1,485 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/logsink"
+	"github.com/ajvb/kala/notify"
+	"github.com/ajvb/kala/validation"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Base API v2 Path
+	ApiV2UrlPrefix = "/api/v2/"
+
+	JobsPath     = "jobs/"
+	ApiV2JobPath = ApiV2UrlPrefix + JobsPath
+)
+
+var ErrInvalidResourceVersion = errors.New("Invalid resource_version. It should be the resource version of the job, e.g. 3")
+
+// JobV2 is the representation of jobs in v2 of the API: what the job should
+// do in Spec, and what it did in Status. v2 is an adapter of the jobs of v1,
+// which keeps working.
+type JobV2 struct {
+	Id string `json:"id"`
+	// Incremented whenever the spec changes, see UpdateJobV2Request.
+	ResourceVersion uint64      `json:"resource_version"`
+	Spec            JobSpecV2   `json:"spec"`
+	Status          JobStatusV2 `json:"status"`
+}
+
+// JobSpecV2 is what a job should do. Only the properties of its type are set.
+type JobSpecV2 struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner,omitempty"`
+	// Team owning the job and its contacts.
+	Ownership *notify.Ownership `json:"ownership,omitempty"`
+	// One of local, remote, amqp, lambda, pubsub, sql and grpc.
+	Type           string           `json:"type"`
+	Command        string           `json:"command,omitempty"`
+	ExitCodes      *job.ExitCodes   `json:"exit_codes,omitempty"`
+	ParseResult    bool             `json:"parse_result,omitempty"`
+	Artifacts      []string         `json:"artifacts,omitempty"`
+	Schedule       string           `json:"schedule,omitempty"`
+	Epsilon        string           `json:"epsilon,omitempty"`
+	Retries        uint             `json:"retries"`
+	Disabled       bool             `json:"disabled"`
+	Shadow         bool             `json:"shadow,omitempty"`
+	Tags           []string         `json:"tags,omitempty"`
+	ParentJobs     []string         `json:"parent_jobs,omitempty"`
+	OnFailureJob   string           `json:"on_failure_job,omitempty"`
+	TriggerSubject string           `json:"trigger_subject,omitempty"`
+	MaxStats       int              `json:"max_stats,omitempty"`
+	Notifications  *notify.Settings `json:"notifications,omitempty"`
+
+	LogSinks     *logsink.Settings `json:"log_sinks,omitempty"`
+	SlowFactor   float64           `json:"slow_factor,omitempty"`
+	FreshnessSLA string            `json:"freshness_sla,omitempty"`
+
+	MisfirePolicy    string `json:"misfire_policy,omitempty"`
+	MisfireTolerance string `json:"misfire_tolerance,omitempty"`
+
+	Engine string `json:"engine,omitempty"`
+
+	Group string `json:"group,omitempty"`
+
+	Namespace string `json:"namespace,omitempty"`
+
+	Remote *job.RemoteProperties `json:"remote,omitempty"`
+	AMQP   *job.AMQPProperties   `json:"amqp,omitempty"`
+	Lambda *job.LambdaProperties `json:"lambda,omitempty"`
+	PubSub *job.PubSubProperties `json:"pubsub,omitempty"`
+	SQL    *job.SQLProperties    `json:"sql,omitempty"`
+	GRPC   *job.GRPCProperties   `json:"grpc,omitempty"`
+
+	Handler *job.HandlerProperties `json:"handler,omitempty"`
+	Plugin  *job.PluginProperties  `json:"plugin,omitempty"`
+}
+
+// JobStatusV2 is what a job did. Times are RFC 3339 in UTC, and left out until
+// they happen.
+type JobStatusV2 struct {
+	NextRunAt        string `json:"next_run_at,omitempty"`
+	LastAttemptedRun string `json:"last_attempted_run,omitempty"`
+	LastSuccess      string `json:"last_success,omitempty"`
+	LastError        string `json:"last_error,omitempty"`
+	SuccessCount     uint   `json:"success_count"`
+	WarningCount     uint   `json:"warning_count"`
+	ErrorCount       uint   `json:"error_count"`
+	Done             bool   `json:"done"`
+	// Jobs run after this one, which list it in their parent_jobs.
+	DependentJobs []string          `json:"dependent_jobs,omitempty"`
+	Disabled      *DisabledStatusV2 `json:"disabled,omitempty"`
+}
+
+// DisabledStatusV2 tells who disabled a job, when and why.
+type DisabledStatusV2 struct {
+	Source string `json:"source"`
+	By     string `json:"by,omitempty"`
+	At     string `json:"at"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// formatTimeV2 formats times of v2, which are empty if zero.
+func formatTimeV2(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// NewJobV2 returns the v2 representation of a job, which should be a copy,
+// see job.Job.Copy. Its secrets are redacted.
+func NewJobV2(j *job.Job) *JobV2 {
+	j.RedactSecrets()
+	v2 := &JobV2{
+		Id:              j.Id,
+		ResourceVersion: j.ResourceVersion,
+		Spec: JobSpecV2{
+			Name:           j.Name,
+			Owner:          j.Owner,
+			Ownership:      j.Ownership,
+			Type:           j.TypeName(),
+			Command:        j.Command,
+			ExitCodes:      j.ExitCodes,
+			ParseResult:    j.ParseResult,
+			Artifacts:      j.Artifacts,
+			Schedule:       j.Schedule,
+			Epsilon:        j.Epsilon,
+			Retries:        j.Retries,
+			Disabled:       j.Disabled,
+			Shadow:         j.Shadow,
+			Tags:           j.Tags,
+			ParentJobs:     j.ParentJobs,
+			OnFailureJob:   j.OnFailureJob,
+			TriggerSubject: j.TriggerSubject,
+			MaxStats:       j.MaxStats,
+			Notifications:  j.Notifications,
+			LogSinks:       j.LogSinks,
+			SlowFactor:     j.SlowFactor,
+			FreshnessSLA:   j.FreshnessSLA,
+
+			MisfirePolicy:    j.MisfirePolicy,
+			MisfireTolerance: j.MisfireTolerance,
+
+			Engine: j.Engine,
+
+			Group: j.Group,
+
+			Namespace: j.Namespace,
+		},
+		Status: JobStatusV2{
+			NextRunAt:        formatTimeV2(j.NextRunAt),
+			LastAttemptedRun: formatTimeV2(j.Metadata.LastAttemptedRun),
+			LastSuccess:      formatTimeV2(j.Metadata.LastSuccess),
+			LastError:        formatTimeV2(j.Metadata.LastError),
+			SuccessCount:     j.Metadata.SuccessCount,
+			WarningCount:     j.Metadata.WarningCount,
+			ErrorCount:       j.Metadata.ErrorCount,
+			Done:             j.IsDone,
+			DependentJobs:    j.DependentJobs,
+		},
+	}
+	switch j.JobType {
+	case job.RemoteJob:
+		v2.Spec.Remote = &j.RemoteProperties
+	case job.AMQPJob:
+		v2.Spec.AMQP = &j.AMQPProperties
+	case job.LambdaJob:
+		v2.Spec.Lambda = &j.LambdaProperties
+	case job.PubSubJob:
+		v2.Spec.PubSub = &j.PubSubProperties
+	case job.SQLJob:
+		v2.Spec.SQL = &j.SQLProperties
+	case job.GRPCJob:
+		v2.Spec.GRPC = &j.GRPCProperties
+	case job.HandlerJob:
+		v2.Spec.Handler = &j.HandlerProperties
+	case job.PluginJob:
+		v2.Spec.Plugin = &j.PluginProperties
+	}
+	if info := j.DisabledInfo; j.Disabled && info != nil {
+		v2.Status.Disabled = &DisabledStatusV2{
+			Source: info.Source,
+			By:     info.By,
+			At:     formatTimeV2(info.At),
+			Reason: info.Reason,
+		}
+	}
+	return v2
+}
+
+// Job returns the v1 job of the spec, to be initialized.
+func (s *JobSpecV2) Job() (*job.Job, error) {
+	j := &job.Job{
+		Name:           s.Name,
+		Owner:          s.Owner,
+		Ownership:      s.Ownership,
+		Command:        s.Command,
+		ExitCodes:      s.ExitCodes,
+		ParseResult:    s.ParseResult,
+		Artifacts:      s.Artifacts,
+		Schedule:       s.Schedule,
+		Epsilon:        s.Epsilon,
+		Retries:        s.Retries,
+		Disabled:       s.Disabled,
+		Shadow:         s.Shadow,
+		Tags:           s.Tags,
+		ParentJobs:     s.ParentJobs,
+		OnFailureJob:   s.OnFailureJob,
+		TriggerSubject: s.TriggerSubject,
+		MaxStats:       s.MaxStats,
+		Notifications:  s.Notifications,
+		LogSinks:       s.LogSinks,
+		SlowFactor:     s.SlowFactor,
+		FreshnessSLA:   s.FreshnessSLA,
+
+		MisfirePolicy:    s.MisfirePolicy,
+		MisfireTolerance: s.MisfireTolerance,
+
+		Engine: s.Engine,
+
+		Group: s.Group,
+
+		Namespace: s.Namespace,
+	}
+	typeName := s.Type
+	if typeName == "" {
+		typeName = "local"
+	}
+	if err := j.SetTypeName(typeName); err != nil {
+		return nil, err
+	}
+	if s.Remote != nil {
+		j.RemoteProperties = *s.Remote
+	}
+	if s.AMQP != nil {
+		j.AMQPProperties = *s.AMQP
+	}
+	if s.Lambda != nil {
+		j.LambdaProperties = *s.Lambda
+	}
+	if s.PubSub != nil {
+		j.PubSubProperties = *s.PubSub
+	}
+	if s.SQL != nil {
+		j.SQLProperties = *s.SQL
+	}
+	if s.GRPC != nil {
+		j.GRPCProperties = *s.GRPC
+	}
+	if s.Handler != nil {
+		j.HandlerProperties = *s.Handler
+	}
+	if s.Plugin != nil {
+		j.PluginProperties = *s.Plugin
+	}
+	return j, nil
+}
+
+type AddJobV2Request struct {
+	Spec JobSpecV2 `json:"spec"`
+}
+
+// UpdateJobV2Request is the body of requests creating or updating a job with
+// its id. The resource version is 0 to create the job, else the resource
+// version of the job the spec was made from: the job is only updated if it
+// didn't change since.
+type UpdateJobV2Request struct {
+	ResourceVersion uint64    `json:"resource_version"`
+	Spec            JobSpecV2 `json:"spec"`
+}
+
+type ListJobsV2Response struct {
+	Jobs []*JobV2 `json:"jobs"`
+}
+
+// HandleAddJobV2Request is the handler for creating jobs in v2 of the API. It
+// responds with the job.
+// POST /api/v2/jobs
+func HandleAddJobV2Request(cache job.JobCache, defaultOwner string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+		req := AddJobV2Request{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+		newJob, err := req.Spec.Job()
+		if err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+		if defaultOwner != "" && newJob.Owner == "" {
+			newJob.Owner = defaultOwner
+		}
+		if !authorizeNamespace(w, r, newJob.Namespace) {
+			return
+		}
+		if err := validation.Job(newJob, cache); err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+		if err := job.GetNamespaces().CheckQuota(cache, newJob); err != nil {
+			errorEncodeJSON(err, http.StatusForbidden, w)
+			return
+		}
+
+		if err := newJob.InitWithContext(runContext(r), cache); err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+
+		encodeJobV2(w, http.StatusCreated, newJob)
+	}
+}
+
+// HandleUpdateJobV2Request is the handler for creating a job with the id of
+// the path, or updating it, in v2 of the API, so that clients such as
+// Terraform providers can manage jobs by ids they choose. It responds with
+// the job, with 201 Created if it was created, and with 409 Conflict if the
+// job exists when creating it, or changed since the resource version when
+// updating it.
+// PUT /api/v2/jobs/{id}
+func HandleUpdateJobV2Request(cache job.JobCache, defaultOwner string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1048576))
+		if err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+		req := UpdateJobV2Request{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			errorEncodeJSON(err, http.StatusBadRequest, w)
+			return
+		}
+		spec, err := req.Spec.Job()
+		if err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+		if defaultOwner != "" && spec.Owner == "" {
+			spec.Owner = defaultOwner
+		}
+		if !authorizeNamespace(w, r, spec.Namespace) {
+			return
+		}
+
+		id := mux.Vars(r)["id"]
+		if req.ResourceVersion == 0 {
+			if !job.ValidId(id) {
+				errorEncodeJSON(job.ErrInvalidId, http.StatusBadRequest, w)
+				return
+			}
+			if err := validation.Job(spec, cache); err != nil {
+				jobErrorEncodeJSON(err, w)
+				return
+			}
+			if err := job.GetNamespaces().CheckQuota(cache, spec); err != nil {
+				errorEncodeJSON(err, http.StatusForbidden, w)
+				return
+			}
+			if err := spec.InitWithId(runContext(r), cache, id); err == job.ErrJobExists {
+				errorEncodeJSON(err, http.StatusConflict, w)
+				return
+			} else if err != nil {
+				jobErrorEncodeJSON(err, w)
+				return
+			}
+			encodeJobV2(w, http.StatusCreated, spec)
+			return
+		}
+
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+		current := j.Copy()
+		if err := validation.Update(spec, current, cache); err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+		if spec.Namespace != current.Namespace {
+			if err := job.GetNamespaces().CheckQuota(cache, spec); err != nil {
+				errorEncodeJSON(err, http.StatusForbidden, w)
+				return
+			}
+		}
+		if err := j.Update(cache, spec, req.ResourceVersion); err == job.ErrStaleResourceVersion {
+			errorEncodeJSON(err, http.StatusConflict, w)
+			return
+		} else if err != nil {
+			jobErrorEncodeJSON(err, w)
+			return
+		}
+		encodeJobV2(w, http.StatusOK, j)
+	}
+}
+
+// HandleListJobsV2Request is the handler for listing the jobs in v2 of the
+// API, ordered by id, or those with the owner, name and tag given as query
+// parameters. Like in v1, it responds with 304 Not Modified if the jobs didn't
+// change since the ETag given in If-None-Match.
+// GET /api/v2/jobs
+func HandleListJobsV2Request(cache job.JobCache) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if notModified(w, r, jobsETag()) {
+			return
+		}
+
+		query := r.URL.Query()
+		jobs := cache.Find(job.JobFilter{
+			Owner: query.Get("owner"),
+			Name:  query.Get("name"),
+			Tag:   query.Get("tag"),
+			Group: query.Get("group"),
+		})
+		jobs = authorizedJobs(r, jobs)
+		resp := &ListJobsV2Response{Jobs: make([]*JobV2, 0, len(jobs))}
+		for _, j := range jobs {
+			resp.Jobs = append(resp.Jobs, NewJobV2(j.Copy()))
+		}
+
+		w.Header().Set(contentType, jsonContentType)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("Error occured when marshalling response: %s", err)
+		}
+	}
+}
+
+// HandleJobV2Request is the handler for getting a job in v2 of the API, or
+// deleting it. Deleting a job with a resource_version query parameter
+// responds with 409 Conflict if the job changed since that version.
+// GET, DELETE /api/v2/jobs/{id}
+func HandleJobV2Request(cache job.JobCache, db job.JobDB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, err := getJob(cache, r)
+		if err != nil {
+			encodeGetJobError(err, w)
+			return
+		}
+
+		if r.Method == "DELETE" {
+			if v := r.URL.Query().Get("resource_version"); v != "" {
+				version, parseErr := strconv.ParseUint(v, 10, 64)
+				if parseErr != nil {
+					errorEncodeJSON(ErrInvalidResourceVersion, http.StatusBadRequest, w)
+					return
+				}
+				err = j.DeleteAtVersion(r.Context(), cache, db, version)
+			} else {
+				err = j.DeleteWithContext(r.Context(), cache, db)
+			}
+			if err != nil {
+				errorEncodeJSON(err, dbErrorStatus(err), w)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		encodeJobV2(w, http.StatusOK, j)
+	}
+}
+
+func encodeJobV2(w http.ResponseWriter, status int, j *job.Job) {
+	w.Header().Set(contentType, jsonContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(NewJobV2(j.Copy())); err != nil {
+		log.Errorf("Error occured when marshalling response: %s", err)
+	}
+}
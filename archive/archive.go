@@ -0,0 +1,159 @@
+// Package archive stores the full responses of remote job runs in object
+// storage (S3 or Google Cloud Storage) or on local disk, since large payloads
+// shouldn't live in the JobDB, and removes them after a retention period.
+package archive
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/utils/logging"
+)
+
+var log = logging.GetLogger(logging.Runner)
+
+// MaxSize is the size of the largest response which is archived. Larger
+// responses are cut off.
+var MaxSize int64 = 64 << 20
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+var ErrUnknownStore = errors.New("Unknown archive URL. Archives are stored at s3://bucket/prefix, gs://bucket/prefix or file:///path")
+
+// Object is a stored archive.
+type Object struct {
+	Key      string
+	Modified time.Time
+}
+
+// Store stores archives by key.
+type Store interface {
+	// Put stores the data and returns a link to it.
+	Put(key string, data []byte, contentType string) (string, error)
+
+	// List returns the archives stored under the prefix.
+	List(prefix string) ([]Object, error)
+
+	Delete(key string) error
+}
+
+// Archiver stores archives in a Store, with keys under a common prefix.
+type Archiver struct {
+	Store  Store
+	Prefix string
+}
+
+// Open returns an Archiver for a URL:
+//
+//	s3://bucket/prefix, with optional region and endpoint parameters, e.g.
+//	    s3://bucket/kala?region=eu-west-1 or s3://bucket?endpoint=http://minio:9000
+//	gs://bucket/prefix
+//	file:///var/lib/kala/archive, or a plain path
+func Open(rawurl string) (*Archiver, error) {
+	if !strings.Contains(rawurl, "://") {
+		return &Archiver{Store: &LocalStore{Dir: rawurl}}, nil
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return &Archiver{Store: &LocalStore{Dir: u.Path}}, nil
+	case "s3":
+		store := &S3Store{
+			Bucket:   u.Host,
+			Region:   u.Query().Get("region"),
+			Endpoint: u.Query().Get("endpoint"),
+		}
+		return &Archiver{Store: store, Prefix: prefix}, nil
+	case "gs":
+		return &Archiver{Store: &GCSStore{Bucket: u.Host}, Prefix: prefix}, nil
+	}
+	return nil, ErrUnknownStore
+}
+
+func (a *Archiver) key(name string) string {
+	if a.Prefix == "" {
+		return name
+	}
+	return a.Prefix + "/" + name
+}
+
+// Put stores the data under the prefix and returns a link to it.
+func (a *Archiver) Put(name string, data []byte, contentType string) (string, error) {
+	return a.Store.Put(a.key(name), data, contentType)
+}
+
+// Expire deletes the archives under the prefix which are older than the
+// retention, and returns how many were deleted.
+func (a *Archiver) Expire(retention time.Duration, now time.Time) (int, error) {
+	prefix := ""
+	if a.Prefix != "" {
+		prefix = a.Prefix + "/"
+	}
+	objects, err := a.Store.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, o := range objects {
+		if now.Sub(o.Modified) <= retention {
+			continue
+		}
+		if err := a.Store.Delete(o.Key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// StartExpiring deletes expired archives every interval until the returned
+// channel is closed.
+func (a *Archiver) StartExpiring(retention, interval time.Duration) chan<- struct{} {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			deleted, err := a.Expire(retention, time.Now())
+			if err != nil {
+				log.Errorf("Error occured expiring archives: %s", err)
+			} else if deleted > 0 {
+				log.Infof("Deleted %d expired archives", deleted)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return stop
+}
+
+var (
+	defaultArchiver     *Archiver
+	defaultArchiverLock sync.RWMutex
+)
+
+// SetDefault replaces the Archiver which archives the responses of jobs.
+func SetDefault(a *Archiver) {
+	defaultArchiverLock.Lock()
+	defer defaultArchiverLock.Unlock()
+	defaultArchiver = a
+}
+
+// Default returns the Archiver which archives the responses of jobs, or nil
+// if archiving isn't set up.
+func Default() *Archiver {
+	defaultArchiverLock.RLock()
+	defer defaultArchiverLock.RUnlock()
+	return defaultArchiver
+}
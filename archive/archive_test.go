@@ -0,0 +1,75 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen(t *testing.T) {
+	a, err := Open("s3://reports/kala/responses?region=eu-west-1&endpoint=http://minio:9000")
+	assert.NoError(t, err)
+	assert.Equal(t, &S3Store{Bucket: "reports", Region: "eu-west-1", Endpoint: "http://minio:9000"}, a.Store)
+	assert.Equal(t, "kala/responses", a.Prefix)
+
+	a, err = Open("gs://reports")
+	assert.NoError(t, err)
+	assert.Equal(t, &GCSStore{Bucket: "reports"}, a.Store)
+	assert.Equal(t, "", a.Prefix)
+
+	a, err = Open("file:///var/lib/kala")
+	assert.NoError(t, err)
+	assert.Equal(t, &LocalStore{Dir: "/var/lib/kala"}, a.Store)
+
+	a, err = Open("archive")
+	assert.NoError(t, err)
+	assert.Equal(t, &LocalStore{Dir: "archive"}, a.Store)
+
+	_, err = Open("ftp://example.com/archive")
+	assert.Equal(t, ErrUnknownStore, err)
+}
+
+func TestLocalArchiver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a := &Archiver{Store: &LocalStore{Dir: dir}, Prefix: "responses"}
+	link, err := a.Put("job/run1", []byte("response"), "text/plain")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "responses", "job", "run1"), link)
+	b, err := ioutil.ReadFile(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "response", string(b))
+
+	_, err = a.Put("job/run2", []byte("response"), "text/plain")
+	assert.NoError(t, err)
+	old := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, os.Chtimes(link, old, old))
+
+	// Files outside of the prefix are kept.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "other"), nil, 0644))
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "other"), old, old))
+
+	deleted, err := a.Expire(24*time.Hour, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+
+	objects, err := a.Store.List("")
+	assert.NoError(t, err)
+	keys := []string{}
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	assert.Equal(t, []string{"other", "responses/job/run2"}, keys)
+}
+
+func TestLocalStoreListMissingDir(t *testing.T) {
+	objects, err := (&LocalStore{Dir: "/nonexistent/kala"}).List("")
+	assert.NoError(t, err)
+	assert.Empty(t, objects)
+}
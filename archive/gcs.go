@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/utils/gcpauth"
+)
+
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCSStore stores archives in a Google Cloud Storage bucket. Credentials are
+// looked up like Application Default Credentials. If STORAGE_EMULATOR_HOST is
+// set, the emulator is used instead, without credentials.
+type GCSStore struct {
+	Bucket string
+}
+
+func (s *GCSStore) endpoint() (string, bool) {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return strings.TrimRight(host, "/"), true
+	}
+	return "https://storage.googleapis.com", false
+}
+
+func (s *GCSStore) do(method, path string, query url.Values, data []byte, contentType string) (*http.Response, error) {
+	endpoint, emulated := s.endpoint()
+	req, err := http.NewRequest(method, endpoint+path+"?"+query.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if !emulated {
+		source, err := gcpauth.DefaultTokenSource("", gcsScope)
+		if err != nil {
+			return nil, err
+		}
+		token, err := source.Token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		return nil, fmt.Errorf("Cloud Storage returned %s: %s", res.Status, body.Error.Message)
+	}
+	return res, nil
+}
+
+// Put uploads the data and returns its gs:// URL.
+func (s *GCSStore) Put(key string, data []byte, contentType string) (string, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	res, err := s.do("POST", "/upload/storage/v1/b/"+url.PathEscape(s.Bucket)+"/o", query, data, contentType)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return "gs://" + s.Bucket + "/" + key, nil
+}
+
+// List returns the objects whose names start with the prefix.
+func (s *GCSStore) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name,updated),nextPageToken"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		res, err := s.do("GET", "/storage/v1/b/"+url.PathEscape(s.Bucket)+"/o", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Items []struct {
+				Name    string    `json:"name"`
+				Updated time.Time `json:"updated"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range body.Items {
+			objects = append(objects, Object{Key: item.Name, Modified: item.Updated})
+		}
+		if body.NextPageToken == "" {
+			return objects, nil
+		}
+		token = body.NextPageToken
+	}
+}
+
+// Delete deletes the object.
+func (s *GCSStore) Delete(key string) error {
+	res, err := s.do("DELETE", "/storage/v1/b/"+url.PathEscape(s.Bucket)+"/o/"+url.PathEscape(key), url.Values{}, nil, "")
+	if err != nil {
+		return err
+	}
+	ioutil.ReadAll(res.Body)
+	return res.Body.Close()
+}
@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCSStore(t *testing.T) {
+	modified := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		assert.Empty(t, r.Header.Get("Authorization"))
+		switch r.Method {
+		case "POST":
+			assert.Equal(t, "media", r.URL.Query().Get("uploadType"))
+			assert.Equal(t, "kala/job/run", r.URL.Query().Get("name"))
+			b, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, "response", string(b))
+			fmt.Fprint(w, `{}`)
+		case "GET":
+			assert.Equal(t, "kala/", r.URL.Query().Get("prefix"))
+			if r.URL.Query().Get("pageToken") == "" {
+				fmt.Fprintf(w, `{"items": [{"name": "kala/a", "updated": "%s"}], "nextPageToken": "next"}`, modified.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(w, `{"items": [{"name": "kala/b", "updated": "%s"}]}`, modified.Format(time.RFC3339))
+			}
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	os.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	defer os.Unsetenv("STORAGE_EMULATOR_HOST")
+
+	s := &GCSStore{Bucket: "reports"}
+	link, err := s.Put("kala/job/run", []byte("response"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, "gs://reports/kala/job/run", link)
+
+	objects, err := s.List("kala/")
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{{"kala/a", modified}, {"kala/b", modified}}, objects)
+
+	assert.NoError(t, s.Delete("kala/a"))
+	assert.Equal(t, []string{
+		"POST /upload/storage/v1/b/reports/o",
+		"GET /storage/v1/b/reports/o",
+		"GET /storage/v1/b/reports/o",
+		"DELETE /storage/v1/b/reports/o/kala%2Fa",
+	}, requests)
+}
@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore stores archives as files in a directory.
+type LocalStore struct {
+	Dir string
+}
+
+func (s *LocalStore) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key))
+}
+
+// Put writes the data to a file and returns its path.
+func (s *LocalStore) Put(key string, data []byte, contentType string) (string, error) {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// List returns the files whose keys start with the prefix.
+func (s *LocalStore) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.Dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{Key: key, Modified: info.ModTime()})
+		}
+		return nil
+	})
+	return objects, err
+}
+
+// Delete removes the file, and its directory if it's left empty.
+func (s *LocalStore) Delete(key string) error {
+	path := s.path(key)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Fails unless the directory is empty.
+	os.Remove(filepath.Dir(path))
+	return nil
+}
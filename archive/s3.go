@@ -0,0 +1,152 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/utils/awsauth"
+)
+
+var ErrNoS3Region = errors.New("No region for the S3 archive. Add a region parameter to the archive URL, or set AWS_REGION")
+
+// S3Store stores archives in an S3 bucket. Credentials are looked up like
+// the AWS SDKs do, see awsauth.DefaultChain.
+type S3Store struct {
+	Bucket string
+
+	// Defaults to AWS_REGION.
+	Region string
+
+	// Overrides the S3 endpoint, e.g. with an S3 compatible store like MinIO.
+	// Buckets of custom endpoints are addressed by path.
+	Endpoint string
+}
+
+func (s *S3Store) region() string {
+	if s.Region != "" {
+		return s.Region
+	}
+	if region := awsauth.Region(); region != "" {
+		return region
+	}
+	// Custom endpoints often don't care about the region.
+	if s.Endpoint != "" {
+		return "us-east-1"
+	}
+	return ""
+}
+
+func (s *S3Store) url(key string) string {
+	path := "/" + escapeKey(key)
+	if s.Endpoint != "" {
+		return strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket + path
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", s.Bucket, s.region(), path)
+}
+
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = awsauth.EscapePath(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+func (s *S3Store) do(method, rawurl string, data []byte, contentType string) (*http.Response, error) {
+	region := s.region()
+	if region == "" {
+		return nil, ErrNoS3Region
+	}
+	creds, err := awsauth.DefaultChain.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, rawurl, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	awsauth.SignS3(req, data, creds, region, time.Now())
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 != 2 {
+		defer res.Body.Close()
+		var body struct {
+			Code    string
+			Message string
+		}
+		xml.NewDecoder(res.Body).Decode(&body)
+		return nil, fmt.Errorf("S3 returned %s: %s %s", res.Status, body.Code, body.Message)
+	}
+	return res, nil
+}
+
+// Put uploads the data and returns its s3:// URL.
+func (s *S3Store) Put(key string, data []byte, contentType string) (string, error) {
+	res, err := s.do("PUT", s.url(key), data, contentType)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return "s3://" + s.Bucket + "/" + key, nil
+}
+
+// List returns the objects whose keys start with the prefix.
+func (s *S3Store) List(prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		// The bucket's URL, without a key.
+		rawurl := strings.TrimSuffix(s.url(""), "/") + "/?" + query.Encode()
+		res, err := s.do("GET", rawurl, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		var body struct {
+			Contents []struct {
+				Key          string
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(res.Body).Decode(&body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range body.Contents {
+			objects = append(objects, Object{Key: c.Key, Modified: c.LastModified})
+		}
+		if !body.IsTruncated || body.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = body.NextContinuationToken
+	}
+}
+
+// Delete deletes the object.
+func (s *S3Store) Delete(key string) error {
+	res, err := s.do("DELETE", s.url(key), nil, "")
+	if err != nil {
+		return err
+	}
+	ioutil.ReadAll(res.Body)
+	return res.Body.Close()
+}
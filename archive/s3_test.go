@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3Store(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	modified := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=id/")
+		assert.NotEmpty(t, r.Header.Get("X-Amz-Content-Sha256"))
+		switch r.Method {
+		case "PUT":
+			b, _ := ioutil.ReadAll(r.Body)
+			assert.Equal(t, "response", string(b))
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		case "GET":
+			if r.URL.Query().Get("continuation-token") == "" {
+				fmt.Fprintf(w, `<ListBucketResult><Contents><Key>kala/a</Key><LastModified>%s</LastModified></Contents><IsTruncated>true</IsTruncated><NextContinuationToken>next</NextContinuationToken></ListBucketResult>`, modified.Format(time.RFC3339))
+			} else {
+				fmt.Fprintf(w, `<ListBucketResult><Contents><Key>kala/b</Key><LastModified>%s</LastModified></Contents><IsTruncated>false</IsTruncated></ListBucketResult>`, modified.Format(time.RFC3339))
+			}
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	s := &S3Store{Bucket: "reports", Endpoint: ts.URL}
+	link, err := s.Put("kala/job/run", []byte("response"), "application/json")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3://reports/kala/job/run", link)
+
+	objects, err := s.List("kala/")
+	assert.NoError(t, err)
+	assert.Equal(t, []Object{{"kala/a", modified}, {"kala/b", modified}}, objects)
+
+	assert.NoError(t, s.Delete("kala/a"))
+	assert.Equal(t, []string{
+		"PUT /reports/kala/job/run",
+		"GET /reports/?list-type=2&prefix=kala%2F",
+		"GET /reports/?continuation-token=next&list-type=2&prefix=kala%2F",
+		"DELETE /reports/kala/a",
+	}, requests)
+}
+
+func TestS3StoreError(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+	}))
+	defer ts.Close()
+
+	_, err := (&S3Store{Bucket: "reports", Endpoint: ts.URL}).Put("kala/job/run", nil, "")
+	assert.EqualError(t, err, "S3 returned 403 Forbidden: AccessDenied Access Denied")
+}
+
+func TestS3StoreURL(t *testing.T) {
+	s := &S3Store{Bucket: "reports", Region: "eu-west-1"}
+	assert.Equal(t, "https://reports.s3.eu-west-1.amazonaws.com/kala/a%20b", s.url("kala/a b"))
+}
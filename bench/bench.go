@@ -0,0 +1,184 @@
+// Package bench measures how well the caches schedule many jobs: how late
+// their runs start, how far they drift from their schedules, and how much
+// memory every job takes, so that changes to the scheduler come with numbers.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/job/storage/memory"
+)
+
+// Caches which can be benchmarked.
+const (
+	LockFreeCache = "lockfree"
+	MemoryCache   = "memory"
+)
+
+var (
+	ErrInvalidCache    = errors.New("Invalid cache. Caches supported: lockfree and memory")
+	ErrInvalidInterval = errors.New("Invalid interval. The interval of the jobs is at least a second, in whole seconds")
+)
+
+// handlerName is the handler run by the synthetic jobs, which does nothing so
+// that the runs measure the scheduler alone.
+const handlerName = "kala-bench"
+
+var registerHandler sync.Once
+
+// Config is a benchmark: how many jobs run, how often and for how long.
+type Config struct {
+	Cache    string
+	Jobs     int
+	Interval time.Duration
+	Duration time.Duration
+}
+
+// Report is what a benchmark measured.
+type Report struct {
+	Cache string `json:"cache"`
+	Jobs  int    `json:"jobs"`
+	Runs  int    `json:"runs"`
+
+	// How long after they were due the runs started.
+	Latency Percentiles `json:"latency"`
+
+	// How far the runs drifted from the times of their schedules, as the
+	// jobs are rescheduled an interval after their previous run.
+	Drift Percentiles `json:"drift"`
+
+	// Heap allocated per scheduled job, in bytes.
+	MemoryPerJob uint64 `json:"memory_per_job"`
+}
+
+// Percentiles of durations.
+type Percentiles struct {
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+func percentiles(durations []time.Duration) Percentiles {
+	if len(durations) == 0 {
+		return Percentiles{}
+	}
+	sort.Slice(durations, func(i, k int) bool {
+		return durations[i] < durations[k]
+	})
+	at := func(p float64) time.Duration {
+		return durations[int(p*float64(len(durations)-1))]
+	}
+	return Percentiles{P50: at(0.5), P95: at(0.95), P99: at(0.99), Max: durations[len(durations)-1]}
+}
+
+// cache is what benchmarks need of the caches.
+type cache interface {
+	job.JobCache
+	Start(persistWaitTime time.Duration)
+	Drain(ctx context.Context) error
+}
+
+func newCache(name string) (cache, error) {
+	switch name {
+	case LockFreeCache:
+		return job.NewLockFreeJobCache(memory.New()), nil
+	case MemoryCache:
+		return job.NewMemoryJobCache(memory.New()), nil
+	}
+	return nil, ErrInvalidCache
+}
+
+// Run schedules the synthetic jobs in a new cache with an in-memory job
+// database, spreading their first runs over an interval, and measures their
+// runs until the duration is over.
+func Run(config Config) (*Report, error) {
+	if config.Interval < time.Second || config.Interval%time.Second != 0 {
+		return nil, ErrInvalidInterval
+	}
+	c, err := newCache(config.Cache)
+	if err != nil {
+		return nil, err
+	}
+	registerHandler.Do(func() {
+		job.RegisterHandler(handlerName, func(ctx context.Context, params map[string]string) error {
+			return nil
+		})
+	})
+
+	var lock sync.Mutex
+	latencies, drifts := []time.Duration{}, []time.Duration{}
+	firstRunAt := map[string]time.Time{}
+	runs := map[string]int{}
+	c.OnRunComplete(func(j *job.Job, stat *job.JobStat, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		latencies = append(latencies, stat.RanAt.Sub(stat.ScheduledAt))
+		if due, ok := firstRunAt[stat.JobId]; ok {
+			due = due.Add(time.Duration(runs[stat.JobId]) * config.Interval)
+			drifts = append(drifts, stat.RanAt.Sub(due))
+		}
+		runs[stat.JobId]++
+	})
+	c.Start(0)
+
+	interval := "PT" + strconv.Itoa(int(config.Interval/time.Second)) + "S"
+	start := time.Now().Add(time.Second)
+	before := heapAlloc()
+	for i := 0; i < config.Jobs; i++ {
+		runAt := start.Add(time.Duration(i) * config.Interval / time.Duration(config.Jobs))
+		j := &job.Job{
+			Name:              "bench-" + strconv.Itoa(i),
+			JobType:           job.HandlerJob,
+			HandlerProperties: job.HandlerProperties{Handler: handlerName},
+			Schedule:          fmt.Sprintf("R/%s/%s", runAt.Format(time.RFC3339Nano), interval),
+		}
+		if err := j.Init(c); err != nil {
+			return nil, err
+		}
+		lock.Lock()
+		firstRunAt[j.Id] = runAt
+		lock.Unlock()
+	}
+	report := &Report{Cache: config.Cache, Jobs: config.Jobs}
+	if config.Jobs > 0 {
+		if after := heapAlloc(); after > before {
+			report.MemoryPerJob = (after - before) / uint64(config.Jobs)
+		}
+	}
+
+	time.Sleep(time.Until(start.Add(config.Duration)))
+	ctx, cancel := context.WithTimeout(context.Background(), config.Interval)
+	defer cancel()
+	c.Drain(ctx)
+	all := c.GetAll()
+	all.Lock.RLock()
+	for _, j := range all.Jobs {
+		j.StopTimer()
+	}
+	all.Lock.RUnlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+	report.Runs = len(latencies)
+	report.Latency = percentiles(latencies)
+	report.Drift = percentiles(drifts)
+	return report, nil
+}
+
+// heapAlloc returns the bytes allocated on the heap after a garbage
+// collection.
+func heapAlloc() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
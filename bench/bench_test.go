@@ -0,0 +1,78 @@
+package bench
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/utils/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	// Logging every scheduled job would be measured too.
+	logging.Configure(logging.Config{Level: "warn"})
+}
+
+func TestRun(t *testing.T) {
+	for _, cache := range []string{LockFreeCache, MemoryCache} {
+		r, err := Run(Config{Cache: cache, Jobs: 20, Interval: time.Second, Duration: 2 * time.Second})
+		assert.NoError(t, err)
+		assert.Equal(t, cache, r.Cache)
+		// Every job runs about twice.
+		assert.InDelta(t, 40, r.Runs, 20, cache)
+		assert.True(t, r.Latency.P50 <= r.Latency.Max, cache)
+		assert.True(t, r.Latency.Max < time.Second, cache)
+	}
+
+	_, err := Run(Config{Cache: "sharded", Jobs: 1, Interval: time.Second})
+	assert.Equal(t, ErrInvalidCache, err)
+	_, err = Run(Config{Cache: LockFreeCache, Jobs: 1, Interval: 1500 * time.Millisecond})
+	assert.Equal(t, ErrInvalidInterval, err)
+}
+
+func TestPercentiles(t *testing.T) {
+	durations := []time.Duration{}
+	for i := 100; i > 0; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, Percentiles{
+		P50: 50 * time.Millisecond,
+		P95: 95 * time.Millisecond,
+		P99: 99 * time.Millisecond,
+		Max: 100 * time.Millisecond,
+	}, percentiles(durations))
+	assert.Equal(t, Percentiles{}, percentiles(nil))
+}
+
+// benchmarkSchedule measures scheduling jobs in the cache, which run in an
+// hour so that only scheduling them is measured.
+func benchmarkSchedule(b *testing.B, name string) {
+	c, err := newCache(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	schedule := fmt.Sprintf("R/%s/PT1H", time.Now().Add(time.Hour).Format(time.RFC3339))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		j := &job.Job{Name: "bench-" + strconv.Itoa(i), Command: "true", Schedule: schedule}
+		if err := j.Init(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	for _, j := range c.GetAll().Jobs {
+		j.StopTimer()
+	}
+}
+
+func BenchmarkLockFreeJobCacheSchedule(b *testing.B) {
+	benchmarkSchedule(b, LockFreeCache)
+}
+
+func BenchmarkMemoryJobCacheSchedule(b *testing.B) {
+	benchmarkSchedule(b, MemoryCache)
+}
@@ -16,12 +16,14 @@ import (
 const (
 	methodGet    = "GET"
 	methodPost   = "POST"
+	methodPut    = "PUT"
 	methodDelete = "DELETE"
 )
 
 var (
 	JobNotFound      = errors.New("Job not found")
 	JobCreationError = errors.New("Error creating job")
+	JobConflict      = errors.New("The job changed since it was read")
 
 	GenericError = errors.New("An error occured performing your request")
 
@@ -128,6 +130,32 @@ func (kc *KalaClient) GetJob(id string) (*job.Job, error) {
 	return j.Job, nil
 }
 
+// UpdateJob replaces the settings of a Job by those of body, which must have
+// the ResourceVersion of the Job they were read from, and returns the updated
+// Job. It returns JobConflict if the Job changed since.
+// Example:
+// 		c := New("http://127.0.0.1:8000")
+//		id := "93b65499-b211-49ce-57e0-19e735cc5abd"
+//		body, err := c.GetJob(id)
+//		body.Schedule = "R/2015-06-04T19:25:16.828696-07:00/PT1H"
+//		job, err := c.UpdateJob(id, body)
+func (kc *KalaClient) UpdateJob(id string, body *job.Job) (*job.Job, error) {
+	j := &api.JobResponse{}
+	status, err := kc.do(methodPut, kc.url(jobPath, id), http.StatusOK, body, j)
+	if err != nil {
+		switch {
+		case err != GenericError:
+			return nil, err
+		case status == http.StatusConflict:
+			return nil, JobConflict
+		case status == http.StatusNotFound:
+			return nil, JobNotFound
+		}
+		return nil, fmt.Errorf("Update failed with a status code of %d", status)
+	}
+	return j.Job, nil
+}
+
 // GetAllJobs returns a map of string (ID's) to job.Job's which contains
 // all Jobs currently within Kala.
 // Example:
@@ -190,6 +218,42 @@ func (kc *KalaClient) StartJob(id string) (bool, error) {
 	return true, nil
 }
 
+// DisableJob disables a Job, recording who disabled it and why, and returns
+// the disabled Job.
+// Example:
+// 		c := New("http://127.0.0.1:8000")
+//		id := "93b65499-b211-49ce-57e0-19e735cc5abd"
+//		job, err := c.DisableJob(id, "admin@example.com", "The API it calls is down")
+func (kc *KalaClient) DisableJob(id, by, reason string) (*job.Job, error) {
+	j := &api.JobResponse{}
+	body := &api.DisableJobRequest{By: by, Reason: reason}
+	_, err := kc.do(methodPost, kc.url(jobPath, id, "disable"), http.StatusOK, body, j)
+	if err != nil {
+		if err == GenericError {
+			return nil, JobNotFound
+		}
+		return nil, err
+	}
+	return j.Job, nil
+}
+
+// EnableJob enables a Job, and returns the enabled Job.
+// Example:
+// 		c := New("http://127.0.0.1:8000")
+//		id := "93b65499-b211-49ce-57e0-19e735cc5abd"
+//		job, err := c.EnableJob(id)
+func (kc *KalaClient) EnableJob(id string) (*job.Job, error) {
+	j := &api.JobResponse{}
+	_, err := kc.do(methodPost, kc.url(jobPath, id, "enable"), http.StatusOK, nil, j)
+	if err != nil {
+		if err == GenericError {
+			return nil, JobNotFound
+		}
+		return nil, err
+	}
+	return j.Job, nil
+}
+
 // GetKalaStats retrieves system-level metrics about Kala
 // Example:
 // 		c := New("http://127.0.0.1:8000")
@@ -199,3 +263,37 @@ func (kc *KalaClient) GetKalaStats() (*job.KalaStats, error) {
 	_, err := kc.do(methodGet, kc.url("stats"), http.StatusOK, nil, ks)
 	return ks.Stats, err
 }
+
+// GetOverview retrieves a summary of the whole scheduler: job totals,
+// upcoming runs and recent failures.
+// Example:
+// 		c := New("http://127.0.0.1:8000")
+//		overview, err := c.GetOverview()
+func (kc *KalaClient) GetOverview() (*job.Overview, error) {
+	o := &api.OverviewResponse{}
+	_, err := kc.do(methodGet, kc.url("overview"), http.StatusOK, nil, o)
+	return o.Overview, err
+}
+
+// GetGraph retrieves the graph of the jobs linked to a Job through parents,
+// children and on failure jobs, or of all jobs which depend on each other
+// if id is empty.
+// Example:
+// 		c := New("http://127.0.0.1:8000")
+//		id := "93b65499-b211-49ce-57e0-19e735cc5abd"
+//		graph, err := c.GetGraph(id)
+func (kc *KalaClient) GetGraph(id string) (*job.Graph, error) {
+	url := kc.url("graph")
+	if id != "" {
+		url = kc.url(jobPath, id, "graph")
+	}
+	g := &api.GraphResponse{}
+	_, err := kc.do(methodGet, url, http.StatusOK, nil, g)
+	if err != nil {
+		if err == GenericError {
+			return nil, JobNotFound
+		}
+		return nil, err
+	}
+	return g.Graph, nil
+}
@@ -151,6 +151,31 @@ func TestGetAllJobsNoJobsExist(t *testing.T) {
 	cleanUp()
 }
 
+func TestUpdateJob(t *testing.T) {
+	ts := NewTestServer()
+	defer ts.Close()
+	kc := New(ts.URL)
+
+	id, err := kc.CreateJob(NewJobMap())
+	assert.NoError(t, err)
+	read, err := kc.GetJob(id)
+	assert.NoError(t, err)
+
+	read.Command = "bash -c 'date -u'"
+	updated, err := kc.UpdateJob(id, read)
+	assert.NoError(t, err)
+	assert.Equal(t, read.Command, updated.Command)
+	assert.Equal(t, read.ResourceVersion+1, updated.ResourceVersion)
+
+	// The settings read before the update are stale.
+	_, err = kc.UpdateJob(id, read)
+	assert.Equal(t, JobConflict, err)
+	_, err = kc.UpdateJob("id-that-doesnt-exist", read)
+	assert.Equal(t, JobNotFound, err)
+
+	cleanUp()
+}
+
 func TestDeleteJob(t *testing.T) {
 	ts := NewTestServer()
 	defer ts.Close()
@@ -266,6 +291,32 @@ func TestStartJobError(t *testing.T) {
 	assert.False(t, ok)
 }
 
+func TestDisableEnableJob(t *testing.T) {
+	ts := NewTestServer()
+	defer ts.Close()
+	kc := New(ts.URL)
+
+	id, err := kc.CreateJob(NewJobMap())
+	assert.NoError(t, err)
+
+	respJob, err := kc.DisableJob(id, "admin@example.com", "Maintenance")
+	assert.NoError(t, err)
+	assert.True(t, respJob.Disabled)
+	assert.Equal(t, job.DisabledByUser, respJob.DisabledInfo.Source)
+	assert.Equal(t, "admin@example.com", respJob.DisabledInfo.By)
+	assert.Equal(t, "Maintenance", respJob.DisabledInfo.Reason)
+
+	respJob, err = kc.EnableJob(id)
+	assert.NoError(t, err)
+	assert.False(t, respJob.Disabled)
+	assert.Nil(t, respJob.DisabledInfo)
+
+	_, err = kc.DisableJob("not-an-actual-id", "", "")
+	assert.Equal(t, JobNotFound, err)
+
+	cleanUp()
+}
+
 func TestGetKalaStats(t *testing.T) {
 	ts := NewTestServer()
 	defer ts.Close()
@@ -297,3 +348,53 @@ func TestGetKalaStats(t *testing.T) {
 
 	cleanUp()
 }
+
+func TestGetOverview(t *testing.T) {
+	ts := NewTestServer()
+	defer ts.Close()
+	kc := New(ts.URL)
+
+	for i := 0; i < 3; i++ {
+		id, err := kc.CreateJob(NewJobMap())
+		assert.NoError(t, err)
+		assert.NotEqual(t, id, "")
+	}
+
+	overview, err := kc.GetOverview()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, overview.Jobs)
+	assert.Equal(t, 3, overview.ActiveJobs)
+	assert.Equal(t, 0, overview.FailingJobs)
+	assert.Len(t, overview.UpcomingRuns, 3)
+
+	cleanUp()
+}
+
+func TestGetGraph(t *testing.T) {
+	ts := NewTestServer()
+	defer ts.Close()
+	kc := New(ts.URL)
+
+	parentId, err := kc.CreateJob(NewJobMap())
+	assert.NoError(t, err)
+	child := NewJobMap()
+	child.Schedule = ""
+	child.ParentJobs = []string{parentId}
+	childId, err := kc.CreateJob(child)
+	assert.NoError(t, err)
+
+	graph, err := kc.GetGraph(childId)
+	assert.NoError(t, err)
+	assert.Len(t, graph.Nodes, 2)
+	assert.Equal(t, []*job.GraphEdge{{From: parentId, To: childId, Kind: job.DependentEdge}}, graph.Edges)
+
+	graph, err = kc.GetGraph("")
+	assert.NoError(t, err)
+	assert.Len(t, graph.Edges, 1)
+
+	_, err = kc.GetGraph("not-an-actual-id")
+	assert.Equal(t, JobNotFound, err)
+
+	cleanUp()
+}
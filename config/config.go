@@ -0,0 +1,311 @@
+// Package config reads the settings of Kala from a YAML config file and from
+// the environment, as values of its command line flags. Flags given on the
+// command line come first, then environment variables, then the file, then
+// the defaults of the flags.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultPath is the config file read if none is given, when it exists.
+const DefaultPath = "kala.yaml"
+
+// EnvPrefix is the prefix of the environment variables overriding flags, e.g.
+// KALA_SLACK_WEBHOOK for --slack-webhook.
+const EnvPrefix = "KALA_"
+
+// File is a config file. Its sections set flags by their names, e.g.
+//
+//	server:
+//	  port: 8000
+//	  cors-allowed-origin: [https://dashboard.example.com]
+//	backend:
+//	  jobDB: postgres
+//	  jobDBAddress: db.example.com:5432
+type File struct {
+	Path   string
+	values map[string]interface{}
+}
+
+// Load reads a config file, whose sections may only set the flags listed
+// for them. It returns every unknown section and flag at once.
+func Load(path string, sections map[string][]string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, b, sections)
+}
+
+// Parse parses the content of a config file, see Load.
+func Parse(path string, b []byte, sections map[string][]string) (*File, error) {
+	parsed := map[string]map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &parsed); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err)
+	}
+
+	f := &File{Path: path, values: map[string]interface{}{}}
+	errs := []string{}
+	for _, section := range sortedKeys(parsed) {
+		allowed, ok := sections[section]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("unknown section %q", section))
+			continue
+		}
+		for name, value := range parsed[section] {
+			if !contains(allowed, name) {
+				errs = append(errs, fmt.Sprintf("unknown setting %q in section %q", name, section))
+				continue
+			}
+			f.values[name] = value
+		}
+	}
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, strings.Join(errs, "; "))
+	}
+	return f, nil
+}
+
+// Settings reads the values of the flags of a command.
+type Settings struct {
+	ctx  *cli.Context
+	file *File
+}
+
+// NewSettings returns the settings of the command, with the values of the
+// config file, which may be nil.
+func NewSettings(ctx *cli.Context, file *File) *Settings {
+	return &Settings{ctx: ctx, file: file}
+}
+
+// Path returns the path of the config file, or "" if there is none.
+func (s *Settings) Path() string {
+	if s.file == nil {
+		return ""
+	}
+	return s.file.Path
+}
+
+// EnvVar returns the environment variable overriding the flag.
+func EnvVar(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// lookup returns the value of the flag set in the environment or in the
+// file, unless it's set on the command line.
+func (s *Settings) lookup(name string) (interface{}, bool) {
+	if s.ctx.IsSet(name) {
+		return nil, false
+	}
+	if value, ok := os.LookupEnv(EnvVar(name)); ok {
+		return value, true
+	}
+	if s.file != nil {
+		value, ok := s.file.values[name]
+		return value, ok
+	}
+	return nil, false
+}
+
+func (s *Settings) String(name string) string {
+	if value, ok := s.lookup(name); ok {
+		if v, err := toString(value); err == nil {
+			return v
+		}
+	}
+	return s.ctx.String(name)
+}
+
+func (s *Settings) Int(name string) int {
+	if value, ok := s.lookup(name); ok {
+		if v, err := toInt(value); err == nil {
+			return v
+		}
+	}
+	return s.ctx.Int(name)
+}
+
+func (s *Settings) Float64(name string) float64 {
+	if value, ok := s.lookup(name); ok {
+		if v, err := toFloat64(value); err == nil {
+			return v
+		}
+	}
+	return s.ctx.Float64(name)
+}
+
+func (s *Settings) Bool(name string) bool {
+	if value, ok := s.lookup(name); ok {
+		if v, err := toBool(value); err == nil {
+			return v
+		}
+	}
+	return s.ctx.Bool(name)
+}
+
+func (s *Settings) Duration(name string) time.Duration {
+	if value, ok := s.lookup(name); ok {
+		if v, err := toDuration(value); err == nil {
+			return v
+		}
+	}
+	return s.ctx.Duration(name)
+}
+
+// StringSlice returns the values of a flag given several times, which are
+// a list in the config file, and separated by commas in the environment.
+func (s *Settings) StringSlice(name string) []string {
+	if value, ok := s.lookup(name); ok {
+		if v, err := toStringSlice(value); err == nil {
+			return v
+		}
+	}
+	return s.ctx.StringSlice(name)
+}
+
+// Validate checks that the values of the flags set in the environment and in
+// the config file have the types of the flags, returning every invalid value
+// at once. The getters of Settings ignore invalid values.
+func (s *Settings) Validate() error {
+	errs := []string{}
+	for _, flag := range s.ctx.Command.Flags {
+		var name string
+		var convert func(interface{}) error
+		switch f := flag.(type) {
+		case cli.StringFlag:
+			name, convert = f.Name, func(v interface{}) error { _, err := toString(v); return err }
+		case cli.IntFlag:
+			name, convert = f.Name, func(v interface{}) error { _, err := toInt(v); return err }
+		case cli.Float64Flag:
+			name, convert = f.Name, func(v interface{}) error { _, err := toFloat64(v); return err }
+		case cli.BoolFlag:
+			name, convert = f.Name, func(v interface{}) error { _, err := toBool(v); return err }
+		case cli.DurationFlag:
+			name, convert = f.Name, func(v interface{}) error { _, err := toDuration(v); return err }
+		case cli.StringSliceFlag:
+			name, convert = f.Name, func(v interface{}) error { _, err := toStringSlice(v); return err }
+		default:
+			continue
+		}
+		// Flags have their short names after a comma, e.g. "port, p".
+		name = strings.TrimSpace(strings.Split(name, ",")[0])
+		value, ok := s.lookup(name)
+		if !ok {
+			continue
+		}
+		if err := convert(value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("Invalid settings: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func toString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int, float64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("should be a string, got %v", value)
+}
+
+func toInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("should be an integer, got %v", value)
+}
+
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("should be a number, got %v", value)
+}
+
+func toBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
+		}
+	}
+	return false, fmt.Errorf("should be true or false, got %v", value)
+}
+
+func toDuration(value interface{}) (time.Duration, error) {
+	if v, ok := value.(string); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("should be a duration such as 30s or 24h, got %v", value)
+}
+
+func toStringSlice(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return []string{}, nil
+		}
+		return strings.Split(v, ","), nil
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			s, err := toString(item)
+			if err != nil {
+				return nil, fmt.Errorf("should be a list of strings, got %v", value)
+			}
+			values[i] = s
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("should be a list of strings, got %v", value)
+}
+
+func sortedKeys(m map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/cli"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSections = map[string][]string{
+	"server":  {"port", "cors-allowed-origin", "lazy-load", "shutdown-grace-period"},
+	"backend": {"jobDB"},
+}
+
+var testFlags = []cli.Flag{
+	cli.IntFlag{Name: "port, p", Value: 8000},
+	cli.StringSliceFlag{Name: "cors-allowed-origin", Value: &cli.StringSlice{}},
+	cli.BoolFlag{Name: "lazy-load"},
+	cli.DurationFlag{Name: "shutdown-grace-period", Value: 30 * time.Second},
+	cli.StringFlag{Name: "jobDB", Value: "boltdb"},
+}
+
+func testSettings(t *testing.T, file *File, args ...string) *Settings {
+	set := flag.NewFlagSet("run", flag.ContinueOnError)
+	for _, f := range testFlags {
+		f.Apply(set)
+	}
+	assert.NoError(t, set.Parse(args))
+	ctx := cli.NewContext(cli.NewApp(), set, nil)
+	ctx.Command = cli.Command{Name: "run", Flags: testFlags}
+	return NewSettings(ctx, file)
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse("kala.yaml", []byte(`
+server:
+  port: 9000
+  cors-allowed-origin: [https://a.example.com, https://b.example.com]
+  lazy-load: true
+backend:
+  jobDB: postgres
+`), testSections)
+	assert.NoError(t, err)
+
+	s := testSettings(t, f)
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, "kala.yaml", s.Path())
+	assert.Equal(t, 9000, s.Int("port"))
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, s.StringSlice("cors-allowed-origin"))
+	assert.True(t, s.Bool("lazy-load"))
+	assert.Equal(t, "postgres", s.String("jobDB"))
+	assert.Equal(t, 30*time.Second, s.Duration("shutdown-grace-period"))
+
+	_, err = Parse("kala.yaml", []byte("auth:\n  token: secret\nserver:\n  prot: 9000\n"), testSections)
+	assert.EqualError(t, err, `Invalid config file kala.yaml: unknown section "auth"; unknown setting "prot" in section "server"`)
+
+	_, err = Parse("kala.yaml", []byte("server: [port]\n"), testSections)
+	assert.Error(t, err)
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	f, err := Parse("kala.yaml", []byte("server:\n  port: 9000\n  shutdown-grace-period: 1m\n"), testSections)
+	assert.NoError(t, err)
+
+	os.Setenv("KALA_SHUTDOWN_GRACE_PERIOD", "2m")
+	os.Setenv("KALA_PORT", "9001")
+	defer os.Unsetenv("KALA_SHUTDOWN_GRACE_PERIOD")
+	defer os.Unsetenv("KALA_PORT")
+
+	s := testSettings(t, f, "--port", "9002")
+	assert.NoError(t, s.Validate())
+	assert.Equal(t, 9002, s.Int("port"))
+	assert.Equal(t, 2*time.Minute, s.Duration("shutdown-grace-period"))
+
+	s = testSettings(t, f)
+	assert.Equal(t, 9001, s.Int("port"))
+
+	os.Setenv("KALA_PORT", "ninety")
+	s = testSettings(t, nil)
+	assert.EqualError(t, s.Validate(), "Invalid settings: port: should be an integer, got ninety")
+	assert.Equal(t, 8000, s.Int("port"))
+}
+
+func TestEnvVar(t *testing.T) {
+	assert.Equal(t, "KALA_SLACK_WEBHOOK", EnvVar("slack-webhook"))
+	assert.Equal(t, "KALA_JOBDBADDRESS", EnvVar("jobDBAddress"))
+}
@@ -0,0 +1,344 @@
+// Package cronjob converts Kubernetes CronJob manifests to Kala jobs and back,
+// to migrate jobs between Kubernetes and Kala in either direction.
+//
+// The container of a CronJob becomes a local job running it with docker run,
+// and its cron schedule an ISO 8601 schedule repeating at the same interval.
+// Only cron schedules repeating at a fixed interval can be converted, e.g.
+// every 15 minutes or every day at 02:00, but not every weekday.
+package cronjob
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/job"
+
+	"github.com/mattn/go-shellwords"
+	"gopkg.in/yaml.v2"
+)
+
+// Annotations of exported CronJobs, read back when they're imported.
+const (
+	IdAnnotation    = "kala/id"
+	OwnerAnnotation = "kala/owner"
+)
+
+// DefaultImage is the image running the commands of exported jobs which don't
+// run a container with docker run.
+const DefaultImage = "busybox"
+
+var (
+	ErrNoCronJobs = errors.New("The manifest has no CronJob")
+	ErrNotLocal   = errors.New("Only local jobs can be exported as CronJobs")
+)
+
+// CronJob is a Kubernetes CronJob, with the fields Kala converts.
+type CronJob struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   ObjectMeta  `yaml:"metadata"`
+	Spec       CronJobSpec `yaml:"spec"`
+}
+
+type ObjectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type CronJobSpec struct {
+	Schedule    string      `yaml:"schedule"`
+	TimeZone    string      `yaml:"timeZone,omitempty"`
+	Suspend     bool        `yaml:"suspend,omitempty"`
+	JobTemplate JobTemplate `yaml:"jobTemplate"`
+}
+
+type JobTemplate struct {
+	Spec JobSpec `yaml:"spec"`
+}
+
+type JobSpec struct {
+	BackoffLimit *int        `yaml:"backoffLimit,omitempty"`
+	Template     PodTemplate `yaml:"template"`
+}
+
+type PodTemplate struct {
+	Spec PodSpec `yaml:"spec"`
+}
+
+type PodSpec struct {
+	Containers    []Container `yaml:"containers"`
+	RestartPolicy string      `yaml:"restartPolicy,omitempty"`
+}
+
+type Container struct {
+	Name    string   `yaml:"name"`
+	Image   string   `yaml:"image"`
+	Command []string `yaml:"command,omitempty"`
+	Args    []string `yaml:"args,omitempty"`
+	Env     []EnvVar `yaml:"env,omitempty"`
+}
+
+type EnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+	// Secrets and config maps can't be converted.
+	ValueFrom interface{} `yaml:"valueFrom,omitempty"`
+}
+
+// manifest is a document of a manifest, a CronJob or a List of them.
+type manifest struct {
+	CronJob `yaml:",inline"`
+	Items   []CronJob `yaml:"items"`
+}
+
+// Parse returns the CronJobs of a manifest of YAML documents, which may be
+// Lists. Documents of other kinds are skipped.
+func Parse(r io.Reader) ([]*CronJob, error) {
+	cronJobs := []*CronJob{}
+	dec := yaml.NewDecoder(r)
+	for {
+		m := manifest{}
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid manifest: %s", err)
+		}
+		items := []CronJob{m.CronJob}
+		if m.Kind == "List" {
+			items = m.Items
+		}
+		for i := range items {
+			if items[i].Kind == "CronJob" {
+				cronJobs = append(cronJobs, &items[i])
+			}
+		}
+	}
+	if len(cronJobs) == 0 {
+		return nil, ErrNoCronJobs
+	}
+	return cronJobs, nil
+}
+
+// Import converts the CronJobs of a manifest to jobs, scheduled from now.
+func Import(r io.Reader, now time.Time) ([]*job.Job, error) {
+	cronJobs, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*job.Job, len(cronJobs))
+	for i, c := range cronJobs {
+		jobs[i], err = c.Job(now)
+		if err != nil {
+			return nil, fmt.Errorf("CronJob %s: %s", c.Metadata.Name, err)
+		}
+	}
+	return jobs, nil
+}
+
+// Job converts the CronJob to a local job running its container with docker
+// run, scheduled from now.
+func (c *CronJob) Job(now time.Time) (*job.Job, error) {
+	containers := c.Spec.JobTemplate.Spec.Template.Spec.Containers
+	if len(containers) != 1 {
+		return nil, fmt.Errorf("Only CronJobs of a single container can be converted, got %d", len(containers))
+	}
+	command, err := dockerRun(&containers[0])
+	if err != nil {
+		return nil, err
+	}
+
+	loc := time.UTC
+	if c.Spec.TimeZone != "" {
+		if loc, err = time.LoadLocation(c.Spec.TimeZone); err != nil {
+			return nil, fmt.Errorf("Unknown time zone %s", c.Spec.TimeZone)
+		}
+	}
+	schedule, err := ToISO8601(c.Spec.Schedule, now.In(loc))
+	if err != nil {
+		return nil, err
+	}
+
+	j := &job.Job{
+		Name:     c.Metadata.Name,
+		Owner:    c.Metadata.Annotations[OwnerAnnotation],
+		Command:  command,
+		Schedule: schedule,
+		Disabled: c.Spec.Suspend,
+	}
+	if limit := c.Spec.JobTemplate.Spec.BackoffLimit; limit != nil && *limit > 0 {
+		j.Retries = uint(*limit)
+	}
+	return j, nil
+}
+
+// dockerRun returns the docker run command running the container.
+func dockerRun(c *Container) (string, error) {
+	if c.Image == "" {
+		return "", errors.New("The container has no image")
+	}
+	args := []string{"docker", "run", "--rm"}
+	for _, env := range c.Env {
+		if env.ValueFrom != nil {
+			return "", fmt.Errorf("The environment variable %s comes from a secret or config map, which can't be converted", env.Name)
+		}
+		args = append(args, "-e", env.Name+"="+env.Value)
+	}
+	command := c.Command
+	if len(command) != 0 {
+		args = append(args, "--entrypoint", command[0])
+		command = command[1:]
+	}
+	args = append(args, c.Image)
+	args = append(args, command...)
+	args = append(args, c.Args...)
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+var shellSafe = regexp.MustCompile(`^[-_./:=@%+,A-Za-z0-9]+$`)
+
+// shellQuote quotes the argument for the command of a local job, if needed.
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'"'"'`, -1) + "'"
+}
+
+// Export converts jobs to a manifest of CronJobs, separated by ---. Jobs
+// which can't be converted, e.g. remote jobs, are skipped with a comment
+// saying why. Jobs which don't run a container with docker run run their
+// command with sh in a container of image, DefaultImage if it's empty.
+func Export(jobs []*job.Job, image string) ([]byte, error) {
+	var buf bytes.Buffer
+	exported := 0
+	for _, j := range jobs {
+		c, err := FromJob(j, image)
+		if err != nil {
+			fmt.Fprintf(&buf, "# Job %s (%s) isn't exported: %s\n", j.Name, j.Id, err)
+			continue
+		}
+		out, err := yaml.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		if exported > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(out)
+		exported++
+	}
+	return buf.Bytes(), nil
+}
+
+// FromJob converts a local job to a CronJob, see Export. The lock of the job
+// mustn't be held.
+func FromJob(j *job.Job, image string) (*CronJob, error) {
+	j = j.Copy()
+	if j.JobType != job.LocalJob {
+		return nil, ErrNotLocal
+	}
+	schedule, err := ToCron(j.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	if image == "" {
+		image = DefaultImage
+	}
+	name := objectName(j.Name)
+	container, ok := parseDockerRun(j.Command)
+	if !ok {
+		container = &Container{Image: image, Command: []string{"sh", "-c", j.Command}}
+	}
+	container.Name = name
+
+	c := &CronJob{
+		APIVersion: "batch/v1",
+		Kind:       "CronJob",
+		Metadata: ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{IdAnnotation: j.Id},
+		},
+		Spec: CronJobSpec{
+			Schedule: schedule,
+			Suspend:  j.Disabled,
+		},
+	}
+	if j.Owner != "" {
+		c.Metadata.Annotations[OwnerAnnotation] = j.Owner
+	}
+	retries := int(j.Retries)
+	c.Spec.JobTemplate.Spec.BackoffLimit = &retries
+	c.Spec.JobTemplate.Spec.Template.Spec = PodSpec{
+		Containers:    []Container{*container},
+		RestartPolicy: "Never",
+	}
+	return c, nil
+}
+
+// parseDockerRun returns the container of a command made by dockerRun, or
+// false if it runs something else.
+func parseDockerRun(command string) (*Container, bool) {
+	args, err := shellwords.NewParser().Parse(command)
+	if err != nil || len(args) < 4 || args[0] != "docker" || args[1] != "run" || args[2] != "--rm" {
+		return nil, false
+	}
+	c := &Container{}
+	i := 3
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i += 2 {
+		if i+1 == len(args) {
+			return nil, false
+		}
+		switch args[i] {
+		case "-e":
+			kv := strings.SplitN(args[i+1], "=", 2)
+			if len(kv) != 2 {
+				return nil, false
+			}
+			c.Env = append(c.Env, EnvVar{Name: kv[0], Value: kv[1]})
+		case "--entrypoint":
+			c.Command = []string{args[i+1]}
+		default:
+			return nil, false
+		}
+	}
+	if i == len(args) {
+		return nil, false
+	}
+	c.Image = args[i]
+	if rest := args[i+1:]; len(rest) != 0 {
+		if c.Command != nil {
+			c.Command = append(c.Command, rest...)
+		} else {
+			c.Args = rest
+		}
+	}
+	return c, true
+}
+
+var nameInvalid = regexp.MustCompile(`[^a-z0-9]+`)
+
+// objectName returns a name of a Kubernetes object for the name of a job:
+// lowercase letters, digits and dashes, short enough for the names of the
+// jobs of the CronJob.
+func objectName(name string) string {
+	s := strings.Trim(nameInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(s) > 52 {
+		s = strings.TrimRight(s[:52], "-")
+	}
+	if s == "" {
+		s = "kala-job"
+	}
+	return s
+}
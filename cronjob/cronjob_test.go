@@ -0,0 +1,189 @@
+package cronjob
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/job"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A Wednesday.
+var now = time.Date(2017, 6, 7, 10, 17, 30, 0, time.UTC)
+
+func TestToISO8601(t *testing.T) {
+	for cron, schedule := range map[string]string{
+		"*/15 * * * *": "R/2017-06-07T10:30:00Z/PT15M",
+		"* * * * *":    "R/2017-06-07T10:18:00Z/PT1M",
+		"5 * * * *":    "R/2017-06-07T11:05:00Z/PT1H",
+		"0 */6 * * *":  "R/2017-06-07T12:00:00Z/PT6H",
+		"30 2 * * *":   "R/2017-06-08T02:30:00Z/P1D",
+		"@daily":       "R/2017-06-08T00:00:00Z/P1D",
+		"0 9 * * 1":    "R/2017-06-12T09:00:00Z/P1W",
+		"0 12 * * 3":   "R/2017-06-07T12:00:00Z/P1W",
+		"0 0 5 * *":    "R/2017-07-05T00:00:00Z/P1M",
+		"0 0 1 1 *":    "R/2018-01-01T00:00:00Z/P1Y",
+	} {
+		s, err := ToISO8601(cron, now)
+		assert.NoError(t, err, cron)
+		assert.Equal(t, schedule, s, cron)
+	}
+
+	for _, cron := range []string{"0 9 * * 1-5", "*/7 * * * *", "0 0 31 * *", "0 0 1 * 1", "0 0 */2 * *", "0 0 * *"} {
+		_, err := ToISO8601(cron, now)
+		assert.Error(t, err, cron)
+	}
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	s, err := ToISO8601("30 2 * * *", now.In(berlin))
+	assert.NoError(t, err)
+	assert.Equal(t, "R/2017-06-08T02:30:00+02:00/P1D", s)
+}
+
+func TestToCron(t *testing.T) {
+	for schedule, cron := range map[string]string{
+		"R/2017-06-07T10:30:00Z/PT15M":      "*/15 * * * *",
+		"R/2017-06-07T10:05:00Z/PT15M":      "5-59/15 * * * *",
+		"R/2017-06-07T11:05:00Z/PT1H":       "5 * * * *",
+		"R/2017-06-07T13:00:00Z/PT6H":       "0 1-23/6 * * *",
+		"R/2017-06-08T02:30:00+02:00/P1D":   "30 0 * * *",
+		"R/2017-06-12T09:00:00Z/P1W":        "0 9 * * 1",
+		"R/2017-07-05T00:00:00Z/P1M":        "0 0 5 * *",
+		"R/2018-01-01T00:00:00Z/P1Y":        "0 0 1 1 *",
+		"R/2017-06-07T10:30:00Z/PT1M":       "* * * * *",
+		"R/2017-06-07T10:30:00-07:00/PT30M": "*/30 * * * *",
+	} {
+		c, err := ToCron(schedule)
+		assert.NoError(t, err, schedule)
+		assert.Equal(t, cron, c, schedule)
+	}
+
+	for _, schedule := range []string{"", "R5/2017-06-07T10:30:00Z/PT15M", "R/2017-06-07T10:30:00Z/PT7M", "R/2017-06-07T10:30:00Z/P1DT1H", "R/2017-06-29T10:30:00Z/P1M"} {
+		_, err := ToCron(schedule)
+		assert.Error(t, err, schedule)
+	}
+}
+
+const testManifest = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: nightly-report
+  annotations:
+    kala/owner: data@example.com
+spec:
+  schedule: "30 2 * * *"
+  suspend: true
+  jobTemplate:
+    spec:
+      backoffLimit: 2
+      template:
+        spec:
+          restartPolicy: OnFailure
+          containers:
+          - name: report
+            image: example/report:1.2
+            command: ["python", "report.py"]
+            args: ["--since", "1 day"]
+            env:
+            - name: MODE
+              value: full
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: batch/v1
+  kind: CronJob
+  metadata:
+    name: cleanup
+  spec:
+    schedule: "@hourly"
+    jobTemplate:
+      spec:
+        template:
+          spec:
+            containers:
+            - name: cleanup
+              image: busybox
+              args: ["rm", "-rf", "/tmp/cache"]
+`
+
+func TestImport(t *testing.T) {
+	jobs, err := Import(strings.NewReader(testManifest), now)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 2) {
+		j := jobs[0]
+		assert.Equal(t, "nightly-report", j.Name)
+		assert.Equal(t, "data@example.com", j.Owner)
+		assert.Equal(t, "docker run --rm -e MODE=full --entrypoint python example/report:1.2 report.py --since '1 day'", j.Command)
+		assert.Equal(t, "R/2017-06-08T02:30:00Z/P1D", j.Schedule)
+		assert.True(t, j.Disabled)
+		assert.Equal(t, uint(2), j.Retries)
+
+		assert.Equal(t, "docker run --rm busybox rm -rf /tmp/cache", jobs[1].Command)
+		assert.Equal(t, "R/2017-06-07T11:00:00Z/PT1H", jobs[1].Schedule)
+	}
+
+	_, err = Import(strings.NewReader("kind: ConfigMap\n"), now)
+	assert.Equal(t, ErrNoCronJobs, err)
+
+	secret := strings.Replace(testManifest, "value: full", "valueFrom: {secretKeyRef: {name: report, key: mode}}", 1)
+	_, err = Import(strings.NewReader(secret), now)
+	assert.Error(t, err)
+}
+
+func TestExport(t *testing.T) {
+	jobs, err := Import(strings.NewReader(testManifest), now)
+	assert.NoError(t, err)
+	jobs[0].Id = "93b65499-b211-49ce-57e0-19e735cc5abd"
+
+	shell := job.GetMockJob()
+	shell.Name = "Backup DB!"
+	shell.Command = "bash -c 'pg_dump db > /backups/db.sql'"
+	shell.Schedule = "R/2017-06-07T03:00:00Z/P1D"
+	jobs = append(jobs, shell)
+
+	out, err := Export(jobs, "")
+	assert.NoError(t, err)
+
+	// Exported CronJobs are imported back to the same jobs.
+	cronJobs, err := Parse(strings.NewReader(string(out)))
+	assert.NoError(t, err)
+	if assert.Len(t, cronJobs, 3) {
+		assert.Equal(t, "93b65499-b211-49ce-57e0-19e735cc5abd", cronJobs[0].Metadata.Annotations[IdAnnotation])
+		assert.Equal(t, "30 2 * * *", cronJobs[0].Spec.Schedule)
+		assert.True(t, cronJobs[0].Spec.Suspend)
+		assert.Equal(t, "Never", cronJobs[0].Spec.JobTemplate.Spec.Template.Spec.RestartPolicy)
+
+		assert.Equal(t, "backup-db", cronJobs[2].Metadata.Name)
+		assert.Equal(t, Container{Name: "backup-db", Image: DefaultImage, Command: []string{"sh", "-c", shell.Command}},
+			cronJobs[2].Spec.JobTemplate.Spec.Template.Spec.Containers[0])
+	}
+	imported, err := Import(strings.NewReader(string(out)), now)
+	assert.NoError(t, err)
+	if assert.Len(t, imported, 3) {
+		for i := range jobs[:2] {
+			assert.Equal(t, jobs[i].Command, imported[i].Command)
+			assert.Equal(t, jobs[i].Schedule, imported[i].Schedule)
+			assert.Equal(t, jobs[i].Owner, imported[i].Owner)
+			assert.Equal(t, jobs[i].Retries, imported[i].Retries)
+		}
+	}
+
+	remote := job.GetMockRemoteJob(job.RemoteProperties{Url: "http://example.com"})
+	remote.Id = "remote"
+	remote.Schedule = "R/2017-06-07T03:00:00Z/P1D"
+	out, err = Export([]*job.Job{remote, shell}, "")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), "# Job mock_remote_job (remote) isn't exported: "+ErrNotLocal.Error()+"\n"))
+	cronJobs, err = Parse(strings.NewReader(string(out)))
+	assert.NoError(t, err)
+	assert.Len(t, cronJobs, 1)
+}
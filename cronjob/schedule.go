@@ -0,0 +1,174 @@
+package cronjob
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/utils/iso8601"
+)
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField is a field of a cron schedule: *, */step or a value.
+type cronField struct {
+	any   bool
+	step  int
+	value int
+}
+
+func parseCronField(s string, min, max int) (cronField, bool) {
+	if s == "*" {
+		return cronField{any: true, step: 1}, true
+	}
+	if strings.HasPrefix(s, "*/") {
+		step, err := strconv.Atoi(s[2:])
+		return cronField{any: true, step: step}, err == nil && step > 0
+	}
+	value, err := strconv.Atoi(s)
+	return cronField{value: value}, err == nil && value >= min && value <= max
+}
+
+// ToISO8601 converts a cron schedule to an ISO 8601 schedule repeating at the
+// same interval, starting at its first time after now, in the location of
+// now. Only schedules repeating at a fixed interval can be converted: every n
+// minutes or hours dividing an hour or a day, or every hour, day, week, month
+// (on days 1 to 28) or year at a fixed time.
+func ToISO8601(schedule string, now time.Time) (string, error) {
+	unsupported := fmt.Errorf("The schedule %q doesn't repeat at a fixed interval, it can't be converted", schedule)
+	if macro, ok := cronMacros[schedule]; ok {
+		schedule = macro
+	}
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return "", fmt.Errorf("Invalid cron schedule %q", schedule)
+	}
+	minute, ok1 := parseCronField(fields[0], 0, 59)
+	hour, ok2 := parseCronField(fields[1], 0, 23)
+	dom, ok3 := parseCronField(fields[2], 1, 28)
+	month, ok4 := parseCronField(fields[3], 1, 12)
+	dow, ok5 := parseCronField(fields[4], 0, 7)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return "", unsupported
+	}
+
+	if dom.any && dom.step != 1 || month.any && month.step != 1 || dow.any && dow.step != 1 {
+		return "", unsupported
+	}
+	daily := dom.any && month.any && dow.any
+
+	now = now.Truncate(time.Minute)
+	y, mo, d := now.Date()
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(y, month, day, hour, minute, 0, 0, now.Location())
+	}
+	var start time.Time
+	var interval string
+	var next func(t time.Time) time.Time
+	switch {
+	case minute.any:
+		if !hour.any || hour.step != 1 || !daily || 60%minute.step != 0 {
+			return "", unsupported
+		}
+		start = at(mo, d, now.Hour(), now.Minute()/minute.step*minute.step)
+		interval = fmt.Sprintf("PT%dM", minute.step)
+		next = func(t time.Time) time.Time { return t.Add(time.Duration(minute.step) * time.Minute) }
+	case hour.any:
+		if !daily || 24%hour.step != 0 {
+			return "", unsupported
+		}
+		start = at(mo, d, now.Hour()/hour.step*hour.step, minute.value)
+		interval = fmt.Sprintf("PT%dH", hour.step)
+		next = func(t time.Time) time.Time { return t.Add(time.Duration(hour.step) * time.Hour) }
+	case daily:
+		start = at(mo, d, hour.value, minute.value)
+		interval = "P1D"
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case dom.any && month.any:
+		start = at(mo, d, hour.value, minute.value)
+		start = start.AddDate(0, 0, (dow.value%7-int(start.Weekday())+7)%7)
+		interval = "P1W"
+		next = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case month.any && dow.any:
+		start = at(mo, dom.value, hour.value, minute.value)
+		interval = "P1M"
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	case dow.any:
+		start = at(time.Month(month.value), dom.value, hour.value, minute.value)
+		interval = "P1Y"
+		next = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	default:
+		return "", unsupported
+	}
+	for !start.After(now) {
+		start = next(start)
+	}
+	return "R/" + start.Format(time.RFC3339) + "/" + interval, nil
+}
+
+// ToCron converts an ISO 8601 schedule repeating forever to a cron schedule
+// in UTC, to the minute. Only intervals of a single unit which cron can
+// express can be converted: minutes or hours dividing an hour or a day, or a
+// day, week, month or year.
+func ToCron(schedule string) (string, error) {
+	parts := strings.Split(schedule, "/")
+	if len(parts) != 3 || parts[0] != "R" {
+		return "", fmt.Errorf("Only schedules repeating forever can be converted, got %q", schedule)
+	}
+	start, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		return "", fmt.Errorf("Invalid start of schedule %q", schedule)
+	}
+	start = start.UTC()
+	d, err := iso8601.FromString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("Invalid interval of schedule %q", schedule)
+	}
+	unsupported := fmt.Errorf("The interval %s can't be expressed in cron", parts[2])
+
+	units := 0
+	for _, n := range []int{d.Years, d.Months, d.Weeks, d.Days, d.Hours, d.Minutes, d.Seconds} {
+		if n != 0 {
+			units++
+		}
+	}
+	if units != 1 {
+		return "", unsupported
+	}
+	m, h := start.Minute(), start.Hour()
+	switch {
+	case d.Minutes != 0 && 60%d.Minutes == 0:
+		return fmt.Sprintf("%s * * * *", cronStep(m, d.Minutes, 59)), nil
+	case d.Hours != 0 && 24%d.Hours == 0:
+		return fmt.Sprintf("%d %s * * *", m, cronStep(h, d.Hours, 23)), nil
+	case d.Days == 1:
+		return fmt.Sprintf("%d %d * * *", m, h), nil
+	case d.Weeks == 1 || d.Days == 7:
+		return fmt.Sprintf("%d %d * * %d", m, h, start.Weekday()), nil
+	case d.Months == 1 && start.Day() <= 28:
+		return fmt.Sprintf("%d %d %d * *", m, h, start.Day()), nil
+	case d.Years == 1:
+		return fmt.Sprintf("%d %d %d %d *", m, h, start.Day(), start.Month()), nil
+	}
+	return "", unsupported
+}
+
+// cronStep returns the cron field of every step from the value, up to max.
+func cronStep(value, step, max int) string {
+	switch {
+	case step == 1:
+		return "*"
+	case value%step == 0:
+		return fmt.Sprintf("*/%d", step)
+	}
+	return fmt.Sprintf("%d-%d/%d", value%step, max, step)
+}
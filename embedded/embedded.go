@@ -0,0 +1,235 @@
+// Package embedded runs the scheduler in-process, so that Go applications embed
+// it rather than shelling out to a separate Kala:
+//
+//	server, err := embedded.New(embedded.Config{Addr: "127.0.0.1:8000"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := server.Start(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//	defer server.Stop()
+//	id, err := server.AddJob(&job.Job{Name: "cleanup", Command: "rm -rf /tmp/cache", Schedule: "R/2017-06-04T19:25:16Z/PT1H"})
+package embedded
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/api"
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/job/storage/memory"
+	"github.com/ajvb/kala/lifecycle"
+	"github.com/ajvb/kala/utils/logging"
+	"github.com/ajvb/kala/validation"
+)
+
+var log = logging.GetLogger(logging.Lifecycle)
+
+var (
+	ErrStarted    = errors.New("The server is already started")
+	ErrNotStarted = errors.New("The server isn't started")
+)
+
+const (
+	// DefaultPersistEvery is how often the jobs are persisted, like the
+	// --persist-every flag of kala run.
+	DefaultPersistEvery = 5 * time.Second
+
+	// DefaultShutdownGracePeriod is how long running jobs may take to finish
+	// when the server stops, like the --shutdown-grace-period flag.
+	DefaultShutdownGracePeriod = 30 * time.Second
+)
+
+// Config are the settings of an embedded server.
+type Config struct {
+	// Address the API is served on, e.g. 127.0.0.1:8000, or :0 for any
+	// free port. The API isn't served if it's empty.
+	Addr string
+
+	// Database the jobs are persisted to, an in-memory database if nil. The
+	// server closes it when it stops.
+	DB job.JobDB
+
+	// Owner of the jobs created without one.
+	DefaultOwner string
+
+	// Zero values use DefaultPersistEvery and DefaultShutdownGracePeriod.
+	PersistEvery        time.Duration
+	ShutdownGracePeriod time.Duration
+}
+
+// Server is an embedded Kala: the cache running the jobs, the database they're
+// persisted to, and optionally the API.
+type Server struct {
+	config Config
+	db     job.JobDB
+	cache  *job.LockFreeJobCache
+	api    *api.Server
+
+	lock     sync.Mutex
+	listener net.Listener
+	shutdown *lifecycle.Manager
+}
+
+// New returns a server with the config, which runs jobs once it's started.
+func New(config Config) (*Server, error) {
+	if config.PersistEvery < 0 || config.ShutdownGracePeriod < 0 {
+		return nil, errors.New("Invalid config. The persist interval and shutdown grace period can't be negative")
+	}
+	if config.PersistEvery == 0 {
+		config.PersistEvery = DefaultPersistEvery
+	}
+	if config.ShutdownGracePeriod == 0 {
+		config.ShutdownGracePeriod = DefaultShutdownGracePeriod
+	}
+	db := config.DB
+	if db == nil {
+		db = memory.New()
+	}
+
+	s := &Server{
+		config: config,
+		db:     db,
+		cache:  job.NewLockFreeJobCache(db),
+	}
+	if config.Addr != "" {
+		s.api = api.NewServer(config.Addr, s.cache, db, config.DefaultOwner)
+	}
+	return s, nil
+}
+
+// Start loads the jobs from the database, runs them, and serves the API if
+// the server has an address. The server stops once ctx is done, or when Stop
+// is called.
+func (s *Server) Start(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.shutdown != nil {
+		return ErrStarted
+	}
+
+	if s.api != nil {
+		l, err := net.Listen("tcp", s.config.Addr)
+		if err != nil {
+			return err
+		}
+		s.listener = l
+		go func() {
+			if err := s.api.Serve(l); err != nil && err != http.ErrServerClosed {
+				log.Errorf("Error occured serving the API: %s", err)
+			}
+		}()
+	}
+	s.cache.Start(s.config.PersistEvery)
+
+	shutdown := lifecycle.New()
+	if s.api != nil {
+		shutdown.Add("stop accepting changes to jobs", 0, s.api.Drain)
+	}
+	shutdown.Add("wait for running jobs", s.config.ShutdownGracePeriod, s.cache.Drain)
+	shutdown.Add("persist jobs", job.ShutdownPersistTimeout, s.cache.Flush)
+	if s.api != nil {
+		shutdown.Add("stop the API server", 5*time.Second, s.api.Shutdown)
+	}
+	shutdown.Add("close the job database", 0, func(ctx context.Context) error {
+		return s.db.Close()
+	})
+	s.shutdown = shutdown
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdown.Shutdown()
+		case <-shutdown.Done():
+		}
+	}()
+	return nil
+}
+
+// Stop stops serving the API, waits for the running jobs, persists the jobs
+// and closes the database. It returns the error of the first step which
+// failed.
+func (s *Server) Stop() error {
+	s.lock.Lock()
+	shutdown := s.shutdown
+	s.lock.Unlock()
+	if shutdown == nil {
+		return ErrNotStarted
+	}
+	return shutdown.Shutdown()
+}
+
+// Addr returns the address the API is served on, e.g. to find the port
+// picked for :0, or nil until the server is started with an address.
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// AddJob validates the job, and creates it like the API does, returning its
+// id. A job without a schedule is run at once.
+func (s *Server) AddJob(j *job.Job) (string, error) {
+	if s.config.DefaultOwner != "" && j.Owner == "" {
+		j.Owner = s.config.DefaultOwner
+	}
+	if err := validation.Job(j, s.cache); err != nil {
+		return "", err
+	}
+	if err := job.GetNamespaces().CheckQuota(s.cache, j); err != nil {
+		return "", err
+	}
+	if err := j.Init(s.cache); err != nil {
+		return "", err
+	}
+	return j.Id, nil
+}
+
+// RegisterHandler makes a Go function available by name to the handler jobs
+// of the servers in the process, see job.HandlerProperties. Handlers are
+// registered before the servers start, so that the jobs calling them run once
+// they're loaded.
+func RegisterHandler(name string, handler job.HandlerFunc) {
+	job.RegisterHandler(name, handler)
+}
+
+// RegisterPlugin makes a job type available by name to the plugin jobs of the
+// servers in the process, see job.Plugin. Like handlers, plugins are
+// registered before the servers start.
+func RegisterPlugin(name string, plugin job.Plugin) {
+	job.RegisterPlugin(name, plugin)
+}
+
+// RegisterMiddleware adds middleware around the attempts of all jobs of the
+// servers in the process, see job.RunMiddleware.
+func RegisterMiddleware(m job.RunMiddleware) {
+	job.RegisterMiddleware(m)
+}
+
+// Job returns the job with the id.
+func (s *Server) Job(id string) (*job.Job, error) {
+	return s.cache.Get(id)
+}
+
+// DeleteJob deletes the job with the id from the cache and the database.
+func (s *Server) DeleteJob(id string) error {
+	j, err := s.cache.Get(id)
+	if err != nil {
+		return err
+	}
+	return j.Delete(s.cache, s.db)
+}
+
+// Cache returns the cache running the jobs, e.g. to find jobs or run them
+// manually.
+func (s *Server) Cache() job.JobCache {
+	return s.cache
+}
@@ -0,0 +1,102 @@
+package embedded
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/client"
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/job/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	db := memory.New()
+	s, err := New(Config{Addr: "127.0.0.1:0", DB: db, DefaultOwner: "admin@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, ErrNotStarted, s.Stop())
+	assert.Nil(t, s.Addr())
+
+	assert.NoError(t, s.Start(context.Background()))
+	assert.Equal(t, ErrStarted, s.Start(context.Background()))
+
+	added := job.GetMockJobWithGenericSchedule()
+	added.Owner = ""
+	id, err := s.AddJob(added)
+	assert.NoError(t, err)
+	j, err := s.Job(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@example.com", j.Owner)
+
+	_, err = s.AddJob(&job.Job{Name: "invalid"})
+	assert.Error(t, err)
+
+	// Jobs added in-process are served by the API.
+	fetched, err := client.New("http://" + s.Addr().String()).GetJob(id)
+	assert.NoError(t, err)
+	if assert.NotNil(t, fetched) {
+		assert.Equal(t, j.Name, fetched.Name)
+	}
+
+	deleted, err := s.AddJob(job.GetMockJobWithGenericSchedule())
+	assert.NoError(t, err)
+	assert.NoError(t, s.DeleteJob(deleted))
+	_, err = s.Job(deleted)
+	assert.Error(t, err)
+
+	// The jobs are persisted when the server stops.
+	assert.NoError(t, s.Stop())
+	assert.NoError(t, s.Stop())
+	saved, err := db.Get(context.Background(), id)
+	assert.NoError(t, err)
+	assert.Equal(t, j.Name, saved.Name)
+}
+
+func TestHandlerJob(t *testing.T) {
+	ran := make(chan string, 1)
+	RegisterHandler("embedded-test", func(ctx context.Context, params map[string]string) error {
+		ran <- params["dir"]
+		return nil
+	})
+
+	s, err := New(Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start(context.Background()))
+	defer s.Stop()
+
+	// A job without a schedule runs at once.
+	_, err = s.AddJob(&job.Job{
+		Name:              "cleanup",
+		JobType:           job.HandlerJob,
+		HandlerProperties: job.HandlerProperties{Handler: "embedded-test", Params: map[string]string{"dir": "/tmp/{{.JobName}}"}},
+	})
+	assert.NoError(t, err)
+	select {
+	case dir := <-ran:
+		assert.Equal(t, "/tmp/cleanup", dir)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The handler didn't run")
+	}
+}
+
+func TestServerStopsWithContext(t *testing.T) {
+	s, err := New(Config{})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, s.Start(ctx))
+	assert.Nil(t, s.Addr())
+
+	cancel()
+	select {
+	case <-s.shutdown.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("The server didn't stop once its context was done")
+	}
+}
+
+func TestNewRejectsNegativeDurations(t *testing.T) {
+	_, err := New(Config{PersistEvery: -time.Second})
+	assert.Error(t, err)
+}
@@ -0,0 +1,36 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func GetMockAMQPJob(props AMQPProperties) *Job {
+	return &Job{
+		Name:           "mock_amqp_job",
+		JobType:        AMQPJob,
+		AMQPProperties: props,
+	}
+}
+
+func TestAMQPJobValidation(t *testing.T) {
+	cache := NewMockCache()
+
+	j := GetMockAMQPJob(AMQPProperties{Url: "amqp://localhost/"})
+	assert.Equal(t, ErrInvalidAMQPJob, j.Init(cache))
+
+	j = GetMockAMQPJob(AMQPProperties{Url: "amqp://localhost/", RoutingKey: "tasks", Body: "{{.JobName"})
+	assert.Equal(t, ErrInvalidAMQPJob, j.Init(cache))
+
+	j = GetMockAMQPJob(AMQPProperties{Url: "amqp://localhost/", Exchange: "tasks", Body: "{{.JobName}}"})
+	j.Schedule = "R/2100-01-01T00:00:00Z/PT1H"
+	assert.NoError(t, j.Init(cache))
+}
+
+func TestAMQPRunConnectionError(t *testing.T) {
+	j := GetMockAMQPJob(AMQPProperties{Url: "amqp://127.0.0.1:1/", RoutingKey: "tasks", Timeout: 1})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.Error(t, runner.AMQPRun())
+}
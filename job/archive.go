@@ -0,0 +1,29 @@
+package job
+
+import (
+	"errors"
+
+	"github.com/ajvb/kala/archive"
+)
+
+var ErrNoArchive = errors.New("Job archives its responses, but no archive is configured")
+
+// archiveResponse stores the full response of a remote job run, if the job
+// asks for it, and links it from the run's JobStat. Failing to archive the
+// response doesn't fail the run.
+func (j *JobRunner) archiveResponse(body []byte, contentType string) {
+	if !j.job.RemoteProperties.ArchiveResponse {
+		return
+	}
+	a := archive.Default()
+	if a == nil {
+		j.logger.Errorf("Can't archive the response of job %s, no archive is configured", j.job.Name)
+		return
+	}
+	link, err := a.Put(j.job.Id+"/"+j.currentStat.RunId, body, contentType)
+	if err != nil {
+		j.logger.Errorf("Error occured archiving the response of job %s: %s", j.job.Name, err)
+		return
+	}
+	j.currentStat.ResponseArchive = link
+}
@@ -0,0 +1,74 @@
+package job
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ajvb/kala/archive"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveResponseValidation(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockRemoteJob(RemoteProperties{Url: "http://localhost/", ArchiveResponse: true})
+	assert.Equal(t, ErrNoArchive, j.Init(cache))
+}
+
+func TestArchiveResponse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	archive.SetDefault(&archive.Archiver{Store: &archive.LocalStore{Dir: dir}, Prefix: "responses"})
+	defer archive.SetDefault(nil)
+
+	response := strings.Repeat("x", 3*MaxOutputSize)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	defer ts.Close()
+
+	j := GetMockRemoteJob(RemoteProperties{Url: ts.URL, ArchiveResponse: true})
+	j.Id = "archived"
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.RemoteRun())
+
+	assert.Equal(t, response[:MaxOutputSize], runner.output)
+	stat := runner.currentStat
+	assert.Equal(t, filepath.Join(dir, "responses", "archived", stat.RunId), stat.ResponseArchive)
+	b, err := ioutil.ReadFile(stat.ResponseArchive)
+	assert.NoError(t, err)
+	assert.Equal(t, response, string(b))
+}
+
+func TestArchiveLastStepResponse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	archive.SetDefault(&archive.Archiver{Store: &archive.LocalStore{Dir: dir}})
+	defer archive.SetDefault(nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer ts.Close()
+
+	j := GetMockRemoteJob(RemoteProperties{
+		Steps:           []RemoteStep{{Url: ts.URL + "/first"}, {Url: ts.URL + "/second"}},
+		ArchiveResponse: true,
+	})
+	j.Id = "steps"
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.RemoteRun())
+
+	b, err := ioutil.ReadFile(runner.currentStat.ResponseArchive)
+	assert.NoError(t, err)
+	assert.Equal(t, "/second", string(b))
+}
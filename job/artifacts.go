@@ -0,0 +1,106 @@
+package job
+
+import (
+	"errors"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ajvb/kala/archive"
+)
+
+var ErrInvalidArtifacts = errors.New("Invalid artifacts. Only local jobs have artifacts, given as glob patterns, and they need an archive to be configured")
+
+// MaxArtifacts is the most files stored as the artifacts of a run.
+var MaxArtifacts = 100
+
+// Artifact is a file produced by a run of a local job, stored in the archive.
+type Artifact struct {
+	// Path of the file, relative to Kala's working directory if its glob is.
+	Path string `json:"path"`
+	Link string `json:"link"`
+	Size int64  `json:"size"`
+}
+
+func validArtifacts(globs []string) bool {
+	for _, glob := range globs {
+		if glob == "" {
+			return false
+		}
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// artifactKey returns the key of a file in the archive, below the run's
+// prefix. Files are kept from leaving it.
+func artifactKey(path string) string {
+	segments := []string{}
+	for _, s := range strings.Split(filepath.ToSlash(filepath.Clean(path)), "/") {
+		switch s {
+		case "", ".":
+			continue
+		case "..":
+			s = "_"
+		}
+		segments = append(segments, s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// collectArtifacts stores the files matching the job's artifact globs once
+// the run is over, under artifacts/<job id>/<run id>/ in the archive, and
+// links them from the run's JobStat. Failing to store them doesn't fail the
+// run.
+func (j *JobRunner) collectArtifacts() {
+	if len(j.job.Artifacts) == 0 {
+		return
+	}
+	a := archive.Default()
+	if a == nil {
+		j.logger.Errorf("Can't store the artifacts of job %s, no archive is configured", j.job.Name)
+		return
+	}
+	seen := map[string]bool{}
+	for _, glob := range j.job.Artifacts {
+		paths, err := filepath.Glob(glob)
+		if err != nil {
+			j.logger.Errorf("Invalid artifact glob %s of job %s: %s", glob, j.job.Name, err)
+			continue
+		}
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			if len(j.currentStat.Artifacts) == MaxArtifacts {
+				j.logger.Warnf("Job %s has more than %d artifacts, only the first ones are stored", j.job.Name, MaxArtifacts)
+				return
+			}
+			if info.Size() > archive.MaxSize {
+				j.logger.Errorf("Artifact %s of job %s is larger than %d bytes, it isn't stored", path, j.job.Name, archive.MaxSize)
+				continue
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				j.logger.Errorf("Error occured reading artifact %s of job %s: %s", path, j.job.Name, err)
+				continue
+			}
+			key := "artifacts/" + j.job.Id + "/" + j.currentStat.RunId + "/" + artifactKey(path)
+			link, err := a.Put(key, data, mime.TypeByExtension(filepath.Ext(path)))
+			if err != nil {
+				j.logger.Errorf("Error occured storing artifact %s of job %s: %s", path, j.job.Name, err)
+				continue
+			}
+			j.currentStat.Artifacts = append(j.currentStat.Artifacts, Artifact{Path: path, Link: link, Size: info.Size()})
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package job
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ajvb/kala/archive"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactKey(t *testing.T) {
+	assert.Equal(t, "reports/daily.pdf", artifactKey("./reports/daily.pdf"))
+	assert.Equal(t, "tmp/out/x.csv", artifactKey("/tmp/out/x.csv"))
+	assert.Equal(t, "_/secret", artifactKey("../secret"))
+}
+
+func TestCollectArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := filepath.Join(dir, "store")
+	archive.SetDefault(&archive.Archiver{Store: &archive.LocalStore{Dir: store}})
+	defer archive.SetDefault(nil)
+
+	out := filepath.Join(dir, "out")
+	cache := NewMockCache()
+	j, stop, err := GetMockJobRunByTest(cache, func(j *Job) {
+		j.Command = "bash -c 'mkdir -p " + out + "/sub && echo report > " + out + "/report.txt && echo data > " + out + "/data.csv'"
+		j.Artifacts = []string{out + "/*.txt", out + "/*", out + "/missing"}
+	})
+	assert.NoError(t, err)
+	defer stop()
+
+	j.Run(cache)
+	j.lock.RLock()
+	artifacts := j.Stats[0].Artifacts
+	runId := j.Stats[0].RunId
+	j.lock.RUnlock()
+	// Files matching several globs are stored once, directories aren't.
+	if assert.Len(t, artifacts, 2) {
+		assert.Equal(t, filepath.Join(out, "report.txt"), artifacts[0].Path)
+		assert.Equal(t, int64(7), artifacts[0].Size)
+		assert.Equal(t, filepath.Join(store, "artifacts", j.Id, runId, artifactKey(out), "report.txt"), artifacts[0].Link)
+		b, err := ioutil.ReadFile(artifacts[0].Link)
+		assert.NoError(t, err)
+		assert.Equal(t, "report\n", string(b))
+		assert.Equal(t, filepath.Join(out, "data.csv"), artifacts[1].Path)
+	}
+}
+
+func TestArtifactsValidation(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.Artifacts = []string{"reports/*.pdf"}
+	// An archive is needed.
+	assert.Equal(t, ErrInvalidArtifacts, j.Init(cache))
+
+	archive.SetDefault(&archive.Archiver{Store: &archive.LocalStore{Dir: os.TempDir()}})
+	defer archive.SetDefault(nil)
+	j.Artifacts = []string{"reports/[.pdf"}
+	assert.Equal(t, ErrInvalidArtifacts, j.Init(cache))
+
+	r := GetMockRemoteJob(RemoteProperties{Url: "http://example.com"})
+	r.Artifacts = []string{"reports/*.pdf"}
+	assert.Equal(t, ErrInvalidArtifacts, r.Init(cache))
+}
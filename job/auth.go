@@ -0,0 +1,351 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of RemoteAuth.
+const (
+	BasicAuth  = "basic"
+	BearerAuth = "bearer"
+	OAuth2Auth = "oauth2"
+)
+
+// DefaultSecretEnvPrefix is the prefix of the environment variables jobs may
+// refer to by default, see SetSecrets.
+const DefaultSecretEnvPrefix = "KALA_SECRET_"
+
+// RedactedSecret replaces the secrets held by jobs, rather than referred to,
+// in the jobs returned by the API. Updating a job with it keeps the secret.
+const RedactedSecret = "<redacted>"
+
+var (
+	ErrInvalidAuth = errors.New("Invalid auth. Types supported: basic with a username, bearer with a token, and oauth2 with a token_url and client_id")
+	// ErrSecretNotAllowed is returned for jobs referring to secrets they may
+	// not read, see SetSecrets.
+	ErrSecretNotAllowed = errors.New("Invalid secret reference. Jobs may only refer to the environment variables with the secret prefix, and the files of the secrets directory, of the server")
+
+	secretsLock     sync.RWMutex
+	secretsDir      string
+	secretEnvPrefix = DefaultSecretEnvPrefix
+
+	// OAuth2 tokens are refreshed this long before they expire.
+	oauth2ExpiryWindow = time.Minute
+
+	oauth2Lock   sync.Mutex
+	oauth2Tokens = map[string]*oauth2Token{}
+)
+
+// RemoteAuth authenticates the requests of remote jobs. The password, token
+// and client secret can refer to secrets instead of holding them, as
+// "env:NAME" for an environment variable of kala, or "file:/path" for a file,
+// e.g. a mounted Kubernetes secret, see SetSecrets.
+type RemoteAuth struct {
+	// "basic", "bearer" or "oauth2"
+	Type string `json:"type"`
+
+	// Basic auth
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Bearer token
+	Token string `json:"token,omitempty"`
+
+	// OAuth2 client credentials. Tokens are reused until they expire.
+	TokenURL     string   `json:"token_url,omitempty"`
+	ClientId     string   `json:"client_id,omitempty"`
+	ClientSecret string   `json:"client_secret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+func (a *RemoteAuth) valid() bool {
+	if a == nil {
+		return true
+	}
+	switch a.Type {
+	case BasicAuth:
+		return a.Username != ""
+	case BearerAuth:
+		return a.Token != ""
+	case OAuth2Auth:
+		return a.TokenURL != "" && a.ClientId != ""
+	}
+	return false
+}
+
+// allowedSecrets says if the secrets the auth refers to may be read, see
+// SetSecrets.
+func (a *RemoteAuth) allowedSecrets() bool {
+	if a == nil {
+		return true
+	}
+	for _, value := range []string{a.Password, a.Token, a.ClientSecret} {
+		if _, err := jobSecretRef(value); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// redacted returns a copy of the auth with the secrets it holds replaced by
+// RedactedSecret.
+func (a *RemoteAuth) redacted() *RemoteAuth {
+	c := *a
+	c.Password, c.Token, c.ClientSecret = redactSecret(a.Password), redactSecret(a.Token), redactSecret(a.ClientSecret)
+	return &c
+}
+
+// keepSecrets replaces the redacted secrets of the auth, e.g. of a job read
+// from the API and sent back, by those of current.
+func (a *RemoteAuth) keepSecrets(current *RemoteAuth) {
+	if a == nil || current == nil {
+		return
+	}
+	if a.Password == RedactedSecret {
+		a.Password = current.Password
+	}
+	if a.Token == RedactedSecret {
+		a.Token = current.Token
+	}
+	if a.ClientSecret == RedactedSecret {
+		a.ClientSecret = current.ClientSecret
+	}
+}
+
+func redactSecret(value string) string {
+	if value == "" || strings.HasPrefix(value, "env:") || strings.HasPrefix(value, "file:") {
+		return value
+	}
+	return RedactedSecret
+}
+
+// SetSecrets sets the secrets jobs may refer to: the environment variables
+// starting with envPrefix, none if it's empty, and the files in dir, none if
+// it's empty, which are also the credentials files Pub/Sub jobs may use.
+// Secrets of the server's own settings, e.g. the tokens of namespaces, aren't
+// restricted.
+func SetSecrets(dir, envPrefix string) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	secretsDir, secretEnvPrefix = dir, envPrefix
+}
+
+// jobSecretRef returns the file a secret reference of a job refers to, or
+// ErrSecretNotAllowed if the job may not read the secret it refers to.
+func jobSecretRef(value string) (string, error) {
+	secretsLock.RLock()
+	dir, envPrefix := secretsDir, secretEnvPrefix
+	secretsLock.RUnlock()
+
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		if envPrefix == "" || !strings.HasPrefix(strings.TrimPrefix(value, "env:"), envPrefix) {
+			return "", ErrSecretNotAllowed
+		}
+	case strings.HasPrefix(value, "file:"):
+		path, ok := fileInDir(dir, strings.TrimPrefix(value, "file:"))
+		if !ok {
+			return "", ErrSecretNotAllowed
+		}
+		return path, nil
+	}
+	return "", nil
+}
+
+// fileInDir returns the path of a file jobs refer to, relative to the
+// directory unless it's absolute, and false if it isn't in the directory, or
+// if there is no directory. Paths are cleaned, and their symlinks followed,
+// before they're checked, so that "../" or links don't lead out of the
+// directory.
+func fileInDir(dir, path string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = filepath.Clean(path)
+	if !inDir(dir, path) {
+		return "", false
+	}
+	// Files which don't exist yet fail when they're read.
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path, true
+	}
+	if resolvedDir, err := filepath.EvalSymlinks(dir); err != nil || !inDir(resolvedDir, resolved) {
+		return "", false
+	}
+	return resolved, true
+}
+
+// inDir says if the clean path is in the directory.
+func inDir(dir, path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveJobSecret returns the value of a secret of a job like resolveSecret,
+// or ErrSecretNotAllowed if the job may not read it, see SetSecrets.
+func resolveJobSecret(value string) (string, error) {
+	path, err := jobSecretRef(value)
+	if err != nil {
+		return "", err
+	}
+	if path != "" {
+		value = "file:" + path
+	}
+	return resolveSecret(value)
+}
+
+// resolveSecret returns the value of a secret reference, or the value itself
+// if it isn't a reference.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("Secret environment variable %s isn't set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		b, err := ioutil.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return value, nil
+}
+
+// authorize adds the Authorization header of the job's auth to the request,
+// unless the request already has one.
+func (j *JobRunner) authorize(req *http.Request) error {
+	auth := j.job.RemoteProperties.Auth
+	if auth == nil || req.Header.Get("Authorization") != "" {
+		return nil
+	}
+
+	var value string
+	switch auth.Type {
+	case BasicAuth:
+		password, err := resolveJobSecret(auth.Password)
+		if err != nil {
+			return err
+		}
+		r := &http.Request{Header: http.Header{}}
+		r.SetBasicAuth(auth.Username, password)
+		value = r.Header.Get("Authorization")
+	case BearerAuth:
+		token, err := resolveJobSecret(auth.Token)
+		if err != nil {
+			return err
+		}
+		value = "Bearer " + token
+	case OAuth2Auth:
+		token, err := auth.oauth2Token()
+		if err != nil {
+			return err
+		}
+		value = "Bearer " + token
+	default:
+		return ErrInvalidAuth
+	}
+
+	// The headers may be the job's own, which mustn't be changed.
+	header := http.Header{}
+	for k, v := range req.Header {
+		header[k] = v
+	}
+	header.Set("Authorization", value)
+	req.Header = header
+	return nil
+}
+
+// unauthorized is called for responses with status 401, so the next request
+// gets a new OAuth2 token, e.g. after the token was revoked.
+func (j *JobRunner) unauthorized() {
+	auth := j.job.RemoteProperties.Auth
+	if auth != nil && auth.Type == OAuth2Auth {
+		oauth2Lock.Lock()
+		delete(oauth2Tokens, auth.cacheKey())
+		oauth2Lock.Unlock()
+	}
+}
+
+type oauth2Token struct {
+	accessToken string
+	expires     time.Time
+}
+
+func (a *RemoteAuth) cacheKey() string {
+	return strings.Join([]string{a.TokenURL, a.ClientId, a.ClientSecret, strings.Join(a.Scopes, " ")}, "\n")
+}
+
+// oauth2Token returns a cached token, or requests a new one with the client
+// credentials grant.
+func (a *RemoteAuth) oauth2Token() (string, error) {
+	key := a.cacheKey()
+	oauth2Lock.Lock()
+	cached, ok := oauth2Tokens[key]
+	oauth2Lock.Unlock()
+	if ok && time.Now().Add(oauth2ExpiryWindow).Before(cached.expires) {
+		return cached.accessToken, nil
+	}
+
+	secret, err := resolveJobSecret(a.ClientSecret)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) != 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	req, err := http.NewRequest("POST", a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientId), url.QueryEscape(secret))
+
+	httpClient := http.Client{
+		Timeout: 30 * time.Second,
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("Error decoding OAuth2 token response (%s): %s", res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return "", fmt.Errorf("Error getting an OAuth2 token (%s): %s %s", res.Status, body.Error, body.ErrorDescription)
+	}
+
+	// Tokens without an expiry are requested for every run.
+	if body.ExpiresIn > 0 {
+		oauth2Lock.Lock()
+		oauth2Tokens[key] = &oauth2Token{body.AccessToken, time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)}
+		oauth2Lock.Unlock()
+	}
+	return body.AccessToken, nil
+}
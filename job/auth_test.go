@@ -0,0 +1,168 @@
+package job
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteAuthValidation(t *testing.T) {
+	cache := NewMockCache()
+
+	for _, auth := range []*RemoteAuth{
+		{Type: "digest"},
+		{Type: BasicAuth},
+		{Type: BearerAuth},
+		{Type: OAuth2Auth, TokenURL: "http://localhost/token"},
+	} {
+		j := GetMockRemoteJob(RemoteProperties{Url: "http://localhost/", Auth: auth})
+		assert.Equal(t, ErrInvalidAuth, j.Init(cache))
+	}
+}
+
+func TestRemoteAuth(t *testing.T) {
+	os.Setenv("KALA_SECRET_TEST_TOKEN", "from-env")
+	defer os.Unsetenv("KALA_SECRET_TEST_TOKEN")
+
+	var authorization string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	j := GetMockRemoteJob(RemoteProperties{
+		Url:  ts.URL,
+		Auth: &RemoteAuth{Type: BasicAuth, Username: "kala", Password: "secret"},
+	})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.RemoteRun())
+	assert.Equal(t, "Basic a2FsYTpzZWNyZXQ=", authorization)
+
+	j.RemoteProperties.Auth = &RemoteAuth{Type: BearerAuth, Token: "env:KALA_SECRET_TEST_TOKEN"}
+	assert.NoError(t, runner.RemoteRun())
+	assert.Equal(t, "Bearer from-env", authorization)
+
+	j.RemoteProperties.Auth.Token = "env:KALA_SECRET_TEST_MISSING"
+	assert.Error(t, runner.RemoteRun())
+
+	// The job's own Authorization header wins, and isn't changed.
+	j.RemoteProperties.Headers = http.Header{"Content-Type": {"application/json"}, "Authorization": {"Token custom"}}
+	assert.NoError(t, runner.RemoteRun())
+	assert.Equal(t, "Token custom", authorization)
+
+	j.RemoteProperties.Auth.Token = "from-job"
+	j.RemoteProperties.Headers = http.Header{"Content-Type": {"application/json"}}
+	assert.NoError(t, runner.RemoteRun())
+	assert.Equal(t, "Bearer from-job", authorization)
+	assert.Len(t, j.RemoteProperties.Headers, 1)
+}
+
+func TestRemoteAuthSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-secrets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("from-file\n"), 0600))
+	outside, err := ioutil.TempFile("", "kala-secret")
+	assert.NoError(t, err)
+	outside.WriteString("outside")
+	outside.Close()
+	defer os.Remove(outside.Name())
+	assert.NoError(t, os.Symlink(outside.Name(), filepath.Join(dir, "link")))
+	os.Setenv("KALA_TEST_OTHER", "other")
+	defer os.Unsetenv("KALA_TEST_OTHER")
+
+	// Without a secrets directory, jobs can't refer to files.
+	_, err = resolveJobSecret("file:" + filepath.Join(dir, "token"))
+	assert.Equal(t, ErrSecretNotAllowed, err)
+
+	SetSecrets(dir, DefaultSecretEnvPrefix)
+	defer SetSecrets("", DefaultSecretEnvPrefix)
+	secret, err := resolveJobSecret("file:" + filepath.Join(dir, "token"))
+	assert.NoError(t, err)
+	assert.Equal(t, "from-file", secret)
+	secret, err = resolveJobSecret("file:token")
+	assert.NoError(t, err)
+	assert.Equal(t, "from-file", secret)
+	for _, ref := range []string{
+		"file:/etc/passwd", "file:" + dir + "/../etc/passwd", "file:../token", "file:link", "env:KALA_TEST_OTHER",
+	} {
+		_, err = resolveJobSecret(ref)
+		assert.Equal(t, ErrSecretNotAllowed, err, ref)
+	}
+
+	cache := NewMockCache()
+	j := GetMockRemoteJob(RemoteProperties{Url: "http://localhost/", Auth: &RemoteAuth{Type: BearerAuth, Token: "env:KALA_TEST_OTHER"}})
+	assert.Equal(t, ErrSecretNotAllowed, j.Init(cache))
+}
+
+func TestRedactSecrets(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockRemoteJob(RemoteProperties{
+		Url:  "http://localhost/",
+		Auth: &RemoteAuth{Type: BasicAuth, Username: "kala", Password: "secret"},
+	})
+	assert.NoError(t, j.Init(cache))
+	defer j.StopTimer()
+
+	c := j.Copy()
+	c.RedactSecrets()
+	assert.Equal(t, RedactedSecret, c.RemoteProperties.Auth.Password)
+	assert.Equal(t, "kala", c.RemoteProperties.Auth.Username)
+	assert.Equal(t, "secret", j.RemoteProperties.Auth.Password)
+
+	// References aren't secrets.
+	ref := &RemoteAuth{Type: BearerAuth, Token: "env:KALA_SECRET_TOKEN"}
+	assert.Equal(t, "env:KALA_SECRET_TOKEN", ref.redacted().Token)
+
+	// Updating the job with the redacted secret keeps it.
+	c.Name = "renamed"
+	assert.NoError(t, j.Update(cache, c, c.ResourceVersion))
+	assert.Equal(t, "renamed", j.Name)
+	assert.Equal(t, "secret", j.RemoteProperties.Auth.Password)
+}
+
+func TestRemoteAuthOAuth2(t *testing.T) {
+	tokens := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			id, secret, _ := r.BasicAuth()
+			assert.Equal(t, "kala", id)
+			assert.Equal(t, "secret", secret)
+			assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+			assert.Equal(t, "reports:write", r.FormValue("scope"))
+			tokens++
+			fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "bearer", "expires_in": 3600}`, tokens)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token2" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	j := GetMockRemoteJob(RemoteProperties{
+		Url: ts.URL + "/reports",
+		Auth: &RemoteAuth{
+			Type:         OAuth2Auth,
+			TokenURL:     ts.URL + "/token",
+			ClientId:     "kala",
+			ClientSecret: "secret",
+			Scopes:       []string{"reports:write"},
+		},
+	})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+
+	// The first token is rejected, so it is dropped and a new one is requested.
+	assert.Error(t, runner.RemoteRun())
+	assert.NoError(t, runner.RemoteRun())
+	assert.NoError(t, runner.RemoteRun())
+	assert.Equal(t, 2, tokens)
+}
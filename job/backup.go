@@ -0,0 +1,82 @@
+package job
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// backup is the format of backups, with the jobs in the format they're
+// persisted in, see MarshalJob.
+type backup struct {
+	CreatedAt time.Time         `json:"created_at"`
+	Jobs      []json.RawMessage `json:"jobs"`
+}
+
+// WriteBackup writes a backup of the jobs, including their stats. The jobs are
+// written one at a time, so that large backups are streamed.
+func WriteBackup(w io.Writer, jobs map[string]*Job, createdAt time.Time) error {
+	ids := make([]string, 0, len(jobs))
+	for id := range jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	bw := bufio.NewWriter(w)
+	header, err := json.Marshal(createdAt)
+	if err != nil {
+		return err
+	}
+	bw.WriteString(`{"created_at":`)
+	bw.Write(header)
+	bw.WriteString(`,"jobs":[`)
+	for i, id := range ids {
+		b, err := MarshalJob(jobs[id].copyWithAllStats())
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		bw.WriteString("\n")
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	bw.WriteString("\n]}\n")
+	return bw.Flush()
+}
+
+// ReadBackup reads the jobs of a backup written by WriteBackup, migrating
+// jobs persisted in older formats.
+func ReadBackup(r io.Reader) ([]*Job, error) {
+	b := backup{}
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0, len(b.Jobs))
+	for _, raw := range b.Jobs {
+		j, err := UnmarshalJob(raw)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Restore saves the jobs of a backup to the db, replacing the jobs with the
+// same ids, and returns how many were restored.
+func Restore(ctx context.Context, r io.Reader, db JobDB) (int, error) {
+	jobs, err := ReadBackup(r)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.SaveAll(ctx, jobs); err != nil {
+		return 0, err
+	}
+	return len(jobs), nil
+}
@@ -0,0 +1,38 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	a, b := GetMockJob(), GetMockJob()
+	a.Id, b.Id = "a", "b"
+	a.Stats = []*JobStat{{JobId: "a", RunId: "run", Success: true, ExecutionDuration: time.Second}}
+
+	buf := new(bytes.Buffer)
+	createdAt := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, WriteBackup(buf, map[string]*Job{"a": a, "b": b}, createdAt))
+
+	db := &MockDBSaves{}
+	n, err := Restore(context.Background(), bytes.NewReader(buf.Bytes()), db)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"a", "b"}, db.saved)
+
+	jobs, err := ReadBackup(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 2) && assert.Len(t, jobs[0].Stats, 1) {
+		assert.Equal(t, a.Name, jobs[0].Name)
+		assert.Equal(t, "run", jobs[0].Stats[0].RunId)
+		assert.Equal(t, time.Second, jobs[0].Stats[0].ExecutionDuration)
+	}
+
+	_, err = Restore(context.Background(), strings.NewReader("not a backup"), db)
+	assert.Error(t, err)
+}
@@ -1,28 +1,49 @@
 package job
 
 import (
+	"context"
 	"errors"
-	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 	"time"
-	"unsafe"
 
-	log "github.com/Sirupsen/logrus"
-	"github.com/cornelk/hashmap"
+	"github.com/ajvb/kala/metrics"
+	"github.com/ajvb/kala/notify"
+	"github.com/ajvb/kala/utils/logging"
 )
 
 var (
+	cacheLog = logging.GetLogger(logging.Cache)
+
 	ErrJobDoesntExist = errors.New("The job you requested does not exist")
+
+	// ShutdownPersistTimeout limits how long persisting the jobs may take when
+	// the process is shut down. See Flush.
+	ShutdownPersistTimeout = 30 * time.Second
+
+	// RetentionInterval is how often the retention of stats drops old stats.
+	RetentionInterval = time.Minute
 )
 
 type JobCache interface {
 	Get(id string) (*Job, error)
 	GetAll() *JobsMap
+	// GetCopy and GetAllSnapshot return copies of jobs, for reading them
+	// without racing with their runs. See Job.Copy.
+	GetCopy(id string) (*Job, error)
+	GetAllSnapshot() map[string]*Job
+	// Find returns the jobs matching the filter, ordered by id, using an index
+	// of their owners, names and tags.
+	Find(f JobFilter) []*Job
+	// Search returns the jobs matching the query, ordered by id, using the
+	// index for its conditions on owners, names and tags.
+	Search(q *Query) []*Job
+	// Set adds the job, or replaces the job with its id. It returns
+	// ErrStaleResourceVersion rather than replacing a job by an older
+	// version of it, e.g. a copy of the job made before it was updated.
 	Set(j *Job) error
 	Delete(id string) error
 	Persist() error
+	Hooks
 }
 
 type JobsMap struct {
@@ -37,18 +58,160 @@ func NewJobsMap() *JobsMap {
 	}
 }
 
+// persistedVersions remembers the version of every job when it was last saved
+// to the db, so that only jobs which changed since are saved again.
+type persistedVersions struct {
+	lock     sync.Mutex
+	versions map[string]persistedVersion
+}
+
+type persistedVersion struct {
+	job     *Job
+	version uint64
+}
+
+// mark records that the job is saved, e.g. when it's loaded from the db.
+func (p *persistedVersions) mark(j *Job) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.versions == nil {
+		p.versions = map[string]persistedVersion{}
+	}
+	p.versions[j.Id] = persistedVersion{j, j.Version()}
+}
+
+// save saves the jobs which changed since they were last saved. It returns a
+// PersistError if some of them couldn't be saved.
+func (p *persistedVersions) save(ctx context.Context, db JobDB, jobs map[string]*Job) error {
+	return p.report(ctx, db, jobs, false).Err()
+}
+
+// changed returns snapshots of the jobs which changed since they were last
+// saved, or of all of them, and their versions. The snapshots are saved rather
+// than the jobs, so that the lock of no job is held while the db is slow, and
+// runs and updates of the jobs go on while they're saved. The lock must be
+// held.
+func (p *persistedVersions) changed(jobs map[string]*Job, all bool) ([]*Job, map[string]persistedVersion) {
+	changed := []*Job{}
+	versions := map[string]persistedVersion{}
+	for id, j := range jobs {
+		if last, ok := p.versions[id]; ok && !all && last.job == j && last.version == j.Version() {
+			continue
+		}
+		// The version is the snapshot's, so that changes made while saving
+		// are saved next time.
+		snapshot, version := j.snapshot()
+		changed = append(changed, snapshot)
+		versions[id] = persistedVersion{j, version}
+	}
+	return changed, versions
+}
+
+// commit records the versions of the saved jobs, and forgets the jobs which
+// aren't in the cache anymore. The lock must be held.
+func (p *persistedVersions) commit(versions map[string]persistedVersion, jobs map[string]*Job) {
+	if p.versions == nil {
+		p.versions = map[string]persistedVersion{}
+	}
+	for id, v := range versions {
+		p.versions[id] = v
+	}
+	for id := range p.versions {
+		if _, ok := jobs[id]; !ok {
+			delete(p.versions, id)
+		}
+	}
+}
+
+// saveUnsaved saves the jobs loaded from the db which have to be saved again,
+// e.g. in the current version of the format.
+func saveUnsaved(db JobDB, jobs []*Job) error {
+	unsaved := []*Job{}
+	for _, j := range jobs {
+		if j.unsaved {
+			unsaved = append(unsaved, j)
+		}
+	}
+	if len(unsaved) == 0 {
+		return nil
+	}
+	if err := db.SaveAll(context.Background(), unsaved); err != nil {
+		return err
+	}
+	for _, j := range unsaved {
+		j.unsaved = false
+	}
+	cacheLog.Infof("Saved %d migrated jobs", len(unsaved))
+	return nil
+}
+
 type MemoryJobCache struct {
 	// Jobs is a map from Job id's to pointers to the jobs.
 	// Used as the main "data store" within this cache implementation.
-	jobs  *JobsMap
-	jobDB JobDB
+	jobs      *JobsMap
+	jobDB     JobDB
+	persisted persistedVersions
+	wal       *WAL
+	lazy      bool
+	warmUp    warmUp
+	retention Retention
+	index     *jobIndex
+	hookFuncs *cacheHooks
+	shutdown  *shutdown
 }
 
 func NewMemoryJobCache(jobDB JobDB) *MemoryJobCache {
 	return &MemoryJobCache{
-		jobs:  NewJobsMap(),
-		jobDB: jobDB,
+		jobs:      NewJobsMap(),
+		jobDB:     jobDB,
+		index:     newJobIndex(),
+		hookFuncs: newCacheHooks(),
+		shutdown:  newShutdown(),
+	}
+}
+
+// SetWAL sets the WAL which is replayed when the cache starts, and written
+// until it's closed. See WAL.
+func (c *MemoryJobCache) SetWAL(w *WAL) {
+	c.wal = w
+}
+
+// SetLazyLoad makes Start return before the jobs are loaded, loading them in
+// the background instead. See WarmUpStatus.
+func (c *MemoryJobCache) SetLazyLoad(lazy bool) {
+	c.lazy = lazy
+}
+
+// WarmUpStatus returns the progress of loading the jobs.
+func (c *MemoryJobCache) WarmUpStatus() WarmUpStatus {
+	return c.warmUp.Status()
+}
+
+// SetRetention bounds the stats kept for every job, see Retention. Call it
+// before Start.
+func (c *MemoryJobCache) SetRetention(ttl time.Duration, maxStats int) {
+	c.retention = Retention{TTL: ttl, MaxStats: maxStats}
+}
+
+// Retention returns the bounds of the stats kept for every job.
+func (c *MemoryJobCache) Retention() Retention {
+	return c.retention
+}
+
+// Retain drops the stats beyond the retention, and returns how many.
+func (c *MemoryJobCache) Retain() int {
+	c.jobs.Lock.RLock()
+	jobs := make([]*Job, 0, len(c.jobs.Jobs))
+	for _, j := range c.jobs.Jobs {
+		jobs = append(jobs, j)
 	}
+	c.jobs.Lock.RUnlock()
+	return c.retention.Apply(jobs, time.Now())
+}
+
+// RetainEvery drops the stats beyond the retention periodically.
+func (c *MemoryJobCache) RetainEvery(interval time.Duration) {
+	c.retention.every(interval, c.shutdown.stop, c.Retain)
 }
 
 func (c *MemoryJobCache) Start(persistWaitTime time.Duration) {
@@ -57,39 +220,51 @@ func (c *MemoryJobCache) Start(persistWaitTime time.Duration) {
 	}
 
 	// Prep cache
-	allJobs, err := c.jobDB.GetAll()
-	if err != nil {
-		log.Fatal(err)
+	load := func() {
+		c.warmUp.load(c.jobDB, c.wal, c.addLoaded)
+		if c.wal != nil {
+			if err := c.wal.start(c); err != nil {
+				cacheLog.Fatalf("Error occured starting the WAL: %s", err)
+			}
+		}
+		// Occasionally, save items in cache to db.
+		go c.PersistEvery(persistWaitTime)
+		if c.retention.Enabled() {
+			go c.RetainEvery(RetentionInterval)
+		}
+		go watchClock(c, ClockCheckInterval, c.shutdown.stop)
+	}
+	if c.lazy {
+		go load()
+	} else {
+		load()
 	}
-	for _, j := range allJobs {
+}
+
+// addLoaded adds the jobs loaded from the db, unless jobs with their ids were
+// created while loading.
+func (c *MemoryJobCache) addLoaded(jobs []*Job) {
+	for _, j := range jobs {
+		if existing, _ := c.Get(j.Id); existing != nil {
+			continue
+		}
+		// The repetitions and interval of the schedule aren't persisted.
+		if err := j.InitDelayDuration(false); err != nil {
+			cacheLog.Errorf("Error occured parsing the schedule of job %s:%s: %s", j.Name, j.Id, err)
+		}
 		if j.ShouldStartWaiting() {
-			j.StartWaiting(c)
+			j.ResumeWaiting(c)
 		}
-		err = c.Set(j)
+		subscribe(j, c)
+		err := c.Set(j)
 		if err != nil {
-			log.Errorln(err)
+			cacheLog.Errorln(err)
+		}
+		// Jobs which couldn't be saved after migrating are saved on the next persist.
+		if !j.unsaved {
+			c.persisted.mark(j)
 		}
 	}
-
-	// Occasionally, save items in cache to db.
-	go c.PersistEvery(persistWaitTime)
-
-	// Process-level defer for shutting down the db.
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	go func() {
-		s := <-ch
-		log.Infof("Process got signal: %s", s)
-		log.Infof("Shutting down....")
-
-		// Persist all jobs to database
-		c.Persist()
-
-		// Close the database
-		c.jobDB.Close()
-
-		os.Exit(0)
-	}()
 }
 
 func (c *MemoryJobCache) Get(id string) (*Job, error) {
@@ -97,6 +272,7 @@ func (c *MemoryJobCache) Get(id string) (*Job, error) {
 	defer c.jobs.Lock.RUnlock()
 
 	j := c.jobs.Jobs[id]
+	metrics.RecordCacheGet(j != nil)
 	if j == nil {
 		return nil, ErrJobDoesntExist
 	}
@@ -108,73 +284,244 @@ func (c *MemoryJobCache) GetAll() *JobsMap {
 	return c.jobs
 }
 
+func (c *MemoryJobCache) GetCopy(id string) (*Job, error) {
+	j, err := c.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return j.Copy(), nil
+}
+
+func (c *MemoryJobCache) GetAllSnapshot() map[string]*Job {
+	c.jobs.Lock.RLock()
+	defer c.jobs.Lock.RUnlock()
+	return snapshot(c.jobs.Jobs)
+}
+
 func (c *MemoryJobCache) Set(j *Job) error {
-	c.jobs.Lock.Lock()
-	defer c.jobs.Lock.Unlock()
 	if j == nil {
 		return nil
 	}
+	// The version is checked under the write lock, so that of two concurrent
+	// writes the older can't win.
+	c.jobs.Lock.Lock()
+	defer c.jobs.Lock.Unlock()
+	if staleWrite(c.jobs.Jobs[j.Id], j) {
+		return ErrStaleResourceVersion
+	}
 	c.jobs.Jobs[j.Id] = j
+	c.index.add(j)
+	jobChanged(j.Id)
+	c.hookFuncs.jobSet(j)
 	return nil
 }
 
-func (c *MemoryJobCache) Delete(id string) error {
-	log.Infoln("Lock on delete")
-	c.jobs.Lock.Lock()
-	defer c.jobs.Lock.Unlock()
+func (c *MemoryJobCache) Find(f JobFilter) []*Job {
+	return findJobs(c, c.index, f)
+}
 
-	j := c.jobs.Jobs[id]
-	if j == nil {
-		return ErrJobDoesntExist
-	}
+func (c *MemoryJobCache) Search(q *Query) []*Job {
+	return searchJobs(c, c.index, q)
+}
 
-	j.Disable()
+func (c *MemoryJobCache) OnSet(f func(j *Job)) {
+	c.hookFuncs.OnSet(f)
+}
+
+func (c *MemoryJobCache) OnDelete(f func(j *Job)) {
+	c.hookFuncs.OnDelete(f)
+}
 
-	go j.DeleteFromParentJobs(c)
+func (c *MemoryJobCache) OnRunComplete(f func(j *Job, stat *JobStat, err error)) {
+	c.hookFuncs.OnRunComplete(f)
+}
 
-	// Remove itself from dependent jobs as a parent job
-	// and possibly delete child jobs if they don't have any other parents.
-	go j.DeleteFromDependentJobs(c)
+func (c *MemoryJobCache) hooks() *cacheHooks {
+	return c.hookFuncs
+}
 
-	delete(c.jobs.Jobs, id)
+func (c *MemoryJobCache) Delete(id string) error {
+	return deleteJob(context.Background(), c, id, nil)
+}
 
-	return nil
+// remove removes the job from the cache, once deleteJob removed the links to
+// it from the other jobs.
+func (c *MemoryJobCache) remove(j *Job) {
+	j.Disable()
+
+	c.jobs.Lock.Lock()
+	delete(c.jobs.Jobs, j.Id)
+	c.jobs.Lock.Unlock()
+	c.index.remove(j.Id)
+	jobChanged(j.Id)
+	metrics.Forget(j.Id)
+	j.unsubscribe()
+	j.publish(notify.JobDeleted)
+	c.hookFuncs.jobDeleted(j)
 }
 
+// Persist saves the jobs which changed since they were last saved.
 func (c *MemoryJobCache) Persist() error {
+	return c.persist(context.Background())
+}
+
+func (c *MemoryJobCache) persist(ctx context.Context) error {
+	return c.wal.checkpoint(func() error {
+		return c.persisted.save(ctx, c.jobDB, c.snapshot())
+	})
+}
+
+// PersistNow saves the jobs like Persist, or all of them, reporting which
+// couldn't be saved.
+func (c *MemoryJobCache) PersistNow(ctx context.Context, all bool) (*PersistReport, error) {
+	var r *PersistReport
+	err := c.wal.checkpoint(func() error {
+		r = c.persisted.report(ctx, c.jobDB, c.snapshot(), all)
+		return r.Err()
+	})
+	return r, err
+}
+
+// snapshot returns the jobs of the cache by id, so that they're saved without
+// holding the lock of the cache while the db is slow.
+func (c *MemoryJobCache) snapshot() map[string]*Job {
 	c.jobs.Lock.RLock()
 	defer c.jobs.Lock.RUnlock()
-	for _, j := range c.jobs.Jobs {
-		err := c.jobDB.Save(j)
-		if err != nil {
-			return err
-		}
+	jobs := make(map[string]*Job, len(c.jobs.Jobs))
+	for id, j := range c.jobs.Jobs {
+		jobs[id] = j
 	}
-	return nil
+	return jobs
 }
 
 func (c *MemoryJobCache) PersistEvery(persistWaitTime time.Duration) {
-	wait := time.Tick(persistWaitTime)
+	wait := time.NewTicker(persistWaitTime)
+	defer wait.Stop()
 	var err error
 	for {
-		<-wait
+		select {
+		case <-wait.C:
+		case <-c.shutdown.stop:
+			return
+		}
 		err = c.Persist()
 		if err != nil {
-			log.Errorf("Error occured persisting the database. Err: %s", err)
+			cacheLog.Errorf("Error occured persisting the database. Err: %s", err)
+		}
+	}
+}
+
+// Drain stops starting runs of the jobs, and waits for the running ones until
+// ctx is done, returning ctx's error if some are still running.
+func (c *MemoryJobCache) Drain(ctx context.Context) error {
+	return c.shutdown.drain(ctx)
+}
+
+// Flush stops persisting the jobs periodically, persists them one last time,
+// and closes the WAL, if there is one, so that changes which couldn't be saved
+// are replayed when Kala starts again.
+func (c *MemoryJobCache) Flush(ctx context.Context) error {
+	c.shutdown.stopLoops()
+	err := c.persist(ctx)
+	if c.wal != nil {
+		if walErr := c.wal.Close(); err == nil {
+			err = walErr
 		}
 	}
+	return err
 }
 
+func (c *MemoryJobCache) shutdownState() *shutdown {
+	return c.shutdown
+}
+
+// Number of shards of LockFreeJobCache. Readers and writers of jobs in
+// different shards never wait for each other.
+const cacheShards = 64
+
+type jobShard struct {
+	lock sync.RWMutex
+	jobs map[string]*Job
+}
+
+// LockFreeJobCache spreads its jobs over shards, each with its own lock, so
+// that runs, API requests and persisting rarely contend.
 type LockFreeJobCache struct {
-	jobs  *hashmap.HashMap
-	jobDB JobDB
+	shards    [cacheShards]*jobShard
+	jobDB     JobDB
+	persisted persistedVersions
+	wal       *WAL
+	lazy      bool
+	warmUp    warmUp
+	retention Retention
+	index     *jobIndex
+	hookFuncs *cacheHooks
+	shutdown  *shutdown
 }
 
 func NewLockFreeJobCache(jobDB JobDB) *LockFreeJobCache {
-	return &LockFreeJobCache{
-		jobs:  hashmap.New(),
-		jobDB: jobDB,
+	c := &LockFreeJobCache{
+		jobDB:     jobDB,
+		index:     newJobIndex(),
+		hookFuncs: newCacheHooks(),
+		shutdown:  newShutdown(),
+	}
+	for i := range c.shards {
+		c.shards[i] = &jobShard{jobs: map[string]*Job{}}
+	}
+	return c
+}
+
+// shard returns the shard of a job id, by its FNV-1a hash.
+func (c *LockFreeJobCache) shard(id string) *jobShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return c.shards[h%cacheShards]
+}
+
+// SetWAL sets the WAL which is replayed when the cache starts, and written
+// until it's closed. See WAL.
+func (c *LockFreeJobCache) SetWAL(w *WAL) {
+	c.wal = w
+}
+
+// SetLazyLoad makes Start return before the jobs are loaded, loading them in
+// the background instead. See WarmUpStatus.
+func (c *LockFreeJobCache) SetLazyLoad(lazy bool) {
+	c.lazy = lazy
+}
+
+// WarmUpStatus returns the progress of loading the jobs.
+func (c *LockFreeJobCache) WarmUpStatus() WarmUpStatus {
+	return c.warmUp.Status()
+}
+
+// SetRetention bounds the stats kept for every job, see Retention. Call it
+// before Start.
+func (c *LockFreeJobCache) SetRetention(ttl time.Duration, maxStats int) {
+	c.retention = Retention{TTL: ttl, MaxStats: maxStats}
+}
+
+// Retention returns the bounds of the stats kept for every job.
+func (c *LockFreeJobCache) Retention() Retention {
+	return c.retention
+}
+
+// Retain drops the stats beyond the retention, and returns how many.
+func (c *LockFreeJobCache) Retain() int {
+	jobs := []*Job{}
+	for _, j := range c.GetAll().Jobs {
+		jobs = append(jobs, j)
 	}
+	return c.retention.Apply(jobs, time.Now())
+}
+
+// RetainEvery drops the stats beyond the retention periodically.
+func (c *LockFreeJobCache) RetainEvery(interval time.Duration) {
+	c.retention.every(interval, c.shutdown.stop, c.Retain)
 }
 
 func (c *LockFreeJobCache) Start(persistWaitTime time.Duration) {
@@ -183,51 +530,64 @@ func (c *LockFreeJobCache) Start(persistWaitTime time.Duration) {
 	}
 
 	// Prep cache
-	allJobs, err := c.jobDB.GetAll()
-	if err != nil {
-		log.Fatal(err)
+	load := func() {
+		c.warmUp.load(c.jobDB, c.wal, c.addLoaded)
+		if c.wal != nil {
+			if err := c.wal.start(c); err != nil {
+				cacheLog.Fatalf("Error occured starting the WAL: %s", err)
+			}
+		}
+		// Occasionally, save items in cache to db.
+		go c.PersistEvery(persistWaitTime)
+		if c.retention.Enabled() {
+			go c.RetainEvery(RetentionInterval)
+		}
+		go watchClock(c, ClockCheckInterval, c.shutdown.stop)
+	}
+	if c.lazy {
+		go load()
+	} else {
+		load()
 	}
-	for _, j := range allJobs {
-		if j.Schedule == "" {
-			log.Infof("Job %s:%s skipped.", j.Name, j.Id)
+}
+
+// addLoaded adds the jobs loaded from the db, unless jobs with their ids were
+// created while loading.
+func (c *LockFreeJobCache) addLoaded(jobs []*Job) {
+	for _, j := range jobs {
+		if j.Schedule == "" && j.TriggerSubject == "" {
+			cacheLog.Infof("Job %s:%s skipped.", j.Name, j.Id)
 			continue
 		}
+		if existing, _ := c.Get(j.Id); existing != nil {
+			continue
+		}
+		// The repetitions and interval of the schedule aren't persisted.
+		if err := j.InitDelayDuration(false); err != nil {
+			cacheLog.Errorf("Error occured parsing the schedule of job %s:%s: %s", j.Name, j.Id, err)
+		}
 		if j.ShouldStartWaiting() {
-			j.StartWaiting(c)
+			j.ResumeWaiting(c)
 		}
-		log.Infof("Job %s:%s added to cache.", j.Name, j.Id)
+		subscribe(j, c)
+		cacheLog.Infof("Job %s:%s added to cache.", j.Name, j.Id)
 		err := c.Set(j)
 		if err != nil {
-			log.Errorln(err)
+			cacheLog.Errorln(err)
+		}
+		// Jobs which couldn't be saved after migrating are saved on the next persist.
+		if !j.unsaved {
+			c.persisted.mark(j)
 		}
 	}
-	// Occasionally, save items in cache to db.
-	go c.PersistEvery(persistWaitTime)
-
-	// Process-level defer for shutting down the db.
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	go func() {
-		s := <-ch
-		log.Infof("Process got signal: %s", s)
-		log.Infof("Shutting down....")
-
-		// Persist all jobs to database
-		c.Persist()
-
-		// Close the database
-		c.jobDB.Close()
-
-		os.Exit(0)
-	}()
 }
 
 func (c *LockFreeJobCache) Get(id string) (*Job, error) {
-	val, exists := c.jobs.GetStringKey(id)
-	if val == nil || !exists {
-		return nil, ErrJobDoesntExist
-	}
-	j := (*Job)(val)
+	shard := c.shard(id)
+	shard.lock.RLock()
+	j := shard.jobs[id]
+	shard.lock.RUnlock()
+	metrics.RecordCacheGet(j != nil)
 	if j == nil {
 		return nil, ErrJobDoesntExist
 	}
@@ -236,55 +596,168 @@ func (c *LockFreeJobCache) Get(id string) (*Job, error) {
 
 func (c *LockFreeJobCache) GetAll() *JobsMap {
 	jm := NewJobsMap()
-	for el := range c.jobs.Iter() {
-		jm.Jobs[el.Key.(string)] = (*Job)(el.Value)
+	for _, shard := range c.shards {
+		shard.lock.RLock()
+		for id, j := range shard.jobs {
+			jm.Jobs[id] = j
+		}
+		shard.lock.RUnlock()
 	}
 	return jm
 }
 
+func (c *LockFreeJobCache) GetCopy(id string) (*Job, error) {
+	j, err := c.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return j.Copy(), nil
+}
+
+func (c *LockFreeJobCache) GetAllSnapshot() map[string]*Job {
+	return snapshot(c.GetAll().Jobs)
+}
+
 func (c *LockFreeJobCache) Set(j *Job) error {
 	if j == nil {
 		return nil
 	}
-	c.jobs.Set(j.Id, unsafe.Pointer(j))
+	shard := c.shard(j.Id)
+	shard.lock.Lock()
+	if staleWrite(shard.jobs[j.Id], j) {
+		shard.lock.Unlock()
+		return ErrStaleResourceVersion
+	}
+	shard.jobs[j.Id] = j
+	shard.lock.Unlock()
+	c.index.add(j)
+	jobChanged(j.Id)
+	c.hookFuncs.jobSet(j)
 	return nil
 }
 
+func (c *LockFreeJobCache) Find(f JobFilter) []*Job {
+	return findJobs(c, c.index, f)
+}
+
+func (c *LockFreeJobCache) Search(q *Query) []*Job {
+	return searchJobs(c, c.index, q)
+}
+
+func (c *LockFreeJobCache) OnSet(f func(j *Job)) {
+	c.hookFuncs.OnSet(f)
+}
+
+func (c *LockFreeJobCache) OnDelete(f func(j *Job)) {
+	c.hookFuncs.OnDelete(f)
+}
+
+func (c *LockFreeJobCache) OnRunComplete(f func(j *Job, stat *JobStat, err error)) {
+	c.hookFuncs.OnRunComplete(f)
+}
+
+func (c *LockFreeJobCache) hooks() *cacheHooks {
+	return c.hookFuncs
+}
+
 func (c *LockFreeJobCache) Delete(id string) error {
-	j, err := c.Get(id)
-	if err != nil {
-		return err
-	}
+	return deleteJob(context.Background(), c, id, nil)
+}
 
+// remove removes the job from the cache, once deleteJob removed the links to
+// it from the other jobs.
+func (c *LockFreeJobCache) remove(j *Job) {
 	j.Disable()
-	go j.DeleteFromParentJobs(c)
-	// Remove itself from dependent jobs as a parent job
-	// and possibly delete child jobs if they don't have any other parents.
-	go j.DeleteFromDependentJobs(c)
-	log.Infof("Deleting %s", id)
-	c.jobs.Del(id)
-	return nil
+	cacheLog.Infof("Deleting %s", j.Id)
+	shard := c.shard(j.Id)
+	shard.lock.Lock()
+	delete(shard.jobs, j.Id)
+	shard.lock.Unlock()
+	c.index.remove(j.Id)
+	jobChanged(j.Id)
+	metrics.Forget(j.Id)
+	j.unsubscribe()
+	j.publish(notify.JobDeleted)
+	c.hookFuncs.jobDeleted(j)
 }
 
+// Persist saves the jobs which changed since they were last saved.
 func (c *LockFreeJobCache) Persist() error {
-	jm := c.GetAll()
-	for _, j := range jm.Jobs {
-		err := c.jobDB.Save(j)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return c.persist(context.Background())
+}
+
+func (c *LockFreeJobCache) persist(ctx context.Context) error {
+	return c.wal.checkpoint(func() error {
+		return c.persisted.save(ctx, c.jobDB, c.GetAll().Jobs)
+	})
+}
+
+// PersistNow saves the jobs like Persist, or all of them, reporting which
+// couldn't be saved.
+func (c *LockFreeJobCache) PersistNow(ctx context.Context, all bool) (*PersistReport, error) {
+	var r *PersistReport
+	err := c.wal.checkpoint(func() error {
+		r = c.persisted.report(ctx, c.jobDB, c.GetAll().Jobs, all)
+		return r.Err()
+	})
+	return r, err
 }
 
 func (c *LockFreeJobCache) PersistEvery(persistWaitTime time.Duration) {
-	wait := time.Tick(persistWaitTime)
+	wait := time.NewTicker(persistWaitTime)
+	defer wait.Stop()
 	var err error
 	for {
-		<-wait
+		select {
+		case <-wait.C:
+		case <-c.shutdown.stop:
+			return
+		}
 		err = c.Persist()
 		if err != nil {
-			log.Errorf("Error occured persisting the database. Err: %s", err)
+			cacheLog.Errorf("Error occured persisting the database. Err: %s", err)
+		}
+	}
+}
+
+// Drain stops starting runs of the jobs, and waits for the running ones until
+// ctx is done, returning ctx's error if some are still running.
+func (c *LockFreeJobCache) Drain(ctx context.Context) error {
+	return c.shutdown.drain(ctx)
+}
+
+// Flush stops persisting the jobs periodically, persists them one last time,
+// and closes the WAL, if there is one, so that changes which couldn't be saved
+// are replayed when Kala starts again.
+func (c *LockFreeJobCache) Flush(ctx context.Context) error {
+	c.shutdown.stopLoops()
+	err := c.persist(ctx)
+	if c.wal != nil {
+		if walErr := c.wal.Close(); err == nil {
+			err = walErr
 		}
 	}
+	return err
+}
+
+func (c *LockFreeJobCache) shutdownState() *shutdown {
+	return c.shutdown
+}
+
+func snapshot(jobs map[string]*Job) map[string]*Job {
+	copies := make(map[string]*Job, len(jobs))
+	for id, j := range jobs {
+		copies[id] = j.Copy()
+	}
+	return copies
+}
+
+// subscribe subscribes a message-triggered job loaded from the db.
+func subscribe(j *Job, cache JobCache) {
+	if j.TriggerSubject == "" {
+		return
+	}
+	if err := j.Subscribe(cache); err != nil {
+		cacheLog.Errorf("Error subscribing job %s:%s to %s: %s", j.Name, j.Id, j.TriggerSubject, err)
+	}
 }
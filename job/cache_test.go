@@ -1,9 +1,14 @@
 package job
 
 import (
+	"context"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ajvb/kala/metrics"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,12 +29,82 @@ func TestCachePersist(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCacheMetrics(t *testing.T) {
+	defer metrics.SetDefault(metrics.Default())
+	for _, cache := range []JobCache{NewMemoryJobCache(&MockDBSaves{}), NewLockFreeJobCache(&MockDBSaves{})} {
+		m := metrics.New(nil, 0)
+		metrics.SetDefault(m)
+
+		j := GetMockJob()
+		j.Id = "a"
+		cache.Set(j)
+		cache.Get("a")
+		cache.Get("not-a-real-id")
+		assert.NoError(t, cache.Persist())
+
+		counts := m.CacheCounts()
+		assert.Equal(t, 1, counts.Jobs)
+		assert.Equal(t, uint64(1), counts.Hits)
+		assert.Equal(t, uint64(1), counts.Misses)
+		assert.Equal(t, uint64(1), counts.Persists)
+		assert.Equal(t, uint64(0), counts.PersistErrors)
+	}
+}
+
+type MockDBSaves struct {
+	MockDB
+	saved []string
+}
+
+func (d *MockDBSaves) Save(ctx context.Context, j *Job) error {
+	d.saved = append(d.saved, j.Id)
+	return nil
+}
+
+func (d *MockDBSaves) SaveAll(ctx context.Context, jobs []*Job) error {
+	for _, j := range jobs {
+		d.Save(ctx, j)
+	}
+	return nil
+}
+
+func TestCachePersistsChangedJobs(t *testing.T) {
+	db := &MockDBSaves{}
+	for _, cache := range []JobCache{NewMemoryJobCache(db), NewLockFreeJobCache(db)} {
+		db.saved = nil
+		a, b := GetMockJob(), GetMockJob()
+		a.Id, b.Id = "a", "b"
+		cache.Set(a)
+		cache.Set(b)
+
+		assert.NoError(t, cache.Persist())
+		assert.Len(t, db.saved, 2)
+		assert.Equal(t, 2, metrics.Default().PersistCounts().LastCycleJobs)
+
+		// Nothing changed.
+		assert.NoError(t, cache.Persist())
+		assert.Len(t, db.saved, 2)
+		assert.Equal(t, 0, metrics.Default().PersistCounts().LastCycleJobs)
+
+		b.Disable()
+		assert.NoError(t, cache.Persist())
+		assert.Equal(t, []string{"b"}, db.saved[2:])
+
+		// A job replaced by another one with the same id is saved.
+		c := GetMockJob()
+		c.Id = "a"
+		cache.Set(c)
+		assert.NoError(t, cache.Persist())
+		assert.Equal(t, []string{"b", "a"}, db.saved[2:])
+	}
+}
+
 type MockDBGetAll struct {
 	MockDB
 	response []*Job
 }
 
-func (d *MockDBGetAll) GetAll() ([]*Job, error) {
+func (d *MockDBGetAll) GetAll(ctx context.Context) ([]*Job, error) {
 	return d.response, nil
 }
 
@@ -54,3 +129,222 @@ func TestCacheStartStartsARecurringJobWithStartDateInThePast(t *testing.T) {
 	assert.Equal(t, j.Metadata.SuccessCount, uint(1))
 	j.lock.RUnlock()
 }
+
+func TestCacheStartResumesTheNextRun(t *testing.T) {
+	pastDate := time.Now().Add(-24 * time.Hour)
+	// The job was waiting for a run due in a moment when it was saved, while
+	// the schedule alone would make it wait for an hour.
+	pending := GetMockRecurringJobWithSchedule(pastDate, "PT1H")
+	pending.Id = "pending"
+	pending.NextRunAt = time.Now().Round(0).Add(100 * time.Millisecond)
+	assert.NoError(t, pending.InitDelayDuration(false))
+
+	// The job's runs came due while Kala was down, and its policy skips
+	// them.
+	start := time.Now().Add(-23*time.Hour - 30*time.Minute).Truncate(time.Second)
+	missed := GetMockRecurringJobWithSchedule(start, "PT1H")
+	missed.Id = "missed"
+	missed.MisfirePolicy = MisfireSkip
+	missed.NextRunAt = start.Add(22 * time.Hour)
+	assert.NoError(t, missed.InitDelayDuration(false))
+
+	cache := NewMockCache()
+	cache.jobDB = &MockDBGetAll{response: []*Job{pending, missed}}
+	cache.Start(0)
+	time.Sleep(500 * time.Millisecond)
+
+	pending.lock.RLock()
+	assert.Len(t, pending.Stats, 1)
+	pending.lock.RUnlock()
+	pending.StopTimer()
+
+	missed.lock.RLock()
+	assert.Len(t, missed.Stats, 0)
+	// Exactly the times of the schedule.
+	assert.Equal(t, start.Add(23*time.Hour).UnixNano(), missed.Metadata.LastMisfire.UnixNano())
+	assert.Equal(t, start.Add(24*time.Hour).UnixNano(), missed.NextRunAt.UnixNano())
+	missed.lock.RUnlock()
+	missed.StopTimer()
+}
+
+func TestCacheGetCopy(t *testing.T) {
+	for _, cache := range []JobCache{NewMemoryJobCache(&MockDB{}), NewLockFreeJobCache(&MockDB{})} {
+		j := GetMockJob()
+		j.Id = "copied"
+		j.Tags = []string{"reports"}
+		j.Stats = []*JobStat{{JobId: j.Id, Success: true}}
+		assert.NoError(t, cache.Set(j))
+
+		c, err := cache.GetCopy(j.Id)
+		assert.NoError(t, err)
+		assert.False(t, c == j)
+		assert.Equal(t, j.Name, c.Name)
+		assert.Equal(t, j.Tags, c.Tags)
+		assert.Equal(t, j.Stats, c.Stats)
+
+		// Changes to the job don't show in the copy.
+		j.Tags[0] = "billing"
+		j.Stats[0].Success = false
+		j.Stats = append(j.Stats, &JobStat{JobId: j.Id})
+		assert.Equal(t, []string{"reports"}, c.Tags)
+		assert.Len(t, c.Stats, 1)
+		assert.True(t, c.Stats[0].Success)
+
+		jobs := cache.GetAllSnapshot()
+		assert.Len(t, jobs, 1)
+		assert.False(t, jobs[j.Id] == j)
+		assert.Len(t, jobs[j.Id].Stats, 2)
+
+		_, err = cache.GetCopy("not-a-real-id")
+		assert.Equal(t, ErrJobDoesntExist, err)
+	}
+}
+
+func TestCacheRejectsStaleWrites(t *testing.T) {
+	for _, cache := range []JobCache{NewMemoryJobCache(&MockDB{}), NewLockFreeJobCache(&MockDB{})} {
+		j := GetMockJob()
+		j.Id = "versioned"
+		j.ResourceVersion = 1
+		assert.NoError(t, cache.Set(j))
+		stale := j.Copy()
+
+		j.Disable()
+		assert.NoError(t, cache.Set(j))
+		assert.Equal(t, ErrStaleResourceVersion, cache.Set(stale))
+		got, err := cache.Get(j.Id)
+		assert.NoError(t, err)
+		assert.True(t, got == j)
+
+		// A copy of the current version replaces the job.
+		current := j.Copy()
+		assert.NoError(t, cache.Set(current))
+		got, _ = cache.Get(j.Id)
+		assert.True(t, got == current)
+	}
+}
+
+func TestCacheRejectsConcurrentStaleWrites(t *testing.T) {
+	for _, cache := range []JobCache{NewMemoryJobCache(&MockDB{}), NewLockFreeJobCache(&MockDB{})} {
+		for i := 0; i < 100; i++ {
+			j := GetMockJob()
+			j.Id = "versioned"
+
+			// Whichever versions are set first, the newest is the one cached.
+			var newest *Job
+			start := make(chan struct{})
+			wg := &sync.WaitGroup{}
+			for version := uint64(1); version <= 8; version++ {
+				v := j.Copy()
+				v.ResourceVersion = version
+				newest = v
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					cache.Set(v)
+				}()
+			}
+			close(start)
+			wg.Wait()
+			got, err := cache.Get(j.Id)
+			assert.NoError(t, err)
+			assert.True(t, got == newest)
+			assert.NoError(t, cache.Delete(j.Id))
+		}
+	}
+}
+
+func benchmarkCache(b *testing.B, jobs int) *LockFreeJobCache {
+	cache := NewLockFreeJobCache(&MockDB{})
+	for i := 0; i < jobs; i++ {
+		cache.Set(&Job{Id: strconv.Itoa(i)})
+	}
+	b.ResetTimer()
+	return cache
+}
+
+func BenchmarkLockFreeJobCacheGet(b *testing.B) {
+	cache := benchmarkCache(b, 10000)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Get(strconv.Itoa(i % 10000))
+			i++
+		}
+	})
+}
+
+func BenchmarkLockFreeJobCacheSet(b *testing.B) {
+	cache := benchmarkCache(b, 10000)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			cache.Set(&Job{Id: strconv.Itoa(i % 20000)})
+			i++
+		}
+	})
+}
+
+func BenchmarkLockFreeJobCacheGetAll(b *testing.B) {
+	cache := benchmarkCache(b, 10000)
+	for i := 0; i < b.N; i++ {
+		cache.GetAll()
+	}
+}
+
+type retainingCache interface {
+	JobCache
+	SetRetention(ttl time.Duration, maxStats int)
+	Retain() int
+}
+
+func TestCacheRetain(t *testing.T) {
+	testCacheRetain(t, NewMockCache())
+	testCacheRetain(t, NewMemoryJobCache(&MockDB{}))
+}
+
+func testCacheRetain(t *testing.T, cache retainingCache) {
+	cache.SetRetention(time.Hour, 3)
+
+	now := time.Now()
+	addStats := func(j *Job, ages ...time.Duration) {
+		for _, age := range ages {
+			stat := NewJobStat(j.Id)
+			stat.RanAt = now.Add(-age)
+			j.Stats = append(j.Stats, stat)
+		}
+	}
+
+	// Trimmed by age.
+	old := GetMockRecurringJobWithSchedule(now, "PT1M")
+	old.Id = "old"
+	addStats(old, 3*time.Hour, 2*time.Hour, time.Minute)
+	// Trimmed by count.
+	frequent := GetMockJob()
+	frequent.Id = "frequent"
+	addStats(frequent, 5*time.Second, 4*time.Second, 3*time.Second, 2*time.Second, time.Second)
+	// Keeps more than the cache's maximum.
+	own := GetMockJob()
+	own.Id = "own"
+	own.MaxStats = 5
+	addStats(own, 5*time.Second, 4*time.Second, 3*time.Second, 2*time.Second, time.Second)
+	// Keeps the stats counting its repetitions.
+	repeating := GetMockJobWithGenericSchedule()
+	repeating.Id = "repeating"
+	assert.NoError(t, repeating.InitDelayDuration(false))
+	addStats(repeating, 3*time.Hour, 2*time.Hour)
+
+	for _, j := range []*Job{old, frequent, own, repeating} {
+		assert.NoError(t, cache.Set(j))
+	}
+
+	assert.Equal(t, 4, cache.Retain())
+	assert.Len(t, old.Stats, 1)
+	if assert.Len(t, frequent.Stats, 3) {
+		assert.Equal(t, now.Add(-3*time.Second), frequent.Stats[0].RanAt)
+	}
+	assert.Len(t, own.Stats, 5)
+	assert.Len(t, repeating.Stats, 2)
+
+	assert.Equal(t, 0, cache.Retain())
+}
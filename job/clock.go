@@ -0,0 +1,103 @@
+package job
+
+import (
+	"time"
+
+	"github.com/ajvb/kala/notify"
+)
+
+var (
+	// How often the wall clock is reconciled with the monotonic clock which
+	// the timers of the jobs follow.
+	ClockCheckInterval = 30 * time.Second
+	// How far the wall clock must move away from the monotonic clock, e.g.
+	// as NTP stepped it or it was set by hand, to reschedule the jobs.
+	ClockJumpThreshold = 5 * time.Second
+)
+
+// clockWatcher detects jumps of the wall clock, by comparing the time the
+// wall and monotonic clocks tell elapsed between checks.
+type clockWatcher struct {
+	wall time.Time
+	mono time.Time
+}
+
+func newClockWatcher(now time.Time) *clockWatcher {
+	return &clockWatcher{wall: now.Round(0), mono: now}
+}
+
+// check returns how far the wall clock jumped since the previous check,
+// given the wall clock time and the monotonic clock time, e.g. time.Now().
+func (w *clockWatcher) check(wall, mono time.Time) time.Duration {
+	jump := wall.Sub(w.wall) - mono.Sub(w.mono)
+	w.wall, w.mono = wall, mono
+	return jump
+}
+
+// watchClock reconciles the timers of the jobs within the cache with the
+// wall clock when it jumps, until stop is closed.
+func watchClock(cache JobCache, interval time.Duration, stop <-chan struct{}) {
+	w := newClockWatcher(time.Now())
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-stop:
+			return
+		}
+		now := time.Now()
+		if jump := w.check(now.Round(0), now); jump > ClockJumpThreshold || jump < -ClockJumpThreshold {
+			ReconcileClock(cache, jump)
+		}
+	}
+}
+
+// ReconcileClock reschedules the next runs of the jobs within the cache at
+// their NextRunAt on the wall clock, after it jumped, and publishes a clock
+// jump event. Timers follow the monotonic clock, so without it the runs would
+// start as much later or earlier as the clock jumped. Runs which are overdue
+// since the clock jumped forward start right away, or misfire. It returns the
+// number of rescheduled jobs.
+func ReconcileClock(cache JobCache, jump time.Duration) int {
+	jobs := []*Job{}
+	all := cache.GetAll()
+	all.Lock.RLock()
+	for _, j := range all.Jobs {
+		jobs = append(jobs, j)
+	}
+	all.Lock.RUnlock()
+
+	rescheduled := 0
+	for _, j := range jobs {
+		if j.rearm(cache) {
+			rescheduled++
+		}
+	}
+	cacheLog.Warnf("The wall clock jumped by %s, rescheduled %d jobs", jump, rescheduled)
+	notify.Dispatch(&notify.Event{
+		Type:        notify.ClockJumped,
+		Time:        time.Now(),
+		ClockJump:   jump,
+		Rescheduled: rescheduled,
+	})
+	return rescheduled
+}
+
+// rearm restarts the timer of the job for its NextRunAt on the wall clock,
+// unless it isn't waiting for a run, and says if it did.
+func (j *Job) rearm(cache JobCache) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.jobTimer == nil || j.Disabled || j.IsDone || j.NextRunAt.IsZero() {
+		return false
+	}
+	if !j.jobTimer.Stop() {
+		// The run already started.
+		return false
+	}
+	j.NextRunAt = j.NextRunAt.Round(0)
+	j.jobTimer = afterFunc(j.NextRunAt.Sub(time.Now().Round(0)), j.scheduledRun(cache, j.NextRunAt))
+	return true
+}
@@ -0,0 +1,60 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/notify"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockWatcher(t *testing.T) {
+	start := time.Date(2017, 6, 4, 12, 0, 0, 0, time.UTC)
+	w := &clockWatcher{wall: start, mono: start}
+
+	assert.Equal(t, time.Duration(0), w.check(start.Add(time.Minute), start.Add(time.Minute)))
+	// NTP set the clock an hour forward, then ten seconds back.
+	assert.Equal(t, time.Hour, w.check(start.Add(time.Hour+2*time.Minute), start.Add(2*time.Minute)))
+	assert.Equal(t, -10*time.Second, w.check(start.Add(time.Hour+3*time.Minute-10*time.Second), start.Add(3*time.Minute)))
+}
+
+func TestReconcileClock(t *testing.T) {
+	publisher := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher()
+	dispatcher.AddPublisher("test", publisher)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+
+	cache := NewMockCache()
+	j := GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	assert.NoError(t, j.Init(cache))
+	defer j.StopTimer()
+	disabled := GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	assert.NoError(t, disabled.Init(cache))
+	disabled.Disable()
+
+	// The clock jumped forward, so that the next run is due in a moment on
+	// the wall clock, while the timer still waits for an hour.
+	j.lock.Lock()
+	j.NextRunAt = time.Now().Round(0).Add(50 * time.Millisecond)
+	j.lock.Unlock()
+
+	assert.Equal(t, 1, ReconcileClock(cache, time.Hour))
+	time.Sleep(500 * time.Millisecond)
+	j.lock.RLock()
+	assert.Len(t, j.Stats, 1)
+	j.lock.RUnlock()
+
+	dispatcher.Wait()
+	var jumps []*notify.Event
+	for _, e := range publisher.events {
+		if e.Type == notify.ClockJumped {
+			jumps = append(jumps, e)
+		}
+	}
+	if assert.Len(t, jumps, 1) {
+		assert.Equal(t, time.Hour, jumps[0].ClockJump)
+		assert.Equal(t, 1, jumps[0].Rescheduled)
+	}
+}
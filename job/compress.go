@@ -0,0 +1,115 @@
+package job
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+)
+
+var ErrInvalidCompression = errors.New("Invalid compression. Compressions supported: gzip")
+
+// GzipCompression compresses the stats of persisted jobs with gzip.
+const GzipCompression = "gzip"
+
+// compressStatMin is the size from which stats persisted one at a time are
+// compressed, e.g. as they have a large output.
+const compressStatMin = 1024
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var (
+	compressionLock  sync.RWMutex
+	statsCompression string
+)
+
+// SetStatsCompression sets how the stats of jobs are compressed when they're
+// persisted, to keep the databases small: with gzip, or not at all if it's
+// empty. Stats are decompressed when they're read however they were saved.
+func SetStatsCompression(compression string) error {
+	if compression != "" && compression != GzipCompression {
+		return ErrInvalidCompression
+	}
+	compressionLock.Lock()
+	defer compressionLock.Unlock()
+	statsCompression = compression
+	return nil
+}
+
+// StatsCompression returns how the stats of jobs are compressed when they're
+// persisted, empty if they aren't.
+func StatsCompression() string {
+	compressionLock.RLock()
+	defer compressionLock.RUnlock()
+	return statsCompression
+}
+
+func compress(b []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// compressStats returns the stats compressed with the encoding, for the
+// envelope of a job.
+func compressStats(stats []*JobStat) ([]byte, error) {
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+	return compress(b)
+}
+
+// decompressStats returns the stats compressed in the envelope of a job.
+func decompressStats(env *envelope) ([]*JobStat, error) {
+	if env.StatsEncoding != GzipCompression {
+		return nil, ErrInvalidCompression
+	}
+	b, err := decompress(env.Stats)
+	if err != nil {
+		return nil, err
+	}
+	stats := []*JobStat{}
+	err = json.Unmarshal(b, &stats)
+	return stats, err
+}
+
+// MarshalStat returns the persisted form of the stat, for StatStores saving
+// stats one at a time. Large stats, e.g. with a long output, are compressed
+// if stats are.
+func MarshalStat(stat *JobStat) ([]byte, error) {
+	b, err := json.Marshal(stat)
+	if err != nil || len(b) < compressStatMin || StatsCompression() == "" {
+		return b, err
+	}
+	return compress(b)
+}
+
+// UnmarshalStat returns the stat persisted by MarshalStat, compressed or not.
+func UnmarshalStat(b []byte) (*JobStat, error) {
+	if bytes.HasPrefix(b, gzipMagic) {
+		var err error
+		if b, err = decompress(b); err != nil {
+			return nil, err
+		}
+	}
+	stat := &JobStat{}
+	return stat, json.Unmarshal(b, stat)
+}
@@ -0,0 +1,63 @@
+package job
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressStats(t *testing.T) {
+	j := GetMockJob()
+	j.Stats = mockStats(50)
+	for _, stat := range j.Stats {
+		stat.Output = strings.Repeat("all good\n", 20)
+	}
+	plain, err := MarshalJob(j)
+	assert.NoError(t, err)
+
+	assert.NoError(t, SetStatsCompression(GzipCompression))
+	defer SetStatsCompression("")
+	compressed, err := MarshalJob(j)
+	assert.NoError(t, err)
+	assert.True(t, len(compressed) < len(plain)/4, "%d should be much less than %d", len(compressed), len(plain))
+
+	env := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(compressed, &env))
+	assert.Equal(t, GzipCompression, env["stats_encoding"])
+	assert.Nil(t, env["job"].(map[string]interface{})["stats"])
+
+	// Stats are read whether they were compressed or not.
+	for _, b := range [][]byte{compressed, plain} {
+		read, err := UnmarshalJob(b)
+		if assert.NoError(t, err) && assert.Len(t, read.Stats, 50) {
+			assert.Equal(t, j.Stats[49].RunId, read.Stats[49].RunId)
+			assert.Equal(t, j.Stats[49].Output, read.Stats[49].Output)
+		}
+	}
+
+	assert.Equal(t, ErrInvalidCompression, SetStatsCompression("zstd"))
+	assert.Equal(t, GzipCompression, StatsCompression())
+}
+
+func TestCompressLargeStats(t *testing.T) {
+	assert.NoError(t, SetStatsCompression(GzipCompression))
+	defer SetStatsCompression("")
+
+	small := &JobStat{RunId: "small", Output: "ok"}
+	b, err := MarshalStat(small)
+	assert.NoError(t, err)
+	assert.Equal(t, byte('{'), b[0])
+
+	large := &JobStat{RunId: "large", Output: strings.Repeat("all good\n", 1000)}
+	b, err = MarshalStat(large)
+	assert.NoError(t, err)
+	assert.Equal(t, gzipMagic, b[:2])
+	assert.True(t, len(b) < len(large.Output)/10)
+
+	stat, err := UnmarshalStat(b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, large.Output, stat.Output)
+	}
+}
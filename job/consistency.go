@@ -0,0 +1,240 @@
+package job
+
+import (
+	"context"
+	"sort"
+)
+
+// Kinds of the problems of a ConsistencyReport.
+const (
+	// A job has a parent job which doesn't exist, among others which do.
+	DanglingParent = "dangling_parent"
+	// All the parent jobs of a job don't exist. Fixing deletes the job, as
+	// deleting its last parent would have.
+	OrphanJob = "orphan"
+	// A job has a dependent job which doesn't exist.
+	DanglingDependent = "dangling_dependent"
+	// A job has a parent job which doesn't have it as a dependent job, so it
+	// never runs after its parent. Fixing adds it to the dependent jobs.
+	UnlinkedChild = "unlinked_child"
+	// A job has a dependent job which doesn't have it as a parent job.
+	// Fixing removes it from the dependent jobs.
+	UnlinkedDependent = "unlinked_dependent"
+	// The on failure job of a job doesn't exist.
+	DanglingOnFailure = "dangling_on_failure"
+)
+
+// ConsistencyReport tells the inconsistencies between the links of jobs,
+// e.g. left by a crash in the middle of deleting a job.
+type ConsistencyReport struct {
+	// Jobs which depend on each other, so that none of them runs after the
+	// others, each sorted by id. Cycles aren't fixed.
+	Cycles   [][]string            `json:"cycles"`
+	Problems []*ConsistencyProblem `json:"problems"`
+	// Set if the problems were fixed.
+	Fixed bool `json:"fixed"`
+}
+
+type ConsistencyProblem struct {
+	Kind  string `json:"kind"`
+	JobId string `json:"job_id"`
+	// The job JobId refers to, or which refers to it.
+	LinkedId string `json:"linked_id,omitempty"`
+}
+
+// CheckConsistency looks for cycles and broken links between the jobs of the
+// cache, and fixes the broken links if fix is set.
+func CheckConsistency(cache JobCache, fix bool) *ConsistencyReport {
+	linksLock.Lock()
+	defer linksLock.Unlock()
+
+	links := readLinks(cache)
+	report := &ConsistencyReport{
+		Cycles:   findCycles(links),
+		Problems: findProblems(links),
+	}
+	if fix {
+		for _, p := range report.Problems {
+			if err := fixProblem(cache, p); err != nil {
+				cacheLog.Errorf("Error occured fixing the %s problem of job %s: %s", p.Kind, p.JobId, err)
+			}
+		}
+		report.Fixed = true
+	}
+	return report
+}
+
+func findProblems(links map[string]jobLinks) []*ConsistencyProblem {
+	problems := []*ConsistencyProblem{}
+	add := func(kind, id, linkedId string) {
+		problems = append(problems, &ConsistencyProblem{Kind: kind, JobId: id, LinkedId: linkedId})
+	}
+	for id, l := range links {
+		missing := 0
+		for _, p := range l.parents {
+			parent, ok := links[p]
+			if !ok {
+				missing++
+			} else if !containsId(parent.dependents, id) {
+				add(UnlinkedChild, id, p)
+			}
+		}
+		if missing != 0 && missing == len(l.parents) {
+			add(OrphanJob, id, "")
+		} else {
+			for _, p := range l.parents {
+				if _, ok := links[p]; !ok {
+					add(DanglingParent, id, p)
+				}
+			}
+		}
+
+		for _, d := range l.dependents {
+			if child, ok := links[d]; !ok {
+				add(DanglingDependent, id, d)
+			} else if !containsId(child.parents, id) {
+				add(UnlinkedDependent, id, d)
+			}
+		}
+
+		if _, ok := links[l.onFailure]; l.onFailure != "" && !ok {
+			add(DanglingOnFailure, id, l.onFailure)
+		}
+	}
+	sort.Slice(problems, func(i, k int) bool {
+		if problems[i].JobId != problems[k].JobId {
+			return problems[i].JobId < problems[k].JobId
+		}
+		if problems[i].Kind != problems[k].Kind {
+			return problems[i].Kind < problems[k].Kind
+		}
+		return problems[i].LinkedId < problems[k].LinkedId
+	})
+	return problems
+}
+
+func fixProblem(cache JobCache, p *ConsistencyProblem) error {
+	if p.Kind == OrphanJob {
+		cacheLog.Infof("Deleting orphan job %s", p.JobId)
+		if remover, ok := cache.(jobRemover); ok {
+			return deleteJobLocked(context.Background(), remover, p.JobId, nil)
+		}
+		return cache.Delete(p.JobId)
+	}
+
+	id := p.JobId
+	if p.Kind == UnlinkedChild {
+		id = p.LinkedId
+	}
+	j, err := cache.Get(id)
+	if err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	switch p.Kind {
+	case DanglingParent:
+		j.ParentJobs = withoutId(j.ParentJobs, p.LinkedId)
+	case DanglingDependent, UnlinkedDependent:
+		j.DependentJobs = withoutId(j.DependentJobs, p.LinkedId)
+	case UnlinkedChild:
+		if !containsId(j.DependentJobs, p.JobId) {
+			j.DependentJobs = append(j.DependentJobs, p.JobId)
+		}
+	case DanglingOnFailure:
+		j.OnFailureJob = ""
+	}
+	j.changed()
+	return nil
+}
+
+// findCycles returns the strongly connected components of the graph of the
+// parent jobs which are cycles, with Tarjan's algorithm.
+func findCycles(links map[string]jobLinks) [][]string {
+	ids := make([]string, 0, len(links))
+	children := map[string][]string{}
+	for id, l := range links {
+		ids = append(ids, id)
+		for _, p := range l.parents {
+			if _, ok := links[p]; ok {
+				children[p] = append(children[p], id)
+			}
+		}
+	}
+	sort.Strings(ids)
+
+	var (
+		cycles  = [][]string{}
+		index   = map[string]int{}
+		lowlink = map[string]int{}
+		onStack = map[string]bool{}
+		stack   []string
+		visit   func(id string)
+	)
+	visit = func(id string) {
+		index[id] = len(index)
+		lowlink[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+
+		selfLoop := false
+		for _, child := range children[id] {
+			if child == id {
+				selfLoop = true
+			}
+			if _, ok := index[child]; !ok {
+				visit(child)
+				if lowlink[child] < lowlink[id] {
+					lowlink[id] = lowlink[child]
+				}
+			} else if onStack[child] && index[child] < lowlink[id] {
+				lowlink[id] = index[child]
+			}
+		}
+
+		if lowlink[id] != index[id] {
+			return
+		}
+		component := []string{}
+		for {
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[n] = false
+			component = append(component, n)
+			if n == id {
+				break
+			}
+		}
+		if len(component) > 1 || selfLoop {
+			sort.Strings(component)
+			cycles = append(cycles, component)
+		}
+	}
+	for _, id := range ids {
+		if _, ok := index[id]; !ok {
+			visit(id)
+		}
+	}
+	sort.Slice(cycles, func(i, k int) bool { return cycles[i][0] < cycles[k][0] })
+	return cycles
+}
+
+func containsId(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutId(ids []string, id string) []string {
+	kept := ids[:0]
+	for _, i := range ids {
+		if i != id {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
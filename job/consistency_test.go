@@ -0,0 +1,53 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	cache := NewMockCache()
+	add := func(id string, parents, dependents []string) *Job {
+		j := GetMockJob()
+		j.Id, j.ParentJobs, j.DependentJobs = id, parents, dependents
+		assert.NoError(t, cache.Set(j))
+		return j
+	}
+	add("parent", nil, []string{"child", "deleted-child", "stranger"})
+	add("child", []string{"parent", "deleted-parent"}, nil)
+	add("orphan", []string{"deleted-parent"}, nil)
+	add("unlinked", []string{"parent"}, nil)
+	add("stranger", nil, nil).OnFailureJob = "deleted-job"
+	add("a", []string{"b"}, []string{"b"})
+	add("b", []string{"a"}, []string{"a"})
+
+	report := CheckConsistency(cache, false)
+	assert.Equal(t, [][]string{{"a", "b"}}, report.Cycles)
+	assert.Equal(t, []*ConsistencyProblem{
+		{Kind: DanglingParent, JobId: "child", LinkedId: "deleted-parent"},
+		{Kind: OrphanJob, JobId: "orphan"},
+		{Kind: DanglingDependent, JobId: "parent", LinkedId: "deleted-child"},
+		{Kind: UnlinkedDependent, JobId: "parent", LinkedId: "stranger"},
+		{Kind: DanglingOnFailure, JobId: "stranger", LinkedId: "deleted-job"},
+		{Kind: UnlinkedChild, JobId: "unlinked", LinkedId: "parent"},
+	}, report.Problems)
+	assert.False(t, report.Fixed)
+
+	report = CheckConsistency(cache, true)
+	assert.True(t, report.Fixed)
+	assert.Len(t, report.Problems, 6)
+
+	parent, _ := cache.Get("parent")
+	assert.Equal(t, []string{"child", "unlinked"}, parent.DependentJobs)
+	child, _ := cache.Get("child")
+	assert.Equal(t, []string{"parent"}, child.ParentJobs)
+	stranger, _ := cache.Get("stranger")
+	assert.Equal(t, "", stranger.OnFailureJob)
+	_, err := cache.Get("orphan")
+	assert.Error(t, err)
+
+	report = CheckConsistency(cache, false)
+	assert.Empty(t, report.Problems)
+	assert.Len(t, report.Cycles, 1)
+}
@@ -0,0 +1,85 @@
+package job
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+const (
+	requestIdKey contextKey = iota
+	triggerMessageKey
+	// The keys of the run slots the run holds, see acquireRunSlots.
+	runSlotsKey
+	// Set if the run is in shadow mode, see withShadow.
+	shadowKey
+	// The time the scheduled run was due, see withScheduledAt.
+	scheduledAtKey
+	// The execution the run replays, see Job.Replay.
+	replayKey
+	// The result of the run triggering dependent jobs, see withParentResult.
+	parentResultKey
+	// Environment variables added to local commands, see WithEnv.
+	envKey
+)
+
+// TriggerMessage is the message which triggered a run of a message-triggered job.
+type TriggerMessage struct {
+	Subject string `json:"subject"`
+	Data    []byte `json:"data"`
+}
+
+// WithRequestId returns a copy of ctx carrying the id of the API request
+// which triggered a run. Runs started with it record the id on their
+// JobStat and log lines, as do the dependent jobs they trigger.
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+// RequestIdFromContext returns the request id stored by WithRequestId, if any.
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// WithTriggerMessage returns a copy of ctx carrying the message which triggered a run.
+func WithTriggerMessage(ctx context.Context, subject string, data []byte) context.Context {
+	return context.WithValue(ctx, triggerMessageKey, &TriggerMessage{subject, data})
+}
+
+// TriggerMessageFromContext returns the message stored by WithTriggerMessage, or nil.
+func TriggerMessageFromContext(ctx context.Context) *TriggerMessage {
+	msg, _ := ctx.Value(triggerMessageKey).(*TriggerMessage)
+	return msg
+}
+
+// withScheduledAt returns a copy of ctx carrying the time a scheduled run was
+// due. Runs record it on their JobStat, as do the dependent jobs they trigger.
+func withScheduledAt(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, scheduledAtKey, t)
+}
+
+// scheduledAtFromContext returns the time stored by withScheduledAt, or the
+// zero time.
+func scheduledAtFromContext(ctx context.Context) time.Time {
+	t, _ := ctx.Value(scheduledAtKey).(time.Time)
+	return t
+}
+
+// WithEnv returns a copy of ctx adding the environment variables, as
+// KEY=value, to the commands of local jobs run with it, e.g. secrets injected
+// by RunMiddleware.
+func WithEnv(ctx context.Context, env ...string) context.Context {
+	parent := envFromContext(ctx)
+	all := make([]string, 0, len(parent)+len(env))
+	all = append(append(all, parent...), env...)
+	return context.WithValue(ctx, envKey, all)
+}
+
+// envFromContext returns the environment variables added with WithEnv, or
+// nil.
+func envFromContext(ctx context.Context) []string {
+	env, _ := ctx.Value(envKey).([]string)
+	return env
+}
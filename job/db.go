@@ -1,43 +1,87 @@
 package job
 
 import (
-	"fmt"
+	"context"
+	"errors"
 
-	log "github.com/Sirupsen/logrus"
+	"github.com/ajvb/kala/metrics"
+	"github.com/ajvb/kala/utils/logging"
 )
 
-// ErrJobNotFound is raised when a Job is able to be found within a database.
-type ErrJobNotFound string
+var (
+	dbLog = logging.GetLogger(logging.DB)
 
-func (id ErrJobNotFound) Error() string {
-	return fmt.Sprintf("Job with id of %s not found.", string(id))
-}
+	// ErrNotFound is returned by JobDBs for jobs which aren't in the database.
+	ErrNotFound = errors.New("The job isn't in the database")
+
+	// ErrConflict is returned by JobDBs when a job can't be saved because of
+	// a conflicting write, e.g. a job with the same id saved concurrently.
+	ErrConflict = errors.New("The job conflicts with the job in the database")
+)
 
+// JobDB persists jobs. Implementations return ErrNotFound and ErrConflict
+// rather than their own errors for missing and conflicting jobs, and give up
+// once the context is done.
 type JobDB interface {
-	GetAll() ([]*Job, error)
-	Get(id string) (*Job, error)
-	Delete(id string) error
-	Save(job *Job) error
+	GetAll(ctx context.Context) ([]*Job, error)
+	Get(ctx context.Context, id string) (*Job, error)
+	Delete(ctx context.Context, id string) error
+	Save(ctx context.Context, job *Job) error
+	// SaveAll saves several jobs at once, in a single transaction or bulk
+	// write where the database supports it.
+	SaveAll(ctx context.Context, jobs []*Job) error
 	Close() error
 }
 
+// CompactableDB is implemented by JobDBs stored in a file which grows as jobs
+// are saved, like boltdb, to report the size of the file and shrink it.
+type CompactableDB interface {
+	DBStats() (metrics.DBStats, error)
+	// Compact rewrites the file without its free pages.
+	Compact(ctx context.Context) error
+}
+
 func (j *Job) Delete(cache JobCache, db JobDB) error {
+	return j.DeleteWithContext(context.Background(), cache, db)
+}
+
+// DeleteWithContext is like Delete, but gives up deleting the job from the db
+// once ctx is done.
+func (j *Job) DeleteWithContext(ctx context.Context, cache JobCache, db JobDB) error {
+	if remover, ok := cache.(jobRemover); ok {
+		if _, err := cache.Get(j.Id); err == nil {
+			// The links to the job are removed from the other jobs in the
+			// same transaction as the job is deleted from the db.
+			err = deleteJob(ctx, remover, j.Id, db)
+			if err != nil {
+				dbLog.Errorf("Error occured while trying to delete job from db: %s", err)
+			}
+			return err
+		}
+	}
+
 	var err error
 	j.Disable()
 	errOne := cache.Delete(j.Id)
 	if errOne != nil {
-		log.Errorf("Error occured while trying to delete job from cache: %s", errOne)
+		dbLog.Errorf("Error occured while trying to delete job from cache: %s", errOne)
 		err = errOne
 	}
-	errTwo := db.Delete(j.Id)
-	if errTwo != nil {
-		log.Errorf("Error occured while trying to delete job from db: %s", errTwo)
+	// Jobs which haven't been persisted yet aren't in the db.
+	errTwo := db.Delete(ctx, j.Id)
+	if errTwo != nil && errTwo != ErrNotFound {
+		dbLog.Errorf("Error occured while trying to delete job from db: %s", errTwo)
 		err = errTwo
 	}
 	return err
 }
 
 func DeleteAll(cache JobCache, db JobDB) error {
+	return DeleteAllWithContext(context.Background(), cache, db)
+}
+
+// DeleteAllWithContext is like DeleteAll, but gives up once ctx is done.
+func DeleteAllWithContext(ctx context.Context, cache JobCache, db JobDB) error {
 	allJobs := cache.GetAll()
 	allJobs.Lock.RLock()
 	// make a copy of all jobs to prevent deadlock on delete
@@ -48,7 +92,7 @@ func DeleteAll(cache JobCache, db JobDB) error {
 	allJobs.Lock.RUnlock()
 
 	for _, j := range jobsCopy {
-		if err := j.Delete(cache, db); err != nil {
+		if err := j.DeleteWithContext(ctx, cache, db); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,57 @@
+package job
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/metrics"
+	"github.com/ajvb/kala/notify"
+)
+
+var (
+	// MaxScheduleDrift is how long after they were due scheduled runs may
+	// start before a schedule drift event warns that the scheduler is
+	// overloaded. No event is sent if it's zero.
+	MaxScheduleDrift = 10 * time.Second
+
+	// ScheduleDriftInterval is the least time between schedule drift events,
+	// so that an overloaded scheduler doesn't send one for every run.
+	ScheduleDriftInterval = time.Minute
+)
+
+var driftAlarm struct {
+	sync.Mutex
+	lastAt time.Time
+}
+
+// recordScheduleLatency records how long after it was due the run started,
+// if it was scheduled, and warns about it if it drifted more than
+// MaxScheduleDrift.
+func (j *JobRunner) recordScheduleLatency() {
+	if scheduledAtFromContext(j.runContext()).IsZero() {
+		return
+	}
+	latency := j.currentStat.RanAt.Sub(j.currentStat.ScheduledAt)
+	if latency < 0 {
+		latency = 0
+	}
+	metrics.RecordScheduleLatency(j.job.Name, j.job.Owner, latency)
+
+	if MaxScheduleDrift <= 0 || latency <= MaxScheduleDrift {
+		return
+	}
+	now := time.Now()
+	driftAlarm.Lock()
+	if !driftAlarm.lastAt.IsZero() && now.Sub(driftAlarm.lastAt) < ScheduleDriftInterval {
+		driftAlarm.Unlock()
+		return
+	}
+	driftAlarm.lastAt = now
+	driftAlarm.Unlock()
+
+	j.logger.Warnf("Job %s started %s after it was due, the scheduler may be overloaded", j.job.Name, latency)
+	e := j.job.event(notify.ScheduleDrifted, j.currentStat, nil)
+	e.Severity = notify.SeverityWarning
+	e.Drift = latency
+	notify.Dispatch(e)
+}
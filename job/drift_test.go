@@ -0,0 +1,55 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/metrics"
+	"github.com/ajvb/kala/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleDrift(t *testing.T) {
+	publisher := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher()
+	dispatcher.AddPublisher("test", publisher)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+	m := metrics.New(nil, 0)
+	metrics.SetDefault(m)
+	defer metrics.SetDefault(metrics.New(nil, 0))
+	driftAlarm.lastAt = time.Time{}
+
+	// Runs which weren't scheduled aren't measured.
+	j := GetMockJob()
+	runner := &JobRunner{job: j}
+	_, _, err := runner.Run(NewMockCache())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), m.ScheduleLatency().Runs)
+
+	// The first run drifting too much is warned about, and the next one
+	// isn't until a minute passed.
+	for i := 0; i < 2; i++ {
+		ctx := withScheduledAt(context.Background(), time.Now().Add(-time.Minute))
+		runner = &JobRunner{job: j, ctx: ctx}
+		_, _, err = runner.Run(NewMockCache())
+		assert.NoError(t, err)
+	}
+	latency := m.ScheduleLatency()
+	assert.Equal(t, uint64(2), latency.Runs)
+	assert.True(t, latency.Max >= time.Minute)
+
+	dispatcher.Wait()
+	var drifts []*notify.Event
+	for _, e := range publisher.events {
+		if e.Type == notify.ScheduleDrifted {
+			drifts = append(drifts, e)
+		}
+	}
+	if assert.Len(t, drifts, 1) {
+		assert.Equal(t, j.Id, drifts[0].JobId)
+		assert.Equal(t, notify.SeverityWarning, drifts[0].Severity)
+		assert.True(t, drifts[0].Drift >= time.Minute)
+	}
+}
@@ -0,0 +1,84 @@
+package job
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DBOptions are the options of the --jobDB* flags, passed to the Driver
+// which opens the JobDB. Drivers ignore the options they don't use.
+type DBOptions struct {
+	// Directory of file based databases like boltdb, and how often their
+	// file is compacted, never if zero.
+	Path         string
+	CompactEvery time.Duration
+
+	Address  string
+	Username string
+	Password string
+
+	// Connection pool of SQL databases like postgres. Zero values keep the
+	// defaults of the driver.
+	MaxConns        int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Driver opens a JobDB. Packages implementing a JobDB register a Driver by
+// name with RegisterDriver, usually from their init function, so that the
+// JobDB can be selected with --jobDB by compiling the package in.
+type Driver interface {
+	Open(opts DBOptions) (JobDB, error)
+}
+
+// DriverFunc adapts a function to a Driver.
+type DriverFunc func(opts DBOptions) (JobDB, error)
+
+func (f DriverFunc) Open(opts DBOptions) (JobDB, error) {
+	return f(opts)
+}
+
+var (
+	drivers     = map[string]Driver{}
+	driversLock sync.RWMutex
+)
+
+// RegisterDriver makes a JobDB available by name. Like sql.Register, it panics
+// if the driver is nil or a driver is already registered with the name.
+func RegisterDriver(name string, driver Driver) {
+	driversLock.Lock()
+	defer driversLock.Unlock()
+	if driver == nil {
+		panic("job: RegisterDriver driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("job: RegisterDriver called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns the sorted names of the registered drivers.
+func Drivers() []string {
+	driversLock.RLock()
+	defer driversLock.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OpenDB opens a JobDB with the driver registered by name.
+func OpenDB(name string, opts DBOptions) (JobDB, error) {
+	driversLock.RLock()
+	driver, ok := drivers[name]
+	driversLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown Job DB implementation '%s' (forgotten import?)", name)
+	}
+	return driver.Open(opts)
+}
@@ -0,0 +1,41 @@
+package job
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenDB(t *testing.T) {
+	var opened DBOptions
+	RegisterDriver("testdriver", DriverFunc(func(opts DBOptions) (JobDB, error) {
+		opened = opts
+		return &MockDB{}, nil
+	}))
+	RegisterDriver("faileddriver", DriverFunc(func(opts DBOptions) (JobDB, error) {
+		return nil, errors.New("can't connect")
+	}))
+	assert.Contains(t, Drivers(), "testdriver")
+
+	opts := DBOptions{Address: "127.0.0.1:1234", Username: "kala", Password: "secret"}
+	db, err := OpenDB("testdriver", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, &MockDB{}, db)
+	assert.Equal(t, opts, opened)
+
+	_, err = OpenDB("faileddriver", opts)
+	assert.EqualError(t, err, "can't connect")
+
+	_, err = OpenDB("nosuchdriver", opts)
+	assert.Error(t, err)
+
+	assert.Panics(t, func() {
+		RegisterDriver("testdriver", DriverFunc(func(opts DBOptions) (JobDB, error) {
+			return &MockDB{}, nil
+		}))
+	})
+	assert.Panics(t, func() {
+		RegisterDriver("nildriver", nil)
+	})
+}
@@ -0,0 +1,215 @@
+package job
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+)
+
+// ErrMigrationMismatch is returned by Migrate and VerifyMigration when the
+// jobs of the db migrated to differ from those of the db migrated from.
+var ErrMigrationMismatch = errors.New("The jobs of the database migrated to differ from those of the database migrated from")
+
+// DualWriteDB is a JobDB which reads the jobs from the db being migrated from,
+// and saves and deletes them in both it and the db being migrated to, so that
+// Kala switches to the new db without downtime once Migrate copied the jobs
+// saved before. Only the errors of From are returned, as it's the db of
+// record: the errors of To are logged, and VerifyMigration finds the jobs
+// they left behind.
+type DualWriteDB struct {
+	From JobDB
+	To   JobDB
+}
+
+func (d *DualWriteDB) GetAll(ctx context.Context) ([]*Job, error) {
+	return d.From.GetAll(ctx)
+}
+
+func (d *DualWriteDB) Get(ctx context.Context, id string) (*Job, error) {
+	return d.From.Get(ctx, id)
+}
+
+func (d *DualWriteDB) Delete(ctx context.Context, id string) error {
+	if err := d.From.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err := d.To.Delete(ctx, id); err != nil && err != ErrNotFound {
+		dbLog.WithField("job_id", id).Errorf("Error occured deleting the job from the database migrated to: %s", err)
+	}
+	return nil
+}
+
+func (d *DualWriteDB) Save(ctx context.Context, j *Job) error {
+	if err := d.From.Save(ctx, j); err != nil {
+		return err
+	}
+	if err := d.To.Save(ctx, d.withStoredStats(ctx, []*Job{j})[0]); err != nil {
+		dbLog.WithField("job_id", j.Id).Errorf("Error occured saving the job to the database migrated to: %s", err)
+	}
+	return nil
+}
+
+func (d *DualWriteDB) SaveAll(ctx context.Context, jobs []*Job) error {
+	if err := d.From.SaveAll(ctx, jobs); err != nil {
+		return err
+	}
+	if err := d.To.SaveAll(ctx, d.withStoredStats(ctx, jobs)); err != nil {
+		dbLog.Errorf("Error occured saving %d jobs to the database migrated to: %s", len(jobs), err)
+	}
+	return nil
+}
+
+// SaveAndDelete saves the jobs and deletes the jobs with the ids in both dbs,
+// in a single transaction in each which is a BatchDB.
+func (d *DualWriteDB) SaveAndDelete(ctx context.Context, jobs []*Job, ids []string) error {
+	if err := saveAndDelete(ctx, d.From, jobs, ids); err != nil {
+		return err
+	}
+	if err := saveAndDelete(ctx, d.To, d.withStoredStats(ctx, jobs), ids); err != nil {
+		dbLog.Errorf("Error occured deleting %d jobs from the database migrated to: %s", len(ids), err)
+	}
+	return nil
+}
+
+// withStoredStats returns copies of the jobs saved to From with all of their
+// stats, if From is a StatStore, as the jobs only have their most recent
+// stats in memory.
+func (d *DualWriteDB) withStoredStats(ctx context.Context, jobs []*Job) []*Job {
+	store, ok := UnwrapDB(d.From).(StatStore)
+	if !ok {
+		return jobs
+	}
+	copies := make([]*Job, len(jobs))
+	for i, j := range jobs {
+		copies[i] = j.Copy()
+		stats, err := store.GetStats(ctx, j.Id)
+		if err != nil {
+			dbLog.WithField("job_id", j.Id).Errorf("Error occured reading the stats of the job to save to the database migrated to: %s", err)
+			continue
+		}
+		copies[i].Stats = stats
+	}
+	return copies
+}
+
+// Close closes both dbs.
+func (d *DualWriteDB) Close() error {
+	errTo := d.To.Close()
+	if err := d.From.Close(); err != nil {
+		return err
+	}
+	return errTo
+}
+
+// MigrateReport tells what migrating the jobs from a db to another copied, and
+// whether the jobs of both are the same.
+type MigrateReport struct {
+	Copied int `json:"copied"`
+
+	// Number of jobs in each db, and the hash of all of their jobs.
+	FromJobs int    `json:"from_jobs"`
+	ToJobs   int    `json:"to_jobs"`
+	FromHash string `json:"from_hash"`
+	ToHash   string `json:"to_hash"`
+
+	// Ids of the jobs which differ, or are missing from either db.
+	Mismatched []string `json:"mismatched,omitempty"`
+}
+
+// Migrate copies all jobs of from to to, replacing the jobs with the same ids,
+// then verifies the copy like VerifyMigration. It returns ErrMigrationMismatch
+// with the report if the jobs of the dbs differ, e.g. as jobs were saved to
+// from while they were copied without a DualWriteDB.
+func Migrate(ctx context.Context, from, to JobDB) (*MigrateReport, error) {
+	jobs, err := from.GetAll(ctx)
+	if err == nil {
+		jobs, err = withAllStats(ctx, from, jobs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) != 0 {
+		if err := to.SaveAll(ctx, jobs); err != nil {
+			return nil, err
+		}
+	}
+	r, err := VerifyMigration(ctx, from, to)
+	if r != nil {
+		r.Copied = len(jobs)
+	}
+	return r, err
+}
+
+// VerifyMigration compares the jobs of both dbs by the hash of their persisted
+// format. It returns ErrMigrationMismatch with the report if they differ.
+func VerifyMigration(ctx context.Context, from, to JobDB) (*MigrateReport, error) {
+	fromHashes, err := jobHashes(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	toHashes, err := jobHashes(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &MigrateReport{
+		FromJobs: len(fromHashes),
+		ToJobs:   len(toHashes),
+		FromHash: combinedHash(fromHashes),
+		ToHash:   combinedHash(toHashes),
+	}
+	for id, hash := range fromHashes {
+		if toHashes[id] != hash {
+			r.Mismatched = append(r.Mismatched, id)
+		}
+	}
+	for id := range toHashes {
+		if _, ok := fromHashes[id]; !ok {
+			r.Mismatched = append(r.Mismatched, id)
+		}
+	}
+	sort.Strings(r.Mismatched)
+	if len(r.Mismatched) != 0 {
+		return r, ErrMigrationMismatch
+	}
+	return r, nil
+}
+
+// jobHashes returns the hashes of the jobs of the db, by id.
+func jobHashes(ctx context.Context, db JobDB) (map[string]string, error) {
+	jobs, err := db.GetAll(ctx)
+	if err == nil {
+		jobs, err = withAllStats(ctx, db, jobs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]string, len(jobs))
+	for _, j := range jobs {
+		b, err := MarshalJob(j)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		hashes[j.Id] = hex.EncodeToString(sum[:])
+	}
+	return hashes, nil
+}
+
+// combinedHash returns the hash of the hashes of all jobs, in the order of
+// their ids.
+func combinedHash(hashes map[string]string) string {
+	ids := make([]string, 0, len(hashes))
+	for id := range hashes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	h := sha256.New()
+	for _, id := range ids {
+		h.Write([]byte(id))
+		h.Write([]byte(hashes[id]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,137 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mapDB persists the jobs in a map, in the format they're persisted in.
+type mapDB struct {
+	MockDB
+	jobs map[string][]byte
+	down bool
+}
+
+func newMapDB() *mapDB {
+	return &mapDB{jobs: map[string][]byte{}}
+}
+
+func (db *mapDB) GetAll(ctx context.Context) ([]*Job, error) {
+	ids := []string{}
+	for id := range db.jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	jobs := []*Job{}
+	for _, id := range ids {
+		j, err := UnmarshalJob(db.jobs[id])
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func (db *mapDB) Get(ctx context.Context, id string) (*Job, error) {
+	b, ok := db.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return UnmarshalJob(b)
+}
+
+func (db *mapDB) Delete(ctx context.Context, id string) error {
+	if db.down {
+		return errors.New("connection refused")
+	}
+	if _, ok := db.jobs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(db.jobs, id)
+	return nil
+}
+
+func (db *mapDB) Save(ctx context.Context, j *Job) error {
+	if db.down {
+		return errors.New("connection refused")
+	}
+	b, err := MarshalJob(j)
+	if err != nil {
+		return err
+	}
+	db.jobs[j.Id] = b
+	return nil
+}
+
+func (db *mapDB) SaveAll(ctx context.Context, jobs []*Job) error {
+	for _, j := range jobs {
+		if err := db.Save(ctx, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestDualWriteDB(t *testing.T) {
+	ctx := context.Background()
+	from, to := newMapDB(), newMapDB()
+	db := &DualWriteDB{From: from, To: to}
+
+	a, b := GetMockJob(), GetMockJob()
+	a.Id, b.Id = "a", "b"
+	assert.NoError(t, db.Save(ctx, a))
+	assert.NoError(t, db.SaveAll(ctx, []*Job{b}))
+	assert.Len(t, to.jobs, 2)
+
+	assert.NoError(t, db.SaveAndDelete(ctx, []*Job{a}, []string{"b"}))
+	assert.Len(t, from.jobs, 1)
+	assert.Len(t, to.jobs, 1)
+
+	// Errors of the db migrated to don't fail writes, and are found by
+	// verifying the migration.
+	to.down = true
+	assert.NoError(t, db.Save(ctx, b))
+	to.down = false
+	r, err := VerifyMigration(ctx, from, to)
+	assert.Equal(t, ErrMigrationMismatch, err)
+	assert.Equal(t, []string{"b"}, r.Mismatched)
+
+	from.down = true
+	assert.Error(t, db.Delete(ctx, "a"))
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	from, to := newMapDB(), newMapDB()
+	for _, id := range []string{"a", "b", "c"} {
+		j := GetMockJob()
+		j.Id = id
+		assert.NoError(t, from.Save(ctx, j))
+	}
+	stale := GetMockJob()
+	stale.Id = "b"
+	stale.Command = "echo stale"
+	assert.NoError(t, to.Save(ctx, stale))
+
+	r, err := Migrate(ctx, from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, r.Copied)
+	assert.Equal(t, 3, r.ToJobs)
+	assert.Equal(t, r.FromHash, r.ToHash)
+	assert.Empty(t, r.Mismatched)
+
+	// Jobs left in the db migrated to are reported.
+	extra := GetMockJob()
+	extra.Id = "d"
+	assert.NoError(t, to.Save(ctx, extra))
+	r, err = VerifyMigration(ctx, from, to)
+	assert.Equal(t, ErrMigrationMismatch, err)
+	assert.Equal(t, 4, r.ToJobs)
+	assert.NotEqual(t, r.FromHash, r.ToHash)
+	assert.Equal(t, []string{"d"}, r.Mismatched)
+}
@@ -0,0 +1,125 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrInvalidEngine = errors.New("Invalid engine. Engines supported: v1 and v2")
+
+// Engines running the attempts of jobs, so that jobs are moved to the
+// redesigned runner one at a time, and the stats of both compared, see
+// StatsQuery.Engine.
+const (
+	// The runner as it always ran jobs.
+	EngineV1 = "v1"
+	// The redesigned runner: attempts run in a pool of workers bounding how
+	// many run at once, see SetEngineV2Workers, and local commands are
+	// killed once the context of their attempt is done, e.g. when
+	// RunMiddleware times it out.
+	EngineV2 = "v2"
+)
+
+var (
+	engineLock    sync.RWMutex
+	defaultEngine = EngineV1
+	// Workers of the v2 engine, a slot per worker. Unbounded if nil.
+	engineV2Workers chan struct{}
+)
+
+func validEngine(engine string) bool {
+	return engine == "" || engine == EngineV1 || engine == EngineV2
+}
+
+// SetDefaultEngine sets the engine of the jobs which don't set theirs.
+func SetDefaultEngine(engine string) error {
+	if engine == "" || !validEngine(engine) {
+		return ErrInvalidEngine
+	}
+	engineLock.Lock()
+	defer engineLock.Unlock()
+	defaultEngine = engine
+	return nil
+}
+
+// DefaultEngine returns the engine of the jobs which don't set theirs.
+func DefaultEngine() string {
+	engineLock.RLock()
+	defer engineLock.RUnlock()
+	return defaultEngine
+}
+
+// SetEngineV2Workers sets how many attempts the v2 engine runs at once,
+// unbounded if n is zero. Attempts wait for a worker before running.
+func SetEngineV2Workers(n int) {
+	engineLock.Lock()
+	defer engineLock.Unlock()
+	engineV2Workers = nil
+	if n > 0 {
+		engineV2Workers = make(chan struct{}, n)
+	}
+}
+
+func getEngineV2Workers() chan struct{} {
+	engineLock.RLock()
+	defer engineLock.RUnlock()
+	return engineV2Workers
+}
+
+// engine returns the engine running the job. Callers must hold the job's
+// lock.
+func (j *Job) engine() string {
+	if j.Engine != "" {
+		return j.Engine
+	}
+	return DefaultEngine()
+}
+
+// engineRun runs an attempt of the job with its engine.
+func (j *JobRunner) engineRun() error {
+	if j.currentStat.Engine != EngineV2 {
+		return j.attempt()
+	}
+
+	workers := getEngineV2Workers()
+	if workers != nil {
+		select {
+		case workers <- struct{}{}:
+		case <-j.runContext().Done():
+			return j.runContext().Err()
+		}
+		defer func() {
+			<-workers
+		}()
+	}
+	return j.attempt()
+}
+
+// commandContext returns the context local commands are bound to: the context
+// of the attempt on the v2 engine, and none on the v1 engine.
+func (j *JobRunner) commandContext() context.Context {
+	if j.currentStat == nil || j.currentStat.Engine != EngineV2 {
+		return context.Background()
+	}
+	return j.runContext()
+}
+
+// EngineStatsSummary aggregates the job's stats of the runs by the engine like
+// StatsSummary, to compare the runs of both engines.
+func (j *Job) EngineStatsSummary(engine string, window time.Duration) *JobStatsSummary {
+	stats := []*JobStat{}
+	for _, stat := range j.allStats() {
+		if stat.ranOn(engine) {
+			stats = append(stats, stat)
+		}
+	}
+	return NewJobStatsSummary(j.Id, stats, window, time.Now())
+}
+
+// ranOn says if the engine ran the run. Runs from before engines were
+// recorded ran on the v1 engine.
+func (s *JobStat) ranOn(engine string) bool {
+	return s.Engine == engine || (s.Engine == "" && engine == EngineV1)
+}
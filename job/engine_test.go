@@ -0,0 +1,101 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngineValidation(t *testing.T) {
+	j := GetMockJob()
+	j.Engine = "v3"
+	assert.Equal(t, ErrInvalidEngine, j.Init(NewMockCache()))
+
+	assert.Equal(t, ErrInvalidEngine, SetDefaultEngine("v3"))
+	assert.Equal(t, ErrInvalidEngine, SetDefaultEngine(""))
+	assert.Equal(t, EngineV1, DefaultEngine())
+}
+
+func TestEngineRecordedOnStats(t *testing.T) {
+	j := GetMockJob()
+	runner := &JobRunner{job: j}
+	stat, _, err := runner.Run(NewMockCache())
+	assert.NoError(t, err)
+	assert.Equal(t, EngineV1, stat.Engine)
+
+	assert.NoError(t, SetDefaultEngine(EngineV2))
+	defer SetDefaultEngine(EngineV1)
+	runner = &JobRunner{job: j}
+	stat, _, err = runner.Run(NewMockCache())
+	assert.NoError(t, err)
+	assert.Equal(t, EngineV2, stat.Engine)
+
+	// Jobs setting their engine don't use the default.
+	j.Engine = EngineV1
+	runner = &JobRunner{job: j}
+	stat, _, err = runner.Run(NewMockCache())
+	assert.NoError(t, err)
+	assert.Equal(t, EngineV1, stat.Engine)
+}
+
+func TestEngineV2KillsCommands(t *testing.T) {
+	defer useMiddleware(func(ctx context.Context, a *Attempt, next RunFunc) error {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		return next(ctx, a)
+	})()
+
+	j := GetMockJob()
+	j.Command = "sleep 5"
+	j.Retries = 0
+	j.Engine = EngineV2
+	runner := &JobRunner{job: j}
+	start := time.Now()
+	_, _, err := runner.Run(NewMockCache())
+	assert.Error(t, err)
+	assert.WithinDuration(t, start, time.Now(), 2*time.Second)
+}
+
+func TestEngineV2Workers(t *testing.T) {
+	SetEngineV2Workers(1)
+	defer SetEngineV2Workers(0)
+
+	// The only worker is busy, so the attempt waits until its run is
+	// cancelled.
+	workers := getEngineV2Workers()
+	workers <- struct{}{}
+	defer func() {
+		<-workers
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	j := GetMockJob()
+	j.Retries = 0
+	j.Engine = EngineV2
+	runner := &JobRunner{job: j, ctx: ctx}
+	_, _, err := runner.Run(NewMockCache())
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestEngineStats(t *testing.T) {
+	now := time.Now()
+	j := GetMockJob()
+	j.Stats = []*JobStat{
+		{RanAt: now.Add(-3 * time.Minute), Success: true},
+		{RanAt: now.Add(-2 * time.Minute), Success: true, Engine: EngineV1},
+		{RanAt: now.Add(-time.Minute), Success: false, Engine: EngineV2},
+	}
+
+	assert.Len(t, j.QueryStats(StatsQuery{Engine: EngineV1}), 2)
+	assert.Len(t, j.QueryStats(StatsQuery{Engine: EngineV2}), 1)
+
+	v1 := j.EngineStatsSummary(EngineV1, time.Hour)
+	assert.Equal(t, 2, v1.Runs)
+	assert.Equal(t, 1.0, v1.SuccessRate)
+	v2 := j.EngineStatsSummary(EngineV2, time.Hour)
+	assert.Equal(t, 1, v2.Runs)
+	assert.Equal(t, 1, v2.Failures)
+}
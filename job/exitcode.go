@@ -0,0 +1,106 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+var ErrInvalidExitCodes = errors.New("Invalid exit_codes. They are only for local jobs, between 0 and 255, and either success or warning codes")
+
+// Outcomes of the runs of local jobs, as classified by their ExitCodes.
+const (
+	OutcomeSuccess = "success"
+	OutcomeWarning = "warning"
+	OutcomeFailure = "failure"
+)
+
+// ExitCodes classifies the exit codes of the command of a local job, e.g.
+// {"success": [0], "warning": [3]}. Other exit codes are failures. Runs with
+// a warning succeed, without retries, but count their warnings in their
+// stats, metadata and metrics.
+type ExitCodes struct {
+	// Exit codes of successful runs, only 0 if empty.
+	Success []int `json:"success,omitempty"`
+	Warning []int `json:"warning,omitempty"`
+}
+
+// outcome returns the outcome of a run whose command exited with code. Nil
+// ExitCodes only have 0 for success.
+func (c *ExitCodes) outcome(code int) string {
+	success := []int{0}
+	if c != nil && len(c.Success) != 0 {
+		success = c.Success
+	}
+	if containsCode(success, code) {
+		return OutcomeSuccess
+	}
+	if c != nil && containsCode(c.Warning, code) {
+		return OutcomeWarning
+	}
+	return OutcomeFailure
+}
+
+func (c *ExitCodes) valid() bool {
+	for _, code := range append(append([]int{}, c.Success...), c.Warning...) {
+		if code < 0 || code > 255 {
+			return false
+		}
+	}
+	for _, code := range c.Warning {
+		if containsCode(c.Success, code) || (len(c.Success) == 0 && code == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsCode(codes []int, code int) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCode returns the exit code of a command which ran with err, and false
+// if it didn't exit with one, e.g. since it didn't start or was killed.
+func exitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || status.Signaled() {
+		return 0, false
+	}
+	return status.ExitStatus(), true
+}
+
+// classifyExit records the exit code of the command, which ran with err, and
+// returns the error of the attempt as classified by the job's ExitCodes: nil
+// for success and warning codes.
+func (j *JobRunner) classifyExit(err error) error {
+	j.exitCode, j.warning = nil, false
+	code, ok := exitCode(err)
+	if !ok {
+		return err
+	}
+	j.exitCode = &code
+	switch j.job.ExitCodes.outcome(code) {
+	case OutcomeSuccess:
+		return nil
+	case OutcomeWarning:
+		j.warning = true
+		return nil
+	}
+	if err == nil {
+		return fmt.Errorf("exit status %d", code)
+	}
+	return err
+}
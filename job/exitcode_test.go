@@ -0,0 +1,66 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCodesOutcome(t *testing.T) {
+	var none *ExitCodes
+	assert.Equal(t, OutcomeSuccess, none.outcome(0))
+	assert.Equal(t, OutcomeFailure, none.outcome(3))
+
+	c := &ExitCodes{Success: []int{0, 1}, Warning: []int{3}}
+	assert.Equal(t, OutcomeSuccess, c.outcome(1))
+	assert.Equal(t, OutcomeWarning, c.outcome(3))
+	assert.Equal(t, OutcomeFailure, c.outcome(2))
+
+	assert.True(t, c.valid())
+	assert.False(t, (&ExitCodes{Warning: []int{0}}).valid())
+	assert.False(t, (&ExitCodes{Success: []int{1}, Warning: []int{1}}).valid())
+	assert.False(t, (&ExitCodes{Warning: []int{256}}).valid())
+}
+
+func TestExitCodesRuns(t *testing.T) {
+	cache := NewMockCache()
+	j, stop, err := GetMockJobRunByTest(cache, func(j *Job) {
+		j.Command = "bash -c 'exit 3'"
+		j.Retries = 1
+		j.ExitCodes = &ExitCodes{Warning: []int{3}}
+	})
+	assert.NoError(t, err)
+	defer stop()
+
+	j.Run(cache)
+	if assert.Equal(t, 1, len(j.Stats)) {
+		assert.True(t, j.Stats[0].Success)
+		assert.True(t, j.Stats[0].Warning)
+		assert.Equal(t, 3, *j.Stats[0].ExitCode)
+		// Warnings aren't retried.
+		assert.Equal(t, uint(0), j.Stats[0].NumberOfRetries)
+	}
+	assert.Equal(t, uint(1), j.Metadata.WarningCount)
+
+	j.Command = "bash -c 'exit 4'"
+	j.Run(cache)
+	if assert.Equal(t, 2, len(j.Stats)) {
+		assert.False(t, j.Stats[1].Success)
+		assert.False(t, j.Stats[1].Warning)
+		assert.Equal(t, 4, *j.Stats[1].ExitCode)
+	}
+
+	// Codes besides 0 can mean success, and 0 failure.
+	j.ExitCodes = &ExitCodes{Success: []int{4}}
+	j.Run(cache)
+	assert.True(t, j.Stats[2].Success)
+	j.Command = "bash -c 'exit 0'"
+	j.Run(cache)
+	assert.False(t, j.Stats[3].Success)
+}
+
+func TestExitCodesOnlyForLocalJobs(t *testing.T) {
+	j := GetMockRemoteJob(RemoteProperties{Url: "http://example.com"})
+	j.ExitCodes = &ExitCodes{Warning: []int{3}}
+	assert.Equal(t, ErrInvalidExitCodes, j.Init(NewMockCache()))
+}
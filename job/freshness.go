@@ -0,0 +1,50 @@
+package job
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ajvb/kala/utils/iso8601"
+)
+
+var ErrInvalidFreshnessSLA = errors.New("Invalid freshness_sla. It's an ISO 8601 duration, e.g. PT26H")
+
+// Freshness tells how recent the last successful run of a job is, so that
+// consumers of its output can check that e.g. the nightly run is done.
+type Freshness struct {
+	JobId string `json:"job_id"`
+	// Nil if the job never succeeded.
+	LastSuccess *time.Time    `json:"last_success"`
+	Age         time.Duration `json:"age,omitempty"`
+
+	// The job's FreshnessSLA, if it declares one.
+	SLA string `json:"freshness_sla,omitempty"`
+	// Whether the job succeeded, within the SLA if it declares one.
+	Fresh bool `json:"fresh"`
+}
+
+func validFreshnessSLA(sla string) bool {
+	d, err := iso8601.FromString(sla)
+	return err == nil && d.ToDuration() > 0
+}
+
+// Freshness returns the freshness of the job's output at now.
+func (j *Job) Freshness(now time.Time) *Freshness {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	f := &Freshness{JobId: j.Id, SLA: j.FreshnessSLA}
+	if j.Metadata.LastSuccess.IsZero() {
+		return f
+	}
+	lastSuccess := j.Metadata.LastSuccess
+	f.LastSuccess = &lastSuccess
+	f.Age = now.Sub(lastSuccess)
+	f.Fresh = true
+	if j.FreshnessSLA != "" {
+		if sla, err := iso8601.FromString(j.FreshnessSLA); err == nil {
+			f.Fresh = f.Age <= sla.ToDuration()
+		}
+	}
+	return f
+}
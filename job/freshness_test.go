@@ -0,0 +1,43 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreshness(t *testing.T) {
+	now := time.Now()
+	j := GetMockJob()
+	j.Id = "nightly"
+	j.FreshnessSLA = "PT26H"
+
+	f := j.Freshness(now)
+	assert.Equal(t, "nightly", f.JobId)
+	assert.Nil(t, f.LastSuccess)
+	assert.False(t, f.Fresh)
+
+	j.Metadata.LastSuccess = now.Add(-25 * time.Hour)
+	f = j.Freshness(now)
+	assert.Equal(t, j.Metadata.LastSuccess, *f.LastSuccess)
+	assert.Equal(t, 25*time.Hour, f.Age)
+	assert.Equal(t, "PT26H", f.SLA)
+	assert.True(t, f.Fresh)
+
+	j.Metadata.LastSuccess = now.Add(-27 * time.Hour)
+	assert.False(t, j.Freshness(now).Fresh)
+
+	// Without an SLA any success is fresh.
+	j.FreshnessSLA = ""
+	assert.True(t, j.Freshness(now).Fresh)
+}
+
+func TestFreshnessSLAValidation(t *testing.T) {
+	j := GetMockJobWithGenericSchedule()
+	j.FreshnessSLA = "26h"
+	assert.Equal(t, ErrInvalidFreshnessSLA, j.Init(NewMockCache()))
+
+	j.FreshnessSLA = "P1D"
+	assert.NoError(t, j.Init(NewMockCache()))
+}
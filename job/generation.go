@@ -0,0 +1,20 @@
+package job
+
+import "sync/atomic"
+
+// generation counts the changes to jobs, see Generation.
+var generation uint64
+
+// Generation returns a counter of the changes to jobs in every cache, which
+// also counts jobs being added and deleted, e.g. to tell clients listing
+// jobs that nothing changed since they last did. It starts over when Kala
+// restarts.
+func Generation() uint64 {
+	return atomic.LoadUint64(&generation)
+}
+
+// jobChanged records a change of a job, in the generation and in the WAL.
+func jobChanged(id string) {
+	atomic.AddUint64(&generation, 1)
+	walChanged(id)
+}
@@ -0,0 +1,24 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneration(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockJobWithGenericSchedule()
+
+	generation := Generation()
+	assert.NoError(t, cache.Set(j))
+	assert.True(t, Generation() > generation)
+
+	generation = Generation()
+	j.Disable()
+	assert.True(t, Generation() > generation)
+
+	generation = Generation()
+	assert.NoError(t, cache.Delete(j.Id))
+	assert.True(t, Generation() > generation)
+}
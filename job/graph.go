@@ -0,0 +1,197 @@
+package job
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Kinds of the edges of a Graph.
+const (
+	// The child runs after the parent, see Job.ParentJobs.
+	DependentEdge = "dependent"
+	// The job runs when the other one fails, see Job.OnFailureJob.
+	OnFailureEdge = "on_failure"
+)
+
+// Graph is the graph of what depends on what: the jobs, and edges from
+// parent jobs to their dependent jobs, and from jobs to their on failure
+// jobs.
+type Graph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Disabled bool   `json:"disabled"`
+	// The job is referred to by another job, but isn't in the cache.
+	Missing bool `json:"missing,omitempty"`
+}
+
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// jobLinks are the fields of a job the graph is built from.
+type jobLinks struct {
+	name       string
+	disabled   bool
+	parents    []string
+	dependents []string
+	onFailure  string
+}
+
+// readLinks reads the links of every job of the cache, each under its lock.
+func readLinks(cache JobCache) map[string]jobLinks {
+	all := cache.GetAll()
+	all.Lock.RLock()
+	jobs := make([]*Job, 0, len(all.Jobs))
+	for _, j := range all.Jobs {
+		jobs = append(jobs, j)
+	}
+	all.Lock.RUnlock()
+
+	links := make(map[string]jobLinks, len(jobs))
+	for _, j := range jobs {
+		j.lock.RLock()
+		links[j.Id] = jobLinks{
+			name:       j.Name,
+			disabled:   j.Disabled,
+			parents:    append([]string(nil), j.ParentJobs...),
+			dependents: append([]string(nil), j.DependentJobs...),
+			onFailure:  j.OnFailureJob,
+		}
+		j.lock.RUnlock()
+	}
+	return links
+}
+
+// NewGraph returns the graph of the jobs of the cache which depend on or are
+// depended on by another job. Both the parent jobs of children and the
+// dependent jobs of parents make edges, so that the graph shows links which
+// only one of the jobs has.
+func NewGraph(cache JobCache) *Graph {
+	links := readLinks(cache)
+	return newGraph(links, graphEdges(links), nil)
+}
+
+// NewJobGraph returns the part of the graph of the jobs of the cache which is
+// linked to the job, through any number of edges in either direction.
+func NewJobGraph(cache JobCache, id string) (*Graph, error) {
+	links := readLinks(cache)
+	if _, ok := links[id]; !ok {
+		return nil, ErrJobDoesntExist
+	}
+	edges := graphEdges(links)
+
+	neighbors := map[string][]string{}
+	for _, e := range edges {
+		neighbors[e.From] = append(neighbors[e.From], e.To)
+		neighbors[e.To] = append(neighbors[e.To], e.From)
+	}
+	linked := map[string]bool{id: true}
+	for queue := []string{id}; len(queue) != 0; queue = queue[1:] {
+		for _, n := range neighbors[queue[0]] {
+			if !linked[n] {
+				linked[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	component := []*GraphEdge{}
+	for _, e := range edges {
+		if linked[e.From] {
+			component = append(component, e)
+		}
+	}
+	return newGraph(links, component, []string{id}), nil
+}
+
+func graphEdges(links map[string]jobLinks) []*GraphEdge {
+	edges := []*GraphEdge{}
+	seen := map[GraphEdge]bool{}
+	add := func(from, to, kind string) {
+		e := GraphEdge{From: from, To: to, Kind: kind}
+		if !seen[e] {
+			seen[e] = true
+			edges = append(edges, &e)
+		}
+	}
+	for id, l := range links {
+		for _, p := range l.parents {
+			add(p, id, DependentEdge)
+		}
+		for _, d := range l.dependents {
+			add(id, d, DependentEdge)
+		}
+		if l.onFailure != "" {
+			add(id, l.onFailure, OnFailureEdge)
+		}
+	}
+	sort.Slice(edges, func(i, k int) bool {
+		if edges[i].From != edges[k].From {
+			return edges[i].From < edges[k].From
+		}
+		if edges[i].To != edges[k].To {
+			return edges[i].To < edges[k].To
+		}
+		return edges[i].Kind < edges[k].Kind
+	})
+	return edges
+}
+
+// newGraph returns the graph of the edges, with a node for every job they
+// link and for the given ids.
+func newGraph(links map[string]jobLinks, edges []*GraphEdge, ids []string) *Graph {
+	g := &Graph{Nodes: []*GraphNode{}, Edges: edges}
+	seen := map[string]bool{}
+	addNode := func(id string) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		l, ok := links[id]
+		g.Nodes = append(g.Nodes, &GraphNode{Id: id, Name: l.name, Disabled: l.disabled, Missing: !ok})
+	}
+	for _, id := range ids {
+		addNode(id)
+	}
+	for _, e := range edges {
+		addNode(e.From)
+		addNode(e.To)
+	}
+	sort.Slice(g.Nodes, func(i, k int) bool { return g.Nodes[i].Id < g.Nodes[k].Id })
+	return g
+}
+
+// DOT returns the graph in the DOT language of Graphviz, e.g. to render it
+// with `dot -Tsvg`.
+func (g *Graph) DOT() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("digraph kala {\n")
+	for _, n := range g.Nodes {
+		switch {
+		case n.Missing:
+			fmt.Fprintf(buf, "  %s [label=%s, color=red];\n", strconv.Quote(n.Id), strconv.Quote(n.Id+" (missing)"))
+		case n.Disabled:
+			fmt.Fprintf(buf, "  %s [label=%s, style=dashed];\n", strconv.Quote(n.Id), strconv.Quote(n.Name))
+		default:
+			fmt.Fprintf(buf, "  %s [label=%s];\n", strconv.Quote(n.Id), strconv.Quote(n.Name))
+		}
+	}
+	for _, e := range g.Edges {
+		if e.Kind == OnFailureEdge {
+			fmt.Fprintf(buf, "  %s -> %s [style=dotted, label=\"on failure\"];\n", strconv.Quote(e.From), strconv.Quote(e.To))
+		} else {
+			fmt.Fprintf(buf, "  %s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
@@ -0,0 +1,63 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraph(t *testing.T) {
+	cache := NewMockCache()
+	add := func(id string, parents ...string) *Job {
+		j := GetMockJob()
+		j.Id, j.Name, j.ParentJobs = id, "job "+id, parents
+		assert.NoError(t, cache.Set(j))
+		for _, p := range parents {
+			if parent, _ := cache.Get(p); parent != nil {
+				parent.DependentJobs = append(parent.DependentJobs, id)
+			}
+		}
+		return j
+	}
+	add("a")
+	add("b", "a")
+	add("c", "b", "gone")
+	add("d").OnFailureJob = "e"
+	add("e").Disable()
+	add("lonely")
+
+	g := NewGraph(cache)
+	nodeIds := []string{}
+	for _, n := range g.Nodes {
+		nodeIds = append(nodeIds, n.Id)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "gone"}, nodeIds)
+	assert.True(t, g.Nodes[5].Missing)
+	assert.Equal(t, []*GraphEdge{
+		{From: "a", To: "b", Kind: DependentEdge},
+		{From: "b", To: "c", Kind: DependentEdge},
+		{From: "d", To: "e", Kind: OnFailureEdge},
+		{From: "gone", To: "c", Kind: DependentEdge},
+	}, g.Edges)
+
+	g, err := NewJobGraph(cache, "b")
+	assert.NoError(t, err)
+	assert.Len(t, g.Nodes, 4)
+	assert.Len(t, g.Edges, 3)
+
+	g, err = NewJobGraph(cache, "lonely")
+	assert.NoError(t, err)
+	assert.Len(t, g.Nodes, 1)
+	assert.Empty(t, g.Edges)
+
+	_, err = NewJobGraph(cache, "not-a-real-id")
+	assert.Equal(t, ErrJobDoesntExist, err)
+
+	g, _ = NewJobGraph(cache, "d")
+	assert.Equal(t, `digraph kala {
+  "d" [label="job d"];
+  "e" [label="job e", style=dashed];
+  "d" -> "e" [style=dotted, label="on failure"];
+}
+`, g.DOT())
+}
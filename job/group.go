@@ -0,0 +1,110 @@
+package job
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidGroup     = errors.New("Invalid group. Groups are names made of lowercase letters, digits, dashes and underscores, nested with dots, e.g. etl.nightly")
+	ErrGroupDoesntExist = errors.New("The group doesn't exist, no job is in it")
+
+	groupNamePattern = regexp.MustCompile(`^[a-z0-9][-_a-z0-9]*$`)
+)
+
+// ValidGroup says if g can be the group of a job. The empty group is valid,
+// for jobs of no group.
+func ValidGroup(g string) bool {
+	if g == "" {
+		return true
+	}
+	for _, name := range strings.Split(g, ".") {
+		if !groupNamePattern.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupPaths returns the groups a job of group g is in: g and the groups it's
+// nested in, e.g. "etl" and "etl.nightly" for "etl.nightly".
+func groupPaths(g string) []string {
+	if g == "" {
+		return nil
+	}
+	paths := []string{}
+	for i, c := range g {
+		if c == '.' {
+			paths = append(paths, g[:i])
+		}
+	}
+	return append(paths, g)
+}
+
+// InGroup says if a job of group g is in group, or in one of its subgroups.
+func InGroup(g, group string) bool {
+	return g == group || strings.HasPrefix(g, group+".")
+}
+
+// Group is a group of jobs, with the counts of the jobs in it.
+type Group struct {
+	Id string `json:"id"`
+	// Group the group is nested in, if any.
+	Parent string `json:"parent,omitempty"`
+	// Groups nested right in the group, ordered by id.
+	Subgroups []string `json:"subgroups"`
+
+	// Jobs right in the group, and in the group and its subgroups.
+	Jobs      int `json:"jobs"`
+	TotalJobs int `json:"total_jobs"`
+	// Disabled jobs in the group and its subgroups.
+	Disabled int `json:"disabled"`
+}
+
+// Groups returns the groups of the jobs and the groups they're nested in,
+// ordered by id.
+func Groups(jobs []*Job) []*Group {
+	groups := map[string]*Group{}
+	for _, j := range jobs {
+		j.lock.RLock()
+		g, disabled := j.Group, j.Disabled
+		j.lock.RUnlock()
+
+		parent := ""
+		for _, path := range groupPaths(g) {
+			group, ok := groups[path]
+			if !ok {
+				group = &Group{Id: path, Parent: parent, Subgroups: []string{}}
+				groups[path] = group
+				if parent != "" {
+					groups[parent].Subgroups = append(groups[parent].Subgroups, path)
+				}
+			}
+			group.TotalJobs++
+			if disabled {
+				group.Disabled++
+			}
+			if path == g {
+				group.Jobs++
+			}
+			parent = path
+		}
+	}
+
+	sorted := make([]*Group, 0, len(groups))
+	for _, group := range groups {
+		sort.Strings(group.Subgroups)
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, k int) bool { return sorted[i].Id < sorted[k].Id })
+	return sorted
+}
+
+// GroupJobs returns the jobs of the cache in the group or its subgroups,
+// ordered by id. Only the jobs of the namespace are returned, unless it's
+// empty.
+func GroupJobs(cache JobCache, group, namespace string) []*Job {
+	return cache.Find(JobFilter{Group: group, Namespace: namespace})
+}
@@ -0,0 +1,76 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidGroup(t *testing.T) {
+	for _, g := range []string{"", "etl", "etl.nightly", "etl.nightly.load_2", "web-api"} {
+		assert.True(t, ValidGroup(g), g)
+	}
+	for _, g := range []string{"ETL", ".etl", "etl.", "etl..nightly", "etl/nightly", "-etl"} {
+		assert.False(t, ValidGroup(g), g)
+	}
+}
+
+func TestGroupPaths(t *testing.T) {
+	assert.Nil(t, groupPaths(""))
+	assert.Equal(t, []string{"etl"}, groupPaths("etl"))
+	assert.Equal(t, []string{"etl", "etl.nightly", "etl.nightly.load"}, groupPaths("etl.nightly.load"))
+
+	assert.True(t, InGroup("etl.nightly", "etl"))
+	assert.True(t, InGroup("etl", "etl"))
+	assert.False(t, InGroup("etl-other", "etl"))
+	assert.False(t, InGroup("etl", "etl.nightly"))
+}
+
+func TestGroups(t *testing.T) {
+	jobs := []*Job{}
+	for _, g := range []string{"etl.nightly", "etl", "", "etl.nightly", "etl.hourly"} {
+		j := GetMockJob()
+		j.Group = g
+		jobs = append(jobs, j)
+	}
+	jobs[0].Disabled = true
+
+	groups := Groups(jobs)
+	assert.Len(t, groups, 3)
+	assert.Equal(t, &Group{Id: "etl", Subgroups: []string{"etl.hourly", "etl.nightly"}, Jobs: 1, TotalJobs: 4, Disabled: 1}, groups[0])
+	assert.Equal(t, &Group{Id: "etl.hourly", Parent: "etl", Subgroups: []string{}, Jobs: 1, TotalJobs: 1}, groups[1])
+	assert.Equal(t, &Group{Id: "etl.nightly", Parent: "etl", Subgroups: []string{}, Jobs: 2, TotalJobs: 2, Disabled: 1}, groups[2])
+}
+
+func TestGroupJobs(t *testing.T) {
+	cache := NewMockCache()
+	ids := map[string]string{}
+	for _, g := range []string{"etl", "etl.nightly", "etl-other", "web"} {
+		j := GetMockJobWithGenericSchedule()
+		j.Group = g
+		assert.NoError(t, j.Init(cache))
+		ids[g] = j.Id
+	}
+
+	found := []string{}
+	for _, j := range GroupJobs(cache, "etl", "") {
+		found = append(found, j.Group)
+	}
+	assert.Len(t, found, 2)
+	assert.Contains(t, found, "etl")
+	assert.Contains(t, found, "etl.nightly")
+	assert.Len(t, GroupJobs(cache, "etl.nightly", ""), 1)
+	assert.Empty(t, GroupJobs(cache, "etl", "data"))
+
+	q, err := ParseQuery(`group="etl"`)
+	assert.NoError(t, err)
+	assert.Len(t, cache.Search(q), 2)
+
+	// Moving a job to another group indexes it again.
+	j, err := cache.Get(ids["etl.nightly"])
+	assert.NoError(t, err)
+	j.Group = "web"
+	cache.Set(j)
+	assert.Len(t, GroupJobs(cache, "etl", ""), 1)
+	assert.Len(t, GroupJobs(cache, "web", ""), 2)
+}
@@ -0,0 +1,163 @@
+package job
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/utils/grpcclient"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	ErrInvalidGRPCJob = errors.New("Invalid gRPC Job. gRPC Job's must contain a Name, a target, a method like package.Service/Method and a valid request template")
+	// ErrDescriptorSetNotAllowed is returned for gRPC jobs with a descriptor
+	// set out of the descriptor sets directory, see SetDescriptorSetsDir.
+	ErrDescriptorSetNotAllowed = errors.New("Invalid descriptor set. gRPC Jobs may only use the descriptor sets in the descriptor sets directory of the server")
+
+	descriptorSetsLock sync.RWMutex
+	descriptorSetsDir  string
+)
+
+// SetDescriptorSetsDir sets the directory of the descriptor sets gRPC jobs
+// may use, none if it's empty.
+func SetDescriptorSetsDir(dir string) {
+	descriptorSetsLock.Lock()
+	defer descriptorSetsLock.Unlock()
+	descriptorSetsDir = dir
+}
+
+// GRPCProperties Custom properties for the gRPC job type, which makes a unary
+// gRPC call on every run. The run fails unless the call returns OK.
+type GRPCProperties struct {
+	// e.g. "reports.example.com:443"
+	Target string `json:"target"`
+
+	// e.g. "reports.v1.Reports/Generate"
+	Method string `json:"method"`
+
+	// A template of the request message in JSON, see TemplateContext.
+	Request string `json:"request"`
+
+	// Metadata to send with the call (e.g. {"authorization": "Bearer ..."})
+	Metadata map[string]string `json:"metadata"`
+
+	// Path of a FileDescriptorSet describing the method, written by
+	// "protoc --include_imports --descriptor_set_out", in the descriptor sets
+	// directory, relative to it unless it's absolute. If empty, the method is
+	// looked up with server reflection.
+	DescriptorSet string `json:"descriptor_set"`
+
+	// Whether to connect with TLS, and whether to skip verifying the server's certificate.
+	TLS                bool `json:"tls"`
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+
+	// A deadline for the call in seconds
+	Timeout int `json:"timeout"`
+}
+
+func (p *GRPCProperties) valid() bool {
+	_, _, ok := grpcclient.SplitMethod(p.Method)
+	return p.Target != "" && ok && validTemplate(p.Request)
+}
+
+// descriptorSet returns the path of the descriptor set, if there is one, or
+// ErrDescriptorSetNotAllowed if it's out of the descriptor sets directory.
+func (p *GRPCProperties) descriptorSet() (string, error) {
+	if p.DescriptorSet == "" {
+		return "", nil
+	}
+	descriptorSetsLock.RLock()
+	dir := descriptorSetsDir
+	descriptorSetsLock.RUnlock()
+	path, ok := fileInDir(dir, p.DescriptorSet)
+	if !ok {
+		return "", ErrDescriptorSetNotAllowed
+	}
+	return path, nil
+}
+
+func (p *GRPCProperties) allowedDescriptorSet() bool {
+	_, err := p.descriptorSet()
+	return err == nil
+}
+
+// GRPCRun makes the job's gRPC call.
+func (j *JobRunner) GRPCRun() error {
+	props := j.job.GRPCProperties
+	timeout := time.Duration(props.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(j.runContext(), timeout)
+	defer cancel()
+
+	var config *tls.Config
+	if props.TLS {
+		config = &tls.Config{InsecureSkipVerify: props.InsecureSkipVerify}
+	}
+	conn, err := grpcclient.NewConn(props.Target, config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	service, method, _ := grpcclient.SplitMethod(props.Method)
+	descriptorSet, err := props.descriptorSet()
+	if err != nil {
+		return err
+	}
+	var files *protoregistry.Files
+	if descriptorSet != "" {
+		files, err = grpcclient.LoadDescriptorSet(descriptorSet)
+	} else {
+		files, err = conn.Reflect(ctx, service)
+	}
+	if err != nil {
+		return err
+	}
+	md, err := grpcclient.FindMethod(files, service, method)
+	if err != nil {
+		return err
+	}
+	types := dynamicpb.NewTypes(files)
+
+	body, err := j.render(props.Request)
+	if err != nil {
+		return err
+	}
+	request := dynamicpb.NewMessage(md.Input())
+	if body != "" {
+		if err := (protojson.UnmarshalOptions{Resolver: types}).Unmarshal([]byte(body), request); err != nil {
+			return err
+		}
+	}
+	b, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	b, err = conn.Invoke(ctx, "/"+service+"/"+method, b, props.Metadata)
+	if err != nil {
+		return err
+	}
+	response := dynamicpb.NewMessage(md.Output())
+	if err := (proto.UnmarshalOptions{Resolver: types}).Unmarshal(b, response); err != nil {
+		return err
+	}
+	output, err := (protojson.MarshalOptions{Resolver: types}).Marshal(response)
+	if err != nil {
+		return err
+	}
+	if len(output) > MaxOutputSize {
+		output = output[:MaxOutputSize]
+	}
+	j.output = string(output)
+	return nil
+}
@@ -0,0 +1,140 @@
+package job
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func GetMockGRPCJob(props GRPCProperties) *Job {
+	return &Job{
+		Name:           "mock_grpc_job",
+		JobType:        GRPCJob,
+		GRPCProperties: props,
+	}
+}
+
+func TestGRPCJobValidation(t *testing.T) {
+	cache := NewMockCache()
+
+	j := GetMockGRPCJob(GRPCProperties{Target: "localhost:50051", Method: "Generate"})
+	assert.Equal(t, ErrInvalidGRPCJob, j.Init(cache))
+
+	j = GetMockGRPCJob(GRPCProperties{Method: "reports.Reports/Generate"})
+	assert.Equal(t, ErrInvalidGRPCJob, j.Init(cache))
+
+	j = GetMockGRPCJob(GRPCProperties{Target: "localhost:50051", Method: "reports.Reports/Generate"})
+	j.Schedule = "R/2100-01-01T00:00:00Z/PT1H"
+	assert.NoError(t, j.Init(cache))
+}
+
+func TestGRPCJobDescriptorSet(t *testing.T) {
+	cache := NewMockCache()
+	props := GRPCProperties{Target: "localhost:50051", Method: "reports.Reports/Generate", DescriptorSet: "/etc/passwd"}
+
+	// Without a descriptor sets directory, jobs can't use descriptor sets.
+	assert.Equal(t, ErrDescriptorSetNotAllowed, GetMockGRPCJob(props).Init(cache))
+
+	SetDescriptorSetsDir("/var/lib/kala/descriptors")
+	defer SetDescriptorSetsDir("")
+	for _, path := range []string{"/etc/passwd", "../passwd", "/var/lib/kala/descriptors/../passwd"} {
+		props.DescriptorSet = path
+		assert.Equal(t, ErrDescriptorSetNotAllowed, GetMockGRPCJob(props).Init(cache), path)
+	}
+	for _, path := range []string{"reports.pb", "/var/lib/kala/descriptors/reports.pb"} {
+		props.DescriptorSet = path
+		j := GetMockGRPCJob(props)
+		j.Schedule = "R/2100-01-01T00:00:00Z/PT1H"
+		assert.NoError(t, j.Init(cache), path)
+	}
+}
+
+// writeReportsDescriptorSet describes reports.Reports/Generate(Request{string
+// job = 1}) returns (Response{string job = 1}).
+func writeReportsDescriptorSet(t *testing.T, dir string) string {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("job"),
+		JsonName: proto.String("job"),
+		Number:   proto.Int32(1),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("reports.proto"),
+		Package: proto.String("reports"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Request"), Field: []*descriptorpb.FieldDescriptorProto{field}},
+			{Name: proto.String("Response"), Field: []*descriptorpb.FieldDescriptorProto{field}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Reports"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Generate"),
+				InputType:  proto.String(".reports.Request"),
+				OutputType: proto.String(".reports.Response"),
+			}},
+		}},
+	}
+	b, _ := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	path := filepath.Join(dir, "reports.pb")
+	assert.NoError(t, ioutil.WriteFile(path, b, 0644))
+	return path
+}
+
+func TestGRPCRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-grpc")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	SetDescriptorSetsDir(dir)
+	defer SetDescriptorSetsDir("")
+
+	// Echoes requests, which have the same fields as responses.
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/reports.Reports/Generate", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+
+		// Fail for requests without a job.
+		if _, _, n := protowire.ConsumeTag(body[5:]); n < 0 {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "no such job")
+			return
+		}
+		w.Write(body)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	j := GetMockGRPCJob(GRPCProperties{
+		Target:             strings.TrimPrefix(ts.URL, "https://"),
+		Method:             "reports.Reports/Generate",
+		Request:            `{"job": "{{.JobName}}"}`,
+		Metadata:           map[string]string{"x-api-key": "secret"},
+		DescriptorSet:      writeReportsDescriptorSet(t, dir),
+		TLS:                true,
+		InsecureSkipVerify: true,
+	})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.GRPCRun())
+	var output map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(runner.output), &output))
+	assert.Equal(t, map[string]string{"job": "mock_grpc_job"}, output)
+
+	j.GRPCProperties.Request = ""
+	assert.EqualError(t, runner.GRPCRun(), "gRPC call failed with NOT_FOUND: no such job")
+}
@@ -0,0 +1,116 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidHandlerJob = errors.New("Invalid Handler Job. Handler Job's must contain a Name, the name of a handler and valid params templates")
+	ErrUnknownHandler    = errors.New("The Handler Job's handler isn't registered. Register it with RegisterHandler")
+)
+
+// HandlerFunc is a Go function run by handler jobs, with the rendered params
+// of the job. The run fails if it returns an error, and is retried like the
+// runs of other jobs. ctx is done once the run times out.
+type HandlerFunc func(ctx context.Context, params map[string]string) error
+
+// HandlerProperties Custom properties for the handler job type, which calls a
+// Go function registered with RegisterHandler in the process running Kala,
+// e.g. an application embedding it, on every run.
+type HandlerProperties struct {
+	// Name the handler is registered under, e.g. "cleanup".
+	Handler string `json:"handler"`
+
+	// Params passed to the handler. The values are templates, see
+	// TemplateContext.
+	Params map[string]string `json:"params"`
+
+	// A timeout for the run in seconds, none if zero.
+	Timeout int `json:"timeout"`
+}
+
+func (p *HandlerProperties) valid() bool {
+	if p.Handler == "" || p.Timeout < 0 {
+		return false
+	}
+	for _, value := range p.Params {
+		if !validTemplate(value) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	handlers     = map[string]HandlerFunc{}
+	handlersLock sync.RWMutex
+)
+
+// RegisterHandler makes a Go function available to handler jobs by name. Like
+// RegisterDriver, it panics if the handler is nil or a handler is already
+// registered with the name. Handlers are registered before the jobs calling
+// them are loaded, else their runs fail with ErrUnknownHandler.
+func RegisterHandler(name string, handler HandlerFunc) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	if handler == nil {
+		panic("job: RegisterHandler handler is nil")
+	}
+	if _, dup := handlers[name]; dup {
+		panic("job: RegisterHandler called twice for handler " + name)
+	}
+	handlers[name] = handler
+}
+
+// Handlers returns the sorted names of the registered handlers.
+func Handlers() []string {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getHandler(name string) HandlerFunc {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+	return handlers[name]
+}
+
+// HandlerRun calls the job's handler. A handler which panics fails the run
+// rather than Kala.
+func (j *JobRunner) HandlerRun() (err error) {
+	props := j.job.HandlerProperties
+	handler := getHandler(props.Handler)
+	if handler == nil {
+		return ErrUnknownHandler
+	}
+
+	params := make(map[string]string, len(props.Params))
+	for key, value := range props.Params {
+		if params[key], err = j.render(value); err != nil {
+			return err
+		}
+	}
+
+	ctx := j.runContext()
+	if props.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(props.Timeout)*time.Second)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("The handler %s panicked: %v", props.Handler, r)
+		}
+	}()
+	return handler(ctx, params)
+}
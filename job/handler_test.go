@@ -0,0 +1,83 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func GetMockHandlerJob(handler string, params map[string]string) *Job {
+	return &Job{
+		Name:    "mock_handler_job",
+		JobType: HandlerJob,
+		HandlerProperties: HandlerProperties{
+			Handler: handler,
+			Params:  params,
+		},
+	}
+}
+
+func TestHandlerJobValidation(t *testing.T) {
+	cache := NewMockCache()
+	RegisterHandler("test-validation", func(ctx context.Context, params map[string]string) error {
+		return nil
+	})
+
+	assert.Equal(t, ErrInvalidHandlerJob, GetMockHandlerJob("", nil).Init(cache))
+	assert.Equal(t, ErrInvalidHandlerJob, GetMockHandlerJob("test-validation", map[string]string{"day": "{{.Time"}).Init(cache))
+	assert.Equal(t, ErrUnknownHandler, GetMockHandlerJob("missing", nil).Init(cache))
+	assert.Contains(t, Handlers(), "test-validation")
+
+	assert.Panics(t, func() {
+		RegisterHandler("test-validation", func(ctx context.Context, params map[string]string) error {
+			return nil
+		})
+	})
+}
+
+func TestHandlerRun(t *testing.T) {
+	var got map[string]string
+	RegisterHandler("test-cleanup", func(ctx context.Context, params map[string]string) error {
+		got = params
+		if params["fail"] == "true" {
+			return errors.New("disk busy")
+		}
+		return nil
+	})
+	RegisterHandler("test-panic", func(ctx context.Context, params map[string]string) error {
+		panic("nil map")
+	})
+	RegisterHandler("test-timeout", func(ctx context.Context, params map[string]string) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	j := GetMockHandlerJob("test-cleanup", map[string]string{"job": "{{.JobName}}"})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.HandlerRun())
+	assert.Equal(t, map[string]string{"job": "mock_handler_job"}, got)
+
+	j.HandlerProperties.Params["fail"] = "true"
+	assert.EqualError(t, runner.HandlerRun(), "disk busy")
+
+	runner = &JobRunner{job: GetMockHandlerJob("test-panic", nil)}
+	runner.runSetup()
+	assert.EqualError(t, runner.HandlerRun(), "The handler test-panic panicked: nil map")
+
+	j = GetMockHandlerJob("test-timeout", nil)
+	j.HandlerProperties.Timeout = 1
+	runner = &JobRunner{job: j}
+	runner.runSetup()
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, runner.HandlerRun())
+	assert.WithinDuration(t, start.Add(time.Second), time.Now(), 500*time.Millisecond)
+
+	// Jobs loaded before their handler is registered fail to run.
+	runner = &JobRunner{job: GetMockHandlerJob("missing", nil)}
+	runner.runSetup()
+	assert.Equal(t, ErrUnknownHandler, runner.HandlerRun())
+}
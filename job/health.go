@@ -0,0 +1,247 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/metrics"
+)
+
+var (
+	// HealthCheckTimeout bounds every health check of a HealthCheckedDB,
+	// including reopening the db.
+	HealthCheckTimeout = 5 * time.Second
+
+	// Bounds of the backoff between attempts to reconnect to an unhealthy
+	// db.
+	reconnectMin = time.Second
+	reconnectMax = time.Minute
+)
+
+// healthCheckId is the id of the job looked up to check the health of JobDBs
+// which aren't PingableDBs. No job has it, as ids are uuids or ValidIds.
+const healthCheckId = "kala health check"
+
+// PingableDB is implemented by JobDBs which check their connection to the
+// database cheaply, e.g. with a PING. The health of other JobDBs is checked by
+// looking up a job which doesn't exist.
+type PingableDB interface {
+	Ping(ctx context.Context) error
+}
+
+// UnwrapDB returns the JobDB wrapped by db, e.g. by a HealthCheckedDB, or db
+// itself, to check which optional interfaces of JobDBs it implements, such as
+// BatchDB or CompactableDB.
+func UnwrapDB(db JobDB) JobDB {
+	if wrapper, ok := db.(interface {
+		Unwrap() JobDB
+	}); ok {
+		return UnwrapDB(wrapper.Unwrap())
+	}
+	return db
+}
+
+// DBHealth is the status of the connection to the db, as of its last health
+// check.
+type DBHealth struct {
+	Healthy     bool       `json:"healthy"`
+	LastCheckAt *time.Time `json:"last_check_at,omitempty"`
+
+	// Checks which failed since the db was last healthy, and the error of the
+	// last one.
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	UnhealthySince      *time.Time `json:"unhealthy_since,omitempty"`
+
+	Reconnects int `json:"reconnects"`
+}
+
+// HealthCheckedDB is a JobDB which checks the health of the db it wraps and,
+// while it's unhealthy, reopens it with backoff, rather than every persist
+// cycle failing until Kala is restarted.
+type HealthCheckedDB struct {
+	open func() (JobDB, error)
+
+	lock   sync.RWMutex
+	db     JobDB
+	health DBHealth
+	stop   chan struct{}
+}
+
+// NewHealthCheckedDB wraps db, which is reopened with open once a health
+// check fails. db is healthy until it's checked.
+func NewHealthCheckedDB(db JobDB, open func() (JobDB, error)) *HealthCheckedDB {
+	return &HealthCheckedDB{
+		open:   open,
+		db:     db,
+		health: DBHealth{Healthy: true},
+	}
+}
+
+// Start checks the health of the db every interval, and retries reconnecting
+// to it sooner, with backoff, while it's unhealthy. Checks stop once the db is
+// closed.
+func (h *HealthCheckedDB) Start(every time.Duration) {
+	h.lock.Lock()
+	if h.stop != nil {
+		h.lock.Unlock()
+		return
+	}
+	h.stop = make(chan struct{})
+	stop := h.stop
+	h.lock.Unlock()
+
+	go func() {
+		wait := every
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+			health := h.Check(context.Background())
+			wait = every
+			if !health.Healthy {
+				wait = reconnectBackoff(health.ConsecutiveFailures)
+				if wait > every {
+					wait = every
+				}
+			}
+		}
+	}()
+}
+
+// reconnectBackoff returns how long to wait before reconnecting after the
+// given number of failed checks.
+func reconnectBackoff(failures int) time.Duration {
+	wait := reconnectMin
+	for i := 1; i < failures && wait < reconnectMax; i++ {
+		wait *= 2
+	}
+	if wait > reconnectMax {
+		wait = reconnectMax
+	}
+	return wait
+}
+
+// Check checks the health of the db, reopening it if it's unhealthy, and
+// returns its health.
+func (h *HealthCheckedDB) Check(ctx context.Context) DBHealth {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+
+	err := ping(ctx, h.Unwrap())
+	reconnected := false
+	if err != nil && h.open != nil {
+		dbLog.Errorf("Error occured checking the health of the job database, reconnecting: %s", err)
+		var db JobDB
+		db, err = h.open()
+		if err == nil {
+			h.swap(db)
+			reconnected = true
+			err = ping(ctx, db)
+		}
+	}
+
+	now := time.Now()
+	h.lock.Lock()
+	h.health.LastCheckAt = &now
+	if reconnected {
+		h.health.Reconnects++
+	}
+	if err != nil {
+		if h.health.Healthy {
+			h.health.UnhealthySince = &now
+		}
+		h.health.Healthy = false
+		h.health.ConsecutiveFailures++
+		h.health.LastError = err.Error()
+	} else {
+		if !h.health.Healthy {
+			dbLog.Infof("The job database is healthy again after %d failed checks", h.health.ConsecutiveFailures)
+		}
+		h.health.Healthy = true
+		h.health.ConsecutiveFailures = 0
+		h.health.LastError = ""
+		h.health.UnhealthySince = nil
+	}
+	health := h.health
+	h.lock.Unlock()
+
+	metrics.RecordDBHealth(err == nil, reconnected)
+	if err != nil {
+		dbLog.Errorf("Error occured checking the health of the job database: %s", err)
+	}
+	return health
+}
+
+// ping checks the connection to the db.
+func ping(ctx context.Context, db JobDB) error {
+	if pingable, ok := db.(PingableDB); ok {
+		return pingable.Ping(ctx)
+	}
+	if _, err := db.Get(ctx, healthCheckId); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// swap replaces the db by the reopened db, and closes it.
+func (h *HealthCheckedDB) swap(db JobDB) {
+	h.lock.Lock()
+	old := h.db
+	h.db = db
+	h.lock.Unlock()
+
+	if err := old.Close(); err != nil {
+		dbLog.Errorf("Error occured closing the unhealthy job database: %s", err)
+	}
+}
+
+// Health returns the health of the db as of its last check.
+func (h *HealthCheckedDB) Health() DBHealth {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.health
+}
+
+// Unwrap returns the db currently wrapped, which changes when it's reopened.
+func (h *HealthCheckedDB) Unwrap() JobDB {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.db
+}
+
+func (h *HealthCheckedDB) GetAll(ctx context.Context) ([]*Job, error) {
+	return h.Unwrap().GetAll(ctx)
+}
+
+func (h *HealthCheckedDB) Get(ctx context.Context, id string) (*Job, error) {
+	return h.Unwrap().Get(ctx, id)
+}
+
+func (h *HealthCheckedDB) Delete(ctx context.Context, id string) error {
+	return h.Unwrap().Delete(ctx, id)
+}
+
+func (h *HealthCheckedDB) Save(ctx context.Context, job *Job) error {
+	return h.Unwrap().Save(ctx, job)
+}
+
+func (h *HealthCheckedDB) SaveAll(ctx context.Context, jobs []*Job) error {
+	return h.Unwrap().SaveAll(ctx, jobs)
+}
+
+// Close stops the health checks and closes the db.
+func (h *HealthCheckedDB) Close() error {
+	h.lock.Lock()
+	if h.stop != nil {
+		close(h.stop)
+	}
+	// Checks aren't started again once closed.
+	h.stop = make(chan struct{})
+	db := h.db
+	h.lock.Unlock()
+	return db.Close()
+}
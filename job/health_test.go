@@ -0,0 +1,74 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// unreachableDB is a db whose connection was lost.
+type unreachableDB struct {
+	MockDB
+	closed bool
+}
+
+func (d *unreachableDB) Get(ctx context.Context, id string) (*Job, error) {
+	return nil, errors.New("connection reset by peer")
+}
+
+func (d *unreachableDB) Close() error {
+	d.closed = true
+	return nil
+}
+
+func TestHealthCheckedDB(t *testing.T) {
+	down := &unreachableDB{}
+	db := NewHealthCheckedDB(down, nil)
+	assert.True(t, db.Health().Healthy)
+
+	health := db.Check(context.Background())
+	assert.False(t, health.Healthy)
+	assert.Equal(t, 1, health.ConsecutiveFailures)
+	assert.Equal(t, "connection reset by peer", health.LastError)
+	assert.NotNil(t, health.UnhealthySince)
+	assert.Equal(t, 2, db.Check(context.Background()).ConsecutiveFailures)
+
+	// The db is reopened once it's unhealthy.
+	reopened := &MockDB{}
+	db.open = func() (JobDB, error) {
+		return reopened, nil
+	}
+	health = db.Check(context.Background())
+	assert.True(t, health.Healthy)
+	assert.Equal(t, 0, health.ConsecutiveFailures)
+	assert.Equal(t, 1, health.Reconnects)
+	assert.Nil(t, health.UnhealthySince)
+	assert.True(t, down.closed)
+	assert.Equal(t, reopened, db.Unwrap())
+
+	// Healthy dbs aren't reopened.
+	assert.Equal(t, 1, db.Check(context.Background()).Reconnects)
+
+	db.open = func() (JobDB, error) {
+		return nil, errors.New("no route to host")
+	}
+	db.db = &unreachableDB{}
+	health = db.Check(context.Background())
+	assert.False(t, health.Healthy)
+	assert.Equal(t, "no route to host", health.LastError)
+}
+
+func TestUnwrapDB(t *testing.T) {
+	inner := &MockDB{}
+	assert.Equal(t, inner, UnwrapDB(inner))
+	assert.Equal(t, inner, UnwrapDB(NewHealthCheckedDB(inner, nil)))
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, reconnectBackoff(1))
+	assert.Equal(t, 4*time.Second, reconnectBackoff(3))
+	assert.Equal(t, time.Minute, reconnectBackoff(100))
+}
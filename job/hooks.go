@@ -0,0 +1,135 @@
+package job
+
+import (
+	"sync"
+)
+
+// Hooks lets subsystems such as notifiers, event buses or audit logs follow
+// the changes of the jobs in a cache, without the cache importing them.
+//
+// Hooks are called after the change, in the order of the changes and one at a
+// time, from a goroutine of the cache. Since no lock of the cache is held then,
+// hooks may read the job, e.g. with Copy, but a slow hook delays the next ones.
+type Hooks interface {
+	// OnSet registers a function called with the jobs added to the cache or
+	// replaced with Set. Jobs loaded from the db when the cache starts aren't
+	// changes, and aren't passed to it.
+	OnSet(f func(j *Job))
+	// OnDelete registers a function called with the jobs deleted from the
+	// cache.
+	OnDelete(f func(j *Job))
+	// OnRunComplete registers a function called after each run of a job in
+	// the cache, with the stat of the run and its error, if it failed.
+	OnRunComplete(f func(j *Job, stat *JobStat, err error))
+}
+
+// cacheHooks are the hooks registered on a cache, and the queue of the calls
+// waiting to be made.
+type cacheHooks struct {
+	lock        sync.Mutex
+	set         []func(*Job)
+	deleted     []func(*Job)
+	runComplete []func(*Job, *JobStat, error)
+
+	// Calls are made by a goroutine running while the queue isn't empty.
+	queue   []func()
+	running bool
+}
+
+func newCacheHooks() *cacheHooks {
+	return &cacheHooks{}
+}
+
+func (h *cacheHooks) OnSet(f func(j *Job)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.set = append(h.set, f)
+}
+
+func (h *cacheHooks) OnDelete(f func(j *Job)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.deleted = append(h.deleted, f)
+}
+
+func (h *cacheHooks) OnRunComplete(f func(j *Job, stat *JobStat, err error)) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.runComplete = append(h.runComplete, f)
+}
+
+func (h *cacheHooks) jobSet(j *Job) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, f := range h.set {
+		f := f
+		h.enqueue(func() { f(j) })
+	}
+}
+
+func (h *cacheHooks) jobDeleted(j *Job) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, f := range h.deleted {
+		f := f
+		h.enqueue(func() { f(j) })
+	}
+}
+
+func (h *cacheHooks) jobRan(j *Job, stat *JobStat, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, f := range h.runComplete {
+		f := f
+		h.enqueue(func() { f(j, stat, err) })
+	}
+}
+
+// enqueue queues a call, starting the goroutine making them if it isn't
+// running. The lock must be held.
+func (h *cacheHooks) enqueue(call func()) {
+	h.queue = append(h.queue, call)
+	if !h.running {
+		h.running = true
+		go h.drain()
+	}
+}
+
+func (h *cacheHooks) drain() {
+	for {
+		h.lock.Lock()
+		if len(h.queue) == 0 {
+			h.running = false
+			h.lock.Unlock()
+			return
+		}
+		call := h.queue[0]
+		h.queue[0] = nil
+		h.queue = h.queue[1:]
+		h.lock.Unlock()
+
+		h.call(call)
+	}
+}
+
+// call makes a call, logging instead of crashing if the hook panics.
+func (h *cacheHooks) call(call func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			cacheLog.Errorf("Error occured in a hook of the cache: %v", r)
+		}
+	}()
+	call()
+}
+
+// hookedCache is a cache with hooks, which are called by the jobs it runs.
+type hookedCache interface {
+	hooks() *cacheHooks
+}
+
+// runCompleted calls the OnRunComplete hooks of the cache, if it has any.
+func runCompleted(cache JobCache, j *Job, stat *JobStat, err error) {
+	if c, ok := cache.(hookedCache); ok {
+		c.hooks().jobRan(j, stat, err)
+	}
+}
@@ -0,0 +1,45 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheHooks(t *testing.T) {
+	for _, cache := range []JobCache{NewMockCache(), NewMemoryJobCache(&MockDB{})} {
+		events := make(chan string, 10)
+		cache.OnSet(func(j *Job) {
+			// Hooks may lock the job.
+			events <- "set " + j.Copy().Id
+		})
+		cache.OnDelete(func(j *Job) {
+			events <- "delete " + j.Id
+		})
+		cache.OnRunComplete(func(j *Job, stat *JobStat, err error) {
+			assert.Equal(t, j.Id, stat.JobId)
+			assert.Error(t, err)
+			events <- "run " + j.Id
+		})
+		cache.OnSet(func(j *Job) {
+			panic("A hook panicking doesn't stop the others")
+		})
+
+		j := GetMockFailingJob()
+		j.Retries = 0
+		j.Id = "hooked"
+		assert.NoError(t, cache.Set(j))
+		j.Run(cache)
+		assert.NoError(t, cache.Delete(j.Id))
+
+		for _, expected := range []string{"set hooked", "run hooked", "delete hooked"} {
+			select {
+			case e := <-events:
+				assert.Equal(t, expected, e)
+			case <-time.After(time.Second):
+				t.Fatalf("Hook not called: %s", expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package job
+
+import (
+	"sort"
+	"sync"
+)
+
+// JobFilter selects jobs by their owner, name, tag, namespace and group.
+// Empty fields match all jobs. A group matches the jobs of its subgroups too.
+type JobFilter struct {
+	Owner     string
+	Name      string
+	Tag       string
+	Namespace string
+	Group     string
+}
+
+func (f JobFilter) empty() bool {
+	return f.Owner == "" && f.Name == "" && f.Tag == "" && f.Namespace == "" && f.Group == ""
+}
+
+type idSet map[string]struct{}
+
+// jobIndex maps the owners, names, tags, namespaces and groups of the jobs in a cache to their
+// ids, so that jobs are found without scanning the cache. Names aren't unique,
+// so they map to several ids too.
+type jobIndex struct {
+	lock   sync.RWMutex
+	owners map[string]idSet
+	names  map[string]idSet
+	tags   map[string]idSet
+	// Jobs of no namespace aren't indexed.
+	namespaces map[string]idSet
+	// Jobs are indexed under their group and the groups it's nested in.
+	groups map[string]idSet
+
+	// The indexed fields of every job, to remove them when the job is
+	// removed or indexed again.
+	entries map[string]indexEntry
+}
+
+type indexEntry struct {
+	owner     string
+	name      string
+	tags      []string
+	namespace string
+	group     string
+}
+
+func newJobIndex() *jobIndex {
+	return &jobIndex{
+		owners:     map[string]idSet{},
+		names:      map[string]idSet{},
+		tags:       map[string]idSet{},
+		namespaces: map[string]idSet{},
+		groups:     map[string]idSet{},
+		entries:    map[string]indexEntry{},
+	}
+}
+
+// add indexes the job, replacing the fields it was indexed with before. The
+// fields are read without the job's lock, which the cache may be called
+// with. They aren't changed by runs.
+func (x *jobIndex) add(j *Job) {
+	entry := indexEntry{
+		owner:     j.Owner,
+		name:      j.Name,
+		tags:      append([]string(nil), j.Tags...),
+		namespace: j.Namespace,
+		group:     j.Group,
+	}
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.removeLocked(j.Id)
+	x.entries[j.Id] = entry
+	addId(x.owners, entry.owner, j.Id)
+	addId(x.names, entry.name, j.Id)
+	for _, tag := range entry.tags {
+		addId(x.tags, tag, j.Id)
+	}
+	if entry.namespace != "" {
+		addId(x.namespaces, entry.namespace, j.Id)
+	}
+	for _, group := range groupPaths(entry.group) {
+		addId(x.groups, group, j.Id)
+	}
+}
+
+func (x *jobIndex) remove(id string) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	x.removeLocked(id)
+}
+
+func (x *jobIndex) removeLocked(id string) {
+	entry, ok := x.entries[id]
+	if !ok {
+		return
+	}
+	delete(x.entries, id)
+	removeId(x.owners, entry.owner, id)
+	removeId(x.names, entry.name, id)
+	for _, tag := range entry.tags {
+		removeId(x.tags, tag, id)
+	}
+	removeId(x.namespaces, entry.namespace, id)
+	for _, group := range groupPaths(entry.group) {
+		removeId(x.groups, group, id)
+	}
+}
+
+func addId(index map[string]idSet, key, id string) {
+	ids, ok := index[key]
+	if !ok {
+		ids = idSet{}
+		index[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func removeId(index map[string]idSet, key, id string) {
+	if ids, ok := index[key]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(index, key)
+		}
+	}
+}
+
+// find returns the sorted ids of the jobs matching the filter, which mustn't
+// be empty.
+func (x *jobIndex) find(f JobFilter) []string {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	sets := []idSet{}
+	if f.Owner != "" {
+		sets = append(sets, x.owners[f.Owner])
+	}
+	if f.Name != "" {
+		sets = append(sets, x.names[f.Name])
+	}
+	if f.Tag != "" {
+		sets = append(sets, x.tags[f.Tag])
+	}
+	if f.Namespace != "" {
+		sets = append(sets, x.namespaces[f.Namespace])
+	}
+	if f.Group != "" {
+		sets = append(sets, x.groups[f.Group])
+	}
+	// Intersect the smallest set with the others.
+	sort.Slice(sets, func(i, k int) bool { return len(sets[i]) < len(sets[k]) })
+	ids := []string{}
+	for id := range sets[0] {
+		matches := true
+		for _, set := range sets[1:] {
+			if _, ok := set[id]; !ok {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// findJobs returns the jobs of the cache matching the filter, ordered by id,
+// using its index unless the filter is empty.
+func findJobs(cache JobCache, index *jobIndex, f JobFilter) []*Job {
+	jobs := []*Job{}
+	if f.empty() {
+		for _, j := range cache.GetAll().Jobs {
+			jobs = append(jobs, j)
+		}
+		sort.Slice(jobs, func(i, k int) bool { return jobs[i].Id < jobs[k].Id })
+		return jobs
+	}
+	for _, id := range index.find(f) {
+		if j, _ := cache.Get(id); j != nil {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
@@ -0,0 +1,52 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ids(jobs []*Job) []string {
+	result := []string{}
+	for _, j := range jobs {
+		result = append(result, j.Id)
+	}
+	return result
+}
+
+func TestCacheFind(t *testing.T) {
+	for _, cache := range []JobCache{NewMockCache(), NewMemoryJobCache(&MockDB{})} {
+		add := func(id, owner, name string, tags ...string) *Job {
+			j := GetMockJob()
+			j.Id, j.Owner, j.Name, j.Tags = id, owner, name, tags
+			assert.NoError(t, cache.Set(j))
+			return j
+		}
+		add("a", "ops@example.com", "backup", "nightly", "db")
+		add("b", "ops@example.com", "cleanup", "nightly")
+		add("c", "dev@example.com", "backup", "db")
+
+		assert.Equal(t, []string{"a", "b", "c"}, ids(cache.Find(JobFilter{})))
+		assert.Equal(t, []string{"a", "b"}, ids(cache.Find(JobFilter{Owner: "ops@example.com"})))
+		assert.Equal(t, []string{"a", "c"}, ids(cache.Find(JobFilter{Name: "backup"})))
+		assert.Equal(t, []string{"a", "c"}, ids(cache.Find(JobFilter{Tag: "db"})))
+		assert.Equal(t, []string{"a"}, ids(cache.Find(JobFilter{Owner: "ops@example.com", Tag: "db"})))
+		assert.Empty(t, cache.Find(JobFilter{Owner: "nobody@example.com"}))
+
+		namespaced := GetMockJob()
+		namespaced.Id, namespaced.Owner, namespaced.Namespace = "d", "dev@example.com", "data"
+		assert.NoError(t, cache.Set(namespaced))
+		assert.Equal(t, []string{"d"}, ids(cache.Find(JobFilter{Namespace: "data"})))
+		assert.Empty(t, cache.Find(JobFilter{Namespace: "web"}))
+		assert.NoError(t, cache.Delete("d"))
+
+		// Setting a job again reindexes it.
+		add("b", "dev@example.com", "cleanup")
+		assert.Equal(t, []string{"a"}, ids(cache.Find(JobFilter{Tag: "nightly"})))
+		assert.Equal(t, []string{"b", "c"}, ids(cache.Find(JobFilter{Owner: "dev@example.com"})))
+
+		assert.NoError(t, cache.Delete("c"))
+		assert.Equal(t, []string{"a"}, ids(cache.Find(JobFilter{Name: "backup"})))
+		assert.Empty(t, cache.Find(JobFilter{Tag: "db", Owner: "dev@example.com"}))
+	}
+}
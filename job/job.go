@@ -2,46 +2,124 @@ package job
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/ajvb/kala/archive"
+	"github.com/ajvb/kala/logsink"
+	"github.com/ajvb/kala/metrics"
+	"github.com/ajvb/kala/notify"
 	"github.com/ajvb/kala/utils/iso8601"
+	"github.com/ajvb/kala/utils/jsonpath"
+	"github.com/ajvb/kala/utils/logging"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/nu7hatch/gouuid"
 )
 
 var (
+	runnerLog = logging.GetLogger(logging.Runner)
+
 	// nano seconds allowed between current time and when job is supposed to start
 	validOffset            = time.Minute
 	RFC3339WithoutTimezone = "2006-01-02T15:04:05"
 
 	ErrInvalidJob       = errors.New("Invalid Local Job. Job's must contain a Name and a Command field")
-	ErrInvalidRemoteJob = errors.New("Invalid Remote Job. Job's must contain a Name and a url field")
-	ErrInvalidJobType   = errors.New("Invalid Job type. Types supported: 0 for local and 1 for remote")
+	ErrInvalidRemoteJob = errors.New("Invalid Remote Job. Job's must contain a Name and a url field, or valid steps")
+	ErrInvalidJobType   = errors.New("Invalid Job type. Types supported: 0 for local, 1 for remote, 2 for amqp, 3 for lambda, 4 for pubsub, 5 for sql, 6 for grpc, 7 for handler and 8 for plugin")
+	ErrInvalidTypeName  = errors.New("Invalid Job type. Types supported: local, remote, amqp, lambda, pubsub, sql, grpc, handler and plugin")
+	ErrInvalidSeverity  = errors.New("Invalid notification severity. Severities supported: critical, error, warning and info")
+	ErrInvalidMailOn    = errors.New("Invalid notifications mail_on. Values supported: output, always and failure")
+	ErrInvalidMaxStats  = errors.New("Invalid max_stats. It can't be negative")
+	ErrInvalidSchedule  = errors.New("Schedule not formatted correctly. Should look like: R/2014-03-08T20:00:00Z/PT2H")
+)
+
+// Sources of disabling a job, see DisabledInfo.
+const (
+	DisabledByUser    = "user"
+	DisabledByBreaker = "breaker"
 )
 
+// DisabledInfo tells who disabled a job, when and why.
+type DisabledInfo struct {
+	// DisabledByUser for jobs disabled through the API, or DisabledByBreaker
+	// for jobs disabled automatically, e.g. after failing too often.
+	Source string `json:"source"`
+	// Who disabled the job, e.g. "admin@example.com".
+	By     string    `json:"by,omitempty"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
 type Job struct {
 	Name string `json:"name"`
 	Id   string `json:"id"`
 
+	// Incremented whenever the settings of the job change, e.g. as it's
+	// updated, enabled or disabled, but not as it runs. Updates and deletes
+	// giving an older version are rejected, see Update.
+	ResourceVersion uint64 `json:"resource_version"`
+
 	// Command to run
 	// e.g. "bash /path/to/my/script.sh"
 	Command string `json:"command"`
 
+	// Exit codes of the command meaning success or a warning, others being
+	// failures. Only 0 means success by default.
+	ExitCodes *ExitCodes `json:"exit_codes,omitempty"`
+
+	// Parse the last line of the standard output of the command as a JSON
+	// object, the result of the run. It's recorded in the run's JobStat, sent
+	// with its notifications and given to the dependent jobs, as
+	// {{.ParentResult}} in their templates and $KALA_PARENT_RESULT.
+	ParseResult bool `json:"parse_result,omitempty"`
+
+	// Glob patterns of the files the command produces, e.g. "reports/*.pdf",
+	// stored in the archive after every run and linked from its JobStat.
+	Artifacts []string `json:"artifacts,omitempty"`
+
+	// Flag successful runs taking longer than this multiple of the job's
+	// baseline duration, e.g. 3, as slow. Not set by default.
+	SlowFactor float64 `json:"slow_factor,omitempty"`
+
 	// Email of the owner of this job
 	// e.g. "admin@example.com"
 	Owner string `json:"owner"`
 
+	// Team owning this job and its contacts, included in notifications.
+	Ownership *notify.Ownership `json:"ownership,omitempty"`
+
+	// Namespace isolating the job with those of its team, e.g. "data". Its
+	// quota is set with SetNamespaces.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Group of the job, a path of nested groups separated by dots, e.g.
+	// "etl.nightly" for the nightly group of the etl group. The jobs of a
+	// group are enabled, disabled, run and deleted together with the group
+	// routes of the API.
+	Group string `json:"group,omitempty"`
+
 	// Is this job disabled?
 	Disabled bool `json:"disabled"`
+	// Who disabled this job, when and why, if it was disabled with
+	// DisableWithInfo.
+	DisabledInfo *DisabledInfo `json:"disabled_info,omitempty"`
+
+	// Is this job in shadow mode? Its runs are scheduled as usual, but only
+	// record stats saying that it would have run, without running the job,
+	// nor notifying about it. Its dependent jobs run in shadow mode too.
+	Shadow bool `json:"shadow,omitempty"`
+
+	// Free-form labels, e.g. used to route notifications.
+	Tags []string `json:"tags,omitempty"`
 
 	// Jobs that are dependent upon this one will be run after this job runs.
 	DependentJobs []string `json:"dependent_jobs"`
@@ -52,6 +130,18 @@ type Job struct {
 	// Job that gets run after all retries have failed consecutively
 	OnFailureJob string `json:"on_failure_job"`
 
+	// Who to notify about failures, recoveries and the job being disabled.
+	Notifications *notify.Settings `json:"notifications,omitempty"`
+
+	// Where the output of its runs is shipped, overriding the server's log
+	// sinks.
+	LogSinks *logsink.Settings `json:"log_sinks,omitempty"`
+
+	// Subject of messages which trigger runs of the job, e.g. "orders.created".
+	// Message-triggered jobs without a schedule only run for messages.
+	TriggerSubject     string `json:"trigger_subject,omitempty"`
+	unsubscribeTrigger func() error
+
 	// ISO 8601 String
 	// e.g. "R/2014-03-08T20:00:00.000Z/PT2H"
 	Schedule     string `json:"schedule"`
@@ -71,7 +161,22 @@ type Job struct {
 	Epsilon         string `json:"epsilon"`
 	epsilonDuration *iso8601.Duration
 
-	jobTimer  *time.Timer
+	// What to do about a scheduled run which is due later than the
+	// MisfireTolerance, e.g. since Kala was down: fire-now, skip or
+	// reschedule, see MisfireReschedule. The tolerance is an ISO 8601
+	// duration, the Epsilon by default, else DefaultMisfireTolerance.
+	MisfirePolicy    string `json:"misfire_policy,omitempty"`
+	MisfireTolerance string `json:"misfire_tolerance,omitempty"`
+
+	// How recent the last successful run must be for the job's output to be
+	// fresh, as an ISO 8601 duration, e.g. "PT26H" for a nightly job.
+	FreshnessSLA string `json:"freshness_sla,omitempty"`
+
+	// Engine running the job's attempts, v1 or v2, the default engine if
+	// empty, see SetDefaultEngine.
+	Engine string `json:"engine,omitempty"`
+
+	jobTimer  *jobTimer
 	NextRunAt time.Time `json:"next_run_at"`
 
 	// Meta data about successful and failed runs.
@@ -83,11 +188,50 @@ type Job struct {
 	// Custom properties for the remote job type
 	RemoteProperties RemoteProperties `json:"remote_properties"`
 
+	// Custom properties for the AMQP job type
+	AMQPProperties AMQPProperties `json:"amqp_properties"`
+
+	// Custom properties for the Lambda job type
+	LambdaProperties LambdaProperties `json:"lambda_properties"`
+
+	// Custom properties for the Pub/Sub job type
+	PubSubProperties PubSubProperties `json:"pubsub_properties"`
+
+	// Custom properties for the SQL job type
+	SQLProperties SQLProperties `json:"sql_properties"`
+
+	// Custom properties for the gRPC job type
+	GRPCProperties GRPCProperties `json:"grpc_properties"`
+
+	// Custom properties for the handler job type
+	HandlerProperties HandlerProperties `json:"handler_properties"`
+
+	// Custom properties for the plugin job type
+	PluginProperties PluginProperties `json:"plugin_properties"`
+
 	// Collection of Job Stats
 	Stats []*JobStat `json:"stats"`
 
+	// Number of stats kept by the retention of the cache, overriding its
+	// maximum, e.g. for a job running every second. The oldest are dropped.
+	MaxStats int `json:"max_stats,omitempty"`
+
 	lock sync.RWMutex
 
+	// Incremented on every change of the job, see Version.
+	version uint64
+
+	// Set if the job was loaded from an older format or replayed from the
+	// WAL, until it's saved to the db. See UnmarshalJob and WAL.
+	unsaved bool
+
+	// Where the stats which aren't in memory are read from, if the job was
+	// loaded from or saved to a StatStore.
+	statStore StatStore
+
+	// Buffer of Stats, if their number is bounded.
+	statRing statRing
+
 	// Says if a job has been executed right numbers of time
 	// and should not been executed again in the future
 	IsDone bool `json:"is_done"`
@@ -98,8 +242,45 @@ type jobType int
 const (
 	LocalJob jobType = iota
 	RemoteJob
+	AMQPJob
+	LambdaJob
+	PubSubJob
+	SQLJob
+	GRPCJob
+	HandlerJob
+	PluginJob
 )
 
+// jobTypeNames are the names of the job types, e.g. in v2 of the API.
+var jobTypeNames = map[jobType]string{
+	LocalJob:  "local",
+	RemoteJob: "remote",
+	AMQPJob:   "amqp",
+	LambdaJob: "lambda",
+	PubSubJob: "pubsub",
+	SQLJob:    "sql",
+	GRPCJob:   "grpc",
+
+	HandlerJob: "handler",
+	PluginJob:  "plugin",
+}
+
+// TypeName returns the name of the job's type, e.g. "remote".
+func (j *Job) TypeName() string {
+	return jobTypeNames[j.JobType]
+}
+
+// SetTypeName sets the job's type by its name, e.g. "remote".
+func (j *Job) SetTypeName(name string) error {
+	for t, n := range jobTypeNames {
+		if n == name {
+			j.JobType = t
+			return nil
+		}
+	}
+	return ErrInvalidTypeName
+}
+
 // RemoteProperties Custom properties for the remote job type
 type RemoteProperties struct {
 	Url    string `json:"url"`
@@ -111,20 +292,70 @@ type RemoteProperties struct {
 	// A list of headers to add to http request (e.g. [{"key": "charset", "value": "UTF-8"}])
 	Headers http.Header `json:"headers"`
 
+	// Authentication of the requests, e.g. with OAuth2 client credentials.
+	Auth *RemoteAuth `json:"auth,omitempty"`
+
 	// A timeout property for the http request in seconds
 	Timeout int `json:"timeout"`
 
 	// A list of expected response codes (e.g. [200, 201])
 	ExpectedResponseCodes []int `json:"expected_response_codes"`
+
+	// Requests to make in order instead of the one above, see RemoteStep.
+	Steps []RemoteStep `json:"steps,omitempty"`
+
+	// Store the full response in the server's archive, linked from the run's
+	// JobStat. With steps, the response of the last step made is stored.
+	ArchiveResponse bool `json:"archive_response,omitempty"`
+
+	// JSONPath of the JSON object in the response which is the result of the
+	// run, e.g. "$.result", see Job.ParseResult. With steps, the result is in
+	// the response of the last step made.
+	ResultPath string `json:"result_path,omitempty"`
+
+	// Poll the status of the work started by the request until it ends, see
+	// RemotePoll. Not for jobs with steps.
+	Poll *RemotePoll `json:"poll,omitempty"`
+}
+
+func (p *RemoteProperties) valid() bool {
+	if p.ResultPath != "" {
+		if _, err := jsonpath.Compile(p.ResultPath); err != nil {
+			return false
+		}
+	}
+	if p.Poll != nil && (len(p.Steps) != 0 || !p.Poll.valid()) {
+		return false
+	}
+	if len(p.Steps) != 0 {
+		return validSteps(p.Steps)
+	}
+	return p.Url != ""
 }
 
 type Metadata struct {
 	SuccessCount     	uint      `json:"success_count"`
+	// Successful runs with a warning, see ExitCodes.
+	WarningCount	uint	  `json:"warning_count"`
 	LastSuccess      	time.Time `json:"last_success"`
 	ErrorCount       	uint      `json:"error_count"`
 	LastError        	time.Time `json:"last_error"`
 	LastAttemptedRun 	time.Time `json:"last_attempted_run"`
 	NumberOfFinishedRuns	uint	  `json:"number_of_finished_runs"`
+
+	// Failed runs in a row, and when the first of them started, kept
+	// until the next successful run.
+	ConsecutiveFailures	uint	  `json:"consecutive_failures"`
+	FailingSince	time.Time	  `json:"failing_since"`
+
+	// Moving average of the durations of successful runs, and the number
+	// of runs it averages, see SlowFactor.
+	BaselineDuration	time.Duration	  `json:"baseline_duration"`
+	BaselineRuns	uint	  `json:"baseline_runs"`
+
+	// Scheduled time of the last run skipped as it misfired, see
+	// MisfirePolicy.
+	LastMisfire	time.Time	  `json:"last_misfire"`
 }
 
 // Bytes returns the byte representation of the Job.
@@ -154,6 +385,22 @@ func NewFromBytes(b []byte) (*Job, error) {
 // Init fills in the protected fields and parses the iso8601 notation.
 // It also adds the job to the Cache
 func (j *Job) Init(cache JobCache) error {
+	return j.InitWithContext(context.Background(), cache)
+}
+
+// InitWithContext is like Init, but a one-off job is run with the given context.
+func (j *Job) InitWithContext(ctx context.Context, cache JobCache) error {
+	return j.initWithId(ctx, cache, "")
+}
+
+// initWithId initializes the job with the id, or a new one if it's empty.
+func (j *Job) initWithId(ctx context.Context, cache JobCache, id string) error {
+	// Jobs are added to their parent jobs under linksLock, so that they
+	// aren't added to parent jobs being deleted.
+	if len(j.ParentJobs) != 0 {
+		linksLock.Lock()
+		defer linksLock.Unlock()
+	}
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
@@ -163,18 +410,23 @@ func (j *Job) Init(cache JobCache) error {
 		return err
 	}
 
-	u4, err := uuid.NewV4()
-	if err != nil {
-		log.Errorf("Error occured when generating uuid: %s", err)
-		return err
+	if id == "" {
+		u4, err := uuid.NewV4()
+		if err != nil {
+			runnerLog.Errorf("Error occured when generating uuid: %s", err)
+			return err
+		}
+		id = u4.String()
 	}
-	j.Id = u4.String()
+	j.Id = id
+	j.ResourceVersion = 1
 
 	// Add Job to the cache.
 	err = cache.Set(j)
 	if err != nil {
 		return err
 	}
+	notify.Dispatch(j.event(notify.JobCreated, nil, nil))
 
 	if len(j.ParentJobs) != 0 {
 		// Add new job to parent jobs
@@ -183,16 +435,32 @@ func (j *Job) Init(cache JobCache) error {
 			if err != nil {
 				return err
 			}
+			parentJob.lock.Lock()
 			parentJob.DependentJobs = append(parentJob.DependentJobs, j.Id)
+			parentJob.changed()
+			parentJob.lock.Unlock()
 		}
 
 		return nil
 	}
 
+	if j.TriggerSubject != "" {
+		err = j.subscribe(cache)
+		if err != nil {
+			j.lock.Unlock()
+			cache.Delete(j.Id)
+			j.lock.Lock()
+			return err
+		}
+		if j.Schedule == "" {
+			return nil
+		}
+	}
+
 	// TODO: Delete from cache after running.
 	if j.Schedule == "" {
 		// If schedule is empty, its a one-off job.
-		go j.Run(cache)
+		go j.RunWithContext(ctx, cache)
 		return nil
 	}
 
@@ -227,9 +495,7 @@ func (j *Job) InitDelayDuration(checkTime bool) error {
 	var err error
 	splitTime := strings.Split(j.Schedule, "/")
 	if len(splitTime) != 3 {
-		return fmt.Errorf(
-			"Schedule not formatted correctly. Should look like: R/2014-03-08T20:00:00Z/PT2H",
-		)
+		return ErrInvalidSchedule
 	}
 
 	// Handle Repeat Amount
@@ -239,7 +505,7 @@ func (j *Job) InitDelayDuration(checkTime bool) error {
 	} else {
 		j.timesToRepeat, err = strconv.ParseInt(strings.Split(splitTime[0], "R")[1], 10, 0)
 		if err != nil {
-			log.Errorf("Error converting timesToRepeat to an int: %s", err)
+			runnerLog.Errorf("Error converting timesToRepeat to an int: %s", err)
 			return err
 		}
 	}
@@ -248,7 +514,7 @@ func (j *Job) InitDelayDuration(checkTime bool) error {
 	if err != nil {
 		j.scheduleTime, err = time.Parse(RFC3339WithoutTimezone, splitTime[1])
 		if err != nil {
-			log.Errorf("Error converting scheduleTime to a time.Time: %s", err)
+			runnerLog.Errorf("Error converting scheduleTime to a time.Time: %s", err)
 			return err
 		}
 	}
@@ -258,22 +524,22 @@ func (j *Job) InitDelayDuration(checkTime bool) error {
 			return fmt.Errorf("Job %s:%s cannot be scheduled %s ago", j.Name, j.Id, diff.String())
 		}
 	}
-	log.Debugf("Job %s:%s scheduled", j.Name, j.Id)
-	log.Debugf("Starting %s will repeat for %d", j.scheduleTime, j.timesToRepeat)
+	runnerLog.Debugf("Job %s:%s scheduled", j.Name, j.Id)
+	runnerLog.Debugf("Starting %s will repeat for %d", j.scheduleTime, j.timesToRepeat)
 
 	if j.timesToRepeat != 0 {
 		j.delayDuration, err = iso8601.FromString(splitTime[2])
 		if err != nil {
-			log.Errorf("Error converting delayDuration to a iso8601.Duration: %s", err)
+			runnerLog.Errorf("Error converting delayDuration to a iso8601.Duration: %s", err)
 			return err
 		}
-		log.Debugf("Delay duration is %s", j.delayDuration.ToDuration())
+		runnerLog.Debugf("Delay duration is %s", j.delayDuration.ToDuration())
 	}
 
 	if j.Epsilon != "" {
 		j.epsilonDuration, err = iso8601.FromString(j.Epsilon)
 		if err != nil {
-			log.Errorf("Error converting j.Epsilon to iso8601.Duration: %s", err)
+			runnerLog.Errorf("Error converting j.Epsilon to iso8601.Duration: %s", err)
 			return err
 		}
 	}
@@ -287,24 +553,68 @@ func (j *Job) StartWaiting(cache JobCache) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
-	log.Infof("Job %s:%s repeating in %s", j.Name, j.Id, waitDuration)
+	now := time.Now()
+	next := now.Add(waitDuration)
+	if j.keepsScheduleTimes() && j.timesToRepeat != 0 && j.scheduleTime.Before(now) {
+		// Runs at the exact time of the schedule, on the wall clock, rather
+		// than the time GetWaitDuration was computed at plus the wait.
+		next = j.dueTime(now).Round(0)
+		waitDuration = next.Sub(now)
+	}
+
+	runnerLog.WithField("job_id", j.Id).Infof("Job %s:%s repeating in %s", j.Name, j.Id, waitDuration)
+
+	j.NextRunAt = next
+	j.changed()
+
+	j.jobTimer = afterFunc(waitDuration, j.scheduledRun(cache, next))
+}
+
+// ResumeWaiting begins a timer for the next run a job loaded from the db was
+// waiting for when it was saved, so that a restart resumes where it left off
+// rather than computing the next run from the schedule again. Runs which came
+// due while Kala was down start right away, as of the time they were due, so
+// that they may misfire. Jobs saved without a pending run, or while the run
+// was starting, compute the next run like StartWaiting.
+func (j *Job) ResumeWaiting(cache JobCache) {
+	j.lock.Lock()
+	if j.NextRunAt.IsZero() || !j.NextRunAt.After(j.Metadata.LastAttemptedRun) {
+		j.lock.Unlock()
+		j.StartWaiting(cache)
+		return
+	}
+	defer j.lock.Unlock()
 
-	j.NextRunAt = time.Now().Add(waitDuration)
+	// NextRunAt is a time on the wall clock, without a monotonic reading.
+	waitDuration := j.NextRunAt.Sub(time.Now().Round(0))
+	runnerLog.WithField("job_id", j.Id).Infof("Job %s:%s resuming, repeating in %s", j.Name, j.Id, waitDuration)
+	j.jobTimer = afterFunc(waitDuration, j.scheduledRun(cache, j.NextRunAt))
+}
 
-	jobRun := func() { j.Run(cache) }
-	j.jobTimer = time.AfterFunc(waitDuration, jobRun)
+// scheduledRun returns the function running the job when its run scheduled
+// at the time is due.
+func (j *Job) scheduledRun(cache JobCache, at time.Time) func() {
+	return func() {
+		if j.misfire(cache, at, time.Now()) {
+			j.RunWithContext(withScheduledAt(context.Background(), at), cache)
+		}
+	}
 }
 
 func (j *Job) GetWaitDuration() time.Duration {
 	j.lock.RLock()
 	defer j.lock.RUnlock()
 
-	waitDuration := time.Duration(j.scheduleTime.UnixNano() - time.Now().UnixNano())
+	now := time.Now()
+	waitDuration := time.Duration(j.scheduleTime.UnixNano() - now.UnixNano())
 
 	if waitDuration < 0 {
 		if j.timesToRepeat == 0 {
 			return 0
 		}
+		if j.keepsScheduleTimes() {
+			return j.dueTime(now).Sub(now)
+		}
 
 		if j.Metadata.LastAttemptedRun.IsZero() {
 			waitDuration = j.delayDuration.ToDuration()
@@ -325,125 +635,122 @@ func (j *Job) Disable() {
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
+	j.disable()
+	j.ResourceVersion++
+	j.changed()
+}
+
+// DisableWithInfo disables the job like Disable, recording who disabled it,
+// when and why.
+func (j *Job) DisableWithInfo(info DisabledInfo) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.disable()
+	j.DisabledInfo = &info
+	j.ResourceVersion++
+	j.changed()
+}
+
+// disable stops the job's timer. The lock must be held.
+func (j *Job) disable() {
 	if j.jobTimer != nil {
 		j.jobTimer.Stop()
 	}
 	j.Disabled = true
 }
 
-func (j *Job) Enable(cache JobCache) {
-	j.lock.Lock()
-	defer j.lock.Unlock()
-
-	if j.jobTimer != nil && j.Disabled {
-		go j.StartWaiting(cache)
+// describeDisabled says why the job is disabled, e.g. "it was disabled by
+// the breaker: 5 consecutive failures". The lock must be held.
+func (j *Job) describeDisabled() string {
+	info := j.DisabledInfo
+	if info == nil {
+		return "it's disabled"
 	}
-	j.Disabled = false
+	s := "it was disabled by the " + info.Source
+	if info.By != "" {
+		s += " " + info.By
+	}
+	if info.Reason != "" {
+		s += ": " + info.Reason
+	}
+	return s
 }
 
-// DeleteFromParentJobs goes through and deletes the current job from any parent jobs.
-func (j *Job) DeleteFromParentJobs(cache JobCache) error {
+func (j *Job) Enable(cache JobCache) {
 	j.lock.Lock()
 	defer j.lock.Unlock()
 
-	for _, p := range j.ParentJobs {
-		parentJob, err := cache.Get(p)
-
-		if err != nil {
-			return err
-		}
-
-		parentJob.lock.Lock()
-
-		ndx := 0
-		for i, id := range parentJob.DependentJobs {
-			if id == j.Id {
-				ndx = i
-				break
-			}
-		}
-		parentJob.DependentJobs = append(
-			parentJob.DependentJobs[:ndx], parentJob.DependentJobs[ndx+1:]...,
-		)
-		err = cache.Set(parentJob)
-		if err != nil {
-			return err
-		}
-		parentJob.lock.Unlock()
+	if j.jobTimer != nil && j.Disabled {
+		go j.StartWaiting(cache)
 	}
-
-	return nil
-}
-
-// DeleteFromDependentJobs
-func (j *Job) DeleteFromDependentJobs(cache JobCache) error {
-	j.lock.RLock()
-	defer j.lock.RUnlock()
-
-	for _, id := range j.DependentJobs {
-		childJob, err := cache.Get(id)
-		if err != nil {
-			return err
-		}
-
-		// If there are no other parent jobs, delete this job.
-		if len(childJob.ParentJobs) == 1 {
-			log.Infof("Deleting child %s", id)
-			cache.Delete(childJob.Id)
-			continue
-		}
-
-		childJob.lock.Lock()
-
-		ndx := 0
-		for i, id := range childJob.ParentJobs {
-			if id == j.Id {
-				ndx = i
-				break
-			}
-		}
-		childJob.ParentJobs = append(
-			childJob.ParentJobs[:ndx], childJob.ParentJobs[ndx+1:]...,
-		)
-
-		childJob.lock.Unlock()
-
+	if j.Disabled {
+		notify.Dispatch(j.event(notify.JobEnabled, nil, nil))
 	}
-
-	return nil
+	j.Disabled = false
+	j.DisabledInfo = nil
+	j.ResourceVersion++
+	j.changed()
 }
 
 // Runs the on failure job, if it exists. Does not lock the parent job - it is up to you to do this
 // however you want
 func (j *Job) RunOnFailureJob(cache JobCache) {
+	j.runOnFailureJob(context.Background(), cache)
+}
+
+func (j *Job) runOnFailureJob(ctx context.Context, cache JobCache) {
 	if (j.OnFailureJob != "") {
 		onFailureJob, cacheErr := cache.Get(j.OnFailureJob)
 		if cacheErr == ErrJobDoesntExist {
-			log.Errorf("Error retrieving dependent job with id of %s", j.OnFailureJob)
+			runnerLog.Errorf("Error retrieving dependent job with id of %s", j.OnFailureJob)
 		} else {
-			onFailureJob.Run(cache)
+			onFailureJob.RunWithContext(ctx, cache)
 		}
 	}
 }
 
 func (j *Job) Run(cache JobCache) {
+	j.RunWithContext(context.Background(), cache)
+}
+
+// RunWithContext runs the job like Run, passing ctx on to the runner and to
+// any dependent or on-failure jobs the run triggers.
+func (j *Job) RunWithContext(ctx context.Context, cache JobCache) {
+	if !startRun(cache) {
+		runnerLog.WithField("job_id", j.Id).Infof("Not running the job, since Kala is shutting down")
+		return
+	}
+	defer endRun(cache)
+
+	j.lock.RLock()
+	namespace, group, tags := j.Namespace, j.Group, j.Tags
+	j.lock.RUnlock()
+	ctx, release, err := acquireRunSlots(ctx, namespace, group, tags)
+	if err != nil {
+		runnerLog.WithField("job_id", j.Id).Infof("Skipped the run of the job, since %s", err)
+		return
+	}
+	defer release()
+
 	// Schedule next run
 	j.lock.RLock()
-	jobRunner := &JobRunner{job: j, meta: j.Metadata}
+	previous := j.Metadata
+	jobRunner := &JobRunner{job: j, meta: j.Metadata, ctx: ctx}
 	j.lock.RUnlock()
 	newStat, newMeta, err := jobRunner.Run(cache)
 	if err != nil {
-		log.Errorf("Error running job: %s", err)
-		j.lock.RLock()
-		j.RunOnFailureJob(cache)
-		j.lock.RUnlock()
+		runnerLog.WithField("job_id", j.Id).Errorf("Error running job: %s", err)
+		// Runs skipped by the quota didn't fail.
+		if err != ErrMaxRuns {
+			j.lock.RLock()
+			j.runOnFailureJob(ctx, cache)
+			j.lock.RUnlock()
+		}
 	}
 
 	j.lock.Lock()
-	j.Metadata = newMeta
-	if newStat != nil {
-		j.Stats = append(j.Stats, newStat)
-	}
+	j.recordRun(cache, previous, newMeta, newStat, err)
 
 	if j.ShouldStartWaiting() {
 		go j.StartWaiting(cache)
@@ -452,6 +759,167 @@ func (j *Job) Run(cache JobCache) {
 	}
 
 	j.lock.Unlock()
+
+	if newStat != nil {
+		runCompleted(cache, j, newStat, err)
+	}
+}
+
+// AddStat adds the stat of a run to the stats of the job, dropping the oldest
+// beyond the number it keeps, see statsCapacity.
+func (j *Job) AddStat(cache JobCache, stat *JobStat) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.addStat(cache, stat)
+	j.changed()
+}
+
+// addStat adds the stat to the stats of the job. The lock must be held.
+func (j *Job) addStat(cache JobCache, stat *JobStat) {
+	j.Stats = j.statRing.append(j.Stats, stat, j.statsCapacity(cache))
+}
+
+// RecordRun records a run of the job which ended: its metadata, and its stat,
+// which is added like AddStat, sent to the metrics and published with the
+// events of the run. previous is the metadata of the job before the run, and
+// err the error of the run. stat is nil if the job didn't run, e.g. since it's
+// disabled.
+func (j *Job) RecordRun(cache JobCache, previous, meta Metadata, stat *JobStat, err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.recordRun(cache, previous, meta, stat, err)
+}
+
+// recordRun records a run like RecordRun. The lock must be held.
+func (j *Job) recordRun(cache JobCache, previous, meta Metadata, stat *JobStat, err error) {
+	j.Metadata = meta
+	if stat != nil {
+		j.addStat(cache, stat)
+	}
+	j.changed()
+	j.recordRunMetrics(stat)
+	j.notifyRun(previous, stat, err)
+}
+
+// recordRunMetrics sends the run to the metrics, unless the job didn't run.
+// The lock must be held.
+func (j *Job) recordRunMetrics(stat *JobStat) {
+	if stat == nil || stat.Shadow {
+		return
+	}
+	metrics.RecordRun(j.Id, j.Name, j.Owner, stat.Success, stat.ExecutionDuration)
+	if stat.Warning {
+		metrics.RecordWarning(j.Id, j.Name, j.Owner)
+	}
+	if stat.Slow {
+		metrics.RecordSlowRun(j.Id, j.Name, j.Owner)
+	}
+}
+
+// StatsSummary aggregates the job's stats of the given window up until now.
+func (j *Job) StatsSummary(window time.Duration) *JobStatsSummary {
+	return NewJobStatsSummary(j.Id, j.allStats(), window, time.Now())
+}
+
+// QueryStats returns the stats of the job matching the query, most recent
+// first, without copying the others.
+func (j *Job) QueryStats(q StatsQuery) []*JobStat {
+	return queryStats(j.allStats(), q)
+}
+
+// trimStats drops the stats beyond the retention, and returns how many. Jobs
+// with a fixed number of repetitions keep their stats, which count their runs.
+func (j *Job) trimStats(r Retention, now time.Time) int {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.Schedule != "" && j.hasFixedRepetitions() {
+		return 0
+	}
+
+	// Stats are appended as runs end, so the oldest are first.
+	drop := r.Drop(j.Stats, j.MaxStats, now)
+	if drop == 0 {
+		return 0
+	}
+	j.Stats = j.statRing.drop(j.Stats, drop)
+	j.changed()
+	return drop
+}
+
+// Version returns a counter of the changes to the job, which the cache uses
+// to only persist jobs which changed.
+func (j *Job) Version() uint64 {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.version
+}
+
+// changed marks the job as changed. The lock must be held.
+func (j *Job) changed() {
+	j.version++
+	jobChanged(j.Id)
+}
+
+// RedactSecrets replaces the secrets the job holds, rather than refers to, by
+// RedactedSecret, for the API. The job must be a copy, see Copy.
+func (j *Job) RedactSecrets() {
+	if j.RemoteProperties.Auth != nil {
+		j.RemoteProperties.Auth = j.RemoteProperties.Auth.redacted()
+	}
+}
+
+// Copy returns a copy of the job's exported fields, which can be read without
+// racing with runs of the job. Its stats are copied too, but its settings, such
+// as the properties of its type, are shared with the job.
+func (j *Job) Copy() *Job {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.copy()
+}
+
+// snapshot returns a copy of the job and its version at once, e.g. to save
+// the job without holding its lock while the db is slow.
+func (j *Job) snapshot() (*Job, uint64) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.copy(), j.version
+}
+
+// copy returns a copy of the job, see Copy. The lock must be held.
+func (j *Job) copy() *Job {
+	c := &Job{}
+	src, dst := reflect.ValueOf(j).Elem(), reflect.ValueOf(c).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if dst.Field(i).CanSet() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	if j.DisabledInfo != nil {
+		info := *j.DisabledInfo
+		c.DisabledInfo = &info
+	}
+	c.Tags = append([]string(nil), j.Tags...)
+	c.DependentJobs = append([]string(nil), j.DependentJobs...)
+	c.ParentJobs = append([]string(nil), j.ParentJobs...)
+	if j.HandlerProperties.Params != nil {
+		c.HandlerProperties.Params = make(map[string]string, len(j.HandlerProperties.Params))
+		for key, value := range j.HandlerProperties.Params {
+			c.HandlerProperties.Params[key] = value
+		}
+	}
+	if j.PluginProperties.Params != nil {
+		c.PluginProperties.Params = make(map[string]string, len(j.PluginProperties.Params))
+		for key, value := range j.PluginProperties.Params {
+			c.PluginProperties.Params[key] = value
+		}
+	}
+	c.statStore = j.statStore
+	c.Stats = make([]*JobStat, len(j.Stats))
+	for i, stat := range j.Stats {
+		s := *stat
+		c.Stats[i] = &s
+	}
+	return c
 }
 
 func (j *Job) StopTimer() {
@@ -478,12 +946,34 @@ func (j *Job) hasFixedRepetitions() bool {
 	return false
 }
 
+// KeepsAllStats says if the job keeps all its stats in memory, as jobs with a
+// fixed number of repetitions do, see statsCapacity. Unlike
+// hasFixedRepetitions, it doesn't need the job to be initialized, e.g. for
+// jobs read from a db.
+func (j *Job) KeepsAllStats() bool {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.keepsAllStats()
+}
+
+// keepsAllStats is KeepsAllStats. The lock must be held.
+func (j *Job) keepsAllStats() bool {
+	repeat := strings.SplitN(j.Schedule, "/", 2)[0]
+	return j.Schedule != "" && repeat != "R" && strings.HasPrefix(repeat, "R")
+}
+
 func (j *Job) ShouldStartWaiting() bool {
 	if j.Disabled {
 		return false
 	}
 
-	if j.hasFixedRepetitions() && int(j.timesToRepeat) < len(j.Stats) {
+	if j.Schedule == "" && j.TriggerSubject != "" {
+		return false
+	}
+
+	// Runs are counted by the metadata, as the stats of the job may be
+	// trimmed or unloaded.
+	if j.hasFixedRepetitions() && j.timesToRepeat < int64(j.Metadata.NumberOfFinishedRuns) {
 		return false
 	}
 	return true
@@ -493,14 +983,72 @@ func (j *Job) validation() error {
 	var err error
 	if j.JobType == LocalJob && (j.Name == "" || j.Command == "") {
 		err = ErrInvalidJob
-	} else if j.JobType == RemoteJob && (j.Name == "" || j.RemoteProperties.Url == "") {
+	} else if j.JobType == RemoteJob && (j.Name == "" || !j.RemoteProperties.valid()) {
 		err = ErrInvalidRemoteJob
-	} else if j.JobType != LocalJob && j.JobType != RemoteJob {
+	} else if j.JobType == RemoteJob && !j.RemoteProperties.Auth.valid() {
+		err = ErrInvalidAuth
+	} else if j.JobType == RemoteJob && !j.RemoteProperties.Auth.allowedSecrets() {
+		err = ErrSecretNotAllowed
+	} else if j.JobType == RemoteJob && j.RemoteProperties.ArchiveResponse && archive.Default() == nil {
+		err = ErrNoArchive
+	} else if j.JobType == AMQPJob && (j.Name == "" || !j.AMQPProperties.valid()) {
+		err = ErrInvalidAMQPJob
+	} else if j.JobType == LambdaJob && (j.Name == "" || !j.LambdaProperties.valid()) {
+		err = ErrInvalidLambdaJob
+	} else if j.JobType == PubSubJob && (j.Name == "" || !j.PubSubProperties.valid()) {
+		err = ErrInvalidPubSubJob
+	} else if j.JobType == PubSubJob && !j.PubSubProperties.allowedCredentialsFile() {
+		err = ErrCredentialsFileNotAllowed
+	} else if j.JobType == SQLJob && (j.Name == "" || !j.SQLProperties.valid()) {
+		err = ErrInvalidSQLJob
+	} else if j.JobType == SQLJob && getSQLConnection(j.SQLProperties.Connection) == nil {
+		err = ErrUnknownSQLConnection
+	} else if j.JobType == GRPCJob && (j.Name == "" || !j.GRPCProperties.valid()) {
+		err = ErrInvalidGRPCJob
+	} else if j.JobType == GRPCJob && !j.GRPCProperties.allowedDescriptorSet() {
+		err = ErrDescriptorSetNotAllowed
+	} else if j.JobType == HandlerJob && (j.Name == "" || !j.HandlerProperties.valid()) {
+		err = ErrInvalidHandlerJob
+	} else if j.JobType == HandlerJob && getHandler(j.HandlerProperties.Handler) == nil {
+		err = ErrUnknownHandler
+	} else if j.JobType == PluginJob && (j.Name == "" || !j.PluginProperties.valid()) {
+		err = ErrInvalidPluginJob
+	} else if j.JobType == PluginJob && getPlugin(j.PluginProperties.Plugin) == nil {
+		err = ErrUnknownPlugin
+	} else if j.JobType < LocalJob || j.JobType > PluginJob {
 		err = ErrInvalidJobType
-	} else {
+	} else if j.Notifications != nil && !notify.ValidSeverity(j.Notifications.Severity) {
+		err = ErrInvalidSeverity
+	} else if j.Notifications != nil && !notify.ValidMailOn(j.Notifications.MailOn) {
+		err = ErrInvalidMailOn
+	} else if j.TriggerSubject != "" && getMessageSubscriber() == nil {
+		err = ErrNoMessageSubscriber
+	} else if j.MaxStats < 0 {
+		err = ErrInvalidMaxStats
+	} else if j.ExitCodes != nil && (j.JobType != LocalJob || !j.ExitCodes.valid()) {
+		err = ErrInvalidExitCodes
+	} else if j.ParseResult && j.JobType != LocalJob {
+		err = ErrInvalidParseResult
+	} else if len(j.Artifacts) != 0 && (j.JobType != LocalJob || !validArtifacts(j.Artifacts) || archive.Default() == nil) {
+		err = ErrInvalidArtifacts
+	} else if logsink.Default().Valid(j.LogSinks) != nil {
+		err = ErrInvalidLogSinks
+	} else if j.SlowFactor != 0 && j.SlowFactor <= 1 {
+		err = ErrInvalidSlowFactor
+	} else if j.FreshnessSLA != "" && !validFreshnessSLA(j.FreshnessSLA) {
+		err = ErrInvalidFreshnessSLA
+	} else if !ValidGroup(j.Group) {
+		err = ErrInvalidGroup
+	} else if !validMisfirePolicy(j.MisfirePolicy) {
+		err = ErrInvalidMisfirePolicy
+	} else if j.MisfireTolerance != "" && !validMisfireTolerance(j.MisfireTolerance) {
+		err = ErrInvalidMisfireTolerance
+	} else if !validEngine(j.Engine) {
+		err = ErrInvalidEngine
+	} else if err = j.validatePluginParams(); err == nil {
 		return nil
 	}
-	log.Errorf(err.Error())
+	runnerLog.Error(err)
 	return err
 }
 
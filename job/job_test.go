@@ -1,6 +1,7 @@
 package job
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -154,6 +155,24 @@ func TestJobDisable(t *testing.T) {
 	assert.False(t, genericMockJob.jobTimer.Stop())
 }
 
+func TestJobDisableWithInfo(t *testing.T) {
+	cache := NewMockCache()
+
+	j := GetMockJobWithGenericSchedule()
+	j.Init(cache)
+	assert.Equal(t, "it's disabled", j.describeDisabled())
+
+	j.DisableWithInfo(DisabledInfo{Source: DisabledByUser, By: "admin@example.com", At: time.Now(), Reason: "Maintenance"})
+	assert.True(t, j.Disabled)
+	assert.False(t, j.jobTimer.Stop())
+	assert.Equal(t, "it was disabled by the user admin@example.com: Maintenance", j.describeDisabled())
+	assert.Equal(t, "Maintenance", j.Copy().DisabledInfo.Reason)
+
+	j.Enable(cache)
+	assert.False(t, j.Disabled)
+	assert.Nil(t, j.DisabledInfo)
+}
+
 func TestJobRun(t *testing.T) {
 	cache := NewMockCache()
 
@@ -344,6 +363,31 @@ func TestDependentJobsSimple(t *testing.T) {
 	assert.WithinDuration(t, mcj.Metadata.LastSuccess, n, 4*time.Second)
 }
 
+func TestRunWithContextRecordsRequestId(t *testing.T) {
+	cache := NewMockCache()
+
+	mockJob := GetMockJobWithGenericSchedule()
+	mockJob.Init(cache)
+
+	mockChildJob := GetMockJob()
+	mockChildJob.ParentJobs = []string{mockJob.Id}
+	mockChildJob.Init(cache)
+
+	mockJob.RunWithContext(WithRequestId(context.Background(), "req-1"), cache)
+
+	mockJob.lock.RLock()
+	assert.Equal(t, "req-1", mockJob.Stats[0].RequestId)
+	mockJob.lock.RUnlock()
+	mockChildJob.lock.RLock()
+	assert.Equal(t, "req-1", mockChildJob.Stats[0].RequestId)
+	mockChildJob.lock.RUnlock()
+
+	mockJob.Run(cache)
+	mockJob.lock.RLock()
+	assert.Equal(t, "", mockJob.Stats[1].RequestId)
+	mockJob.lock.RUnlock()
+}
+
 // Parent doesn't exist
 func TestDependentJobsParentDoesNotExist(t *testing.T) {
 	cache := NewMockCache()
@@ -823,7 +867,6 @@ func TestDependentJobsChildGetsDeleted(t *testing.T) {
 	assert.True(t, len(mockJob.DependentJobs) == 1)
 
 	cache.Delete(mockChildJob.Id)
-	time.Sleep(time.Second)
 
 	// Check to make sure its deleted
 	_, err := cache.Get(mockChildJob.Id)
@@ -908,7 +951,6 @@ func TestDependentJobsParentJobGetsDeleted(t *testing.T) {
 	assert.True(t, len(mockJobBackup.DependentJobs) == 1)
 
 	cache.Delete(mockJob.Id)
-	time.Sleep(time.Second * 2)
 
 	// Make sure it is deleted
 	_, err := cache.Get(mockJob.Id)
@@ -1043,3 +1085,15 @@ func TestOnFailureJobDoesntTriggerOnSuccess(t *testing.T) {
 	onFailureJob.lock.RUnlock()
 	j.lock.RUnlock()
 }
+
+func TestJobTypeName(t *testing.T) {
+	j := GetMockJob()
+	assert.Equal(t, "local", j.TypeName())
+
+	assert.NoError(t, j.SetTypeName("grpc"))
+	assert.Equal(t, GRPCJob, j.JobType)
+	assert.Equal(t, "grpc", j.TypeName())
+
+	assert.Equal(t, ErrInvalidTypeName, j.SetTypeName("ftp"))
+	assert.Equal(t, GRPCJob, j.JobType)
+}
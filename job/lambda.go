@@ -0,0 +1,144 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/utils/awsauth"
+)
+
+var (
+	ErrInvalidLambdaJob = errors.New("Invalid Lambda Job. Lambda Job's must contain a Name, a function name, a valid invocation type and a valid payload template")
+	ErrNoAWSRegion      = errors.New("No AWS region configured for the Lambda Job. Set the job's region, or AWS_REGION")
+)
+
+// Invocation types of Lambda functions.
+const (
+	LambdaRequestResponse = "RequestResponse"
+	LambdaEvent           = "Event"
+	LambdaDryRun          = "DryRun"
+)
+
+// LambdaProperties Custom properties for the Lambda job type, which invokes
+// an AWS Lambda function on every run. Credentials are looked up like the AWS
+// SDKs do, e.g. from AWS_ACCESS_KEY_ID or the instance's role.
+type LambdaProperties struct {
+	// Name or ARN of the function, e.g. "nightly-report".
+	FunctionName string `json:"function_name"`
+
+	// Version or alias of the function to invoke.
+	Qualifier string `json:"qualifier"`
+
+	// Defaults to the region of the function's ARN, or to AWS_REGION.
+	Region string `json:"region"`
+
+	// A template of the JSON payload, see TemplateContext.
+	Payload string `json:"payload"`
+
+	// "RequestResponse" waits for the function to finish (the default), "Event"
+	// queues the invocation, and "DryRun" only checks for permission.
+	InvocationType string `json:"invocation_type"`
+
+	// A timeout for the invocation in seconds. Defaults to 15 minutes, the
+	// longest a function may run.
+	Timeout int `json:"timeout"`
+
+	// Overrides the Lambda endpoint, e.g. for testing with LocalStack.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+func (p *LambdaProperties) valid() bool {
+	switch p.InvocationType {
+	case "", LambdaRequestResponse, LambdaEvent, LambdaDryRun:
+	default:
+		return false
+	}
+	return p.FunctionName != "" && validTemplate(p.Payload)
+}
+
+// region returns the region of the function.
+func (p *LambdaProperties) region() string {
+	if p.Region != "" {
+		return p.Region
+	}
+	// arn:aws:lambda:us-east-1:123456789012:function:name
+	if parts := strings.Split(p.FunctionName, ":"); len(parts) > 3 && parts[0] == "arn" {
+		return parts[3]
+	}
+	return awsauth.Region()
+}
+
+// LambdaRun invokes the job's function. A run fails if the invocation fails,
+// or if the function itself returns an error.
+func (j *JobRunner) LambdaRun() error {
+	props := j.job.LambdaProperties
+	region := props.region()
+	if region == "" {
+		return ErrNoAWSRegion
+	}
+	invocationType := props.InvocationType
+	if invocationType == "" {
+		invocationType = LambdaRequestResponse
+	}
+	timeout := time.Duration(props.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 15 * time.Minute
+	}
+
+	payload, err := j.render(props.Payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := props.Endpoint
+	if endpoint == "" {
+		endpoint = "https://lambda." + region + ".amazonaws.com"
+	}
+	url := strings.TrimRight(endpoint, "/") + "/2015-03-31/functions/" + awsauth.EscapePath(props.FunctionName) + "/invocations"
+	if props.Qualifier != "" {
+		url += "?Qualifier=" + awsauth.EscapePath(props.Qualifier)
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Invocation-Type", invocationType)
+
+	creds, err := awsauth.DefaultChain.Retrieve()
+	if err != nil {
+		return err
+	}
+	awsauth.Sign(req, []byte(payload), creds, region, "lambda", time.Now())
+
+	httpClient := http.Client{
+		Timeout: timeout,
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, int64(MaxOutputSize)))
+	j.output = string(body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// Errors are e.g. {"Type": "User", "message": "Function not found: ..."}
+		var awsErr struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &awsErr)
+		return fmt.Errorf("Invoking %s failed with %s: %s %s", props.FunctionName, res.Status, res.Header.Get("X-Amzn-ErrorType"), awsErr.Message)
+	}
+	if functionErr := res.Header.Get("X-Amz-Function-Error"); functionErr != "" {
+		return fmt.Errorf("Function %s returned an error (%s): %s", props.FunctionName, functionErr, body)
+	}
+	return nil
+}
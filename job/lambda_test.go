@@ -0,0 +1,106 @@
+package job
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func GetMockLambdaJob(props LambdaProperties) *Job {
+	return &Job{
+		Name:             "mock_lambda_job",
+		JobType:          LambdaJob,
+		LambdaProperties: props,
+	}
+}
+
+func TestLambdaJobValidation(t *testing.T) {
+	cache := NewMockCache()
+
+	j := GetMockLambdaJob(LambdaProperties{})
+	assert.Equal(t, ErrInvalidLambdaJob, j.Init(cache))
+
+	j = GetMockLambdaJob(LambdaProperties{FunctionName: "report", InvocationType: "Sometimes"})
+	assert.Equal(t, ErrInvalidLambdaJob, j.Init(cache))
+
+	j = GetMockLambdaJob(LambdaProperties{FunctionName: "report", Payload: `{"job": "{{.JobName}}"}`})
+	j.Schedule = "R/2100-01-01T00:00:00Z/PT1H"
+	assert.NoError(t, j.Init(cache))
+}
+
+func TestLambdaRegion(t *testing.T) {
+	props := LambdaProperties{FunctionName: "arn:aws:lambda:eu-west-1:123456789012:function:report"}
+	assert.Equal(t, "eu-west-1", props.region())
+	props.Region = "us-east-2"
+	assert.Equal(t, "us-east-2", props.region())
+}
+
+func TestLambdaRun(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	functionError := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/2015-03-31/functions/report/invocations", r.URL.Path)
+		assert.Equal(t, "live", r.URL.Query().Get("Qualifier"))
+		assert.Equal(t, "RequestResponse", r.Header.Get("X-Amz-Invocation-Type"))
+		assert.Contains(t, r.Header.Get("Authorization"), "Credential=id/")
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-east-1/lambda/aws4_request")
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, `{"job": "mock_lambda_job"}`, string(body))
+
+		if functionError != "" {
+			w.Header().Set("X-Amz-Function-Error", functionError)
+			w.Write([]byte(`{"errorMessage": "boom"}`))
+			return
+		}
+		w.Write([]byte(`{"rows": 42}`))
+	}))
+	defer ts.Close()
+
+	j := GetMockLambdaJob(LambdaProperties{
+		FunctionName: "report",
+		Qualifier:    "live",
+		Region:       "us-east-1",
+		Payload:      `{"job": "{{.JobName}}"}`,
+		Endpoint:     ts.URL,
+	})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.LambdaRun())
+	assert.Equal(t, `{"rows": 42}`, runner.output)
+
+	functionError = "Unhandled"
+	err := runner.LambdaRun()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "boom")
+	}
+}
+
+func TestLambdaRunInvocationError(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amzn-ErrorType", "ResourceNotFoundException")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"Type": "User", "Message": "Function not found"}`))
+	}))
+	defer ts.Close()
+
+	j := GetMockLambdaJob(LambdaProperties{FunctionName: "missing", Region: "us-east-1", Endpoint: ts.URL})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	err := runner.LambdaRun()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Function not found")
+	}
+}
@@ -0,0 +1,104 @@
+package job
+
+import (
+	"context"
+	"sync"
+)
+
+// shutdown is the state of shutting down a cache: the runs of its jobs, which
+// stop being started once it drains, and the channel closed to stop persisting
+// and retaining stats periodically.
+type shutdown struct {
+	lock     sync.Mutex
+	running  int
+	draining bool
+	// Closed once draining and no run is left.
+	idle chan struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newShutdown() *shutdown {
+	return &shutdown{
+		idle: make(chan struct{}),
+		stop: make(chan struct{}),
+	}
+}
+
+// startRun counts a run starting, and returns false if the cache is draining,
+// in which case the run mustn't start.
+func (s *shutdown) startRun() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.draining {
+		return false
+	}
+	s.running++
+	return true
+}
+
+func (s *shutdown) endRun() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.running--
+	if s.draining && s.running == 0 {
+		close(s.idle)
+	}
+}
+
+// drain stops starting runs, and waits for the running ones until ctx is done.
+func (s *shutdown) drain(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.draining {
+		s.draining = true
+		if s.running == 0 {
+			close(s.idle)
+		}
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-s.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopLoops stops persisting and retaining stats periodically.
+func (s *shutdown) stopLoops() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// drainingCache is a cache which stops starting the runs of its jobs when
+// draining.
+type drainingCache interface {
+	shutdownState() *shutdown
+}
+
+// startRun counts a run of a job of the cache starting, and returns false if
+// the cache is draining. endRun must be called once the run ends.
+func startRun(cache JobCache) bool {
+	if c, ok := cache.(drainingCache); ok {
+		return c.shutdownState().startRun()
+	}
+	return true
+}
+
+func endRun(cache JobCache) {
+	if c, ok := cache.(drainingCache); ok {
+		c.shutdownState().endRun()
+	}
+}
+
+// draining says if the cache stopped starting the runs of its jobs.
+func draining(cache JobCache) bool {
+	if c, ok := cache.(drainingCache); ok {
+		s := c.shutdownState()
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return s.draining
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type shuttingDownCache interface {
+	JobCache
+	Drain(ctx context.Context) error
+	Flush(ctx context.Context) error
+}
+
+func TestCacheShutdown(t *testing.T) {
+	for _, cache := range []shuttingDownCache{NewLockFreeJobCache(&MockDBSaves{}), NewMemoryJobCache(&MockDBSaves{})} {
+		slow := GetMockJob()
+		slow.Id = "slow"
+		slow.Command = "bash -c 'sleep 0.2'"
+		assert.NoError(t, cache.Set(slow))
+		go slow.Run(cache)
+		time.Sleep(50 * time.Millisecond)
+
+		// The running job finishes before the cache is drained.
+		start := time.Now()
+		assert.NoError(t, cache.Drain(context.Background()))
+		assert.True(t, time.Since(start) > 50*time.Millisecond)
+		assert.Equal(t, uint(1), slow.Metadata.SuccessCount)
+
+		// No job is run once drained.
+		slow.Run(cache)
+		assert.Equal(t, uint(1), slow.Metadata.SuccessCount)
+
+		assert.NoError(t, cache.Flush(context.Background()))
+	}
+}
+
+func TestCacheDrainTimeout(t *testing.T) {
+	cache := NewMockCache()
+	slow := GetMockJob()
+	slow.Id = "slow"
+	slow.Command = "bash -c 'sleep 0.2'"
+	assert.NoError(t, cache.Set(slow))
+	go slow.Run(cache)
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, cache.Drain(ctx))
+}
@@ -0,0 +1,121 @@
+package job
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/logsink"
+)
+
+var ErrInvalidLogSinks = errors.New("Invalid log_sinks. Sinks are syslog, file, loki or cloudwatch URLs, and jobs may only use the file sinks of the server")
+
+// MaxShippedOutputSize is the number of bytes of the output of a run of a
+// local job shipped to the log sinks. Only the last lines of longer outputs
+// are shipped.
+var MaxShippedOutputSize = 1 << 20
+
+// lineRecorder records the lines written to the standard output and error
+// of a command, with the time they were written, keeping the last max bytes.
+type lineRecorder struct {
+	lock    sync.Mutex
+	max     int
+	size    int
+	lines   []logsink.Line
+	partial map[string][]byte
+}
+
+func newLineRecorder(max int) *lineRecorder {
+	return &lineRecorder{max: max, partial: map[string][]byte{}}
+}
+
+// writer returns the io.Writer of a stream.
+func (r *lineRecorder) writer(stream string) *streamWriter {
+	return &streamWriter{r, stream}
+}
+
+type streamWriter struct {
+	r      *lineRecorder
+	stream string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.r.write(w.stream, p, time.Now())
+	return len(p), nil
+}
+
+func (r *lineRecorder) write(stream string, p []byte, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	buf := append(r.partial[stream], p...)
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			break
+		}
+		r.add(logsink.Line{Time: now, Stream: stream, Text: strings.TrimSuffix(string(buf[:i]), "\r")})
+		buf = buf[i+1:]
+	}
+	r.partial[stream] = append([]byte(nil), buf...)
+}
+
+// add adds the line, dropping the oldest lines beyond max bytes. The lock
+// must be held.
+func (r *lineRecorder) add(l logsink.Line) {
+	r.lines = append(r.lines, l)
+	r.size += len(l.Text)
+	for r.size > r.max && len(r.lines) > 1 {
+		r.size -= len(r.lines[0].Text)
+		r.lines = r.lines[1:]
+	}
+}
+
+// Lines returns the recorded lines, including those the command didn't end.
+func (r *lineRecorder) Lines() []logsink.Line {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, stream := range []string{logsink.Stdout, logsink.Stderr} {
+		if len(r.partial[stream]) != 0 {
+			r.add(logsink.Line{Time: time.Now(), Stream: stream, Text: string(r.partial[stream])})
+			r.partial[stream] = nil
+		}
+	}
+	return r.lines
+}
+
+// shipsLogs returns whether the output of the job's runs is shipped to log
+// sinks.
+func (j *JobRunner) shipsLogs() bool {
+	return logsink.Default().Ships(j.job.LogSinks)
+}
+
+// shipLogs ships the output of the run to the log sinks. Local jobs ship the
+// lines of their command, other jobs their output, e.g. the response of
+// remote jobs, as standard output.
+func (j *JobRunner) shipLogs(success bool) {
+	if !j.shipsLogs() {
+		return
+	}
+	lines := []logsink.Line{}
+	if j.lines != nil {
+		lines = j.lines.Lines()
+	} else if j.output != "" {
+		now := time.Now()
+		for _, text := range strings.Split(strings.TrimRight(j.output, "\n"), "\n") {
+			lines = append(lines, logsink.Line{Time: now, Stream: logsink.Stdout, Text: text})
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	logsink.Default().Ship(&logsink.Record{
+		JobId:   j.job.Id,
+		JobName: j.job.Name,
+		Owner:   j.job.Owner,
+		RunId:   j.currentStat.RunId,
+		Success: success,
+		Lines:   lines,
+	}, j.job.LogSinks)
+}
@@ -0,0 +1,62 @@
+package job
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/logsink"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineRecorder(t *testing.T) {
+	r := newLineRecorder(12)
+	now := time.Now()
+	r.write(logsink.Stdout, []byte("one\ntw"), now)
+	r.write(logsink.Stderr, []byte("oops\r\n"), now)
+	r.write(logsink.Stdout, []byte("o\nthree"), now)
+
+	texts := []string{}
+	for _, l := range r.Lines() {
+		texts = append(texts, l.Stream+":"+l.Text)
+	}
+	// The oldest lines beyond 12 bytes are dropped.
+	assert.Equal(t, []string{"stderr:oops", "stdout:two", "stdout:three"}, texts)
+}
+
+func TestShipLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	shipper, err := logsink.NewShipper([]string{"file://" + dir})
+	assert.NoError(t, err)
+	logsink.SetDefault(shipper)
+	defer logsink.SetDefault(nil)
+
+	cache := NewMockCache()
+	j, stop, err := GetMockJobRunByTest(cache, func(j *Job) {
+		j.Command = `bash -c 'echo copying; echo disk full >&2'`
+	})
+	assert.NoError(t, err)
+	defer stop()
+
+	j.Run(cache)
+	shipper.Wait()
+	b, err := ioutil.ReadFile(filepath.Join(dir, j.Id+".log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), " "+j.Stats[0].RunId+" stdout: copying\n")
+	assert.Contains(t, string(b), " "+j.Stats[0].RunId+" stderr: disk full\n")
+
+	// Jobs may opt out.
+	j.LogSinks = &logsink.Settings{Disabled: true}
+	j.Run(cache)
+	shipper.Wait()
+	b, _ = ioutil.ReadFile(filepath.Join(dir, j.Id+".log"))
+	assert.NotContains(t, string(b), j.Stats[1].RunId)
+
+	j.LogSinks = &logsink.Settings{Sinks: []string{"file:///etc"}}
+	assert.Equal(t, ErrInvalidLogSinks, j.Init(cache))
+}
@@ -0,0 +1,93 @@
+package job
+
+import (
+	"context"
+	"sync"
+)
+
+// Attempt is an attempt of a run of a job, as seen by RunMiddleware.
+type Attempt struct {
+	// The job, which mustn't be changed. Its lock is held during the attempt.
+	Job *Job
+
+	// Stat of the run, with its run id and when it was scheduled.
+	Stat *JobStat
+
+	// Number of the attempt, 1 for the first and more once it's retried.
+	Number uint
+
+	// Output and result of the attempt, once it ran. Middleware may change
+	// them after calling next, or set them when it doesn't.
+	Output string
+	Result map[string]interface{}
+}
+
+// RunFunc runs an attempt of a job.
+type RunFunc func(ctx context.Context, attempt *Attempt) error
+
+// RunMiddleware wraps every attempt of every job, like the middleware of the
+// API wraps requests, to add tracing, rate limits or secrets to all runs
+// whatever their type. It calls next to run the attempt, with ctx or a context
+// derived from it, e.g. WithEnv(ctx, "TOKEN=..."). It may return without
+// calling next to short-circuit the attempt: it fails if an error is returned,
+// and is retried like the attempts of other runs, and succeeds else, with the
+// Output and Result set by the middleware.
+type RunMiddleware func(ctx context.Context, attempt *Attempt, next RunFunc) error
+
+var (
+	middlewares     []RunMiddleware
+	middlewaresLock sync.RWMutex
+)
+
+// RegisterMiddleware adds middleware around the attempts of all jobs. The
+// middleware registered first runs first, wrapping those registered after
+// it. It panics if the middleware is nil.
+func RegisterMiddleware(m RunMiddleware) {
+	middlewaresLock.Lock()
+	defer middlewaresLock.Unlock()
+	if m == nil {
+		panic("job: RegisterMiddleware middleware is nil")
+	}
+	middlewares = append(middlewares, m)
+}
+
+// getMiddlewares returns the registered middleware, in the order it runs.
+func getMiddlewares() []RunMiddleware {
+	middlewaresLock.RLock()
+	defer middlewaresLock.RUnlock()
+	return middlewares
+}
+
+// attempt runs an attempt of the job through the registered middleware. The
+// context passed on by the middleware is the context of the attempt alone.
+func (j *JobRunner) attempt() error {
+	chain := getMiddlewares()
+	if len(chain) == 0 {
+		return j.runType()
+	}
+
+	runCtx := j.runContext()
+	defer func() {
+		j.ctx = runCtx
+	}()
+	a := &Attempt{
+		Job:    j.job,
+		Stat:   j.currentStat,
+		Number: j.job.Retries - j.currentRetries + 1,
+	}
+	var next RunFunc = func(ctx context.Context, a *Attempt) error {
+		j.ctx = ctx
+		err := j.runType()
+		a.Output, a.Result = j.output, j.result
+		return err
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		m, inner := chain[i], next
+		next = func(ctx context.Context, a *Attempt) error {
+			return m(ctx, a, inner)
+		}
+	}
+	err := next(runCtx, a)
+	j.output, j.result = a.Output, a.Result
+	return err
+}
@@ -0,0 +1,89 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useMiddleware registers middleware for the test alone.
+func useMiddleware(m ...RunMiddleware) func() {
+	middlewaresLock.Lock()
+	saved := middlewares
+	middlewaresLock.Unlock()
+	for _, m := range m {
+		RegisterMiddleware(m)
+	}
+	return func() {
+		middlewaresLock.Lock()
+		middlewares = saved
+		middlewaresLock.Unlock()
+	}
+}
+
+func TestRunMiddleware(t *testing.T) {
+	calls := []string{}
+	defer useMiddleware(
+		func(ctx context.Context, a *Attempt, next RunFunc) error {
+			calls = append(calls, "tracing")
+			return next(WithEnv(ctx, "TRACE_ID=abc"), a)
+		},
+		func(ctx context.Context, a *Attempt, next RunFunc) error {
+			calls = append(calls, "secrets")
+			err := next(WithEnv(ctx, "TOKEN=s3cr3t"), a)
+			a.Result = map[string]interface{}{"attempt": float64(a.Number)}
+			return err
+		},
+	)()
+
+	j := GetMockJob()
+	j.Command = "printenv TRACE_ID TOKEN"
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.attempt())
+	assert.Equal(t, []string{"tracing", "secrets"}, calls)
+	assert.Equal(t, "abc\ns3cr3t\n", runner.output)
+	assert.Equal(t, map[string]interface{}{"attempt": float64(1)}, runner.result)
+
+	// The context of the attempt isn't the context of the run.
+	assert.Nil(t, envFromContext(runner.runContext()))
+}
+
+func TestRunMiddlewareShortCircuits(t *testing.T) {
+	limited := errors.New("rate limited")
+	defer useMiddleware(func(ctx context.Context, a *Attempt, next RunFunc) error {
+		if a.Job.Name == "limited" {
+			return limited
+		}
+		if a.Job.Name == "cached" {
+			a.Output = "from cache"
+			return nil
+		}
+		return next(ctx, a)
+	})()
+
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.Name = "limited"
+	j.Retries = 1
+	runner := &JobRunner{job: j}
+	stat, meta, err := runner.Run(cache)
+	assert.Equal(t, limited, err)
+	assert.False(t, stat.Success)
+	assert.Equal(t, uint(1), stat.NumberOfRetries)
+	assert.Equal(t, uint(2), meta.ErrorCount)
+
+	j = GetMockJob()
+	j.Name = "cached"
+	runner = &JobRunner{job: j}
+	stat, _, err = runner.Run(cache)
+	assert.NoError(t, err)
+	assert.True(t, stat.Success)
+	assert.Equal(t, "from cache", stat.Output)
+
+	assert.Panics(t, func() {
+		RegisterMiddleware(nil)
+	})
+}
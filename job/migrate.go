@@ -0,0 +1,145 @@
+package job
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// SchemaVersion is the version of the format jobs are persisted in. It's
+// increased, with a Migration added to migrations, whenever persisted jobs
+// have to be changed to be loaded by this version of Kala, e.g. when a field
+// is renamed or its type changes.
+const SchemaVersion = 2
+
+// Migration upgrades a persisted job, as a JSON object, from one version of
+// the format to the next.
+type Migration func(doc map[string]interface{}) error
+
+// migrations[i] upgrades jobs from version i to i+1. Version 0 is the format
+// before jobs were wrapped in an envelope: gob for boltdb and redis, JSON for
+// consul, and BSON documents for mongo.
+var migrations = []Migration{
+	// Version 1 wraps jobs in an envelope, without changing them.
+	func(doc map[string]interface{}) error { return nil },
+	// Version 2 adds the resource version of jobs, starting at 1.
+	func(doc map[string]interface{}) error {
+		if v, _ := doc["resource_version"].(float64); v == 0 {
+			doc["resource_version"] = 1
+		}
+		return nil
+	},
+}
+
+// envelope is the persisted form of a job.
+type envelope struct {
+	SchemaVersion *int            `json:"schema_version"`
+	Job           json.RawMessage `json:"job"`
+
+	// The stats of the job, compressed with the encoding, if they're
+	// compressed, see SetStatsCompression.
+	StatsEncoding string `json:"stats_encoding,omitempty"`
+	Stats         []byte `json:"stats,omitempty"`
+}
+
+// MarshalJob returns the persisted form of the job, in the current version of
+// the format.
+func MarshalJob(j *Job) ([]byte, error) {
+	if StatsCompression() == "" {
+		doc, err := json.Marshal(j)
+		if err != nil {
+			return nil, err
+		}
+		return marshalEnvelope(doc)
+	}
+
+	doc, stats, err := marshalWithoutStats(j)
+	if err != nil {
+		return nil, err
+	}
+	version := SchemaVersion
+	env := envelope{SchemaVersion: &version, Job: doc}
+	if len(stats) != 0 {
+		if env.Stats, err = compressStats(stats); err != nil {
+			return nil, err
+		}
+		env.StatsEncoding = StatsCompression()
+	}
+	return json.Marshal(env)
+}
+
+// marshalEnvelope wraps the JSON document of a job in an envelope of the
+// current version of the format.
+func marshalEnvelope(doc []byte) ([]byte, error) {
+	version := SchemaVersion
+	return json.Marshal(envelope{SchemaVersion: &version, Job: doc})
+}
+
+// UnmarshalJob returns the job persisted by MarshalJob, or by a version of
+// Kala before envelopes, migrated to the current version of the format. The
+// caches save migrated jobs back to the db when they're started.
+func UnmarshalJob(b []byte) (*Job, error) {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		// Version 0 of boltdb and redis.
+		j := &Job{}
+		if err := gob.NewDecoder(bytes.NewReader(b)).Decode(j); err != nil {
+			return nil, err
+		}
+		return UpgradeJob(j)
+	}
+
+	env := envelope{}
+	if err := json.Unmarshal(trimmed, &env); err != nil {
+		return nil, err
+	}
+	if env.SchemaVersion == nil {
+		// Version 0 of consul.
+		return migrate(trimmed, 0)
+	}
+	j, err := migrate(env.Job, *env.SchemaVersion)
+	if err != nil || env.StatsEncoding == "" {
+		return j, err
+	}
+	j.Stats, err = decompressStats(&env)
+	return j, err
+}
+
+// UpgradeJob migrates a job decoded from version 0 of the format, for JobDBs
+// which decode it themselves.
+func UpgradeJob(j *Job) (*Job, error) {
+	doc, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	return migrate(doc, 0)
+}
+
+func migrate(doc []byte, version int) (*Job, error) {
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("The job was persisted in version %d of the format, newer than version %d of this Kala", version, SchemaVersion)
+	}
+	if version < SchemaVersion {
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(doc, &fields); err != nil {
+			return nil, err
+		}
+		for v := version; v < SchemaVersion; v++ {
+			if err := migrations[v](fields); err != nil {
+				return nil, fmt.Errorf("Error occured migrating the job to version %d of the format: %s", v+1, err)
+			}
+		}
+		var err error
+		if doc, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	j := &Job{}
+	if err := json.Unmarshal(doc, j); err != nil {
+		return nil, err
+	}
+	j.unsaved = version < SchemaVersion
+	return j, nil
+}
@@ -0,0 +1,94 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalJob(t *testing.T) {
+	j := GetMockJobWithGenericSchedule()
+	j.Id = "marshal"
+	j.Tags = []string{"a"}
+
+	b, err := MarshalJob(j)
+	assert.NoError(t, err)
+	env := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(b, &env))
+	assert.Equal(t, float64(SchemaVersion), env["schema_version"])
+
+	loaded, err := UnmarshalJob(b)
+	assert.NoError(t, err)
+	assert.False(t, loaded.unsaved)
+	assert.Equal(t, j.Id, loaded.Id)
+	assert.Equal(t, j.Name, loaded.Name)
+	assert.Equal(t, j.Schedule, loaded.Schedule)
+	assert.Equal(t, j.Tags, loaded.Tags)
+}
+
+func TestUnmarshalJobMigratesOldFormats(t *testing.T) {
+	j := GetMockJobWithGenericSchedule()
+	j.Id = "legacy"
+
+	gobBytes, err := j.Bytes()
+	assert.NoError(t, err)
+	jsonBytes, err := json.Marshal(j)
+	assert.NoError(t, err)
+
+	for _, b := range [][]byte{gobBytes, jsonBytes} {
+		loaded, err := UnmarshalJob(b)
+		if assert.NoError(t, err) {
+			assert.True(t, loaded.unsaved)
+			assert.Equal(t, j.Id, loaded.Id)
+			assert.Equal(t, j.Command, loaded.Command)
+		}
+	}
+
+	_, err = UnmarshalJob([]byte(`{"schema_version": 1000, "job": {}}`))
+	assert.Error(t, err)
+}
+
+func TestMigrations(t *testing.T) {
+	assert.Len(t, migrations, SchemaVersion)
+
+	loaded, err := UnmarshalJob([]byte(`{"schema_version": 1, "job": {"id": "old"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), loaded.ResourceVersion)
+
+	defer func(m []Migration) { migrations = m }(migrations)
+	migrations = append([]Migration(nil), migrations...)
+	migrations[0] = func(doc map[string]interface{}) error {
+		doc["owner"] = "migrated@example.com"
+		return nil
+	}
+
+	loaded, err = UnmarshalJob([]byte(`{"id": "old", "owner": "old@example.com"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "migrated@example.com", loaded.Owner)
+
+	migrations[0] = func(doc map[string]interface{}) error {
+		return errors.New("can't migrate")
+	}
+	_, err = UnmarshalJob([]byte(`{"id": "old"}`))
+	assert.Error(t, err)
+}
+
+func TestSaveUnsaved(t *testing.T) {
+	db := &MockDBSaves{}
+	current, err := UnmarshalJob(mustMarshalJob(t, &Job{Id: "current"}))
+	assert.NoError(t, err)
+	old, err := UnmarshalJob([]byte(`{"id": "old"}`))
+	assert.NoError(t, err)
+
+	assert.NoError(t, saveUnsaved(db, []*Job{current, old}))
+	assert.Equal(t, []string{"old"}, db.saved)
+	assert.False(t, old.unsaved)
+}
+
+func mustMarshalJob(t *testing.T, j *Job) []byte {
+	b, err := MarshalJob(j)
+	assert.NoError(t, err)
+	return b
+}
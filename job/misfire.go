@@ -0,0 +1,121 @@
+package job
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ajvb/kala/notify"
+	"github.com/ajvb/kala/utils/iso8601"
+)
+
+var (
+	ErrInvalidMisfirePolicy    = errors.New("Invalid misfire_policy. Policies supported: fire-now, skip and reschedule")
+	ErrInvalidMisfireTolerance = errors.New("Invalid misfire_tolerance. It's an ISO 8601 duration, e.g. PT5M")
+)
+
+// Misfire policies, telling what to do about a scheduled run which is due
+// later than its job's misfire tolerance, e.g. since Kala was down.
+const (
+	// MisfireFireNow runs the missed run right away, and keeps the later
+	// runs at the times of the schedule.
+	MisfireFireNow = "fire-now"
+	// MisfireSkip drops the missed run, and waits for the next time of the
+	// schedule.
+	MisfireSkip = "skip"
+	// MisfireReschedule runs the missed run right away, and shifts the
+	// schedule so that the later runs are an interval after it. It's the
+	// policy of jobs which don't set one.
+	MisfireReschedule = "reschedule"
+)
+
+// DefaultMisfireTolerance is how late the runs of jobs without a misfire
+// tolerance nor an epsilon may start before they misfire.
+var DefaultMisfireTolerance = time.Minute
+
+func validMisfirePolicy(p string) bool {
+	return p == "" || p == MisfireFireNow || p == MisfireSkip || p == MisfireReschedule
+}
+
+func validMisfireTolerance(tolerance string) bool {
+	_, err := iso8601.FromString(tolerance)
+	return err == nil
+}
+
+// misfirePolicy returns the job's misfire policy. Callers must hold the
+// job's lock.
+func (j *Job) misfirePolicy() string {
+	if j.MisfirePolicy == "" {
+		return MisfireReschedule
+	}
+	return j.MisfirePolicy
+}
+
+// misfireTolerance returns how late the job's runs may start before they
+// misfire: its MisfireTolerance, else its Epsilon, else
+// DefaultMisfireTolerance. Callers must hold the job's lock.
+func (j *Job) misfireTolerance() time.Duration {
+	if j.MisfireTolerance != "" {
+		if d, err := iso8601.FromString(j.MisfireTolerance); err == nil {
+			return d.ToDuration()
+		}
+	}
+	if j.epsilonDuration != nil && j.epsilonDuration.ToDuration() != 0 {
+		return j.epsilonDuration.ToDuration()
+	}
+	return DefaultMisfireTolerance
+}
+
+// keepsScheduleTimes returns whether the job runs at the times of its
+// schedule, rather than an interval after its previous run. Callers must
+// hold the job's lock.
+func (j *Job) keepsScheduleTimes() bool {
+	return j.MisfirePolicy == MisfireFireNow || j.MisfirePolicy == MisfireSkip
+}
+
+// dueTime returns when the next run of the job is due on its schedule, once
+// its start passed: the latest time of the schedule since its previous run or
+// misfire, if that was missed, else the next time. Callers must hold the
+// job's lock.
+func (j *Job) dueTime(now time.Time) time.Time {
+	interval := j.delayDuration.ToDuration()
+	if interval <= 0 {
+		return now
+	}
+	latest := j.scheduleTime.Add(now.Sub(j.scheduleTime) / interval * interval)
+	previous := j.Metadata.LastAttemptedRun
+	if j.Metadata.LastMisfire.After(previous) {
+		previous = j.Metadata.LastMisfire
+	}
+	if latest.After(previous) {
+		return latest
+	}
+	return latest.Add(interval)
+}
+
+// misfire applies the job's misfire policy to its run scheduled at the given
+// time, if it is due later than the job's tolerance, and publishes a misfire
+// event telling what was done. It returns whether the run still runs.
+func (j *Job) misfire(cache JobCache, at, now time.Time) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	late := now.Sub(at)
+	if late <= j.misfireTolerance() {
+		return true
+	}
+	policy := j.misfirePolicy()
+	runnerLog.WithField("job_id", j.Id).Warnf("Job %s:%s misfired, its run scheduled at %s is %s late: %s", j.Name, j.Id, at, late, policy)
+	e := j.event(notify.RunMisfired, nil, nil)
+	e.Misfire = &notify.Misfire{ScheduledAt: at, Late: late, Action: policy}
+	notify.Dispatch(e)
+	if policy != MisfireSkip {
+		return true
+	}
+
+	j.Metadata.LastMisfire = at
+	j.changed()
+	if j.ShouldStartWaiting() {
+		go j.StartWaiting(cache)
+	}
+	return false
+}
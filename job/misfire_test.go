@@ -0,0 +1,102 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/notify"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDueTime(t *testing.T) {
+	now := time.Now()
+	start := now.Add(-10*time.Hour - 30*time.Minute)
+	j := &Job{
+		Schedule:      "R/" + start.Format(time.RFC3339Nano) + "/PT1H",
+		MisfirePolicy: MisfireSkip,
+	}
+	assert.NoError(t, j.InitDelayDuration(false))
+
+	// The run of 30 minutes ago was missed, e.g. as Kala was down.
+	j.Metadata.LastAttemptedRun = start.Add(7*time.Hour + time.Second)
+	assert.Equal(t, start.Add(10*time.Hour).UnixNano(), j.dueTime(now).UnixNano())
+	assert.True(t, j.GetWaitDuration() < 0)
+
+	// Once it ran or misfired, the next run is on the schedule.
+	j.Metadata.LastMisfire = start.Add(10 * time.Hour)
+	assert.Equal(t, start.Add(11*time.Hour).UnixNano(), j.dueTime(now).UnixNano())
+	assert.InDelta(t, float64(30*time.Minute), float64(j.GetWaitDuration()), float64(time.Second))
+
+	// Without a policy keeping the schedule's times, the next run is an
+	// interval after the previous one.
+	j.MisfirePolicy = ""
+	j.Metadata.LastAttemptedRun = now
+	assert.InDelta(t, float64(time.Hour), float64(j.GetWaitDuration()), float64(time.Second))
+}
+
+func TestMisfire(t *testing.T) {
+	publisher := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher()
+	dispatcher.AddPublisher("test", publisher)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+
+	cache := NewMockCache()
+	j := GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	j.MisfirePolicy = MisfireSkip
+	j.MisfireTolerance = "PT1M"
+	assert.NoError(t, j.Init(cache))
+	defer j.StopTimer()
+
+	now := time.Now()
+	assert.True(t, j.misfire(cache, now.Add(-10*time.Second), now))
+
+	at := now.Add(-time.Hour)
+	assert.False(t, j.misfire(cache, at, now))
+	j.lock.RLock()
+	assert.Equal(t, at, j.Metadata.LastMisfire)
+	j.lock.RUnlock()
+
+	j.lock.Lock()
+	j.MisfirePolicy = MisfireFireNow
+	j.lock.Unlock()
+	assert.True(t, j.misfire(cache, at, now))
+
+	dispatcher.Wait()
+	var actions []string
+	for _, e := range publisher.events {
+		if e.JobId == j.Id && e.Type == notify.RunMisfired {
+			assert.Equal(t, at, e.Misfire.ScheduledAt)
+			assert.Equal(t, time.Hour, e.Misfire.Late)
+			actions = append(actions, e.Misfire.Action)
+		}
+	}
+	assert.Equal(t, []string{MisfireSkip, MisfireFireNow}, actions)
+}
+
+func TestMisfireTolerance(t *testing.T) {
+	j := GetMockJobWithGenericSchedule()
+	assert.Equal(t, DefaultMisfireTolerance, j.misfireTolerance())
+
+	j.Epsilon = "PT10M"
+	assert.NoError(t, j.InitDelayDuration(false))
+	assert.Equal(t, 10*time.Minute, j.misfireTolerance())
+
+	j.MisfireTolerance = "PT30S"
+	assert.Equal(t, 30*time.Second, j.misfireTolerance())
+}
+
+func TestMisfireValidation(t *testing.T) {
+	j := GetMockJobWithGenericSchedule()
+	j.MisfirePolicy = "catch-up"
+	assert.Equal(t, ErrInvalidMisfirePolicy, j.Init(NewMockCache()))
+
+	j.MisfirePolicy = MisfireSkip
+	j.MisfireTolerance = "5m"
+	assert.Equal(t, ErrInvalidMisfireTolerance, j.Init(NewMockCache()))
+
+	j.MisfireTolerance = "PT5M"
+	assert.NoError(t, j.Init(NewMockCache()))
+	j.StopTimer()
+}
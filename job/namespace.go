@@ -0,0 +1,388 @@
+package job
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidNamespace = errors.New("Invalid namespace. Namespaces are made of lowercase letters, digits and dashes, and start and end with a letter or digit")
+	ErrMaxJobs          = errors.New("The namespace already has as many jobs as its quota allows")
+
+	namespacePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// ValidNamespace says if ns can be the namespace of a job. The empty
+// namespace is valid, for jobs of no namespace.
+func ValidNamespace(ns string) bool {
+	return ns == "" || namespacePattern.MatchString(ns)
+}
+
+// NamespaceConfig is the quota of a namespace, and the tokens of the clients
+// allowed to use its routes.
+type NamespaceConfig struct {
+	Quota
+	// Runs of jobs of the namespace at once. Runs beyond it wait for one to
+	// end. Dependent and on failure jobs run in the slot of the run which
+	// triggered them. No limit if 0.
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// Tokens of the clients allowed to use the routes of the namespace, sent
+	// as "Authorization: Bearer <token>". The routes are open to all clients
+	// if there is none. Tokens can refer to secrets as "env:NAME" or
+	// "file:/path", see RemoteAuth.
+	Tokens []string `json:"tokens,omitempty"`
+}
+
+// ConcurrencyLimit bounds the runs at once of the jobs of a group, with those
+// of its subgroups, or of the jobs with a tag. Runs beyond it wait for one to
+// end, as with the MaxConcurrency of namespaces.
+type ConcurrencyLimit struct {
+	MaxConcurrency int `json:"max_concurrency"`
+}
+
+// Namespaces are the namespaces with a quota or tokens, the owners with a
+// quota, the groups and tags with a concurrency limit, and the admin tokens,
+// e.g.
+//
+//	{"namespaces": {"data": {"max_jobs": 100, "max_concurrency": 5, "tokens": ["env:DATA_TOKEN"]}},
+//	 "owners": {"ops@example.com": {"max_jobs": 20, "max_runs_per_minute": 60}},
+//	 "groups": {"etl.nightly": {"max_concurrency": 2}},
+//	 "tags": {"heavy-etl": {"max_concurrency": 3}},
+//	 "admin_tokens": ["env:ADMIN_TOKEN"]}
+//
+// Jobs can be in other namespaces and groups, of other owners and with other
+// tags too, without limits.
+type Namespaces struct {
+	Namespaces map[string]*NamespaceConfig  `json:"namespaces"`
+	Owners     map[string]*Quota            `json:"owners"`
+	Groups     map[string]*ConcurrencyLimit `json:"groups"`
+	Tags       map[string]*ConcurrencyLimit `json:"tags"`
+	// Tokens of the clients allowed to use the routes across namespaces,
+	// such as the admin routes, once namespaces have tokens, see
+	// AuthorizedAdmin. They can refer to secrets like the tokens of
+	// namespaces.
+	AdminTokens []string `json:"admin_tokens,omitempty"`
+
+	// The resolved tokens of every namespace, and the admin tokens.
+	tokens      map[string][]string
+	adminTokens []string
+
+	lock sync.Mutex
+	// Slots of the runs of the namespaces, groups and tags with a
+	// MaxConcurrency, by the key of their runSlot.
+	slots map[string]chan struct{}
+	// Runs of the last minute of the namespaces and owners with a quota.
+	namespaceRuns map[string]runLog
+	ownerRuns     map[string]runLog
+}
+
+// LoadNamespaces reads the namespaces from a JSON file, see Namespaces.
+func LoadNamespaces(path string) (*Namespaces, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseNamespaces(f)
+}
+
+// ParseNamespaces parses the namespaces and owners and resolves the tokens of
+// the namespaces, returning every invalid namespace and owner at once.
+func ParseNamespaces(r io.Reader) (*Namespaces, error) {
+	n := &Namespaces{}
+	if err := json.NewDecoder(r).Decode(n); err != nil {
+		return nil, fmt.Errorf("Invalid namespaces: %s", err)
+	}
+
+	names := make([]string, 0, len(n.Namespaces))
+	for name := range n.Namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	n.tokens = map[string][]string{}
+	errs := []string{}
+	for _, name := range names {
+		c := n.Namespaces[name]
+		switch {
+		case name == "" || !ValidNamespace(name):
+			errs = append(errs, fmt.Sprintf("%q isn't a valid name", name))
+			continue
+		case c == nil:
+			errs = append(errs, fmt.Sprintf("%s has no settings", name))
+			continue
+		case c.negative() || c.MaxConcurrency < 0:
+			errs = append(errs, fmt.Sprintf("%s has a negative quota", name))
+		}
+		for _, token := range c.Tokens {
+			resolved, err := resolveSecret(token)
+			if err != nil || resolved == "" {
+				errs = append(errs, fmt.Sprintf("%s has an empty or unreadable token", name))
+				continue
+			}
+			n.tokens[name] = append(n.tokens[name], resolved)
+		}
+	}
+
+	for _, token := range n.AdminTokens {
+		resolved, err := resolveSecret(token)
+		if err != nil || resolved == "" {
+			errs = append(errs, "admin_tokens has an empty or unreadable token")
+			continue
+		}
+		n.adminTokens = append(n.adminTokens, resolved)
+	}
+
+	owners := make([]string, 0, len(n.Owners))
+	for owner := range n.Owners {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		switch q := n.Owners[owner]; {
+		case owner == "":
+			errs = append(errs, "an owner has no name")
+		case q == nil:
+			errs = append(errs, fmt.Sprintf("owner %s has no settings", owner))
+		case q.negative():
+			errs = append(errs, fmt.Sprintf("owner %s has a negative quota", owner))
+		}
+	}
+	errs = append(errs, invalidLimits("group", n.Groups, ValidGroup)...)
+	errs = append(errs, invalidLimits("tag", n.Tags, func(string) bool { return true })...)
+	if len(errs) != 0 {
+		return nil, fmt.Errorf("Invalid namespaces: %s", strings.Join(errs, "; "))
+	}
+	return n, nil
+}
+
+// invalidLimits returns why the concurrency limits of the groups or tags are
+// invalid, if they are.
+func invalidLimits(kind string, limits map[string]*ConcurrencyLimit, valid func(string) bool) []string {
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errs := []string{}
+	for _, name := range names {
+		switch l := limits[name]; {
+		case name == "" || !valid(name):
+			errs = append(errs, fmt.Sprintf("%q isn't a valid %s", name, kind))
+		case l == nil:
+			errs = append(errs, fmt.Sprintf("%s %s has no settings", kind, name))
+		case l.MaxConcurrency < 0:
+			errs = append(errs, fmt.Sprintf("%s %s has a negative max_concurrency", kind, name))
+		}
+	}
+	return errs
+}
+
+// Config returns the settings of the namespace, or nil if it has none.
+func (n *Namespaces) Config(ns string) *NamespaceConfig {
+	return n.Namespaces[ns]
+}
+
+// Authorized says if a client with the token may use the routes of the
+// namespace.
+func (n *Namespaces) Authorized(ns, token string) bool {
+	tokens := n.tokens[ns]
+	if len(tokens) == 0 {
+		return true
+	}
+	return matchToken(tokens, token)
+}
+
+// AuthorizedAdmin says if a client with the token may use the routes across
+// namespaces, such as the admin routes. They're open to all clients while no
+// namespace has tokens and there are no admin tokens, and else only to the
+// clients with an admin token.
+func (n *Namespaces) AuthorizedAdmin(token string) bool {
+	if len(n.adminTokens) == 0 {
+		for _, tokens := range n.tokens {
+			if len(tokens) != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return matchToken(n.adminTokens, token)
+}
+
+// ValidToken says if the token is one of the tokens of any namespace, or an
+// admin token.
+func (n *Namespaces) ValidToken(token string) bool {
+	valid := matchToken(n.adminTokens, token)
+	for _, tokens := range n.tokens {
+		if matchToken(tokens, token) {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// matchToken says if the token is one of the tokens, in constant time.
+func matchToken(tokens []string, token string) bool {
+	match := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			match = true
+		}
+	}
+	return match
+}
+
+// CheckQuota returns ErrMaxJobs or ErrOwnerMaxJobs if adding the job to the
+// cache would exceed the quota of its namespace or of its owner.
+func (n *Namespaces) CheckQuota(cache JobCache, j *Job) error {
+	nsQuota, ownerQuota := n.quotas(j.Namespace, j.Owner)
+	if nsQuota != nil && nsQuota.MaxJobs > 0 && countOthers(cache, JobFilter{Namespace: j.Namespace}, j) >= nsQuota.MaxJobs {
+		return ErrMaxJobs
+	}
+	if ownerQuota != nil && ownerQuota.MaxJobs > 0 && countOthers(cache, JobFilter{Owner: j.Owner}, j) >= ownerQuota.MaxJobs {
+		return ErrOwnerMaxJobs
+	}
+	return nil
+}
+
+// countOthers counts the jobs of the cache matching the filter, besides j.
+func countOthers(cache JobCache, f JobFilter, j *Job) int {
+	count := 0
+	for _, other := range cache.Find(f) {
+		if other.Id != j.Id {
+			count++
+		}
+	}
+	return count
+}
+
+// runSlot is a slot of runs at once shared by jobs, e.g. by the jobs of a
+// namespace.
+type runSlot struct {
+	key string
+	max int
+}
+
+// runSlots returns the slots a run of a job of the namespace, group and tags
+// waits for: those of the namespace, of the group and the groups it's nested
+// in, and of the tags with a MaxConcurrency. They're ordered by key, so that
+// runs waiting for several of them take them in the same order.
+func (n *Namespaces) runSlots(ns, group string, tags []string) []runSlot {
+	slots := []runSlot{}
+	if c := n.Config(ns); ns != "" && c != nil && c.MaxConcurrency > 0 {
+		slots = append(slots, runSlot{"namespace:" + ns, c.MaxConcurrency})
+	}
+	for _, path := range groupPaths(group) {
+		if l := n.Groups[path]; l != nil && l.MaxConcurrency > 0 {
+			slots = append(slots, runSlot{"group:" + path, l.MaxConcurrency})
+		}
+	}
+	for _, tag := range tags {
+		if l := n.Tags[tag]; l != nil && l.MaxConcurrency > 0 {
+			slots = append(slots, runSlot{"tag:" + tag, l.MaxConcurrency})
+		}
+	}
+	sort.Slice(slots, func(i, k int) bool { return slots[i].key < slots[k].key })
+	return slots
+}
+
+// runSlotError is returned by acquireRunSlots when ctx was done before the
+// slot was free.
+type runSlotError struct {
+	slot runSlot
+	err  error
+}
+
+func (e *runSlotError) Error() string {
+	return fmt.Sprintf("no run slot of %s was free (max_concurrency %d, %s)", e.slot.key, e.slot.max, e.err)
+}
+
+// acquire waits for the slot, unless ctx is done first. The returned
+// function releases the slot.
+func (n *Namespaces) acquire(ctx context.Context, slot runSlot) (func(), error) {
+	n.lock.Lock()
+	if n.slots == nil {
+		n.slots = map[string]chan struct{}{}
+	}
+	slots, ok := n.slots[slot.key]
+	if !ok {
+		slots = make(chan struct{}, slot.max)
+		n.slots[slot.key] = slots
+	}
+	n.lock.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+var (
+	namespacesLock sync.RWMutex
+	namespaces     = &Namespaces{}
+)
+
+// SetNamespaces sets the namespaces with a quota or tokens, and the owners
+// with a quota. Runs count against the concurrency and runs per minute of the
+// namespaces set when they started.
+func SetNamespaces(n *Namespaces) {
+	namespacesLock.Lock()
+	defer namespacesLock.Unlock()
+	namespaces = n
+}
+
+// GetNamespaces returns the namespaces set with SetNamespaces.
+func GetNamespaces() *Namespaces {
+	namespacesLock.RLock()
+	defer namespacesLock.RUnlock()
+	return namespaces
+}
+
+// acquireRunSlots waits for the slots of a run of a job of the namespace,
+// group and tags, see runSlots, but those held by the run which triggered it,
+// e.g. for dependent jobs. It returns the context of the run, telling the runs
+// it triggers which slots it holds, and the function releasing them, or a
+// runSlotError if ctx is done first.
+func acquireRunSlots(ctx context.Context, ns, group string, tags []string) (context.Context, func(), error) {
+	held, _ := ctx.Value(runSlotsKey).(map[string]bool)
+	releases := []func(){}
+	release := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	n := GetNamespaces()
+	holding := map[string]bool{}
+	for key := range held {
+		holding[key] = true
+	}
+	for _, slot := range n.runSlots(ns, group, tags) {
+		// Tags may be repeated.
+		if holding[slot.key] {
+			continue
+		}
+		r, err := n.acquire(ctx, slot)
+		if err != nil {
+			release()
+			return nil, nil, &runSlotError{slot, err}
+		}
+		releases = append(releases, r)
+		holding[slot.key] = true
+	}
+	if len(releases) == 0 {
+		return ctx, release, nil
+	}
+	return context.WithValue(ctx, runSlotsKey, holding), release, nil
+}
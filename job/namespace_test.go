@@ -0,0 +1,146 @@
+package job
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNamespaces(t *testing.T) {
+	os.Setenv("KALA_TEST_DATA_TOKEN", "s3cret")
+	defer os.Unsetenv("KALA_TEST_DATA_TOKEN")
+
+	n, err := ParseNamespaces(strings.NewReader(`{"namespaces": {
+		"data": {"max_jobs": 2, "max_concurrency": 1, "tokens": ["env:KALA_TEST_DATA_TOKEN"]},
+		"web": {}
+	}}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, n.Config("data").MaxJobs)
+		assert.Nil(t, n.Config("ops"))
+		assert.True(t, n.Authorized("data", "s3cret"))
+		assert.False(t, n.Authorized("data", "env:KALA_TEST_DATA_TOKEN"))
+		assert.False(t, n.Authorized("data", ""))
+		assert.True(t, n.Authorized("web", ""))
+		assert.True(t, n.Authorized("ops", ""))
+		assert.True(t, n.ValidToken("s3cret"))
+		assert.False(t, n.ValidToken(""))
+		assert.False(t, n.ValidToken("other"))
+
+		// Without admin tokens, the routes across namespaces are closed once
+		// namespaces have tokens.
+		assert.False(t, n.AuthorizedAdmin(""))
+		assert.False(t, n.AuthorizedAdmin("s3cret"))
+		assert.True(t, (&Namespaces{}).AuthorizedAdmin(""))
+	}
+
+	n, err = ParseNamespaces(strings.NewReader(`{"admin_tokens": ["admin"]}`))
+	if assert.NoError(t, err) {
+		assert.True(t, n.AuthorizedAdmin("admin"))
+		assert.False(t, n.AuthorizedAdmin(""))
+		assert.True(t, n.ValidToken("admin"))
+	}
+
+	_, err = ParseNamespaces(strings.NewReader(`{"namespaces": {
+		"Data": {}, "web": {"max_jobs": -1}, "ops": {"tokens": ["env:KALA_TEST_MISSING_TOKEN"]}
+	}}`))
+	if assert.Error(t, err) {
+		assert.Equal(t, `Invalid namespaces: "Data" isn't a valid name; ops has an empty or unreadable token; web has a negative quota`, err.Error())
+	}
+}
+
+func TestNamespaceMaxJobs(t *testing.T) {
+	n := &Namespaces{Namespaces: map[string]*NamespaceConfig{"data": {Quota: Quota{MaxJobs: 1}}}}
+	cache := NewMockCache()
+
+	j := GetMockJob()
+	j.Namespace = "data"
+	assert.NoError(t, n.CheckQuota(cache, j))
+	assert.NoError(t, j.Init(cache))
+	// Saving the job again doesn't count it twice.
+	assert.NoError(t, n.CheckQuota(cache, j))
+
+	other := GetMockJob()
+	other.Namespace = "data"
+	assert.Equal(t, ErrMaxJobs, n.CheckQuota(cache, other))
+	other.Namespace = "web"
+	assert.NoError(t, n.CheckQuota(cache, other))
+}
+
+func TestNamespaceMaxConcurrency(t *testing.T) {
+	SetNamespaces(&Namespaces{Namespaces: map[string]*NamespaceConfig{"data": {MaxConcurrency: 1}}})
+	defer SetNamespaces(&Namespaces{})
+
+	ctx, release, err := acquireRunSlots(context.Background(), "data", "", nil)
+	assert.NoError(t, err)
+
+	// Runs triggered by the run, e.g. of dependent jobs, share its slot.
+	_, releaseNested, err := acquireRunSlots(ctx, "data", "", nil)
+	assert.NoError(t, err)
+	releaseNested()
+
+	// Other runs wait for it.
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = acquireRunSlots(timeout, "data", "", nil)
+	if assert.Error(t, err) {
+		assert.Equal(t, "no run slot of namespace:data was free (max_concurrency 1, context deadline exceeded)", err.Error())
+	}
+
+	_, releaseOther, err := acquireRunSlots(context.Background(), "web", "", nil)
+	assert.NoError(t, err)
+	releaseOther()
+
+	release()
+	_, release, err = acquireRunSlots(context.Background(), "data", "", nil)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestGroupAndTagMaxConcurrency(t *testing.T) {
+	SetNamespaces(&Namespaces{
+		Groups: map[string]*ConcurrencyLimit{"etl": {MaxConcurrency: 1}},
+		Tags:   map[string]*ConcurrencyLimit{"heavy": {MaxConcurrency: 2}},
+	})
+	defer SetNamespaces(&Namespaces{})
+
+	// Subgroups share the slots of their group.
+	ctx, release, err := acquireRunSlots(context.Background(), "", "etl.nightly", []string{"heavy", "heavy"})
+	assert.NoError(t, err)
+	_, releaseNested, err := acquireRunSlots(ctx, "", "etl", []string{"heavy"})
+	assert.NoError(t, err)
+	releaseNested()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = acquireRunSlots(timeout, "", "etl.hourly", nil)
+	assert.Equal(t, &runSlotError{runSlot{"group:etl", 1}, context.DeadlineExceeded}, err)
+
+	// The run holds one of the 2 slots of the tag.
+	_, releaseTag, err := acquireRunSlots(context.Background(), "", "web", []string{"heavy"})
+	assert.NoError(t, err)
+	_, _, err = acquireRunSlots(timeout, "", "", []string{"heavy"})
+	assert.Equal(t, &runSlotError{runSlot{"tag:heavy", 2}, context.DeadlineExceeded}, err)
+	releaseTag()
+
+	release()
+	_, release, err = acquireRunSlots(context.Background(), "", "etl", []string{"heavy"})
+	assert.NoError(t, err)
+	release()
+}
+
+func TestParseConcurrencyLimits(t *testing.T) {
+	n, err := ParseNamespaces(strings.NewReader(`{"groups": {"etl.nightly": {"max_concurrency": 2}}, "tags": {"heavy-etl": {"max_concurrency": 3}}}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, n.Groups["etl.nightly"].MaxConcurrency)
+		assert.Equal(t, []runSlot{{"group:etl.nightly", 2}, {"tag:heavy-etl", 3}}, n.runSlots("", "etl.nightly.load", []string{"heavy-etl", "other"}))
+	}
+
+	_, err = ParseNamespaces(strings.NewReader(`{"groups": {"ETL": {}, "etl": {"max_concurrency": -1}}, "tags": {"heavy": null}}`))
+	if assert.Error(t, err) {
+		assert.Equal(t, `Invalid namespaces: "ETL" isn't a valid group; group etl has a negative max_concurrency; tag heavy has no settings`, err.Error())
+	}
+}
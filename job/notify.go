@@ -0,0 +1,90 @@
+package job
+
+import (
+	"time"
+
+	"github.com/ajvb/kala/notify"
+)
+
+// event returns a notification event of the given type about the job and,
+// if given, the run described by stat. Callers must hold the job's lock.
+func (j *Job) event(t notify.EventType, stat *JobStat, err error) *notify.Event {
+	e := &notify.Event{
+		Type:      t,
+		JobId:     j.Id,
+		JobName:   j.Name,
+		Owner:     j.Owner,
+		Ownership: j.Ownership,
+		Tags:      j.Tags,
+		Time:      time.Now(),
+		Severity:  j.Notifications.GetSeverity(),
+		Settings:  j.Notifications,
+	}
+	if stat != nil {
+		e.RunId = stat.RunId
+		e.RequestId = stat.RequestId
+		e.Duration = stat.ExecutionDuration
+		e.NumberOfRetries = stat.NumberOfRetries
+		e.Output = stat.Output
+		e.Result = stat.Result
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+// notifyRun sends an output event if the job mails the run's output, then a
+// failure event if the run failed, else a success event, followed by a slow
+// event if the run was slow, and a recovery event if the previous run failed.
+// Callers must hold the job's lock.
+func (j *Job) notifyRun(previous Metadata, stat *JobStat, err error) {
+	if stat == nil || stat.Shadow {
+		// The job didn't run, e.g. since it is disabled or in shadow mode.
+		return
+	}
+	if j.Notifications.Mails(err != nil, stat.Output) {
+		notify.Dispatch(j.event(notify.RunOutput, stat, err))
+	}
+	if err != nil {
+		e := j.event(notify.JobFailed, stat, err)
+		e.ConsecutiveFailures, e.FailingSince = failureStreak(j.Metadata)
+		notify.Dispatch(e)
+		return
+	}
+
+	notify.Dispatch(j.event(notify.RunSucceeded, stat, nil))
+	if stat.Slow {
+		e := j.event(notify.RunSlow, stat, nil)
+		e.Baseline = previous.BaselineDuration
+		notify.Dispatch(e)
+	}
+	if previous.LastError.After(previous.LastSuccess) {
+		e := j.event(notify.JobRecovered, stat, nil)
+		e.ConsecutiveFailures, e.FailingSince = failureStreak(previous)
+		notify.Dispatch(e)
+	}
+}
+
+// failureStreak returns the failed runs in a row tracked by meta, and when
+// the first of them started. It doesn't count the stats, which may not go
+// back to the start of the streak, e.g. once they're trimmed or unloaded.
+func failureStreak(meta Metadata) (int, time.Time) {
+	return int(meta.ConsecutiveFailures), meta.FailingSince
+}
+
+// publish sends a lifecycle event about the job.
+func (j *Job) publish(t notify.EventType) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	notify.Dispatch(j.event(t, nil, nil))
+}
+
+// NotifyDisabled sends an event telling that the job was disabled.
+func (j *Job) NotifyDisabled() {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	e := j.event(notify.JobDisabled, nil, nil)
+	e.ConsecutiveFailures, e.FailingSince = failureStreak(j.Metadata)
+	notify.Dispatch(e)
+}
@@ -0,0 +1,177 @@
+package job
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/notify"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingNotifier struct {
+	lock   sync.Mutex
+	events []*notify.Event
+}
+
+func (n *recordingNotifier) Notify(e *notify.Event) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.events = append(n.events, e)
+	return nil
+}
+
+func TestJobNotifications(t *testing.T) {
+	notifier := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher(notifier)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+
+	cache := NewMockCache()
+	j := GetMockFailingJob()
+	j.Id = "failing"
+	j.Retries = 0
+
+	j.Run(cache)
+	dispatcher.Wait()
+	assert.Len(t, notifier.events, 1)
+	assert.Equal(t, notify.JobFailed, notifier.events[0].Type)
+	assert.Equal(t, "failing", notifier.events[0].JobId)
+	assert.NotEmpty(t, notifier.events[0].Error)
+	assert.Equal(t, j.Stats[0].RunId, notifier.events[0].RunId)
+	assert.Equal(t, 1, notifier.events[0].ConsecutiveFailures)
+
+	j.Run(cache)
+	dispatcher.Wait()
+	assert.Len(t, notifier.events, 2)
+	assert.Equal(t, 2, notifier.events[1].ConsecutiveFailures)
+	notifier.events = notifier.events[1:]
+
+	j.Command = "bash -c 'date'"
+	j.Run(cache)
+	dispatcher.Wait()
+	assert.Len(t, notifier.events, 2)
+	assert.Equal(t, notify.JobRecovered, notifier.events[1].Type)
+	assert.Equal(t, 2, notifier.events[1].ConsecutiveFailures)
+	assert.Equal(t, j.Stats[0].RanAt, notifier.events[1].FailingSince)
+	assert.Equal(t, uint(0), j.Metadata.ConsecutiveFailures)
+
+	// A further success is no recovery.
+	j.Run(cache)
+	dispatcher.Wait()
+	assert.Len(t, notifier.events, 2)
+
+	j.Disable()
+	j.NotifyDisabled()
+	dispatcher.Wait()
+	assert.Len(t, notifier.events, 3)
+	assert.Equal(t, notify.JobDisabled, notifier.events[2].Type)
+}
+
+func TestFailureStreakOutlivesStats(t *testing.T) {
+	notifier := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher(notifier)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+
+	cache := NewMockCache()
+	j := GetMockFailingJob()
+	j.Id = "streak"
+	j.Retries = 0
+	for i := 0; i < 3; i++ {
+		j.Run(cache)
+	}
+	failingSince := j.Stats[0].RanAt
+	// The stats of the first failed runs are dropped, e.g. by the retention.
+	j.Stats = j.Stats[2:]
+	j.Run(cache)
+
+	j.Command = "bash -c 'date'"
+	j.Run(cache)
+	dispatcher.Wait()
+	// Other tests' jobs may notify too.
+	var failure, recovery *notify.Event
+	for _, e := range notifier.events {
+		if e.JobId == j.Id && e.Type == notify.JobFailed {
+			failure = e
+		}
+		if e.JobId == j.Id && e.Type == notify.JobRecovered {
+			recovery = e
+		}
+	}
+	if !assert.NotNil(t, failure) || !assert.NotNil(t, recovery) {
+		return
+	}
+	assert.Equal(t, 4, failure.ConsecutiveFailures)
+	assert.Equal(t, failingSince, failure.FailingSince)
+	assert.Equal(t, 4, recovery.ConsecutiveFailures)
+	assert.Equal(t, failingSince, recovery.FailingSince)
+}
+
+func TestJobMailsOutput(t *testing.T) {
+	email := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher()
+	dispatcher.Add(notify.EmailChannel, email)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+
+	cache := NewMockCache()
+	j := GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	j.Command = "bash -c 'echo copied'"
+	j.Notifications = &notify.Settings{MailTo: []string{"owner@example.com"}}
+	assert.NoError(t, j.Init(cache))
+
+	j.Run(cache)
+	// Runs without output aren't mailed.
+	j.Command = "true"
+	j.Run(cache)
+	dispatcher.Wait()
+
+	var mailed []*notify.Event
+	for _, e := range email.events {
+		if e.JobId == j.Id && e.Type == notify.RunOutput {
+			mailed = append(mailed, e)
+		}
+	}
+	if assert.Len(t, mailed, 1) {
+		assert.Equal(t, "copied\n", mailed[0].Output)
+	}
+}
+
+func TestJobInvalidSeverity(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockJobWithGenericSchedule()
+	j.Notifications = &notify.Settings{Severity: "urgent"}
+	assert.Equal(t, ErrInvalidSeverity, j.Init(cache))
+
+	j.Notifications.Severity = notify.SeverityCritical
+	assert.NoError(t, j.Init(cache))
+
+	j.Notifications.MailOn = "never"
+	assert.Equal(t, ErrInvalidMailOn, j.Init(cache))
+}
+
+func TestJobLifecycleEvents(t *testing.T) {
+	publisher := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher()
+	dispatcher.AddPublisher("test", publisher)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+
+	cache := NewMockCache()
+	j := GetMockJobWithGenericSchedule()
+	assert.NoError(t, j.Init(cache))
+	j.Run(cache)
+	j.Disable()
+	j.Enable(cache)
+	assert.NoError(t, cache.Delete(j.Id))
+	dispatcher.Wait()
+
+	var types []notify.EventType
+	for _, e := range publisher.events {
+		types = append(types, e.Type)
+		assert.Equal(t, j.Id, e.JobId)
+	}
+	assert.Equal(t, []notify.EventType{notify.JobCreated, notify.RunStarted, notify.RunSucceeded, notify.JobEnabled, notify.JobDeleted}, types)
+}
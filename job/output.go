@@ -0,0 +1,42 @@
+package job
+
+// MaxOutputSize is the number of bytes of a run's output kept in its JobStat.
+// Only the end of longer outputs is kept, as that's where errors usually are.
+var MaxOutputSize = 2048
+
+// tailBuffer is an io.Writer which keeps the last max bytes written to it.
+type tailBuffer struct {
+	max       int
+	buf       []byte
+	truncated bool
+}
+
+func newTailBuffer(max int) *tailBuffer {
+	return &tailBuffer{max: max}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if b.max <= 0 {
+		b.truncated = b.truncated || n > 0
+		return n, nil
+	}
+	if len(p) >= b.max {
+		b.truncated = b.truncated || len(b.buf) > 0 || len(p) > b.max
+		b.buf = append(b.buf[:0], p[len(p)-b.max:]...)
+		return n, nil
+	}
+	if overflow := len(b.buf) + len(p) - b.max; overflow > 0 {
+		b.truncated = true
+		b.buf = append(b.buf[:0], b.buf[overflow:]...)
+	}
+	b.buf = append(b.buf, p...)
+	return n, nil
+}
+
+func (b *tailBuffer) String() string {
+	if b.truncated {
+		return "..." + string(b.buf)
+	}
+	return string(b.buf)
+}
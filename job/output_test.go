@@ -0,0 +1,30 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailBuffer(t *testing.T) {
+	b := newTailBuffer(5)
+	b.Write([]byte("abc"))
+	assert.Equal(t, "abc", b.String())
+	b.Write([]byte("de"))
+	assert.Equal(t, "abcde", b.String())
+	b.Write([]byte("fg"))
+	assert.Equal(t, "...cdefg", b.String())
+	b.Write([]byte("0123456789"))
+	assert.Equal(t, "...56789", b.String())
+}
+
+func TestRunRecordsOutput(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.Id = "output"
+	j.Command = "bash -c 'echo hello; echo failed >&2; exit 1'"
+	j.Retries = 0
+
+	j.Run(cache)
+	assert.Equal(t, "hello\nfailed\n", j.Stats[0].Output)
+}
@@ -0,0 +1,112 @@
+package job
+
+import (
+	"sort"
+	"time"
+)
+
+var (
+	// How far ahead the Overview looks for upcoming runs.
+	UpcomingRunsWindow = time.Hour
+	// Maximum number of failures listed in the Overview.
+	MaxRecentFailures = 20
+)
+
+// Overview is a summary of the whole scheduler, meant for status pages.
+type Overview struct {
+	Jobs         int `json:"jobs"`
+	ActiveJobs   int `json:"active_jobs"`
+	DisabledJobs int `json:"disabled_jobs"`
+	// Disabled jobs which were disabled by the breaker, rather than by users.
+	DisabledByBreaker int `json:"disabled_by_breaker"`
+	// Jobs whose most recent run failed.
+	FailingJobs int `json:"failing_jobs"`
+
+	UpcomingRuns   []*UpcomingRun   `json:"upcoming_runs"`
+	RecentFailures []*RecentFailure `json:"recent_failures"`
+	// Usage of the namespaces and owners with a quota, see SetNamespaces.
+	Quotas []*QuotaUsage `json:"quotas"`
+
+	CreatedAt time.Time `json:"created"`
+}
+
+// UpcomingRun is a job scheduled to run soon.
+type UpcomingRun struct {
+	JobId     string    `json:"job_id"`
+	JobName   string    `json:"job_name"`
+	NextRunAt time.Time `json:"next_run_at"`
+}
+
+// RecentFailure is a failed run of a job.
+type RecentFailure struct {
+	JobName string `json:"job_name"`
+	*JobStat
+}
+
+// NewOverview summarizes every job within the cache.
+func NewOverview(cache JobCache) *Overview {
+	now := time.Now()
+	o := &Overview{
+		UpcomingRuns:   []*UpcomingRun{},
+		RecentFailures: []*RecentFailure{},
+		CreatedAt:      now,
+	}
+
+	jobs := cache.GetAll()
+	jobs.Lock.RLock()
+	defer jobs.Lock.RUnlock()
+
+	namespaces, owners := map[string]quotaCounts{}, map[string]quotaCounts{}
+	o.Jobs = len(jobs.Jobs)
+	for _, j := range jobs.Jobs {
+		j.lock.RLock()
+
+		namespaces[j.Namespace] = namespaces[j.Namespace].add(j)
+		owners[j.Owner] = owners[j.Owner].add(j)
+
+		if j.Disabled {
+			o.DisabledJobs++
+			if j.DisabledInfo != nil && j.DisabledInfo.Source == DisabledByBreaker {
+				o.DisabledByBreaker++
+			}
+		} else {
+			o.ActiveJobs++
+		}
+
+		if j.Metadata.LastError.After(j.Metadata.LastSuccess) {
+			o.FailingJobs++
+		}
+
+		if !j.Disabled && !j.IsDone && !j.NextRunAt.Before(now) && j.NextRunAt.Sub(now) <= UpcomingRunsWindow {
+			o.UpcomingRuns = append(o.UpcomingRuns, &UpcomingRun{
+				JobId:     j.Id,
+				JobName:   j.Name,
+				NextRunAt: j.NextRunAt,
+			})
+		}
+
+		for _, stat := range j.Stats {
+			if !stat.Success {
+				o.RecentFailures = append(o.RecentFailures, &RecentFailure{
+					JobName: j.Name,
+					JobStat: stat,
+				})
+			}
+		}
+
+		j.lock.RUnlock()
+	}
+
+	sort.Slice(o.UpcomingRuns, func(i, k int) bool {
+		return o.UpcomingRuns[i].NextRunAt.Before(o.UpcomingRuns[k].NextRunAt)
+	})
+	sort.Slice(o.RecentFailures, func(i, k int) bool {
+		return o.RecentFailures[i].RanAt.After(o.RecentFailures[k].RanAt)
+	})
+	if len(o.RecentFailures) > MaxRecentFailures {
+		o.RecentFailures = o.RecentFailures[:MaxRecentFailures]
+	}
+	o.Quotas = GetNamespaces().usage(namespaces, owners, now)
+
+	return o
+}
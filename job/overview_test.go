@@ -0,0 +1,49 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOverview(t *testing.T) {
+	cache := NewMockCache()
+
+	soon := GetMockJobWithSchedule(2, time.Now().Add(10*time.Minute), "PT1H")
+	soon.Init(cache)
+	later := GetMockJobWithSchedule(2, time.Now().Add(5*time.Minute), "PT1H")
+	later.Name = "mock_later_job"
+	later.Init(cache)
+	notSoon := GetMockJobWithSchedule(2, time.Now().Add(2*time.Hour), "PT1H")
+	notSoon.Init(cache)
+	disabled := GetMockJobWithGenericSchedule()
+	disabled.Init(cache)
+	disabled.Disable()
+	tripped := GetMockJobWithGenericSchedule()
+	tripped.Init(cache)
+	tripped.DisableWithInfo(DisabledInfo{Source: DisabledByBreaker, At: time.Now(), Reason: "5 consecutive failures"})
+
+	failing := GetMockFailingJob()
+	failing.Schedule = "R2/" + time.Now().Add(time.Hour*3).Format(time.RFC3339) + "/PT1H"
+	failing.Retries = 0
+	failing.Init(cache)
+	failing.Run(cache)
+
+	o := NewOverview(cache)
+
+	assert.Equal(t, 6, o.Jobs)
+	assert.Equal(t, 4, o.ActiveJobs)
+	assert.Equal(t, 2, o.DisabledJobs)
+	assert.Equal(t, 1, o.DisabledByBreaker)
+	assert.Equal(t, 1, o.FailingJobs)
+
+	assert.Len(t, o.UpcomingRuns, 2)
+	assert.Equal(t, later.Id, o.UpcomingRuns[0].JobId)
+	assert.Equal(t, "mock_later_job", o.UpcomingRuns[0].JobName)
+	assert.Equal(t, soon.Id, o.UpcomingRuns[1].JobId)
+
+	assert.Len(t, o.RecentFailures, 1)
+	assert.Equal(t, failing.Id, o.RecentFailures[0].JobId)
+	assert.Equal(t, failing.Name, o.RecentFailures[0].JobName)
+}
@@ -0,0 +1,185 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/metrics"
+)
+
+// PersistingCache is implemented by caches which persist their jobs on
+// demand, reporting which jobs couldn't be saved, e.g. to check that the
+// jobs are durable before maintenance of the database.
+type PersistingCache interface {
+	// PersistNow saves the jobs which changed since they were last saved,
+	// or all of them if all is true. Its error is the report's, or the
+	// error of checkpointing the WAL.
+	PersistNow(ctx context.Context, all bool) (*PersistReport, error)
+}
+
+// PersistReport tells what persisting the jobs on demand saved.
+type PersistReport struct {
+	Saved int `json:"saved"`
+	// Jobs which didn't change since they were last saved.
+	Unchanged int `json:"unchanged"`
+	// Errors of the jobs which couldn't be saved, by id.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Err returns a PersistError if jobs couldn't be saved.
+func (r *PersistReport) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	return &PersistError{Jobs: r.Saved + len(r.Errors), Errors: r.Errors}
+}
+
+// DefaultPersistWorkers is the default number of batches of jobs saved at
+// once when the jobs are persisted.
+const DefaultPersistWorkers = 4
+
+var (
+	persistWorkersLock sync.RWMutex
+	persistWorkers     = DefaultPersistWorkers
+
+	// PersistBatchSize is the number of jobs saved together, with SaveAll,
+	// by each worker persisting the jobs.
+	PersistBatchSize = 500
+)
+
+// SetPersistWorkers sets how many batches of jobs are saved at once, see
+// PersistBatchSize, so that a slow db doesn't hold up persisting every job.
+// They're saved one batch at a time if n is less than 2.
+func SetPersistWorkers(n int) {
+	persistWorkersLock.Lock()
+	defer persistWorkersLock.Unlock()
+	persistWorkers = n
+}
+
+// PersistWorkers returns how many batches of jobs are saved at once.
+func PersistWorkers() int {
+	persistWorkersLock.RLock()
+	defer persistWorkersLock.RUnlock()
+	if persistWorkers < 1 {
+		return 1
+	}
+	return persistWorkers
+}
+
+// maxPersistErrors is the number of errors of jobs a PersistError tells.
+const maxPersistErrors = 3
+
+// PersistError is the error of persisting the jobs when some of them
+// couldn't be saved. The others were saved.
+type PersistError struct {
+	// Jobs is the number of jobs to save.
+	Jobs int
+	// Errors of the jobs which couldn't be saved, by id.
+	Errors map[string]string
+}
+
+func (e *PersistError) Error() string {
+	ids := make([]string, 0, len(e.Errors))
+	for id := range e.Errors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	errs := []string{}
+	for i, id := range ids {
+		if i == maxPersistErrors {
+			errs = append(errs, "...")
+			break
+		}
+		errs = append(errs, id+": "+e.Errors[id])
+	}
+	return fmt.Sprintf("%d of the %d jobs to save couldn't be saved: %s", len(e.Errors), e.Jobs, strings.Join(errs, ", "))
+}
+
+// failedBatch is a batch of jobs which couldn't be saved.
+type failedBatch struct {
+	jobs []*Job
+	err  error
+}
+
+// saveBatches saves the jobs in batches of PersistBatchSize, PersistWorkers
+// of them at once, and returns the batches which couldn't be saved, in the
+// order of the jobs. A batch failing doesn't stop the others from being saved.
+func saveBatches(ctx context.Context, db JobDB, jobs []*Job) []failedBatch {
+	size := PersistBatchSize
+	if size <= 0 {
+		size = len(jobs)
+	}
+	var batches [][]*Job
+	for len(jobs) > 0 {
+		n := size
+		if n > len(jobs) {
+			n = len(jobs)
+		}
+		batches = append(batches, jobs[:n])
+		jobs = jobs[n:]
+	}
+
+	errs := make([]error, len(batches))
+	workers := make(chan struct{}, PersistWorkers())
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(i int, batch []*Job) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			errs[i] = db.SaveAll(ctx, batch)
+		}(i, batch)
+	}
+	wg.Wait()
+
+	var failed []failedBatch
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, failedBatch{batches[i], err})
+		}
+	}
+	return failed
+}
+
+// report saves the jobs which changed since they were last saved, or all of
+// them, and reports the error of every job which couldn't be saved. The jobs
+// of the batches which can't be saved at once are saved one at a time, so
+// that a job which can't be saved doesn't keep the others from being saved.
+func (p *persistedVersions) report(ctx context.Context, db JobDB, jobs map[string]*Job, all bool) *PersistReport {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	start := time.Now()
+	metrics.RecordCacheSize(len(jobs))
+
+	changed, versions := p.changed(jobs, all)
+	r := &PersistReport{Unchanged: len(jobs) - len(changed)}
+	for _, failed := range saveBatches(ctx, db, changed) {
+		cacheLog.Errorf("Error occured saving %d jobs at once, saving them one at a time: %s", len(failed.jobs), failed.err)
+		for _, j := range failed.jobs {
+			if err := db.Save(ctx, j); err != nil {
+				if r.Errors == nil {
+					r.Errors = map[string]string{}
+				}
+				r.Errors[j.Id] = err.Error()
+				delete(versions, j.Id)
+				j.lock.RLock()
+				name, owner := j.Name, j.Owner
+				j.lock.RUnlock()
+				metrics.RecordPersistFailure(j.Id, name, owner)
+			}
+		}
+	}
+	r.Saved = len(changed) - len(r.Errors)
+	metrics.RecordPersist(r.Saved)
+	metrics.RecordPersistDuration(time.Since(start), len(r.Errors) != 0)
+	p.commit(versions, jobs)
+	unloadSavedStats(db, versions)
+	return r
+}
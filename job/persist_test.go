@@ -0,0 +1,214 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// brokenJobsDB fails to save the jobs with the ids, and SaveAll with them.
+type brokenJobsDB struct {
+	MockDBSaves
+	failing map[string]bool
+}
+
+func (d *brokenJobsDB) Save(ctx context.Context, j *Job) error {
+	if d.failing[j.Id] {
+		return errors.New("disk full")
+	}
+	return d.MockDBSaves.Save(ctx, j)
+}
+
+func (d *brokenJobsDB) SaveAll(ctx context.Context, jobs []*Job) error {
+	for _, j := range jobs {
+		if d.failing[j.Id] {
+			return errors.New("disk full")
+		}
+	}
+	return d.MockDBSaves.SaveAll(ctx, jobs)
+}
+
+func TestPersistNow(t *testing.T) {
+	db := &brokenJobsDB{failing: map[string]bool{"broken": true}}
+	for _, cache := range []PersistingCache{NewMemoryJobCache(db), NewLockFreeJobCache(db)} {
+		db.saved = nil
+		for _, id := range []string{"ok", "broken"} {
+			j := GetMockJob()
+			j.Id = id
+			assert.NoError(t, cache.(JobCache).Set(j))
+		}
+
+		r, err := cache.PersistNow(context.Background(), false)
+		assert.Error(t, err)
+		assert.Equal(t, &PersistReport{Saved: 1, Errors: map[string]string{"broken": "disk full"}}, r)
+		assert.Equal(t, []string{"ok"}, db.saved)
+
+		// The job which couldn't be saved is saved again.
+		delete(db.failing, "broken")
+		r, err = cache.PersistNow(context.Background(), false)
+		assert.NoError(t, err)
+		assert.Equal(t, &PersistReport{Saved: 1, Unchanged: 1}, r)
+
+		r, err = cache.PersistNow(context.Background(), true)
+		assert.NoError(t, err)
+		assert.Equal(t, &PersistReport{Saved: 2}, r)
+		db.failing["broken"] = true
+	}
+}
+
+// slowBatchesDB counts the batches saved at once, and fails those with a job
+// with a failing id.
+type slowBatchesDB struct {
+	MockDB
+	lock    sync.Mutex
+	saving  int
+	most    int
+	saved   []string
+	failing map[string]bool
+}
+
+func (d *slowBatchesDB) SaveAll(ctx context.Context, jobs []*Job) error {
+	d.lock.Lock()
+	d.saving++
+	if d.saving > d.most {
+		d.most = d.saving
+	}
+	d.lock.Unlock()
+	time.Sleep(10 * time.Millisecond)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.saving--
+	for _, j := range jobs {
+		if d.failing[j.Id] {
+			return errors.New("disk full")
+		}
+	}
+	for _, j := range jobs {
+		d.saved = append(d.saved, j.Id)
+	}
+	return nil
+}
+
+func (d *slowBatchesDB) Save(ctx context.Context, j *Job) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.failing[j.Id] {
+		return errors.New("disk full")
+	}
+	d.saved = append(d.saved, j.Id)
+	return nil
+}
+
+func TestPersistSavesBatchesInParallel(t *testing.T) {
+	defer func(size int) { PersistBatchSize = size }(PersistBatchSize)
+	defer SetPersistWorkers(DefaultPersistWorkers)
+	PersistBatchSize = 2
+	SetPersistWorkers(3)
+
+	db := &slowBatchesDB{failing: map[string]bool{"broken": true}}
+	cache := NewMemoryJobCache(db)
+	for i := 0; i < 11; i++ {
+		j := GetMockJob()
+		j.Id = fmt.Sprintf("job-%d", i)
+		assert.NoError(t, cache.Set(j))
+	}
+	broken := GetMockJob()
+	broken.Id = "broken"
+	assert.NoError(t, cache.Set(broken))
+
+	// The other job of the batch which couldn't be saved is saved alone.
+	err := cache.Persist()
+	assert.Equal(t, &PersistError{Jobs: 12, Errors: map[string]string{"broken": "disk full"}}, err)
+	assert.EqualError(t, err, "1 of the 12 jobs to save couldn't be saved: broken: disk full")
+	assert.Equal(t, 3, db.most)
+	assert.Len(t, db.saved, 11)
+	assert.NotContains(t, db.saved, "broken")
+
+	// Only the job which couldn't be saved is saved again.
+	delete(db.failing, "broken")
+	db.saved = nil
+	assert.NoError(t, cache.Persist())
+	assert.Equal(t, []string{"broken"}, db.saved)
+}
+
+func TestPersistCountsFailuresPerJob(t *testing.T) {
+	db := &brokenJobsDB{failing: map[string]bool{"broken-1": true, "broken-2": true}}
+	cache := NewLockFreeJobCache(db)
+	for _, id := range []string{"ok", "broken-1", "broken-2"} {
+		j := GetMockJob()
+		j.Id = id
+		assert.NoError(t, cache.Set(j))
+	}
+	failures := metrics.Default().PersistCounts().JobFailures
+	counts, _ := metrics.Default().JobCounts("broken-1")
+
+	err := cache.Persist()
+	assert.EqualError(t, err, "2 of the 3 jobs to save couldn't be saved: broken-1: disk full, broken-2: disk full")
+	assert.Error(t, cache.Persist())
+	assert.Equal(t, []string{"ok"}, db.saved)
+
+	assert.Equal(t, failures+4, metrics.Default().PersistCounts().JobFailures)
+	jc, _ := metrics.Default().JobCounts("broken-1")
+	assert.Equal(t, counts.PersistFailures+2, jc.PersistFailures)
+}
+
+func TestPersistErrorTellsFirstErrors(t *testing.T) {
+	err := &PersistError{Jobs: 10, Errors: map[string]string{"d": "x", "b": "x", "a": "x", "c": "x"}}
+	assert.Equal(t, "4 of the 10 jobs to save couldn't be saved: a: x, b: x, c: x, ...", err.Error())
+}
+
+// blockingDB blocks saving the jobs until it's released, holding the jobs it
+// saves.
+type blockingDB struct {
+	MockDB
+	saving  chan []*Job
+	release chan struct{}
+}
+
+func (d *blockingDB) SaveAll(ctx context.Context, jobs []*Job) error {
+	d.saving <- jobs
+	<-d.release
+	return nil
+}
+
+func TestPersistSavesSnapshots(t *testing.T) {
+	db := &blockingDB{saving: make(chan []*Job, 1), release: make(chan struct{})}
+	cache := NewLockFreeJobCache(db)
+	j := GetMockJob()
+	assert.NoError(t, cache.Set(j))
+
+	persisted := make(chan error)
+	go func() { persisted <- cache.Persist() }()
+	saving := <-db.saving
+	assert.Len(t, saving, 1)
+	assert.False(t, saving[0] == j)
+
+	// The job can be changed while it's saved, which the snapshot doesn't
+	// see.
+	changed := make(chan struct{})
+	go func() {
+		j.Disable()
+		close(changed)
+	}()
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("Changing the job waited for it to be saved")
+	}
+	assert.False(t, saving[0].Disabled)
+	close(db.release)
+	assert.NoError(t, <-persisted)
+
+	// The change is saved next time.
+	go func() { persisted <- cache.Persist() }()
+	saving = <-db.saving
+	assert.True(t, saving[0].Disabled)
+	assert.NoError(t, <-persisted)
+}
@@ -0,0 +1,217 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidPluginJob = errors.New("Invalid Plugin Job. Plugin Job's must contain a Name, the name of a plugin and valid params templates")
+	ErrUnknownPlugin    = errors.New("The Plugin Job's plugin isn't registered. Register it with RegisterPlugin, or with --plugin")
+)
+
+// Plugin runs the jobs of a job type added to Kala without changing how jobs
+// are run, e.g. FTP transfers or calls to a custom RPC. Plugins are compiled
+// in and registered with RegisterPlugin, or are executables registered with
+// --plugin, see ExecPlugin. It's named Plugin rather than runner as JobRunner
+// runs a single job, whatever its type.
+type Plugin interface {
+	// Validate checks the params of a job when it's created, returning why
+	// they're invalid.
+	Validate(params map[string]string) error
+
+	// Run runs the job with its rendered params. The run fails if it returns
+	// an error, and is retried like the runs of other jobs. ctx is done once
+	// the run times out.
+	Run(ctx context.Context, params map[string]string) (*PluginResult, error)
+}
+
+// PluginResult is what a run of a plugin did: its output, kept in the run's
+// stats like the output of other jobs, and its result, passed to the
+// dependent jobs, see Job.ParseResult.
+type PluginResult struct {
+	Output string                 `json:"output"`
+	Result map[string]interface{} `json:"result"`
+}
+
+// PluginProperties Custom properties for the plugin job type, which runs a
+// plugin registered by name on every run.
+type PluginProperties struct {
+	// Name the plugin is registered under, e.g. "sftp".
+	Plugin string `json:"plugin"`
+
+	// Params passed to the plugin. The values are templates, see
+	// TemplateContext.
+	Params map[string]string `json:"params"`
+
+	// A timeout for the run in seconds, none if zero.
+	Timeout int `json:"timeout"`
+}
+
+func (p *PluginProperties) valid() bool {
+	if p.Plugin == "" || p.Timeout < 0 {
+		return false
+	}
+	for _, value := range p.Params {
+		if !validTemplate(value) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	plugins     = map[string]Plugin{}
+	pluginsLock sync.RWMutex
+)
+
+// RegisterPlugin makes a plugin available to plugin jobs by name. Like
+// RegisterDriver, it panics if the plugin is nil or a plugin is already
+// registered with the name. Plugins are registered before the jobs running
+// them are loaded, else their runs fail with ErrUnknownPlugin.
+func RegisterPlugin(name string, plugin Plugin) {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	if plugin == nil {
+		panic("job: RegisterPlugin plugin is nil")
+	}
+	if _, dup := plugins[name]; dup {
+		panic("job: RegisterPlugin called twice for plugin " + name)
+	}
+	plugins[name] = plugin
+}
+
+// Plugins returns the sorted names of the registered plugins.
+func Plugins() []string {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getPlugin(name string) Plugin {
+	pluginsLock.RLock()
+	defer pluginsLock.RUnlock()
+	return plugins[name]
+}
+
+// validatePluginParams checks the params of plugin jobs with their plugin,
+// once the rest of the job is valid.
+func (j *Job) validatePluginParams() error {
+	if j.JobType != PluginJob {
+		return nil
+	}
+	props := j.PluginProperties
+	if err := getPlugin(props.Plugin).Validate(props.Params); err != nil {
+		return fmt.Errorf("Invalid Plugin Job. The plugin %s rejected its params: %s", props.Plugin, err)
+	}
+	return nil
+}
+
+// PluginRun runs the job's plugin. A plugin which panics fails the run rather
+// than Kala.
+func (j *JobRunner) PluginRun() (err error) {
+	props := j.job.PluginProperties
+	plugin := getPlugin(props.Plugin)
+	if plugin == nil {
+		return ErrUnknownPlugin
+	}
+
+	params := make(map[string]string, len(props.Params))
+	for key, value := range props.Params {
+		if params[key], err = j.render(value); err != nil {
+			return err
+		}
+	}
+
+	ctx := j.runContext()
+	if props.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(props.Timeout)*time.Second)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("The plugin %s panicked: %v", props.Plugin, r)
+		}
+	}()
+	result, err := plugin.Run(ctx, params)
+	if result != nil {
+		j.output = truncate(result.Output, MaxOutputSize)
+		j.result = result.Result
+	}
+	return err
+}
+
+// ExecPlugin is a plugin which is an executable, so that job types are added
+// without rebuilding Kala. It's run as `path validate` and `path run` with the
+// params as a JSON object on its standard input, and writes a JSON object with
+// the output, result and error of the run, e.g.
+//
+//	{"output": "uploaded 3 files", "result": {"files": 3}, "error": ""}
+//
+// to its standard output. A non-empty error, or a non-zero exit status, fails
+// the run. ExecPlugins are killed once their run times out.
+type ExecPlugin struct {
+	Path string
+}
+
+// execPluginResponse is what ExecPlugins write to their standard output.
+type execPluginResponse struct {
+	PluginResult
+	Error string `json:"error"`
+}
+
+func (p *ExecPlugin) Validate(params map[string]string) error {
+	_, err := p.exec(context.Background(), "validate", params)
+	return err
+}
+
+func (p *ExecPlugin) Run(ctx context.Context, params map[string]string) (*PluginResult, error) {
+	return p.exec(ctx, "run", params)
+}
+
+func (p *ExecPlugin) exec(ctx context.Context, command string, params map[string]string) (*PluginResult, error) {
+	stdin, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path, command)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	res := &execPluginResponse{}
+	if stdout.Len() != 0 {
+		if err := json.Unmarshal(stdout.Bytes(), res); err != nil && runErr == nil {
+			return nil, fmt.Errorf("The plugin %s didn't write a JSON object: %s", p.Path, err)
+		}
+	}
+	if res.Error != "" {
+		return &res.PluginResult, errors.New(res.Error)
+	}
+	if runErr != nil && ctx.Err() != nil {
+		return &res.PluginResult, ctx.Err()
+	}
+	if runErr != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return &res.PluginResult, fmt.Errorf("%s: %s", runErr, msg)
+		}
+		return &res.PluginResult, runErr
+	}
+	return &res.PluginResult, nil
+}
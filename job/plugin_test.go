@@ -0,0 +1,132 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func GetMockPluginJob(plugin string, params map[string]string) *Job {
+	return &Job{
+		Name:    "mock_plugin_job",
+		JobType: PluginJob,
+		PluginProperties: PluginProperties{
+			Plugin: plugin,
+			Params: params,
+		},
+	}
+}
+
+// ftpPlugin is a compiled-in plugin transferring files.
+type ftpPlugin struct {
+	got map[string]string
+}
+
+func (p *ftpPlugin) Validate(params map[string]string) error {
+	if params["host"] == "" {
+		return errors.New("host is required")
+	}
+	return nil
+}
+
+func (p *ftpPlugin) Run(ctx context.Context, params map[string]string) (*PluginResult, error) {
+	p.got = params
+	switch params["host"] {
+	case "down":
+		return &PluginResult{Output: "connecting to down"}, errors.New("connection refused")
+	case "panic":
+		panic("nil conn")
+	case "slow":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &PluginResult{
+		Output: "uploaded 2 files",
+		Result: map[string]interface{}{"files": float64(2)},
+	}, nil
+}
+
+func TestPluginJobValidation(t *testing.T) {
+	cache := NewMockCache()
+	RegisterPlugin("test-validation", &ftpPlugin{})
+
+	assert.Equal(t, ErrInvalidPluginJob, GetMockPluginJob("", nil).Init(cache))
+	assert.Equal(t, ErrInvalidPluginJob, GetMockPluginJob("test-validation", map[string]string{"host": "{{.Time"}).Init(cache))
+	assert.Equal(t, ErrUnknownPlugin, GetMockPluginJob("missing", nil).Init(cache))
+	assert.EqualError(t, GetMockPluginJob("test-validation", nil).Init(cache),
+		"Invalid Plugin Job. The plugin test-validation rejected its params: host is required")
+	assert.NoError(t, GetMockPluginJob("test-validation", map[string]string{"host": "ftp.example.com"}).Init(cache))
+	assert.Contains(t, Plugins(), "test-validation")
+
+	assert.Panics(t, func() {
+		RegisterPlugin("test-validation", &ftpPlugin{})
+	})
+}
+
+func TestPluginRun(t *testing.T) {
+	plugin := &ftpPlugin{}
+	RegisterPlugin("test-ftp", plugin)
+
+	j := GetMockPluginJob("test-ftp", map[string]string{"host": "ftp.example.com", "dir": "/{{.JobName}}"})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.PluginRun())
+	assert.Equal(t, map[string]string{"host": "ftp.example.com", "dir": "/mock_plugin_job"}, plugin.got)
+	assert.Equal(t, "uploaded 2 files", runner.output)
+	assert.Equal(t, map[string]interface{}{"files": float64(2)}, runner.result)
+
+	j.PluginProperties.Params["host"] = "down"
+	assert.EqualError(t, runner.PluginRun(), "connection refused")
+	assert.Equal(t, "connecting to down", runner.output)
+
+	j.PluginProperties.Params["host"] = "panic"
+	assert.EqualError(t, runner.PluginRun(), "The plugin test-ftp panicked: nil conn")
+
+	j.PluginProperties.Params["host"] = "slow"
+	j.PluginProperties.Timeout = 1
+	start := time.Now()
+	assert.Equal(t, context.DeadlineExceeded, runner.PluginRun())
+	assert.WithinDuration(t, start.Add(time.Second), time.Now(), 500*time.Millisecond)
+
+	runner = &JobRunner{job: GetMockPluginJob("missing", nil)}
+	runner.runSetup()
+	assert.Equal(t, ErrUnknownPlugin, runner.PluginRun())
+}
+
+const execPluginScript = `#!/bin/sh
+params=$(cat)
+case "$1 $params" in
+validate*'"host":""'*) echo '{"error": "host is required"}' ;;
+validate*) echo '{}' ;;
+*'"host":"down"'*) echo 'connection refused' >&2; exit 3 ;;
+*) echo '{"output": "uploaded 2 files", "result": {"files": 2}}' ;;
+esac
+`
+
+func TestExecPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-plugin")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sftp")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(execPluginScript), 0755))
+	plugin := &ExecPlugin{Path: path}
+
+	assert.NoError(t, plugin.Validate(map[string]string{"host": "sftp.example.com"}))
+	assert.EqualError(t, plugin.Validate(map[string]string{"host": ""}), "host is required")
+
+	result, err := plugin.Run(context.Background(), map[string]string{"host": "sftp.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, &PluginResult{
+		Output: "uploaded 2 files",
+		Result: map[string]interface{}{"files": float64(2)},
+	}, result)
+
+	_, err = plugin.Run(context.Background(), map[string]string{"host": "down"})
+	assert.EqualError(t, err, "exit status 3: connection refused")
+}
@@ -0,0 +1,211 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ajvb/kala/utils/jsonpath"
+)
+
+// RemotePoll makes a remote job which starts asynchronous work poll its
+// status, e.g. after a 202 Accepted, until it reaches a terminal state. The
+// run succeeds or fails with the final state instead of the first response.
+type RemotePoll struct {
+	// Where the status URL is in the first response: a JSONPath in its body,
+	// e.g. "$.links.status", or a header, e.g. "Location". Relative URLs are
+	// resolved against the job's url.
+	UrlPath   string `json:"url_path,omitempty"`
+	UrlHeader string `json:"url_header,omitempty"`
+
+	// JSONPath of the state in the status responses, e.g. "$.state".
+	StatePath string `json:"state_path"`
+
+	// Terminal states of successful and failed work, e.g. ["done"] and
+	// ["failed", "cancelled"]. Other states keep the job polling.
+	SuccessStates []string `json:"success_states"`
+	FailureStates []string `json:"failure_states,omitempty"`
+
+	// Seconds between polls, 10 by default. The status is polled right away
+	// first.
+	Interval int `json:"interval,omitempty"`
+
+	// Seconds after which the run fails if the work didn't end, an hour by
+	// default.
+	Timeout int `json:"timeout,omitempty"`
+}
+
+func (p *RemotePoll) valid() bool {
+	if (p.UrlPath == "") == (p.UrlHeader == "") || p.StatePath == "" || len(p.SuccessStates) == 0 || p.Interval < 0 || p.Timeout < 0 {
+		return false
+	}
+	if p.UrlPath != "" {
+		if _, err := jsonpath.Compile(p.UrlPath); err != nil {
+			return false
+		}
+	}
+	_, err := jsonpath.Compile(p.StatePath)
+	return err == nil
+}
+
+func (p *RemotePoll) interval() time.Duration {
+	if p.Interval == 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(p.Interval) * time.Second
+}
+
+func (p *RemotePoll) timeout() time.Duration {
+	if p.Timeout == 0 {
+		return time.Hour
+	}
+	return time.Duration(p.Timeout) * time.Second
+}
+
+// statusURL returns the status URL of the first response of the job, made
+// to base.
+func (p *RemotePoll) statusURL(base *url.URL, header http.Header, body []byte) (string, error) {
+	var ref string
+	if p.UrlHeader != "" {
+		ref = header.Get(p.UrlHeader)
+		if ref == "" {
+			return "", fmt.Errorf("The response has no %s header to poll", p.UrlHeader)
+		}
+	} else {
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", fmt.Errorf("The response isn't JSON: %s", err)
+		}
+		v, err := jsonpath.Get(doc, p.UrlPath)
+		if err != nil {
+			return "", err
+		}
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return "", fmt.Errorf("The status URL at %s isn't a string", p.UrlPath)
+		}
+		ref = s
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func containsState(states []string, state string) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// poll polls the status URL of the job's first response until the work is
+// in a terminal state, and returns an error unless it's a success state. The
+// output, result and archived response of the run are those of the last
+// status response.
+func (j *JobRunner) poll(p *RemotePoll, base *url.URL, header http.Header, body []byte) error {
+	statusURL, err := p.statusURL(base, header, body)
+	if err != nil {
+		return err
+	}
+	j.logger.Infof("Job %s polls %s", j.job.Name, statusURL)
+
+	// The last status response is the output of the run.
+	var last []byte
+	defer func() {
+		if last != nil {
+			j.output = truncate(string(last), MaxOutputSize)
+			j.archiveResponse(last, "application/json")
+		}
+	}()
+
+	deadline := time.Now().Add(p.timeout())
+	for {
+		status, state, err := j.pollOnce(p, statusURL)
+		if status != nil {
+			last = status
+		}
+		if err != nil {
+			return err
+		}
+		if containsState(p.SuccessStates, state) {
+			if path := j.job.RemoteProperties.ResultPath; path != "" {
+				j.setResult(parseRemoteResult(last, path))
+			}
+			return nil
+		}
+		if containsState(p.FailureStates, state) {
+			return fmt.Errorf("The work ended in state %s", state)
+		}
+
+		wait := p.interval()
+		if remaining := deadline.Sub(time.Now()); remaining < wait {
+			if remaining <= 0 {
+				return fmt.Errorf("The work didn't end after %s", p.timeout())
+			}
+			wait = remaining
+		}
+		select {
+		case <-time.After(wait):
+		case <-j.runContext().Done():
+			return j.runContext().Err()
+		}
+	}
+}
+
+// pollOnce gets the status and returns the response body and the state in
+// it. Server errors are retried at the next poll, with an empty state.
+func (j *JobRunner) pollOnce(p *RemotePoll, statusURL string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", statusURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	if err := j.authorize(req); err != nil {
+		return nil, "", err
+	}
+	httpClient := http.Client{
+		Timeout: j.responseTimeout(),
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		j.logger.Warnf("Error occured polling the status of job %s: %s", j.job.Name, err)
+		return nil, "", nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		j.unauthorized()
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxStepResponseSize))
+	if err != nil {
+		return nil, "", err
+	}
+	if res.StatusCode >= 500 {
+		j.logger.Warnf("Polling the status of job %s got %s", j.job.Name, res.Status)
+		return body, "", nil
+	}
+	if res.StatusCode/100 != 2 {
+		return body, "", fmt.Errorf("Polling the status got %s", res.Status)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body, "", fmt.Errorf("The status isn't JSON: %s", err)
+	}
+	v, err := jsonpath.Get(doc, p.StatePath)
+	if err != nil {
+		return body, "", err
+	}
+	if s, ok := v.(string); ok {
+		return body, s, nil
+	}
+	b, _ := json.Marshal(v)
+	return body, string(b), nil
+}
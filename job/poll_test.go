@@ -0,0 +1,100 @@
+package job
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pollServer starts work on POST /jobs, whose status at /jobs/1 is the next
+// of the states on every poll.
+func pollServer(states ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.Header().Set("Location", "/jobs/1")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"links": {"status": "/jobs/1"}}`))
+			return
+		}
+		state := states[0]
+		if len(states) > 1 {
+			states = states[1:]
+		}
+		if state == "unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"state": "` + state + `", "result": {"rows": 3}}`))
+	}))
+}
+
+func pollingJob(url string, poll *RemotePoll) *JobRunner {
+	j := GetMockRemoteJob(RemoteProperties{
+		Url:                   url + "/jobs",
+		Method:                "POST",
+		ExpectedResponseCodes: []int{202},
+		ResultPath:            "$.result",
+		Poll:                  poll,
+	})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	return runner
+}
+
+func TestRemotePoll(t *testing.T) {
+	ts := pollServer("running", "unavailable", "done")
+	defer ts.Close()
+
+	runner := pollingJob(ts.URL, &RemotePoll{UrlHeader: "Location", StatePath: "$.state", SuccessStates: []string{"done"}, Interval: 1})
+	assert.NoError(t, runner.RemoteRun())
+	assert.Contains(t, runner.output, `"state": "done"`)
+	assert.Equal(t, map[string]interface{}{"rows": float64(3)}, runner.result)
+}
+
+func TestRemotePollFailure(t *testing.T) {
+	ts := pollServer("failed")
+	defer ts.Close()
+
+	runner := pollingJob(ts.URL, &RemotePoll{UrlPath: "$.links.status", StatePath: "$.state", SuccessStates: []string{"done"}, FailureStates: []string{"failed"}})
+	err := runner.RemoteRun()
+	if assert.Error(t, err) {
+		assert.Equal(t, "The work ended in state failed", err.Error())
+	}
+	assert.Nil(t, runner.result)
+}
+
+func TestRemotePollTimeout(t *testing.T) {
+	ts := pollServer("running")
+	defer ts.Close()
+
+	runner := pollingJob(ts.URL, &RemotePoll{UrlHeader: "Location", StatePath: "$.state", SuccessStates: []string{"done"}, Interval: 1, Timeout: 1})
+	assert.Error(t, runner.RemoteRun())
+
+	// Runs stop polling once their context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runner = pollingJob(ts.URL, &RemotePoll{UrlHeader: "Location", StatePath: "$.state", SuccessStates: []string{"done"}})
+	runner.ctx = ctx
+	assert.Equal(t, context.Canceled, runner.RemoteRun())
+}
+
+func TestRemotePollValidation(t *testing.T) {
+	valid := RemotePoll{UrlHeader: "Location", StatePath: "$.state", SuccessStates: []string{"done"}}
+	assert.True(t, valid.valid())
+
+	both := valid
+	both.UrlPath = "$.status_url"
+	assert.False(t, both.valid())
+	noStates := valid
+	noStates.SuccessStates = nil
+	assert.False(t, noStates.valid())
+	badPath := valid
+	badPath.StatePath = "$..state"
+	assert.False(t, badPath.valid())
+
+	steps := RemoteProperties{Steps: []RemoteStep{{Url: "http://example.com"}}, Poll: &valid}
+	assert.False(t, steps.valid())
+}
@@ -0,0 +1,189 @@
+package job
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/utils/gcpauth"
+)
+
+const pubSubScope = "https://www.googleapis.com/auth/pubsub"
+
+var (
+	ErrInvalidPubSubJob = errors.New("Invalid Pub/Sub Job. Pub/Sub Job's must contain a Name, a topic and a valid data template")
+	// ErrCredentialsFileNotAllowed is returned for Pub/Sub jobs with a
+	// credentials file out of the secrets directory, see SetSecrets.
+	ErrCredentialsFileNotAllowed = errors.New("Invalid credentials file. Pub/Sub Jobs may only use the credentials files in the secrets directory of the server")
+)
+
+// PubSubProperties Custom properties for the Pub/Sub job type, which publishes
+// a message to a Google Cloud Pub/Sub topic on every run. Credentials are
+// looked up like Application Default Credentials, e.g. from
+// GOOGLE_APPLICATION_CREDENTIALS or GKE workload identity.
+type PubSubProperties struct {
+	// Defaults to GOOGLE_CLOUD_PROJECT, or the project of the instance.
+	Project string `json:"project"`
+
+	// Name of the topic, e.g. "reports", or its full path, e.g. "projects/my-project/topics/reports".
+	Topic string `json:"topic"`
+
+	// A template of the message data, see TemplateContext.
+	Data string `json:"data"`
+
+	// Attributes to add to the message (e.g. {"source": "kala"})
+	Attributes map[string]string `json:"attributes"`
+
+	// Messages with the same ordering key are delivered in order, if the subscription enables it.
+	OrderingKey string `json:"ordering_key"`
+
+	// Path of a service account key, overriding the default credentials. It
+	// must be in the secrets directory, relative to it unless it's absolute.
+	CredentialsFile string `json:"credentials_file"`
+
+	// A timeout for publishing in seconds
+	Timeout int `json:"timeout"`
+
+	// Overrides the Pub/Sub endpoint, e.g. a regional one. The emulator is used
+	// instead if PUBSUB_EMULATOR_HOST is set.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+func (p *PubSubProperties) valid() bool {
+	return p.Topic != "" && validTemplate(p.Data)
+}
+
+// credentialsFile returns the path of the credentials file, if there is one,
+// or ErrCredentialsFileNotAllowed if it's out of the secrets directory.
+func (p *PubSubProperties) credentialsFile() (string, error) {
+	if p.CredentialsFile == "" {
+		return "", nil
+	}
+	secretsLock.RLock()
+	dir := secretsDir
+	secretsLock.RUnlock()
+	path, ok := fileInDir(dir, p.CredentialsFile)
+	if !ok {
+		return "", ErrCredentialsFileNotAllowed
+	}
+	return path, nil
+}
+
+func (p *PubSubProperties) allowedCredentialsFile() bool {
+	_, err := p.credentialsFile()
+	return err == nil
+}
+
+// topicPath returns the full path of the topic.
+func (p *PubSubProperties) topicPath() (string, error) {
+	if strings.HasPrefix(p.Topic, "projects/") {
+		return p.Topic, nil
+	}
+	project := p.Project
+	if project == "" {
+		var err error
+		project, err = gcpauth.ProjectId()
+		if err != nil {
+			return "", err
+		}
+	}
+	return "projects/" + project + "/topics/" + p.Topic, nil
+}
+
+// PubSubRun publishes the job's message.
+func (j *JobRunner) PubSubRun() error {
+	props := j.job.PubSubProperties
+	timeout := time.Duration(props.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	topic, err := props.topicPath()
+	if err != nil {
+		return err
+	}
+	data, err := j.render(props.Data)
+	if err != nil {
+		return err
+	}
+
+	type message struct {
+		Data        string            `json:"data"`
+		Attributes  map[string]string `json:"attributes,omitempty"`
+		OrderingKey string            `json:"orderingKey,omitempty"`
+	}
+	body, err := json.Marshal(map[string][]message{
+		"messages": {{
+			Data:        base64.StdEncoding.EncodeToString([]byte(data)),
+			Attributes:  props.Attributes,
+			OrderingKey: props.OrderingKey,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := props.Endpoint
+	emulator := os.Getenv("PUBSUB_EMULATOR_HOST")
+	if emulator != "" {
+		endpoint = "http://" + emulator
+	} else if endpoint == "" {
+		endpoint = "https://pubsub.googleapis.com"
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(endpoint, "/")+"/v1/"+topic+":publish", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// The emulator doesn't need credentials.
+	if emulator == "" {
+		credentials, err := props.credentialsFile()
+		if err != nil {
+			return err
+		}
+		source, err := gcpauth.DefaultTokenSource(credentials, pubSubScope)
+		if err != nil {
+			return err
+		}
+		token, err := source.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+
+	httpClient := http.Client{
+		Timeout: timeout,
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		MessageIds []string `json:"messageIds"`
+		Error      struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, int64(MaxOutputSize)))
+	json.Unmarshal(b, &result)
+	if res.StatusCode != http.StatusOK {
+		j.output = string(b)
+		return fmt.Errorf("Publishing to %s failed with %s: %s %s", topic, res.Status, result.Error.Status, result.Error.Message)
+	}
+
+	j.output = fmt.Sprintf("Published message %s to %s", strings.Join(result.MessageIds, ", "), topic)
+	return nil
+}
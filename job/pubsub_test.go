@@ -0,0 +1,96 @@
+package job
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func GetMockPubSubJob(props PubSubProperties) *Job {
+	return &Job{
+		Name:             "mock_pubsub_job",
+		JobType:          PubSubJob,
+		PubSubProperties: props,
+	}
+}
+
+func TestPubSubJobValidation(t *testing.T) {
+	cache := NewMockCache()
+
+	j := GetMockPubSubJob(PubSubProperties{Data: "{{.JobName}}"})
+	assert.Equal(t, ErrInvalidPubSubJob, j.Init(cache))
+
+	j = GetMockPubSubJob(PubSubProperties{Topic: "reports", Data: "{{.JobName}}"})
+	j.Schedule = "R/2100-01-01T00:00:00Z/PT1H"
+	assert.NoError(t, j.Init(cache))
+}
+
+func TestPubSubJobCredentialsFile(t *testing.T) {
+	cache := NewMockCache()
+	props := PubSubProperties{Topic: "reports", Data: "{{.JobName}}", CredentialsFile: "/etc/passwd"}
+
+	// Without a secrets directory, jobs can't use credentials files.
+	assert.Equal(t, ErrCredentialsFileNotAllowed, GetMockPubSubJob(props).Init(cache))
+
+	SetSecrets("/var/run/secrets/kala", DefaultSecretEnvPrefix)
+	defer SetSecrets("", DefaultSecretEnvPrefix)
+	for _, path := range []string{"/etc/passwd", "../passwd", "/var/run/secrets/kala/../passwd"} {
+		props.CredentialsFile = path
+		assert.Equal(t, ErrCredentialsFileNotAllowed, GetMockPubSubJob(props).Init(cache), path)
+	}
+	for _, path := range []string{"pubsub.json", "/var/run/secrets/kala/pubsub.json"} {
+		props.CredentialsFile = path
+		j := GetMockPubSubJob(props)
+		j.Schedule = "R/2100-01-01T00:00:00Z/PT1H"
+		assert.NoError(t, j.Init(cache), path)
+	}
+}
+
+func TestPubSubRun(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/my-project/topics/reports:publish" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "Topic not found", "status": "NOT_FOUND"}}`))
+			return
+		}
+
+		var body struct {
+			Messages []struct {
+				Data       []byte
+				Attributes map[string]string
+			}
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if assert.Len(t, body.Messages, 1) {
+			assert.Equal(t, "mock_pubsub_job", string(body.Messages[0].Data))
+			assert.Equal(t, "kala", body.Messages[0].Attributes["source"])
+		}
+		w.Write([]byte(`{"messageIds": ["42"]}`))
+	}))
+	defer ts.Close()
+
+	os.Setenv("PUBSUB_EMULATOR_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	defer os.Unsetenv("PUBSUB_EMULATOR_HOST")
+
+	j := GetMockPubSubJob(PubSubProperties{
+		Project:    "my-project",
+		Topic:      "reports",
+		Data:       "{{.JobName}}",
+		Attributes: map[string]string{"source": "kala"},
+	})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.PubSubRun())
+	assert.Equal(t, "Published message 42 to projects/my-project/topics/reports", runner.output)
+
+	j.PubSubProperties.Topic = "projects/my-project/topics/missing"
+	err := runner.PubSubRun()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Topic not found")
+	}
+}
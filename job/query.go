@@ -0,0 +1,236 @@
+package job
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Query selects jobs with conditions on their fields, all of which must
+// match, e.g. name~"backup" AND owner="data" AND disabled=false. Conditions
+// compare a field with = and != , or check that it contains a value with ~,
+// ignoring case. Values are quoted, or single words such as true.
+type Query struct {
+	conditions []condition
+}
+
+type condition struct {
+	field string
+	op    string
+	value string
+}
+
+// The fields of queries, and how to read them. The lock of the job must be
+// held.
+var queryFields = map[string]func(j *Job) []string{
+	"id":              func(j *Job) []string { return []string{j.Id} },
+	"name":            func(j *Job) []string { return []string{j.Name} },
+	"owner":           func(j *Job) []string { return []string{j.Owner} },
+	"namespace":       func(j *Job) []string { return []string{j.Namespace} },
+	"group":           func(j *Job) []string { return groupPaths(j.Group) },
+	"tag":             func(j *Job) []string { return j.Tags },
+	"type":            func(j *Job) []string { return []string{j.TypeName()} },
+	"command":         func(j *Job) []string { return []string{j.Command} },
+	"schedule":        func(j *Job) []string { return []string{j.Schedule} },
+	"trigger_subject": func(j *Job) []string { return []string{j.TriggerSubject} },
+	"disabled":        func(j *Job) []string { return []string{fmt.Sprint(j.Disabled)} },
+	"done":            func(j *Job) []string { return []string{fmt.Sprint(j.IsDone)} },
+}
+
+var boolQueryFields = map[string]bool{"disabled": true, "done": true}
+
+// ParseQuery parses a query, see Query. The empty query matches all jobs.
+func ParseQuery(s string) (*Query, error) {
+	tokens, err := tokenizeQuery(s)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Query{}
+	for i := 0; i < len(tokens); {
+		if len(q.conditions) != 0 {
+			if !strings.EqualFold(tokens[i].text, "AND") || tokens[i].quoted {
+				return nil, fmt.Errorf("Invalid query: expected AND at %d, got %q", tokens[i].pos, tokens[i].text)
+			}
+			i++
+		}
+		if i+3 > len(tokens) {
+			return nil, fmt.Errorf("Invalid query: expected a condition such as name=\"backup\" at the end")
+		}
+		field, op, value := tokens[i], tokens[i+1], tokens[i+2]
+		if _, ok := queryFields[field.text]; !ok || field.quoted {
+			return nil, fmt.Errorf("Invalid query: unknown field %q at %d", field.text, field.pos)
+		}
+		if op.quoted || (op.text != "=" && op.text != "!=" && op.text != "~") {
+			return nil, fmt.Errorf("Invalid query: expected =, != or ~ at %d, got %q", op.pos, op.text)
+		}
+		if boolQueryFields[field.text] && (op.text == "~" || (value.text != "true" && value.text != "false")) {
+			return nil, fmt.Errorf("Invalid query: %s should be compared with =true or =false at %d", field.text, op.pos)
+		}
+		q.conditions = append(q.conditions, condition{field: field.text, op: op.text, value: value.text})
+		i += 3
+	}
+	return q, nil
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+// tokenizeQuery splits a query into fields, operators, values and ANDs.
+func tokenizeQuery(s string) ([]queryToken, error) {
+	tokens := []queryToken{}
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '=' || r == '~':
+			tokens = append(tokens, queryToken{text: string(r), pos: i})
+			i++
+		case r == '!':
+			if i+1 == len(runes) || runes[i+1] != '=' {
+				return nil, fmt.Errorf("Invalid query: expected != at %d", i)
+			}
+			tokens = append(tokens, queryToken{text: "!=", pos: i})
+			i += 2
+		case r == '"':
+			start := i
+			value := []rune{}
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value = append(value, runes[i])
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("Invalid query: unterminated quote at %d", start)
+			}
+			tokens = append(tokens, queryToken{text: string(value), quoted: true, pos: start})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`=!~"`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, queryToken{text: string(runes[start:i]), pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+func (c condition) matches(j *Job) bool {
+	values := queryFields[c.field](j)
+	for _, v := range values {
+		if c.matchesValue(v) {
+			return c.op != "!="
+		}
+	}
+	// No value matches, e.g. the job has no tags.
+	return c.op == "!="
+}
+
+func (c condition) matchesValue(v string) bool {
+	if c.op == "~" {
+		return strings.Contains(strings.ToLower(v), strings.ToLower(c.value))
+	}
+	return v == c.value
+}
+
+// Matches says if the job matches the query.
+func (q *Query) Matches(j *Job) bool {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	for _, c := range q.conditions {
+		if !c.matches(j) {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates returns the ids of the jobs which may match the query, found
+// in the index with the conditions on owners, names, tags and groups, or
+// false if the query has none.
+func (x *jobIndex) candidates(q *Query) (idSet, bool) {
+	x.lock.RLock()
+	defer x.lock.RUnlock()
+
+	var candidates idSet
+	for _, c := range q.conditions {
+		var index map[string]idSet
+		switch c.field {
+		case "owner":
+			index = x.owners
+		case "name":
+			index = x.names
+		case "tag":
+			index = x.tags
+		case "group":
+			index = x.groups
+		}
+		if index == nil || c.op == "!=" {
+			continue
+		}
+
+		ids := idSet{}
+		if c.op == "=" {
+			ids = index[c.value]
+		} else {
+			for key, keyIds := range index {
+				if c.matchesValue(key) {
+					for id := range keyIds {
+						ids[id] = struct{}{}
+					}
+				}
+			}
+		}
+		if candidates == nil {
+			candidates = idSet{}
+			for id := range ids {
+				candidates[id] = struct{}{}
+			}
+			continue
+		}
+		for id := range candidates {
+			if _, ok := ids[id]; !ok {
+				delete(candidates, id)
+			}
+		}
+	}
+	return candidates, candidates != nil
+}
+
+// searchJobs returns the jobs of the cache matching the query, ordered by id,
+// only reading the jobs which its index can't rule out.
+func searchJobs(cache JobCache, index *jobIndex, q *Query) []*Job {
+	jobs := []*Job{}
+	if ids, ok := index.candidates(q); ok {
+		for id := range ids {
+			if j, _ := cache.Get(id); j != nil && q.Matches(j) {
+				jobs = append(jobs, j)
+			}
+		}
+	} else {
+		// The jobs are read without holding the lock of the map, which
+		// Set may be called with the lock of a job to take.
+		all := cache.GetAll()
+		all.Lock.RLock()
+		candidates := make([]*Job, 0, len(all.Jobs))
+		for _, j := range all.Jobs {
+			candidates = append(candidates, j)
+		}
+		all.Lock.RUnlock()
+		for _, j := range candidates {
+			if q.Matches(j) {
+				jobs = append(jobs, j)
+			}
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Id < jobs[k].Id })
+	return jobs
+}
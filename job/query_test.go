@@ -0,0 +1,68 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery(`name~"nightly backup" and owner="data" AND disabled=false AND tag!=db`)
+	assert.NoError(t, err)
+	assert.Equal(t, []condition{
+		{"name", "~", "nightly backup"},
+		{"owner", "=", "data"},
+		{"disabled", "=", "false"},
+		{"tag", "!=", "db"},
+	}, q.conditions)
+
+	q, err = ParseQuery(`command="bash -c \"date\""`)
+	assert.NoError(t, err)
+	assert.Equal(t, `bash -c "date"`, q.conditions[0].value)
+
+	q, err = ParseQuery("  ")
+	assert.NoError(t, err)
+	assert.Empty(t, q.conditions)
+
+	for _, invalid := range []string{
+		`name`,
+		`name="backup" owner="data"`,
+		`color="red"`,
+		`name>"backup"`,
+		`name ! "backup"`,
+		`name="backup`,
+		`disabled~true`,
+		`disabled=maybe`,
+	} {
+		_, err := ParseQuery(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCacheSearch(t *testing.T) {
+	for _, cache := range []JobCache{NewMockCache(), NewMemoryJobCache(&MockDB{})} {
+		add := func(id, owner, name string, tags ...string) *Job {
+			j := GetMockJob()
+			j.Id, j.Owner, j.Name, j.Tags = id, owner, name, tags
+			assert.NoError(t, cache.Set(j))
+			return j
+		}
+		add("a", "data", "Nightly Backup", "db")
+		add("b", "data", "cleanup", "nightly")
+		add("c", "ops", "backup logs")
+		add("d", "data", "weekly backup").Disable()
+
+		search := func(query string) []string {
+			q, err := ParseQuery(query)
+			assert.NoError(t, err)
+			return ids(cache.Search(q))
+		}
+		assert.Equal(t, []string{"a", "b", "c", "d"}, search(""))
+		assert.Equal(t, []string{"a", "c", "d"}, search(`name~"backup"`))
+		assert.Equal(t, []string{"a"}, search(`name~"backup" AND owner="data" AND disabled=false`))
+		assert.Equal(t, []string{"d"}, search(`disabled=true`))
+		assert.Equal(t, []string{"b", "c", "d"}, search(`tag!="db"`))
+		assert.Equal(t, []string{"b"}, search(`tag~NIGHT AND owner!=ops`))
+		assert.Empty(t, search(`owner="nobody"`))
+	}
+}
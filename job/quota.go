@@ -0,0 +1,197 @@
+package job
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var (
+	ErrOwnerMaxJobs = errors.New("The owner already has as many jobs as its quota allows")
+	ErrMaxRuns      = errors.New("The namespace or owner of the job already started as many runs in the last minute as its quota allows")
+)
+
+// Quota limits the jobs of a namespace or an owner. Zero values don't limit.
+type Quota struct {
+	// Jobs the namespace or owner may have.
+	MaxJobs int `json:"max_jobs,omitempty"`
+	// Runs started in any minute. Scheduled runs beyond it are skipped, and
+	// manual starts rejected.
+	MaxRunsPerMinute int `json:"max_runs_per_minute,omitempty"`
+	// Stats kept for every job, dropping the oldest as runs end. It caps the
+	// max_stats of the jobs and the retention.
+	MaxStats int `json:"max_stats,omitempty"`
+}
+
+func (q Quota) negative() bool {
+	return q.MaxJobs < 0 || q.MaxRunsPerMinute < 0 || q.MaxStats < 0
+}
+
+// runLog holds the start times of the runs of the last minute, oldest first.
+type runLog []time.Time
+
+// since drops the runs started before t.
+func (l runLog) since(t time.Time) runLog {
+	i := 0
+	for i < len(l) && l[i].Before(t) {
+		i++
+	}
+	return l[i:]
+}
+
+// quotas returns the quotas of the namespace and the owner, which may be nil.
+func (n *Namespaces) quotas(ns, owner string) (nsQuota, ownerQuota *Quota) {
+	if c := n.Config(ns); ns != "" && c != nil {
+		nsQuota = &c.Quota
+	}
+	if owner != "" {
+		ownerQuota = n.Owners[owner]
+	}
+	return nsQuota, ownerQuota
+}
+
+// runWait returns how long a run of a job of the namespace and owner has to
+// wait to stay within their runs per minute, 0 if it can start now. The lock
+// must be held.
+func (n *Namespaces) runWait(ns, owner string, now time.Time) time.Duration {
+	nsQuota, ownerQuota := n.quotas(ns, owner)
+	wait := time.Duration(0)
+	check := func(q *Quota, runs map[string]runLog, key string) {
+		if q == nil || q.MaxRunsPerMinute == 0 {
+			return
+		}
+		log := runs[key].since(now.Add(-time.Minute))
+		runs[key] = log
+		if len(log) < q.MaxRunsPerMinute {
+			return
+		}
+		// The run can start once enough runs left the window.
+		if w := log[len(log)-q.MaxRunsPerMinute].Add(time.Minute).Sub(now); w > wait {
+			wait = w
+		}
+	}
+	n.initRuns()
+	check(nsQuota, n.namespaceRuns, ns)
+	check(ownerQuota, n.ownerRuns, owner)
+	return wait
+}
+
+func (n *Namespaces) initRuns() {
+	if n.namespaceRuns == nil {
+		n.namespaceRuns = map[string]runLog{}
+		n.ownerRuns = map[string]runLog{}
+	}
+}
+
+// RunQuotaWait returns how long the job has to wait before it can start a run
+// within the runs per minute of its namespace and owner, 0 if it can start
+// now.
+func (n *Namespaces) RunQuotaWait(j *Job, now time.Time) time.Duration {
+	j.lock.RLock()
+	ns, owner := j.Namespace, j.Owner
+	j.lock.RUnlock()
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.runWait(ns, owner, now)
+}
+
+// takeRun counts a run of a job of the namespace and owner starting now,
+// unless it would exceed their runs per minute, returning ErrMaxRuns then.
+func (n *Namespaces) takeRun(ns, owner string, now time.Time) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.runWait(ns, owner, now) > 0 {
+		return ErrMaxRuns
+	}
+	// Runs are counted for every quota, for its usage.
+	since := now.Add(-time.Minute)
+	nsQuota, ownerQuota := n.quotas(ns, owner)
+	if nsQuota != nil {
+		n.namespaceRuns[ns] = append(n.namespaceRuns[ns].since(since), now)
+	}
+	if ownerQuota != nil {
+		n.ownerRuns[owner] = append(n.ownerRuns[owner].since(since), now)
+	}
+	return nil
+}
+
+// maxStats returns the stats the quotas of the namespace and owner let a job
+// keep, 0 if they don't limit them.
+func (n *Namespaces) maxStats(ns, owner string) int {
+	max := 0
+	nsQuota, ownerQuota := n.quotas(ns, owner)
+	for _, q := range []*Quota{nsQuota, ownerQuota} {
+		if q != nil && q.MaxStats > 0 && (max == 0 || q.MaxStats < max) {
+			max = q.MaxStats
+		}
+	}
+	return max
+}
+
+// QuotaUsage is how much of its quota a namespace or an owner uses.
+type QuotaUsage struct {
+	Namespace string `json:"namespace,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	Quota
+
+	Jobs           int `json:"jobs"`
+	RunsLastMinute int `json:"runs_last_minute"`
+	// Stats kept for the jobs.
+	Stats int `json:"stats"`
+}
+
+// quotaCounts are the jobs of a namespace or an owner, and their stats.
+type quotaCounts struct {
+	jobs, stats int
+}
+
+// add counts the job, whose lock must be held.
+func (c quotaCounts) add(j *Job) quotaCounts {
+	c.jobs++
+	c.stats += len(j.Stats)
+	return c
+}
+
+// usage returns the usage of every namespace and owner with a quota, given
+// the counts of every namespace and owner, namespaces first.
+func (n *Namespaces) usage(namespaces, owners map[string]quotaCounts, now time.Time) []*QuotaUsage {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.initRuns()
+
+	usage := []*QuotaUsage{}
+	for ns, c := range n.Namespaces {
+		if c == nil {
+			continue
+		}
+		n.namespaceRuns[ns] = n.namespaceRuns[ns].since(now.Add(-time.Minute))
+		usage = append(usage, &QuotaUsage{
+			Namespace:      ns,
+			Quota:          c.Quota,
+			Jobs:           namespaces[ns].jobs,
+			RunsLastMinute: len(n.namespaceRuns[ns]),
+			Stats:          namespaces[ns].stats,
+		})
+	}
+	for owner, q := range n.Owners {
+		if q == nil {
+			continue
+		}
+		n.ownerRuns[owner] = n.ownerRuns[owner].since(now.Add(-time.Minute))
+		usage = append(usage, &QuotaUsage{
+			Owner:          owner,
+			Quota:          *q,
+			Jobs:           owners[owner].jobs,
+			RunsLastMinute: len(n.ownerRuns[owner]),
+			Stats:          owners[owner].stats,
+		})
+	}
+	sort.Slice(usage, func(i, k int) bool {
+		if (usage[i].Namespace == "") != (usage[k].Namespace == "") {
+			return usage[i].Namespace != ""
+		}
+		return usage[i].Namespace+usage[i].Owner < usage[k].Namespace+usage[k].Owner
+	})
+	return usage
+}
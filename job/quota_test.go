@@ -0,0 +1,95 @@
+package job
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOwnerQuotas(t *testing.T) {
+	n, err := ParseNamespaces(strings.NewReader(`{
+		"namespaces": {"data": {"max_jobs": 2, "max_stats": 10}},
+		"owners": {"ops@example.com": {"max_jobs": 1, "max_runs_per_minute": 60}}
+	}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 10, n.Config("data").MaxStats)
+		assert.Equal(t, 60, n.Owners["ops@example.com"].MaxRunsPerMinute)
+	}
+
+	_, err = ParseNamespaces(strings.NewReader(`{"owners": {"ops@example.com": {"max_stats": -1}, "web@example.com": null}}`))
+	if assert.Error(t, err) {
+		assert.Equal(t, "Invalid namespaces: owner ops@example.com has a negative quota; owner web@example.com has no settings", err.Error())
+	}
+}
+
+func TestOwnerMaxJobs(t *testing.T) {
+	n := &Namespaces{Owners: map[string]*Quota{"example@example.com": {MaxJobs: 1}}}
+	cache := NewMockCache()
+
+	j := GetMockJob()
+	assert.NoError(t, n.CheckQuota(cache, j))
+	assert.NoError(t, j.Init(cache))
+
+	other := GetMockJob()
+	assert.Equal(t, ErrOwnerMaxJobs, n.CheckQuota(cache, other))
+	other.Owner = "ops@example.com"
+	assert.NoError(t, n.CheckQuota(cache, other))
+}
+
+func TestMaxRunsPerMinute(t *testing.T) {
+	n := &Namespaces{
+		Namespaces: map[string]*NamespaceConfig{"data": {Quota: Quota{MaxRunsPerMinute: 2}}},
+		Owners:     map[string]*Quota{"ops@example.com": {MaxRunsPerMinute: 1}},
+	}
+	now := time.Now()
+
+	assert.NoError(t, n.takeRun("data", "", now))
+	assert.NoError(t, n.takeRun("data", "", now.Add(10*time.Second)))
+	assert.Equal(t, ErrMaxRuns, n.takeRun("data", "", now.Add(20*time.Second)))
+	assert.Equal(t, 40*time.Second, n.runWait("data", "", now.Add(20*time.Second)))
+	// The first run left the window.
+	assert.NoError(t, n.takeRun("data", "", now.Add(time.Minute)))
+
+	// Both the quotas of the namespace and of the owner apply.
+	assert.NoError(t, n.takeRun("", "ops@example.com", now))
+	assert.Equal(t, ErrMaxRuns, n.takeRun("web", "ops@example.com", now))
+	assert.NoError(t, n.takeRun("web", "dev@example.com", now))
+}
+
+func TestMaxRunsPerMinuteSkipsRuns(t *testing.T) {
+	SetNamespaces(&Namespaces{Owners: map[string]*Quota{"quota@example.com": {MaxRunsPerMinute: 1}}})
+	defer SetNamespaces(&Namespaces{})
+	cache := NewMockCache()
+	j, stop, err := GetMockJobRunByTest(cache, func(j *Job) {
+		j.Owner = "quota@example.com"
+	})
+	assert.NoError(t, err)
+	defer stop()
+
+	j.Run(cache)
+	j.Run(cache)
+	assert.Equal(t, 1, len(j.Stats))
+	assert.True(t, GetNamespaces().RunQuotaWait(j, time.Now()) > 0)
+}
+
+func TestStatsQuota(t *testing.T) {
+	SetNamespaces(&Namespaces{Namespaces: map[string]*NamespaceConfig{"data": {Quota: Quota{MaxStats: 2}}}})
+	defer SetNamespaces(&Namespaces{})
+	cache := NewMockCache()
+	j := GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	j.Namespace = "data"
+	j.MaxStats = 5
+	assert.NoError(t, j.Init(cache))
+
+	for i := 0; i < 3; i++ {
+		j.Run(cache)
+	}
+	assert.Equal(t, 2, len(j.Stats))
+
+	o := NewOverview(cache)
+	if assert.Equal(t, 1, len(o.Quotas)) {
+		assert.Equal(t, &QuotaUsage{Namespace: "data", Quota: Quota{MaxStats: 2}, Jobs: 1, RunsLastMinute: 3, Stats: 2}, o.Quotas[0])
+	}
+}
@@ -0,0 +1,77 @@
+package job
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrExecutionNotFound = errors.New("Execution not found")
+	ErrNotReplayed       = errors.New("The execution wasn't replayed, as the job is disabled, over its quota, or Kala is shutting down")
+)
+
+// replay is a run replaying an execution of a job.
+type replay struct {
+	jobId string
+	// The stat of the execution replayed.
+	of *JobStat
+	// The stat of the replay, set once it starts.
+	stat *JobStat
+}
+
+// start records on the stat of the replay which execution it replays, and
+// when it was due.
+func (r *replay) start(stat *JobStat) {
+	stat.ReplayOf = r.of.RunId
+	stat.ScheduledAt = r.of.ScheduledAt
+	if stat.ScheduledAt.IsZero() {
+		stat.ScheduledAt = r.of.RanAt
+	}
+	r.stat = stat
+}
+
+// replay returns the replay the run is, or nil. The dependent jobs of a
+// replay get its context, but aren't replays themselves.
+func (j *JobRunner) replay() *replay {
+	r, _ := j.runContext().Value(replayKey).(*replay)
+	if r == nil || r.jobId != j.job.Id {
+		return nil
+	}
+	return r
+}
+
+// Replay runs the job again with the template context of its execution with
+// the run id: the time it ran, the time it was due and the message which
+// triggered it, e.g. to reprocess the data of the window of a failed run.
+// Like a manual start, it runs the job now and schedules its next run anew.
+// It returns the stat of the replay, whose ReplayOf is the run id.
+func (j *Job) Replay(ctx context.Context, cache JobCache, runId string) (*JobStat, error) {
+	j.lock.RLock()
+	var of *JobStat
+	for _, stat := range j.Stats {
+		if stat.RunId == runId {
+			s := *stat
+			of = &s
+		}
+	}
+	j.lock.RUnlock()
+	if of == nil {
+		return nil, ErrExecutionNotFound
+	}
+
+	r := &replay{jobId: j.Id, of: of}
+	ctx = context.WithValue(ctx, replayKey, r)
+	if of.Trigger != nil {
+		ctx = WithTriggerMessage(ctx, of.Trigger.Subject, of.Trigger.Data)
+	}
+	j.StopTimer()
+	j.RunWithContext(ctx, cache)
+	if r.stat == nil {
+		return nil, ErrNotReplayed
+	}
+
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	stat := *r.stat
+	return &stat, nil
+}
@@ -0,0 +1,62 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplay(t *testing.T) {
+	cache := NewMockCache()
+	j, stop, err := GetMockJobRunByTest(cache, nil)
+	assert.NoError(t, err)
+	defer stop()
+
+	due := time.Now().Add(-time.Minute)
+	j.RunWithContext(WithTriggerMessage(withScheduledAt(context.Background(), due), "orders.created", []byte("order-1")), cache)
+	if !assert.Equal(t, 1, len(j.Stats)) {
+		return
+	}
+	original := j.Stats[0]
+	assert.Equal(t, due, original.ScheduledAt)
+
+	stat, err := j.Replay(context.Background(), cache, original.RunId)
+	if assert.NoError(t, err) {
+		assert.Equal(t, original.RunId, stat.ReplayOf)
+		assert.NotEqual(t, original.RunId, stat.RunId)
+		assert.Equal(t, due, stat.ScheduledAt)
+		assert.Equal(t, &TriggerMessage{"orders.created", []byte("order-1")}, stat.Trigger)
+		assert.Equal(t, 2, len(j.Stats))
+	}
+
+	_, err = j.Replay(context.Background(), cache, "missing")
+	assert.Equal(t, ErrExecutionNotFound, err)
+
+	j.Disable()
+	_, err = j.Replay(context.Background(), cache, original.RunId)
+	assert.Equal(t, ErrNotReplayed, err)
+}
+
+func TestReplayTemplateContext(t *testing.T) {
+	j := GetMockJob()
+	j.Id = "id"
+	due := time.Now().Add(-time.Hour)
+	of := &JobStat{RunId: "run-1", RanAt: due.Add(time.Second), ScheduledAt: due}
+	runner := &JobRunner{job: j, ctx: context.WithValue(context.Background(), replayKey, &replay{jobId: j.Id, of: of})}
+	runner.runSetup()
+
+	c := runner.templateContext()
+	assert.Equal(t, of.RanAt, c.Time)
+	assert.Equal(t, due, c.ScheduledAt)
+	assert.Equal(t, "run-1", c.ReplayOf)
+	assert.NotEqual(t, "run-1", c.RunId)
+
+	// Dependent jobs of a replay aren't replays.
+	dependent := GetMockJob()
+	dependent.Id = "dependent"
+	child := &JobRunner{job: dependent, ctx: runner.ctx}
+	child.runSetup()
+	assert.Equal(t, "", child.templateContext().ReplayOf)
+}
@@ -0,0 +1,203 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"sync"
+
+	"github.com/ajvb/kala/notify"
+)
+
+var (
+	ErrInvalidId            = errors.New("Invalid id. Ids are letters, digits, dashes, underscores and dots, e.g. nightly-report")
+	ErrJobExists            = errors.New("A job with the id already exists")
+	ErrStaleResourceVersion = errors.New("The job changed since the given resource version. Get the job again and retry with its resource version")
+)
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9][-_.A-Za-z0-9]{0,127}$`)
+
+// ValidId returns whether the id can be given to a job created with
+// InitWithId.
+func ValidId(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// resourcesLock serializes creating jobs with their ids and changing jobs at
+// their resource versions, so that two of them can't both succeed. It's taken
+// before linksLock.
+var resourcesLock sync.Mutex
+
+// stateFields are the fields of a job which Update keeps, as they're changed
+// by Kala as the job runs rather than by its settings.
+var stateFields = map[string]bool{
+	"Id":              true,
+	"ResourceVersion": true,
+	"DependentJobs":   true,
+	"DisabledInfo":    true,
+	"NextRunAt":       true,
+	"Metadata":        true,
+	"Stats":           true,
+	"IsDone":          true,
+}
+
+// InitWithId is like InitWithContext, but the job gets the id rather than a
+// new one, so that clients can create jobs with ids they choose, e.g. the
+// names of their resources in Terraform. It returns ErrJobExists if a job
+// already has the id.
+func (j *Job) InitWithId(ctx context.Context, cache JobCache, id string) error {
+	if !ValidId(id) {
+		return ErrInvalidId
+	}
+	resourcesLock.Lock()
+	defer resourcesLock.Unlock()
+
+	if existing, _ := cache.Get(id); existing != nil {
+		return ErrJobExists
+	}
+	return j.initWithId(ctx, cache, id)
+}
+
+// Update replaces the settings of the job with those of spec, if the job is
+// still at the resource version, else it returns ErrStaleResourceVersion. Its
+// id, stats, metadata and dependent jobs are kept, its resource version is
+// incremented and it's scheduled again from its new schedule. Unlike a new
+// job, an updated job without a schedule isn't run.
+func (j *Job) Update(cache JobCache, spec *Job, version uint64) error {
+	if err := spec.validation(); err != nil {
+		return err
+	}
+	if spec.Schedule != "" && len(spec.ParentJobs) == 0 {
+		if err := spec.InitDelayDuration(false); err != nil {
+			return ErrInvalidSchedule
+		}
+	}
+
+	resourcesLock.Lock()
+	defer resourcesLock.Unlock()
+	linksLock.Lock()
+	defer linksLock.Unlock()
+
+	for _, p := range spec.ParentJobs {
+		if parent, _ := cache.Get(p); parent == nil || p == j.Id {
+			return ErrJobDoesntExist
+		}
+	}
+
+	j.lock.Lock()
+	if j.ResourceVersion != version {
+		j.lock.Unlock()
+		return ErrStaleResourceVersion
+	}
+	if j.jobTimer != nil {
+		j.jobTimer.Stop()
+	}
+	if j.unsubscribeTrigger != nil {
+		if err := j.unsubscribeTrigger(); err != nil {
+			runnerLog.WithField("job_id", j.Id).Errorf("Error unsubscribing from %s: %s", j.TriggerSubject, err)
+		}
+		j.unsubscribeTrigger = nil
+	}
+	oldParents := j.ParentJobs
+	// The secrets redacted in the job read from the API are kept.
+	spec.RemoteProperties.Auth.keepSecrets(j.RemoteProperties.Auth)
+
+	src, dst := reflect.ValueOf(spec).Elem(), reflect.ValueOf(j).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if dst.Field(i).CanSet() && !stateFields[dst.Type().Field(i).Name] {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	if !j.Disabled {
+		j.DisabledInfo = nil
+	}
+	j.ResourceVersion++
+	j.changed()
+	notify.Dispatch(j.event(notify.JobUpdated, nil, nil))
+	j.lock.Unlock()
+
+	j.relink(cache, oldParents)
+	if err := cache.Set(j); err != nil {
+		return err
+	}
+
+	j.lock.Lock()
+	if j.TriggerSubject != "" {
+		if err := j.subscribe(cache); err != nil {
+			runnerLog.WithField("job_id", j.Id).Errorf("Error occured subscribing to %s: %s", j.TriggerSubject, err)
+		}
+	}
+	j.lock.Unlock()
+
+	if j.Schedule == "" || len(j.ParentJobs) != 0 {
+		return nil
+	}
+	if err := j.InitDelayDuration(false); err != nil {
+		return err
+	}
+	if j.ShouldStartWaiting() {
+		j.StartWaiting(cache)
+	}
+	return nil
+}
+
+// relink moves the job from the dependent jobs of its old parent jobs to
+// those of its parent jobs. linksLock must be held.
+func (j *Job) relink(cache JobCache, oldParents []string) {
+	j.lock.RLock()
+	parents := append([]string(nil), j.ParentJobs...)
+	j.lock.RUnlock()
+
+	for _, id := range oldParents {
+		if containsId(parents, id) {
+			continue
+		}
+		if parent, _ := cache.Get(id); parent != nil {
+			parent.lock.Lock()
+			parent.DependentJobs = withoutId(append([]string(nil), parent.DependentJobs...), j.Id)
+			parent.changed()
+			parent.lock.Unlock()
+		}
+	}
+	for _, id := range parents {
+		if containsId(oldParents, id) {
+			continue
+		}
+		if parent, _ := cache.Get(id); parent != nil {
+			parent.lock.Lock()
+			parent.DependentJobs = append(parent.DependentJobs, j.Id)
+			parent.changed()
+			parent.lock.Unlock()
+		}
+	}
+}
+
+// staleWrite returns whether setting the job in a cache would replace the
+// existing job with its id by an older version of it. A copy of the current
+// version isn't stale. The job is the caller's: either its lock is held, or
+// it isn't shared yet. Caches call it under the lock guarding the existing
+// job's entry, so that the job they check is the job they replace.
+func staleWrite(existing, j *Job) bool {
+	if existing == nil || existing == j {
+		return false
+	}
+	existing.lock.RLock()
+	defer existing.lock.RUnlock()
+	return j.ResourceVersion < existing.ResourceVersion
+}
+
+// DeleteAtVersion deletes the job like DeleteWithContext, if it's still at
+// the resource version, else it returns ErrStaleResourceVersion.
+func (j *Job) DeleteAtVersion(ctx context.Context, cache JobCache, db JobDB, version uint64) error {
+	resourcesLock.Lock()
+	defer resourcesLock.Unlock()
+
+	j.lock.RLock()
+	current := j.ResourceVersion
+	j.lock.RUnlock()
+	if current != version {
+		return ErrStaleResourceVersion
+	}
+	return j.DeleteWithContext(ctx, cache, db)
+}
@@ -0,0 +1,64 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitWithId(t *testing.T) {
+	cache := NewMockCache()
+
+	j := GetMockJobWithGenericSchedule()
+	assert.NoError(t, j.InitWithId(context.Background(), cache, "nightly-report"))
+	assert.Equal(t, "nightly-report", j.Id)
+	assert.Equal(t, uint64(1), j.ResourceVersion)
+
+	assert.Equal(t, ErrJobExists, GetMockJobWithGenericSchedule().InitWithId(context.Background(), cache, "nightly-report"))
+	assert.Equal(t, ErrInvalidId, GetMockJobWithGenericSchedule().InitWithId(context.Background(), cache, "nightly report"))
+	assert.Equal(t, ErrInvalidId, GetMockJobWithGenericSchedule().InitWithId(context.Background(), cache, ""))
+
+	j.Disable()
+	assert.Equal(t, uint64(2), j.ResourceVersion)
+	j.Enable(cache)
+	assert.Equal(t, uint64(3), j.ResourceVersion)
+}
+
+func TestUpdate(t *testing.T) {
+	cache := NewMockCache()
+	parent, other := GetMockJobWithGenericSchedule(), GetMockJobWithGenericSchedule()
+	assert.NoError(t, parent.Init(cache))
+	assert.NoError(t, other.Init(cache))
+	j := GetMockJob()
+	j.ParentJobs = []string{parent.Id}
+	assert.NoError(t, j.Init(cache))
+	j.Metadata.SuccessCount = 3
+
+	spec := GetMockJob()
+	spec.Command = "echo updated"
+	spec.ParentJobs = []string{other.Id}
+	assert.NoError(t, j.Update(cache, spec, 1))
+	assert.Equal(t, "echo updated", j.Command)
+	assert.Equal(t, uint64(2), j.ResourceVersion)
+	assert.Equal(t, uint(3), j.Metadata.SuccessCount)
+	assert.Empty(t, parent.DependentJobs)
+	assert.Equal(t, []string{j.Id}, other.DependentJobs)
+
+	assert.Equal(t, ErrStaleResourceVersion, j.Update(cache, GetMockJob(), 1))
+	assert.Equal(t, "echo updated", j.Command)
+
+	// An updated schedule is scheduled again.
+	spec = GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	assert.NoError(t, j.Update(cache, spec, 2))
+	assert.Empty(t, other.DependentJobs)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), j.NextRunAt, time.Minute)
+	j.StopTimer()
+
+	db := &MockDB{}
+	assert.Equal(t, ErrStaleResourceVersion, j.DeleteAtVersion(context.Background(), cache, db, 2))
+	assert.NoError(t, j.DeleteAtVersion(context.Background(), cache, db, 3))
+	_, err := cache.Get(j.Id)
+	assert.Error(t, err)
+}
@@ -0,0 +1,85 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/ajvb/kala/utils/jsonpath"
+)
+
+var ErrInvalidParseResult = errors.New("Invalid parse_result. Only local jobs parse their output, remote jobs set a result_path")
+
+// MaxResultSize is the number of bytes of the end of the standard output of
+// local jobs, and of the beginning of the responses of remote jobs, read to
+// parse their result from.
+var MaxResultSize = 64 << 10
+
+// parseResult parses the result of a run: the JSON object which is the last
+// line of the standard output of local jobs with ParseResult. It returns an
+// error if the line isn't a JSON object.
+func parseResult(stdout string) (map[string]interface{}, error) {
+	lines := strings.Split(strings.TrimRight(stdout, "\r\n"), "\n")
+	result := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &result); err != nil {
+		return nil, fmt.Errorf("The last line of the output isn't a JSON object: %s", err)
+	}
+	return result, nil
+}
+
+// parseRemoteResult parses the result of a run of a remote job: the JSON
+// object at the ResultPath of its response body.
+func parseRemoteResult(body []byte, path string) (map[string]interface{}, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("The response isn't JSON: %s", err)
+	}
+	v, err := jsonpath.Get(doc, path)
+	if err != nil {
+		return nil, err
+	}
+	result, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("The result at %s isn't a JSON object", path)
+	}
+	return result, nil
+}
+
+// setResult records the result of the attempt, logging why it has none if
+// it couldn't be parsed. Runs don't fail for their result.
+func (j *JobRunner) setResult(result map[string]interface{}, err error) {
+	if err != nil {
+		j.logger.Warnf("Job %s has no result: %s", j.job.Name, err)
+	}
+	j.result = result
+}
+
+// withParentResult returns a copy of ctx carrying the result of the run
+// which triggers the dependent jobs, which may be nil.
+func withParentResult(ctx context.Context, result map[string]interface{}) context.Context {
+	return context.WithValue(ctx, parentResultKey, result)
+}
+
+// parentResultFromContext returns the result stored by withParentResult, or
+// nil.
+func parentResultFromContext(ctx context.Context) map[string]interface{} {
+	result, _ := ctx.Value(parentResultKey).(map[string]interface{})
+	return result
+}
+
+// syncWriter serializes the writes to w, e.g. of the standard output and
+// error of a command.
+type syncWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,107 @@
+package job
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResult(t *testing.T) {
+	result, err := parseResult("working\n{\"rows\": 3}\n")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"rows": float64(3)}, result)
+
+	_, err = parseResult("{\"rows\": 3}\ndone\n")
+	assert.Error(t, err)
+	_, err = parseResult("[1, 2]")
+	assert.Error(t, err)
+	_, err = parseResult("")
+	assert.Error(t, err)
+}
+
+func TestParseRemoteResult(t *testing.T) {
+	result, err := parseRemoteResult([]byte(`{"status": "ok", "result": {"rows": 3}}`), "$.result")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"rows": float64(3)}, result)
+
+	_, err = parseRemoteResult([]byte(`{"status": "ok"}`), "$.status")
+	assert.Error(t, err)
+	_, err = parseRemoteResult([]byte(`not json`), "$.result")
+	assert.Error(t, err)
+}
+
+func TestLocalJobResult(t *testing.T) {
+	cache := NewMockCache()
+	j, stop, err := GetMockJobRunByTest(cache, func(j *Job) {
+		j.Id = "parent"
+		j.Command = `bash -c 'echo working >&2; echo "{\"rows\": 3}"'`
+		j.ParseResult = true
+	})
+	assert.NoError(t, err)
+	defer stop()
+
+	child := GetMockJob()
+	child.Id = "child"
+	child.Command = "env"
+	child.ParentJobs = []string{j.Id}
+	assert.NoError(t, child.Init(cache))
+
+	j.Run(cache)
+	j.lock.RLock()
+	assert.Equal(t, map[string]interface{}{"rows": float64(3)}, j.Stats[0].Result)
+	assert.Contains(t, j.Stats[0].Output, "working")
+	j.lock.RUnlock()
+	child.lock.RLock()
+	assert.Contains(t, child.Stats[0].Output, "KALA_PARENT_RESULT={\"rows\":3}\n")
+	child.lock.RUnlock()
+
+	// Runs without a result still succeed.
+	j.Command = "bash -c 'echo done'"
+	j.Run(cache)
+	j.lock.RLock()
+	assert.True(t, j.Stats[1].Success)
+	assert.Nil(t, j.Stats[1].Result)
+	j.lock.RUnlock()
+}
+
+func TestRemoteJobResult(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result": {"step": "` + r.URL.Path + `"}}`))
+	}))
+	defer ts.Close()
+
+	j := GetMockRemoteJob(RemoteProperties{Url: ts.URL + "/run", ResultPath: "$.result"})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.RemoteRun())
+	assert.Equal(t, map[string]interface{}{"step": "/run"}, runner.result)
+
+	j = GetMockRemoteJob(RemoteProperties{
+		Steps:      []RemoteStep{{Url: ts.URL + "/first"}, {Url: ts.URL + "/second"}},
+		ResultPath: "$.result",
+	})
+	runner = &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.RemoteRun())
+	assert.Equal(t, map[string]interface{}{"step": "/second"}, runner.result)
+}
+
+func TestParentResultTemplate(t *testing.T) {
+	runner := &JobRunner{job: GetMockJob(), ctx: withParentResult(context.Background(), map[string]interface{}{"rows": 3})}
+	runner.runSetup()
+	out, err := runner.render("{{.ParentResult.rows}}")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", out)
+}
+
+func TestParseResultValidation(t *testing.T) {
+	j := GetMockRemoteJob(RemoteProperties{Url: "http://example.com"})
+	j.ParseResult = true
+	assert.Equal(t, ErrInvalidParseResult, j.Init(NewMockCache()))
+
+	j = GetMockRemoteJob(RemoteProperties{Url: "http://example.com", ResultPath: "$..result"})
+	assert.Error(t, j.Init(NewMockCache()))
+}
@@ -0,0 +1,79 @@
+package job
+
+import (
+	"time"
+
+	"github.com/ajvb/kala/metrics"
+)
+
+// Retention bounds the stats kept for jobs, by age and by count, whichever
+// limit is hit first. Zero values don't limit the stats. Both caches use it,
+// configured with SetRetention.
+type Retention struct {
+	TTL time.Duration
+
+	// Overridden by the MaxStats of a job.
+	MaxStats int
+}
+
+// Enabled says if the retention limits stats at all.
+func (r Retention) Enabled() bool {
+	return r.TTL > 0 || r.MaxStats > 0
+}
+
+// Drop returns the number of stats to drop from the start of stats, which
+// are ordered from oldest to newest, with maxStats overriding r.MaxStats if
+// it's set.
+func (r Retention) Drop(stats []*JobStat, maxStats int, now time.Time) int {
+	if maxStats <= 0 {
+		maxStats = r.MaxStats
+	}
+	drop := 0
+	if r.TTL > 0 {
+		for drop < len(stats) && now.Sub(stats[drop].RanAt) > r.TTL {
+			drop++
+		}
+	}
+	if maxStats > 0 && len(stats)-drop > maxStats {
+		drop = len(stats) - maxStats
+	}
+	return drop
+}
+
+// Apply drops the stats of the jobs beyond the retention, and returns how
+// many.
+func (r Retention) Apply(jobs []*Job, now time.Time) int {
+	if !r.Enabled() {
+		return 0
+	}
+	dropped := 0
+	for _, j := range jobs {
+		// Stats saved to a StatStore are dropped from it, counting those
+		// dropped from memory too.
+		inMemory := j.trimStats(r, now)
+		if stored := j.trimStoredStats(r, now); stored > inMemory {
+			inMemory = stored
+		}
+		dropped += inMemory
+	}
+	if dropped > 0 {
+		metrics.RecordStatsDropped(dropped)
+	}
+	return dropped
+}
+
+// every calls retain periodically, until stop is closed.
+func (r Retention) every(interval time.Duration, stop <-chan struct{}, retain func() int) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-stop:
+			return
+		}
+		if dropped := retain(); dropped > 0 {
+			cacheLog.Debugf("Dropped %d stats beyond the retention", dropped)
+		}
+	}
+}
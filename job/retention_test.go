@@ -0,0 +1,28 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionDrop(t *testing.T) {
+	now := time.Now()
+	stats := []*JobStat{}
+	for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 3 * time.Minute, 2 * time.Minute, time.Minute} {
+		stats = append(stats, &JobStat{RanAt: now.Add(-age)})
+	}
+
+	assert.False(t, Retention{}.Enabled())
+	assert.Equal(t, 0, Retention{}.Drop(stats, 0, now))
+
+	// Whichever limit is hit first.
+	assert.Equal(t, 2, Retention{TTL: time.Hour}.Drop(stats, 0, now))
+	assert.Equal(t, 2, Retention{TTL: time.Hour, MaxStats: 4}.Drop(stats, 0, now))
+	assert.Equal(t, 3, Retention{TTL: time.Hour, MaxStats: 2}.Drop(stats, 0, now))
+
+	// The maximum of the job overrides the retention's.
+	assert.Equal(t, 1, Retention{MaxStats: 2}.Drop(stats, 4, now))
+	assert.Equal(t, 4, Retention{}.Drop(stats, 1, now))
+}
@@ -0,0 +1,89 @@
+package job
+
+// statRing holds the stats of a job with a bounded number of them, see
+// statsCapacity, in a buffer allocated once, so that runs don't grow and copy
+// the stats, and every job takes a predictable amount of memory. The buffer
+// holds twice as many stats as are kept: stats are appended until its end,
+// then the kept stats are moved back to its start, so that Job.Stats stays a
+// slice of the buffer, oldest first. Readers of the stats outside the lock of
+// the job must copy the slice, as the buffer is reused.
+type statRing struct {
+	buf   []*JobStat
+	start int
+}
+
+// append appends the stat to the stats of a job, dropping the oldest so that
+// at most capacity are kept, and returns the new stats. Stats aren't bounded
+// if capacity is zero.
+func (r *statRing) append(stats []*JobStat, stat *JobStat, capacity int) []*JobStat {
+	if capacity <= 0 {
+		r.buf, r.start = nil, 0
+		return append(stats, stat)
+	}
+	if len(r.buf) != 2*capacity || !r.holds(stats) {
+		// The capacity changed, or the stats were replaced, e.g. by those
+		// loaded from the db.
+		if len(stats) > capacity {
+			stats = stats[len(stats)-capacity:]
+		}
+		r.buf = make([]*JobStat, 2*capacity)
+		r.start = 0
+		stats = r.buf[:copy(r.buf, stats)]
+	}
+	if len(stats) == capacity {
+		stats = r.drop(stats, 1)
+	}
+	end := r.start + len(stats)
+	if end == len(r.buf) {
+		n := copy(r.buf, stats)
+		for i := n; i < end; i++ {
+			r.buf[i] = nil
+		}
+		r.start, end = 0, n
+	}
+	r.buf[end] = stat
+	return r.buf[r.start : end+1]
+}
+
+// drop drops the n oldest stats, and returns the remaining ones. The slots of
+// the buffer they took are cleared, so that they can be garbage collected.
+func (r *statRing) drop(stats []*JobStat, n int) []*JobStat {
+	if !r.holds(stats) {
+		kept := make([]*JobStat, len(stats)-n)
+		copy(kept, stats[n:])
+		return kept
+	}
+	for i := 0; i < n; i++ {
+		r.buf[r.start+i] = nil
+	}
+	r.start += n
+	return r.buf[r.start : r.start+len(stats)-n]
+}
+
+// holds says if the stats are the slice of the buffer last returned.
+func (r *statRing) holds(stats []*JobStat) bool {
+	if r.buf == nil || r.start >= len(r.buf) || len(stats) == 0 {
+		return r.buf != nil && len(stats) == 0
+	}
+	return &stats[0] == &r.buf[r.start]
+}
+
+// statsCapacity returns how many stats the job keeps: its MaxStats, or that
+// of the retention of the cache, bounded by the quotas of its namespace and
+// owner. It's zero if the stats aren't bounded, e.g. for jobs with a fixed
+// number of repetitions, which count their runs. The lock must be held.
+func (j *Job) statsCapacity(cache JobCache) int {
+	if j.Schedule != "" && j.hasFixedRepetitions() {
+		return 0
+	}
+	capacity := j.MaxStats
+	if retaining, ok := cache.(interface {
+		Retention() Retention
+	}); ok && capacity <= 0 {
+		capacity = retaining.Retention().MaxStats
+	}
+	if max := GetNamespaces().maxStats(j.Namespace, j.Owner); max > 0 && (capacity <= 0 || max < capacity) {
+		capacity = max
+	}
+	return capacity
+}
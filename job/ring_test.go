@@ -0,0 +1,138 @@
+package job
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/metrics"
+	"github.com/ajvb/kala/notify"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatRing(t *testing.T) {
+	r := &statRing{}
+	var stats []*JobStat
+	all := mockStats(10)
+	for _, stat := range all {
+		stats = r.append(stats, stat, 3)
+		assert.True(t, len(stats) <= 3)
+	}
+	assert.Equal(t, all[7:], stats)
+
+	// Appending to full stats doesn't allocate.
+	stat := &JobStat{RunId: "more"}
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+		stats = r.append(stats, stat, 3)
+	}))
+
+	// Dropped stats aren't referenced by the buffer.
+	stats = r.drop(stats, 2)
+	assert.Len(t, stats, 1)
+	held := 0
+	for _, s := range r.buf {
+		if s != nil {
+			held++
+		}
+	}
+	assert.Equal(t, 1, held)
+
+	// Stats which were replaced, and a new capacity, are copied to a new
+	// buffer.
+	stats = r.append(all[:5], all[5], 4)
+	assert.Equal(t, all[2:6], stats)
+	assert.Len(t, r.buf, 8)
+	stats = r.append(stats, all[6], 0)
+	assert.Equal(t, all[2:7], stats)
+	assert.Nil(t, r.buf)
+}
+
+func TestStatsCapacity(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockJob()
+	assert.Equal(t, 0, j.statsCapacity(cache))
+
+	cache.SetRetention(time.Hour, 100)
+	assert.Equal(t, 100, j.statsCapacity(cache))
+	j.MaxStats = 10
+	assert.Equal(t, 10, j.statsCapacity(cache))
+
+	// Jobs with a fixed number of repetitions keep their stats.
+	j = GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	j.timesToRepeat = 2
+	assert.Equal(t, 0, j.statsCapacity(cache))
+}
+
+func TestRunsKeepStatsInRing(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.MaxStats = 2
+	assert.NoError(t, cache.Set(j))
+	for i := 0; i < 3; i++ {
+		j.Run(cache)
+	}
+	assert.Len(t, j.Stats, 2)
+	assert.Len(t, j.statRing.buf, 4)
+}
+
+func TestAddStat(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.MaxStats = 5
+	version := j.Version()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.AddStat(cache, NewJobStat(j.Id))
+			j.QueryStats(StatsQuery{})
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, j.Stats, 5)
+	assert.Equal(t, version+20, j.Version())
+}
+
+func TestRecordRun(t *testing.T) {
+	publisher := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher()
+	dispatcher.AddPublisher("test", publisher)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.Id = "recorded"
+	before, _ := metrics.Default().JobCounts(j.Id)
+
+	stat := NewJobStat(j.Id)
+	stat.Success, stat.Warning, stat.Slow = true, true, true
+	stat.ExecutionDuration = time.Second
+	j.RecordRun(cache, j.Metadata, Metadata{SuccessCount: 1}, stat, nil)
+	dispatcher.Wait()
+	assert.Equal(t, []*JobStat{stat}, j.Stats)
+	assert.Equal(t, uint(1), j.Metadata.SuccessCount)
+	if assert.Len(t, publisher.events, 2) {
+		assert.Equal(t, notify.RunSucceeded, publisher.events[0].Type)
+		assert.Equal(t, notify.RunSlow, publisher.events[1].Type)
+	}
+	after, _ := metrics.Default().JobCounts(j.Id)
+	assert.Equal(t, before.Runs+1, after.Runs)
+	assert.Equal(t, before.Warnings+1, after.Warnings)
+	assert.Equal(t, before.SlowRuns+1, after.SlowRuns)
+	assert.Equal(t, before.TotalDuration+time.Second, after.TotalDuration)
+
+	// Runs which didn't happen are neither counted nor published.
+	shadow := NewJobStat(j.Id)
+	shadow.Success, shadow.Shadow = true, true
+	j.RecordRun(cache, j.Metadata, j.Metadata, shadow, nil)
+	j.RecordRun(cache, j.Metadata, j.Metadata, nil, ErrJobDisabled)
+	dispatcher.Wait()
+	assert.Len(t, j.Stats, 2)
+	assert.Len(t, publisher.events, 2)
+	after, _ = metrics.Default().JobCounts(j.Id)
+	assert.Equal(t, before.Runs+1, after.Runs)
+}
@@ -2,12 +2,21 @@ package job
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 
+	"github.com/ajvb/kala/archive"
+	"github.com/ajvb/kala/logsink"
+	"github.com/ajvb/kala/notify"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/mattn/go-shellwords"
 )
@@ -19,6 +28,28 @@ type JobRunner struct {
 	numberOfAttempts uint
 	currentRetries   uint
 	currentStat      *JobStat
+
+	// Output of the last attempt, truncated to MaxOutputSize.
+	output string
+
+	// Exit code of the command of the last attempt of local jobs, if it
+	// exited, and whether it was a warning, see ExitCodes.
+	exitCode *int
+	warning  bool
+
+	// Result parsed from the output of the last attempt, see
+	// Job.ParseResult and RemoteProperties.ResultPath.
+	result map[string]interface{}
+
+	// Lines of output of the last attempt of a local job, if they're shipped
+	// to log sinks.
+	lines *lineRecorder
+
+	// Context of the run, carrying e.g. the id of the triggering request.
+	ctx context.Context
+
+	// Logs with the job id and run id of the current run.
+	logger *log.Entry
 }
 
 var (
@@ -34,30 +65,41 @@ func (j *JobRunner) Run(cache JobCache) (*JobStat, Metadata, error) {
 	defer j.job.lock.RUnlock()
 
 	j.meta.LastAttemptedRun = time.Now()
+	if j.ctx == nil {
+		j.ctx = context.Background()
+	}
+	j.logger = runnerLog.WithFields(log.Fields{
+		"job_id":   j.job.Id,
+		"job_name": j.job.Name,
+	})
+	if requestId := RequestIdFromContext(j.ctx); requestId != "" {
+		j.logger = j.logger.WithField("request_id", requestId)
+	}
 
 	if j.job.Disabled {
-		log.Infof("Job %s tried to run, but exited early because its disabled.", j.job.Name)
+		j.logger.Infof("Job %s tried to run, but exited early because %s.", j.job.Name, j.job.describeDisabled())
 		return nil, j.meta, ErrJobDisabled
 	}
-
-	log.Infof("Job %s:%s started.", j.job.Name, j.job.Id)
+	if err := GetNamespaces().takeRun(j.job.Namespace, j.job.Owner, time.Now()); err != nil {
+		j.logger.Warnf("Job %s tried to run, but was skipped: %s", j.job.Name, err)
+		return nil, j.meta, err
+	}
 
 	j.runSetup()
+	j.recordScheduleLatency()
+	if j.shadow() {
+		return j.shadowRun(cache)
+	}
+	notify.Dispatch(j.job.event(notify.RunStarted, j.currentStat, nil))
+
+	j.logger.Infof("Job %s:%s started.", j.job.Name, j.job.Id)
 
 	for {
-		var err error
-		if j.job.JobType == LocalJob {
-			err = j.LocalRun()
-		} else if j.job.JobType == RemoteJob {
-			err = j.RemoteRun()
-		} else {
-			err = ErrJobTypeInvalid
-		}
+		err := j.engineRun()
 
 		if err != nil {
 			// Log Error in Metadata
-			// TODO - Error Reporting, email error
-			log.Errorf("Run Command got an Error: %s", err)
+			j.logger.Errorf("Run Command got an Error: %s", err)
 
 			j.meta.ErrorCount++
 			j.meta.LastError = time.Now()
@@ -70,6 +112,10 @@ func (j *JobRunner) Run(cache JobCache) (*JobStat, Metadata, error) {
 
 			j.collectStats(false)
 			j.meta.NumberOfFinishedRuns++
+			if j.meta.ConsecutiveFailures == 0 {
+				j.meta.FailingSince = j.currentStat.RanAt
+			}
+			j.meta.ConsecutiveFailures++
 
 			// TODO: Wrap error into something better.
 			return j.currentStat, j.meta, err
@@ -78,21 +124,27 @@ func (j *JobRunner) Run(cache JobCache) (*JobStat, Metadata, error) {
 		}
 	}
 
-	log.Infof("Job %s:%s finished.", j.job.Name, j.job.Id)
+	j.logger.Infof("Job %s:%s finished.", j.job.Name, j.job.Id)
 	j.meta.SuccessCount++
+	if j.warning {
+		j.meta.WarningCount++
+	}
 	j.meta.NumberOfFinishedRuns++
 	j.meta.LastSuccess = time.Now()
+	j.meta.ConsecutiveFailures = 0
+	j.meta.FailingSince = time.Time{}
 
 	j.collectStats(true)
+	j.checkSlow()
 
 	// Run Dependent Jobs
 	if len(j.job.DependentJobs) != 0 {
 		for _, id := range j.job.DependentJobs {
 			newJob, err := cache.Get(id)
 			if err != nil {
-				log.Errorf("Error retrieving dependent job with id of %s", id)
+				j.logger.Errorf("Error retrieving dependent job with id of %s", id)
 			} else {
-				newJob.Run(cache)
+				newJob.RunWithContext(withParentResult(j.ctx, j.currentStat.Result), cache)
 			}
 		}
 	}
@@ -100,6 +152,42 @@ func (j *JobRunner) Run(cache JobCache) (*JobStat, Metadata, error) {
 	return j.currentStat, j.meta, nil
 }
 
+// runType runs an attempt of the job with the run function of its type.
+func (j *JobRunner) runType() error {
+	var err error
+	if j.job.JobType == LocalJob {
+		err = j.LocalRun()
+	} else if j.job.JobType == RemoteJob {
+		err = j.RemoteRun()
+	} else if j.job.JobType == AMQPJob {
+		err = j.AMQPRun()
+	} else if j.job.JobType == LambdaJob {
+		err = j.LambdaRun()
+	} else if j.job.JobType == PubSubJob {
+		err = j.PubSubRun()
+	} else if j.job.JobType == SQLJob {
+		err = j.SQLRun()
+	} else if j.job.JobType == GRPCJob {
+		err = j.GRPCRun()
+	} else if j.job.JobType == HandlerJob {
+		err = j.HandlerRun()
+	} else if j.job.JobType == PluginJob {
+		err = j.PluginRun()
+	} else {
+		err = ErrJobTypeInvalid
+	}
+	return err
+}
+
+// runContext returns the context of the run. It is empty for runs of
+// commands without a job run, e.g. by Job.RunCmd.
+func (j *JobRunner) runContext() context.Context {
+	if j.ctx == nil {
+		return context.Background()
+	}
+	return j.ctx
+}
+
 // LocalRun executes the Job's local shell command
 func (j *JobRunner) LocalRun() error {
 	return j.runCmd()
@@ -107,6 +195,11 @@ func (j *JobRunner) LocalRun() error {
 
 // RemoteRun sends a http request, and checks if the response is valid in time,
 func (j *JobRunner) RemoteRun() error {
+	j.result = nil
+	if len(j.job.RemoteProperties.Steps) != 0 {
+		return j.runSteps()
+	}
+
 	// Calculate a response timeout
 	timeout := j.responseTimeout()
 
@@ -124,19 +217,50 @@ func (j *JobRunner) RemoteRun() error {
 
 	// Set default or user's passed headers
 	j.setHeaders(req)
+	if err := j.authorize(req); err != nil {
+		return err
+	}
 
 	// Do the request
 	res, err := httpClient.Do(req)
 	if err != nil {
 		return err
 	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		j.unauthorized()
+	}
 
-	// Check if we got any of the status codes the user asked for
-	if j.checkExpected(res.StatusCode) {
-		return nil
+	var body []byte
+	if j.job.RemoteProperties.ArchiveResponse {
+		body, err = ioutil.ReadAll(io.LimitReader(res.Body, archive.MaxSize))
+		if err == nil {
+			j.archiveResponse(body, res.Header.Get("Content-Type"))
+		}
+		j.output = truncate(string(body), MaxOutputSize)
 	} else {
+		// Keep the beginning of the response, without waiting for all of it,
+		// unless the result or the status URL to poll is parsed from it.
+		limit := MaxOutputSize
+		if j.job.RemoteProperties.ResultPath != "" || j.job.RemoteProperties.Poll != nil {
+			limit = MaxResultSize
+		}
+		body, _ = ioutil.ReadAll(io.LimitReader(res.Body, int64(limit)))
+		j.output = truncate(string(body), MaxOutputSize)
+	}
+	poll := j.job.RemoteProperties.Poll
+	if path := j.job.RemoteProperties.ResultPath; path != "" && poll == nil {
+		j.setResult(parseRemoteResult(body, path))
+	}
+
+	// Check if we got any of the status codes the user asked for
+	if !j.checkExpected(res.StatusCode) {
 		return errors.New(res.Status)
 	}
+	if poll != nil {
+		return j.poll(poll, req.URL, res.Header, body)
+	}
+	return nil
 }
 
 func initShParser() *shellwords.Parser {
@@ -158,8 +282,48 @@ func (j *JobRunner) runCmd() error {
 	if len(args) == 0 {
 		return ErrCmdIsEmpty
 	}
-	cmd := exec.Command(args[0], args[1:]...)
-	return cmd.Run()
+	cmd := exec.CommandContext(j.commandContext(), args[0], args[1:]...)
+	env := append([]string(nil), envFromContext(j.runContext())...)
+	if msg := TriggerMessageFromContext(j.runContext()); msg != nil {
+		env = append(env, "KALA_TRIGGER_SUBJECT="+msg.Subject, "KALA_TRIGGER_MESSAGE="+string(msg.Data))
+	}
+	if result := parentResultFromContext(j.runContext()); result != nil {
+		if b, err := json.Marshal(result); err == nil {
+			env = append(env, "KALA_PARENT_RESULT="+string(b))
+		}
+	}
+	if len(env) != 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output := newTailBuffer(MaxOutputSize)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// The result is parsed from the standard output alone, and the lines
+	// shipped to log sinks tell their stream.
+	var stdout *tailBuffer
+	j.lines = nil
+	if j.job.ParseResult || j.shipsLogs() {
+		combined := &syncWriter{w: output}
+		stdoutWriters, stderrWriters := []io.Writer{combined}, []io.Writer{combined}
+		if j.job.ParseResult {
+			stdout = newTailBuffer(MaxResultSize)
+			stdoutWriters = append(stdoutWriters, stdout)
+		}
+		if j.shipsLogs() {
+			j.lines = newLineRecorder(MaxShippedOutputSize)
+			stdoutWriters = append(stdoutWriters, j.lines.writer(logsink.Stdout))
+			stderrWriters = append(stderrWriters, j.lines.writer(logsink.Stderr))
+		}
+		cmd.Stdout = io.MultiWriter(stdoutWriters...)
+		cmd.Stderr = io.MultiWriter(stderrWriters...)
+	}
+	j.result = nil
+	err = cmd.Run()
+	j.output = output.String()
+	if stdout != nil {
+		j.setResult(parseResult(string(stdout.buf)))
+	}
+	return j.classifyExit(err)
 }
 
 func (j *JobRunner) shouldRetry() bool {
@@ -185,6 +349,20 @@ func (j *JobRunner) shouldRetry() bool {
 func (j *JobRunner) runSetup() {
 	// Setup Job Stat
 	j.currentStat = NewJobStat(j.job.Id)
+	j.currentStat.RequestId = RequestIdFromContext(j.runContext())
+	j.currentStat.ScheduledAt = j.currentStat.RanAt
+	if t := scheduledAtFromContext(j.runContext()); !t.IsZero() {
+		j.currentStat.ScheduledAt = t
+	}
+	j.currentStat.Trigger = TriggerMessageFromContext(j.runContext())
+	j.currentStat.Engine = j.job.engine()
+	if r := j.replay(); r != nil {
+		r.start(j.currentStat)
+	}
+	if j.logger == nil {
+		j.logger = runnerLog.WithField("job_id", j.job.Id)
+	}
+	j.logger = j.logger.WithField("run_id", j.currentStat.RunId)
 
 	// Init retries
 	j.currentRetries = j.job.Retries
@@ -194,6 +372,13 @@ func (j *JobRunner) collectStats(success bool) {
 	j.currentStat.ExecutionDuration = time.Now().Sub(j.currentStat.RanAt)
 	j.currentStat.Success = success
 	j.currentStat.NumberOfRetries = j.job.Retries - j.currentRetries
+	j.currentStat.Output = j.output
+	j.currentStat.ExitCode = j.exitCode
+	j.currentStat.Warning = success && j.warning
+	j.currentStat.Result = j.result
+
+	j.collectArtifacts()
+	j.shipLogs(success)
 }
 
 func (j *JobRunner) checkExpected(statusCode int) bool {
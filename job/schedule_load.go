@@ -0,0 +1,134 @@
+package job
+
+import (
+	"sort"
+	"time"
+)
+
+var (
+	// How far ahead the ScheduleLoad looks.
+	ScheduleLoadWindow = 24 * time.Hour
+	// Maximum number of hot spots listed in the ScheduleLoad.
+	MaxHotSpots = 10
+)
+
+// ScheduleLoad is a histogram of the runs jobs are scheduled to start in
+// every minute of the ScheduleLoadWindow, to find the moments many jobs start
+// at once.
+type ScheduleLoad struct {
+	// Start of the first minute, and end of the last.
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// Runs starting in every minute from From, the runs of the first minute
+	// first.
+	Minutes []int `json:"minutes"`
+
+	Runs int `json:"runs"`
+	// Most runs starting in a minute, and the mean of all minutes.
+	Peak int     `json:"peak"`
+	Mean float64 `json:"mean"`
+
+	// Minutes with the most runs, busiest first. Only minutes in which
+	// several jobs start are hot spots.
+	HotSpots []*HotSpot `json:"hot_spots"`
+}
+
+// HotSpot is a minute in which several jobs start.
+type HotSpot struct {
+	Minute time.Time `json:"minute"`
+	Runs   int       `json:"runs"`
+	// Jobs starting within the minute.
+	JobIds []string `json:"job_ids"`
+}
+
+// runTimes returns the times the job is scheduled to start runs within
+// [from, to). Overdue runs start at from. Runs are expected to start an
+// interval apart, as they're scheduled an interval after the previous run
+// started. The lock must be held.
+func (j *Job) runTimes(from, to time.Time) []time.Time {
+	if j.Disabled || j.IsDone || j.Schedule == "" || j.NextRunAt.IsZero() {
+		return nil
+	}
+	remaining := -1
+	if j.hasFixedRepetitions() {
+		remaining = int(j.timesToRepeat) + 1 - int(j.Metadata.NumberOfFinishedRuns)
+	}
+	// One-shot R0 schedules have no interval, and run once.
+	var interval time.Duration
+	if j.delayDuration != nil {
+		interval = j.delayDuration.ToDuration()
+	}
+
+	times := []time.Time{}
+	for t := j.NextRunAt; t.Before(to) && remaining != 0; t = t.Add(interval) {
+		if t.Before(from) {
+			t = from
+		}
+		times = append(times, t)
+		remaining--
+		if interval <= 0 {
+			break
+		}
+	}
+	return times
+}
+
+// NewScheduleLoad counts the runs every job within the cache is scheduled to
+// start in the ScheduleLoadWindow after now.
+func NewScheduleLoad(cache JobCache, now time.Time) *ScheduleLoad {
+	from := now.Truncate(time.Minute)
+	l := &ScheduleLoad{
+		From:     from,
+		To:       from.Add(ScheduleLoadWindow),
+		Minutes:  make([]int, int(ScheduleLoadWindow/time.Minute)),
+		HotSpots: []*HotSpot{},
+	}
+
+	jobs := cache.GetAll()
+	jobs.Lock.RLock()
+	// Ids of the jobs starting in every minute.
+	jobIds := map[int][]string{}
+	for _, j := range jobs.Jobs {
+		j.lock.RLock()
+		last := -1
+		for _, t := range j.runTimes(l.From, l.To) {
+			minute := int(t.Sub(l.From) / time.Minute)
+			l.Minutes[minute]++
+			if minute != last {
+				jobIds[minute] = append(jobIds[minute], j.Id)
+				last = minute
+			}
+		}
+		j.lock.RUnlock()
+	}
+	jobs.Lock.RUnlock()
+
+	for minute, runs := range l.Minutes {
+		l.Runs += runs
+		if runs > l.Peak {
+			l.Peak = runs
+		}
+		if len(jobIds[minute]) > 1 {
+			l.HotSpots = append(l.HotSpots, &HotSpot{
+				Minute: l.From.Add(time.Duration(minute) * time.Minute),
+				Runs:   runs,
+				JobIds: jobIds[minute],
+			})
+		}
+	}
+	if len(l.Minutes) != 0 {
+		l.Mean = float64(l.Runs) / float64(len(l.Minutes))
+	}
+
+	sort.SliceStable(l.HotSpots, func(i, k int) bool {
+		return l.HotSpots[i].Runs > l.HotSpots[k].Runs
+	})
+	if len(l.HotSpots) > MaxHotSpots {
+		l.HotSpots = l.HotSpots[:MaxHotSpots]
+	}
+	for _, spot := range l.HotSpots {
+		sort.Strings(spot.JobIds)
+	}
+	return l
+}
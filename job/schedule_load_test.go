@@ -0,0 +1,46 @@
+package job
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewScheduleLoad(t *testing.T) {
+	cache := NewMockCache()
+	now := time.Now()
+
+	at := now.Add(90 * time.Minute)
+	hourly := GetMockRecurringJobWithSchedule(at, "PT1H")
+	assert.NoError(t, hourly.Init(cache))
+	alsoHourly := GetMockRecurringJobWithSchedule(at, "PT1H")
+	assert.NoError(t, alsoHourly.Init(cache))
+	// Runs 3 times, the first of them and 2 repetitions.
+	repeated := GetMockJobWithSchedule(2, now.Add(10*time.Minute), "PT1H")
+	assert.NoError(t, repeated.Init(cache))
+	// One-shot R0 schedules have no interval, and run once.
+	once := GetMockJobWithSchedule(0, now.Add(20*time.Minute), "")
+	assert.NoError(t, once.Init(cache))
+	disabled := GetMockRecurringJobWithSchedule(at, "PT1M")
+	assert.NoError(t, disabled.Init(cache))
+	disabled.Disable()
+
+	l := NewScheduleLoad(cache, now)
+
+	assert.Equal(t, now.Truncate(time.Minute), l.From)
+	assert.Len(t, l.Minutes, 24*60)
+	assert.Equal(t, 2*23+3+1, l.Runs)
+	assert.Equal(t, 2, l.Peak)
+	assert.InDelta(t, 50.0/(24*60), l.Mean, 0.0001)
+
+	assert.Len(t, l.HotSpots, MaxHotSpots)
+	first := l.HotSpots[0]
+	assert.Equal(t, 2, first.Runs)
+	assert.Equal(t, at.Truncate(time.Minute), first.Minute)
+	ids := []string{hourly.Id, alsoHourly.Id}
+	sort.Strings(ids)
+	assert.Equal(t, ids, first.JobIds)
+	assert.Equal(t, 2, l.Minutes[int(first.Minute.Sub(l.From)/time.Minute)])
+}
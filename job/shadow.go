@@ -0,0 +1,60 @@
+package job
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	shadowLock sync.RWMutex
+	shadowMode bool
+)
+
+// SetShadowMode sets whether every job runs in shadow mode, see Job.Shadow,
+// e.g. to check the schedules of jobs migrated to a new server before
+// turning off the old one.
+func SetShadowMode(on bool) {
+	shadowLock.Lock()
+	defer shadowLock.Unlock()
+	shadowMode = on
+}
+
+// ShadowMode returns whether every job runs in shadow mode.
+func ShadowMode() bool {
+	shadowLock.RLock()
+	defer shadowLock.RUnlock()
+	return shadowMode
+}
+
+// withShadow returns a copy of ctx telling the runs started with it, e.g. of
+// dependent jobs, to run in shadow mode.
+func withShadow(ctx context.Context) context.Context {
+	return context.WithValue(ctx, shadowKey, true)
+}
+
+// shadow says if the run only records that it would have run. The lock of
+// the job must be held.
+func (j *JobRunner) shadow() bool {
+	inShadow, _ := j.runContext().Value(shadowKey).(bool)
+	return j.job.Shadow || inShadow || ShadowMode()
+}
+
+// shadowRun records a successful run without running the job, and runs the
+// dependent jobs in shadow mode too.
+func (j *JobRunner) shadowRun(cache JobCache) (*JobStat, Metadata, error) {
+	j.logger.Infof("Job %s:%s would have run, but runs in shadow mode.", j.job.Name, j.job.Id)
+	j.meta.NumberOfFinishedRuns++
+	j.currentStat.Success = true
+	j.currentStat.Shadow = true
+
+	ctx := withShadow(j.runContext())
+	for _, id := range j.job.DependentJobs {
+		dependent, err := cache.Get(id)
+		if err != nil {
+			j.logger.Errorf("Error retrieving dependent job with id of %s", id)
+			continue
+		}
+		dependent.RunWithContext(ctx, cache)
+	}
+	return j.currentStat, j.meta, nil
+}
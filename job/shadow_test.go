@@ -0,0 +1,53 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowJob(t *testing.T) {
+	cache := NewMockCache()
+
+	// Failing commands don't fail in shadow mode, as they don't run.
+	parent := GetMockFailingJob()
+	parent.Schedule = "R/" + time.Now().Add(time.Hour).Format(time.RFC3339) + "/PT1H"
+	parent.Shadow = true
+	assert.NoError(t, parent.Init(cache))
+	child := GetMockFailingJob()
+	child.ParentJobs = []string{parent.Id}
+	assert.NoError(t, child.Init(cache))
+
+	parent.Run(cache)
+
+	if assert.Len(t, parent.Stats, 1) {
+		assert.True(t, parent.Stats[0].Success)
+		assert.True(t, parent.Stats[0].Shadow)
+	}
+	assert.Equal(t, uint(0), parent.Metadata.ErrorCount)
+	assert.Equal(t, uint(1), parent.Metadata.NumberOfFinishedRuns)
+	assert.True(t, parent.Metadata.LastSuccess.IsZero())
+
+	// Its dependent jobs run in shadow mode too.
+	if assert.Len(t, child.Stats, 1) {
+		assert.True(t, child.Stats[0].Shadow)
+	}
+	assert.Equal(t, uint(0), child.Metadata.ErrorCount)
+}
+
+func TestShadowMode(t *testing.T) {
+	SetShadowMode(true)
+	defer SetShadowMode(false)
+	cache := NewMockCache()
+
+	j := GetMockFailingJob()
+	j.Schedule = "R/" + time.Now().Add(time.Hour).Format(time.RFC3339) + "/PT1H"
+	assert.NoError(t, j.Init(cache))
+	j.Run(cache)
+
+	if assert.Len(t, j.Stats, 1) {
+		assert.True(t, j.Stats[0].Shadow)
+	}
+	assert.Equal(t, uint(0), j.Metadata.ErrorCount)
+}
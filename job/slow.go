@@ -0,0 +1,34 @@
+package job
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidSlowFactor = errors.New("Invalid slow_factor. It's the multiple of the baseline duration over which runs are slow, greater than 1")
+
+// MinBaselineRuns is the number of successful runs making up the baseline
+// duration of a job before any run is flagged as slow.
+var MinBaselineRuns uint = 5
+
+// baselineWeight is the weight of a run in the baseline duration, an
+// exponentially weighted moving average of the durations of successful runs.
+const baselineWeight = 0.2
+
+// checkSlow flags the successful run as slow if it took longer than the job's
+// SlowFactor times its baseline duration, then adds the run to the baseline.
+func (j *JobRunner) checkSlow() {
+	d := j.currentStat.ExecutionDuration
+	if j.job.SlowFactor > 0 && j.meta.BaselineRuns >= MinBaselineRuns &&
+		float64(d) > j.job.SlowFactor*float64(j.meta.BaselineDuration) {
+		j.currentStat.Slow = true
+		j.logger.Warnf("Job %s ran slow: %s instead of about %s", j.job.Name, d, j.meta.BaselineDuration)
+	}
+
+	if j.meta.BaselineRuns == 0 {
+		j.meta.BaselineDuration = d
+	} else {
+		j.meta.BaselineDuration = time.Duration((1-baselineWeight)*float64(j.meta.BaselineDuration) + baselineWeight*float64(d))
+	}
+	j.meta.BaselineRuns++
+}
@@ -0,0 +1,85 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/notify"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSlow(t *testing.T) {
+	j := GetMockJob()
+	j.SlowFactor = 2
+	runner := &JobRunner{job: j, currentStat: &JobStat{ExecutionDuration: 10 * time.Second}}
+	runner.runSetup()
+
+	// The first runs make up the baseline.
+	for i := uint(0); i < MinBaselineRuns; i++ {
+		runner.currentStat = &JobStat{ExecutionDuration: 10 * time.Second}
+		runner.checkSlow()
+		assert.False(t, runner.currentStat.Slow)
+	}
+	assert.Equal(t, 10*time.Second, runner.meta.BaselineDuration)
+	assert.Equal(t, MinBaselineRuns, runner.meta.BaselineRuns)
+
+	runner.currentStat = &JobStat{ExecutionDuration: 15 * time.Second}
+	runner.checkSlow()
+	assert.False(t, runner.currentStat.Slow)
+	assert.Equal(t, 11*time.Second, runner.meta.BaselineDuration)
+
+	runner.currentStat = &JobStat{ExecutionDuration: 30 * time.Second}
+	runner.checkSlow()
+	assert.True(t, runner.currentStat.Slow)
+	assert.Equal(t, time.Duration(14.8*float64(time.Second)), runner.meta.BaselineDuration)
+
+	// Without a factor runs are only added to the baseline.
+	j.SlowFactor = 0
+	runner.currentStat = &JobStat{ExecutionDuration: time.Minute}
+	runner.checkSlow()
+	assert.False(t, runner.currentStat.Slow)
+}
+
+func TestSlowRunNotification(t *testing.T) {
+	notifier := &recordingNotifier{}
+	dispatcher := notify.NewDispatcher(notifier)
+	notify.SetDefault(dispatcher)
+	defer notify.SetDefault(notify.NewDispatcher())
+
+	cache := NewMockCache()
+	j := GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	j.Command = "sleep 0.1"
+	j.SlowFactor = 2
+	j.Notifications = &notify.Settings{Events: []notify.EventType{notify.RunSlow}}
+	assert.NoError(t, j.Init(cache))
+	j.Metadata.BaselineDuration = time.Millisecond
+	j.Metadata.BaselineRuns = MinBaselineRuns
+
+	j.Run(cache)
+	dispatcher.Wait()
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	assert.True(t, j.Stats[0].Slow)
+	assert.Equal(t, MinBaselineRuns+1, j.Metadata.BaselineRuns)
+
+	var slow *notify.Event
+	for _, e := range notifier.events {
+		if e.JobId == j.Id && e.Type == notify.RunSlow {
+			slow = e
+		}
+	}
+	if assert.NotNil(t, slow) {
+		assert.Equal(t, time.Millisecond, slow.Baseline)
+		assert.Equal(t, j.Stats[0].RunId, slow.RunId)
+	}
+}
+
+func TestSlowFactorValidation(t *testing.T) {
+	j := GetMockJobWithGenericSchedule()
+	j.SlowFactor = 0.5
+	assert.Equal(t, ErrInvalidSlowFactor, j.Init(NewMockCache()))
+
+	j.SlowFactor = 3
+	assert.NoError(t, j.Init(NewMockCache()))
+}
@@ -0,0 +1,142 @@
+package job
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultSplayWindow is the window Rebalance spreads the jobs starting in
+// the same minute over, unless it's given another.
+var DefaultSplayWindow = 10 * time.Minute
+
+// RebalanceReport tells how Rebalance moves the next runs of the jobs which
+// start in the same minute, spreading them evenly over the window.
+type RebalanceReport struct {
+	Window time.Duration `json:"window"`
+	Moves  []*SplayMove  `json:"moves"`
+	// Set if the moves were applied, rather than only proposed.
+	Applied bool `json:"applied"`
+}
+
+// SplayMove is the move of the next run of a job. As the runs of jobs are
+// scheduled an interval after the previous run started, the later runs move
+// along.
+type SplayMove struct {
+	JobId        string        `json:"job_id"`
+	JobName      string        `json:"job_name"`
+	NextRunAt    time.Time     `json:"next_run_at"`
+	NewNextRunAt time.Time     `json:"new_next_run_at"`
+	Offset       time.Duration `json:"offset"`
+	// The new schedule of jobs which haven't started yet, whose start moves.
+	NewSchedule string `json:"new_schedule,omitempty"`
+}
+
+// Rebalance proposes moves of the next runs of the jobs within the cache
+// which start in the same minute of the ScheduleLoadWindow, spreading them
+// evenly over the window after it. The first job of every minute, by id,
+// keeps its run, and jobs whose interval isn't longer than their offset
+// aren't moved, as they would run after their own next run. If apply is set,
+// the moves are applied.
+func Rebalance(cache JobCache, window time.Duration, apply bool) *RebalanceReport {
+	if window <= 0 {
+		window = DefaultSplayWindow
+	}
+	report := &RebalanceReport{Window: window, Moves: []*SplayMove{}}
+	now := time.Now()
+	from := now.Truncate(time.Minute)
+	to := from.Add(ScheduleLoadWindow)
+
+	jobs := cache.GetAll()
+	jobs.Lock.RLock()
+	minutes := map[time.Time][]*Job{}
+	for _, j := range jobs.Jobs {
+		j.lock.RLock()
+		// Only the jobs scheduled to run in the window, but not overdue.
+		if times := j.runTimes(from, to); len(times) != 0 && !j.NextRunAt.Before(now) {
+			minute := j.NextRunAt.Truncate(time.Minute)
+			minutes[minute] = append(minutes[minute], j)
+		}
+		j.lock.RUnlock()
+	}
+	jobs.Lock.RUnlock()
+
+	for _, group := range minutes {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, k int) bool { return group[i].Id < group[k].Id })
+		for i, j := range group[1:] {
+			offset := window * time.Duration(i+1) / time.Duration(len(group))
+			offset -= offset % time.Second
+			if move := j.splayMove(offset); move != nil {
+				report.Moves = append(report.Moves, move)
+			}
+		}
+	}
+	sort.Slice(report.Moves, func(i, k int) bool {
+		mi, mk := report.Moves[i].NextRunAt.Truncate(time.Minute), report.Moves[k].NextRunAt.Truncate(time.Minute)
+		if !mi.Equal(mk) {
+			return mi.Before(mk)
+		}
+		return report.Moves[i].Offset < report.Moves[k].Offset
+	})
+
+	if apply {
+		for _, move := range report.Moves {
+			j, err := cache.Get(move.JobId)
+			if err != nil || j == nil || !j.applySplayMove(cache, move) {
+				cacheLog.Errorf("Error occured moving the next run of job %s, which was changed or deleted", move.JobId)
+			}
+		}
+		report.Applied = true
+	}
+	return report
+}
+
+// splayMove returns the move of the next run of the job by offset, or nil if
+// its interval isn't longer than offset.
+func (j *Job) splayMove(offset time.Duration) *SplayMove {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	// One-shot R0 schedules have no interval.
+	if j.timesToRepeat != 0 && j.delayDuration != nil && j.delayDuration.ToDuration() <= offset {
+		return nil
+	}
+	move := &SplayMove{
+		JobId:        j.Id,
+		JobName:      j.Name,
+		NextRunAt:    j.NextRunAt,
+		NewNextRunAt: j.NextRunAt.Add(offset),
+		Offset:       offset,
+	}
+	if j.scheduleTime.After(time.Now()) {
+		parts := strings.Split(j.Schedule, "/")
+		parts[1] = j.scheduleTime.Add(offset).Format(time.RFC3339)
+		move.NewSchedule = strings.Join(parts, "/")
+	}
+	return move
+}
+
+// applySplayMove moves the next run of the job, unless it changed since the
+// move was proposed, and says if it did.
+func (j *Job) applySplayMove(cache JobCache, move *SplayMove) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if !j.NextRunAt.Equal(move.NextRunAt) || j.Disabled || j.IsDone {
+		return false
+	}
+	if move.NewSchedule != "" {
+		j.Schedule = move.NewSchedule
+		j.scheduleTime = j.scheduleTime.Add(move.Offset)
+	}
+	if j.jobTimer != nil {
+		j.jobTimer.Stop()
+	}
+	j.NextRunAt = move.NewNextRunAt
+	j.jobTimer = afterFunc(time.Until(j.NextRunAt), j.scheduledRun(cache, j.NextRunAt))
+	j.changed()
+	return true
+}
@@ -0,0 +1,81 @@
+package job
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebalance(t *testing.T) {
+	cache := NewMockCache()
+	at := time.Now().Add(time.Hour).Truncate(time.Minute)
+
+	jobs := []*Job{}
+	for i := 0; i < 3; i++ {
+		j := GetMockRecurringJobWithSchedule(at, "PT1H")
+		assert.NoError(t, j.Init(cache))
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Id < jobs[k].Id })
+	alone := GetMockRecurringJobWithSchedule(at.Add(time.Hour/2), "PT1H")
+	assert.NoError(t, alone.Init(cache))
+
+	report := Rebalance(cache, 9*time.Minute, false)
+	assert.False(t, report.Applied)
+	if assert.Len(t, report.Moves, 2) {
+		assert.Equal(t, jobs[1].Id, report.Moves[0].JobId)
+		assert.Equal(t, 3*time.Minute, report.Moves[0].Offset)
+		assert.WithinDuration(t, at.Add(3*time.Minute), report.Moves[0].NewNextRunAt, time.Second)
+		assert.Equal(t, "R/"+at.Add(3*time.Minute).Format(time.RFC3339)+"/PT1H", report.Moves[0].NewSchedule)
+		assert.Equal(t, jobs[2].Id, report.Moves[1].JobId)
+		assert.Equal(t, 6*time.Minute, report.Moves[1].Offset)
+	}
+	// Proposing doesn't move the runs.
+	assert.Equal(t, 3, NewScheduleLoad(cache, time.Now()).Peak)
+
+	report = Rebalance(cache, 9*time.Minute, true)
+	assert.True(t, report.Applied)
+	assert.Equal(t, 1, NewScheduleLoad(cache, time.Now()).Peak)
+	assert.WithinDuration(t, at, jobs[0].NextRunAt, time.Second)
+	assert.WithinDuration(t, at.Add(6*time.Minute), jobs[2].NextRunAt, time.Second)
+	assert.Equal(t, "R/"+at.Add(6*time.Minute).Format(time.RFC3339)+"/PT1H", jobs[2].Schedule)
+
+	// Nothing is left to move.
+	assert.Empty(t, Rebalance(cache, 9*time.Minute, false).Moves)
+}
+
+func TestRebalanceKeepsShortIntervals(t *testing.T) {
+	cache := NewMockCache()
+	at := time.Now().Add(time.Hour)
+	for _, interval := range []string{"PT1H", "PT2M"} {
+		assert.NoError(t, GetMockRecurringJobWithSchedule(at, interval).Init(cache))
+	}
+
+	// The job running every 2 minutes would have to move by 5 minutes, if
+	// it's the second, after its next run.
+	for _, move := range Rebalance(cache, 10*time.Minute, false).Moves {
+		assert.True(t, strings.HasSuffix(move.NewSchedule, "/PT1H"))
+	}
+}
+
+func TestRebalanceMovesOneShotJobs(t *testing.T) {
+	cache := NewMockCache()
+	at := time.Now().Add(time.Hour).Truncate(time.Minute)
+	jobs := []*Job{}
+	for i := 0; i < 2; i++ {
+		j := GetMockJobWithSchedule(0, at, "")
+		assert.NoError(t, j.Init(cache))
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Id < jobs[k].Id })
+
+	report := Rebalance(cache, 4*time.Minute, false)
+	if assert.Len(t, report.Moves, 1) {
+		assert.Equal(t, jobs[1].Id, report.Moves[0].JobId)
+		assert.Equal(t, 2*time.Minute, report.Moves[0].Offset)
+		assert.Equal(t, "R0/"+at.Add(2*time.Minute).Format(time.RFC3339)+"/", report.Moves[0].NewSchedule)
+	}
+}
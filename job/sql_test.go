@@ -0,0 +1,117 @@
+package job
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriver answers SELECTs with two rows, and other statements with three
+// affected rows.
+type fakeDriver struct{}
+type fakeConn struct{}
+type fakeStmt struct{ query string }
+type fakeRows struct{ n int }
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return 0 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if s.query == "broken" {
+		return nil, errors.New("syntax error")
+	}
+	return driver.RowsAffected(3), nil
+}
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error) { return &fakeRows{}, nil }
+
+func (*fakeRows) Columns() []string { return []string{"id", "name"} }
+func (*fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n == 2 {
+		return io.EOF
+	}
+	r.n++
+	dest[0] = int64(r.n)
+	dest[1] = []byte("row")
+	return nil
+}
+
+func init() {
+	sql.Register("kalafake", fakeDriver{})
+}
+
+func GetMockSQLJob(statement string) *Job {
+	return &Job{
+		Name:    "mock_sql_job",
+		JobType: SQLJob,
+		SQLProperties: SQLProperties{
+			Connection: "fake",
+			Statement:  statement,
+		},
+	}
+}
+
+func TestSQLJobValidation(t *testing.T) {
+	cache := NewMockCache()
+	min, max := int64(2), int64(1)
+
+	j := GetMockSQLJob("")
+	assert.Equal(t, ErrInvalidSQLJob, j.Init(cache))
+
+	j = GetMockSQLJob("SELECT 1")
+	j.SQLProperties.MinRows, j.SQLProperties.MaxRows = &min, &max
+	assert.Equal(t, ErrInvalidSQLJob, j.Init(cache))
+
+	j = GetMockSQLJob("SELECT 1")
+	j.SQLProperties.Connection = "missing"
+	assert.Equal(t, ErrUnknownSQLConnection, j.Init(cache))
+
+	assert.Equal(t, ErrUnknownSQLDriver, AddSQLConnection("sqlite", "file:test.db"))
+	assert.NoError(t, AddSQLConnection("pg", "postgres://localhost/kala"))
+	assert.NoError(t, AddSQLConnection("my", "mysql://root@tcp(localhost:3306)/kala"))
+}
+
+func TestSQLRun(t *testing.T) {
+	assert.NoError(t, addSQLConnection("fake", "kalafake", ""))
+
+	j := GetMockSQLJob("SELECT id, name FROM things")
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.SQLRun())
+	assert.Equal(t, "2 rows returned\nid\tname\n1\trow\n2\trow\n", runner.output)
+
+	// Data-quality checks fail on unexpected row counts.
+	max := int64(0)
+	j.SQLProperties.MaxRows = &max
+	assert.EqualError(t, runner.SQLRun(), "Expected at most 0 rows, but got 2")
+
+	j = GetMockSQLJob("DELETE FROM sessions")
+	min := int64(5)
+	j.SQLProperties.MinRows = &min
+	runner = &JobRunner{job: j}
+	runner.runSetup()
+	assert.EqualError(t, runner.SQLRun(), "Expected at least 5 rows, but got 3")
+	assert.Equal(t, "3 rows affected", runner.output)
+
+	j = GetMockSQLJob("broken")
+	runner = &JobRunner{job: j}
+	runner.runSetup()
+	assert.EqualError(t, runner.SQLRun(), "syntax error")
+}
+
+func TestReturnsRows(t *testing.T) {
+	assert.True(t, returnsRows("  select 1"))
+	assert.True(t, returnsRows("(SELECT 1) UNION (SELECT 2)"))
+	assert.True(t, returnsRows("WITH x AS (SELECT 1) SELECT * FROM x"))
+	assert.False(t, returnsRows("DELETE FROM sessions"))
+	assert.False(t, returnsRows("VACUUM"))
+}
@@ -1,7 +1,10 @@
 package job
 
 import (
+	"sort"
 	"time"
+
+	"github.com/nu7hatch/gouuid"
 )
 
 // KalaStats is the struct for storing app-level metrics
@@ -12,6 +15,8 @@ type KalaStats struct {
 
 	ErrorCount   uint `json:"error_count"`
 	SuccessCount uint `json:"success_count"`
+	// Successful runs with a warning, see ExitCodes.
+	WarningCount uint `json:"warning_count"`
 
 	NextRunAt        time.Time `json:"next_run_at"`
 	LastAttemptedRun time.Time `json:"last_attempted_run"`
@@ -61,6 +66,7 @@ func NewKalaStats(cache JobCache) *KalaStats {
 
 		ks.ErrorCount += job.Metadata.ErrorCount
 		ks.SuccessCount += job.Metadata.SuccessCount
+		ks.WarningCount += job.Metadata.WarningCount
 	}
 	ks.NextRunAt = nextRun
 	ks.LastAttemptedRun = lastRun
@@ -71,15 +77,232 @@ func NewKalaStats(cache JobCache) *KalaStats {
 // JobStat is used to store metrics about a specific Job .Run()
 type JobStat struct {
 	JobId             string        `json:"job_id"`
+	RunId             string        `json:"run_id"`
+	RequestId         string        `json:"request_id,omitempty"`
 	RanAt             time.Time     `json:"ran_at"`
 	NumberOfRetries   uint          `json:"number_of_retries"`
 	Success           bool          `json:"success"`
 	ExecutionDuration time.Duration `json:"execution_duration"`
+
+	// When the run was due, which is RanAt unless it was scheduled.
+	ScheduledAt time.Time `json:"scheduled_at"`
+
+	// End of the command's output, or the beginning of the remote job's response body.
+	Output string `json:"output,omitempty"`
+
+	// Exit code of the command of local jobs, if it exited, and whether it
+	// was a warning, see ExitCodes. Runs with a warning are successful.
+	ExitCode *int `json:"exit_code,omitempty"`
+	Warning  bool `json:"warning,omitempty"`
+
+	// Structured result of the run, see Job.ParseResult and
+	// RemoteProperties.ResultPath.
+	Result map[string]interface{} `json:"result,omitempty"`
+
+	// Link to the full response of the remote job, if it's archived.
+	ResponseArchive string `json:"response_archive,omitempty"`
+
+	// Files produced by the run of a local job, see Job.Artifacts.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+
+	// Set if the run took much longer than usual, see Job.SlowFactor.
+	Slow bool `json:"slow,omitempty"`
+
+	// Set if the job didn't run, but only recorded that it would have, in
+	// shadow mode.
+	Shadow bool `json:"shadow,omitempty"`
+
+	// Message which triggered the run, if any.
+	Trigger *TriggerMessage `json:"trigger,omitempty"`
+	// Run id of the execution the run replayed, see Job.Replay.
+	ReplayOf string `json:"replay_of,omitempty"`
+
+	// Engine which ran the run, see Job.Engine.
+	Engine string `json:"engine,omitempty"`
 }
 
 func NewJobStat(id string) *JobStat {
-	return &JobStat{
+	stat := &JobStat{
 		JobId: id,
 		RanAt: time.Now(),
 	}
+	if u4, err := uuid.NewV4(); err == nil {
+		stat.RunId = u4.String()
+	}
+	return stat
+}
+
+// StatsQuery selects stats of a job, see Job.QueryStats.
+type StatsQuery struct {
+	// Only stats which ran at or after Since, if not zero.
+	Since time.Time
+	// Only stats which ran before Until, if not zero.
+	Until time.Time
+	// Only stats of successful or of failed runs, if not nil.
+	Success *bool
+	// Only stats of runs by the engine, if not empty.
+	Engine string
+	// At most Limit stats, the most recent ones, if not zero.
+	Limit int
+}
+
+// queryStats returns copies of the stats matching the query, most recent
+// first. Stats are expected to be in the order they ran, so that the stats
+// of the time range are found by binary search rather than going through
+// all of them.
+func queryStats(stats []*JobStat, q StatsQuery) []*JobStat {
+	start := 0
+	if !q.Since.IsZero() {
+		start = sort.Search(len(stats), func(i int) bool {
+			return !stats[i].RanAt.Before(q.Since)
+		})
+	}
+	end := len(stats)
+	if !q.Until.IsZero() {
+		end = sort.Search(len(stats), func(i int) bool {
+			return !stats[i].RanAt.Before(q.Until)
+		})
+	}
+
+	matches := []*JobStat{}
+	for i := end - 1; i >= start; i-- {
+		if q.Limit > 0 && len(matches) == q.Limit {
+			break
+		}
+		if q.Success != nil && stats[i].Success != *q.Success {
+			continue
+		}
+		if q.Engine != "" && !stats[i].ranOn(q.Engine) {
+			continue
+		}
+		stat := *stats[i]
+		matches = append(matches, &stat)
+	}
+	return matches
+}
+
+// JobStatsSummary aggregates the JobStats of a job over a time window.
+type JobStatsSummary struct {
+	JobId string    `json:"job_id"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+
+	Runs        int     `json:"runs"`
+	Successes   int     `json:"successes"`
+	Failures    int     `json:"failures"`
+	SuccessRate float64 `json:"success_rate"`
+	// Successful runs with a warning, see ExitCodes.
+	Warnings int `json:"warnings"`
+	// Successful runs flagged as slow, see Job.SlowFactor.
+	SlowRuns int `json:"slow_runs"`
+
+	AverageDuration time.Duration `json:"average_duration"`
+	MedianDuration  time.Duration `json:"median_duration"`
+	P95Duration     time.Duration `json:"p95_duration"`
+
+	// Number of consecutive failures of the most recent runs.
+	CurrentFailureStreak int `json:"current_failure_streak"`
+	LongestFailureStreak int `json:"longest_failure_streak"`
+
+	// Trend splits the window into equally sized buckets, oldest first.
+	Trend []*JobStatsBucket `json:"trend"`
+}
+
+// JobStatsBucket holds the run counts of a slice of a JobStatsSummary window.
+type JobStatsBucket struct {
+	Start           time.Time     `json:"start"`
+	Runs            int           `json:"runs"`
+	Failures        int           `json:"failures"`
+	SuccessRate     float64       `json:"success_rate"`
+	AverageDuration time.Duration `json:"average_duration"`
+}
+
+// NewJobStatsSummary summarizes the stats which ran within window before now.
+// Stats are expected to be in the order they ran.
+func NewJobStatsSummary(id string, stats []*JobStat, window time.Duration, now time.Time) *JobStatsSummary {
+	summary := &JobStatsSummary{
+		JobId: id,
+		Since: now.Add(-window),
+		Until: now,
+	}
+
+	bucketSize := time.Hour
+	if window >= 48*time.Hour {
+		bucketSize = 24 * time.Hour
+	}
+	numBuckets := int((window + bucketSize - 1) / bucketSize)
+	bucketDurations := make([]time.Duration, numBuckets)
+	for i := 0; i < numBuckets; i++ {
+		summary.Trend = append(summary.Trend, &JobStatsBucket{
+			Start: summary.Since.Add(time.Duration(i) * bucketSize),
+		})
+	}
+
+	durations := []time.Duration{}
+	var total time.Duration
+	streak := 0
+	for _, stat := range stats {
+		if stat.RanAt.Before(summary.Since) || stat.RanAt.After(now) {
+			continue
+		}
+		summary.Runs++
+		durations = append(durations, stat.ExecutionDuration)
+		total += stat.ExecutionDuration
+
+		bucketIndex := int(stat.RanAt.Sub(summary.Since) / bucketSize)
+		if bucketIndex >= numBuckets {
+			bucketIndex = numBuckets - 1
+		}
+		bucket := summary.Trend[bucketIndex]
+		bucket.Runs++
+		bucketDurations[bucketIndex] += stat.ExecutionDuration
+
+		if stat.Warning {
+			summary.Warnings++
+		}
+		if stat.Slow {
+			summary.SlowRuns++
+		}
+		if stat.Success {
+			summary.Successes++
+			streak = 0
+		} else {
+			summary.Failures++
+			bucket.Failures++
+			streak++
+			if streak > summary.LongestFailureStreak {
+				summary.LongestFailureStreak = streak
+			}
+		}
+	}
+	summary.CurrentFailureStreak = streak
+
+	for i, bucket := range summary.Trend {
+		if bucket.Runs > 0 {
+			bucket.SuccessRate = float64(bucket.Runs-bucket.Failures) / float64(bucket.Runs)
+			bucket.AverageDuration = bucketDurations[i] / time.Duration(bucket.Runs)
+		}
+	}
+
+	if summary.Runs == 0 {
+		return summary
+	}
+
+	summary.SuccessRate = float64(summary.Successes) / float64(summary.Runs)
+	summary.AverageDuration = total / time.Duration(summary.Runs)
+
+	sort.Slice(durations, func(i, k int) bool { return durations[i] < durations[k] })
+	summary.MedianDuration = percentile(durations, 50)
+	summary.P95Duration = percentile(durations, 95)
+
+	return summary
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
 }
@@ -1,6 +1,7 @@
 package job
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -18,6 +19,7 @@ func TestJobStats(t *testing.T) {
 
 	assert.NotNil(t, j.Stats[0])
 	assert.Equal(t, j.Stats[0].JobId, j.Id)
+	assert.NotEmpty(t, j.Stats[0].RunId)
 	assert.WithinDuration(t, j.Stats[0].RanAt, now, time.Second)
 	assert.Equal(t, j.Stats[0].NumberOfRetries, uint(0))
 	assert.True(t, j.Stats[0].Success)
@@ -74,3 +76,74 @@ func TestNextRunAt(t *testing.T) {
 	assert.Equal(t, j.Metadata.LastAttemptedRun.UnixNano(), kalaStat.LastAttemptedRun.UnixNano())
 	assert.NotEqual(t, j2.NextRunAt.UnixNano(), kalaStat.NextRunAt.UnixNano())
 }
+
+func TestNewJobStatsSummary(t *testing.T) {
+	now := time.Now()
+	stats := []*JobStat{
+		// Outside of the window
+		{JobId: "1", RanAt: now.Add(-8 * 24 * time.Hour), Success: false, ExecutionDuration: time.Hour},
+		{JobId: "1", RanAt: now.Add(-6 * 24 * time.Hour), Success: false, ExecutionDuration: 4 * time.Second},
+		{JobId: "1", RanAt: now.Add(-5 * 24 * time.Hour), Success: false, ExecutionDuration: 3 * time.Second},
+		{JobId: "1", RanAt: now.Add(-4 * 24 * time.Hour), Success: true, ExecutionDuration: 1 * time.Second},
+		{JobId: "1", RanAt: now.Add(-2 * time.Hour), Success: true, ExecutionDuration: 2 * time.Second},
+		{JobId: "1", RanAt: now.Add(-time.Hour), Success: false, ExecutionDuration: 10 * time.Second},
+	}
+
+	summary := NewJobStatsSummary("1", stats, 7*24*time.Hour, now)
+
+	assert.Equal(t, "1", summary.JobId)
+	assert.Equal(t, 5, summary.Runs)
+	assert.Equal(t, 2, summary.Successes)
+	assert.Equal(t, 3, summary.Failures)
+	assert.InDelta(t, 0.4, summary.SuccessRate, 0.0001)
+	assert.Equal(t, 4*time.Second, summary.AverageDuration)
+	assert.Equal(t, 3*time.Second, summary.MedianDuration)
+	assert.Equal(t, 10*time.Second, summary.P95Duration)
+	assert.Equal(t, 1, summary.CurrentFailureStreak)
+	assert.Equal(t, 2, summary.LongestFailureStreak)
+
+	assert.Len(t, summary.Trend, 7)
+	assert.Equal(t, 1, summary.Trend[1].Runs)
+	assert.Equal(t, 0.0, summary.Trend[1].SuccessRate)
+	assert.Equal(t, 2, summary.Trend[6].Runs)
+	assert.Equal(t, 0.5, summary.Trend[6].SuccessRate)
+	assert.Equal(t, 6*time.Second, summary.Trend[6].AverageDuration)
+}
+
+func TestNewJobStatsSummaryNoRuns(t *testing.T) {
+	summary := NewJobStatsSummary("1", nil, 12*time.Hour, time.Now())
+
+	assert.Equal(t, 0, summary.Runs)
+	assert.Equal(t, 0.0, summary.SuccessRate)
+	assert.Len(t, summary.Trend, 12)
+}
+
+func TestQueryStats(t *testing.T) {
+	now := time.Now()
+	stats := []*JobStat{}
+	for i := 0; i < 10; i++ {
+		stats = append(stats, &JobStat{RunId: fmt.Sprint(i), RanAt: now.Add(time.Duration(i-10) * time.Hour), Success: i%3 != 0})
+	}
+	runIds := func(stats []*JobStat) []string {
+		ids := []string{}
+		for _, stat := range stats {
+			ids = append(ids, stat.RunId)
+		}
+		return ids
+	}
+
+	assert.Len(t, queryStats(stats, StatsQuery{}), 10)
+	assert.Equal(t, []string{"9", "8"}, runIds(queryStats(stats, StatsQuery{Limit: 2})))
+
+	failed := false
+	assert.Equal(t, []string{"9", "6", "3", "0"}, runIds(queryStats(stats, StatsQuery{Success: &failed})))
+
+	q := StatsQuery{Since: stats[2].RanAt, Until: stats[6].RanAt, Success: &failed}
+	assert.Equal(t, []string{"3"}, runIds(queryStats(stats, q)))
+	q = StatsQuery{Since: stats[2].RanAt, Until: stats[6].RanAt}
+	assert.Equal(t, []string{"5", "4", "3", "2"}, runIds(queryStats(stats, q)))
+
+	// Stats are copied.
+	queryStats(stats, StatsQuery{Limit: 1})[0].Output = "changed"
+	assert.Empty(t, stats[9].Output)
+}
@@ -0,0 +1,214 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StatStore is implemented by JobDBs which store the stats of jobs apart from
+// the jobs. The jobs they return only have their most recent stats, see
+// SetLoadedStats, and the stats of their older runs are read from the store
+// when they're asked for, so that Kala doesn't load the whole history of
+// every job into memory when it starts.
+type StatStore interface {
+	// GetStats returns all the stats of the job, oldest first.
+	GetStats(ctx context.Context, id string) ([]*JobStat, error)
+	// TrimStats drops the stats of the job of the runs before the time, if
+	// it isn't zero, and all but the keep most recent, if keep is positive,
+	// and returns how many it dropped.
+	TrimStats(ctx context.Context, id string, before time.Time, keep int) (int, error)
+}
+
+// DefaultLoadedStats is the default number of stats of each job kept in
+// memory by caches of StatStores.
+const DefaultLoadedStats = 100
+
+var (
+	loadedStatsLock sync.RWMutex
+	loadedStats     = DefaultLoadedStats
+)
+
+// SetLoadedStats sets how many of their most recent stats jobs loaded from a
+// StatStore have, and keep in memory once they're persisted. All of them are
+// loaded if n is zero.
+func SetLoadedStats(n int) {
+	loadedStatsLock.Lock()
+	defer loadedStatsLock.Unlock()
+	loadedStats = n
+}
+
+// LoadedStats returns how many stats of each job are loaded from StatStores.
+func LoadedStats() int {
+	loadedStatsLock.RLock()
+	defer loadedStatsLock.RUnlock()
+	return loadedStats
+}
+
+// MarshalJobWithoutStats returns the persisted form of the job like
+// MarshalJob, but without its stats, and its stats, for StatStores.
+func MarshalJobWithoutStats(j *Job) ([]byte, []*JobStat, error) {
+	doc, stats, err := marshalWithoutStats(j)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := marshalEnvelope(doc)
+	return b, stats, err
+}
+
+// marshalWithoutStats returns the JSON document of the job without its
+// stats, and its stats.
+func marshalWithoutStats(j *Job) ([]byte, []*JobStat, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	doc, err := json.Marshal(struct {
+		*RJob
+		Stats []*JobStat `json:"stats"`
+	}{RJob: (*RJob)(j)})
+	return doc, append([]*JobStat(nil), j.Stats...), err
+}
+
+// withAllStats returns the jobs loaded from the db with all of their stats,
+// rather than the most recent ones, if the db is a StatStore, e.g. to copy
+// them to another db.
+func withAllStats(ctx context.Context, db JobDB, jobs []*Job) ([]*Job, error) {
+	store, ok := UnwrapDB(db).(StatStore)
+	if !ok {
+		return jobs, nil
+	}
+	for _, j := range jobs {
+		stats, err := store.GetStats(ctx, j.Id)
+		if err != nil {
+			return nil, err
+		}
+		j.Stats = stats
+	}
+	return jobs, nil
+}
+
+// attachStatStore reads the older stats of the jobs from the db when they're
+// asked for, if it's a StatStore.
+func attachStatStore(db JobDB, jobs []*Job) {
+	store, ok := UnwrapDB(db).(StatStore)
+	if !ok {
+		return
+	}
+	for _, j := range jobs {
+		j.lock.Lock()
+		j.statStore = store
+		j.lock.Unlock()
+	}
+}
+
+// unloadStats drops the stats of the job from memory but the most recent
+// ones, once they're saved to the StatStore, unless the job changed since its
+// version was saved or it keeps all its stats.
+func (j *Job) unloadStats(store StatStore, version uint64) {
+	keep := LoadedStats()
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.statStore = store
+	if keep <= 0 || j.keepsAllStats() || j.version != version || len(j.Stats) <= keep {
+		return
+	}
+	j.Stats = j.statRing.drop(j.Stats, len(j.Stats)-keep)
+}
+
+// unloadSavedStats unloads the stats of the saved jobs, if the db is a
+// StatStore.
+func unloadSavedStats(db JobDB, versions map[string]persistedVersion) {
+	store, ok := UnwrapDB(db).(StatStore)
+	if !ok {
+		return
+	}
+	for _, v := range versions {
+		v.job.unloadStats(store, v.version)
+	}
+}
+
+// allStats returns all the stats of the job, oldest first: those read from
+// its StatStore, followed by those which weren't saved to it yet. If the
+// store can't be read, only the stats in memory are returned.
+func (j *Job) allStats() []*JobStat {
+	j.lock.RLock()
+	store, stats := j.statStore, append([]*JobStat(nil), j.Stats...)
+	j.lock.RUnlock()
+	if store == nil {
+		return stats
+	}
+
+	stored, err := store.GetStats(context.Background(), j.Id)
+	if err != nil {
+		runnerLog.WithField("job_id", j.Id).Errorf("Error occured reading the stats of job %s: %s", j.Name, err)
+		return stats
+	}
+	saved := make(map[string]bool, len(stats))
+	for _, stat := range stored {
+		saved[stat.key()] = true
+	}
+	for _, stat := range stats {
+		if !saved[stat.key()] {
+			stored = append(stored, stat)
+		}
+	}
+	return stored
+}
+
+// AllStats returns copies of all the stats of the job, oldest first,
+// including those not loaded from its StatStore.
+func (j *Job) AllStats() []*JobStat {
+	stats := j.allStats()
+	copies := make([]*JobStat, len(stats))
+	for i, stat := range stats {
+		s := *stat
+		copies[i] = &s
+	}
+	return copies
+}
+
+// copyWithAllStats returns a copy of the job with all of its stats, if it has
+// a StatStore, or the job.
+func (j *Job) copyWithAllStats() *Job {
+	j.lock.RLock()
+	store := j.statStore
+	j.lock.RUnlock()
+	if store == nil {
+		return j
+	}
+	c := j.Copy()
+	c.Stats = j.allStats()
+	return c
+}
+
+// trimStoredStats drops the stats of the job beyond the retention from its
+// StatStore, if it has one, and returns how many.
+func (j *Job) trimStoredStats(r Retention, now time.Time) int {
+	j.lock.RLock()
+	store, maxStats := j.statStore, j.MaxStats
+	fixed := j.Schedule != "" && j.hasFixedRepetitions()
+	j.lock.RUnlock()
+	if store == nil || fixed {
+		return 0
+	}
+
+	if maxStats <= 0 {
+		maxStats = r.MaxStats
+	}
+	var before time.Time
+	if r.TTL > 0 {
+		before = now.Add(-r.TTL)
+	}
+	dropped, err := store.TrimStats(context.Background(), j.Id, before, maxStats)
+	if err != nil {
+		runnerLog.WithField("job_id", j.Id).Errorf("Error occured dropping the stats of job %s: %s", j.Name, err)
+	}
+	return dropped
+}
+
+// key identifies the run of the stat.
+func (s *JobStat) key() string {
+	return s.RunId + "@" + strconv.FormatInt(s.RanAt.UnixNano(), 10)
+}
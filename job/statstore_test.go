@@ -0,0 +1,104 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockStatStore keeps the stats of the jobs saved to it, like a StatStore.
+type mockStatStore struct {
+	MockDBSaves
+	stats map[string][]*JobStat
+}
+
+func (d *mockStatStore) SaveAll(ctx context.Context, jobs []*Job) error {
+	for _, j := range jobs {
+		_, stats, err := MarshalJobWithoutStats(j)
+		if err != nil {
+			return err
+		}
+		stored := d.stats[j.Id]
+		for _, stat := range stats {
+			if len(stored) == 0 || stat.RanAt.After(stored[len(stored)-1].RanAt) {
+				stored = append(stored, stat)
+			}
+		}
+		d.stats[j.Id] = stored
+	}
+	return d.MockDBSaves.SaveAll(ctx, jobs)
+}
+
+func (d *mockStatStore) GetStats(ctx context.Context, id string) ([]*JobStat, error) {
+	return append([]*JobStat(nil), d.stats[id]...), nil
+}
+
+func (d *mockStatStore) TrimStats(ctx context.Context, id string, before time.Time, keep int) (int, error) {
+	drop := Retention{}.Drop(d.stats[id], keep, time.Now())
+	for drop < len(d.stats[id]) && d.stats[id][drop].RanAt.Before(before) {
+		drop++
+	}
+	d.stats[id] = d.stats[id][drop:]
+	return drop, nil
+}
+
+func mockStats(n int) []*JobStat {
+	stats := []*JobStat{}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < n; i++ {
+		stats = append(stats, &JobStat{RunId: fmt.Sprintf("run-%d", i), RanAt: start.Add(time.Duration(i) * time.Minute)})
+	}
+	return stats
+}
+
+func TestPersistUnloadsStats(t *testing.T) {
+	SetLoadedStats(2)
+	defer SetLoadedStats(DefaultLoadedStats)
+	db := &mockStatStore{stats: map[string][]*JobStat{}}
+	cache := NewLockFreeJobCache(db)
+
+	j := GetMockJob()
+	j.Stats = mockStats(4)
+	cache.Set(j)
+	assert.NoError(t, cache.Persist())
+	assert.Len(t, j.Stats, 2)
+	assert.Len(t, j.QueryStats(StatsQuery{}), 4)
+
+	// Stats which weren't saved yet are read too.
+	j.lock.Lock()
+	j.Stats = append(j.Stats, mockStats(5)[4])
+	j.lock.Unlock()
+	stats := j.AllStats()
+	if assert.Len(t, stats, 5) {
+		assert.Equal(t, "run-4", stats[4].RunId)
+	}
+
+	// Jobs which changed since they were saved keep their stats until
+	// they're saved again.
+	j.lock.Lock()
+	j.changed()
+	j.lock.Unlock()
+	j.unloadStats(db, j.Version()-1)
+	assert.Len(t, j.Stats, 3)
+}
+
+func TestRetentionTrimsStoredStats(t *testing.T) {
+	SetLoadedStats(2)
+	defer SetLoadedStats(DefaultLoadedStats)
+	db := &mockStatStore{stats: map[string][]*JobStat{}}
+	cache := NewLockFreeJobCache(db)
+
+	j := GetMockJob()
+	j.Stats = mockStats(6)
+	cache.Set(j)
+	assert.NoError(t, cache.Persist())
+
+	// Only 2 of the 3 stats dropped are in memory.
+	cache.SetRetention(0, 3)
+	assert.Equal(t, 3, cache.Retain())
+	assert.Len(t, db.stats[j.Id], 3)
+	assert.Len(t, j.Stats, 2)
+}
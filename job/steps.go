@@ -0,0 +1,218 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/utils/jsonpath"
+)
+
+// The size of step responses which are read, e.g. to extract values from.
+const maxStepResponseSize = 1 << 20
+
+// RemoteStep is one request of a remote job made of several steps. Its url,
+// body and header values are templates, which can refer to the responses of
+// earlier steps, e.g. "Bearer {{.Steps.login.Values.token}}".
+type RemoteStep struct {
+	// Name to refer to the step's response with. Defaults to "step1", "step2"...
+	Name string `json:"name"`
+
+	Url     string            `json:"url"`
+	Method  string            `json:"method"`
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers"`
+
+	// A timeout for the request in seconds. Defaults to 30 seconds.
+	Timeout int `json:"timeout"`
+
+	// A list of expected response codes (e.g. [200, 201]). Defaults to [200].
+	ExpectedResponseCodes []int `json:"expected_response_codes"`
+
+	// Values to extract from the JSON response by JSONPath, e.g.
+	// {"token": "$.access_token"}. The step fails if a path is missing.
+	Extract map[string]string `json:"extract"`
+}
+
+// StepResponse is the response of a step, as available to later steps.
+type StepResponse struct {
+	Status  int
+	Headers http.Header
+	Body    string
+	Values  map[string]interface{}
+}
+
+func (s *RemoteStep) name(i int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("step%d", i+1)
+}
+
+func (s *RemoteStep) method() string {
+	if s.Method == "" {
+		return "GET"
+	}
+	return strings.ToUpper(s.Method)
+}
+
+func (s *RemoteStep) valid() bool {
+	if s.Url == "" || !validTemplate(s.Url) || !validTemplate(s.Body) {
+		return false
+	}
+	for _, v := range s.Headers {
+		if !validTemplate(v) {
+			return false
+		}
+	}
+	for _, path := range s.Extract {
+		if _, err := jsonpath.Compile(path); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func validSteps(steps []RemoteStep) bool {
+	names := map[string]bool{}
+	for i := range steps {
+		name := steps[i].name(i)
+		if names[name] || !steps[i].valid() {
+			return false
+		}
+		names[name] = true
+	}
+	return true
+}
+
+// runSteps makes the requests of the steps in order, and fails on the first
+// step with an unexpected status code or a missing value.
+func (j *JobRunner) runSteps() error {
+	c := j.templateContext()
+	c.Steps = map[string]*StepResponse{}
+	output := new(bytes.Buffer)
+	var last *StepResponse
+	defer func() {
+		j.output = output.String()
+		if last != nil {
+			j.archiveResponse([]byte(last.Body), last.Headers.Get("Content-Type"))
+			if path := j.job.RemoteProperties.ResultPath; path != "" {
+				j.setResult(parseRemoteResult([]byte(last.Body), path))
+			}
+		}
+	}()
+
+	for i := range j.job.RemoteProperties.Steps {
+		step := &j.job.RemoteProperties.Steps[i]
+		name := step.name(i)
+		res, err := j.runStep(step, c)
+		if res != nil {
+			last = res
+			fmt.Fprintf(output, "%s: %s %d\n", name, step.method(), res.Status)
+		}
+		if err != nil {
+			fmt.Fprintf(output, "%s failed: %s\n", name, err)
+			if res != nil {
+				output.WriteString(truncate(res.Body, MaxOutputSize-output.Len()))
+			}
+			return fmt.Errorf("Step %s failed: %s", name, err)
+		}
+		c.Steps[name] = res
+	}
+	return nil
+}
+
+func (j *JobRunner) runStep(step *RemoteStep, c *TemplateContext) (*StepResponse, error) {
+	url, err := j.renderWith(step.Url, c)
+	if err != nil {
+		return nil, err
+	}
+	body, err := j.renderWith(step.Body, c)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(step.method(), url, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range step.Headers {
+		v, err := j.renderWith(v, c)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(k, v)
+	}
+	if err := j.authorize(req); err != nil {
+		return nil, err
+	}
+
+	timeout := time.Duration(step.Timeout) * time.Second
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	httpClient := http.Client{
+		Timeout: timeout,
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		j.unauthorized()
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxStepResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	response := &StepResponse{
+		Status:  res.StatusCode,
+		Headers: res.Header,
+		Body:    string(b),
+		Values:  map[string]interface{}{},
+	}
+
+	expected := step.ExpectedResponseCodes
+	if len(expected) == 0 {
+		expected = []int{200}
+	}
+	ok := false
+	for _, code := range expected {
+		ok = ok || code == res.StatusCode
+	}
+	if !ok {
+		return response, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	if len(step.Extract) != 0 {
+		var doc interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return response, fmt.Errorf("response isn't JSON: %s", err)
+		}
+		for name, path := range step.Extract {
+			v, err := jsonpath.Get(doc, path)
+			if err != nil {
+				return response, err
+			}
+			response.Values[name] = v
+		}
+	}
+	return response, nil
+}
+
+func truncate(s string, max int) string {
+	if max < 0 {
+		max = 0
+	}
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
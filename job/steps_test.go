@@ -0,0 +1,85 @@
+package job
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoteStepsValidation(t *testing.T) {
+	cache := NewMockCache()
+
+	j := GetMockRemoteJob(RemoteProperties{Steps: []RemoteStep{{Url: "http://localhost/{{.JobName"}}})
+	assert.Equal(t, ErrInvalidRemoteJob, j.Init(cache))
+
+	j = GetMockRemoteJob(RemoteProperties{Steps: []RemoteStep{{Url: "http://localhost/", Extract: map[string]string{"id": "$.items[x]"}}}})
+	assert.Equal(t, ErrInvalidRemoteJob, j.Init(cache))
+
+	j = GetMockRemoteJob(RemoteProperties{Steps: []RemoteStep{{Name: "a", Url: "http://localhost/"}, {Name: "a", Url: "http://localhost/"}}})
+	assert.Equal(t, ErrInvalidRemoteJob, j.Init(cache))
+
+	j = GetMockRemoteJob(RemoteProperties{Steps: []RemoteStep{{Url: "http://localhost/"}, {Url: "http://localhost/{{.Steps.step1.Status}}"}}})
+	j.Schedule = "R/2100-01-01T00:00:00Z/PT1H"
+	assert.NoError(t, j.Init(cache))
+}
+
+func TestRemoteSteps(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			w.Header().Set("X-Session", "s1")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"access_token": "abc", "user": {"id": 7}}`))
+		case "/users/7/reports":
+			if r.Header.Get("Authorization") != "Bearer abc" || r.Header.Get("X-Session") != "s1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"reports": []}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	j := GetMockRemoteJob(RemoteProperties{Steps: []RemoteStep{
+		{
+			Name:                  "login",
+			Url:                   ts.URL + "/login",
+			Method:                "post",
+			Body:                  `{"job": "{{.JobName}}"}`,
+			ExpectedResponseCodes: []int{201},
+			Extract:               map[string]string{"token": "$.access_token", "user": "$.user.id"},
+		},
+		{
+			Url: ts.URL + "/users/{{.Steps.login.Values.user}}/reports",
+			Headers: map[string]string{
+				"Authorization": "Bearer {{.Steps.login.Values.token}}",
+				"X-Session":     `{{.Steps.login.Headers.Get "X-Session"}}`,
+			},
+			Extract: map[string]string{"reports": "$.reports"},
+		},
+	}})
+	runner := &JobRunner{job: j}
+	runner.runSetup()
+	assert.NoError(t, runner.RemoteRun())
+	assert.Equal(t, "login: POST 201\nstep2: GET 200\n", runner.output)
+
+	// The run fails on the first unmet expectation.
+	j.RemoteProperties.Steps[0].Extract["missing"] = "$.refresh_token"
+	err := runner.RemoteRun()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Step login failed")
+	}
+	assert.Contains(t, runner.output, "login failed")
+	assert.NotContains(t, runner.output, "step2")
+
+	delete(j.RemoteProperties.Steps[0].Extract, "missing")
+	j.RemoteProperties.Steps[1].Headers["X-Session"] = "other"
+	err = runner.RemoteRun()
+	if assert.Error(t, err) {
+		assert.Equal(t, "Step step2 failed: unexpected status 401 Unauthorized", err.Error())
+	}
+}
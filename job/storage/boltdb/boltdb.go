@@ -1,47 +1,218 @@
 package boltdb
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
+	"encoding/binary"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/metrics"
+	"github.com/ajvb/kala/utils/logging"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/boltdb/bolt"
 )
 
 var (
+	log = logging.GetLogger(logging.DB)
+
 	jobBucket = []byte("jobs")
+	// The stats of each job are stored in a bucket of their own, keyed by
+	// when they ran, rather than with the job, see job.StatStore.
+	statsBucket = []byte("stats")
+	metaBucket  = []byte("meta")
+	// Set once the stats stored with jobs were moved to their buckets.
+	statsSplitKey = []byte("stats_split")
+
+	// How often the stats of the file are recorded.
+	statsInterval = time.Minute
 )
 
+func init() {
+	job.RegisterDriver("boltdb", job.DriverFunc(func(opts job.DBOptions) (job.JobDB, error) {
+		db := GetBoltDB(opts.Path)
+		db.StartMaintenance(opts.CompactEvery)
+		return db, nil
+	}))
+}
+
 func GetBoltDB(path string) *BoltJobDB {
 	if path != "" && !strings.HasSuffix(path, "/") {
 		path += "/"
 	}
 	path += "jobdb.db"
-	database, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 10})
+	database, err := open(path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := splitStats(database); err != nil {
+		log.Fatalf("Error occured moving the stats of the jobs in %s to their buckets: %s", path, err)
+	}
 	return &BoltJobDB{
 		path:   path,
 		dbConn: database,
+		stop:   make(chan struct{}),
 	}
 }
 
+func open(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 10})
+}
+
 type BoltJobDB struct {
+	// Guards dbConn, which is reopened by Compact.
+	lock   sync.RWMutex
 	dbConn *bolt.DB
 	path   string
+
+	compactions     uint64
+	lastCompactedAt *time.Time
+
+	stop      chan struct{}
+	closeOnce sync.Once
 }
 
 func (db *BoltJobDB) Close() error {
+	db.closeOnce.Do(func() { close(db.stop) })
+	db.lock.RLock()
+	defer db.lock.RUnlock()
 	return db.dbConn.Close()
 }
 
-func (db *BoltJobDB) GetAll() ([]*job.Job, error) {
+// StartMaintenance records the stats of the file every minute, and compacts
+// the file every compactEvery unless it's zero, until the db is closed.
+func (db *BoltJobDB) StartMaintenance(compactEvery time.Duration) {
+	db.recordStats()
+	go func() {
+		stats := time.NewTicker(statsInterval)
+		defer stats.Stop()
+		var compact <-chan time.Time
+		if compactEvery > 0 {
+			ticker := time.NewTicker(compactEvery)
+			defer ticker.Stop()
+			compact = ticker.C
+		}
+		for {
+			select {
+			case <-stats.C:
+				db.recordStats()
+			case <-compact:
+				if err := db.Compact(context.Background()); err != nil {
+					log.Errorf("Error occured compacting %s: %s", db.path, err)
+				}
+				db.recordStats()
+			case <-db.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (db *BoltJobDB) recordStats() {
+	stats, err := db.DBStats()
+	if err != nil {
+		log.Errorf("Error occured getting the stats of %s: %s", db.path, err)
+		return
+	}
+	metrics.RecordDBStats(stats)
+}
+
+// DBStats returns the size of the file and the stats of its freelist.
+func (db *BoltJobDB) DBStats() (metrics.DBStats, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return metrics.DBStats{}, err
+	}
+	s := db.dbConn.Stats()
+	return metrics.DBStats{
+		SizeBytes:       info.Size(),
+		FreePages:       s.FreePageN,
+		PendingPages:    s.PendingPageN,
+		FreeBytes:       int64(s.FreeAlloc),
+		FreelistBytes:   int64(s.FreelistInuse),
+		Compactions:     db.compactions,
+		LastCompactedAt: db.lastCompactedAt,
+	}, nil
+}
+
+// Compact copies the jobs to a new file, which replaces the file once they're
+// all copied. Bolt never shrinks its file, reusing the pages freed by deleted
+// and overwritten jobs instead, so the file stays as large as it ever was.
+// Reads and writes wait for the compaction.
+func (db *BoltJobDB) Compact(ctx context.Context) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tmpPath := db.path + ".compact"
+	os.Remove(tmpPath)
+	tmp, err := open(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = db.dbConn.View(func(src *bolt.Tx) error {
+		return tmp.Update(func(dst *bolt.Tx) error {
+			return src.ForEach(func(name []byte, b *bolt.Bucket) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				copied, err := dst.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(copied, b)
+			})
+		})
+	})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := db.dbConn.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(tmpPath, db.path)
+	if db.dbConn, err = open(db.path); err != nil {
+		log.Fatalf("Error occured reopening %s after compacting it: %s", db.path, err)
+	}
+	if renameErr != nil {
+		os.Remove(tmpPath)
+		return renameErr
+	}
+	now := time.Now()
+	db.compactions++
+	db.lastCompactedAt = &now
+	return nil
+}
+
+// copyBucket copies the keys and nested buckets of src to dst.
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(nested, src.Bucket(k))
+	})
+}
+
+func (db *BoltJobDB) GetAll(ctx context.Context) ([]*job.Job, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
 	allJobs := []*job.Job{}
+	if err := ctx.Err(); err != nil {
+		return allJobs, err
+	}
 
 	err := db.dbConn.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(jobBucket)
@@ -50,11 +221,7 @@ func (db *BoltJobDB) GetAll() ([]*job.Job, error) {
 		}
 
 		err = bucket.ForEach(func(k, v []byte) error {
-			buffer := bytes.NewBuffer(v)
-			dec := gob.NewDecoder(buffer)
-			j := new(job.Job)
-			err := dec.Decode(j)
-
+			j, err := unmarshalJob(tx, k, v)
 			if err != nil {
 				return err
 			}
@@ -76,20 +243,61 @@ func (db *BoltJobDB) GetAll() ([]*job.Job, error) {
 	return allJobs, err
 }
 
-func (db *BoltJobDB) Get(id string) (*job.Job, error) {
-	j := new(job.Job)
+// GetPage returns up to limit jobs with ids after the given id, in order.
+func (db *BoltJobDB) GetPage(ctx context.Context, after string, limit int) ([]*job.Job, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	jobs := []*job.Job{}
+	if err := ctx.Err(); err != nil {
+		return jobs, err
+	}
+
+	err := db.dbConn.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobBucket)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, v = c.Next()
+		}
+		for ; k != nil && len(jobs) < limit; k, v = c.Next() {
+			j, err := unmarshalJob(tx, k, v)
+			if err != nil {
+				return err
+			}
+			if err := j.InitDelayDuration(false); err != nil {
+				return err
+			}
+			jobs = append(jobs, j)
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+func (db *BoltJobDB) Get(ctx context.Context, id string) (*job.Job, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var j *job.Job
 
 	err := db.dbConn.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(jobBucket)
+		if b == nil {
+			return job.ErrNotFound
+		}
 
 		v := b.Get([]byte(id))
 		if v == nil {
-			return job.ErrJobNotFound(id)
+			return job.ErrNotFound
 		}
 
-		buf := bytes.NewBuffer(v)
-		err := gob.NewDecoder(buf).Decode(j)
-
+		var err error
+		j, err = unmarshalJob(tx, []byte(id), v)
 		return err
 	})
 	if err != nil {
@@ -100,33 +308,268 @@ func (db *BoltJobDB) Get(id string) (*job.Job, error) {
 	return j, nil
 }
 
-func (db *BoltJobDB) Delete(id string) error {
+func (db *BoltJobDB) Delete(ctx context.Context, id string) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	err := db.dbConn.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(jobBucket)
+		if bucket == nil || bucket.Get([]byte(id)) == nil {
+			return job.ErrNotFound
+		}
+		if err := deleteStats(tx, []byte(id)); err != nil {
+			return err
+		}
 		return bucket.Delete([]byte(id))
 	})
 	return err
 }
 
-func (db *BoltJobDB) Save(j *job.Job) error {
+func (db *BoltJobDB) Save(ctx context.Context, j *job.Job) error {
+	return db.SaveAll(ctx, []*job.Job{j})
+}
+
+// SaveAll saves the jobs in a single transaction, which is rolled back if
+// ctx is done before all of them are written.
+func (db *BoltJobDB) SaveAll(ctx context.Context, jobs []*job.Job) error {
+	return db.SaveAndDelete(ctx, jobs, nil)
+}
+
+// SaveAndDelete saves the jobs and deletes the jobs with the ids in a single
+// transaction, which is rolled back if ctx is done before all the jobs are
+// written.
+func (db *BoltJobDB) SaveAndDelete(ctx context.Context, jobs []*job.Job, ids []string) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
 	err := db.dbConn.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(jobBucket)
 		if err != nil {
 			return err
 		}
 
-		buffer := new(bytes.Buffer)
-		enc := gob.NewEncoder(buffer)
-		err = enc.Encode(j)
+		for _, j := range jobs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			b, stats, err := job.MarshalJobWithoutStats(j)
+			if err != nil {
+				return err
+			}
+
+			err = bucket.Put([]byte(j.Id), b)
+			if err != nil {
+				return err
+			}
+			if err := putStats(tx, []byte(j.Id), stats); err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			if err := deleteStats(tx, []byte(id)); err != nil {
+				return err
+			}
+			if err := bucket.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// GetStats returns all the stats of the job, oldest first.
+func (db *BoltJobDB) GetStats(ctx context.Context, id string) ([]*job.JobStat, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var stats []*job.JobStat
+	err := db.dbConn.View(func(tx *bolt.Tx) error {
+		var err error
+		stats, err = getStats(tx, []byte(id), 0)
+		return err
+	})
+	return stats, err
+}
+
+// TrimStats drops the stats of the job of the runs before the time, and all
+// but the keep most recent, and returns how many.
+func (db *BoltJobDB) TrimStats(ctx context.Context, id string, before time.Time, keep int) (int, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	dropped := 0
+	err := db.dbConn.Update(func(tx *bolt.Tx) error {
+		b := jobStatsBucket(tx, []byte(id))
+		if b == nil {
+			return nil
+		}
+		// Keys start with when the stats ran, so the oldest are first.
+		keys := [][]byte{}
+		b.ForEach(func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		})
+		drop := 0
+		if !before.IsZero() {
+			for drop < len(keys) && statTime(keys[drop]).Before(before) {
+				drop++
+			}
+		}
+		if keep > 0 && len(keys)-drop > keep {
+			drop = len(keys) - keep
+		}
+		for _, k := range keys[:drop] {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		dropped = drop
+		return nil
+	})
+	return dropped, err
+}
+
+// unmarshalJob returns the job stored with the id, with its most recent
+// stats, see job.LoadedStats, or all of them if it keeps all its stats.
+func unmarshalJob(tx *bolt.Tx, id, v []byte) (*job.Job, error) {
+	j, err := job.UnmarshalJob(v)
+	if err != nil {
+		return nil, err
+	}
+	limit := job.LoadedStats()
+	if j.KeepsAllStats() {
+		limit = 0
+	}
+	j.Stats, err = getStats(tx, id, limit)
+	return j, err
+}
+
+func jobStatsBucket(tx *bolt.Tx, id []byte) *bolt.Bucket {
+	stats := tx.Bucket(statsBucket)
+	if stats == nil {
+		return nil
+	}
+	return stats.Bucket(id)
+}
+
+// getStats returns the limit most recent stats of the job, or all of them if
+// limit is zero, oldest first.
+func getStats(tx *bolt.Tx, id []byte, limit int) ([]*job.JobStat, error) {
+	stats := []*job.JobStat{}
+	b := jobStatsBucket(tx, id)
+	if b == nil {
+		return stats, nil
+	}
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil && (limit <= 0 || len(stats) < limit); k, v = c.Prev() {
+		stat, err := job.UnmarshalStat(v)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		stats = append(stats, stat)
+	}
+	for i, k := 0, len(stats)-1; i < k; i, k = i+1, k-1 {
+		stats[i], stats[k] = stats[k], stats[i]
+	}
+	return stats, nil
+}
 
-		err = bucket.Put([]byte(j.Id), buffer.Bytes())
+// putStats stores the stats of the job, overwriting those already stored.
+func putStats(tx *bolt.Tx, id []byte, stats []*job.JobStat) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	all, err := tx.CreateBucketIfNotExists(statsBucket)
+	if err != nil {
+		return err
+	}
+	b, err := all.CreateBucketIfNotExists(id)
+	if err != nil {
+		return err
+	}
+	for _, stat := range stats {
+		v, err := job.MarshalStat(stat)
 		if err != nil {
 			return err
 		}
+		if err := b.Put(statKey(stat), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteStats(tx *bolt.Tx, id []byte) error {
+	all := tx.Bucket(statsBucket)
+	if all == nil || all.Bucket(id) == nil {
 		return nil
+	}
+	return all.DeleteBucket(id)
+}
+
+// statKey orders the stats by when they ran, then by run id.
+func statKey(stat *job.JobStat) []byte {
+	k := make([]byte, 8, 8+len(stat.RunId))
+	binary.BigEndian.PutUint64(k, uint64(stat.RanAt.UnixNano()))
+	return append(k, stat.RunId...)
+}
+
+func statTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k)))
+}
+
+// splitStats moves the stats stored with the jobs by earlier versions of Kala
+// to their buckets, once.
+func splitStats(database *bolt.DB) error {
+	return database.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if meta.Get(statsSplitKey) != nil {
+			return nil
+		}
+		if bucket := tx.Bucket(jobBucket); bucket != nil {
+			// Keys can't be changed while iterating over them.
+			jobs := map[string][]byte{}
+			err := bucket.ForEach(func(k, v []byte) error {
+				jobs[string(k)] = v
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			moved := 0
+			for id, v := range jobs {
+				j, err := job.UnmarshalJob(v)
+				if err != nil {
+					return err
+				}
+				if len(j.Stats) == 0 {
+					continue
+				}
+				b, stats, err := job.MarshalJobWithoutStats(j)
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte(id), b); err != nil {
+					return err
+				}
+				if err := putStats(tx, []byte(id), stats); err != nil {
+					return err
+				}
+				moved++
+			}
+			if moved > 0 {
+				log.Infof("Moved the stats of %d jobs to their buckets", moved)
+			}
+		}
+		return meta.Put(statsSplitKey, []byte{1})
 	})
-	return err
 }
@@ -1,25 +1,46 @@
 package boltdb
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ajvb/kala/job"
 
+	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/assert"
 )
 
-var testDbPath = ""
+var ctx = context.Background()
+
+// testDbPath is a temp dir, so that the tests don't write a db to the tree.
+var testDbPath string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "kala-bolt")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	testDbPath = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
 
 func setupTest(t *testing.T) {
 	db := GetBoltDB(testDbPath)
 	defer db.Close()
 
-	jobs, err := db.GetAll()
+	jobs, err := db.GetAll(ctx)
 	assert.NoError(t, err)
 
 	for _, j := range jobs {
-		err = db.Delete(j.Id)
+		err = db.Delete(ctx, j.Id)
 		assert.NoError(t, err)
 	}
 
@@ -34,9 +55,9 @@ func TestSaveAndGetJob(t *testing.T) {
 
 	genericMockJob := job.GetMockJobWithGenericSchedule()
 	genericMockJob.Init(cache)
-	db.Save(genericMockJob)
+	db.Save(ctx, genericMockJob)
 
-	j, err := db.Get(genericMockJob.Id)
+	j, err := db.Get(ctx, genericMockJob.Id)
 	assert.Nil(t, err)
 
 	assert.WithinDuration(t, j.NextRunAt, genericMockJob.NextRunAt, 100*time.Microsecond)
@@ -57,10 +78,10 @@ func TestDeleteJob(t *testing.T) {
 
 	genericMockJob := job.GetMockJobWithGenericSchedule()
 	genericMockJob.Init(cache)
-	db.Save(genericMockJob)
+	db.Save(ctx, genericMockJob)
 
 	// Make sure its there
-	j, err := db.Get(genericMockJob.Id)
+	j, err := db.Get(ctx, genericMockJob.Id)
 	assert.Nil(t, err)
 	assert.Equal(t, j.Name, genericMockJob.Name)
 	retrievedJob, err := cache.Get(genericMockJob.Id)
@@ -70,9 +91,10 @@ func TestDeleteJob(t *testing.T) {
 	// Delete it
 	genericMockJob.Delete(cache, db)
 
-	k, err := db.Get(genericMockJob.Id)
-	assert.Error(t, err)
+	k, err := db.Get(ctx, genericMockJob.Id)
+	assert.Equal(t, job.ErrNotFound, err)
 	assert.Nil(t, k)
+	assert.Equal(t, job.ErrNotFound, db.Delete(ctx, genericMockJob.Id))
 	retrievedJobTwo, err := cache.Get(genericMockJob.Id)
 	assert.Error(t, err)
 	assert.Nil(t, retrievedJobTwo)
@@ -89,15 +111,349 @@ func TestSaveAndGetAllJobs(t *testing.T) {
 
 	genericMockJobOne := job.GetMockJobWithGenericSchedule()
 	genericMockJobOne.Init(cache)
-	err := db.Save(genericMockJobOne)
+	err := db.Save(ctx, genericMockJobOne)
 	assert.NoError(t, err)
 
 	genericMockJobTwo := job.GetMockJobWithGenericSchedule()
 	genericMockJobTwo.Init(cache)
-	err = db.Save(genericMockJobTwo)
+	err = db.Save(ctx, genericMockJobTwo)
 	assert.NoError(t, err)
 
-	jobs, err := db.GetAll()
+	jobs, err := db.GetAll(ctx)
 	assert.Nil(t, err)
 	assert.Equal(t, len(jobs), 2)
 }
+
+func TestSaveAllJobs(t *testing.T) {
+	setupTest(t)
+
+	db := GetBoltDB(testDbPath)
+	cache := job.NewLockFreeJobCache(db)
+	defer db.Close()
+
+	genericMockJobOne := job.GetMockJobWithGenericSchedule()
+	genericMockJobOne.Init(cache)
+	genericMockJobTwo := job.GetMockJobWithGenericSchedule()
+	genericMockJobTwo.Init(cache)
+
+	err := db.SaveAll(ctx, []*job.Job{genericMockJobOne, genericMockJobTwo})
+	assert.NoError(t, err)
+
+	jobs, err := db.GetAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, len(jobs), 2)
+}
+
+func TestDeleteJobWithDependentJobs(t *testing.T) {
+	setupTest(t)
+
+	db := GetBoltDB(testDbPath)
+	cache := job.NewLockFreeJobCache(db)
+	defer db.Close()
+
+	parent := job.GetMockJobWithGenericSchedule()
+	assert.NoError(t, parent.Init(cache))
+	otherParent := job.GetMockJobWithGenericSchedule()
+	assert.NoError(t, otherParent.Init(cache))
+	child := job.GetMockJob()
+	child.ParentJobs = []string{parent.Id}
+	assert.NoError(t, child.Init(cache))
+	childWithOtherParent := job.GetMockJob()
+	childWithOtherParent.ParentJobs = []string{parent.Id, otherParent.Id}
+	assert.NoError(t, childWithOtherParent.Init(cache))
+	assert.NoError(t, cache.Persist())
+
+	assert.NoError(t, parent.Delete(cache, db))
+
+	jobs, err := db.GetAll(ctx)
+	assert.NoError(t, err)
+	saved := map[string]*job.Job{}
+	for _, j := range jobs {
+		saved[j.Id] = j
+	}
+	assert.Len(t, saved, 2)
+	assert.Equal(t, []string{childWithOtherParent.Id}, saved[otherParent.Id].DependentJobs)
+	assert.Equal(t, []string{otherParent.Id}, saved[childWithOtherParent.Id].ParentJobs)
+
+	_, err = cache.Get(child.Id)
+	assert.Equal(t, job.ErrJobDoesntExist, err)
+}
+
+func TestGetLegacyJob(t *testing.T) {
+	setupTest(t)
+
+	db := GetBoltDB(testDbPath)
+	defer db.Close()
+
+	// Jobs used to be saved as gob.
+	legacyJob := job.GetMockJobWithGenericSchedule()
+	legacyJob.Id = "legacy"
+	b, err := legacyJob.Bytes()
+	assert.NoError(t, err)
+	err = db.dbConn.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(jobBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(legacyJob.Id), b)
+	})
+	assert.NoError(t, err)
+
+	j, err := db.Get(ctx, legacyJob.Id)
+	if assert.NoError(t, err) {
+		assert.Equal(t, legacyJob.Name, j.Name)
+		assert.Equal(t, legacyJob.Schedule, j.Schedule)
+	}
+}
+
+func TestOpenDB(t *testing.T) {
+	db, err := job.OpenDB("boltdb", job.DBOptions{Path: testDbPath})
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.IsType(t, &BoltJobDB{}, db)
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-bolt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := GetBoltDB(dir)
+	defer db.Close()
+
+	jobs := []*job.Job{}
+	for i := 0; i < 200; i++ {
+		j := job.GetMockJobWithGenericSchedule()
+		j.Id = fmt.Sprintf("job-%03d", i)
+		j.Command = strings.Repeat("echo compact; ", 100)
+		jobs = append(jobs, j)
+	}
+	assert.NoError(t, db.SaveAll(ctx, jobs))
+	for _, j := range jobs[1:] {
+		assert.NoError(t, db.Delete(ctx, j.Id))
+	}
+
+	before, err := db.DBStats()
+	assert.NoError(t, err)
+	assert.True(t, before.FreePages > 0)
+
+	assert.NoError(t, db.Compact(ctx))
+
+	after, err := db.DBStats()
+	assert.NoError(t, err)
+	assert.True(t, after.SizeBytes < before.SizeBytes, "%d should be less than %d", after.SizeBytes, before.SizeBytes)
+	assert.Equal(t, uint64(1), after.Compactions)
+	assert.NotNil(t, after.LastCompactedAt)
+
+	remaining, err := db.GetAll(ctx)
+	if assert.NoError(t, err) && assert.Len(t, remaining, 1) {
+		assert.Equal(t, jobs[0].Id, remaining[0].Id)
+		assert.Equal(t, jobs[0].Command, remaining[0].Command)
+	}
+
+	// The compacted file is still writable.
+	assert.NoError(t, db.Save(ctx, jobs[1]))
+}
+
+func TestGetPage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-bolt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := GetBoltDB(dir)
+	defer db.Close()
+
+	page, err := db.GetPage(ctx, "", 2)
+	assert.NoError(t, err)
+	assert.Empty(t, page)
+
+	jobs := []*job.Job{}
+	for i := 0; i < 5; i++ {
+		j := job.GetMockJobWithGenericSchedule()
+		j.Id = fmt.Sprintf("job-%d", i)
+		jobs = append(jobs, j)
+	}
+	assert.NoError(t, db.SaveAll(ctx, jobs))
+
+	ids := []string{}
+	after := ""
+	for {
+		page, err := db.GetPage(ctx, after, 2)
+		assert.NoError(t, err)
+		for _, j := range page {
+			ids = append(ids, j.Id)
+		}
+		if len(page) < 2 {
+			break
+		}
+		after = page[len(page)-1].Id
+	}
+	assert.Equal(t, []string{"job-0", "job-1", "job-2", "job-3", "job-4"}, ids)
+}
+
+func mockStats(n int) []*job.JobStat {
+	stats := []*job.JobStat{}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < n; i++ {
+		stats = append(stats, &job.JobStat{
+			RunId:   fmt.Sprintf("run-%d", i),
+			RanAt:   start.Add(time.Duration(i) * time.Minute),
+			Success: true,
+		})
+	}
+	return stats
+}
+
+func runIds(stats []*job.JobStat) []string {
+	ids := []string{}
+	for _, stat := range stats {
+		ids = append(ids, stat.RunId)
+	}
+	return ids
+}
+
+func TestStatStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-bolt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	job.SetLoadedStats(2)
+	defer job.SetLoadedStats(job.DefaultLoadedStats)
+
+	db := GetBoltDB(dir)
+	defer db.Close()
+
+	j := job.GetMockRecurringJobWithSchedule(time.Now().Add(5*time.Minute), "PT1H")
+	j.Id = "stats"
+	j.Stats = mockStats(5)
+	assert.NoError(t, db.Save(ctx, j))
+
+	// Jobs are loaded with their most recent stats only.
+	loaded, err := db.Get(ctx, j.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run-3", "run-4"}, runIds(loaded.Stats))
+	all, err := db.GetAll(ctx)
+	if assert.NoError(t, err) && assert.Len(t, all, 1) {
+		assert.Equal(t, []string{"run-3", "run-4"}, runIds(all[0].Stats))
+	}
+	stats, err := db.GetStats(ctx, j.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run-0", "run-1", "run-2", "run-3", "run-4"}, runIds(stats))
+
+	// Saving the job with its recent stats keeps the older ones.
+	loaded.Stats = append(loaded.Stats, mockStats(6)[5])
+	assert.NoError(t, db.Save(ctx, loaded))
+	stats, err = db.GetStats(ctx, j.Id)
+	assert.NoError(t, err)
+	assert.Len(t, stats, 6)
+
+	dropped, err := db.TrimStats(ctx, j.Id, stats[1].RanAt, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dropped)
+	stats, err = db.GetStats(ctx, j.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run-3", "run-4", "run-5"}, runIds(stats))
+
+	assert.NoError(t, db.Delete(ctx, j.Id))
+	stats, err = db.GetStats(ctx, j.Id)
+	assert.NoError(t, err)
+	assert.Empty(t, stats)
+}
+
+func TestSplitStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-bolt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Jobs used to be saved with their stats.
+	db := GetBoltDB(dir)
+	j := job.GetMockJobWithGenericSchedule()
+	j.Id = "inline"
+	j.Stats = mockStats(3)
+	b, err := job.MarshalJob(j)
+	assert.NoError(t, err)
+	err = db.dbConn.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(metaBucket).Delete(statsSplitKey); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists(jobBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(j.Id), b)
+	})
+	assert.NoError(t, err)
+	db.Close()
+
+	db = GetBoltDB(dir)
+	defer db.Close()
+	stats, err := db.GetStats(ctx, j.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"run-0", "run-1", "run-2"}, runIds(stats))
+	err = db.dbConn.View(func(tx *bolt.Tx) error {
+		stored, err := job.UnmarshalJob(tx.Bucket(jobBucket).Get([]byte(j.Id)))
+		if assert.NoError(t, err) {
+			assert.Empty(t, stored.Stats)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestCacheUnloadsStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-bolt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	job.SetLoadedStats(2)
+	defer job.SetLoadedStats(job.DefaultLoadedStats)
+
+	db := GetBoltDB(dir)
+	defer db.Close()
+	j := job.GetMockRecurringJobWithSchedule(time.Now().Add(5*time.Minute), "PT1H")
+	j.Id = "unloaded"
+	j.Stats = mockStats(5)
+	assert.NoError(t, db.Save(ctx, j))
+
+	cache := job.NewLockFreeJobCache(db)
+	cache.Start(time.Hour)
+	loaded, err := cache.Get(j.Id)
+	assert.NoError(t, err)
+	defer loaded.StopTimer()
+	assert.Len(t, loaded.Stats, 2)
+
+	// Older stats are read when they're asked for.
+	assert.Len(t, loaded.QueryStats(job.StatsQuery{}), 5)
+	assert.Equal(t, 5, loaded.StatsSummary(24*time.Hour).Runs)
+	assert.Len(t, loaded.AllStats(), 5)
+}
+
+func TestCacheRestartsFixedRepetitionJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kala-bolt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := GetBoltDB(dir)
+	defer db.Close()
+	// Jobs with a fixed number of repetitions keep all their stats, beyond
+	// the stats loaded for other jobs.
+	j := job.GetMockJobWithSchedule(150, time.Now().Add(time.Hour), "PT1H")
+	j.Id = "repeated"
+	j.Stats = mockStats(job.DefaultLoadedStats + 20)
+	j.Metadata.NumberOfFinishedRuns = uint(len(j.Stats))
+	assert.NoError(t, db.Save(ctx, j))
+
+	cache := job.NewLockFreeJobCache(db)
+	cache.Start(time.Hour)
+	loaded, err := cache.Get(j.Id)
+	assert.NoError(t, err)
+	defer loaded.StopTimer()
+	assert.Len(t, loaded.Stats, job.DefaultLoadedStats+20)
+	assert.True(t, loaded.ShouldStartWaiting())
+	assert.False(t, loaded.NextRunAt.IsZero())
+
+	assert.NoError(t, cache.Persist())
+	assert.Len(t, loaded.Stats, job.DefaultLoadedStats+20)
+
+	// It stops once it ran its repetitions.
+	loaded.Metadata.NumberOfFinishedRuns = 151
+	assert.False(t, loaded.ShouldStartWaiting())
+}
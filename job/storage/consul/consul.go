@@ -1,57 +1,157 @@
 package consul
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/utils/logging"
 
 	"github.com/hashicorp/consul/api"
-
-	log "github.com/Sirupsen/logrus"
 )
 
 var (
-	prefix = "kala/jobs/"
+	log = logging.GetLogger(logging.DB)
+
+	// DefaultPrefix is the KV prefix jobs are stored under by default.
+	DefaultPrefix = "kala/jobs/"
+
+	// Consul limits the number of operations of a transaction.
+	maxTxnOps = 64
 )
 
+func init() {
+	job.RegisterDriver("consul", job.DriverFunc(func(opts job.DBOptions) (job.JobDB, error) {
+		options, err := ParseURL(opts.Address)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Password != "" {
+			options.Token = opts.Password
+		}
+		return NewWithOptions(options)
+	}))
+}
+
+// Options configures the connection to Consul. Empty options default to the
+// CONSUL_HTTP_ADDR, CONSUL_HTTP_TOKEN, CONSUL_HTTP_SSL, CONSUL_CACERT,
+// CONSUL_CLIENT_CERT and CONSUL_CLIENT_KEY environment variables, like the
+// Consul CLI.
+type Options struct {
+	// Address of the agent, host:port.
+	Address    string
+	Scheme     string
+	Datacenter string
+
+	// ACL token.
+	Token string
+
+	// KV prefix the jobs are stored under, DefaultPrefix if empty.
+	Prefix string
+
+	TLS api.TLSConfig
+}
+
+// ParseURL returns the Options of an address, which is either host:port or a
+// URL with the KV prefix as path:
+//
+//	https://consul.example.com:8501/apps/kala/jobs?datacenter=dc1&token=...&ca_file=ca.pem&cert_file=client.pem&key_file=client-key.pem
+//
+// skip_verify=true disables verifying the certificate of the agent.
+func ParseURL(address string) (Options, error) {
+	opts := Options{}
+	if !strings.Contains(address, "://") {
+		opts.Address = address
+		return opts, nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return opts, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return opts, fmt.Errorf("Unknown Consul URL scheme '%s'", u.Scheme)
+	}
+	query := u.Query()
+	opts.Address = u.Host
+	opts.Scheme = u.Scheme
+	opts.Datacenter = query.Get("datacenter")
+	opts.Token = query.Get("token")
+	opts.Prefix = strings.Trim(u.Path, "/")
+	opts.TLS = api.TLSConfig{
+		CAFile:             query.Get("ca_file"),
+		CertFile:           query.Get("cert_file"),
+		KeyFile:            query.Get("key_file"),
+		InsecureSkipVerify: query.Get("skip_verify") == "true",
+	}
+	return opts, nil
+}
+
 func New(address string) *ConsulJobDB {
+	db, err := NewWithOptions(Options{Address: address})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+// NewWithOptions returns a ConsulJobDB storing jobs under the prefix of the
+// options.
+func NewWithOptions(opts Options) (*ConsulJobDB, error) {
 	config := api.DefaultConfig()
-	if address != "" {
-		config.Address = address
+	if opts.Address != "" {
+		config.Address = opts.Address
+	}
+	if opts.Scheme != "" {
+		config.Scheme = opts.Scheme
+	}
+	if opts.Datacenter != "" {
+		config.Datacenter = opts.Datacenter
+	}
+	if opts.Token != "" {
+		config.Token = opts.Token
+	}
+	if opts.TLS.CAFile != "" || opts.TLS.CertFile != "" || opts.TLS.KeyFile != "" || opts.TLS.InsecureSkipVerify {
+		config.TLSConfig = opts.TLS
 	}
 	client, err := api.NewClient(config)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return &ConsulJobDB{
-		conn: client.KV(),
+
+	prefix := DefaultPrefix
+	if opts.Prefix != "" {
+		// Keys of jobs start with the prefix, and nothing else does.
+		prefix = strings.Trim(opts.Prefix, "/") + "/"
 	}
+	return &ConsulJobDB{
+		conn:   client.KV(),
+		prefix: prefix,
+	}, nil
 }
 
 type ConsulJobDB struct {
-	conn *api.KV
+	conn   *api.KV
+	prefix string
 }
 
 func (db *ConsulJobDB) Close() error {
 	return nil
 }
 
-func (db *ConsulJobDB) GetAll() ([]*job.Job, error) {
+func (db *ConsulJobDB) GetAll(ctx context.Context) ([]*job.Job, error) {
 	allJobs := []*job.Job{}
 
-	pairs, _, err := db.conn.List(prefix, &api.QueryOptions{RequireConsistent: true})
+	pairs, _, err := db.conn.List(db.prefix, (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx))
 	if err != nil {
 		return allJobs, err
 	}
 	for _, pair := range pairs {
 
-		buffer := bytes.NewBuffer(pair.Value)
-		dec := json.NewDecoder(buffer)
-		j := new(job.Job)
-		err := dec.Decode(j)
+		j, err := job.UnmarshalJob(pair.Value)
 		if err != nil {
+			log.Errorf("Error occured loading job %s: %s", pair.Key, err)
 			continue
 		}
 		j.InitDelayDuration(false)
@@ -61,37 +161,63 @@ func (db *ConsulJobDB) GetAll() ([]*job.Job, error) {
 	return allJobs, err
 }
 
-func (db *ConsulJobDB) Get(id string) (*job.Job, error) {
-	j := new(job.Job)
-
-	pair, _, err := db.conn.Get(prefix+id, &api.QueryOptions{RequireConsistent: true})
+func (db *ConsulJobDB) Get(ctx context.Context, id string) (*job.Job, error) {
+	pair, _, err := db.conn.Get(db.prefix+id, (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	if pair == nil {
-		return nil, fmt.Errorf("ID %s not found", id)
+		return nil, job.ErrNotFound
 	}
-	buf := bytes.NewBuffer(pair.Value)
-	err = json.NewDecoder(buf).Decode(j)
+	return job.UnmarshalJob(pair.Value)
+}
+
+func (db *ConsulJobDB) Delete(ctx context.Context, id string) error {
+	pair, _, err := db.conn.Get(db.prefix+id, (&api.QueryOptions{RequireConsistent: true}).WithContext(ctx))
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if pair == nil {
+		return job.ErrNotFound
 	}
-	return j, nil
+	_, err = db.conn.Delete(db.prefix+id, (&api.WriteOptions{}).WithContext(ctx))
+	return err
 }
 
-func (db *ConsulJobDB) Delete(id string) error {
-	_, err := db.conn.Delete(prefix+id, &api.WriteOptions{})
-	return err
+// SaveAll saves the jobs in transactions of up to 64 jobs each.
+func (db *ConsulJobDB) SaveAll(ctx context.Context, jobs []*job.Job) error {
+	for len(jobs) > 0 {
+		n := len(jobs)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		ops := api.KVTxnOps{}
+		for _, j := range jobs[:n] {
+			b, err := job.MarshalJob(j)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, &api.KVTxnOp{Verb: api.KVSet, Key: db.prefix + j.Id, Value: b})
+		}
+		ok, resp, _, err := db.conn.Txn(ops, (&api.QueryOptions{}).WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Errorf("Transaction saving jobs was rolled back: %v", resp.Errors)
+			return job.ErrConflict
+		}
+		jobs = jobs[n:]
+	}
+	return nil
 }
 
-func (db *ConsulJobDB) Save(j *job.Job) error {
-	buffer := new(bytes.Buffer)
-	enc := json.NewEncoder(buffer)
-	err := enc.Encode(j)
+func (db *ConsulJobDB) Save(ctx context.Context, j *job.Job) error {
+	b, err := job.MarshalJob(j)
 	if err != nil {
 		return err
 	}
-	pair := &api.KVPair{Key: prefix + j.Id, Value: buffer.Bytes()}
-	_, err = db.conn.Put(pair, &api.WriteOptions{})
+	pair := &api.KVPair{Key: db.prefix + j.Id, Value: b}
+	_, err = db.conn.Put(pair, (&api.WriteOptions{}).WithContext(ctx))
 	return err
 }
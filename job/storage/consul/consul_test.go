@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -9,16 +10,18 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+var ctx = context.Background()
+
 var testDbPath = ""
 
 func setupTest(t *testing.T) {
 	db := New("")
 	defer db.Close()
 
-	jobs, err := db.GetAll()
+	jobs, err := db.GetAll(ctx)
 	assert.NoError(t, err)
 	for _, j := range jobs {
-		err = db.Delete(j.Id)
+		err = db.Delete(ctx, j.Id)
 		assert.NoError(t, err)
 	}
 
@@ -33,9 +36,9 @@ func TestSaveAndGetJob(t *testing.T) {
 
 	genericMockJob := job.GetMockJobWithGenericSchedule()
 	genericMockJob.Init(cache)
-	db.Save(genericMockJob)
+	db.Save(ctx, genericMockJob)
 
-	j, err := db.Get(genericMockJob.Id)
+	j, err := db.Get(ctx, genericMockJob.Id)
 	assert.Nil(t, err)
 
 	assert.WithinDuration(t, j.NextRunAt, genericMockJob.NextRunAt, 100*time.Microsecond)
@@ -55,10 +58,10 @@ func TestDeleteJob(t *testing.T) {
 
 	genericMockJob := job.GetMockJobWithGenericSchedule()
 	genericMockJob.Init(cache)
-	db.Save(genericMockJob)
+	db.Save(ctx, genericMockJob)
 
 	// Make sure its there
-	j, err := db.Get(genericMockJob.Id)
+	j, err := db.Get(ctx, genericMockJob.Id)
 	assert.Nil(t, err)
 	assert.Equal(t, j.Name, genericMockJob.Name)
 	retrievedJob, err := cache.Get(genericMockJob.Id)
@@ -70,7 +73,7 @@ func TestDeleteJob(t *testing.T) {
 	assert.Nil(t, err)
 	//
 	// fmt.Printf("%#v", genericMockJob)
-	k, err := db.Get(genericMockJob.Id)
+	k, err := db.Get(ctx, genericMockJob.Id)
 	assert.Error(t, err)
 	assert.Nil(t, k)
 	retrievedJobTwo, err := cache.Get(genericMockJob.Id)
@@ -89,15 +92,44 @@ func TestSaveAndGetAllJobs(t *testing.T) {
 
 	genericMockJobOne := job.GetMockJobWithGenericSchedule()
 	genericMockJobOne.Init(cache)
-	err := db.Save(genericMockJobOne)
+	err := db.Save(ctx, genericMockJobOne)
 	assert.NoError(t, err)
 
 	genericMockJobTwo := job.GetMockJobWithGenericSchedule()
 	genericMockJobTwo.Init(cache)
-	err = db.Save(genericMockJobTwo)
+	err = db.Save(ctx, genericMockJobTwo)
 	assert.NoError(t, err)
 
-	jobs, err := db.GetAll()
+	jobs, err := db.GetAll(ctx)
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(jobs))
 }
+
+func TestParseURL(t *testing.T) {
+	opts, err := ParseURL("127.0.0.1:8500")
+	assert.NoError(t, err)
+	assert.Equal(t, Options{Address: "127.0.0.1:8500"}, opts)
+
+	opts, err = ParseURL("https://consul.example.com:8501/apps/kala/?datacenter=dc2&token=secret&ca_file=/etc/ca.pem&skip_verify=true")
+	assert.NoError(t, err)
+	assert.Equal(t, "consul.example.com:8501", opts.Address)
+	assert.Equal(t, "https", opts.Scheme)
+	assert.Equal(t, "dc2", opts.Datacenter)
+	assert.Equal(t, "secret", opts.Token)
+	assert.Equal(t, "apps/kala", opts.Prefix)
+	assert.Equal(t, "/etc/ca.pem", opts.TLS.CAFile)
+	assert.True(t, opts.TLS.InsecureSkipVerify)
+
+	_, err = ParseURL("consul://127.0.0.1:8500")
+	assert.Error(t, err)
+}
+
+func TestNewWithOptions(t *testing.T) {
+	db, err := NewWithOptions(Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultPrefix, db.prefix)
+
+	db, err = NewWithOptions(Options{Prefix: "/apps/kala", Datacenter: "dc2", Token: "secret"})
+	assert.NoError(t, err)
+	assert.Equal(t, "apps/kala/", db.prefix)
+}
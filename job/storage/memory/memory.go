@@ -0,0 +1,133 @@
+// Package memory implements a JobDB which keeps the jobs in memory, for tests
+// and ephemeral deployments whose jobs don't outlive Kala.
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ajvb/kala/job"
+)
+
+func init() {
+	job.RegisterDriver("memory", job.DriverFunc(func(opts job.DBOptions) (job.JobDB, error) {
+		return New(), nil
+	}))
+}
+
+// DB is a JobDB which keeps the jobs in memory, in the format they're
+// persisted in, so that jobs read from it are copies like those of other
+// JobDBs.
+type DB struct {
+	lock sync.RWMutex
+	jobs map[string][]byte
+}
+
+// New returns an empty DB.
+func New() *DB {
+	return &DB{jobs: map[string][]byte{}}
+}
+
+// GetAll returns all jobs, ordered by id.
+func (db *DB) GetAll(ctx context.Context) ([]*job.Job, error) {
+	return db.GetPage(ctx, "", -1)
+}
+
+// GetPage returns up to limit jobs with ids after the given id, in order. A
+// negative limit returns all of them.
+func (db *DB) GetPage(ctx context.Context, after string, limit int) ([]*job.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	ids := make([]string, 0, len(db.jobs))
+	for id := range db.jobs {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if limit >= 0 && len(ids) > limit {
+		ids = ids[:limit]
+	}
+	jobs := make([]*job.Job, 0, len(ids))
+	for _, id := range ids {
+		j, err := job.UnmarshalJob(db.jobs[id])
+		if err != nil {
+			return nil, err
+		}
+		if err := j.InitDelayDuration(false); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func (db *DB) Get(ctx context.Context, id string) (*job.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	db.lock.RLock()
+	b, ok := db.jobs[id]
+	db.lock.RUnlock()
+	if !ok {
+		return nil, job.ErrNotFound
+	}
+	return job.UnmarshalJob(b)
+}
+
+func (db *DB) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if _, ok := db.jobs[id]; !ok {
+		return job.ErrNotFound
+	}
+	delete(db.jobs, id)
+	return nil
+}
+
+func (db *DB) Save(ctx context.Context, j *job.Job) error {
+	return db.SaveAndDelete(ctx, []*job.Job{j}, nil)
+}
+
+func (db *DB) SaveAll(ctx context.Context, jobs []*job.Job) error {
+	return db.SaveAndDelete(ctx, jobs, nil)
+}
+
+// SaveAndDelete saves the jobs and deletes the jobs with the ids at once:
+// nothing is changed unless all the jobs can be marshalled.
+func (db *DB) SaveAndDelete(ctx context.Context, jobs []*job.Job, ids []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	saved := make(map[string][]byte, len(jobs))
+	for _, j := range jobs {
+		b, err := job.MarshalJob(j)
+		if err != nil {
+			return err
+		}
+		saved[j.Id] = b
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	for id, b := range saved {
+		db.jobs[id] = b
+	}
+	for _, id := range ids {
+		delete(db.jobs, id)
+	}
+	return nil
+}
+
+// Close does nothing: the jobs are kept until the DB is garbage collected.
+func (db *DB) Close() error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ajvb/kala/job"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+func TestSaveAndGetJob(t *testing.T) {
+	db := New()
+	j := job.GetMockJobWithGenericSchedule()
+	j.Id = "a"
+	assert.NoError(t, db.Save(ctx, j))
+
+	saved, err := db.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, j.Name, saved.Name)
+	assert.Equal(t, j.Command, saved.Command)
+	assert.Equal(t, j.Schedule, saved.Schedule)
+
+	// Jobs read are copies.
+	saved.Name = "changed"
+	again, err := db.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, j.Name, again.Name)
+
+	_, err = db.Get(ctx, "missing")
+	assert.Equal(t, job.ErrNotFound, err)
+}
+
+func TestDeleteJob(t *testing.T) {
+	db := New()
+	j := job.GetMockJob()
+	j.Id = "a"
+	assert.NoError(t, db.Save(ctx, j))
+
+	assert.NoError(t, db.Delete(ctx, "a"))
+	assert.Equal(t, job.ErrNotFound, db.Delete(ctx, "a"))
+	jobs, err := db.GetAll(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, jobs)
+}
+
+func TestGetPage(t *testing.T) {
+	db := New()
+	jobs := []*job.Job{}
+	for i := 0; i < 5; i++ {
+		j := job.GetMockJob()
+		j.Id = fmt.Sprintf("job-%d", i)
+		jobs = append(jobs, j)
+	}
+	assert.NoError(t, db.SaveAll(ctx, jobs))
+
+	page, err := db.GetPage(ctx, "", 2)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 2) {
+		assert.Equal(t, "job-0", page[0].Id)
+		assert.Equal(t, "job-1", page[1].Id)
+	}
+	page, err = db.GetPage(ctx, "job-3", 2)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, "job-4", page[0].Id)
+	}
+
+	assert.NoError(t, db.SaveAndDelete(ctx, jobs[:1], []string{"job-1", "job-2"}))
+	all, err := db.GetAll(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+}
+
+func TestDriver(t *testing.T) {
+	db, err := job.OpenDB("memory", job.DBOptions{})
+	assert.NoError(t, err)
+	assert.IsType(t, &DB{}, db)
+	assert.NoError(t, db.Close())
+}
@@ -1,14 +1,18 @@
 package mongo
 
 import (
+	"context"
+
 	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/utils/logging"
 
-	log "github.com/Sirupsen/logrus"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
 var (
+	log = logging.GetLogger(logging.DB)
+
 	database   = "kala"
 	collection = "jobs"
 )
@@ -20,69 +24,233 @@ type DB struct {
 	session    *mgo.Session
 }
 
-// New instantiates a new DB.
-func New(addrs string, cred *mgo.Credential) *DB {
-	session, err := mgo.Dial(addrs)
-	if err != nil {
-		log.Fatal(err)
-	}
-	if cred.Username != "" {
-		err = session.Login(cred)
+func init() {
+	job.RegisterDriver("mongo", job.DriverFunc(func(opts job.DBOptions) (job.JobDB, error) {
+		options, err := ParseURL(opts.Address)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
+		}
+		if opts.Username != "" {
+			options.DialInfo.Username = opts.Username
+			options.DialInfo.Password = opts.Password
 		}
+		return NewWithOptions(options)
+	}))
+}
+
+// NewWithOptions instantiates a new DB, storing jobs in the database of the
+// options, or kala if it has none.
+func NewWithOptions(opts *Options) (*DB, error) {
+	session, err := mgo.DialWithInfo(opts.DialInfo)
+	if err != nil {
+		return nil, err
+	}
+	session.SetMode(opts.Mode, true)
+	if opts.Safe != nil {
+		session.SetSafe(opts.Safe)
 	}
-	db := session.DB(database)
+	name := opts.DialInfo.Database
+	if name == "" {
+		name = database
+	}
+	db := session.DB(name)
 	c := db.C(collection)
-	session.SetMode(mgo.Monotonic, true)
-	c.EnsureIndexKey("id")
+	for _, key := range []string{"id", "owner", "name"} {
+		if err := c.EnsureIndexKey(key); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
 	return &DB{
 		collection: c,
 		database:   db,
 		session:    session,
+	}, nil
+}
+
+// New instantiates a new DB.
+func New(addrs string, cred *mgo.Credential) *DB {
+	opts, err := ParseURL(addrs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cred.Username != "" {
+		opts.DialInfo.Username = cred.Username
+		opts.DialInfo.Password = cred.Password
+		if cred.Source != "" {
+			opts.DialInfo.Source = cred.Source
+		}
+		if cred.Mechanism != "" {
+			opts.DialInfo.Mechanism = cred.Mechanism
+		}
+	}
+	db, err := NewWithOptions(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+// record is the document of a persisted Job. Jobs saved before envelopes are
+// documents of the Job itself, without data.
+type record struct {
+	Id string `bson:"id"`
+
+	// Indexed, for querying jobs from the mongo shell.
+	Name  string `bson:"name"`
+	Owner string `bson:"owner"`
+
+	Data []byte `bson:"data"`
+}
+
+func newRecord(j *job.Job) (*record, error) {
+	data, err := job.MarshalJob(j)
+	if err != nil {
+		return nil, err
+	}
+	return &record{Id: j.Id, Name: j.Name, Owner: j.Owner, Data: data}, nil
+}
+
+func decode(raw bson.Raw) (*job.Job, error) {
+	rec := record{}
+	if err := raw.Unmarshal(&rec); err != nil {
+		return nil, err
+	}
+	if rec.Data != nil {
+		return job.UnmarshalJob(rec.Data)
 	}
+	j := &job.Job{}
+	if err := raw.Unmarshal(j); err != nil {
+		return nil, err
+	}
+	return job.UpgradeJob(j)
 }
 
 // GetAll returns all persisted Jobs.
-func (d DB) GetAll() ([]*job.Job, error) {
+func (d DB) GetAll(ctx context.Context) ([]*job.Job, error) {
 	jobs := []*job.Job{}
-	err := d.collection.Find(bson.M{}).All(&jobs)
+	if err := ctx.Err(); err != nil {
+		return jobs, err
+	}
+	raws := []bson.Raw{}
+	err := d.collection.Find(bson.M{}).All(&raws)
 	if err != nil {
 		return jobs, err
 	}
+	for _, raw := range raws {
+		j, err := decode(raw)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
 	return jobs, nil
 }
 
-// Get returns a persisted Job.
-func (d DB) Get(id string) (*job.Job, error) {
-	result := job.Job{}
-	err := d.collection.Find(bson.M{"id": id}).One(&result)
+// GetPage returns up to limit jobs with ids after the given id, in order.
+func (d DB) GetPage(ctx context.Context, after string, limit int) ([]*job.Job, error) {
+	jobs := []*job.Job{}
+	if err := ctx.Err(); err != nil {
+		return jobs, err
+	}
+	raws := []bson.Raw{}
+	err := d.collection.Find(bson.M{"id": bson.M{"$gt": after}}).Sort("id").Limit(limit).All(&raws)
 	if err != nil {
+		return jobs, err
+	}
+	for _, raw := range raws {
+		j, err := decode(raw)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Get returns a persisted Job.
+func (d DB) Get(ctx context.Context, id string) (*job.Job, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	return &result, nil
+	raw := bson.Raw{}
+	err := d.collection.Find(bson.M{"id": id}).One(&raw)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return decode(raw)
 }
 
 // Delete deletes a persisted Job.
-func (d DB) Delete(id string) error {
+func (d DB) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	err := d.collection.Remove(bson.M{"id": id})
 	if err != nil {
-		return err
+		return convertError(err)
 	}
 
 	return nil
 }
 
-// Save persists a Job.
-func (d DB) Save(j *job.Job) error {
-	err := d.collection.Insert(j)
+// Save persists a Job, replacing the persisted Job with the same id, so that
+// saving a Job again doesn't add a copy of it.
+func (d DB) Save(ctx context.Context, j *job.Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rec, err := newRecord(j)
 	if err != nil {
 		return err
 	}
+	_, err = d.collection.Upsert(bson.M{"id": j.Id}, rec)
+	if err != nil {
+		return convertError(err)
+	}
 
 	return nil
 }
 
+// SaveAll persists the Jobs with a single bulk write.
+func (d DB) SaveAll(ctx context.Context, jobs []*job.Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	bulk := d.collection.Bulk()
+	for _, j := range jobs {
+		rec, err := newRecord(j)
+		if err != nil {
+			return err
+		}
+		bulk.Upsert(bson.M{"id": j.Id}, rec)
+	}
+	_, err := bulk.Run()
+	return convertError(err)
+}
+
+// convertError returns the errors of the JobDB interface for mgo's errors.
+func convertError(err error) error {
+	switch {
+	case err == mgo.ErrNotFound:
+		return job.ErrNotFound
+	case mgo.IsDup(err):
+		return job.ErrConflict
+	}
+	return err
+}
+
+// Ping checks the connection to Mongo.
+func (d DB) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.session.Ping()
+}
+
 // Close closes the connection to Redis.
 func (d DB) Close() error {
 	d.session.Close()
@@ -0,0 +1,13 @@
+//go:build !go1.15
+// +build !go1.15
+
+package postgres
+
+import (
+	"database/sql"
+	"time"
+)
+
+func setConnMaxIdleTime(conn *sql.DB, d time.Duration) {
+	log.Warnf("Ignoring the idle timeout of Postgres connections, which needs Kala built with Go 1.15 or later")
+}
@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migrationsLock is the key of the advisory lock held while migrating, so
+// that Kala servers started together don't migrate at the same time.
+const migrationsLock = 0x6b616c61
+
+// migrations[i] creates version i+1 of the tables. Released migrations are
+// never changed: a change to the tables is a new migration.
+var migrations = []string{
+	`CREATE TABLE kala_jobs (
+		id         text PRIMARY KEY,
+		data       jsonb NOT NULL,
+		updated_at timestamptz NOT NULL DEFAULT now()
+	)`,
+	`ALTER TABLE kala_jobs
+		ADD COLUMN name     text    GENERATED ALWAYS AS (data->'job'->>'name') STORED,
+		ADD COLUMN owner    text    GENERATED ALWAYS AS (data->'job'->>'owner') STORED,
+		ADD COLUMN disabled boolean GENERATED ALWAYS AS ((data->'job'->>'disabled')::boolean) STORED;
+	CREATE INDEX kala_jobs_name ON kala_jobs (name);
+	CREATE INDEX kala_jobs_owner ON kala_jobs (owner)`,
+}
+
+// migrate runs the migrations the database hasn't run yet in a transaction,
+// recording them in kala_schema_migrations.
+func migrate(ctx context.Context, conn *sql.DB) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationsLock); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS kala_schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	var version int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM kala_schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("The Postgres tables are at version %d, newer than version %d of this Kala", version, len(migrations))
+	}
+
+	for v := version; v < len(migrations); v++ {
+		if _, err := tx.ExecContext(ctx, migrations[v]); err != nil {
+			return fmt.Errorf("Error occured migrating the Postgres tables to version %d: %s", v+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO kala_schema_migrations (version) VALUES ($1)`, v+1); err != nil {
+			return err
+		}
+		log.Infof("Migrated the Postgres tables to version %d", v+1)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,260 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/utils/logging"
+
+	_ "github.com/lib/pq"
+)
+
+var log = logging.GetLogger(logging.DB)
+
+// PoolOptions tunes the pool of connections to Postgres. Zero values keep the
+// defaults of database/sql.
+type PoolOptions struct {
+	MaxConns        int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// Only supported when built with Go 1.15 or later.
+	ConnMaxIdleTime time.Duration
+}
+
+// DB is a JobDB storing jobs in Postgres, as JSONB documents in the format of
+// job.MarshalJob. The name, owner and disabled columns are generated from the
+// documents, so that jobs can be queried with SQL, e.g.
+//
+//	SELECT id, name FROM kala_jobs WHERE owner = 'ops@example.com' AND NOT disabled
+//
+// It requires Postgres 12 or later.
+type DB struct {
+	conn *sql.DB
+}
+
+func init() {
+	job.RegisterDriver("postgres", job.DriverFunc(func(opts job.DBOptions) (job.JobDB, error) {
+		dsn, err := withCredentials(opts.Address, opts.Username, opts.Password)
+		if err != nil {
+			return nil, err
+		}
+		return New(dsn, PoolOptions{
+			MaxConns:        opts.MaxConns,
+			MaxIdleConns:    opts.MaxIdleConns,
+			ConnMaxLifetime: opts.ConnMaxLifetime,
+			ConnMaxIdleTime: opts.ConnMaxIdleTime,
+		})
+	}))
+}
+
+// withCredentials sets the user and password of the dsn, either a URL
+// (postgres://host/db?sslmode=disable) or keyword/value pairs
+// (host=localhost dbname=kala), if they're given.
+func withCredentials(dsn, username, password string) (string, error) {
+	if username == "" {
+		return dsn, nil
+	}
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
+		}
+		u.User = url.UserPassword(username, password)
+		return u.String(), nil
+	}
+	return fmt.Sprintf("%s user=%s password=%s", dsn, quote(username), quote(password)), nil
+}
+
+// quote quotes a value of a keyword/value dsn.
+func quote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	return "'" + strings.Replace(value, "'", `\'`, -1) + "'"
+}
+
+// New connects to Postgres, and migrates the tables of Kala to the version of
+// this Kala.
+func New(dsn string, pool PoolOptions) (*DB, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if pool.MaxConns > 0 {
+		conn.SetMaxOpenConns(pool.MaxConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		conn.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime > 0 {
+		setConnMaxIdleTime(conn, pool.ConnMaxIdleTime)
+	}
+
+	if err := migrate(context.Background(), conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &DB{conn: conn}, nil
+}
+
+func (db *DB) Close() error {
+	return db.conn.Close()
+}
+
+// Ping checks the connection to Postgres.
+func (db *DB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+// GetAll returns all persisted Jobs.
+func (db *DB) GetAll(ctx context.Context) ([]*job.Job, error) {
+	return db.Find(ctx, Query{})
+}
+
+// GetPage returns up to limit jobs with ids after the given id, in order.
+func (db *DB) GetPage(ctx context.Context, after string, limit int) ([]*job.Job, error) {
+	rows, err := db.conn.QueryContext(ctx, `SELECT data FROM kala_jobs WHERE id > $1 ORDER BY id LIMIT $2`, after, limit)
+	if err != nil {
+		return []*job.Job{}, err
+	}
+	return scanJobs(rows)
+}
+
+// Get returns a persisted Job.
+func (db *DB) Get(ctx context.Context, id string) (*job.Job, error) {
+	var data []byte
+	err := db.conn.QueryRowContext(ctx, `SELECT data FROM kala_jobs WHERE id = $1`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, job.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job.UnmarshalJob(data)
+}
+
+// Delete deletes a persisted Job.
+func (db *DB) Delete(ctx context.Context, id string) error {
+	result, err := db.conn.ExecContext(ctx, `DELETE FROM kala_jobs WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return job.ErrNotFound
+	}
+	return nil
+}
+
+// Save persists a Job, replacing the job with the same id.
+func (db *DB) Save(ctx context.Context, j *job.Job) error {
+	return db.SaveAll(ctx, []*job.Job{j})
+}
+
+// SaveAll persists the Jobs in a single transaction.
+func (db *DB) SaveAll(ctx context.Context, jobs []*job.Job) error {
+	return db.SaveAndDelete(ctx, jobs, nil)
+}
+
+// SaveAndDelete persists the Jobs and deletes the Jobs with the ids in a
+// single transaction.
+func (db *DB) SaveAndDelete(ctx context.Context, jobs []*job.Job, ids []string) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO kala_jobs (id, data, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, updated_at = EXCLUDED.updated_at`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, j := range jobs {
+		data, err := job.MarshalJob(j)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, j.Id, string(data)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM kala_jobs WHERE id = $1`, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Query selects jobs by the generated columns. Empty fields match all jobs.
+type Query struct {
+	Name     string
+	Owner    string
+	Disabled *bool
+}
+
+// where returns the WHERE clause of the query, and its arguments.
+func (q Query) where() (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+	add := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if q.Name != "" {
+		add("name", q.Name)
+	}
+	if q.Owner != "" {
+		add("owner", q.Owner)
+	}
+	if q.Disabled != nil {
+		add("disabled", *q.Disabled)
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Find returns the persisted Jobs matching the query, using the indexes of the
+// generated columns instead of loading every job.
+func (db *DB) Find(ctx context.Context, q Query) ([]*job.Job, error) {
+	where, args := q.where()
+	rows, err := db.conn.QueryContext(ctx, `SELECT data FROM kala_jobs`+where+` ORDER BY id`, args...)
+	if err != nil {
+		return []*job.Job{}, err
+	}
+	return scanJobs(rows)
+}
+
+// scanJobs returns the jobs of the rows of a query of their data, and closes
+// the rows.
+func scanJobs(rows *sql.Rows) ([]*job.Job, error) {
+	jobs := []*job.Job{}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		j, err := job.UnmarshalJob(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := j.InitDelayDuration(false); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
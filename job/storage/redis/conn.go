@@ -0,0 +1,328 @@
+package redis
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	defaultAddress = "127.0.0.1:6379"
+
+	// Redirects followed by a command to a Redis Cluster.
+	maxRedirects = 5
+)
+
+var (
+	ErrNoMaster        = errors.New("None of the sentinels knows the master")
+	ErrTooManyRedirect = errors.New("Too many cluster redirects")
+)
+
+// Options configures the connection to Redis.
+type Options struct {
+	// Addresses of the Redis server, of the sentinels if MasterName is set,
+	// or of some nodes of the cluster if Cluster is set.
+	Addrs []string
+
+	// Name of the master monitored by the sentinels.
+	MasterName string
+
+	// Follow the redirects of a Redis Cluster.
+	Cluster bool
+
+	// Credentials sent with AUTH. Username is only needed for ACL users of
+	// Redis 6 and later.
+	Username string
+	Password string
+
+	Database int
+
+	// TLSConfig enables TLS if it isn't nil.
+	TLSConfig *tls.Config
+
+	// Timeout of connecting, reading and writing, defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// ParseURL returns the Options of an address, which is either host:port or
+// a URL:
+//
+//	redis://[[username]:password@]host:port[/database]
+//	rediss://... for TLS, with ?skip_verify=true to not verify the certificate
+//	redis-sentinel://[[username]:password@]host:port,host:port/master[/database]
+//	redis-cluster://[[username]:password@]host:port,host:port
+//
+// Sentinel and cluster URLs also take the rediss- prefix for TLS, e.g.
+// rediss-cluster://.
+func ParseURL(address string) (Options, error) {
+	opts := Options{}
+	if address == "" {
+		opts.Addrs = []string{defaultAddress}
+		return opts, nil
+	}
+	if !strings.Contains(address, "://") {
+		opts.Addrs = []string{address}
+		return opts, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return opts, err
+	}
+	scheme := u.Scheme
+	if strings.HasPrefix(scheme, "rediss") {
+		opts.TLSConfig = &tls.Config{InsecureSkipVerify: u.Query().Get("skip_verify") == "true"}
+		scheme = "redis" + strings.TrimPrefix(scheme, "rediss")
+	}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	opts.Addrs = strings.Split(u.Host, ",")
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	switch scheme {
+	case "redis":
+	case "redis-sentinel":
+		if path[0] == "" {
+			return opts, fmt.Errorf("Missing the name of the master in '%s'", address)
+		}
+		opts.MasterName = path[0]
+		path = path[1:]
+	case "redis-cluster":
+		opts.Cluster = true
+	default:
+		return opts, fmt.Errorf("Unknown Redis URL scheme '%s'", u.Scheme)
+	}
+	if len(path) > 0 && path[0] != "" {
+		if opts.Cluster {
+			return opts, errors.New("Redis Cluster only has database 0")
+		}
+		if opts.Database, err = strconv.Atoi(path[0]); err != nil {
+			return opts, fmt.Errorf("Invalid Redis database '%s'", path[0])
+		}
+	}
+	return opts, nil
+}
+
+// failoverConn is a connection to the Redis server, the master monitored by
+// sentinels, or the node of a cluster serving the jobs, which reconnects
+// after failovers. Unlike redis.Conn, it may be used concurrently.
+type failoverConn struct {
+	opts Options
+
+	// Connects to an address, and authenticates if auth is set.
+	dial func(address string, auth bool) (redis.Conn, error)
+
+	lock sync.Mutex
+	conn redis.Conn
+}
+
+func newFailoverConn(opts Options) (*failoverConn, error) {
+	if len(opts.Addrs) == 0 {
+		opts.Addrs = []string{defaultAddress}
+	}
+	c := &failoverConn{opts: opts}
+	c.dial = c.dialAddress
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *failoverConn) dialAddress(address string, auth bool) (redis.Conn, error) {
+	timeout := c.opts.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	options := []redis.DialOption{
+		redis.DialReadTimeout(timeout),
+		redis.DialWriteTimeout(timeout),
+		redis.DialConnectTimeout(timeout),
+	}
+	if c.opts.TLSConfig != nil {
+		dialer := &net.Dialer{Timeout: timeout}
+		config := c.opts.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(address)
+		}
+		options = append(options, redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, network, addr, config)
+		}))
+	}
+	conn, err := redis.Dial("tcp", address, options...)
+	if err != nil {
+		return nil, err
+	}
+	if !auth {
+		return conn, nil
+	}
+	if c.opts.Password != "" {
+		args := []interface{}{c.opts.Password}
+		if c.opts.Username != "" {
+			args = []interface{}{c.opts.Username, c.opts.Password}
+		}
+		if _, err := conn.Do("AUTH", args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.opts.Database != 0 {
+		if _, err := conn.Do("SELECT", c.opts.Database); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// connect connects to the server, the master, or any node of the cluster.
+func (c *failoverConn) connect() error {
+	if c.opts.MasterName != "" {
+		address, err := c.master()
+		if err != nil {
+			return err
+		}
+		return c.connectTo(address)
+	}
+	var err error
+	for _, address := range c.opts.Addrs {
+		if err = c.connectTo(address); err == nil {
+			return nil
+		}
+		log.Warnf("Error occured connecting to Redis at %s: %s", address, err)
+	}
+	return err
+}
+
+func (c *failoverConn) connectTo(address string) error {
+	conn, err := c.dial(address, true)
+	if err != nil {
+		return err
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+	return nil
+}
+
+// master asks the sentinels for the address of the master.
+func (c *failoverConn) master() (string, error) {
+	for _, address := range c.opts.Addrs {
+		sentinel, err := c.dial(address, false)
+		if err != nil {
+			log.Warnf("Error occured connecting to sentinel %s: %s", address, err)
+			continue
+		}
+		reply, err := redis.Strings(sentinel.Do("SENTINEL", "get-master-addr-by-name", c.opts.MasterName))
+		sentinel.Close()
+		if err != nil || len(reply) != 2 {
+			log.Warnf("Sentinel %s doesn't know master %s: %v", address, c.opts.MasterName, err)
+			continue
+		}
+		return net.JoinHostPort(reply[0], reply[1]), nil
+	}
+	return "", ErrNoMaster
+}
+
+// Do runs the command, reconnecting and running it again if the connection
+// broke or the server is no longer the master, and following the redirects
+// of a cluster.
+func (c *failoverConn) Do(command string, args ...interface{}) (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn == nil || c.conn.Err() != nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := c.conn.Do(command, args...)
+	if c.conn.Err() != nil || isError(err, "READONLY") || isError(err, "LOADING") {
+		log.Warnf("Reconnecting to Redis after error: %s", err)
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+		reply, err = c.conn.Do(command, args...)
+	}
+
+	for i := 0; c.opts.Cluster && i < maxRedirects; i++ {
+		switch {
+		case isError(err, "MOVED"):
+			// The jobs are in a single hash, so all commands go to its node.
+			if err := c.connectTo(redirectAddress(err)); err != nil {
+				return nil, err
+			}
+			reply, err = c.conn.Do(command, args...)
+		case isError(err, "ASK"):
+			conn, dialErr := c.dial(redirectAddress(err), true)
+			if dialErr != nil {
+				return nil, dialErr
+			}
+			if _, err = conn.Do("ASKING"); err == nil {
+				reply, err = conn.Do(command, args...)
+			}
+			conn.Close()
+		default:
+			return reply, err
+		}
+	}
+	if c.opts.Cluster && (isError(err, "MOVED") || isError(err, "ASK")) {
+		return nil, ErrTooManyRedirect
+	}
+	return reply, err
+}
+
+// isError says if err is an error reply starting with the code.
+func isError(err error, code string) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), code+" ")
+}
+
+// redirectAddress returns the address of a MOVED or ASK error reply, which
+// look like "MOVED 3999 127.0.0.1:6381".
+func redirectAddress(err error) string {
+	fields := strings.Fields(err.Error())
+	return fields[len(fields)-1]
+}
+
+func (c *failoverConn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *failoverConn) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Err()
+}
+
+// Send, Flush and Receive aren't used by the DB, since pipelined commands
+// can't be run again on another server.
+func (c *failoverConn) Send(command string, args ...interface{}) error {
+	return errors.New("Send isn't supported")
+}
+
+func (c *failoverConn) Flush() error {
+	return errors.New("Flush isn't supported")
+}
+
+func (c *failoverConn) Receive() (interface{}, error) {
+	return nil, errors.New("Receive isn't supported")
+}
@@ -1,13 +1,17 @@
 package redis
 
 import (
+	"context"
+
 	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/utils/logging"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/garyburd/redigo/redis"
 )
 
 var (
+	log = logging.GetLogger(logging.DB)
+
 	// HashKey is the hash key where jobs are persisted.
 	HashKey = "kala:jobs"
 )
@@ -18,6 +22,35 @@ type DB struct {
 	keyprefix string
 }
 
+func init() {
+	job.RegisterDriver("redis", job.DriverFunc(func(opts job.DBOptions) (job.JobDB, error) {
+		options, err := ParseURL(opts.Address)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Username != "" {
+			options.Username = opts.Username
+		}
+		if opts.Password != "" {
+			options.Password = opts.Password
+		}
+		return NewWithOptions(options)
+	}))
+}
+
+// NewWithOptions instantiates a new DB connected to a Redis server, a master
+// monitored by Redis Sentinel, or a Redis Cluster. It reconnects after
+// failovers.
+func NewWithOptions(opts Options) (*DB, error) {
+	conn, err := newFailoverConn(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{
+		conn: conn,
+	}, nil
+}
+
 // New instantiates a new DB.
 func New(address string, password redis.DialOption, sendPassword bool) *DB {
 	var conn redis.Conn
@@ -39,8 +72,11 @@ func New(address string, password redis.DialOption, sendPassword bool) *DB {
 }
 
 // GetAll returns all persisted Jobs.
-func (d DB) GetAll() ([]*job.Job, error) {
+func (d DB) GetAll(ctx context.Context) ([]*job.Job, error) {
 	jobs := []*job.Job{}
+	if err := ctx.Err(); err != nil {
+		return jobs, err
+	}
 
 	vals, err := d.conn.Do("HVALS", HashKey)
 	if err != nil {
@@ -48,7 +84,7 @@ func (d DB) GetAll() ([]*job.Job, error) {
 	}
 
 	for _, val := range vals.([]interface{}) {
-		j, err := job.NewFromBytes(val.([]byte))
+		j, err := job.UnmarshalJob(val.([]byte))
 		if err != nil {
 			return nil, err
 		}
@@ -65,31 +101,43 @@ func (d DB) GetAll() ([]*job.Job, error) {
 }
 
 // Get returns a persisted Job.
-func (d DB) Get(id string) (*job.Job, error) {
+func (d DB) Get(ctx context.Context, id string) (*job.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	val, err := d.conn.Do("HGET", HashKey, id)
 	if err != nil {
 		return nil, err
 	}
 	if val == nil {
-		return nil, job.ErrJobNotFound(id)
+		return nil, job.ErrNotFound
 	}
 
-	return job.NewFromBytes(val.([]byte))
+	return job.UnmarshalJob(val.([]byte))
 }
 
 // Delete deletes a persisted Job.
-func (d DB) Delete(id string) error {
-	_, err := d.conn.Do("HDEL", HashKey, id)
+func (d DB) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deleted, err := redis.Int(d.conn.Do("HDEL", HashKey, id))
 	if err != nil {
 		return err
 	}
+	if deleted == 0 {
+		return job.ErrNotFound
+	}
 
 	return nil
 }
 
 // Save persists a Job.
-func (d DB) Save(j *job.Job) error {
-	bytes, err := j.Bytes()
+func (d DB) Save(ctx context.Context, j *job.Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	bytes, err := job.MarshalJob(j)
 	if err != nil {
 		return err
 	}
@@ -102,6 +150,40 @@ func (d DB) Save(j *job.Job) error {
 	return nil
 }
 
+// SaveAll persists the Jobs with a single HMSET.
+func (d DB) SaveAll(ctx context.Context, jobs []*job.Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+	args := []interface{}{HashKey}
+	for _, j := range jobs {
+		bytes, err := job.MarshalJob(j)
+		if err != nil {
+			return err
+		}
+		args = append(args, j.Id, bytes)
+	}
+
+	_, err := d.conn.Do("HMSET", args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Ping checks the connection to Redis.
+func (d DB) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := d.conn.Do("PING")
+	return err
+}
+
 // Close closes the connection to Redis.
 func (d DB) Close() error {
 	err := d.conn.Close()
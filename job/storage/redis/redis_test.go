@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -10,6 +11,8 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+var ctx = context.Background()
+
 type testJob struct {
 	Job   *job.Job
 	Bytes []byte
@@ -37,7 +40,7 @@ func initTestJobs(n int) []testJob {
 		j := job.GetMockJobWithGenericSchedule()
 		j.Init(cache)
 
-		bytes, err := j.Bytes()
+		bytes, err := job.MarshalJob(j)
 		if err != nil {
 			panic(err)
 		}
@@ -59,17 +62,30 @@ func TestSaveJob(t *testing.T) {
 	conn.Command("HSET", HashKey, testJob.Job.Id, testJob.Bytes).
 		Expect("ok")
 
-	err := db.Save(testJob.Job)
+	err := db.Save(ctx, testJob.Job)
 	assert.Nil(t, err)
 
 	// Test error handling
 	conn.Command("HSET", HashKey, testJob.Job.Id, testJob.Bytes).
 		ExpectError(errors.New("Redis error"))
 
-	err = db.Save(testJob.Job)
+	err = db.Save(ctx, testJob.Job)
 	assert.NotNil(t, err)
 }
 
+func TestSaveAllJobs(t *testing.T) {
+	// Expect a single HMSET operation with the ID and encoded job of every job
+	conn.Command("HMSET", HashKey, testJobs[0].Job.Id, testJobs[0].Bytes, testJobs[1].Job.Id, testJobs[1].Bytes).
+		Expect("OK")
+
+	err := db.SaveAll(ctx, []*job.Job{testJobs[0].Job, testJobs[1].Job})
+	assert.Nil(t, err)
+
+	// Nothing to save
+	err = db.SaveAll(ctx, nil)
+	assert.Nil(t, err)
+}
+
 func TestGetJob(t *testing.T) {
 	testJob := testJobs[0]
 
@@ -78,7 +94,7 @@ func TestGetJob(t *testing.T) {
 		Expect(testJob.Bytes).
 		ExpectError(nil)
 
-	storedJob, err := db.Get(testJob.Job.Id)
+	storedJob, err := db.Get(ctx, testJob.Job.Id)
 	assert.Nil(t, err)
 
 	assert.WithinDuration(t, storedJob.NextRunAt, testJob.Job.NextRunAt, 100*time.Microsecond)
@@ -93,8 +109,15 @@ func TestGetJob(t *testing.T) {
 	conn.Command("HGET", HashKey, testJob.Job.Id).
 		ExpectError(errors.New("Redis error"))
 
-	storedJob, err = db.Get(testJob.Job.Id)
+	storedJob, err = db.Get(ctx, testJob.Job.Id)
 	assert.NotNil(t, err)
+
+	// Test a missing job
+	conn.Command("HGET", HashKey, testJob.Job.Id).
+		Expect(nil)
+
+	storedJob, err = db.Get(ctx, testJob.Job.Id)
+	assert.Equal(t, job.ErrNotFound, err)
 }
 
 func TestDeleteJob(t *testing.T) {
@@ -102,17 +125,24 @@ func TestDeleteJob(t *testing.T) {
 
 	// Expect a HDEL operation to be preformed with the job ID
 	conn.Command("HDEL", HashKey, testJob.Job.Id).
-		Expect("ok").
+		Expect(int64(1)).
 		ExpectError(nil)
 
-	err := db.Delete(testJob.Job.Id)
+	err := db.Delete(ctx, testJob.Job.Id)
 	assert.Nil(t, err)
 
+	// Nothing was deleted
+	conn.Command("HDEL", HashKey, testJob.Job.Id).
+		Expect(int64(0))
+
+	err = db.Delete(ctx, testJob.Job.Id)
+	assert.Equal(t, job.ErrNotFound, err)
+
 	// Test error handling
 	conn.Command("HDEL", HashKey, testJob.Job.Id).
 		ExpectError(errors.New("Redis error"))
 
-	err = db.Delete(testJob.Job.Id)
+	err = db.Delete(ctx, testJob.Job.Id)
 	assert.NotNil(t, err)
 }
 
@@ -126,7 +156,7 @@ func TestGetAllJobs(t *testing.T) {
 	}).
 		ExpectError(nil)
 
-	jobs, err := db.GetAll()
+	jobs, err := db.GetAll(ctx)
 	assert.Nil(t, err)
 
 	for i, j := range jobs {
@@ -143,7 +173,7 @@ func TestGetAllJobs(t *testing.T) {
 	conn.Command("HVALS", HashKey).
 		ExpectError(errors.New("Redis error"))
 
-	jobs, err = db.GetAll()
+	jobs, err = db.GetAll(ctx)
 	assert.NotNil(t, err)
 }
 
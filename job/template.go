@@ -0,0 +1,92 @@
+package job
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+)
+
+// TemplateContext is the data available to the templates of a job's payloads,
+// e.g. "{{.JobName}} ran at {{.Time.Format "2006-01-02"}}".
+type TemplateContext struct {
+	JobId     string
+	JobName   string
+	Owner     string
+	RunId     string
+	RequestId string
+	Time      time.Time
+	// When the run was due, which is Time unless it was scheduled.
+	ScheduledAt time.Time
+	// Interval of the schedule which ended when the run was due, e.g. the
+	// previous day for a daily job, see Window. Both are ScheduledAt for jobs
+	// without a repeating schedule.
+	WindowStart time.Time
+	WindowEnd   time.Time
+	// Run id of the execution the run replays, if any. Replays have the Time,
+	// ScheduledAt and message of the execution they replay.
+	ReplayOf string
+
+	// Subject and data of the message which triggered the run, if any.
+	Subject string
+	Message string
+
+	// Result of the run which triggered the dependent job, if it has one.
+	ParentResult map[string]interface{}
+
+	// Responses of the earlier steps of remote jobs with steps, by name.
+	Steps map[string]*StepResponse
+}
+
+// templateContext returns the TemplateContext of the current run.
+func (j *JobRunner) templateContext() *TemplateContext {
+	c := &TemplateContext{
+		JobId:   j.job.Id,
+		JobName: j.job.Name,
+		Owner:   j.job.Owner,
+		Time:    time.Now(),
+	}
+	if j.currentStat != nil {
+		c.RunId = j.currentStat.RunId
+		c.RequestId = j.currentStat.RequestId
+		c.Time = j.currentStat.RanAt
+		c.ScheduledAt = j.currentStat.ScheduledAt
+		c.ReplayOf = j.currentStat.ReplayOf
+	}
+	if r := j.replay(); r != nil {
+		c.Time = r.of.RanAt
+	}
+	if c.ScheduledAt.IsZero() {
+		c.ScheduledAt = c.Time
+	}
+	w := j.job.window(c.ScheduledAt)
+	c.WindowStart, c.WindowEnd = w.Start, w.End
+	if msg := TriggerMessageFromContext(j.runContext()); msg != nil {
+		c.Subject = msg.Subject
+		c.Message = string(msg.Data)
+	}
+	c.ParentResult = parentResultFromContext(j.runContext())
+	return c
+}
+
+// render executes the template text with the run's TemplateContext.
+func (j *JobRunner) render(text string) (string, error) {
+	return j.renderWith(text, j.templateContext())
+}
+
+func (j *JobRunner) renderWith(text string, c *TemplateContext) (string, error) {
+	t, err := template.New(j.job.Id).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	if err := t.Execute(buf, c); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// validTemplate returns whether the template text parses.
+func validTemplate(text string) bool {
+	_, err := template.New("").Parse(text)
+	return err == nil
+}
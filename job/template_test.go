@@ -0,0 +1,23 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRender(t *testing.T) {
+	j := GetMockJob()
+	j.Id = "id"
+	runner := &JobRunner{job: j, ctx: WithTriggerMessage(context.Background(), "orders.created", []byte("order-1"))}
+	runner.runSetup()
+
+	out, err := runner.render(`{"job": "{{.JobName}}", "run": "{{.RunId}}", "order": "{{.Message}}", "day": "{{.Time.Format "2006"}}"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"job": "mock_job", "run": "`+runner.currentStat.RunId+`", "order": "order-1", "day": "`+runner.currentStat.RanAt.Format("2006")+`"}`, out)
+
+	_, err = runner.render("{{.Missing}}")
+	assert.Error(t, err)
+	assert.False(t, validTemplate("{{.JobName"))
+}
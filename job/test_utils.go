@@ -1,6 +1,7 @@
 package job
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -9,16 +10,19 @@ import (
 
 type MockDB struct{}
 
-func (m *MockDB) GetAll() ([]*Job, error) {
+func (m *MockDB) GetAll(ctx context.Context) ([]*Job, error) {
 	return nil, nil
 }
-func (m *MockDB) Get(id string) (*Job, error) {
+func (m *MockDB) Get(ctx context.Context, id string) (*Job, error) {
 	return nil, nil
 }
-func (m *MockDB) Delete(id string) error {
+func (m *MockDB) Delete(ctx context.Context, id string) error {
 	return nil
 }
-func (m *MockDB) Save(job *Job) error {
+func (m *MockDB) Save(ctx context.Context, job *Job) error {
+	return nil
+}
+func (m *MockDB) SaveAll(ctx context.Context, jobs []*Job) error {
 	return nil
 }
 func (m *MockDB) Close() error {
@@ -82,6 +86,19 @@ func GetMockRecurringJobWithSchedule(scheduleTime time.Time, delay string) *Job
 	return genericMockJob
 }
 
+// GetMockJobRunByTest returns a recurring job scheduled later, so that it
+// only runs when the test runs it, initialized in the cache once setup, if
+// any, changed it. Defer stop, which stops its timer, so that it doesn't run
+// during later tests.
+func GetMockJobRunByTest(cache JobCache, setup func(j *Job)) (*Job, func(), error) {
+	j := GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	if setup != nil {
+		setup(j)
+	}
+	err := j.Init(cache)
+	return j, j.StopTimer, err
+}
+
 func GetMockJobWithGenericSchedule() *Job {
 	fiveMinutesFromNow := time.Now().Add(time.Minute * 5)
 	return GetMockJobWithSchedule(2, fiveMinutesFromNow, "P1DT10M10S")
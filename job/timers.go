@@ -0,0 +1,143 @@
+package job
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// idleWait is how long the timer queue sleeps when no timer is queued. Queuing
+// a timer wakes it up.
+const idleWait = time.Hour
+
+// timers fires the timers of every job. A goroutine and a runtime timer per
+// job waste memory and wake the runtime constantly with tens of thousands of
+// jobs, so jobs share a single queue ordered by when their runs are due.
+var timers = newTimerQueue()
+
+// jobTimer is a timer of a job, queued until it fires or is stopped.
+type jobTimer struct {
+	at time.Time
+	f  func()
+	// Position in the heap, -1 once it fired or was stopped.
+	index int
+	queue *timerQueue
+}
+
+// Stop stops the timer from firing, like time.Timer.Stop. It returns false if
+// the timer already fired or was stopped.
+func (t *jobTimer) Stop() bool {
+	q := t.queue
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&q.timers, t.index)
+	return true
+}
+
+// timerQueue fires timers from a single goroutine, which sleeps until the
+// earliest timer is due, and runs their functions in their own goroutines
+// like time.AfterFunc, so that a long run doesn't hold up the other jobs. The
+// attempts of the runs are bounded by the workers of the v2 engine, see
+// SetEngineV2Workers.
+type timerQueue struct {
+	lock   sync.Mutex
+	timers timerHeap
+	start  sync.Once
+	wake   chan struct{}
+}
+
+func newTimerQueue() *timerQueue {
+	return &timerQueue{wake: make(chan struct{}, 1)}
+}
+
+// afterFunc queues a timer calling f in its own goroutine once d elapsed,
+// like time.AfterFunc.
+func afterFunc(d time.Duration, f func()) *jobTimer {
+	return timers.afterFunc(d, f)
+}
+
+func (q *timerQueue) afterFunc(d time.Duration, f func()) *jobTimer {
+	q.start.Do(func() {
+		go q.run()
+	})
+
+	t := &jobTimer{at: time.Now().Add(d), f: f, queue: q}
+	q.lock.Lock()
+	heap.Push(&q.timers, t)
+	first := t.index == 0
+	q.lock.Unlock()
+
+	if first {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return t
+}
+
+// Len returns the number of queued timers.
+func (q *timerQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.timers)
+}
+
+func (q *timerQueue) run() {
+	for {
+		wait := q.fire(time.Now())
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-q.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// fire fires the timers due at now, and returns how long until the next one
+// is due.
+func (q *timerQueue) fire(now time.Time) time.Duration {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.timers) > 0 && !q.timers[0].at.After(now) {
+		t := heap.Pop(&q.timers).(*jobTimer)
+		go t.f()
+	}
+	if len(q.timers) == 0 {
+		return idleWait
+	}
+	return q.timers[0].at.Sub(now)
+}
+
+// timerHeap is a min-heap of timers by when they are due.
+type timerHeap []*jobTimer
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, k int) bool { return h[i].at.Before(h[k].at) }
+
+func (h timerHeap) Swap(i, k int) {
+	h[i], h[k] = h[k], h[i]
+	h[i].index = i
+	h[k].index = k
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*jobTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,79 @@
+package job
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimerQueueFiresInOrder(t *testing.T) {
+	q := newTimerQueue()
+	var lock sync.Mutex
+	fired := []int{}
+	done := make(chan struct{})
+	for _, i := range []int{3, 1, 2} {
+		i := i
+		q.afterFunc(time.Duration(i)*50*time.Millisecond, func() {
+			lock.Lock()
+			defer lock.Unlock()
+			fired = append(fired, i)
+			if len(fired) == 3 {
+				close(done)
+			}
+		})
+	}
+	assert.Equal(t, 3, q.Len())
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("The timers didn't fire")
+	}
+	assert.Equal(t, []int{1, 2, 3}, fired)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestTimerQueueStop(t *testing.T) {
+	q := newTimerQueue()
+	fired := make(chan bool, 2)
+	stopped := q.afterFunc(50*time.Millisecond, func() { fired <- false })
+	q.afterFunc(100*time.Millisecond, func() { fired <- true })
+
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+	assert.True(t, <-fired)
+	select {
+	case <-fired:
+		t.Fatal("The stopped timer fired")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTimerQueueWakesForEarlierTimers(t *testing.T) {
+	q := newTimerQueue()
+	late := q.afterFunc(time.Hour, func() {})
+	defer late.Stop()
+
+	// The queue sleeps until the timer due in an hour, and must wake up for
+	// the earlier one.
+	time.Sleep(10 * time.Millisecond)
+	fired := make(chan struct{})
+	start := time.Now()
+	q.afterFunc(50*time.Millisecond, func() { close(fired) })
+	select {
+	case <-fired:
+		assert.WithinDuration(t, start.Add(50*time.Millisecond), time.Now(), 500*time.Millisecond)
+	case <-time.After(2 * time.Second):
+		t.Fatal("The earlier timer didn't fire")
+	}
+}
+
+func BenchmarkTimerQueue(b *testing.B) {
+	q := newTimerQueue()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		q.afterFunc(time.Hour+time.Duration(i), func() {}).Stop()
+	}
+}
@@ -0,0 +1,79 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrNoMessageSubscriber = errors.New("Job has a trigger subject, but no message broker is configured")
+
+// MessageSubscriber subscribes message-triggered jobs to the subject of their messages.
+type MessageSubscriber interface {
+	// Subscribe calls handler with every message on the subject, until unsubscribed.
+	Subscribe(subject string, handler func(data []byte)) (unsubscribe func() error, err error)
+}
+
+var (
+	subscriberLock    sync.RWMutex
+	messageSubscriber MessageSubscriber
+)
+
+// SetMessageSubscriber sets the MessageSubscriber of message-triggered jobs.
+func SetMessageSubscriber(s MessageSubscriber) {
+	subscriberLock.Lock()
+	defer subscriberLock.Unlock()
+	messageSubscriber = s
+}
+
+func getMessageSubscriber() MessageSubscriber {
+	subscriberLock.RLock()
+	defer subscriberLock.RUnlock()
+	return messageSubscriber
+}
+
+// subscribe runs the job for every message on its TriggerSubject.
+// Callers must hold the job's lock.
+func (j *Job) subscribe(cache JobCache) error {
+	subscriber := getMessageSubscriber()
+	if subscriber == nil {
+		return ErrNoMessageSubscriber
+	}
+
+	subject := j.TriggerSubject
+	unsubscribe, err := subscriber.Subscribe(subject, func(data []byte) {
+		j.lock.RLock()
+		disabled := j.Disabled
+		j.lock.RUnlock()
+		if disabled {
+			return
+		}
+		j.RunWithContext(WithTriggerMessage(context.Background(), subject, data), cache)
+	})
+	if err != nil {
+		return err
+	}
+	j.unsubscribeTrigger = unsubscribe
+	return nil
+}
+
+// unsubscribe stops running the job for messages.
+func (j *Job) unsubscribe() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.unsubscribeTrigger == nil {
+		return
+	}
+	if err := j.unsubscribeTrigger(); err != nil {
+		runnerLog.WithField("job_id", j.Id).Errorf("Error unsubscribing from %s: %s", j.TriggerSubject, err)
+	}
+	j.unsubscribeTrigger = nil
+}
+
+// Subscribe subscribes a message-triggered job loaded from the db.
+func (j *Job) Subscribe(cache JobCache) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.subscribe(cache)
+}
@@ -0,0 +1,89 @@
+package job
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSubscriber struct {
+	lock     sync.Mutex
+	handlers map[string]func([]byte)
+	err      error
+}
+
+func (s *fakeSubscriber) Subscribe(subject string, handler func([]byte)) (func() error, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	s.handlers[subject] = handler
+	return func() error {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.handlers, subject)
+		return nil
+	}, nil
+}
+
+func (s *fakeSubscriber) publish(subject string, data []byte) {
+	s.lock.Lock()
+	handler := s.handlers[subject]
+	s.lock.Unlock()
+	if handler != nil {
+		handler(data)
+	}
+}
+
+func TestMessageTriggeredJob(t *testing.T) {
+	subscriber := &fakeSubscriber{handlers: map[string]func([]byte){}}
+	SetMessageSubscriber(subscriber)
+	defer SetMessageSubscriber(nil)
+
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.Retries = 0
+	j.TriggerSubject = "orders.created"
+	// The message is passed in the environment of the command.
+	j.Command = "env"
+	assert.NoError(t, j.Init(cache))
+
+	// Not run without a message.
+	assert.Len(t, j.Stats, 0)
+
+	subscriber.publish("orders.created", []byte("order-1"))
+	assert.Len(t, j.Stats, 1)
+	assert.True(t, j.Stats[0].Success)
+	assert.Contains(t, j.Stats[0].Output, "KALA_TRIGGER_SUBJECT=orders.created\n")
+	assert.Contains(t, j.Stats[0].Output, "KALA_TRIGGER_MESSAGE=order-1\n")
+	assert.False(t, j.ShouldStartWaiting())
+
+	j.Disable()
+	subscriber.publish("orders.created", []byte("order-2"))
+	assert.Len(t, j.Stats, 1)
+
+	assert.NoError(t, cache.Delete(j.Id))
+	assert.Len(t, subscriber.handlers, 0)
+}
+
+func TestMessageTriggeredJobWithoutSubscriber(t *testing.T) {
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.TriggerSubject = "orders.created"
+	assert.Equal(t, ErrNoMessageSubscriber, j.Init(cache))
+}
+
+func TestMessageTriggeredJobSubscribeError(t *testing.T) {
+	SetMessageSubscriber(&fakeSubscriber{err: errors.New("disconnected")})
+	defer SetMessageSubscriber(nil)
+
+	cache := NewMockCache()
+	j := GetMockJob()
+	j.TriggerSubject = "orders.created"
+	assert.Error(t, j.Init(cache))
+	_, err := cache.Get(j.Id)
+	assert.Equal(t, ErrJobDoesntExist, err)
+}
@@ -0,0 +1,176 @@
+package job
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// BatchDB is implemented by JobDBs which save and delete jobs in a single
+// transaction, so that deleting a job and removing the links to it from the
+// other jobs are persisted together or not at all.
+type BatchDB interface {
+	// SaveAndDelete saves the jobs and deletes the jobs with the ids, which
+	// don't have to be in the database.
+	SaveAndDelete(ctx context.Context, jobs []*Job, ids []string) error
+}
+
+// linksLock serializes changes to the links between jobs: creating jobs with
+// parent jobs, deleting jobs and fixing broken links. It's taken before the
+// lock of any job, and the locks of the jobs are then taken one at a time.
+var linksLock sync.Mutex
+
+// jobRemover is a cache which removes a job without changing the jobs linked
+// to it, once deleteJob changed them.
+type jobRemover interface {
+	JobCache
+	remove(j *Job)
+}
+
+// deletion is what deleting a job changes: the jobs deleted, which are the
+// job and its dependent jobs left without parent jobs, and the new links of
+// the jobs linked to them.
+type deletion struct {
+	deleted []*Job
+	changed []*linkChange
+}
+
+type linkChange struct {
+	job        *Job
+	parents    []string
+	dependents []string
+}
+
+// planDeletion returns what deleting the job changes, without changing it.
+// linksLock must be held.
+func planDeletion(cache JobCache, j *Job) *deletion {
+	d := &deletion{}
+	deleting := map[string]bool{j.Id: true}
+	changes := map[string]*linkChange{}
+	change := func(linked *Job) *linkChange {
+		c, ok := changes[linked.Id]
+		if !ok {
+			linked.lock.RLock()
+			c = &linkChange{
+				job:        linked,
+				parents:    append([]string(nil), linked.ParentJobs...),
+				dependents: append([]string(nil), linked.DependentJobs...),
+			}
+			linked.lock.RUnlock()
+			changes[linked.Id] = c
+		}
+		return c
+	}
+
+	for queue := []*Job{j}; len(queue) != 0; queue = queue[1:] {
+		deleted := queue[0]
+		d.deleted = append(d.deleted, deleted)
+		deleted.lock.RLock()
+		parents := append([]string(nil), deleted.ParentJobs...)
+		dependents := append([]string(nil), deleted.DependentJobs...)
+		deleted.lock.RUnlock()
+
+		for _, id := range parents {
+			if parent, _ := cache.Get(id); parent != nil && !deleting[id] {
+				c := change(parent)
+				c.dependents = withoutId(c.dependents, deleted.Id)
+			}
+		}
+		for _, id := range dependents {
+			child, _ := cache.Get(id)
+			if child == nil || deleting[id] {
+				continue
+			}
+			c := change(child)
+			c.parents = withoutId(c.parents, deleted.Id)
+			// Dependent jobs are deleted with their last parent job.
+			if len(c.parents) == 0 {
+				deleting[id] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	for id, c := range changes {
+		if !deleting[id] {
+			d.changed = append(d.changed, c)
+		}
+	}
+	sort.Slice(d.changed, func(i, k int) bool { return d.changed[i].job.Id < d.changed[k].job.Id })
+	return d
+}
+
+// persist saves the jobs with their new links and deletes the deleted jobs.
+func (d *deletion) persist(ctx context.Context, db JobDB) error {
+	jobs := make([]*Job, len(d.changed))
+	for i, c := range d.changed {
+		jobs[i] = c.job.Copy()
+		jobs[i].ParentJobs, jobs[i].DependentJobs = c.parents, c.dependents
+	}
+	ids := make([]string, len(d.deleted))
+	for i, j := range d.deleted {
+		ids[i] = j.Id
+	}
+
+	return saveAndDelete(ctx, db, jobs, ids)
+}
+
+// saveAndDelete saves the jobs and deletes the jobs with the ids, in a single
+// transaction if the db is a BatchDB.
+func saveAndDelete(ctx context.Context, db JobDB, jobs []*Job, ids []string) error {
+	if batch, ok := UnwrapDB(db).(BatchDB); ok {
+		return batch.SaveAndDelete(ctx, jobs, ids)
+	}
+	if len(jobs) != 0 {
+		if err := db.SaveAll(ctx, jobs); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		// Jobs which haven't been persisted yet aren't in the db.
+		if err := db.Delete(ctx, id); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply changes the links of the jobs and removes the deleted jobs from the
+// cache.
+func (d *deletion) apply(cache jobRemover) {
+	for _, c := range d.changed {
+		c.job.lock.Lock()
+		c.job.ParentJobs, c.job.DependentJobs = c.parents, c.dependents
+		c.job.changed()
+		c.job.lock.Unlock()
+	}
+	for _, j := range d.deleted {
+		cache.remove(j)
+	}
+}
+
+// deleteJob deletes the job with the id from the cache, with its dependent
+// jobs left without parent jobs, and removes the links to them from the other
+// jobs. If db isn't nil, the changes are persisted first, and nothing is
+// changed if persisting them fails.
+func deleteJob(ctx context.Context, cache jobRemover, id string, db JobDB) error {
+	linksLock.Lock()
+	defer linksLock.Unlock()
+	return deleteJobLocked(ctx, cache, id, db)
+}
+
+// deleteJobLocked is deleteJob for callers holding linksLock.
+func deleteJobLocked(ctx context.Context, cache jobRemover, id string, db JobDB) error {
+	j, err := cache.Get(id)
+	if err != nil {
+		return err
+	}
+	d := planDeletion(cache, j)
+	if db != nil {
+		if err := d.persist(ctx, db); err != nil {
+			return err
+		}
+	}
+	d.apply(cache)
+	return nil
+}
@@ -0,0 +1,53 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingDB struct {
+	MockDB
+}
+
+func (db *failingDB) SaveAll(ctx context.Context, jobs []*Job) error {
+	return errors.New("The db is down")
+}
+
+func TestDeleteCycle(t *testing.T) {
+	cache := NewMockCache()
+	for _, id := range []string{"a", "b"} {
+		j := GetMockJob()
+		j.Id = id
+		assert.NoError(t, cache.Set(j))
+	}
+	a, _ := cache.Get("a")
+	b, _ := cache.Get("b")
+	a.ParentJobs, a.DependentJobs = []string{"b"}, []string{"b"}
+	b.ParentJobs, b.DependentJobs = []string{"a"}, []string{"a"}
+
+	assert.NoError(t, cache.Delete("a"))
+	assert.Empty(t, cache.GetAll().Jobs)
+}
+
+func TestDeleteFailingToPersistChangesNothing(t *testing.T) {
+	cache := NewMockCache()
+	parent := GetMockJobWithGenericSchedule()
+	assert.NoError(t, parent.Init(cache))
+	otherParent := GetMockJobWithGenericSchedule()
+	assert.NoError(t, otherParent.Init(cache))
+	child := GetMockJob()
+	child.ParentJobs = []string{parent.Id, otherParent.Id}
+	assert.NoError(t, child.Init(cache))
+
+	assert.Error(t, parent.Delete(cache, &failingDB{}))
+	assert.Len(t, cache.GetAll().Jobs, 3)
+	assert.Equal(t, []string{parent.Id, otherParent.Id}, child.ParentJobs)
+
+	assert.NoError(t, parent.Delete(cache, &MockDB{}))
+	assert.Len(t, cache.GetAll().Jobs, 2)
+	assert.Equal(t, []string{otherParent.Id}, child.ParentJobs)
+	assert.Equal(t, []string{child.Id}, otherParent.DependentJobs)
+}
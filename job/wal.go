@@ -0,0 +1,442 @@
+package job
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	walSave   = "save"
+	walDelete = "delete"
+
+	walSuffix = ".wal"
+)
+
+// Changes which failed to be written are retried after walRetryMin, doubling
+// up to walRetryMax while writes fail.
+var (
+	walRetryMin = 100 * time.Millisecond
+	walRetryMax = 30 * time.Second
+)
+
+// walRecord is a line of the WAL: the job after a change, or the id of a
+// deleted job.
+type walRecord struct {
+	Op  string          `json:"op"`
+	Id  string          `json:"id"`
+	Job json.RawMessage `json:"job,omitempty"`
+}
+
+// WAL is an append-only log of changes to jobs, such as new jobs, runs and
+// deletions, which is replayed when Kala starts, so that changes made since
+// jobs were last persisted aren't lost if Kala crashes.
+//
+// Changes are written in the background right after they're made, with every
+// changed job written once per write and the log synced to disk after each
+// write. The log is made of segments, which are removed once the jobs are
+// persisted.
+type WAL struct {
+	dir   string
+	cache JobCache
+
+	// Guards pending, which is changed while jobs are locked.
+	pendingLock sync.Mutex
+	pending     map[string]struct{}
+
+	// Guards the segment being written. Never held while changing jobs.
+	lock    sync.Mutex
+	file    walFile
+	segment int
+	// Set when a write failed, as the segment may end with a partial record
+	// then, which Replay stops at: the next write starts a new segment.
+	torn bool
+
+	// Signals the writer that changes are pending.
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// walFile is the segment being written, replaced in tests.
+type walFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// activeWAL is the WAL changes are written to, once a cache started it.
+var activeWAL atomic.Value
+
+func currentWAL() *WAL {
+	w, _ := activeWAL.Load().(*WAL)
+	return w
+}
+
+// walChanged records a change of a job in the WAL, if there is one.
+func walChanged(id string) {
+	if w := currentWAL(); w != nil {
+		w.changed(id)
+	}
+}
+
+// OpenWAL opens the WAL in the directory, creating it if it doesn't exist.
+// Give it to a cache with SetWAL before starting the cache.
+func OpenWAL(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		dir:     dir,
+		pending: map[string]struct{}{},
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		w.segment = segments[len(segments)-1]
+	}
+	return w, nil
+}
+
+func (w *WAL) path(segment int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%08d%s", segment, walSuffix))
+}
+
+// segments returns the numbers of the segments in the directory, in order.
+func (w *WAL) segments() ([]int, error) {
+	infos, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := []int{}
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), walSuffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(info.Name(), walSuffix))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, n)
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// Replay applies the changes in the WAL to the jobs loaded from the db, and
+// returns the changed jobs, which are marked unsaved, and the ids of deleted
+// jobs.
+func (w *WAL) Replay(jobs []*Job) ([]*Job, []string, error) {
+	byId := make(map[string]*Job, len(jobs))
+	order := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		byId[j.Id] = j
+		order = append(order, j.Id)
+	}
+	deleted := map[string]bool{}
+
+	segments, err := w.segments()
+	if err != nil {
+		return nil, nil, err
+	}
+	replayed := 0
+	for _, segment := range segments {
+		f, err := os.Open(w.path(segment))
+		if err != nil {
+			return nil, nil, err
+		}
+		r := bufio.NewReader(f)
+		for {
+			line, err := r.ReadBytes('\n')
+			if err == io.EOF && len(line) == 0 {
+				break
+			}
+			rec := walRecord{}
+			if err != nil || json.Unmarshal(line, &rec) != nil {
+				// A crash while writing leaves a partial record at the end.
+				cacheLog.Warnf("Ignoring partial record at the end of WAL segment %d", segment)
+				break
+			}
+			switch rec.Op {
+			case walSave:
+				j, err := UnmarshalJob(rec.Job)
+				if err != nil {
+					f.Close()
+					return nil, nil, err
+				}
+				j.unsaved = true
+				if _, ok := byId[rec.Id]; !ok {
+					order = append(order, rec.Id)
+				}
+				byId[rec.Id] = j
+				delete(deleted, rec.Id)
+			case walDelete:
+				if _, ok := byId[rec.Id]; ok {
+					delete(byId, rec.Id)
+					deleted[rec.Id] = true
+				}
+			}
+			replayed++
+		}
+		f.Close()
+	}
+	if replayed > 0 {
+		cacheLog.Infof("Replayed %d changes from the WAL", replayed)
+	}
+
+	result := make([]*Job, 0, len(byId))
+	for _, id := range order {
+		if j, ok := byId[id]; ok {
+			result = append(result, j)
+			delete(byId, id)
+		}
+	}
+	deletedIds := make([]string, 0, len(deleted))
+	for id := range deleted {
+		deletedIds = append(deletedIds, id)
+	}
+	sort.Strings(deletedIds)
+	return result, deletedIds, nil
+}
+
+// replayWAL applies the WAL, if there is one, to the jobs loaded from the db,
+// and deletes the jobs deleted since they were persisted from the db.
+func replayWAL(w *WAL, db JobDB, jobs []*Job) []*Job {
+	if w == nil {
+		return jobs
+	}
+	jobs, deleted, err := w.Replay(jobs)
+	if err != nil {
+		cacheLog.Fatalf("Error occured replaying the WAL: %s", err)
+	}
+	for _, id := range deleted {
+		if err := db.Delete(context.Background(), id); err != nil && err != ErrNotFound {
+			cacheLog.Errorf("Error occured deleting job %s replayed from the WAL: %s", id, err)
+		}
+	}
+	return jobs
+}
+
+// start starts writing the changes of the jobs in the cache to a new segment.
+// The replayed segments are removed by the next checkpoint.
+func (w *WAL) start(cache JobCache) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.cache = cache
+	if err := w.openSegment(w.segment + 1); err != nil {
+		return err
+	}
+	activeWAL.Store(w)
+	go w.write()
+	return nil
+}
+
+func (w *WAL) openSegment(segment int) error {
+	f, err := os.OpenFile(w.path(segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.segment = segment
+	return nil
+}
+
+// changed records that the job with the id changed or was deleted.
+func (w *WAL) changed(id string) {
+	w.pendingLock.Lock()
+	w.pending[id] = struct{}{}
+	w.pendingLock.Unlock()
+	w.signal()
+}
+
+func (w *WAL) write() {
+	defer close(w.done)
+	backoff := walRetryMin
+	for {
+		select {
+		case <-w.notify:
+			w.lock.Lock()
+			err := w.flush()
+			w.lock.Unlock()
+			if err == nil {
+				backoff = walRetryMin
+				continue
+			}
+			// The changes are retried even if nothing else changes.
+			cacheLog.Errorf("Error occured writing the WAL, retrying in %s: %s", backoff, err)
+			time.AfterFunc(backoff, w.signal)
+			if backoff *= 2; backoff > walRetryMax {
+				backoff = walRetryMax
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// signal tells the writer that changes are pending.
+func (w *WAL) signal() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// flush writes the pending changes and syncs the segment. The changes stay
+// pending if they couldn't be written, to be written by the next flush to a
+// new segment. The lock must be held.
+func (w *WAL) flush() error {
+	if w.file == nil {
+		return nil
+	}
+	w.pendingLock.Lock()
+	pending := w.pending
+	w.pending = map[string]struct{}{}
+	w.pendingLock.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := w.untear()
+	if err == nil {
+		err = w.writeChanges(pending)
+	}
+	if err != nil {
+		w.torn = true
+		w.pendingLock.Lock()
+		for id := range pending {
+			w.pending[id] = struct{}{}
+		}
+		w.pendingLock.Unlock()
+	}
+	return err
+}
+
+// untear starts a new segment if a write to the segment failed, so that the
+// records written next aren't behind a partial record. The lock must be
+// held.
+func (w *WAL) untear() error {
+	if !w.torn {
+		return nil
+	}
+	// The segment is left as it is, its changes are written again.
+	w.file.Close()
+	if err := w.openSegment(w.segment + 1); err != nil {
+		return err
+	}
+	w.torn = false
+	return nil
+}
+
+// writeChanges writes the jobs with the ids as they are now, or their
+// deletion, and syncs the segment.
+func (w *WAL) writeChanges(pending map[string]struct{}) error {
+	buf := bufio.NewWriter(w.file)
+	enc := json.NewEncoder(buf)
+	for id := range pending {
+		rec := walRecord{Op: walDelete, Id: id}
+		if j, err := w.cache.Get(id); err == nil && j != nil {
+			b, err := MarshalJob(j)
+			if err != nil {
+				return err
+			}
+			rec = walRecord{Op: walSave, Id: id, Job: b}
+		}
+		if err := enc.Encode(&rec); err != nil {
+			return err
+		}
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// rotate writes the pending changes and starts a new segment. It returns the
+// last segment with changes made before it was called, and false if the WAL
+// isn't being written, e.g. before it's replayed.
+func (w *WAL) rotate() (int, bool, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return 0, false, nil
+	}
+	if err := w.flush(); err != nil {
+		return 0, false, err
+	}
+	last := w.segment
+	if err := w.file.Close(); err != nil {
+		return 0, false, err
+	}
+	return last, true, w.openSegment(last + 1)
+}
+
+// truncate removes the segments up to and including the segment.
+func (w *WAL) truncate(upTo int) error {
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment > upTo {
+			break
+		}
+		if err := os.Remove(w.path(segment)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkpoint persists the jobs with save, and removes the segments with the
+// changes made before, once they're saved. Without a WAL, it only saves.
+func (w *WAL) checkpoint(save func() error) error {
+	if w == nil {
+		return save()
+	}
+	last, started, err := w.rotate()
+	if err != nil {
+		return err
+	}
+	if err := save(); err != nil || !started {
+		return err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.truncate(last)
+}
+
+// Close writes the pending changes and stops writing the WAL.
+func (w *WAL) Close() error {
+	if currentWAL() == w {
+		activeWAL.Store((*WAL)(nil))
+		close(w.stop)
+		<-w.done
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	return err
+}
@@ -0,0 +1,187 @@
+package job
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWAL(t *testing.T) (*WAL, string) {
+	dir, err := ioutil.TempDir("", "kala-wal")
+	assert.NoError(t, err)
+	w, err := OpenWAL(dir)
+	assert.NoError(t, err)
+	return w, dir
+}
+
+func TestWALReplay(t *testing.T) {
+	w, dir := newTestWAL(t)
+	defer os.RemoveAll(dir)
+
+	// Jobs persisted before the WAL was started.
+	persistedB, persistedC := GetMockJob(), GetMockJob()
+	persistedB.Id, persistedC.Id = "b", "c"
+
+	cache := NewMemoryJobCache(&MockDB{})
+	cache.Set(persistedB)
+	assert.NoError(t, w.start(cache))
+	a := GetMockJob()
+	a.Id = "a"
+	cache.Set(a)
+	a.Disable()
+	assert.NoError(t, cache.Delete("b"))
+	assert.NoError(t, w.Close())
+
+	// Nothing is written once the WAL is closed.
+	c := GetMockJob()
+	c.Id = "c"
+	cache.Set(c)
+
+	w, err := OpenWAL(dir)
+	assert.NoError(t, err)
+	jobs, deleted, err := w.Replay([]*Job{persistedB, persistedC})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, deleted)
+	if assert.Len(t, jobs, 2) {
+		assert.True(t, jobs[0] == persistedC)
+		assert.False(t, jobs[0].unsaved)
+		assert.Equal(t, "a", jobs[1].Id)
+		assert.True(t, jobs[1].Disabled)
+		assert.True(t, jobs[1].unsaved)
+	}
+}
+
+func TestWALIgnoresPartialRecords(t *testing.T) {
+	w, dir := newTestWAL(t)
+	defer os.RemoveAll(dir)
+
+	cache := NewMemoryJobCache(&MockDB{})
+	assert.NoError(t, w.start(cache))
+	a := GetMockJob()
+	a.Id = "a"
+	cache.Set(a)
+	assert.NoError(t, w.Close())
+
+	f, err := os.OpenFile(w.path(w.segment), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	f.WriteString(`{"op":"save","id":"b","jo`)
+	f.Close()
+
+	w, err = OpenWAL(dir)
+	assert.NoError(t, err)
+	jobs, _, err := w.Replay(nil)
+	assert.NoError(t, err)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, "a", jobs[0].Id)
+	}
+}
+
+// tornWALFile writes half of the first write to the segment, and fails it.
+type tornWALFile struct {
+	walFile
+	torn bool
+}
+
+func (f *tornWALFile) Write(p []byte) (int, error) {
+	if f.torn {
+		return f.walFile.Write(p)
+	}
+	f.torn = true
+	n, _ := f.walFile.Write(p[:len(p)/2])
+	return n, errors.New("disk is full")
+}
+
+func TestWALRetriesChangesWhichFailedToBeWritten(t *testing.T) {
+	w, dir := newTestWAL(t)
+	defer os.RemoveAll(dir)
+	defer func(min time.Duration) { walRetryMin = min }(walRetryMin)
+	walRetryMin = time.Millisecond
+
+	cache := NewMemoryJobCache(&MockDB{})
+	assert.NoError(t, w.start(cache))
+	a := GetMockJob()
+	a.Id = "a"
+	cache.Set(a)
+
+	w.lock.Lock()
+	assert.NoError(t, w.flush())
+	torn := w.segment
+	w.file = &tornWALFile{walFile: w.file}
+	w.lock.Unlock()
+	b := GetMockJob()
+	b.Id = "b"
+	cache.Set(b)
+
+	// The change is written to a new segment, without another change.
+	retried := false
+	for i := 0; i < 500 && !retried; i++ {
+		time.Sleep(time.Millisecond * 10)
+		w.lock.Lock()
+		w.pendingLock.Lock()
+		retried = w.segment > torn && len(w.pending) == 0
+		w.pendingLock.Unlock()
+		w.lock.Unlock()
+	}
+	assert.True(t, retried)
+
+	c := GetMockJob()
+	c.Id = "c"
+	cache.Set(c)
+	assert.NoError(t, w.Close())
+
+	w, err := OpenWAL(dir)
+	assert.NoError(t, err)
+	jobs, _, err := w.Replay(nil)
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, j := range jobs {
+		ids = append(ids, j.Id)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, ids)
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	w, dir := newTestWAL(t)
+	defer os.RemoveAll(dir)
+
+	// Before the WAL is started, nothing is removed.
+	assert.NoError(t, ioutil.WriteFile(w.path(1), []byte{}, 0644))
+	w, err := OpenWAL(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, w.checkpoint(func() error { return nil }))
+	segments, err := w.segments()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1}, segments)
+
+	cache := NewMemoryJobCache(&MockDB{})
+	assert.NoError(t, w.start(cache))
+	defer w.Close()
+	a := GetMockJob()
+	a.Id = "a"
+	cache.Set(a)
+
+	// Segments are kept until the jobs are saved.
+	assert.Error(t, w.checkpoint(func() error { return errors.New("db is down") }))
+	segments, err = w.segments()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, segments)
+
+	assert.NoError(t, w.checkpoint(func() error { return nil }))
+	segments, err = w.segments()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{4}, segments)
+}
+
+func TestNilWALCheckpoint(t *testing.T) {
+	var w *WAL
+	saved := false
+	assert.NoError(t, w.checkpoint(func() error {
+		saved = true
+		return nil
+	}))
+	assert.True(t, saved)
+}
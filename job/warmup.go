@@ -0,0 +1,135 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	// LoadPageSize is the number of jobs loaded at once from PagedDBs when the
+	// cache starts.
+	LoadPageSize = 500
+
+	// Bounds of the backoff between attempts to load jobs from an unavailable
+	// db.
+	loadRetryMin = time.Second
+	loadRetryMax = time.Minute
+)
+
+// PagedDB is implemented by JobDBs which can load jobs a page at a time, so
+// that caches start running jobs before all of a large set is loaded.
+type PagedDB interface {
+	// GetPage returns up to limit jobs with ids after the given id, ordered by
+	// id. after is empty for the first page.
+	GetPage(ctx context.Context, after string, limit int) ([]*Job, error)
+}
+
+// WarmUpStatus is the progress of loading the jobs from the db when the cache
+// starts.
+type WarmUpStatus struct {
+	Ready  bool `json:"ready"`
+	Loaded int  `json:"loaded"`
+	Pages  int  `json:"pages"`
+
+	// Failed attempts to load a page, and the error of the last one.
+	FailedAttempts int    `json:"failed_attempts"`
+	LastError      string `json:"last_error,omitempty"`
+
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	ReadyAt   *time.Time `json:"ready_at,omitempty"`
+}
+
+// warmUp loads the jobs of a cache, and tracks its progress.
+type warmUp struct {
+	lock   sync.RWMutex
+	status WarmUpStatus
+}
+
+func (w *warmUp) Status() WarmUpStatus {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	return w.status
+}
+
+func (w *warmUp) update(f func(s *WarmUpStatus)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	f(&w.status)
+}
+
+// load loads the jobs from the db, a page at a time if it's a PagedDB, and
+// adds each page to the cache with add. While the db is unavailable, it
+// retries with backoff rather than giving up. With a WAL, the WAL is replayed
+// once all jobs are loaded, so the jobs are only added then.
+func (w *warmUp) load(db JobDB, wal *WAL, add func(jobs []*Job)) {
+	now := time.Now()
+	w.update(func(s *WarmUpStatus) { s.StartedAt = &now })
+
+	loaded := []*Job{}
+	after := ""
+	backoff := loadRetryMin
+	for {
+		page, done, err := loadPage(db, after)
+		if err == nil {
+			attachStatStore(db, page)
+		}
+		if err != nil {
+			cacheLog.Errorf("Error occured loading jobs, retrying in %s: %s", backoff, err)
+			w.update(func(s *WarmUpStatus) {
+				s.FailedAttempts++
+				s.LastError = err.Error()
+			})
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > loadRetryMax {
+				backoff = loadRetryMax
+			}
+			continue
+		}
+		backoff = loadRetryMin
+
+		if wal != nil {
+			loaded = append(loaded, page...)
+		} else {
+			if err := saveUnsaved(db, page); err != nil {
+				cacheLog.Errorf("Error occured saving migrated jobs: %s", err)
+			}
+			add(page)
+		}
+		w.update(func(s *WarmUpStatus) {
+			s.Loaded += len(page)
+			s.Pages++
+		})
+		if done {
+			break
+		}
+		after = page[len(page)-1].Id
+	}
+
+	if wal != nil {
+		loaded = replayWAL(wal, db, loaded)
+		if err := saveUnsaved(db, loaded); err != nil {
+			cacheLog.Errorf("Error occured saving migrated jobs: %s", err)
+		}
+		add(loaded)
+	}
+
+	now = time.Now()
+	w.update(func(s *WarmUpStatus) {
+		s.Ready = true
+		s.ReadyAt = &now
+	})
+	cacheLog.Infof("Loaded %d jobs", w.Status().Loaded)
+}
+
+// loadPage returns the page of jobs after the id, and whether it's the last
+// page. JobDBs which aren't PagedDBs load all jobs as a single page.
+func loadPage(db JobDB, after string) ([]*Job, bool, error) {
+	paged, ok := UnwrapDB(db).(PagedDB)
+	if !ok {
+		jobs, err := db.GetAll(context.Background())
+		return jobs, true, err
+	}
+	jobs, err := paged.GetPage(context.Background(), after, LoadPageSize)
+	return jobs, len(jobs) < LoadPageSize, err
+}
@@ -0,0 +1,120 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pagedDB serves its jobs, sorted by id, a page at a time. It fails the first
+// failures calls, and waits for release if it's set.
+type pagedDB struct {
+	MockDB
+	jobs []*Job
+
+	lock     sync.Mutex
+	failures int
+	calls    int
+	release  chan struct{}
+}
+
+func newPagedDB(n int) *pagedDB {
+	db := &pagedDB{}
+	for i := 0; i < n; i++ {
+		j := GetMockJob()
+		j.Id = fmt.Sprintf("job-%02d", i)
+		db.jobs = append(db.jobs, j)
+	}
+	return db
+}
+
+func (db *pagedDB) GetPage(ctx context.Context, after string, limit int) ([]*Job, error) {
+	if db.release != nil {
+		<-db.release
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.calls++
+	if db.failures > 0 {
+		db.failures--
+		return nil, errors.New("connection refused")
+	}
+	page := []*Job{}
+	for _, j := range db.jobs {
+		if j.Id > after && len(page) < limit {
+			page = append(page, j)
+		}
+	}
+	return page, nil
+}
+
+func withLoadSettings(pageSize int, f func()) {
+	oldSize, oldMin := LoadPageSize, loadRetryMin
+	LoadPageSize, loadRetryMin = pageSize, time.Millisecond
+	defer func() { LoadPageSize, loadRetryMin = oldSize, oldMin }()
+	f()
+}
+
+func TestWarmUpLoadsPages(t *testing.T) {
+	withLoadSettings(2, func() {
+		db := newPagedDB(5)
+		cache := NewMemoryJobCache(db)
+		cache.Start(time.Hour)
+
+		status := cache.WarmUpStatus()
+		assert.True(t, status.Ready)
+		assert.Equal(t, 5, status.Loaded)
+		assert.Equal(t, 3, status.Pages)
+		assert.NotNil(t, status.ReadyAt)
+		assert.Len(t, cache.GetAll().Jobs, 5)
+	})
+}
+
+func TestWarmUpRetriesWithBackoff(t *testing.T) {
+	withLoadSettings(10, func() {
+		db := newPagedDB(3)
+		db.failures = 2
+		cache := NewMemoryJobCache(db)
+		cache.Start(time.Hour)
+
+		status := cache.WarmUpStatus()
+		assert.True(t, status.Ready)
+		assert.Equal(t, 2, status.FailedAttempts)
+		assert.Equal(t, "connection refused", status.LastError)
+		assert.Equal(t, 3, db.calls)
+		assert.Len(t, cache.GetAll().Jobs, 3)
+	})
+}
+
+func TestWarmUpLazyLoad(t *testing.T) {
+	withLoadSettings(10, func() {
+		db := newPagedDB(3)
+		db.release = make(chan struct{})
+		cache := NewMemoryJobCache(db)
+		cache.SetLazyLoad(true)
+		cache.Start(time.Hour)
+
+		assert.False(t, cache.WarmUpStatus().Ready)
+
+		// A job created while loading isn't replaced by the loaded job.
+		created := GetMockJob()
+		created.Id = "job-01"
+		created.Name = "created"
+		assert.NoError(t, cache.Set(created))
+
+		close(db.release)
+		for i := 0; i < 100 && !cache.WarmUpStatus().Ready; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.True(t, cache.WarmUpStatus().Ready)
+		j, err := cache.Get("job-01")
+		assert.NoError(t, err)
+		assert.Equal(t, "created", j.Name)
+		assert.Len(t, cache.GetAll().Jobs, 3)
+	})
+}
@@ -0,0 +1,111 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNoWindows       = errors.New("Only jobs with a repeating schedule have windows")
+	ErrInvalidBackfill = errors.New("The range of a backfill should hold at least one window of the job, and at most MaxBackfillWindows")
+)
+
+// Most windows a backfill may run the job for.
+var MaxBackfillWindows = 1000
+
+// Window is an interval of the schedule of a job, from one of its runs to
+// the next. A run processes the window which ended when it was due, e.g. the
+// previous day for a daily job.
+type Window struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// windowIndex returns the index of the last time of the schedule at or before
+// t, counting from the start of the schedule.
+func windowIndex(start time.Time, interval time.Duration, t time.Time) int64 {
+	d := t.Sub(start)
+	k := int64(d / interval)
+	if d%interval < 0 {
+		k--
+	}
+	return k
+}
+
+// window returns the window of a run of the job due at the time: the interval
+// of its schedule which ended last at or before the time. As runs are only
+// ever late, the window is the one which ended when they were due. Jobs
+// without a repeating schedule have an empty window at the time. The lock
+// must be held.
+func (j *Job) window(at time.Time) Window {
+	if j.Schedule == "" || j.delayDuration == nil || j.delayDuration.ToDuration() <= 0 {
+		return Window{Start: at, End: at}
+	}
+	interval := j.delayDuration.ToDuration()
+	end := j.scheduleTime.Add(time.Duration(windowIndex(j.scheduleTime, interval, at)) * interval)
+	return Window{Start: end.Add(-interval), End: end}
+}
+
+// BackfillWindows returns the windows of the schedule of the job within
+// [from, to), the oldest first.
+func (j *Job) BackfillWindows(from, to time.Time) ([]Window, error) {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+
+	if j.Schedule == "" || j.delayDuration == nil || j.delayDuration.ToDuration() <= 0 {
+		return nil, ErrNoWindows
+	}
+	interval := j.delayDuration.ToDuration()
+	// The first window starts at the first time of the schedule at or after
+	// from.
+	k := windowIndex(j.scheduleTime, interval, from)
+	if start := j.scheduleTime.Add(time.Duration(k) * interval); start.Before(from) {
+		k++
+	}
+	windows := []Window{}
+	for {
+		start := j.scheduleTime.Add(time.Duration(k) * interval)
+		end := start.Add(interval)
+		if end.After(to) {
+			break
+		}
+		if len(windows) == MaxBackfillWindows {
+			return nil, ErrInvalidBackfill
+		}
+		windows = append(windows, Window{Start: start, End: end})
+		k++
+	}
+	if len(windows) == 0 {
+		return nil, ErrInvalidBackfill
+	}
+	return windows, nil
+}
+
+// Backfill runs the job for every window, one after the other, as if each
+// run was due at the end of its window. Runs wait for the runs per minute of
+// the namespace and owner of the job. It stops once ctx is done, Kala shuts
+// down or the job is deleted. Like manual starts, the runs schedule the next
+// run of the job anew.
+func (j *Job) Backfill(ctx context.Context, cache JobCache, windows []Window) {
+	logger := runnerLog.WithField("job_id", j.Id)
+	for i, w := range windows {
+		if wait := GetNamespaces().RunQuotaWait(j, time.Now()); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil || draining(cache) {
+			logger.Infof("Stopped the backfill of job %s after %d of %d windows", j.Id, i, len(windows))
+			return
+		}
+		if found, err := cache.Get(j.Id); err != nil || found != j {
+			logger.Infof("Stopped the backfill of job %s after %d of %d windows, as it was deleted or replaced", j.Id, i, len(windows))
+			return
+		}
+		j.StopTimer()
+		j.RunWithContext(withScheduledAt(ctx, w.End), cache)
+	}
+	logger.Infof("Backfilled %d windows of job %s", len(windows), j.Id)
+}
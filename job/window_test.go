@@ -0,0 +1,82 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+	start := time.Date(2017, 6, 4, 2, 0, 0, 0, time.UTC)
+	j := GetMockRecurringJobWithSchedule(start, "P1D")
+	assert.NoError(t, j.InitDelayDuration(false))
+	day := 24 * time.Hour
+
+	// A late run processes the window which ended when it was due.
+	assert.Equal(t, Window{start.Add(day), start.Add(2 * day)}, utcWindow(j.window(start.Add(2*day+5*time.Second))))
+	assert.Equal(t, Window{start, start.Add(day)}, utcWindow(j.window(start.Add(day))))
+	assert.Equal(t, Window{start.Add(-2 * day), start.Add(-day)}, utcWindow(j.window(start.Add(-time.Hour))))
+
+	// Jobs without a repeating schedule have an empty window.
+	at := time.Now()
+	assert.Equal(t, Window{at, at}, GetMockJob().window(at))
+
+	runner := &JobRunner{job: j, ctx: withScheduledAt(context.Background(), start.Add(3*day))}
+	runner.runSetup()
+	c := runner.templateContext()
+	assert.True(t, start.Add(2*day).Equal(c.WindowStart))
+	assert.True(t, start.Add(3*day).Equal(c.WindowEnd))
+}
+
+func TestBackfillWindows(t *testing.T) {
+	start := time.Date(2017, 6, 4, 2, 0, 0, 0, time.UTC)
+	j := GetMockRecurringJobWithSchedule(start, "P1D")
+	assert.NoError(t, j.InitDelayDuration(false))
+	day := 24 * time.Hour
+
+	windows, err := j.BackfillWindows(start.Add(time.Hour), start.Add(3*day+time.Hour))
+	if assert.NoError(t, err) && assert.Equal(t, 2, len(windows)) {
+		assert.Equal(t, Window{start.Add(day), start.Add(2 * day)}, utcWindow(windows[0]))
+		assert.Equal(t, Window{start.Add(2 * day), start.Add(3 * day)}, utcWindow(windows[1]))
+	}
+	windows, err = j.BackfillWindows(start.Add(-day), start.Add(day))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(windows))
+
+	_, err = j.BackfillWindows(start.Add(time.Hour), start.Add(day))
+	assert.Equal(t, ErrInvalidBackfill, err)
+	_, err = j.BackfillWindows(start, start.Add(time.Duration(MaxBackfillWindows+1)*day))
+	assert.Equal(t, ErrInvalidBackfill, err)
+	_, err = GetMockJob().BackfillWindows(start, start.Add(day))
+	assert.Equal(t, ErrNoWindows, err)
+}
+
+func TestBackfill(t *testing.T) {
+	cache := NewMockCache()
+	j, stop, err := GetMockJobRunByTest(cache, nil)
+	assert.NoError(t, err)
+	defer stop()
+
+	windows, err := j.BackfillWindows(time.Now().Add(-4*time.Hour), time.Now().Add(-time.Hour))
+	if !assert.NoError(t, err) {
+		return
+	}
+	j.Backfill(context.Background(), cache, windows)
+	if assert.Equal(t, len(windows), len(j.Stats)) {
+		for i, w := range windows {
+			assert.True(t, w.End.Equal(j.Stats[i].ScheduledAt))
+		}
+	}
+
+	// Backfills stop once the job is deleted.
+	assert.NoError(t, cache.Delete(j.Id))
+	j.Backfill(context.Background(), cache, windows)
+	assert.Equal(t, len(windows), len(j.Stats))
+}
+
+// utcWindow returns the window in UTC, to compare it with others.
+func utcWindow(w Window) Window {
+	return Window{w.Start.UTC(), w.End.UTC()}
+}
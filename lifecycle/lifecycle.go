@@ -0,0 +1,100 @@
+// Package lifecycle shuts Kala down in order, so that nothing is lost: the
+// API stops accepting changes, running jobs finish, the jobs are persisted,
+// and only then is the job database closed.
+package lifecycle
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/utils/logging"
+)
+
+var log = logging.GetLogger(logging.Lifecycle)
+
+// Stage is a step of shutting down, e.g. the Drain method of a cache.
+type Stage func(ctx context.Context) error
+
+type stage struct {
+	name    string
+	timeout time.Duration
+	run     Stage
+}
+
+// Manager runs the stages of shutting down in the order they were added, once
+// the process gets a signal or Shutdown is called.
+type Manager struct {
+	lock   sync.Mutex
+	stages []stage
+
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func New() *Manager {
+	return &Manager{done: make(chan struct{})}
+}
+
+// Add adds a stage, run after the stages added before. Its context is done
+// after the timeout, if it isn't zero.
+func (m *Manager) Add(name string, timeout time.Duration, run Stage) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.stages = append(m.stages, stage{name: name, timeout: timeout, run: run})
+}
+
+// Shutdown runs the stages, and returns the error of the first which failed.
+// Later stages still run, so that e.g. the job database is closed even if the
+// jobs couldn't all be persisted. Shutdown only runs the stages once, and
+// later calls wait for them and return the same error.
+func (m *Manager) Shutdown() error {
+	m.once.Do(func() {
+		defer close(m.done)
+		m.lock.Lock()
+		stages := m.stages
+		m.lock.Unlock()
+
+		for _, s := range stages {
+			log.Infof("Shutting down: %s", s.name)
+			if err := s.runWithTimeout(); err != nil {
+				log.Errorf("Error occured shutting down (%s): %s", s.name, err)
+				if m.err == nil {
+					m.err = err
+				}
+			}
+		}
+		log.Infof("Shut down")
+	})
+	<-m.done
+	return m.err
+}
+
+func (s stage) runWithTimeout() error {
+	ctx := context.Background()
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	return s.run(ctx)
+}
+
+// Notify shuts down once the process gets one of the signals.
+func (m *Manager) Notify(signals ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	go func() {
+		s := <-ch
+		log.Infof("Process got signal: %s", s)
+		m.Shutdown()
+	}()
+}
+
+// Done is closed once the stages ran.
+func (m *Manager) Done() <-chan struct{} {
+	return m.done
+}
@@ -0,0 +1,42 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownRunsStagesInOrder(t *testing.T) {
+	m := New()
+	ran := []string{}
+	m.Add("drain", 0, func(ctx context.Context) error {
+		ran = append(ran, "drain")
+		return nil
+	})
+	m.Add("wait", 10*time.Millisecond, func(ctx context.Context) error {
+		ran = append(ran, "wait")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	m.Add("close", 0, func(ctx context.Context) error {
+		ran = append(ran, "close")
+		return errors.New("Closing failed")
+	})
+
+	// The first error is returned, and later stages still run.
+	assert.Equal(t, context.DeadlineExceeded, m.Shutdown())
+	assert.Equal(t, []string{"drain", "wait", "close"}, ran)
+
+	select {
+	case <-m.Done():
+	default:
+		t.Fatal("Done isn't closed")
+	}
+
+	// Stages only run once.
+	assert.Equal(t, context.DeadlineExceeded, m.Shutdown())
+	assert.Len(t, ran, 3)
+}
@@ -0,0 +1,153 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/utils/awsauth"
+)
+
+var ErrNoCloudWatchRegion = errors.New("No region for the CloudWatch Logs sink. Add a region parameter to the sink URL, or set AWS_REGION")
+
+// Most events of a PutLogEvents request.
+const cloudWatchMaxEvents = 10000
+
+// CloudWatchSink puts the lines to CloudWatch Logs, in the log stream of the
+// job, named after its id, in Group. Log streams are created as needed.
+// Credentials are looked up like the AWS SDKs do, see awsauth.DefaultChain.
+type CloudWatchSink struct {
+	Group string
+
+	// Defaults to AWS_REGION.
+	Region string
+
+	// Overrides the CloudWatch Logs endpoint.
+	Endpoint string
+}
+
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// cloudWatchError is an error returned by CloudWatch Logs, e.g.
+// {"__type": "ResourceNotFoundException", "message": "..."}.
+type cloudWatchError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+	Status  string `json:"-"`
+}
+
+func (e *cloudWatchError) Error() string {
+	return fmt.Sprintf("CloudWatch Logs returned %s: %s %s", e.Status, e.Type, e.Message)
+}
+
+func (e *cloudWatchError) is(t string) bool {
+	// Types may be prefixed with a namespace, e.g. "com.amazonaws...#Type".
+	return e.Type == t || strings.HasSuffix(e.Type, "#"+t)
+}
+
+// stream returns the log stream of the job. Colons and asterisks aren't
+// allowed in stream names.
+func (s *CloudWatchSink) stream(jobId string) string {
+	return strings.NewReplacer(":", "_", "*", "_").Replace(jobId)
+}
+
+func (s *CloudWatchSink) Ship(r *Record) error {
+	events := []cloudWatchEvent{}
+	for _, l := range r.Lines {
+		events = append(events, cloudWatchEvent{
+			Timestamp: l.Time.UnixNano() / int64(time.Millisecond),
+			Message:   r.format(l),
+		})
+	}
+	stream := s.stream(r.JobId)
+	for len(events) != 0 {
+		n := len(events)
+		if n > cloudWatchMaxEvents {
+			n = cloudWatchMaxEvents
+		}
+		err := s.put(stream, events[:n])
+		if cwErr, ok := err.(*cloudWatchError); ok && cwErr.is("ResourceNotFoundException") {
+			if err = s.createStream(stream); err == nil {
+				err = s.put(stream, events[:n])
+			}
+		}
+		if err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+func (s *CloudWatchSink) put(stream string, events []cloudWatchEvent) error {
+	return s.do("PutLogEvents", map[string]interface{}{
+		"logGroupName":  s.Group,
+		"logStreamName": stream,
+		"logEvents":     events,
+	})
+}
+
+func (s *CloudWatchSink) createStream(stream string) error {
+	err := s.do("CreateLogStream", map[string]interface{}{
+		"logGroupName":  s.Group,
+		"logStreamName": stream,
+	})
+	// Another run may have created it meanwhile.
+	if cwErr, ok := err.(*cloudWatchError); ok && cwErr.is("ResourceAlreadyExistsException") {
+		return nil
+	}
+	return err
+}
+
+func (s *CloudWatchSink) do(action string, params map[string]interface{}) error {
+	region := s.Region
+	if region == "" {
+		region = awsauth.Region()
+	}
+	if region == "" {
+		return ErrNoCloudWatchRegion
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://logs." + region + ".amazonaws.com"
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+
+	creds, err := awsauth.DefaultChain.Retrieve()
+	if err != nil {
+		return err
+	}
+	awsauth.Sign(req, body, creds, region, "logs", time.Now())
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		cwErr := &cloudWatchError{Status: res.Status}
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		json.Unmarshal(msg, cwErr)
+		return cwErr
+	}
+	ioutil.ReadAll(res.Body)
+	return nil
+}
@@ -0,0 +1,66 @@
+package logsink
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudWatchSink(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	streams := map[string][]cloudWatchEvent{}
+	actions := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "/eu-west-1/logs/aws4_request")
+		var params struct {
+			LogGroupName  string
+			LogStreamName string
+			LogEvents     []cloudWatchEvent
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&params))
+		action := r.Header.Get("X-Amz-Target")
+		actions = append(actions, action)
+		if params.LogGroupName != "kala" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "The specified log group does not exist."}`))
+			return
+		}
+		events, ok := streams[params.LogStreamName]
+		switch action {
+		case "Logs_20140328.CreateLogStream":
+			streams[params.LogStreamName] = []cloudWatchEvent{}
+		case "Logs_20140328.PutLogEvents":
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type": "ResourceNotFoundException", "message": "The specified log stream does not exist."}`))
+				return
+			}
+			streams[params.LogStreamName] = append(events, params.LogEvents...)
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	sink := &CloudWatchSink{Group: "kala", Region: "eu-west-1", Endpoint: ts.URL}
+	assert.NoError(t, sink.Ship(testRecord()))
+	assert.NoError(t, sink.Ship(testRecord()))
+	assert.Equal(t, []string{"Logs_20140328.PutLogEvents", "Logs_20140328.CreateLogStream", "Logs_20140328.PutLogEvents", "Logs_20140328.PutLogEvents"}, actions)
+	if assert.Len(t, streams["id"], 4) {
+		assert.Equal(t, cloudWatchEvent{Timestamp: 1496541601000, Message: "run stderr: disk full"}, streams["id"][1])
+	}
+
+	// Missing log groups aren't created.
+	sink.Group = "missing"
+	err := sink.Ship(testRecord())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "ResourceNotFoundException")
+	}
+}
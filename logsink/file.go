@@ -0,0 +1,51 @@
+package logsink
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSink appends the output of every job to a file of its own in Dir,
+// named after the job's id, e.g. /var/log/kala/<id>.log. Every line is
+// prefixed with its time, run and stream.
+type FileSink struct {
+	Dir string
+
+	lock sync.Mutex
+}
+
+// path returns the file of the job. Ids are kept from leaving the directory.
+func (s *FileSink) path(jobId string) string {
+	return filepath.Join(s.Dir, pathSeparators.Replace(jobId)+".log")
+}
+
+var pathSeparators = strings.NewReplacer("/", "_", "\\", "_")
+
+func (s *FileSink) Ship(r *Record) error {
+	var buf bytes.Buffer
+	for _, l := range r.Lines {
+		buf.WriteString(l.Time.UTC().Format(time.RFC3339Nano))
+		buf.WriteString(" ")
+		buf.WriteString(r.format(l))
+		buf.WriteString("\n")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(r.JobId), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
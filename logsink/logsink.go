@@ -0,0 +1,273 @@
+// Package logsink ships the output captured from job runs to external log
+// sinks (syslog, a file per job, Loki or CloudWatch Logs), so that it lands
+// next to the rest of an organization's logs.
+package logsink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/utils/logging"
+)
+
+var log = logging.GetLogger(logging.Runner)
+
+var (
+	ErrUnknownSink     = errors.New("Unknown log sink URL. Sinks are syslog://host:514, syslog+tcp://host:514, file:///dir, loki://host:3100, loki+https://host or cloudwatch://group")
+	ErrFileSinkDenied  = errors.New("Jobs may only ship their output to the file sinks configured on the server")
+	ErrNoCloudWatchLog = errors.New("No log group for the CloudWatch Logs sink, e.g. cloudwatch://kala-jobs")
+)
+
+// Streams of the lines of output.
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// Line is a line of output of a run.
+type Line struct {
+	Time   time.Time
+	Stream string
+	Text   string
+}
+
+// Record is the output of a run, shipped once the run finished.
+type Record struct {
+	JobId   string
+	JobName string
+	Owner   string
+	RunId   string
+	Success bool
+	Lines   []Line
+}
+
+// Sink ships records to a log store.
+type Sink interface {
+	Ship(r *Record) error
+}
+
+// Settings are the log sinks of a job, overriding those of the server.
+type Settings struct {
+	// URLs of the sinks, replacing the server's sinks if not empty.
+	Sinks []string `json:"sinks,omitempty"`
+
+	// Don't ship the job's output at all.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// Open returns the Sink of a URL:
+//
+//	syslog://host:514, over UDP, or syslog+tcp://host:514
+//	file:///var/log/kala, appending the output of every job to <id>.log
+//	loki://host:3100 or loki+https://host, with an optional tenant parameter
+//	cloudwatch://group, with optional region and endpoint parameters
+func Open(rawurl string) (Sink, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp", "syslog+tcp":
+		if u.Host == "" {
+			return nil, ErrUnknownSink
+		}
+		network := strings.TrimPrefix(strings.TrimPrefix(u.Scheme, "syslog"), "+")
+		if network == "" {
+			network = "udp"
+		}
+		return &SyslogSink{Network: network, Addr: u.Host}, nil
+	case "file":
+		if u.Path == "" {
+			return nil, ErrUnknownSink
+		}
+		return &FileSink{Dir: u.Path}, nil
+	case "loki", "loki+http", "loki+https":
+		if u.Host == "" {
+			return nil, ErrUnknownSink
+		}
+		scheme := "http"
+		if u.Scheme == "loki+https" {
+			scheme = "https"
+		}
+		push := url.URL{Scheme: scheme, Host: u.Host, Path: strings.TrimRight(u.Path, "/") + "/loki/api/v1/push"}
+		return &LokiSink{URL: push.String(), Tenant: u.Query().Get("tenant")}, nil
+	case "cloudwatch":
+		if u.Host == "" {
+			return nil, ErrNoCloudWatchLog
+		}
+		return &CloudWatchSink{
+			Group:    u.Host + u.Path,
+			Region:   u.Query().Get("region"),
+			Endpoint: u.Query().Get("endpoint"),
+		}, nil
+	}
+	return nil, ErrUnknownSink
+}
+
+// Shipper ships the output of runs to the server's sinks, or to those of the
+// job's settings, in the background.
+type Shipper struct {
+	urls  []string
+	sinks []Sink
+
+	lock   sync.Mutex
+	opened map[string]Sink
+
+	wg sync.WaitGroup
+}
+
+// NewShipper returns a Shipper for the server's sink URLs.
+func NewShipper(urls []string) (*Shipper, error) {
+	s := &Shipper{urls: urls, opened: map[string]Sink{}}
+	for _, rawurl := range urls {
+		sink, err := Open(rawurl)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid log sink %s: %s", rawurl, err)
+		}
+		s.sinks = append(s.sinks, sink)
+		s.opened[rawurl] = sink
+	}
+	return s, nil
+}
+
+// Ships returns whether the output of runs of a job with the settings is
+// shipped anywhere.
+func (s *Shipper) Ships(settings *Settings) bool {
+	if s == nil {
+		return false
+	}
+	if settings != nil {
+		if settings.Disabled {
+			return false
+		}
+		if len(settings.Sinks) != 0 {
+			return true
+		}
+	}
+	return len(s.sinks) != 0
+}
+
+// Ship ships the record to the sinks of the job's settings in the
+// background. Errors are logged.
+func (s *Shipper) Ship(r *Record, settings *Settings) {
+	if !s.Ships(settings) {
+		return
+	}
+	sinks := s.sinks
+	if settings != nil && len(settings.Sinks) != 0 {
+		sinks = []Sink{}
+		for _, rawurl := range settings.Sinks {
+			sink, err := s.open(rawurl)
+			if err != nil {
+				log.WithField("job_id", r.JobId).Errorf("Error occured opening log sink %s: %s", rawurl, err)
+				continue
+			}
+			sinks = append(sinks, sink)
+		}
+	}
+	for _, sink := range sinks {
+		s.wg.Add(1)
+		go func(sink Sink) {
+			defer s.wg.Done()
+			if err := sink.Ship(r); err != nil {
+				log.WithField("job_id", r.JobId).Errorf("Error occured shipping the output of run %s: %s", r.RunId, err)
+			}
+		}(sink)
+	}
+}
+
+// open returns the Sink of a URL of a job's settings, sharing it between
+// jobs.
+func (s *Shipper) open(rawurl string) (Sink, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sink, ok := s.opened[rawurl]; ok {
+		return sink, nil
+	}
+	sink, err := Open(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	s.opened[rawurl] = sink
+	return sink, nil
+}
+
+// Wait waits for the records being shipped.
+func (s *Shipper) Wait() {
+	s.wg.Wait()
+}
+
+// Drain waits for the records being shipped until ctx is done, e.g. on
+// shutdown.
+func (s *Shipper) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Valid returns an error if a job's settings refer to invalid sinks. As the
+// API shouldn't write files wherever it is told, jobs may only use the file
+// sinks of the server.
+func (s *Shipper) Valid(settings *Settings) error {
+	if settings == nil {
+		return nil
+	}
+	for _, rawurl := range settings.Sinks {
+		sink, err := Open(rawurl)
+		if err != nil {
+			return err
+		}
+		if _, ok := sink.(*FileSink); ok && (s == nil || !contains(s.urls, rawurl)) {
+			return ErrFileSinkDenied
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// format formats a line of the record as text, with the run and stream it's
+// from, for sinks without fields.
+func (r *Record) format(l Line) string {
+	return fmt.Sprintf("%s %s: %s", r.RunId, l.Stream, l.Text)
+}
+
+var (
+	defaultShipper     *Shipper
+	defaultShipperLock sync.RWMutex
+)
+
+// SetDefault replaces the Shipper which ships the output of jobs.
+func SetDefault(s *Shipper) {
+	defaultShipperLock.Lock()
+	defer defaultShipperLock.Unlock()
+	defaultShipper = s
+}
+
+// Default returns the Shipper which ships the output of jobs, or nil if no
+// sinks are set up.
+func Default() *Shipper {
+	defaultShipperLock.RLock()
+	defer defaultShipperLock.RUnlock()
+	return defaultShipper
+}
@@ -0,0 +1,100 @@
+package logsink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRecord() *Record {
+	at := time.Date(2017, 6, 4, 2, 0, 0, 0, time.UTC)
+	return &Record{
+		JobId:   "id",
+		JobName: "backup",
+		RunId:   "run",
+		Lines: []Line{
+			{Time: at, Stream: Stdout, Text: "copying"},
+			{Time: at.Add(time.Second), Stream: Stderr, Text: "disk full"},
+		},
+	}
+}
+
+func TestOpen(t *testing.T) {
+	sink, err := Open("syslog://logs.example.com:514")
+	assert.NoError(t, err)
+	assert.Equal(t, &SyslogSink{Network: "udp", Addr: "logs.example.com:514"}, sink)
+	sink, err = Open("syslog+tcp://logs.example.com:514")
+	assert.NoError(t, err)
+	assert.Equal(t, &SyslogSink{Network: "tcp", Addr: "logs.example.com:514"}, sink)
+
+	sink, err = Open("file:///var/log/kala")
+	assert.NoError(t, err)
+	assert.Equal(t, &FileSink{Dir: "/var/log/kala"}, sink)
+
+	sink, err = Open("loki+https://loki.example.com/prefix?tenant=ops")
+	assert.NoError(t, err)
+	assert.Equal(t, &LokiSink{URL: "https://loki.example.com/prefix/loki/api/v1/push", Tenant: "ops"}, sink)
+
+	sink, err = Open("cloudwatch://kala/jobs?region=eu-west-1")
+	assert.NoError(t, err)
+	assert.Equal(t, &CloudWatchSink{Group: "kala/jobs", Region: "eu-west-1"}, sink)
+
+	_, err = Open("cloudwatch://")
+	assert.Equal(t, ErrNoCloudWatchLog, err)
+	_, err = Open("ftp://example.com/logs")
+	assert.Equal(t, ErrUnknownSink, err)
+}
+
+func TestShipper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logsink")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	server, job := filepath.Join(dir, "server"), filepath.Join(dir, "job")
+
+	s, err := NewShipper([]string{"file://" + server, "file://" + job})
+	assert.NoError(t, err)
+	assert.True(t, s.Ships(nil))
+	assert.False(t, s.Ships(&Settings{Disabled: true}))
+
+	s.Ship(testRecord(), nil)
+	s.Wait()
+	for _, dir := range []string{server, job} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "id.log"))
+		assert.NoError(t, err)
+		assert.Equal(t, "2017-06-04T02:00:00Z run stdout: copying\n2017-06-04T02:00:01Z run stderr: disk full\n", string(b))
+	}
+
+	// The sinks of a job replace the server's.
+	os.RemoveAll(server)
+	s.Ship(testRecord(), &Settings{Sinks: []string{"file://" + job}})
+	s.Wait()
+	_, err = os.Stat(server)
+	assert.True(t, os.IsNotExist(err))
+	b, _ := ioutil.ReadFile(filepath.Join(job, "id.log"))
+	assert.Equal(t, 4, strings.Count(string(b), "\n"))
+
+	s.Ship(testRecord(), &Settings{Disabled: true})
+	s.Wait()
+	_, err = os.Stat(server)
+	assert.True(t, os.IsNotExist(err))
+
+	var none *Shipper
+	assert.False(t, none.Ships(&Settings{Sinks: []string{"loki://loki:3100"}}))
+}
+
+func TestShipperValid(t *testing.T) {
+	s, err := NewShipper([]string{"file:///var/log/kala"})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Valid(nil))
+	assert.NoError(t, s.Valid(&Settings{Sinks: []string{"file:///var/log/kala", "loki://loki:3100"}}))
+	assert.Equal(t, ErrFileSinkDenied, s.Valid(&Settings{Sinks: []string{"file:///etc"}}))
+	assert.Equal(t, ErrUnknownSink, s.Valid(&Settings{Sinks: []string{"ftp://example.com"}}))
+
+	_, err = NewShipper([]string{"ftp://example.com"})
+	assert.Error(t, err)
+}
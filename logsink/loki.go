@@ -0,0 +1,76 @@
+package logsink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// LokiSink pushes the lines to Grafana Loki, in a stream per job, run and
+// output stream, labelled with job="kala", job_id, job_name, run_id and
+// stream.
+type LokiSink struct {
+	// URL of the push API, e.g. http://loki:3100/loki/api/v1/push.
+	URL string
+
+	// Tenant of multi-tenant Loki, sent as X-Scope-OrgID.
+	Tenant string
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *LokiSink) Ship(r *Record) error {
+	streams := []*lokiStream{}
+	byName := map[string]*lokiStream{}
+	for _, l := range r.Lines {
+		stream, ok := byName[l.Stream]
+		if !ok {
+			stream = &lokiStream{Stream: map[string]string{
+				"job":      "kala",
+				"job_id":   r.JobId,
+				"job_name": r.JobName,
+				"run_id":   r.RunId,
+				"stream":   l.Stream,
+			}}
+			byName[l.Stream] = stream
+			streams = append(streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(l.Time.UnixNano(), 10), l.Text})
+	}
+	if len(streams) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(map[string]interface{}{"streams": streams})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Tenant != "" {
+		req.Header.Set("X-Scope-OrgID", s.Tenant)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("Loki returned %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
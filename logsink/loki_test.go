@@ -0,0 +1,35 @@
+package logsink
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLokiSink(t *testing.T) {
+	var pushed struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	status := http.StatusNoContent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		assert.Equal(t, "ops", r.Header.Get("X-Scope-OrgID"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&pushed))
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	sink := &LokiSink{URL: ts.URL + "/loki/api/v1/push", Tenant: "ops"}
+	assert.NoError(t, sink.Ship(testRecord()))
+	if assert.Len(t, pushed.Streams, 2) {
+		assert.Equal(t, map[string]string{"job": "kala", "job_id": "id", "job_name": "backup", "run_id": "run", "stream": "stdout"}, pushed.Streams[0].Stream)
+		assert.Equal(t, [][2]string{{"1496541600000000000", "copying"}}, pushed.Streams[0].Values)
+		assert.Equal(t, "stderr", pushed.Streams[1].Stream["stream"])
+	}
+
+	status = http.StatusBadRequest
+	assert.Error(t, sink.Ship(testRecord()))
+}
@@ -0,0 +1,76 @@
+package logsink
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Syslog facility and severities of the lines, see RFC 5424.
+const (
+	syslogFacilityUser = 1
+	syslogSeverityErr  = 3
+	syslogSeverityInfo = 6
+)
+
+var syslogTimeout = 10 * time.Second
+
+// SyslogSink sends every line as an RFC 5424 message to a syslog server,
+// over UDP or TCP. The lines of standard error have the severity err, those
+// of standard output info, and the message's structured data tells the job
+// and run.
+type SyslogSink struct {
+	// "udp" or "tcp".
+	Network string
+	Addr    string
+}
+
+func (s *SyslogSink) Ship(r *Record) error {
+	conn, err := net.DialTimeout(s.Network, s.Addr, syslogTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(syslogTimeout))
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	for _, l := range r.Lines {
+		msg := syslogMessage(r, l, hostname)
+		if s.Network == "tcp" {
+			// Octet counting framing, see RFC 6587.
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syslogMessage(r *Record, l Line, hostname string) string {
+	severity := syslogSeverityInfo
+	if l.Stream == Stderr {
+		severity = syslogSeverityErr
+	}
+	return fmt.Sprintf("<%d>1 %s %s kala - %s [kala job_id=\"%s\" job_name=\"%s\" run_id=\"%s\" stream=\"%s\"] %s",
+		syslogFacilityUser*8+severity, l.Time.UTC().Format(time.RFC3339Nano), hostname, l.Stream,
+		sdEscape(r.JobId), sdEscape(r.JobName), sdEscape(r.RunId), l.Stream, l.Text)
+}
+
+// sdEscape escapes a value of structured data.
+func sdEscape(s string) string {
+	var buf bytes.Buffer
+	for _, c := range s {
+		if strings.ContainsRune(`"\]`, c) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
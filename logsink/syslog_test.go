@@ -0,0 +1,35 @@
+package logsink
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	sink := &SyslogSink{Network: "udp", Addr: conn.LocalAddr().String()}
+	assert.NoError(t, sink.Ship(testRecord()))
+
+	buf := make([]byte, 1024)
+	messages := []string{}
+	for i := 0; i < 2; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		assert.NoError(t, err)
+		messages = append(messages, string(buf[:n]))
+	}
+	assert.Regexp(t, `^<14>1 2017-06-04T02:00:00Z \S+ kala - stdout \[kala job_id="id" job_name="backup" run_id="run" stream="stdout"\] copying$`, messages[0])
+	assert.Regexp(t, `^<11>1 2017-06-04T02:00:01Z \S+ kala - stderr .* disk full$`, messages[1])
+}
+
+func TestSyslogMessageEscapes(t *testing.T) {
+	r := &Record{JobId: "id", JobName: `say "hi" [now]`, RunId: "run"}
+	msg := syslogMessage(r, Line{Stream: Stdout, Text: "hi"}, "host")
+	assert.Contains(t, msg, `job_name="say \"hi\" [now\]"`)
+}
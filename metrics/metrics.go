@@ -0,0 +1,585 @@
+// Package metrics records job run metrics and forwards them to a
+// configurable Sink, such as StatsD or DogStatsD.
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Names of the metrics emitted for every job run.
+	RunsMetric     = "job.runs"
+	FailuresMetric = "job.failures"
+	WarningsMetric = "job.warnings"
+	SlowRunsMetric = "job.slow_runs"
+	DurationMetric = "job.duration"
+
+	// Name of the metric of the failures to save a job to the database.
+	PersistFailuresMetric = "job.persist_failures"
+
+	// Name of the metric of how long after they were due scheduled runs
+	// started.
+	ScheduleLatencyMetric = "job.schedule_latency"
+
+	// Name of the metric of the number of jobs saved by each persist cycle of the cache.
+	PersistedMetric = "cache.persisted"
+
+	// Names of the metrics of the cache: the number of jobs, the duration
+	// and failures of persist cycles, and the stats dropped by the retention.
+	CacheJobsMetric       = "cache.jobs"
+	PersistDurationMetric = "cache.persist_duration"
+	PersistErrorsMetric   = "cache.persist_errors"
+	StatsDroppedMetric    = "cache.stats_dropped"
+
+	// Names of the gauges of the storage of the job database.
+	DBSizeMetric = "db.size_bytes"
+	DBFreeMetric = "db.free_bytes"
+
+	// Names of the metrics of the health checks of the job database: whether
+	// it's up, and the failed checks and reconnects.
+	DBUpMetric           = "db.up"
+	DBCheckFailureMetric = "db.health_check_failures"
+	DBReconnectsMetric   = "db.reconnects"
+
+	// Number of the most recent schedule latencies whose percentiles are
+	// reported.
+	latencySamples = 1024
+
+	// DefaultMaxJobs is the default number of jobs tracked individually.
+	DefaultMaxJobs = 1000
+
+	// OverflowJobId is the id under which runs of jobs beyond the
+	// cardinality cap are aggregated.
+	OverflowJobId = "_other"
+)
+
+// Tag is a key/value pair attached to a metric.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Sink is implemented by metric backends.
+type Sink interface {
+	IncrCounter(name string, tags []Tag, value int64)
+	Timing(name string, tags []Tag, d time.Duration)
+	Gauge(name string, tags []Tag, value int64)
+}
+
+// BlackholeSink discards all metrics. It is the default Sink.
+type BlackholeSink struct{}
+
+func (*BlackholeSink) IncrCounter(name string, tags []Tag, value int64) {}
+func (*BlackholeSink) Timing(name string, tags []Tag, d time.Duration)  {}
+func (*BlackholeSink) Gauge(name string, tags []Tag, value int64)       {}
+
+// Counts is a snapshot of run counters.
+type Counts struct {
+	Runs          uint64        `json:"runs"`
+	Failures      uint64        `json:"failures"`
+	Warnings      uint64        `json:"warnings"`
+	SlowRuns      uint64        `json:"slow_runs"`
+	TotalDuration time.Duration `json:"total_duration"`
+}
+
+// JobCounts are the run counters of a single job.
+type JobCounts struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	Counts
+	// Times the job couldn't be saved to the database.
+	PersistFailures uint64 `json:"persist_failures"`
+}
+
+// Metrics keeps global and per-job run counters and forwards every run to its Sink.
+type Metrics struct {
+	// Counted atomically since every lookup counts, and first for their
+	// alignment. See RecordCacheGet.
+	hits   uint64
+	misses uint64
+
+	sink    Sink
+	maxJobs int
+
+	lock     sync.RWMutex
+	counts   Counts
+	jobs     map[string]*JobCounts
+	persists PersistCounts
+	cache    CacheCounts
+	db       *DBStats
+	health   *DBHealthCounts
+	latency  latencyWindow
+}
+
+// LatencyCounts tell how long after they were due scheduled runs started: in
+// total, and the percentiles of the most recent runs.
+type LatencyCounts struct {
+	Runs  uint64        `json:"runs"`
+	Total time.Duration `json:"total"`
+	Max   time.Duration `json:"max"`
+
+	P50 time.Duration `json:"p50"`
+	P95 time.Duration `json:"p95"`
+	P99 time.Duration `json:"p99"`
+}
+
+// latencyWindow keeps the most recent schedule latencies in a ring.
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+	counts  LatencyCounts
+}
+
+// CacheCounts are the counters of the job cache.
+type CacheCounts struct {
+	Jobs int `json:"jobs"`
+
+	// Lookups of jobs by id, which found the job or not.
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+
+	// Persist cycles, including failed ones, and how long they took.
+	Persists            uint64        `json:"persists"`
+	PersistErrors       uint64        `json:"persist_errors"`
+	PersistDuration     time.Duration `json:"persist_duration"`
+	LastPersistDuration time.Duration `json:"last_persist_duration"`
+
+	StatsDropped uint64 `json:"stats_dropped"`
+}
+
+// DBStats are the stats of the file of a job database, such as boltdb, which
+// grows as jobs are saved and is shrunk by compacting it.
+type DBStats struct {
+	SizeBytes int64 `json:"size_bytes"`
+
+	// Pages freed by deleted and overwritten jobs, reused before the file
+	// grows, and the pages which will be free once read transactions end.
+	FreePages     int   `json:"free_pages"`
+	PendingPages  int   `json:"pending_pages"`
+	FreeBytes     int64 `json:"free_bytes"`
+	FreelistBytes int64 `json:"freelist_bytes"`
+
+	Compactions     uint64     `json:"compactions"`
+	LastCompactedAt *time.Time `json:"last_compacted_at,omitempty"`
+}
+
+// DBHealthCounts are the counters of the health checks of the job database.
+type DBHealthCounts struct {
+	Up            bool   `json:"up"`
+	Checks        uint64 `json:"checks"`
+	CheckFailures uint64 `json:"check_failures"`
+	Reconnects    uint64 `json:"reconnects"`
+}
+
+// PersistCounts counts the jobs saved by the persist cycles of the cache.
+type PersistCounts struct {
+	Cycles uint64 `json:"cycles"`
+	Jobs   uint64 `json:"jobs"`
+
+	// Number of jobs saved by the most recent cycle.
+	LastCycleJobs int `json:"last_cycle_jobs"`
+	// Number of times jobs couldn't be saved, see RecordPersistFailure.
+	JobFailures uint64 `json:"job_failures"`
+}
+
+// New returns a Metrics that emits to sink. A nil sink discards metrics.
+// At most maxJobs jobs are tracked individually, runs of any further jobs
+// are aggregated under OverflowJobId. A maxJobs of 0 uses DefaultMaxJobs.
+func New(sink Sink, maxJobs int) *Metrics {
+	if sink == nil {
+		sink = &BlackholeSink{}
+	}
+	if maxJobs <= 0 {
+		maxJobs = DefaultMaxJobs
+	}
+	return &Metrics{
+		sink:    sink,
+		maxJobs: maxJobs,
+		jobs:    map[string]*JobCounts{},
+	}
+}
+
+// RecordRun records the outcome of a single job run.
+func (m *Metrics) RecordRun(id, name, owner string, success bool, duration time.Duration) {
+	m.lock.Lock()
+	m.counts.Runs++
+	if !success {
+		m.counts.Failures++
+	}
+	m.counts.TotalDuration += duration
+
+	jc := m.jobCounts(id, name, owner)
+	jc.Runs++
+	if !success {
+		jc.Failures++
+	}
+	jc.TotalDuration += duration
+	m.lock.Unlock()
+
+	tags := []Tag{{"job", name}, {"owner", owner}}
+	m.sink.IncrCounter(RunsMetric, tags, 1)
+	if !success {
+		m.sink.IncrCounter(FailuresMetric, tags, 1)
+	}
+	m.sink.Timing(DurationMetric, tags, duration)
+}
+
+// RecordWarning records a warning of a successful job run, which is recorded
+// with RecordRun too.
+func (m *Metrics) RecordWarning(id, name, owner string) {
+	m.lock.Lock()
+	m.counts.Warnings++
+	m.jobCounts(id, name, owner).Warnings++
+	m.lock.Unlock()
+
+	m.sink.IncrCounter(WarningsMetric, []Tag{{"job", name}, {"owner", owner}}, 1)
+}
+
+// RecordSlowRun records a job run which took much longer than the usual
+// runs of the job, which is recorded with RecordRun too.
+func (m *Metrics) RecordSlowRun(id, name, owner string) {
+	m.lock.Lock()
+	m.counts.SlowRuns++
+	m.jobCounts(id, name, owner).SlowRuns++
+	m.lock.Unlock()
+
+	m.sink.IncrCounter(SlowRunsMetric, []Tag{{"job", name}, {"owner", owner}}, 1)
+}
+
+// RecordPersist records a persist cycle of the cache which saved the given
+// number of jobs.
+func (m *Metrics) RecordPersist(jobs int) {
+	m.lock.Lock()
+	m.persists.Cycles++
+	m.persists.Jobs += uint64(jobs)
+	m.persists.LastCycleJobs = jobs
+	m.lock.Unlock()
+
+	m.sink.IncrCounter(PersistedMetric, nil, int64(jobs))
+}
+
+// RecordPersistFailure records that a persist cycle of the cache couldn't
+// save the job. The other jobs are still saved.
+func (m *Metrics) RecordPersistFailure(id, name, owner string) {
+	m.lock.Lock()
+	m.persists.JobFailures++
+	m.jobCounts(id, name, owner).PersistFailures++
+	m.lock.Unlock()
+
+	m.sink.IncrCounter(PersistFailuresMetric, []Tag{{"job", name}, {"owner", owner}}, 1)
+}
+
+// RecordCacheGet records a lookup of a job in the cache. Lookups are only
+// counted, not sent to the Sink, which would get a metric for every lookup.
+func (m *Metrics) RecordCacheGet(hit bool) {
+	if hit {
+		atomic.AddUint64(&m.hits, 1)
+	} else {
+		atomic.AddUint64(&m.misses, 1)
+	}
+}
+
+// RecordCacheSize records the number of jobs in the cache.
+func (m *Metrics) RecordCacheSize(jobs int) {
+	m.lock.Lock()
+	m.cache.Jobs = jobs
+	m.lock.Unlock()
+
+	m.sink.Gauge(CacheJobsMetric, nil, int64(jobs))
+}
+
+// RecordPersistDuration records how long a persist cycle took, and whether it
+// failed.
+func (m *Metrics) RecordPersistDuration(d time.Duration, failed bool) {
+	m.lock.Lock()
+	m.cache.Persists++
+	m.cache.PersistDuration += d
+	m.cache.LastPersistDuration = d
+	if failed {
+		m.cache.PersistErrors++
+	}
+	m.lock.Unlock()
+
+	m.sink.Timing(PersistDurationMetric, nil, d)
+	if failed {
+		m.sink.IncrCounter(PersistErrorsMetric, nil, 1)
+	}
+}
+
+// RecordStatsDropped records stats of runs dropped by the retention.
+func (m *Metrics) RecordStatsDropped(n int) {
+	m.lock.Lock()
+	m.cache.StatsDropped += uint64(n)
+	m.lock.Unlock()
+
+	m.sink.IncrCounter(StatsDroppedMetric, nil, int64(n))
+}
+
+// CacheCounts returns a snapshot of the counters of the cache.
+func (m *Metrics) CacheCounts() CacheCounts {
+	m.lock.RLock()
+	counts := m.cache
+	m.lock.RUnlock()
+	counts.Hits = atomic.LoadUint64(&m.hits)
+	counts.Misses = atomic.LoadUint64(&m.misses)
+	return counts
+}
+
+// RecordDBStats records the latest stats of the job database.
+func (m *Metrics) RecordDBStats(stats DBStats) {
+	m.lock.Lock()
+	m.db = &stats
+	m.lock.Unlock()
+
+	m.sink.Gauge(DBSizeMetric, nil, stats.SizeBytes)
+	m.sink.Gauge(DBFreeMetric, nil, stats.FreeBytes)
+}
+
+// RecordDBHealth records a health check of the job database, and whether it
+// reconnected to it.
+func (m *Metrics) RecordDBHealth(up, reconnected bool) {
+	m.lock.Lock()
+	if m.health == nil {
+		m.health = &DBHealthCounts{}
+	}
+	m.health.Up = up
+	m.health.Checks++
+	if !up {
+		m.health.CheckFailures++
+	}
+	if reconnected {
+		m.health.Reconnects++
+	}
+	m.lock.Unlock()
+
+	var gauge int64
+	if up {
+		gauge = 1
+	}
+	m.sink.Gauge(DBUpMetric, nil, gauge)
+	if !up {
+		m.sink.IncrCounter(DBCheckFailureMetric, nil, 1)
+	}
+	if reconnected {
+		m.sink.IncrCounter(DBReconnectsMetric, nil, 1)
+	}
+}
+
+// RecordScheduleLatency records how long after it was due a scheduled run
+// started.
+func (m *Metrics) RecordScheduleLatency(name, owner string, latency time.Duration) {
+	m.lock.Lock()
+	w := &m.latency
+	if len(w.samples) < latencySamples {
+		w.samples = append(w.samples, latency)
+	} else {
+		w.samples[w.next] = latency
+		w.next = (w.next + 1) % latencySamples
+	}
+	w.counts.Runs++
+	w.counts.Total += latency
+	if latency > w.counts.Max {
+		w.counts.Max = latency
+	}
+	m.lock.Unlock()
+
+	m.sink.Timing(ScheduleLatencyMetric, []Tag{{"job", name}, {"owner", owner}}, latency)
+}
+
+// ScheduleLatency returns the latencies of the scheduled runs, with the
+// percentiles of the most recent ones.
+func (m *Metrics) ScheduleLatency() LatencyCounts {
+	m.lock.RLock()
+	counts := m.latency.counts
+	samples := append([]time.Duration(nil), m.latency.samples...)
+	m.lock.RUnlock()
+
+	if len(samples) == 0 {
+		return counts
+	}
+	sort.Slice(samples, func(i, k int) bool {
+		return samples[i] < samples[k]
+	})
+	at := func(p float64) time.Duration {
+		return samples[int(p*float64(len(samples)-1))]
+	}
+	counts.P50, counts.P95, counts.P99 = at(0.5), at(0.95), at(0.99)
+	return counts
+}
+
+// jobCounts returns the counters for a job, creating them if the cardinality
+// cap allows it. Must be called with the lock held.
+func (m *Metrics) jobCounts(id, name, owner string) *JobCounts {
+	jc, ok := m.jobs[id]
+	if ok {
+		jc.Name, jc.Owner = name, owner
+		return jc
+	}
+	if len(m.jobs) >= m.maxJobs {
+		jc, ok = m.jobs[OverflowJobId]
+		if ok {
+			return jc
+		}
+		id, name, owner = OverflowJobId, "", ""
+	}
+	jc = &JobCounts{Id: id, Name: name, Owner: owner}
+	m.jobs[id] = jc
+	return jc
+}
+
+// Counts returns a snapshot of the global run counters.
+func (m *Metrics) Counts() Counts {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.counts
+}
+
+// PersistCounts returns a snapshot of the persist counters.
+func (m *Metrics) PersistCounts() PersistCounts {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.persists
+}
+
+// DBStats returns the latest stats of the job database, and whether any were
+// recorded.
+func (m *Metrics) DBStats() (DBStats, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.db == nil {
+		return DBStats{}, false
+	}
+	return *m.db, true
+}
+
+// DBHealthCounts returns the counters of the health checks of the job
+// database, and whether it's checked.
+func (m *Metrics) DBHealthCounts() (DBHealthCounts, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.health == nil {
+		return DBHealthCounts{}, false
+	}
+	return *m.health, true
+}
+
+// JobCounts returns a snapshot of the run counters of a job, and whether
+// the job is being tracked.
+func (m *Metrics) JobCounts(id string) (JobCounts, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	jc, ok := m.jobs[id]
+	if !ok {
+		return JobCounts{Id: id}, false
+	}
+	return *jc, true
+}
+
+// AllJobCounts returns a snapshot of the run counters of every tracked job.
+func (m *Metrics) AllJobCounts() []JobCounts {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	all := make([]JobCounts, 0, len(m.jobs))
+	for _, jc := range m.jobs {
+		all = append(all, *jc)
+	}
+	return all
+}
+
+// Forget stops tracking a job, freeing up room under the cardinality cap.
+func (m *Metrics) Forget(id string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.jobs, id)
+}
+
+var (
+	defaultMetrics     = New(nil, 0)
+	defaultMetricsLock sync.RWMutex
+)
+
+// SetDefault replaces the Metrics used by the package-level functions.
+func SetDefault(m *Metrics) {
+	defaultMetricsLock.Lock()
+	defer defaultMetricsLock.Unlock()
+	defaultMetrics = m
+}
+
+// Default returns the Metrics used by the package-level functions.
+func Default() *Metrics {
+	defaultMetricsLock.RLock()
+	defer defaultMetricsLock.RUnlock()
+	return defaultMetrics
+}
+
+// RecordRun records a job run on the default Metrics.
+func RecordRun(id, name, owner string, success bool, duration time.Duration) {
+	Default().RecordRun(id, name, owner, success, duration)
+}
+
+// RecordWarning records a warning of a job run on the default Metrics.
+func RecordWarning(id, name, owner string) {
+	Default().RecordWarning(id, name, owner)
+}
+
+// RecordSlowRun records a slow job run on the default Metrics.
+func RecordSlowRun(id, name, owner string) {
+	Default().RecordSlowRun(id, name, owner)
+}
+
+// RecordPersist records a persist cycle on the default Metrics.
+func RecordPersist(jobs int) {
+	Default().RecordPersist(jobs)
+}
+
+// RecordPersistFailure records a job which couldn't be saved on the default
+// Metrics.
+func RecordPersistFailure(id, name, owner string) {
+	Default().RecordPersistFailure(id, name, owner)
+}
+
+// RecordCacheGet records a lookup in the cache on the default Metrics.
+func RecordCacheGet(hit bool) {
+	Default().RecordCacheGet(hit)
+}
+
+// RecordCacheSize records the number of jobs in the cache on the default Metrics.
+func RecordCacheSize(jobs int) {
+	Default().RecordCacheSize(jobs)
+}
+
+// RecordPersistDuration records a persist cycle's duration on the default Metrics.
+func RecordPersistDuration(d time.Duration, failed bool) {
+	Default().RecordPersistDuration(d, failed)
+}
+
+// RecordStatsDropped records stats dropped by the retention on the default Metrics.
+func RecordStatsDropped(n int) {
+	Default().RecordStatsDropped(n)
+}
+
+// RecordDBStats records the stats of the job database on the default Metrics.
+func RecordDBStats(stats DBStats) {
+	Default().RecordDBStats(stats)
+}
+
+// RecordDBHealth records a health check of the job database on the default
+// Metrics.
+func RecordDBHealth(up, reconnected bool) {
+	Default().RecordDBHealth(up, reconnected)
+}
+
+// RecordScheduleLatency records the latency of a scheduled run on the default
+// Metrics.
+func RecordScheduleLatency(name, owner string, latency time.Duration) {
+	Default().RecordScheduleLatency(name, owner, latency)
+}
+
+// Forget stops tracking a job on the default Metrics.
+func Forget(id string) {
+	Default().Forget(id)
+}
@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordedMetric struct {
+	name  string
+	tags  []Tag
+	value int64
+}
+
+type mockSink struct {
+	counters []recordedMetric
+	timings  []recordedMetric
+	gauges   []recordedMetric
+}
+
+func (s *mockSink) IncrCounter(name string, tags []Tag, value int64) {
+	s.counters = append(s.counters, recordedMetric{name, tags, value})
+}
+
+func (s *mockSink) Timing(name string, tags []Tag, d time.Duration) {
+	s.timings = append(s.timings, recordedMetric{name, tags, int64(d)})
+}
+
+func (s *mockSink) Gauge(name string, tags []Tag, value int64) {
+	s.gauges = append(s.gauges, recordedMetric{name, tags, value})
+}
+
+func TestRecordRun(t *testing.T) {
+	sink := &mockSink{}
+	m := New(sink, 0)
+
+	m.RecordRun("1", "backup", "admin@example.com", true, time.Second)
+	m.RecordRun("1", "backup", "admin@example.com", false, 2*time.Second)
+
+	counts := m.Counts()
+	assert.Equal(t, uint64(2), counts.Runs)
+	assert.Equal(t, uint64(1), counts.Failures)
+	assert.Equal(t, 3*time.Second, counts.TotalDuration)
+
+	assert.Len(t, sink.counters, 3)
+	assert.Equal(t, RunsMetric, sink.counters[0].name)
+	assert.Equal(t, FailuresMetric, sink.counters[2].name)
+	assert.Equal(t, []Tag{{"job", "backup"}, {"owner", "admin@example.com"}}, sink.counters[0].tags)
+
+	assert.Len(t, sink.timings, 2)
+	assert.Equal(t, DurationMetric, sink.timings[0].name)
+	assert.Equal(t, int64(time.Second), sink.timings[0].value)
+}
+
+func TestRecordPersist(t *testing.T) {
+	sink := &mockSink{}
+	m := New(sink, 0)
+
+	m.RecordPersist(10)
+	m.RecordPersist(0)
+
+	assert.Equal(t, PersistCounts{Cycles: 2, Jobs: 10, LastCycleJobs: 0}, m.PersistCounts())
+	assert.Equal(t, []recordedMetric{{PersistedMetric, nil, 10}, {PersistedMetric, nil, 0}}, sink.counters)
+}
+
+func TestRecordPersistFailure(t *testing.T) {
+	sink := &mockSink{}
+	m := New(sink, 0)
+
+	m.RecordPersistFailure("1", "backup", "admin")
+	m.RecordPersistFailure("1", "backup", "admin")
+
+	assert.Equal(t, uint64(2), m.PersistCounts().JobFailures)
+	jc, ok := m.JobCounts("1")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), jc.PersistFailures)
+	assert.Equal(t, uint64(0), jc.Runs)
+	tags := []Tag{{"job", "backup"}, {"owner", "admin"}}
+	assert.Equal(t, []recordedMetric{{PersistFailuresMetric, tags, 1}, {PersistFailuresMetric, tags, 1}}, sink.counters)
+}
+
+func TestRecordDBStats(t *testing.T) {
+	sink := &mockSink{}
+	m := New(sink, 0)
+
+	_, ok := m.DBStats()
+	assert.False(t, ok)
+
+	m.RecordDBStats(DBStats{SizeBytes: 65536, FreePages: 3, FreeBytes: 12288})
+	stats, ok := m.DBStats()
+	assert.True(t, ok)
+	assert.Equal(t, int64(65536), stats.SizeBytes)
+	assert.Equal(t, []recordedMetric{
+		{DBSizeMetric, nil, 65536},
+		{DBFreeMetric, nil, 12288},
+	}, sink.gauges)
+}
+
+func TestRecordDBHealth(t *testing.T) {
+	sink := &mockSink{}
+	m := New(sink, 0)
+
+	_, ok := m.DBHealthCounts()
+	assert.False(t, ok)
+
+	m.RecordDBHealth(false, false)
+	m.RecordDBHealth(true, true)
+	health, ok := m.DBHealthCounts()
+	assert.True(t, ok)
+	assert.Equal(t, DBHealthCounts{Up: true, Checks: 2, CheckFailures: 1, Reconnects: 1}, health)
+	assert.Equal(t, []recordedMetric{{DBUpMetric, nil, 0}, {DBUpMetric, nil, 1}}, sink.gauges)
+	assert.Equal(t, []recordedMetric{{DBCheckFailureMetric, nil, 1}, {DBReconnectsMetric, nil, 1}}, sink.counters)
+}
+
+func TestRecordScheduleLatency(t *testing.T) {
+	sink := &mockSink{}
+	m := New(sink, 0)
+
+	assert.Equal(t, LatencyCounts{}, m.ScheduleLatency())
+	for i := 1; i <= 100; i++ {
+		m.RecordScheduleLatency("backup", "admin", time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, LatencyCounts{
+		Runs:  100,
+		Total: 5050 * time.Millisecond,
+		Max:   100 * time.Millisecond,
+		P50:   50 * time.Millisecond,
+		P95:   95 * time.Millisecond,
+		P99:   99 * time.Millisecond,
+	}, m.ScheduleLatency())
+	assert.Len(t, sink.timings, 100)
+	assert.Equal(t, recordedMetric{ScheduleLatencyMetric, []Tag{{"job", "backup"}, {"owner", "admin"}}, int64(time.Millisecond)}, sink.timings[0])
+
+	// Percentiles are of the most recent runs only.
+	for i := 0; i < latencySamples; i++ {
+		m.RecordScheduleLatency("backup", "admin", time.Second)
+	}
+	latency := m.ScheduleLatency()
+	assert.Equal(t, time.Second, latency.P50)
+	assert.Equal(t, uint64(100+latencySamples), latency.Runs)
+}
+
+func TestRecordCache(t *testing.T) {
+	sink := &mockSink{}
+	m := New(sink, 0)
+
+	m.RecordCacheGet(true)
+	m.RecordCacheGet(true)
+	m.RecordCacheGet(false)
+	m.RecordCacheSize(5)
+	m.RecordPersistDuration(2*time.Second, false)
+	m.RecordPersistDuration(time.Second, true)
+	m.RecordStatsDropped(7)
+
+	assert.Equal(t, CacheCounts{
+		Jobs:                5,
+		Hits:                2,
+		Misses:              1,
+		Persists:            2,
+		PersistErrors:       1,
+		PersistDuration:     3 * time.Second,
+		LastPersistDuration: time.Second,
+		StatsDropped:        7,
+	}, m.CacheCounts())
+	assert.Equal(t, []recordedMetric{{CacheJobsMetric, nil, 5}}, sink.gauges)
+	assert.Equal(t, []recordedMetric{
+		{PersistDurationMetric, nil, int64(2 * time.Second)},
+		{PersistDurationMetric, nil, int64(time.Second)},
+	}, sink.timings)
+	assert.Equal(t, []recordedMetric{
+		{PersistErrorsMetric, nil, 1},
+		{StatsDroppedMetric, nil, 7},
+	}, sink.counters)
+}
+
+func TestNilSinkDiscards(t *testing.T) {
+	m := New(nil, 0)
+	m.RecordRun("1", "backup", "", true, time.Second)
+	assert.Equal(t, uint64(1), m.Counts().Runs)
+}
+
+func TestSetDefault(t *testing.T) {
+	original := Default()
+	defer SetDefault(original)
+
+	sink := &mockSink{}
+	SetDefault(New(sink, 0))
+	RecordRun("1", "backup", "", true, time.Second)
+	assert.Len(t, sink.counters, 1)
+}
+
+func TestPerJobCounts(t *testing.T) {
+	m := New(nil, 0)
+
+	m.RecordRun("1", "backup", "admin", true, time.Second)
+	m.RecordRun("1", "backup", "admin", false, time.Second)
+	m.RecordRun("2", "cleanup", "ops", true, time.Second)
+
+	jc, ok := m.JobCounts("1")
+	assert.True(t, ok)
+	assert.Equal(t, "backup", jc.Name)
+	assert.Equal(t, "admin", jc.Owner)
+	assert.Equal(t, uint64(2), jc.Runs)
+	assert.Equal(t, uint64(1), jc.Failures)
+	assert.Equal(t, 2*time.Second, jc.TotalDuration)
+
+	_, ok = m.JobCounts("3")
+	assert.False(t, ok)
+	assert.Len(t, m.AllJobCounts(), 2)
+
+	m.Forget("1")
+	_, ok = m.JobCounts("1")
+	assert.False(t, ok)
+}
+
+func TestPerJobCountsCardinalityCap(t *testing.T) {
+	m := New(nil, 2)
+
+	m.RecordRun("1", "one", "", true, time.Second)
+	m.RecordRun("2", "two", "", true, time.Second)
+	m.RecordRun("3", "three", "", true, time.Second)
+	m.RecordRun("4", "four", "", false, time.Second)
+	m.RecordRun("1", "one", "", true, time.Second)
+
+	jc, ok := m.JobCounts("1")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), jc.Runs)
+
+	_, ok = m.JobCounts("3")
+	assert.False(t, ok)
+
+	overflow, ok := m.JobCounts(OverflowJobId)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), overflow.Runs)
+	assert.Equal(t, uint64(1), overflow.Failures)
+	assert.Equal(t, uint64(5), m.Counts().Runs)
+}
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// PrometheusContentType is the content type of the Prometheus text exposition format.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the global and per-job counters in the Prometheus
+// text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	counts := m.Counts()
+	jobs := m.AllJobCounts()
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Id < jobs[k].Id })
+
+	buf := bufio.NewWriter(w)
+
+	writeHeader(buf, "kala_runs_total", "counter", "Total number of job runs.")
+	fmt.Fprintf(buf, "kala_runs_total %d\n", counts.Runs)
+	writeHeader(buf, "kala_failures_total", "counter", "Total number of failed job runs.")
+	fmt.Fprintf(buf, "kala_failures_total %d\n", counts.Failures)
+	writeHeader(buf, "kala_warnings_total", "counter", "Total number of successful job runs with a warning.")
+	fmt.Fprintf(buf, "kala_warnings_total %d\n", counts.Warnings)
+	writeHeader(buf, "kala_slow_runs_total", "counter", "Total number of job runs which took much longer than usual.")
+	fmt.Fprintf(buf, "kala_slow_runs_total %d\n", counts.SlowRuns)
+
+	latency := m.ScheduleLatency()
+	writeHeader(buf, "kala_schedule_latency_seconds", "summary", "How long after they were due scheduled runs started, with the quantiles of the most recent runs.")
+	for _, q := range []struct {
+		quantile string
+		value    float64
+	}{{"0.5", latency.P50.Seconds()}, {"0.95", latency.P95.Seconds()}, {"0.99", latency.P99.Seconds()}} {
+		fmt.Fprintf(buf, "kala_schedule_latency_seconds{quantile=\"%s\"} %g\n", q.quantile, q.value)
+	}
+	fmt.Fprintf(buf, "kala_schedule_latency_seconds_sum %g\n", latency.Total.Seconds())
+	fmt.Fprintf(buf, "kala_schedule_latency_seconds_count %d\n", latency.Runs)
+	writeHeader(buf, "kala_schedule_latency_max_seconds", "gauge", "Longest time after it was due a scheduled run started.")
+	fmt.Fprintf(buf, "kala_schedule_latency_max_seconds %g\n", latency.Max.Seconds())
+
+	persists := m.PersistCounts()
+	writeHeader(buf, "kala_persisted_jobs_total", "counter", "Total number of jobs saved to the database by persist cycles.")
+	fmt.Fprintf(buf, "kala_persisted_jobs_total %d\n", persists.Jobs)
+	writeHeader(buf, "kala_persisted_jobs", "gauge", "Number of jobs saved to the database by the last persist cycle.")
+	fmt.Fprintf(buf, "kala_persisted_jobs %d\n", persists.LastCycleJobs)
+	writeHeader(buf, "kala_persist_job_failures_total", "counter", "Total number of times jobs couldn't be saved to the database by persist cycles.")
+	fmt.Fprintf(buf, "kala_persist_job_failures_total %d\n", persists.JobFailures)
+
+	cache := m.CacheCounts()
+	writeHeader(buf, "kala_cache_jobs", "gauge", "Number of jobs in the cache, as of the last persist cycle.")
+	fmt.Fprintf(buf, "kala_cache_jobs %d\n", cache.Jobs)
+	writeHeader(buf, "kala_cache_hits_total", "counter", "Total number of lookups of jobs found in the cache.")
+	fmt.Fprintf(buf, "kala_cache_hits_total %d\n", cache.Hits)
+	writeHeader(buf, "kala_cache_misses_total", "counter", "Total number of lookups of jobs missing from the cache.")
+	fmt.Fprintf(buf, "kala_cache_misses_total %d\n", cache.Misses)
+	writeHeader(buf, "kala_cache_persist_duration_seconds", "summary", "Duration of persist cycles.")
+	fmt.Fprintf(buf, "kala_cache_persist_duration_seconds_sum %g\n", cache.PersistDuration.Seconds())
+	fmt.Fprintf(buf, "kala_cache_persist_duration_seconds_count %d\n", cache.Persists)
+	writeHeader(buf, "kala_cache_persist_errors_total", "counter", "Total number of failed persist cycles.")
+	fmt.Fprintf(buf, "kala_cache_persist_errors_total %d\n", cache.PersistErrors)
+	writeHeader(buf, "kala_cache_stats_dropped_total", "counter", "Total number of stats of runs dropped by the retention.")
+	fmt.Fprintf(buf, "kala_cache_stats_dropped_total %d\n", cache.StatsDropped)
+
+	if db, ok := m.DBStats(); ok {
+		writeHeader(buf, "kala_db_size_bytes", "gauge", "Size of the file of the job database.")
+		fmt.Fprintf(buf, "kala_db_size_bytes %d\n", db.SizeBytes)
+		writeHeader(buf, "kala_db_free_bytes", "gauge", "Bytes of free pages in the file of the job database.")
+		fmt.Fprintf(buf, "kala_db_free_bytes %d\n", db.FreeBytes)
+		writeHeader(buf, "kala_db_free_pages", "gauge", "Number of free pages in the file of the job database.")
+		fmt.Fprintf(buf, "kala_db_free_pages %d\n", db.FreePages)
+		writeHeader(buf, "kala_db_compactions_total", "counter", "Total number of compactions of the job database.")
+		fmt.Fprintf(buf, "kala_db_compactions_total %d\n", db.Compactions)
+	}
+
+	if health, ok := m.DBHealthCounts(); ok {
+		up := 0
+		if health.Up {
+			up = 1
+		}
+		writeHeader(buf, "kala_db_up", "gauge", "Whether the last health check of the job database succeeded.")
+		fmt.Fprintf(buf, "kala_db_up %d\n", up)
+		writeHeader(buf, "kala_db_health_check_failures_total", "counter", "Total number of failed health checks of the job database.")
+		fmt.Fprintf(buf, "kala_db_health_check_failures_total %d\n", health.CheckFailures)
+		writeHeader(buf, "kala_db_reconnects_total", "counter", "Total number of reconnects to the job database after failed health checks.")
+		fmt.Fprintf(buf, "kala_db_reconnects_total %d\n", health.Reconnects)
+	}
+
+	writeHeader(buf, "kala_job_runs_total", "counter", "Number of runs per job.")
+	for _, jc := range jobs {
+		fmt.Fprintf(buf, "kala_job_runs_total{%s} %d\n", jobLabels(jc), jc.Runs)
+	}
+	writeHeader(buf, "kala_job_failures_total", "counter", "Number of failed runs per job.")
+	for _, jc := range jobs {
+		fmt.Fprintf(buf, "kala_job_failures_total{%s} %d\n", jobLabels(jc), jc.Failures)
+	}
+	writeHeader(buf, "kala_job_warnings_total", "counter", "Number of successful runs with a warning per job.")
+	for _, jc := range jobs {
+		fmt.Fprintf(buf, "kala_job_warnings_total{%s} %d\n", jobLabels(jc), jc.Warnings)
+	}
+	writeHeader(buf, "kala_job_slow_runs_total", "counter", "Number of runs which took much longer than usual per job.")
+	for _, jc := range jobs {
+		fmt.Fprintf(buf, "kala_job_slow_runs_total{%s} %d\n", jobLabels(jc), jc.SlowRuns)
+	}
+	writeHeader(buf, "kala_job_persist_failures_total", "counter", "Number of times persist cycles couldn't save the job to the database per job.")
+	for _, jc := range jobs {
+		fmt.Fprintf(buf, "kala_job_persist_failures_total{%s} %d\n", jobLabels(jc), jc.PersistFailures)
+	}
+	writeHeader(buf, "kala_job_duration_seconds", "summary", "Duration of runs per job.")
+	for _, jc := range jobs {
+		fmt.Fprintf(buf, "kala_job_duration_seconds_sum{%s} %g\n", jobLabels(jc), jc.TotalDuration.Seconds())
+		fmt.Fprintf(buf, "kala_job_duration_seconds_count{%s} %d\n", jobLabels(jc), jc.Runs)
+	}
+
+	return buf.Flush()
+}
+
+// PrometheusHandler serves the default Metrics in the Prometheus text exposition format.
+func PrometheusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", PrometheusContentType)
+	Default().WritePrometheus(w)
+}
+
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func jobLabels(jc JobCounts) string {
+	return fmt.Sprintf(`job_id="%s",job="%s",owner="%s"`,
+		labelReplacer.Replace(jc.Id),
+		labelReplacer.Replace(jc.Name),
+		labelReplacer.Replace(jc.Owner),
+	)
+}
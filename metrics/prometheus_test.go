@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	m := New(nil, 0)
+	m.RecordRun("1", `back"up`, "admin", true, 1500*time.Millisecond)
+	m.RecordRun("1", `back"up`, "admin", false, 500*time.Millisecond)
+	m.RecordWarning("1", `back"up`, "admin")
+	m.RecordSlowRun("1", `back"up`, "admin")
+	m.RecordPersist(3)
+	m.RecordPersist(1)
+	m.RecordPersistFailure("1", `back"up`, "admin")
+	m.RecordDBStats(DBStats{SizeBytes: 65536, FreeBytes: 4096, FreePages: 1, Compactions: 2})
+	m.RecordCacheGet(true)
+	m.RecordCacheGet(false)
+	m.RecordCacheSize(4)
+	m.RecordPersistDuration(250*time.Millisecond, true)
+	m.RecordStatsDropped(3)
+	m.RecordDBHealth(false, true)
+	m.RecordScheduleLatency(`back"up`, "admin", 2*time.Second)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, m.WritePrometheus(buf))
+	out := buf.String()
+
+	assert.Contains(t, out, "# TYPE kala_runs_total counter\nkala_runs_total 2\n")
+	assert.Contains(t, out, "kala_failures_total 1\n")
+	assert.Contains(t, out, "kala_warnings_total 1\n")
+	assert.Contains(t, out, "kala_slow_runs_total 1\n")
+	assert.Contains(t, out, "kala_persisted_jobs_total 4\n")
+	assert.Contains(t, out, "# TYPE kala_persisted_jobs gauge\nkala_persisted_jobs 1\n")
+	assert.Contains(t, out, "kala_persist_job_failures_total 1\n")
+	assert.Contains(t, out, "# TYPE kala_db_size_bytes gauge\nkala_db_size_bytes 65536\n")
+	assert.Contains(t, out, "kala_db_free_bytes 4096\n")
+	assert.Contains(t, out, "kala_db_compactions_total 2\n")
+	assert.Contains(t, out, "# TYPE kala_db_up gauge\nkala_db_up 0\n")
+	assert.Contains(t, out, "kala_db_health_check_failures_total 1\n")
+	assert.Contains(t, out, "kala_db_reconnects_total 1\n")
+	assert.Contains(t, out, "# TYPE kala_cache_jobs gauge\nkala_cache_jobs 4\n")
+	assert.Contains(t, out, "kala_cache_hits_total 1\n")
+	assert.Contains(t, out, "kala_cache_misses_total 1\n")
+	assert.Contains(t, out, "kala_cache_persist_duration_seconds_sum 0.25\n")
+	assert.Contains(t, out, "kala_cache_persist_duration_seconds_count 1\n")
+	assert.Contains(t, out, "kala_cache_persist_errors_total 1\n")
+	assert.Contains(t, out, "kala_cache_stats_dropped_total 3\n")
+	assert.Contains(t, out, "# TYPE kala_schedule_latency_seconds summary\nkala_schedule_latency_seconds{quantile=\"0.5\"} 2\n")
+	assert.Contains(t, out, "kala_schedule_latency_seconds_count 1\n")
+	assert.Contains(t, out, "kala_schedule_latency_max_seconds 2\n")
+	assert.Contains(t, out, `kala_job_runs_total{job_id="1",job="back\"up",owner="admin"} 2`)
+	assert.Contains(t, out, `kala_job_failures_total{job_id="1",job="back\"up",owner="admin"} 1`)
+	assert.Contains(t, out, `kala_job_warnings_total{job_id="1",job="back\"up",owner="admin"} 1`)
+	assert.Contains(t, out, `kala_job_slow_runs_total{job_id="1",job="back\"up",owner="admin"} 1`)
+	assert.Contains(t, out, `kala_job_persist_failures_total{job_id="1",job="back\"up",owner="admin"} 1`)
+	assert.Contains(t, out, `kala_job_duration_seconds_sum{job_id="1",job="back\"up",owner="admin"} 2`)
+	assert.Contains(t, out, `kala_job_duration_seconds_count{job_id="1",job="back\"up",owner="admin"} 2`)
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/metrics", nil)
+	assert.NoError(t, err)
+
+	PrometheusHandler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, PrometheusContentType, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "kala_runs_total")
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// StatsdSink sends metrics over UDP to a StatsD or DogStatsD agent.
+//
+// Plain StatsD has no notion of tags, so tag values are appended to the
+// metric name instead (e.g. "kala.job.runs.backup.admin").
+type StatsdSink struct {
+	conn      net.Conn
+	prefix    string
+	dogstatsd bool
+}
+
+// NewStatsdSink dials the agent at addr ("host:port"). Every metric name is
+// prefixed with prefix, and tags are sent in DogStatsD format if dogstatsd is true.
+func NewStatsdSink(addr, prefix string, dogstatsd bool) (*StatsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsdSink{
+		conn:      conn,
+		prefix:    prefix,
+		dogstatsd: dogstatsd,
+	}, nil
+}
+
+func (s *StatsdSink) IncrCounter(name string, tags []Tag, value int64) {
+	s.send(name, tags, fmt.Sprintf("%d|c", value))
+}
+
+func (s *StatsdSink) Timing(name string, tags []Tag, d time.Duration) {
+	s.send(name, tags, fmt.Sprintf("%d|ms", int64(d/time.Millisecond)))
+}
+
+func (s *StatsdSink) Gauge(name string, tags []Tag, value int64) {
+	s.send(name, tags, fmt.Sprintf("%d|g", value))
+}
+
+// Close closes the underlying connection.
+func (s *StatsdSink) Close() error {
+	return s.conn.Close()
+}
+
+func (s *StatsdSink) send(name string, tags []Tag, value string) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(s.prefix)
+	buf.WriteString(name)
+	if !s.dogstatsd {
+		for _, t := range tags {
+			buf.WriteString(".")
+			buf.WriteString(sanitizeName(t.Value))
+		}
+	}
+	buf.WriteString(":")
+	buf.WriteString(value)
+	if s.dogstatsd && len(tags) > 0 {
+		buf.WriteString("|#")
+		for i, t := range tags {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString(sanitizeTag(t.Key))
+			buf.WriteString(":")
+			buf.WriteString(sanitizeTag(t.Value))
+		}
+	}
+
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		log.Debugf("Error occured sending metric %s: %s", name, err)
+	}
+}
+
+var (
+	nameReplacer = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+	tagReplacer  = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+)
+
+func sanitizeName(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return nameReplacer.Replace(s)
+}
+
+func sanitizeTag(s string) string {
+	return tagReplacer.Replace(s)
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listenUDP(t *testing.T) *net.UDPConn {
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	conn, err := net.ListenUDP("udp", addr)
+	assert.NoError(t, err)
+	return conn
+}
+
+func readPacket(t *testing.T, conn *net.UDPConn) string {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestStatsdSink(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+
+	sink, err := NewStatsdSink(conn.LocalAddr().String(), "kala", false)
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	tags := []Tag{{"job", "nightly backup"}, {"owner", "admin@example.com"}}
+	sink.IncrCounter(RunsMetric, tags, 1)
+	assert.Equal(t, "kala.job.runs.nightly_backup.admin_example_com:1|c", readPacket(t, conn))
+
+	sink.Timing(DurationMetric, tags, 1500*time.Millisecond)
+	assert.Equal(t, "kala.job.duration.nightly_backup.admin_example_com:1500|ms", readPacket(t, conn))
+
+	sink.Gauge(DBSizeMetric, nil, 65536)
+	assert.Equal(t, "kala.db.size_bytes:65536|g", readPacket(t, conn))
+}
+
+func TestDogStatsdSink(t *testing.T) {
+	conn := listenUDP(t)
+	defer conn.Close()
+
+	sink, err := NewStatsdSink(conn.LocalAddr().String(), "kala.", true)
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	tags := []Tag{{"job", "backup"}, {"owner", "admin@example.com"}}
+	sink.IncrCounter(FailuresMetric, tags, 1)
+	assert.Equal(t, "kala.job.failures:1|c|#job:backup,owner:admin_example.com", readPacket(t, conn))
+}
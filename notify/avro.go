@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// EventAvroSchema is the Avro schema of events published in Avro. Times are
+// in milliseconds since the epoch and durations in nanoseconds.
+const EventAvroSchema = `{
+  "type": "record",
+  "name": "Event",
+  "namespace": "com.github.ajvb.kala",
+  "fields": [
+    {"name": "type", "type": "string"},
+    {"name": "job_id", "type": "string"},
+    {"name": "job_name", "type": "string"},
+    {"name": "owner", "type": "string"},
+    {"name": "tags", "type": {"type": "array", "items": "string"}},
+    {"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "severity", "type": "string"},
+    {"name": "consecutive_failures", "type": "long"},
+    {"name": "run_id", "type": "string"},
+    {"name": "request_id", "type": "string"},
+    {"name": "duration", "type": "long"},
+    {"name": "number_of_retries", "type": "long"},
+    {"name": "error", "type": "string"},
+    {"name": "output", "type": "string"}
+  ]
+}`
+
+// EncodeAvro encodes the event in Avro's binary encoding, using EventAvroSchema.
+// A schemaId other than 0 prepends the Confluent Schema Registry header.
+func EncodeAvro(e *Event, schemaId uint32) []byte {
+	buf := new(bytes.Buffer)
+	if schemaId != 0 {
+		buf.WriteByte(0)
+		binary.Write(buf, binary.BigEndian, schemaId)
+	}
+
+	writeAvroString(buf, string(e.Type))
+	writeAvroString(buf, e.JobId)
+	writeAvroString(buf, e.JobName)
+	writeAvroString(buf, e.Owner)
+	if len(e.Tags) != 0 {
+		writeAvroLong(buf, int64(len(e.Tags)))
+		for _, tag := range e.Tags {
+			writeAvroString(buf, tag)
+		}
+	}
+	writeAvroLong(buf, 0)
+	writeAvroLong(buf, e.Time.UnixNano()/1e6)
+	writeAvroString(buf, e.Severity)
+	writeAvroLong(buf, int64(e.ConsecutiveFailures))
+	writeAvroString(buf, e.RunId)
+	writeAvroString(buf, e.RequestId)
+	writeAvroLong(buf, int64(e.Duration))
+	writeAvroLong(buf, int64(e.NumberOfRetries))
+	writeAvroString(buf, e.Error)
+	writeAvroString(buf, e.Output)
+	return buf.Bytes()
+}
+
+// writeAvroLong writes a zig-zag encoded variable-length integer.
+func writeAvroLong(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func writeAvroString(buf *bytes.Buffer, s string) {
+	writeAvroLong(buf, int64(len(s)))
+	buf.WriteString(s)
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readAvroLong(t *testing.T, r *bytes.Reader) int64 {
+	v, err := binary.ReadVarint(r)
+	assert.NoError(t, err)
+	return v
+}
+
+func readAvroString(t *testing.T, r *bytes.Reader) string {
+	b := make([]byte, readAvroLong(t, r))
+	r.Read(b)
+	return string(b)
+}
+
+func TestEventAvroSchemaIsJSON(t *testing.T) {
+	var schema map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(EventAvroSchema), &schema))
+	assert.Len(t, schema["fields"], 14)
+}
+
+func TestEncodeAvro(t *testing.T) {
+	now := time.Date(2017, 6, 4, 19, 25, 16, 0, time.UTC)
+	e := &Event{
+		Type:                JobFailed,
+		JobId:               "id",
+		JobName:             "backup",
+		Tags:                []string{"a", "b"},
+		Time:                now,
+		Severity:            SeverityCritical,
+		ConsecutiveFailures: 3,
+		Duration:            -time.Second,
+		Error:               "exit status 1",
+	}
+
+	b := EncodeAvro(e, 42)
+	assert.Equal(t, []byte{0, 0, 0, 0, 42}, b[:5])
+
+	r := bytes.NewReader(b[5:])
+	assert.Equal(t, "failure", readAvroString(t, r))
+	assert.Equal(t, "id", readAvroString(t, r))
+	assert.Equal(t, "backup", readAvroString(t, r))
+	assert.Equal(t, "", readAvroString(t, r))
+	assert.Equal(t, int64(2), readAvroLong(t, r))
+	assert.Equal(t, "a", readAvroString(t, r))
+	assert.Equal(t, "b", readAvroString(t, r))
+	assert.Equal(t, int64(0), readAvroLong(t, r))
+	assert.Equal(t, now.Unix()*1000, readAvroLong(t, r))
+	assert.Equal(t, "critical", readAvroString(t, r))
+	assert.Equal(t, int64(3), readAvroLong(t, r))
+	assert.Equal(t, "", readAvroString(t, r))
+	assert.Equal(t, "", readAvroString(t, r))
+	assert.Equal(t, int64(-time.Second), readAvroLong(t, r))
+	assert.Equal(t, int64(0), readAvroLong(t, r))
+	assert.Equal(t, "exit status 1", readAvroString(t, r))
+	assert.Equal(t, "", readAvroString(t, r))
+	assert.Equal(t, 0, r.Len())
+
+	// Without a schema id there is no header.
+	assert.Equal(t, byte(len("failure")*2), EncodeAvro(e, 0)[0])
+}
@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// DefaultEmailSubject is the default template of email subjects.
+	DefaultEmailSubject = `[kala] Job {{.JobName}}: {{.Type}}`
+	// DefaultEmailBody is the default template of email bodies.
+	DefaultEmailBody = `Job {{.JobName}} ({{.JobId}}) {{if eq .Type "failure"}}failed{{else if eq .Type "recovery"}}recovered{{else if eq .Type "slow"}}ran slow, {{.Duration}} instead of about {{.Baseline}},{{else}}was disabled{{end}} at {{.Time.Format "2006-01-02T15:04:05Z07:00"}}.
+{{if and (eq .Type "recovery") .ConsecutiveFailures}}It had failed {{.ConsecutiveFailures}} {{if eq .ConsecutiveFailures 1}}run{{else}}runs in a row{{end}}{{if not .FailingSince.IsZero}} over {{.Downtime}}{{end}}.
+{{end}}{{with .Ownership}}
+Team: {{.Team}}{{if .Email}} <{{.Email}}>{{end}}{{if .Slack}}, {{.Slack}} on Slack{{end}}
+{{if .RunbookURL}}Runbook: {{.RunbookURL}}
+{{end}}{{end}}{{if .RunId}}
+Run: {{.RunId}}
+Duration: {{.Duration}}
+Retries: {{.NumberOfRetries}}
+{{end}}{{with .ResultJSON}}Result: {{.}}
+{{end}}{{if .Error}}Error: {{.Error}}
+{{end}}`
+)
+
+// headerReplacer keeps templated values from spanning several header lines.
+var headerReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// EmailConfig configures an EmailNotifier.
+type EmailConfig struct {
+	// Address of the SMTP server, e.g. "smtp.example.com:587".
+	Addr     string
+	Username string
+	Password string
+	From     string
+
+	// Default recipients, used unless the job's settings list its own.
+	To []string
+
+	// Templates of the subject and body, executed with the Event.
+	// The defaults are used if empty.
+	Subject string
+	Body    string
+}
+
+// EmailNotifier sends events as emails over SMTP.
+type EmailNotifier struct {
+	config  EmailConfig
+	subject *template.Template
+	body    *template.Template
+
+	// send is smtp.SendMail, replaced in tests.
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewEmailNotifier returns an EmailNotifier, or an error if its templates don't parse.
+func NewEmailNotifier(config EmailConfig) (*EmailNotifier, error) {
+	if config.Subject == "" {
+		config.Subject = DefaultEmailSubject
+	}
+	if config.Body == "" {
+		config.Body = DefaultEmailBody
+	}
+
+	subject, err := template.New("subject").Parse(config.Subject)
+	if err != nil {
+		return nil, err
+	}
+	body, err := template.New("body").Parse(config.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailNotifier{
+		config:  config,
+		subject: subject,
+		body:    body,
+		send:    smtp.SendMail,
+	}, nil
+}
+
+func (n *EmailNotifier) Notify(e *Event) error {
+	to := n.config.To
+	if e.Settings != nil && len(e.Settings.Emails) != 0 {
+		to = e.Settings.Emails
+	}
+	if e.Type == RunOutput {
+		to = nil
+		if e.Settings != nil {
+			to = e.Settings.MailTo
+		}
+	}
+	if len(to) == 0 {
+		return nil
+	}
+
+	msg, err := n.message(e, to)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		host := n.config.Addr
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, host)
+	}
+	return n.send(n.config.Addr, auth, n.config.From, to, msg)
+}
+
+func (n *EmailNotifier) message(e *Event, to []string) ([]byte, error) {
+	subject := new(bytes.Buffer)
+	if err := n.subject.Execute(subject, e); err != nil {
+		return nil, err
+	}
+	body := new(bytes.Buffer)
+	if e.Type == RunOutput {
+		// Like cron, the body is the output of the run.
+		body.WriteString(e.Output)
+		if e.Error != "" {
+			fmt.Fprintf(body, "\nError: %s\n", e.Error)
+		}
+	} else if err := n.body.Execute(body, e); err != nil {
+		return nil, err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(msg, "To: %s\r\n", headerReplacer.Replace(strings.Join(to, ", ")))
+	fmt.Fprintf(msg, "Subject: %s\r\n", headerReplacer.Replace(subject.String()))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sentMail struct {
+	addr string
+	from string
+	to   []string
+	msg  string
+}
+
+func newTestEmailNotifier(t *testing.T, config EmailConfig) (*EmailNotifier, *[]sentMail) {
+	n, err := NewEmailNotifier(config)
+	assert.NoError(t, err)
+	sent := &[]sentMail{}
+	n.send = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		*sent = append(*sent, sentMail{addr, from, to, string(msg)})
+		return nil
+	}
+	return n, sent
+}
+
+func TestEmailNotifier(t *testing.T) {
+	n, sent := newTestEmailNotifier(t, EmailConfig{
+		Addr: "smtp.example.com:25",
+		From: "kala@example.com",
+		To:   []string{"ops@example.com"},
+	})
+
+	err := n.Notify(&Event{Type: JobFailed, JobId: "id", JobName: "backup", RunId: "run", Error: "exit status 1"})
+	assert.NoError(t, err)
+
+	assert.Len(t, *sent, 1)
+	mail := (*sent)[0]
+	assert.Equal(t, "smtp.example.com:25", mail.addr)
+	assert.Equal(t, []string{"ops@example.com"}, mail.to)
+	assert.Contains(t, mail.msg, "Subject: [kala] Job backup: failure\r\n")
+	assert.Contains(t, mail.msg, "Job backup (id) failed")
+	assert.Contains(t, mail.msg, "Error: exit status 1")
+}
+
+func TestEmailNotifierOutput(t *testing.T) {
+	n, sent := newTestEmailNotifier(t, EmailConfig{To: []string{"ops@example.com"}})
+
+	settings := &Settings{Emails: []string{"oncall@example.com"}, MailTo: []string{"owner@example.com"}}
+	err := n.Notify(&Event{Type: RunOutput, JobName: "backup", Output: "copied 3 files\n", Error: "exit status 1", Settings: settings})
+	assert.NoError(t, err)
+
+	assert.Len(t, *sent, 1)
+	mail := (*sent)[0]
+	assert.Equal(t, []string{"owner@example.com"}, mail.to)
+	assert.Contains(t, mail.msg, "Subject: [kala] Job backup: output\r\n")
+	assert.Contains(t, mail.msg, "\r\n\r\ncopied 3 files\n\nError: exit status 1\n")
+}
+
+func TestEmailNotifierJobRecipients(t *testing.T) {
+	n, sent := newTestEmailNotifier(t, EmailConfig{
+		To:      []string{"ops@example.com"},
+		Subject: "{{.JobName}}\r\nBcc: evil@example.com",
+	})
+
+	err := n.Notify(&Event{Type: JobRecovered, JobName: "backup", Settings: &Settings{Emails: []string{"owner@example.com"}}})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"owner@example.com"}, (*sent)[0].to)
+	assert.NotContains(t, (*sent)[0].msg, "\r\nBcc:")
+}
+
+func TestEmailNotifierRecovery(t *testing.T) {
+	n, sent := newTestEmailNotifier(t, EmailConfig{To: []string{"ops@example.com"}})
+
+	now := time.Now()
+	assert.NoError(t, n.Notify(&Event{Type: JobRecovered, JobId: "id", JobName: "backup", Time: now, ConsecutiveFailures: 2, FailingSince: now.Add(-90 * time.Minute)}))
+	assert.Contains(t, (*sent)[0].msg, "Job backup (id) recovered")
+	assert.Contains(t, (*sent)[0].msg, "It had failed 2 runs in a row over 1h30m0s.")
+}
+
+func TestEmailNotifierResult(t *testing.T) {
+	n, sent := newTestEmailNotifier(t, EmailConfig{To: []string{"ops@example.com"}})
+
+	assert.NoError(t, n.Notify(&Event{Type: JobRecovered, JobName: "backup", Result: map[string]interface{}{"rows": 3}}))
+	assert.Contains(t, (*sent)[0].msg, `Result: {"rows":3}`)
+	assert.NoError(t, n.Notify(&Event{Type: JobRecovered, JobName: "backup"}))
+	assert.NotContains(t, (*sent)[1].msg, "Result:")
+}
+
+func TestEmailNotifierSlowRun(t *testing.T) {
+	n, sent := newTestEmailNotifier(t, EmailConfig{To: []string{"ops@example.com"}})
+
+	assert.NoError(t, n.Notify(&Event{Type: RunSlow, JobId: "id", JobName: "backup", Duration: 5 * time.Minute, Baseline: 90 * time.Second}))
+	assert.Contains(t, (*sent)[0].msg, "Subject: [kala] Job backup: slow\r\n")
+	assert.Contains(t, (*sent)[0].msg, "Job backup (id) ran slow, 5m0s instead of about 1m30s, at ")
+}
+
+func TestEmailNotifierNoRecipients(t *testing.T) {
+	n, sent := newTestEmailNotifier(t, EmailConfig{})
+	assert.NoError(t, n.Notify(&Event{Type: JobFailed}))
+	assert.Len(t, *sent, 0)
+}
+
+func TestEmailNotifierInvalidTemplate(t *testing.T) {
+	_, err := NewEmailNotifier(EmailConfig{Body: "{{.Missing"})
+	assert.Error(t, err)
+}
+
+func TestEmailNotifierOwnership(t *testing.T) {
+	n, sent := newTestEmailNotifier(t, EmailConfig{To: []string{"ops@example.com"}})
+
+	err := n.Notify(&Event{Type: JobFailed, JobName: "backup", Ownership: &Ownership{
+		Team: "data", Email: "data@example.com", Slack: "@data-oncall", RunbookURL: "https://wiki.example.com/backup",
+	}})
+	assert.NoError(t, err)
+
+	assert.Contains(t, (*sent)[0].msg, "Team: data <data@example.com>, @data-oncall on Slack\nRunbook: https://wiki.example.com/backup\n")
+}
@@ -0,0 +1,257 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// PagerDutyEventsURL is the endpoint of the PagerDuty Events API v2.
+	PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	// OpsgenieAPIURL is the Opsgenie API. EU accounts use https://api.eu.opsgenie.com.
+	OpsgenieAPIURL = "https://api.opsgenie.com"
+)
+
+// incidentKey identifies a job's incident, so that failures of the same job
+// are grouped and its recovery resolves it.
+func incidentKey(e *Event) string {
+	return "kala-" + e.JobId
+}
+
+func incidentSummary(e *Event) string {
+	if e.Error != "" {
+		return fmt.Sprintf("Job %s failed: %s", e.JobName, e.Error)
+	}
+	return fmt.Sprintf("Job %s failed", e.JobName)
+}
+
+func incidentDetails(e *Event) map[string]interface{} {
+	details := map[string]interface{}{
+		"job_id":            e.JobId,
+		"job_name":          e.JobName,
+		"owner":             e.Owner,
+		"run_id":            e.RunId,
+		"duration":          e.Duration.String(),
+		"number_of_retries": e.NumberOfRetries,
+		"output":            e.Output,
+	}
+	if len(e.Result) != 0 {
+		details["result"] = e.Result
+	}
+	if e.ConsecutiveFailures != 0 {
+		details["consecutive_failures"] = e.ConsecutiveFailures
+		details["failing_since"] = e.FailingSince.Format(time.RFC3339)
+	}
+	if o := e.Ownership; o != nil {
+		details["team"] = o.Team
+		for k, v := range map[string]string{"team_email": o.Email, "team_slack": o.Slack, "runbook_url": o.RunbookURL} {
+			if v != "" {
+				details[k] = v
+			}
+		}
+	}
+	return details
+}
+
+// PagerDutyNotifier triggers PagerDuty incidents when jobs of at least
+// MinSeverity fail, and resolves them when the jobs recover.
+type PagerDutyNotifier struct {
+	RoutingKey  string
+	MinSeverity string
+
+	client *http.Client
+	url    string
+}
+
+// NewPagerDutyNotifier returns a PagerDutyNotifier for an Events API v2 integration.
+// A minSeverity of "" pages for critical jobs only.
+func NewPagerDutyNotifier(routingKey, minSeverity string) *PagerDutyNotifier {
+	if minSeverity == "" {
+		minSeverity = SeverityCritical
+	}
+	return &PagerDutyNotifier{
+		RoutingKey:  routingKey,
+		MinSeverity: minSeverity,
+		client:      &http.Client{Timeout: DefaultWebhookTimeout},
+		url:         PagerDutyEventsURL,
+	}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     string                 `json:"timestamp"`
+	CustomDetails map[string]interface{} `json:"custom_details"`
+}
+
+func (n *PagerDutyNotifier) Notify(e *Event) error {
+	if !AtLeast(e.Severity, n.MinSeverity) {
+		return nil
+	}
+
+	event := &pagerDutyEvent{
+		RoutingKey: n.RoutingKey,
+		DedupKey:   incidentKey(e),
+	}
+	switch e.Type {
+	case JobFailed:
+		severity := e.Severity
+		if severity == "" {
+			severity = DefaultSeverity
+		}
+		event.EventAction = "trigger"
+		event.Payload = &pagerDutyPayload{
+			Summary:       incidentSummary(e),
+			Source:        "kala",
+			Severity:      severity,
+			Timestamp:     e.Time.Format(time.RFC3339),
+			CustomDetails: incidentDetails(e),
+		}
+		if e.Ownership != nil && e.Ownership.RunbookURL != "" {
+			event.Links = []pagerDutyLink{{Href: e.Ownership.RunbookURL, Text: "Runbook"}}
+		}
+	case JobRecovered:
+		event.EventAction = "resolve"
+	default:
+		return nil
+	}
+
+	return postIncidentJSON(n.client, n.url, nil, event)
+}
+
+// OpsgenieNotifier creates Opsgenie alerts when jobs of at least MinSeverity
+// fail, and closes them when the jobs recover.
+type OpsgenieNotifier struct {
+	APIKey      string
+	MinSeverity string
+
+	client *http.Client
+	url    string
+}
+
+// NewOpsgenieNotifier returns an OpsgenieNotifier. An apiURL of "" uses OpsgenieAPIURL,
+// and a minSeverity of "" alerts for critical jobs only.
+func NewOpsgenieNotifier(apiKey, apiURL, minSeverity string) *OpsgenieNotifier {
+	if apiURL == "" {
+		apiURL = OpsgenieAPIURL
+	}
+	if minSeverity == "" {
+		minSeverity = SeverityCritical
+	}
+	return &OpsgenieNotifier{
+		APIKey:      apiKey,
+		MinSeverity: minSeverity,
+		client:      &http.Client{Timeout: DefaultWebhookTimeout},
+		url:         apiURL,
+	}
+}
+
+var opsgeniePriorities = map[string]string{
+	SeverityCritical: "P1",
+	SeverityError:    "P2",
+	SeverityWarning:  "P3",
+	SeverityInfo:     "P5",
+}
+
+type opsgenieAlert struct {
+	Message     string            `json:"message"`
+	Alias       string            `json:"alias"`
+	Description string            `json:"description,omitempty"`
+	Priority    string            `json:"priority"`
+	Source      string            `json:"source"`
+	Tags        []string          `json:"tags,omitempty"`
+	Details     map[string]string `json:"details"`
+}
+
+type opsgenieClose struct {
+	Source string `json:"source"`
+	Note   string `json:"note"`
+}
+
+func (n *OpsgenieNotifier) Notify(e *Event) error {
+	if !AtLeast(e.Severity, n.MinSeverity) {
+		return nil
+	}
+
+	header := http.Header{"Authorization": {"GenieKey " + n.APIKey}}
+	switch e.Type {
+	case JobFailed:
+		severity := e.Severity
+		if severity == "" {
+			severity = DefaultSeverity
+		}
+		details := map[string]string{}
+		for k, v := range incidentDetails(e) {
+			if k != "output" {
+				details[k] = fmt.Sprint(v)
+			}
+		}
+		return postIncidentJSON(n.client, n.url+"/v2/alerts", header, &opsgenieAlert{
+			Message:     truncate(incidentSummary(e), 130),
+			Alias:       incidentKey(e),
+			Description: e.Output,
+			Priority:    opsgeniePriorities[severity],
+			Source:      "kala",
+			Tags:        e.Tags,
+			Details:     details,
+		})
+	case JobRecovered:
+		closeURL := fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", n.url, url.PathEscape(incidentKey(e)))
+		return postIncidentJSON(n.client, closeURL, header, &opsgenieClose{
+			Source: "kala",
+			Note:   e.recovered(),
+		})
+	}
+	return nil
+}
+
+// truncate shortens s to at most n bytes, as Opsgenie rejects longer messages.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+func postIncidentJSON(client *http.Client, endpoint string, header http.Header, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type incidentRequest struct {
+	path  string
+	query string
+	auth  string
+	body  map[string]interface{}
+}
+
+func incidentServer(t *testing.T) (*httptest.Server, chan incidentRequest) {
+	requests := make(chan incidentRequest, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := incidentRequest{path: r.URL.Path, query: r.URL.RawQuery, auth: r.Header.Get("Authorization")}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req.body))
+		requests <- req
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return ts, requests
+}
+
+func TestAtLeast(t *testing.T) {
+	assert.True(t, AtLeast(SeverityCritical, SeverityCritical))
+	assert.True(t, AtLeast(SeverityCritical, SeverityWarning))
+	assert.False(t, AtLeast(SeverityWarning, SeverityError))
+	assert.True(t, AtLeast("", SeverityError))
+	assert.False(t, AtLeast("", SeverityCritical))
+}
+
+func TestPagerDutyNotifier(t *testing.T) {
+	ts, requests := incidentServer(t)
+	defer ts.Close()
+
+	n := NewPagerDutyNotifier("routing-key", "")
+	n.url = ts.URL
+
+	// Not critical, doesn't page.
+	assert.NoError(t, n.Notify(&Event{Type: JobFailed, JobId: "id", Severity: SeverityError}))
+
+	assert.NoError(t, n.Notify(&Event{Type: JobFailed, JobId: "id", JobName: "backup", Severity: SeverityCritical, Error: "exit status 1"}))
+	req := <-requests
+	assert.Equal(t, "trigger", req.body["event_action"])
+	assert.Equal(t, "routing-key", req.body["routing_key"])
+	assert.Equal(t, "kala-id", req.body["dedup_key"])
+	payload := req.body["payload"].(map[string]interface{})
+	assert.Equal(t, "Job backup failed: exit status 1", payload["summary"])
+	assert.Equal(t, "critical", payload["severity"])
+
+	assert.NoError(t, n.Notify(&Event{Type: JobRecovered, JobId: "id", Severity: SeverityCritical}))
+	req = <-requests
+	assert.Equal(t, "resolve", req.body["event_action"])
+	assert.Equal(t, "kala-id", req.body["dedup_key"])
+
+	// The team owning the job and its runbook are in the incident.
+	ownership := &Ownership{Team: "data", Slack: "#data", RunbookURL: "https://wiki.example.com/backup"}
+	assert.NoError(t, n.Notify(&Event{Type: JobFailed, JobId: "id", Severity: SeverityCritical, Ownership: ownership}))
+	req = <-requests
+	details := req.body["payload"].(map[string]interface{})["custom_details"].(map[string]interface{})
+	assert.Equal(t, "data", details["team"])
+	assert.Equal(t, "#data", details["team_slack"])
+	assert.Nil(t, details["team_email"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"href": "https://wiki.example.com/backup", "text": "Runbook"}}, req.body["links"])
+
+	assert.Len(t, requests, 0)
+}
+
+func TestOpsgenieNotifier(t *testing.T) {
+	ts, requests := incidentServer(t)
+	defer ts.Close()
+
+	n := NewOpsgenieNotifier("api-key", ts.URL, SeverityError)
+
+	assert.NoError(t, n.Notify(&Event{Type: JobFailed, JobId: "id", JobName: "backup", Tags: []string{"nightly"}}))
+	req := <-requests
+	assert.Equal(t, "/v2/alerts", req.path)
+	assert.Equal(t, "GenieKey api-key", req.auth)
+	assert.Equal(t, "kala-id", req.body["alias"])
+	assert.Equal(t, "P2", req.body["priority"])
+	assert.Equal(t, "Job backup failed", req.body["message"])
+
+	assert.NoError(t, n.Notify(&Event{Type: JobRecovered, JobId: "id", JobName: "backup", ConsecutiveFailures: 1}))
+	req = <-requests
+	assert.Equal(t, "/v2/alerts/kala-id/close", req.path)
+	assert.Equal(t, "identifierType=alias", req.query)
+	assert.Equal(t, "Job backup recovered after 1 failed run", req.body["note"])
+
+	assert.NoError(t, n.Notify(&Event{Type: JobDisabled, JobId: "id"}))
+	assert.Len(t, requests, 0)
+}
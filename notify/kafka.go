@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Formats of published events.
+const (
+	JSONFormat = "json"
+	AvroFormat = "avro"
+)
+
+// KafkaConfig configures a KafkaPublisher.
+type KafkaConfig struct {
+	Brokers []string
+	Topic   string
+
+	// JSONFormat (the default) or AvroFormat.
+	Format string
+	// Schema Registry id of EventAvroSchema. If set, Avro messages
+	// are framed in the Confluent wire format.
+	AvroSchemaId uint32
+}
+
+// messageWriter is implemented by kafka.Writer.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// KafkaPublisher publishes events to a Kafka topic, keyed by job id so
+// that the events of a job stay in order.
+type KafkaPublisher struct {
+	config KafkaConfig
+	writer messageWriter
+}
+
+// NewKafkaPublisher returns a KafkaPublisher. The connection to the brokers
+// is established with the first event.
+func NewKafkaPublisher(config KafkaConfig) (*KafkaPublisher, error) {
+	if len(config.Brokers) == 0 || config.Topic == "" {
+		return nil, fmt.Errorf("Kafka needs brokers and a topic")
+	}
+	if config.Format == "" {
+		config.Format = JSONFormat
+	}
+	if config.Format != JSONFormat && config.Format != AvroFormat {
+		return nil, fmt.Errorf("Unknown event format %q", config.Format)
+	}
+
+	writer := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      config.Brokers,
+		Topic:        config.Topic,
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: 10 * time.Millisecond,
+	})
+	return &KafkaPublisher{config: config, writer: writer}, nil
+}
+
+func (p *KafkaPublisher) Notify(e *Event) error {
+	value, err := p.encode(e)
+	if err != nil {
+		return err
+	}
+	return p.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   []byte(e.JobId),
+		Value: value,
+		Time:  e.Time,
+	})
+}
+
+func (p *KafkaPublisher) encode(e *Event) ([]byte, error) {
+	if p.config.Format == AvroFormat {
+		return EncodeAvro(e, p.config.AvroSchemaId), nil
+	}
+	return json.Marshal(e)
+}
+
+// Close flushes pending messages and closes the connections to the brokers.
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWriter struct {
+	messages []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error { return nil }
+
+func TestNewKafkaPublisherInvalid(t *testing.T) {
+	_, err := NewKafkaPublisher(KafkaConfig{Topic: "kala"})
+	assert.Error(t, err)
+	_, err = NewKafkaPublisher(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "kala", Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	p, err := NewKafkaPublisher(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "kala"})
+	assert.NoError(t, err)
+	writer := &fakeWriter{}
+	p.writer = writer
+
+	assert.NoError(t, p.Notify(&Event{Type: RunStarted, JobId: "id"}))
+	assert.Len(t, writer.messages, 1)
+	assert.Equal(t, "id", string(writer.messages[0].Key))
+	var e Event
+	assert.NoError(t, json.Unmarshal(writer.messages[0].Value, &e))
+	assert.Equal(t, RunStarted, e.Type)
+
+	p.config.Format = AvroFormat
+	assert.NoError(t, p.Notify(&Event{Type: RunStarted, JobId: "id"}))
+	assert.Equal(t, EncodeAvro(&Event{Type: RunStarted, JobId: "id"}, 0), writer.messages[1].Value)
+}
@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS publishes events to NATS subjects, and subscribes message-triggered
+// jobs to theirs, as a job.MessageSubscriber.
+type NATS struct {
+	conn          *nats.Conn
+	subjectPrefix string
+	queueGroup    string
+}
+
+// NewNATS connects to the NATS servers at url, which may be a comma separated
+// list. Events are published to "<subjectPrefix>.<type>.<job id>". Messages
+// triggering jobs are received by one member of the queue group, so that
+// several instances of kala can share a subject.
+func NewNATS(url, subjectPrefix, queueGroup string) (*NATS, error) {
+	conn, err := nats.Connect(url, nats.Name("kala"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{
+		conn:          conn,
+		subjectPrefix: subjectPrefix,
+		queueGroup:    queueGroup,
+	}, nil
+}
+
+// natsSubjectReplacer removes the token separator and wildcards from subject tokens.
+var natsSubjectReplacer = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_")
+
+func (n *NATS) subject(e *Event) string {
+	return n.subjectPrefix + "." + string(e.Type) + "." + natsSubjectReplacer.Replace(e.JobId)
+}
+
+func (n *NATS) Notify(e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return n.conn.Publish(n.subject(e), data)
+}
+
+func (n *NATS) Subscribe(subject string, handler func(data []byte)) (func() error, error) {
+	sub, err := n.conn.QueueSubscribe(subject, n.queueGroup, func(msg *nats.Msg) {
+		handler(msg.Data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sub.Unsubscribe, nil
+}
+
+// Close flushes pending events and closes the connection.
+func (n *NATS) Close() {
+	n.conn.Flush()
+	n.conn.Close()
+}
@@ -0,0 +1,13 @@
+package notify
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNATSSubject(t *testing.T) {
+	n := &NATS{subjectPrefix: "kala.events"}
+	assert.Equal(t, "kala.events.failure.93b65499-b211", n.subject(&Event{Type: JobFailed, JobId: "93b65499-b211"}))
+	assert.Equal(t, "kala.events.started.a_b_", n.subject(&Event{Type: RunStarted, JobId: "a.b*"}))
+}
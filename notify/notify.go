@@ -0,0 +1,476 @@
+// Package notify sends notifications about job events, such as failures and
+// recoveries, to channels like webhooks and email, and publishes every job
+// lifecycle and run event to publishers like Kafka.
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ajvb/kala/utils/logging"
+)
+
+var log = logging.GetLogger(logging.Notify)
+
+// EventType is the kind of a job event.
+type EventType string
+
+const (
+	// JobFailed is sent when a run failed after all of its retries.
+	JobFailed EventType = "failure"
+	// JobRecovered is sent when a run succeeded after the previous one failed.
+	JobRecovered EventType = "recovery"
+	// JobDisabled is sent when a job is disabled.
+	JobDisabled EventType = "disabled"
+	// RunSlow is sent when a run took much longer than the usual runs of the
+	// job. Only jobs whose settings list it are notified about it.
+	RunSlow EventType = "slow"
+	// RunOutput is sent after the runs of jobs with a MAILTO, only to their
+	// MailTo recipients, see Settings.Mails.
+	RunOutput EventType = "output"
+
+	// Lifecycle and run events, which are only sent to publishers.
+	JobCreated   EventType = "created"
+	JobUpdated   EventType = "updated"
+	JobDeleted   EventType = "deleted"
+	JobEnabled   EventType = "enabled"
+	RunStarted   EventType = "started"
+	RunSucceeded EventType = "success"
+	// RunMisfired is sent when a scheduled run is due later than its job's
+	// misfire tolerance, telling what was done about it.
+	RunMisfired EventType = "misfire"
+	// ClockJumped is sent when the wall clock jumped, e.g. as NTP stepped
+	// it, and the jobs were rescheduled. It isn't about a job.
+	ClockJumped EventType = "clock_jump"
+	// ScheduleDrifted is sent when a scheduled run started later after it
+	// was due than the threshold, as the scheduler is overloaded.
+	ScheduleDrifted EventType = "schedule_drift"
+)
+
+// IsNotification returns whether events of the type are sent to notifiers,
+// rather than to publishers only.
+func (t EventType) IsNotification() bool {
+	return t == JobFailed || t == JobRecovered || t == JobDisabled || t == RunSlow
+}
+
+// Severities of jobs' events, from most to least severe.
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+
+	// DefaultSeverity is the severity of jobs which don't set one.
+	DefaultSeverity = SeverityError
+)
+
+var severityRanks = map[string]int{
+	SeverityCritical: 4,
+	SeverityError:    3,
+	SeverityWarning:  2,
+	SeverityInfo:     1,
+}
+
+// ValidSeverity returns whether s is one of the severities, or empty.
+func ValidSeverity(s string) bool {
+	_, ok := severityRanks[s]
+	return ok || s == ""
+}
+
+// AtLeast returns whether severity s is as severe as min.
+func AtLeast(s, min string) bool {
+	if s == "" {
+		s = DefaultSeverity
+	}
+	return severityRanks[s] >= severityRanks[min]
+}
+
+// Settings are the per-job notification settings.
+type Settings struct {
+	// Only notify about these events. Notifies about all events if empty.
+	Events []EventType `json:"events,omitempty"`
+
+	// Email recipients, overriding the server's default recipients.
+	Emails []string `json:"emails,omitempty"`
+
+	// Webhooks which are called in addition to the server's webhooks.
+	Webhooks []string `json:"webhooks,omitempty"`
+
+	// Slack channel, overriding the channels of the server and the job's tags.
+	SlackChannel string `json:"slack_channel,omitempty"`
+
+	// Severity of the job's failures. Only critical failures page by default.
+	Severity string `json:"severity,omitempty"`
+
+	// Email the output of the job's runs to these addresses, like cron's
+	// MAILTO. MailOn tells which runs: those with output by default, as cron
+	// does, always, or only failures.
+	MailTo []string `json:"mailto,omitempty"`
+	MailOn string   `json:"mail_on,omitempty"`
+}
+
+// When the output of runs is emailed to MailTo.
+const (
+	MailOnOutput  = "output"
+	MailOnAlways  = "always"
+	MailOnFailure = "failure"
+)
+
+// ValidMailOn returns whether s is one of the MailOn values, or empty.
+func ValidMailOn(s string) bool {
+	return s == "" || s == MailOnOutput || s == MailOnAlways || s == MailOnFailure
+}
+
+// Mails returns whether the output of a run is emailed to MailTo.
+func (s *Settings) Mails(failed bool, output string) bool {
+	if s == nil || len(s.MailTo) == 0 {
+		return false
+	}
+	switch s.MailOn {
+	case MailOnAlways:
+		return true
+	case MailOnFailure:
+		return failed
+	}
+	return output != ""
+}
+
+// GetSeverity returns the severity of the job's events.
+func (s *Settings) GetSeverity() string {
+	if s == nil || s.Severity == "" {
+		return DefaultSeverity
+	}
+	return s.Severity
+}
+
+// Wants returns whether notifications about events of type t should be sent.
+// Slow runs are only notified about if listed.
+func (s *Settings) Wants(t EventType) bool {
+	if s == nil || len(s.Events) == 0 {
+		return t != RunSlow
+	}
+	for _, e := range s.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Ownership tells who owns a job and how to reach them, so that whoever gets
+// notified about it knows who to turn to.
+type Ownership struct {
+	Team string `json:"team"`
+	// Email of the team, e.g. "data-team@example.com".
+	Email string `json:"email,omitempty"`
+	// Slack handle or channel of the team, e.g. "@data-oncall" or "#data".
+	Slack string `json:"slack,omitempty"`
+	// Url of the runbook of the job, telling what to do when it fails.
+	RunbookURL string `json:"runbook_url,omitempty"`
+}
+
+// Event is a notification about a job.
+type Event struct {
+	Type    EventType `json:"type"`
+	JobId   string    `json:"job_id"`
+	JobName string    `json:"job_name"`
+	Owner   string    `json:"owner"`
+	Tags    []string  `json:"tags,omitempty"`
+	Time    time.Time `json:"time"`
+
+	// Team owning the job, if it set one.
+	Ownership *Ownership `json:"ownership,omitempty"`
+
+	// Severity of the job, see Settings.
+	Severity string `json:"severity"`
+
+	// Number of consecutive failed runs of the job. For recoveries, the
+	// number of failed runs before the recovery.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// When the first of the consecutive failed runs started, if any.
+	FailingSince time.Time `json:"failing_since"`
+
+	// Details of the run which caused the event, if any.
+	RunId           string        `json:"run_id,omitempty"`
+	RequestId       string        `json:"request_id,omitempty"`
+	Duration        time.Duration `json:"duration,omitempty"`
+	NumberOfRetries uint          `json:"number_of_retries,omitempty"`
+	Error           string        `json:"error,omitempty"`
+	Output          string        `json:"output,omitempty"`
+	// Structured result of the run, if the job parses one.
+	Result map[string]interface{} `json:"result,omitempty"`
+	// Usual duration of the job's runs, for slow runs.
+	Baseline time.Duration `json:"baseline,omitempty"`
+	// The missed run, for misfires.
+	Misfire *Misfire `json:"misfire,omitempty"`
+	// How far the wall clock jumped, and the number of jobs rescheduled, for
+	// clock jumps.
+	ClockJump   time.Duration `json:"clock_jump,omitempty"`
+	Rescheduled int           `json:"rescheduled,omitempty"`
+	// How long after it was due the run started, for schedule drifts.
+	Drift time.Duration `json:"drift,omitempty"`
+
+	// The job's notification settings.
+	Settings *Settings `json:"-"`
+}
+
+// Misfire is a scheduled run which was due later than its job's tolerance,
+// and the action of the job's misfire policy, e.g. "skip".
+type Misfire struct {
+	ScheduledAt time.Time     `json:"scheduled_at"`
+	Late        time.Duration `json:"late"`
+	Action      string        `json:"action"`
+}
+
+// ResultJSON returns the result of the run as JSON, or "" if it has none.
+func (e *Event) ResultJSON() string {
+	if len(e.Result) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(e.Result)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// Downtime returns how long the job had been failing at the time of the
+// event, to the second, or 0 if it wasn't failing.
+func (e *Event) Downtime() time.Duration {
+	if e.FailingSince.IsZero() {
+		return 0
+	}
+	d := e.Time.Sub(e.FailingSince)
+	return d - d%time.Second
+}
+
+// recovered returns the title of recovery events, telling how long the job
+// failed, e.g. "Job backup recovered after 3 failed runs over 2h0m0s".
+func (e *Event) recovered() string {
+	title := fmt.Sprintf("Job %s recovered", e.JobName)
+	if streak := e.streak(); streak != "" {
+		title += " after " + streak
+	}
+	return title
+}
+
+// streak describes the failed runs in a row of the event, e.g. "3 failed
+// runs over 2h0m0s", or returns "" if there are none.
+func (e *Event) streak() string {
+	if e.ConsecutiveFailures == 0 {
+		return ""
+	}
+	streak := fmt.Sprintf("%d failed runs", e.ConsecutiveFailures)
+	if e.ConsecutiveFailures == 1 {
+		streak = "1 failed run"
+	}
+	if !e.FailingSince.IsZero() {
+		streak += fmt.Sprintf(" over %s", e.Downtime())
+	}
+	return streak
+}
+
+// slow returns the title of slow run events, e.g. "Job backup ran slow:
+// 5m0s instead of about 1m30s".
+func (e *Event) slow() string {
+	return fmt.Sprintf("Job %s ran slow: %s instead of about %s", e.JobName, e.Duration-e.Duration%time.Second, e.Baseline-e.Baseline%time.Second)
+}
+
+// Notifier is implemented by notification channels.
+type Notifier interface {
+	Notify(e *Event) error
+}
+
+// Names of the channels of the built-in notifiers, as used in routing rules.
+const (
+	WebhookChannel   = "webhook"
+	EmailChannel     = "email"
+	SlackChannel     = "slack"
+	PagerDutyChannel = "pagerduty"
+	OpsgenieChannel  = "opsgenie"
+)
+
+type namedNotifier struct {
+	name string
+	Notifier
+}
+
+// PublishQueueSize is the number of events buffered for each publisher.
+// Events are dropped while a publisher's queue is full.
+var PublishQueueSize = 1000
+
+type publisher struct {
+	name   string
+	events chan *Event
+}
+
+// Dispatcher sends notifications to a set of Notifiers, and all events to
+// a set of publishers.
+type Dispatcher struct {
+	lock       sync.RWMutex
+	notifiers  []namedNotifier
+	publishers []publisher
+	router     *Router
+	wg         sync.WaitGroup
+}
+
+// NewDispatcher returns a Dispatcher sending events to the given notifiers.
+// These are unnamed, and receive all events regardless of routing rules.
+func NewDispatcher(notifiers ...Notifier) *Dispatcher {
+	d := &Dispatcher{}
+	for _, n := range notifiers {
+		d.notifiers = append(d.notifiers, namedNotifier{Notifier: n})
+	}
+	return d
+}
+
+// Add adds a Notifier to the Dispatcher under the given channel name.
+func (d *Dispatcher) Add(name string, n Notifier) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.notifiers = append(d.notifiers, namedNotifier{name, n})
+}
+
+// AddPublisher adds a Notifier which is sent every event, including lifecycle
+// and run events, regardless of job settings and routing rules. Events are
+// sent to it one at a time, in the order they were dispatched.
+func (d *Dispatcher) AddPublisher(name string, n Notifier) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	p := publisher{name, make(chan *Event, PublishQueueSize)}
+	d.publishers = append(d.publishers, p)
+	go func() {
+		for e := range p.events {
+			if err := n.Notify(e); err != nil {
+				log.WithField("job_id", e.JobId).Errorf("Error publishing %s event to %s: %s", e.Type, p.name, err)
+			}
+			d.wg.Done()
+		}
+	}()
+}
+
+// SetRouter makes the Dispatcher send events only to the channels picked by
+// the router. It fails if the router refers to channels which weren't added.
+func (d *Dispatcher) SetRouter(r *Router) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	names := map[string]bool{}
+	for _, n := range d.notifiers {
+		names[n.name] = true
+	}
+	for _, channel := range r.channels() {
+		if !names[channel] {
+			return fmt.Errorf("Routing rules refer to channel %q, which isn't configured", channel)
+		}
+	}
+	d.router = r
+	return nil
+}
+
+// ReplaceNotifiers replaces the Notifiers and the Router of the Dispatcher
+// with those of another one, e.g. built from a reloaded configuration. Its
+// publishers are kept, and notifications being sent aren't interrupted.
+func (d *Dispatcher) ReplaceNotifiers(from *Dispatcher) {
+	from.lock.RLock()
+	notifiers, router := from.notifiers, from.router
+	from.lock.RUnlock()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.notifiers, d.router = notifiers, router
+}
+
+// Dispatch queues the event for the publishers and, if it is a notification,
+// sends it to the Notifiers in the background, unless the job's settings opt
+// out of events of its type. If a Router is set, named Notifiers only receive
+// the events routed to them. Errors are logged.
+func (d *Dispatcher) Dispatch(e *Event) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for _, p := range d.publishers {
+		d.wg.Add(1)
+		select {
+		case p.events <- e:
+		default:
+			d.wg.Done()
+			log.WithField("job_id", e.JobId).Warnf("Dropped %s event, as the queue of %s is full", e.Type, p.name)
+		}
+	}
+
+	if e.Type == RunOutput {
+		d.mail(e)
+		return
+	}
+	if !e.Type.IsNotification() || !e.Settings.Wants(e.Type) {
+		return
+	}
+
+	var routed map[string]bool
+	if d.router != nil {
+		routed = d.router.Route(e)
+	}
+	for _, n := range d.notifiers {
+		if d.router != nil && n.name != "" && !routed[n.name] {
+			continue
+		}
+		d.wg.Add(1)
+		go func(n Notifier) {
+			defer d.wg.Done()
+			if err := n.Notify(e); err != nil {
+				log.WithField("job_id", e.JobId).Errorf("Error sending %s notification: %s", e.Type, err)
+			}
+		}(n.Notifier)
+	}
+}
+
+// mail sends an output event to the email notifiers, regardless of the
+// routing rules, as the job's settings tell the recipients. The lock must be
+// held.
+func (d *Dispatcher) mail(e *Event) {
+	for _, n := range d.notifiers {
+		if n.name != EmailChannel {
+			continue
+		}
+		d.wg.Add(1)
+		go func(n Notifier) {
+			defer d.wg.Done()
+			if err := n.Notify(e); err != nil {
+				log.WithField("job_id", e.JobId).Errorf("Error mailing the output of run %s: %s", e.RunId, err)
+			}
+		}(n.Notifier)
+	}
+}
+
+// Wait blocks until all dispatched events have been sent.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+var (
+	defaultLock       sync.RWMutex
+	defaultDispatcher = NewDispatcher()
+)
+
+// SetDefault replaces the Dispatcher used by the package-level functions.
+func SetDefault(d *Dispatcher) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	defaultDispatcher = d
+}
+
+// Default returns the Dispatcher used by the package-level functions.
+func Default() *Dispatcher {
+	defaultLock.RLock()
+	defer defaultLock.RUnlock()
+	return defaultDispatcher
+}
+
+// Dispatch sends the event using the default Dispatcher.
+func Dispatch(e *Event) {
+	Default().Dispatch(e)
+}
@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingNotifier struct {
+	lock   sync.Mutex
+	events []*Event
+	err    error
+}
+
+func (n *recordingNotifier) Notify(e *Event) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.events = append(n.events, e)
+	return n.err
+}
+
+func TestSettingsWants(t *testing.T) {
+	var s *Settings
+	assert.True(t, s.Wants(JobFailed))
+
+	s = &Settings{}
+	assert.True(t, s.Wants(JobDisabled))
+	// Slow runs are opt-in.
+	assert.False(t, s.Wants(RunSlow))
+	assert.True(t, (&Settings{Events: []EventType{RunSlow}}).Wants(RunSlow))
+
+	s = &Settings{Events: []EventType{JobFailed}}
+	assert.True(t, s.Wants(JobFailed))
+	assert.False(t, s.Wants(JobRecovered))
+}
+
+func TestSettingsMails(t *testing.T) {
+	var s *Settings
+	assert.False(t, s.Mails(true, "output"))
+
+	// Like cron, runs with output are mailed by default.
+	s = &Settings{MailTo: []string{"owner@example.com"}}
+	assert.True(t, s.Mails(false, "output"))
+	assert.False(t, s.Mails(true, ""))
+
+	s.MailOn = MailOnAlways
+	assert.True(t, s.Mails(false, ""))
+	s.MailOn = MailOnFailure
+	assert.True(t, s.Mails(true, ""))
+	assert.False(t, s.Mails(false, "output"))
+
+	assert.True(t, ValidMailOn(""))
+	assert.False(t, ValidMailOn("never"))
+}
+
+func TestDispatcherMailsOutput(t *testing.T) {
+	webhook := &recordingNotifier{}
+	email := &recordingNotifier{}
+	d := NewDispatcher()
+	d.Add(WebhookChannel, webhook)
+	d.Add(EmailChannel, email)
+	// Output is mailed regardless of the routing rules.
+	assert.NoError(t, d.SetRouter(&Router{Default: []string{WebhookChannel}}))
+
+	d.Dispatch(&Event{Type: RunOutput, JobId: "id", Settings: &Settings{Events: []EventType{JobFailed}, MailTo: []string{"owner@example.com"}}})
+	d.Wait()
+
+	assert.Len(t, webhook.events, 0)
+	assert.Len(t, email.events, 1)
+}
+
+func TestDispatcher(t *testing.T) {
+	first := &recordingNotifier{}
+	second := &recordingNotifier{err: errors.New("unreachable")}
+	d := NewDispatcher(first)
+	d.Add(WebhookChannel, second)
+
+	d.Dispatch(&Event{Type: JobFailed, JobId: "id"})
+	d.Dispatch(&Event{Type: JobRecovered, JobId: "id", Settings: &Settings{Events: []EventType{JobFailed}}})
+	d.Wait()
+
+	assert.Len(t, first.events, 1)
+	assert.Len(t, second.events, 1)
+	assert.Equal(t, JobFailed, first.events[0].Type)
+}
+
+func TestDispatcherPublishers(t *testing.T) {
+	notifier := &recordingNotifier{}
+	publisher := &recordingNotifier{}
+	d := NewDispatcher(notifier)
+	d.AddPublisher("kafka", publisher)
+
+	for _, typ := range []EventType{JobCreated, RunStarted, JobFailed, RunStarted, RunSucceeded, JobRecovered} {
+		d.Dispatch(&Event{Type: typ, Settings: &Settings{Events: []EventType{JobFailed}}})
+	}
+	d.Wait()
+
+	assert.Len(t, notifier.events, 1)
+	assert.Len(t, publisher.events, 6)
+	assert.Equal(t, JobCreated, publisher.events[0].Type)
+	assert.Equal(t, JobRecovered, publisher.events[5].Type)
+}
+
+func TestDispatcherReplaceNotifiers(t *testing.T) {
+	old := &recordingNotifier{}
+	replacement := &recordingNotifier{}
+	publisher := &recordingNotifier{}
+	d := NewDispatcher()
+	d.Add(WebhookChannel, old)
+	d.AddPublisher("kafka", publisher)
+
+	reloaded := NewDispatcher()
+	reloaded.Add(SlackChannel, replacement)
+	d.ReplaceNotifiers(reloaded)
+
+	d.Dispatch(&Event{Type: JobFailed, JobId: "id"})
+	d.Wait()
+
+	assert.Len(t, old.events, 0)
+	assert.Len(t, replacement.events, 1)
+	assert.Len(t, publisher.events, 1)
+}
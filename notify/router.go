@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Selector matches events. Empty fields match all events.
+type Selector struct {
+	// Matches jobs with any of the tags.
+	Tags []string `json:"tags,omitempty"`
+	// Matches jobs of any of the owners.
+	Owners []string `json:"owners,omitempty"`
+	// Matches events of any of the types.
+	Events []EventType `json:"events,omitempty"`
+	// Matches jobs at least as severe.
+	MinSeverity string `json:"min_severity,omitempty"`
+	// Matches jobs which failed at least as many times in a row.
+	MinConsecutiveFailures int `json:"min_consecutive_failures,omitempty"`
+}
+
+// Matches returns whether the event is selected.
+func (s *Selector) Matches(e *Event) bool {
+	if len(s.Tags) != 0 && !containsAny(s.Tags, e.Tags) {
+		return false
+	}
+	if len(s.Owners) != 0 && !containsAny(s.Owners, []string{e.Owner}) {
+		return false
+	}
+	if len(s.Events) != 0 {
+		found := false
+		for _, t := range s.Events {
+			found = found || t == e.Type
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.MinSeverity != "" && !AtLeast(e.Severity, s.MinSeverity) {
+		return false
+	}
+	return e.ConsecutiveFailures >= s.MinConsecutiveFailures
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, v := range values {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Rule sends the events matched by its selector to its channels.
+type Rule struct {
+	Match    Selector `json:"match"`
+	Channels []string `json:"channels"`
+}
+
+// Router picks the channels of an event using routing rules, so that
+// notifications don't have to be configured on every job.
+type Router struct {
+	// Every matching rule adds its channels.
+	Rules []Rule `json:"rules"`
+
+	// Channels of events matched by no rule.
+	Default []string `json:"default"`
+}
+
+// Route returns the names of the channels the event is sent to.
+func (r *Router) Route(e *Event) map[string]bool {
+	routed := map[string]bool{}
+	for i := range r.Rules {
+		if r.Rules[i].Match.Matches(e) {
+			for _, channel := range r.Rules[i].Channels {
+				routed[channel] = true
+			}
+		}
+	}
+	if len(routed) == 0 {
+		for _, channel := range r.Default {
+			routed[channel] = true
+		}
+	}
+	return routed
+}
+
+func (r *Router) channels() []string {
+	channels := append([]string{}, r.Default...)
+	for _, rule := range r.Rules {
+		channels = append(channels, rule.Channels...)
+	}
+	return channels
+}
+
+// ParseRouter reads routing rules in JSON.
+func ParseRouter(reader io.Reader) (*Router, error) {
+	r := &Router{}
+	if err := json.NewDecoder(reader).Decode(r); err != nil {
+		return nil, err
+	}
+	for i, rule := range r.Rules {
+		if len(rule.Channels) == 0 {
+			return nil, fmt.Errorf("Routing rule %d has no channels", i)
+		}
+		if !ValidSeverity(rule.Match.MinSeverity) {
+			return nil, fmt.Errorf("Routing rule %d has unknown severity %q", i, rule.Match.MinSeverity)
+		}
+	}
+	return r, nil
+}
+
+// LoadRouter reads routing rules from a JSON file.
+func LoadRouter(path string) (*Router, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseRouter(f)
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRoutes = `{
+	"rules": [
+		{"match": {"tags": ["billing"]}, "channels": ["slack"]},
+		{"match": {"min_severity": "critical", "min_consecutive_failures": 3, "events": ["failure", "recovery"]}, "channels": ["pagerduty"]},
+		{"match": {"owners": ["dba@example.com"]}, "channels": ["email"]}
+	],
+	"default": ["webhook"]
+}`
+
+func TestRouterRoute(t *testing.T) {
+	r, err := ParseRouter(strings.NewReader(testRoutes))
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]bool{"webhook": true}, r.Route(&Event{Type: JobFailed}))
+	assert.Equal(t, map[string]bool{"slack": true}, r.Route(&Event{Type: JobFailed, Tags: []string{"nightly", "billing"}}))
+
+	critical := &Event{Type: JobFailed, Severity: SeverityCritical, ConsecutiveFailures: 2}
+	assert.Equal(t, map[string]bool{"webhook": true}, r.Route(critical))
+	critical.ConsecutiveFailures = 3
+	assert.Equal(t, map[string]bool{"pagerduty": true}, r.Route(critical))
+	critical.Type = JobDisabled
+	assert.Equal(t, map[string]bool{"webhook": true}, r.Route(critical))
+
+	both := &Event{Type: JobRecovered, Owner: "dba@example.com", Tags: []string{"billing"}}
+	assert.Equal(t, map[string]bool{"slack": true, "email": true}, r.Route(both))
+}
+
+func TestParseRouterInvalid(t *testing.T) {
+	_, err := ParseRouter(strings.NewReader(`{"rules": [{"match": {"tags": ["a"]}}]}`))
+	assert.Error(t, err)
+
+	_, err = ParseRouter(strings.NewReader(`{"rules": [{"match": {"min_severity": "urgent"}, "channels": ["slack"]}]}`))
+	assert.Error(t, err)
+
+	_, err = ParseRouter(strings.NewReader(`{"rules": [`))
+	assert.Error(t, err)
+}
+
+func TestDispatcherRouting(t *testing.T) {
+	slack := &recordingNotifier{}
+	email := &recordingNotifier{}
+	unnamed := &recordingNotifier{}
+	d := NewDispatcher(unnamed)
+	d.Add(SlackChannel, slack)
+	d.Add(EmailChannel, email)
+
+	r, err := ParseRouter(strings.NewReader(`{"rules": [{"match": {"tags": ["billing"]}, "channels": ["slack"]}], "default": ["email"]}`))
+	assert.NoError(t, err)
+	assert.NoError(t, d.SetRouter(r))
+
+	d.Dispatch(&Event{Type: JobFailed, Tags: []string{"billing"}})
+	d.Dispatch(&Event{Type: JobFailed})
+	d.Wait()
+
+	assert.Len(t, slack.events, 1)
+	assert.Len(t, email.events, 1)
+	assert.Len(t, unnamed.events, 2)
+}
+
+func TestDispatcherRoutingUnknownChannel(t *testing.T) {
+	d := NewDispatcher()
+	d.Add(SlackChannel, &recordingNotifier{})
+	assert.Error(t, d.SetRouter(&Router{Default: []string{PagerDutyChannel}}))
+}
@@ -0,0 +1,221 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// SlackPostMessageURL is the Web API method used with bot tokens.
+	SlackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+	// SlackOutputExcerptSize is the number of bytes of a run's output included in messages.
+	SlackOutputExcerptSize = 500
+)
+
+var ErrSlackNotConfigured = errors.New("Slack needs either a webhook url or a bot token")
+
+// SlackConfig configures a SlackNotifier.
+type SlackConfig struct {
+	// Incoming webhook url. Either it or Token must be set.
+	WebhookURL string
+	// Bot token, used to post with chat.postMessage.
+	Token string
+
+	// Default channel. Optional with webhooks, which have a channel of their own.
+	Channel string
+	// Channels by job tag, used for jobs without a channel of their own.
+	TagChannels map[string]string
+}
+
+// SlackNotifier posts run failures and recoveries to Slack.
+type SlackNotifier struct {
+	config SlackConfig
+	client *http.Client
+
+	// postMessageURL is SlackPostMessageURL, replaced in tests.
+	postMessageURL string
+}
+
+// NewSlackNotifier returns a SlackNotifier, or ErrSlackNotConfigured.
+func NewSlackNotifier(config SlackConfig) (*SlackNotifier, error) {
+	if config.WebhookURL == "" && config.Token == "" {
+		return nil, ErrSlackNotConfigured
+	}
+	return &SlackNotifier{
+		config:         config,
+		client:         &http.Client{Timeout: DefaultWebhookTimeout},
+		postMessageURL: SlackPostMessageURL,
+	}, nil
+}
+
+// ParseTagChannels parses "tag=#channel" pairs, as given on the command line.
+func ParseTagChannels(pairs []string) (map[string]string, error) {
+	channels := map[string]string{}
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid tag channel %q, expected tag=#channel", pair)
+		}
+		channels[parts[0]] = parts[1]
+	}
+	return channels, nil
+}
+
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Fallback string       `json:"fallback"`
+	Color    string       `json:"color"`
+	Title    string       `json:"title"`
+	Text     string       `json:"text,omitempty"`
+	Fields   []slackField `json:"fields"`
+	MrkdwnIn []string     `json:"mrkdwn_in"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func (n *SlackNotifier) Notify(e *Event) error {
+	if e.Type != JobFailed && e.Type != JobRecovered && e.Type != RunSlow {
+		return nil
+	}
+
+	msg := n.message(e)
+	if n.config.Token != "" {
+		if msg.Channel == "" {
+			return fmt.Errorf("No Slack channel for job %s", e.JobId)
+		}
+		return n.postMessage(msg)
+	}
+	return n.postWebhook(msg)
+}
+
+// channel picks the job's channel, then the first of its tags with a channel,
+// then the default channel.
+func (n *SlackNotifier) channel(e *Event) string {
+	if e.Settings != nil && e.Settings.SlackChannel != "" {
+		return e.Settings.SlackChannel
+	}
+	for _, tag := range e.Tags {
+		if channel, ok := n.config.TagChannels[tag]; ok {
+			return channel
+		}
+	}
+	return n.config.Channel
+}
+
+func (n *SlackNotifier) message(e *Event) *slackMessage {
+	title, color := fmt.Sprintf("Job %s failed", e.JobName), "danger"
+	switch e.Type {
+	case JobRecovered:
+		title, color = e.recovered(), "good"
+	case RunSlow:
+		title, color = e.slow(), "warning"
+	}
+
+	attachment := slackAttachment{
+		Fallback: title,
+		Color:    color,
+		Title:    title,
+		Fields: []slackField{
+			{Title: "Job", Value: fmt.Sprintf("%s (%s)", e.JobName, e.JobId)},
+			{Title: "Duration", Value: e.Duration.String(), Short: true},
+			{Title: "Retries", Value: fmt.Sprintf("%d", e.NumberOfRetries), Short: true},
+		},
+		MrkdwnIn: []string{"text"},
+	}
+	if e.Owner != "" {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Owner", Value: e.Owner, Short: true})
+	}
+	if o := e.Ownership; o != nil {
+		team := o.Team
+		if o.Slack != "" {
+			team += " (" + o.Slack + ")"
+		}
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Team", Value: team, Short: true})
+		if o.RunbookURL != "" {
+			attachment.Fields = append(attachment.Fields, slackField{Title: "Runbook", Value: "<" + o.RunbookURL + "|Runbook>", Short: true})
+		}
+	}
+	if result := e.ResultJSON(); result != "" {
+		if len(result) > SlackOutputExcerptSize {
+			result = result[:SlackOutputExcerptSize] + "..."
+		}
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Result", Value: "`" + result + "`"})
+	}
+	if e.Error != "" {
+		attachment.Fields = append(attachment.Fields, slackField{Title: "Error", Value: e.Error})
+	}
+	if output := strings.TrimSpace(e.Output); output != "" {
+		if len(output) > SlackOutputExcerptSize {
+			output = "..." + output[len(output)-SlackOutputExcerptSize:]
+		}
+		attachment.Text = "```" + output + "```"
+	}
+
+	return &slackMessage{
+		Channel:     n.channel(e),
+		Text:        title,
+		Attachments: []slackAttachment{attachment},
+	}
+}
+
+func (n *SlackNotifier) postWebhook(msg *slackMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.config.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func (n *SlackNotifier) postMessage(msg *slackMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.postMessageURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+n.config.Token)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The Web API responds with 200 OK and reports errors in the body.
+	var result struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("Slack responded with %s", resp.Status)
+	}
+	if !result.Ok {
+		return fmt.Errorf("Slack responded with error %s", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func slackServer(t *testing.T, response string) (*httptest.Server, chan *http.Request, chan slackMessage) {
+	requests := make(chan *http.Request, 1)
+	messages := make(chan slackMessage, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg slackMessage
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		requests <- r
+		messages <- msg
+		w.Write([]byte(response))
+	}))
+	return ts, requests, messages
+}
+
+func TestNewSlackNotifierNotConfigured(t *testing.T) {
+	_, err := NewSlackNotifier(SlackConfig{Channel: "#ops"})
+	assert.Equal(t, ErrSlackNotConfigured, err)
+}
+
+func TestSlackNotifierWebhook(t *testing.T) {
+	ts, _, messages := slackServer(t, "ok")
+	defer ts.Close()
+
+	n, err := NewSlackNotifier(SlackConfig{WebhookURL: ts.URL})
+	assert.NoError(t, err)
+
+	err = n.Notify(&Event{
+		Type:     JobFailed,
+		JobId:    "id",
+		JobName:  "backup",
+		Duration: 2 * time.Second,
+		Error:    "exit status 1",
+		Output:   strings.Repeat("x", SlackOutputExcerptSize) + "disk full\n",
+	})
+	assert.NoError(t, err)
+
+	msg := <-messages
+	assert.Equal(t, "", msg.Channel)
+	assert.Equal(t, "Job backup failed", msg.Text)
+	assert.Equal(t, "danger", msg.Attachments[0].Color)
+	assert.Equal(t, "2s", msg.Attachments[0].Fields[1].Value)
+	assert.True(t, strings.HasSuffix(msg.Attachments[0].Text, "disk full```"))
+	assert.True(t, len(msg.Attachments[0].Text) < SlackOutputExcerptSize+10)
+}
+
+func TestSlackNotifierToken(t *testing.T) {
+	ts, requests, messages := slackServer(t, `{"ok":true}`)
+	defer ts.Close()
+
+	n, err := NewSlackNotifier(SlackConfig{
+		Token:       "xoxb-token",
+		Channel:     "#ops",
+		TagChannels: map[string]string{"billing": "#billing"},
+	})
+	assert.NoError(t, err)
+	n.postMessageURL = ts.URL
+
+	now := time.Now()
+	err = n.Notify(&Event{Type: JobRecovered, JobName: "invoices", Tags: []string{"nightly", "billing"},
+		Time: now, ConsecutiveFailures: 3, FailingSince: now.Add(-2 * time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer xoxb-token", (<-requests).Header.Get("Authorization"))
+	msg := <-messages
+	assert.Equal(t, "#billing", msg.Channel)
+	assert.Equal(t, "good", msg.Attachments[0].Color)
+	assert.Equal(t, "Job invoices recovered after 3 failed runs over 2h0m0s", msg.Text)
+
+	err = n.Notify(&Event{Type: JobFailed, Tags: []string{"billing"}, Settings: &Settings{SlackChannel: "#invoices"}})
+	assert.NoError(t, err)
+	<-requests
+	assert.Equal(t, "#invoices", (<-messages).Channel)
+
+	err = n.Notify(&Event{Type: RunSlow, JobName: "invoices", Duration: 5*time.Minute + 300*time.Millisecond, Baseline: 90 * time.Second})
+	assert.NoError(t, err)
+	<-requests
+	msg = <-messages
+	assert.Equal(t, "warning", msg.Attachments[0].Color)
+	assert.Equal(t, "Job invoices ran slow: 5m0s instead of about 1m30s", msg.Text)
+
+	// Disabled jobs aren't posted.
+	assert.NoError(t, n.Notify(&Event{Type: JobDisabled}))
+}
+
+func TestSlackNotifierTokenError(t *testing.T) {
+	ts, _, _ := slackServer(t, `{"ok":false,"error":"channel_not_found"}`)
+	defer ts.Close()
+
+	n, err := NewSlackNotifier(SlackConfig{Token: "xoxb-token", Channel: "#missing"})
+	assert.NoError(t, err)
+	n.postMessageURL = ts.URL
+
+	err = n.Notify(&Event{Type: JobFailed})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "channel_not_found")
+}
+
+func TestParseTagChannels(t *testing.T) {
+	channels, err := ParseTagChannels([]string{"billing=#billing", "etl=#data"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"billing": "#billing", "etl": "#data"}, channels)
+
+	_, err = ParseTagChannels([]string{"billing"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DefaultWebhookTimeout is the timeout of webhook requests.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// WebhookNotifier POSTs events as JSON to the configured urls, and to the
+// webhooks in the job's settings.
+type WebhookNotifier struct {
+	Urls   []string
+	Client *http.Client
+}
+
+// NewWebhookNotifier returns a WebhookNotifier for the given urls.
+func NewWebhookNotifier(urls []string) *WebhookNotifier {
+	return &WebhookNotifier{
+		Urls:   urls,
+		Client: &http.Client{Timeout: DefaultWebhookTimeout},
+	}
+}
+
+func (n *WebhookNotifier) Notify(e *Event) error {
+	urls := n.Urls
+	if e.Settings != nil {
+		urls = append(urls[:len(urls):len(urls)], e.Settings.Webhooks...)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, url := range urls {
+		if err := n.post(url, body); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (n *WebhookNotifier) post(url string, body []byte) error {
+	resp, err := n.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	received := make(chan Event, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e Event
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&e))
+		received <- e
+	}))
+	defer ts.Close()
+
+	n := NewWebhookNotifier([]string{ts.URL})
+	err := n.Notify(&Event{
+		Type:     JobFailed,
+		JobId:    "id",
+		Error:    "exit status 1",
+		Settings: &Settings{Webhooks: []string{ts.URL + "/job"}},
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		e := <-received
+		assert.Equal(t, JobFailed, e.Type)
+		assert.Equal(t, "id", e.JobId)
+		assert.Equal(t, "exit status 1", e.Error)
+	}
+	assert.Len(t, n.Urls, 1)
+}
+
+func TestWebhookNotifierError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	n := NewWebhookNotifier([]string{ts.URL})
+	assert.Error(t, n.Notify(&Event{Type: JobFailed}))
+}
@@ -0,0 +1,335 @@
+package awsauth
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoCredentials = errors.New("No AWS credentials found in the environment, the shared credentials file, or the container or instance metadata")
+
+	// errNotConfigured is returned by providers whose source isn't set up,
+	// e.g. the environment provider without AWS_ACCESS_KEY_ID.
+	errNotConfigured = errors.New("not configured")
+
+	// Temporary credentials are refreshed this long before they expire.
+	expiryWindow = 5 * time.Minute
+
+	containerEndpoint = "http://169.254.170.2"
+	ec2Endpoint       = "http://169.254.169.254"
+	metadataClient    = &http.Client{Timeout: time.Second}
+	stsClient         = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Credentials are AWS security credentials. Temporary credentials have a
+// session token and an expiry.
+type Credentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+func (c *Credentials) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && now.Add(expiryWindow).After(c.Expires)
+}
+
+// Provider is a source of credentials.
+type Provider interface {
+	Retrieve() (*Credentials, error)
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func() (*Credentials, error)
+
+func (f ProviderFunc) Retrieve() (*Credentials, error) {
+	return f()
+}
+
+// Chain returns the credentials of the first of its providers which is set up,
+// and caches them until they are about to expire.
+type Chain struct {
+	Providers []Provider
+
+	lock   sync.Mutex
+	cached *Credentials
+}
+
+// NewChain returns a Chain trying the providers in order.
+func NewChain(providers ...Provider) *Chain {
+	return &Chain{Providers: providers}
+}
+
+// Retrieve returns the cached credentials, or retrieves new ones.
+func (c *Chain) Retrieve() (*Credentials, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.cached != nil && !c.cached.expired(time.Now()) {
+		return c.cached, nil
+	}
+
+	err := ErrNoCredentials
+	for _, p := range c.Providers {
+		creds, perr := p.Retrieve()
+		if perr == errNotConfigured {
+			continue
+		}
+		if perr != nil {
+			err = perr
+			continue
+		}
+		c.cached = creds
+		return creds, nil
+	}
+	return nil, err
+}
+
+// DefaultChain looks for credentials in the same places as the AWS SDKs: the
+// environment, the shared credentials file, a web identity token (e.g. EKS
+// service accounts), the ECS container metadata, and the EC2 instance metadata.
+var DefaultChain = NewChain(
+	ProviderFunc(envCredentials),
+	ProviderFunc(sharedFileCredentials),
+	ProviderFunc(webIdentityCredentials),
+	ProviderFunc(containerCredentials),
+	ProviderFunc(ec2Credentials),
+)
+
+// Region returns the region configured in the environment, if any.
+func Region() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+func envCredentials() (*Credentials, error) {
+	id := firstEnv("AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY")
+	secret := firstEnv("AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY")
+	if id == "" || secret == "" {
+		return nil, errNotConfigured
+	}
+	return &Credentials{
+		AccessKeyId:     id,
+		SecretAccessKey: secret,
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}, nil
+}
+
+func sharedFileCredentials() (*Credentials, error) {
+	filename := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if filename == "" {
+		home := firstEnv("HOME", "USERPROFILE")
+		if home == "" {
+			return nil, errNotConfigured
+		}
+		filename = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := firstEnv("AWS_PROFILE", "AWS_DEFAULT_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, errNotConfigured
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	creds := &Credentials{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if section != profile || len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyId = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if creds.AccessKeyId == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("No credentials for profile %q in %s", profile, filename)
+	}
+	return creds, nil
+}
+
+func webIdentityCredentials() (*Credentials, error) {
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	roleArn := os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || roleArn == "" {
+		return nil, errNotConfigured
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("kala-%d", time.Now().UnixNano())
+	}
+
+	endpoint := "https://sts.amazonaws.com/"
+	if region := Region(); region != "" {
+		endpoint = "https://sts." + region + ".amazonaws.com/"
+	}
+	res, err := stsClient.PostForm(endpoint, url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleArn},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		Credentials struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+		Error struct {
+			Code    string
+			Message string
+		}
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("Error decoding STS response (%s): %s", res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error assuming role %s: %s: %s", roleArn, body.Error.Code, body.Error.Message)
+	}
+	c := body.Credentials
+	return &Credentials{c.AccessKeyId, c.SecretAccessKey, c.SessionToken, c.Expiration}, nil
+}
+
+func containerCredentials() (*Credentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = containerEndpoint + relative
+	}
+	if endpoint == "" {
+		return nil, errNotConfigured
+	}
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return getMetadataCredentials(req)
+}
+
+func ec2Credentials() (*Credentials, error) {
+	if strings.ToLower(os.Getenv("AWS_EC2_METADATA_DISABLED")) == "true" {
+		return nil, errNotConfigured
+	}
+
+	// Use IMDSv2 if possible, and fall back to IMDSv1.
+	token := ""
+	req, _ := http.NewRequest("PUT", ec2Endpoint+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	if res, err := metadataClient.Do(req); err == nil {
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			token = string(b)
+		}
+	}
+
+	newRequest := func(path string) *http.Request {
+		req, _ := http.NewRequest("GET", ec2Endpoint+"/latest/meta-data/iam/security-credentials/"+path, nil)
+		if token != "" {
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		return req
+	}
+
+	res, err := metadataClient.Do(newRequest(""))
+	if err != nil {
+		// Not running on EC2.
+		return nil, errNotConfigured
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error listing the instance's roles: %s", res.Status)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(b), "\n", 2)[0])
+	if role == "" {
+		return nil, errNotConfigured
+	}
+	return getMetadataCredentials(newRequest(role))
+}
+
+// getMetadataCredentials requests credentials from the container or instance
+// metadata, which share a format.
+func getMetadataCredentials(req *http.Request) (*Credentials, error) {
+	res, err := metadataClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error retrieving credentials from %s: %s", req.URL, res.Status)
+	}
+
+	var body struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return &Credentials{body.AccessKeyId, body.SecretAccessKey, body.Token, body.Expiration}, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,126 @@
+package awsauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setenv(t *testing.T, env map[string]string) func() {
+	old := map[string]string{}
+	for k, v := range env {
+		old[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, v := range old {
+			os.Setenv(k, v)
+		}
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	defer setenv(t, map[string]string{
+		"AWS_ACCESS_KEY_ID":     "id",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_SESSION_TOKEN":     "token",
+	})()
+
+	creds, err := envCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{AccessKeyId: "id", SecretAccessKey: "secret", SessionToken: "token"}, creds)
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, err = envCredentials()
+	assert.Equal(t, errNotConfigured, err)
+}
+
+func TestSharedFileCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awsauth")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "credentials")
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(`
+[default]
+aws_access_key_id = default_id
+aws_secret_access_key = default_secret
+
+# Used for scheduled jobs
+[kala]
+aws_access_key_id=kala_id
+aws_secret_access_key=kala_secret
+`), 0600))
+
+	defer setenv(t, map[string]string{"AWS_SHARED_CREDENTIALS_FILE": filename, "AWS_PROFILE": ""})()
+	creds, err := sharedFileCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, "default_id", creds.AccessKeyId)
+
+	os.Setenv("AWS_PROFILE", "kala")
+	creds, err = sharedFileCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{AccessKeyId: "kala_id", SecretAccessKey: "kala_secret"}, creds)
+
+	os.Setenv("AWS_PROFILE", "missing")
+	_, err = sharedFileCredentials()
+	assert.Error(t, err)
+
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "missing"))
+	_, err = sharedFileCredentials()
+	assert.Equal(t, errNotConfigured, err)
+}
+
+func TestContainerCredentials(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/credentials/abc", r.URL.Path)
+		assert.Equal(t, "Bearer auth", r.Header.Get("Authorization"))
+		fmt.Fprintf(w, `{"AccessKeyId": "id", "SecretAccessKey": "secret", "Token": "token", "Expiration": %q}`, expiration.Format(time.RFC3339))
+	}))
+	defer ts.Close()
+
+	defer setenv(t, map[string]string{
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": ts.URL + "/v2/credentials/abc",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN":  "Bearer auth",
+	})()
+
+	creds, err := containerCredentials()
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{"id", "secret", "token", expiration}, creds)
+}
+
+func TestChain(t *testing.T) {
+	calls := 0
+	expires := time.Now().Add(time.Hour)
+	chain := NewChain(
+		ProviderFunc(func() (*Credentials, error) { return nil, errNotConfigured }),
+		ProviderFunc(func() (*Credentials, error) {
+			calls++
+			return &Credentials{AccessKeyId: "id", SecretAccessKey: "secret", Expires: expires}, nil
+		}),
+	)
+
+	creds, err := chain.Retrieve()
+	assert.NoError(t, err)
+	assert.Equal(t, "id", creds.AccessKeyId)
+	_, err = chain.Retrieve()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+
+	// Credentials about to expire are refreshed.
+	expires = time.Now().Add(time.Minute)
+	chain.cached.Expires = expires
+	chain.Retrieve()
+	chain.Retrieve()
+	assert.Equal(t, 3, calls)
+
+	_, err = NewChain().Retrieve()
+	assert.Equal(t, ErrNoCredentials, err)
+}
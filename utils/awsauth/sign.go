@@ -0,0 +1,152 @@
+// Package awsauth signs requests to AWS APIs with Signature Version 4, using
+// credentials from the same chain as the AWS SDKs.
+package awsauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm    = "AWS4-HMAC-SHA256"
+	amzDate      = "20060102T150405Z"
+	amzShortDate = "20060102"
+)
+
+// Sign adds the Signature Version 4 headers to the request, whose body is
+// the given payload. Every header already set on the request is signed.
+func Sign(req *http.Request, payload []byte, creds *Credentials, region, service string, now time.Time) {
+	sign(req, payload, creds, region, service, now, true)
+}
+
+// SignS3 is like Sign, for S3 requests, which also need the hash of the payload
+// in a header, and whose paths aren't escaped twice.
+func SignS3(req *http.Request, payload []byte, creds *Credentials, region string, now time.Time) {
+	req.Header.Set("X-Amz-Content-Sha256", hashHex(payload))
+	sign(req, payload, creds, region, "s3", now, false)
+}
+
+func sign(req *http.Request, payload []byte, creds *Credentials, region, service string, now time.Time, escapePath bool) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDate))
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" || k == "user-agent" {
+			continue
+		}
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = strings.Join(strings.Fields(v[i]), " ")
+		}
+		headers[k] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if escapePath {
+		path = escape(path, false)
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		hashHex(payload),
+	}, "\n")
+
+	scope := strings.Join([]string{now.Format(amzShortDate), region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		algorithm,
+		now.Format(amzDate),
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), now.Format(amzShortDate))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, creds.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, escape(k, true)+"="+escape(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// EscapePath escapes every byte of a path segment except the unreserved
+// characters, as AWS expects e.g. ARNs in paths to be escaped.
+func EscapePath(segment string) string {
+	return escape(segment, true)
+}
+
+func escape(s string, escapeSlash bool) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,48 @@
+package awsauth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// From the AWS Signature Version 4 test suite.
+var testCredentials = &Credentials{
+	AccessKeyId:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestSignGetVanilla(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	Sign(req, nil, testCredentials, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func TestSignSessionToken(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://example.amazonaws.com/", nil)
+	creds := *testCredentials
+	creds.SessionToken = "token"
+	Sign(req, []byte("{}"), &creds, "us-east-1", "service", time.Now())
+
+	assert.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+}
+
+func TestEscapePath(t *testing.T) {
+	assert.Equal(t, "arn%3Aaws%3Alambda%3Aus-east-1%3A123%3Afunction%3Amy_func", EscapePath("arn:aws:lambda:us-east-1:123:function:my_func"))
+	assert.Equal(t, "/a%2520b/c", escape("/a%20b/c", false))
+}
+
+func TestSignS3(t *testing.T) {
+	req, _ := http.NewRequest("PUT", "https://bucket.s3.amazonaws.com/a%20b", nil)
+	SignS3(req, []byte("body"), testCredentials, "us-east-1", time.Now())
+
+	assert.Equal(t, "230d8358dc8e8890b4c58deeb62912ee2f20357ae92a5cc861b98e68fe31acb5", req.Header.Get("X-Amz-Content-Sha256"))
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date,")
+}
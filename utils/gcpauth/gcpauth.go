@@ -0,0 +1,310 @@
+// Package gcpauth gets OAuth2 access tokens for Google Cloud APIs, from a
+// service account key or from the metadata server, e.g. with GKE workload
+// identity, following Application Default Credentials.
+package gcpauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CloudPlatformScope gives access to all Google Cloud APIs the account may use.
+	CloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	defaultTokenURL = "https://oauth2.googleapis.com/token"
+	metadataHost    = "metadata.google.internal"
+)
+
+var (
+	ErrInvalidKey = errors.New("The service account's private key isn't a PEM encoded RSA key")
+
+	// Tokens are refreshed this long before they expire.
+	expiryWindow = time.Minute
+
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+
+	lock    sync.Mutex
+	sources = map[string]TokenSource{}
+)
+
+// Token is an OAuth2 access token.
+type Token struct {
+	AccessToken string
+	Expires     time.Time
+}
+
+// TokenSource returns valid access tokens.
+type TokenSource interface {
+	Token() (*Token, error)
+}
+
+// credentialsFile is a service account key or a user's credentials, as
+// written by "gcloud auth application-default login".
+type credentialsFile struct {
+	Type string `json:"type"`
+
+	// Service account keys
+	ProjectId    string `json:"project_id"`
+	PrivateKeyId string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+
+	// User credentials
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// DefaultTokenSource returns a TokenSource for the given credentials file,
+// which defaults to GOOGLE_APPLICATION_CREDENTIALS, then to gcloud's
+// application default credentials, and then to the metadata server.
+// TokenSources are cached, so tokens are reused until they expire.
+func DefaultTokenSource(filename string, scopes ...string) (TokenSource, error) {
+	if filename == "" {
+		filename = defaultCredentialsFile()
+	}
+	key := filename + " " + strings.Join(scopes, " ")
+
+	lock.Lock()
+	defer lock.Unlock()
+	if ts, ok := sources[key]; ok {
+		return ts, nil
+	}
+
+	var ts TokenSource
+	if filename == "" {
+		ts = &metadataSource{scopes: scopes}
+	} else {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		ts, err = FromJSON(data, scopes...)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading credentials from %s: %s", filename, err)
+		}
+	}
+	ts = &cachingSource{source: ts}
+	sources[key] = ts
+	return ts, nil
+}
+
+func defaultCredentialsFile() string {
+	if filename := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); filename != "" {
+		return filename
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		filename := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+		if _, err := os.Stat(filename); err == nil {
+			return filename
+		}
+	}
+	return ""
+}
+
+// FromJSON returns a TokenSource for a service account key or user credentials.
+func FromJSON(data []byte, scopes ...string) (TokenSource, error) {
+	var f credentialsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if f.TokenURI == "" {
+		f.TokenURI = defaultTokenURL
+	}
+
+	switch f.Type {
+	case "service_account":
+		block, _ := pem.Decode([]byte(f.PrivateKey))
+		if block == nil {
+			return nil, ErrInvalidKey
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if err != nil || !ok {
+			return nil, ErrInvalidKey
+		}
+		return &serviceAccountSource{file: f, key: key, scopes: scopes}, nil
+	case "authorized_user":
+		return &userSource{file: f}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported credentials type %q", f.Type)
+	}
+}
+
+type serviceAccountSource struct {
+	file   credentialsFile
+	key    *rsa.PrivateKey
+	scopes []string
+}
+
+// Token exchanges a JWT signed with the account's key for an access token.
+func (s *serviceAccountSource) Token() (*Token, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": s.file.PrivateKeyId,
+	})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   s.file.ClientEmail,
+		"scope": strings.Join(s.scopes, " "),
+		"aud":   s.file.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return requestToken(s.file.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	})
+}
+
+type userSource struct {
+	file credentialsFile
+}
+
+// Token exchanges the user's refresh token for an access token.
+func (s *userSource) Token() (*Token, error) {
+	return requestToken(s.file.TokenURI, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {s.file.ClientId},
+		"client_secret": {s.file.ClientSecret},
+		"refresh_token": {s.file.RefreshToken},
+	})
+}
+
+func requestToken(tokenURL string, form url.Values) (*Token, error) {
+	res, err := httpClient.PostForm(tokenURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return decodeToken(res)
+}
+
+// metadataSource gets the tokens of the instance's service account, which is
+// the Kubernetes service account's with GKE workload identity.
+type metadataSource struct {
+	scopes []string
+}
+
+func (s *metadataSource) Token() (*Token, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = metadataHost
+	}
+	u := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token"
+	if len(s.scopes) != 0 {
+		u += "?scopes=" + url.QueryEscape(strings.Join(s.scopes, ","))
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("No Google credentials configured, and the metadata server isn't reachable: %s", err)
+	}
+	defer res.Body.Close()
+	return decodeToken(res)
+}
+
+func decodeToken(res *http.Response) (*Token, error) {
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("Error decoding token response (%s): %s", res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return nil, fmt.Errorf("Error getting an access token (%s): %s %s", res.Status, body.Error, body.ErrorDescription)
+	}
+	return &Token{
+		AccessToken: body.AccessToken,
+		Expires:     time.Now().Add(time.Duration(body.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// cachingSource reuses tokens until they are about to expire.
+type cachingSource struct {
+	source TokenSource
+
+	lock  sync.Mutex
+	token *Token
+}
+
+func (s *cachingSource) Token() (*Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.token != nil && time.Now().Add(expiryWindow).Before(s.token.Expires) {
+		return s.token, nil
+	}
+	token, err := s.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+// ProjectId returns the project configured in GOOGLE_CLOUD_PROJECT, or the
+// project of the instance from the metadata server.
+func ProjectId() (string, error) {
+	if project := os.Getenv("GOOGLE_CLOUD_PROJECT"); project != "" {
+		return project, nil
+	}
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = metadataHost
+	}
+	req, err := http.NewRequest("GET", "http://"+host+"/computeMetadata/v1/project/project-id", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("No Google Cloud project configured, and the metadata server isn't reachable: %s", err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Error getting the project from the metadata server: %s", res.Status)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,99 @@
+package gcpauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceAccountToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.FormValue("grant_type"))
+
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if assert.Len(t, parts, 3) {
+			hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature))
+
+			var claims map[string]interface{}
+			b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			json.Unmarshal(b, &claims)
+			assert.Equal(t, "kala@project.iam.gserviceaccount.com", claims["iss"])
+			assert.Equal(t, CloudPlatformScope, claims["scope"])
+		}
+		fmt.Fprint(w, `{"access_token": "token", "expires_in": 3600, "token_type": "Bearer"}`)
+	}))
+	defer ts.Close()
+
+	data, _ := json.Marshal(credentialsFile{
+		Type:         "service_account",
+		PrivateKeyId: "1",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		ClientEmail:  "kala@project.iam.gserviceaccount.com",
+		TokenURI:     ts.URL,
+	})
+	source, err := FromJSON(data, CloudPlatformScope)
+	assert.NoError(t, err)
+	source = &cachingSource{source: source}
+
+	token, err := source.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+	source.Token()
+	assert.Equal(t, 1, requests)
+}
+
+func TestInvalidCredentials(t *testing.T) {
+	_, err := FromJSON([]byte(`{"type": "service_account", "private_key": "nope"}`))
+	assert.Equal(t, ErrInvalidKey, err)
+
+	_, err = FromJSON([]byte(`{"type": "external_account"}`))
+	assert.Error(t, err)
+}
+
+func TestMetadataToken(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			fmt.Fprint(w, `{"access_token": "metadata-token", "expires_in": 3600}`)
+		case "/computeMetadata/v1/project/project-id":
+			fmt.Fprint(w, "my-project")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	os.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(ts.URL, "http://"))
+	defer os.Unsetenv("GCE_METADATA_HOST")
+
+	token, err := (&metadataSource{}).Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "metadata-token", token.AccessToken)
+
+	project, err := ProjectId()
+	assert.NoError(t, err)
+	assert.Equal(t, "my-project", project)
+}
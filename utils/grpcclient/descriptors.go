@@ -0,0 +1,200 @@
+package grpcclient
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// The well-known types, which descriptor sets and servers often leave out.
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Methods of the server reflection service, newest first.
+var reflectionMethods = []string{
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo",
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo",
+}
+
+// LoadDescriptorSet reads a FileDescriptorSet, as written by
+// "protoc --include_imports --descriptor_set_out".
+func LoadDescriptorSet(path string) (*protoregistry.Files, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("Error reading descriptor set %s: %s", path, err)
+	}
+	return buildFiles(set.File)
+}
+
+// Reflect asks the server for the file defining the symbol, e.g. a service,
+// and the files it depends on.
+func (c *Conn) Reflect(ctx context.Context, symbol string) (*protoregistry.Files, error) {
+	var request []byte
+	request = protowire.AppendTag(request, 4, protowire.BytesType)
+	request = protowire.AppendString(request, symbol)
+
+	var response []byte
+	var err error
+	for _, method := range reflectionMethods {
+		response, err = c.Invoke(ctx, method, request, nil)
+		if statusErr, ok := err.(*StatusError); !ok || statusErr.Code != CodeUnimplemented {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up %s with server reflection: %s", symbol, err)
+	}
+
+	var files []*descriptorpb.FileDescriptorProto
+	err = parseFields(response, func(num protowire.Number, value []byte) error {
+		switch num {
+		// file_descriptor_response
+		case 4:
+			return parseFields(value, func(num protowire.Number, value []byte) error {
+				if num != 1 {
+					return nil
+				}
+				file := &descriptorpb.FileDescriptorProto{}
+				if err := proto.Unmarshal(value, file); err != nil {
+					return err
+				}
+				files = append(files, file)
+				return nil
+			})
+		// error_response
+		case 7:
+			var message string
+			parseFields(value, func(num protowire.Number, value []byte) error {
+				if num == 2 {
+					message = string(value)
+				}
+				return nil
+			})
+			return fmt.Errorf("Error looking up %s with server reflection: %s", symbol, message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buildFiles(files)
+}
+
+// parseFields calls fn with the number and the value of every length-delimited
+// field of the message.
+func parseFields(b []byte, fn func(protowire.Number, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildFiles links the files, in any order, to the files they import.
+func buildFiles(protos []*descriptorpb.FileDescriptorProto) (*protoregistry.Files, error) {
+	byName := map[string]*descriptorpb.FileDescriptorProto{}
+	for _, fd := range protos {
+		byName[fd.GetName()] = fd
+	}
+
+	files := &protoregistry.Files{}
+	var add func(fd *descriptorpb.FileDescriptorProto) error
+	add = func(fd *descriptorpb.FileDescriptorProto) error {
+		if _, err := files.FindFileByPath(fd.GetName()); err == nil {
+			return nil
+		}
+		for _, dep := range fd.Dependency {
+			if depFd, ok := byName[dep]; ok {
+				if err := add(depFd); err != nil {
+					return err
+				}
+			}
+		}
+		f, err := protodesc.NewFile(fd, resolver{files})
+		if err != nil {
+			return err
+		}
+		return files.RegisterFile(f)
+	}
+	for _, fd := range protos {
+		if err := add(fd); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// resolver looks up files in the given files, and then in the files linked
+// into kala, like the well-known types.
+type resolver struct {
+	files *protoregistry.Files
+}
+
+func (r resolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if f, err := r.files.FindFileByPath(path); err == nil {
+		return f, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r resolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// FindMethod looks up a unary method in the files.
+func FindMethod(files *protoregistry.Files, service, method string) (protoreflect.MethodDescriptor, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("Service %s not found", service)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("Method %s not found in service %s", method, service)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("Method %s.%s is streaming, only unary methods are supported", service, method)
+	}
+	return md, nil
+}
@@ -0,0 +1,126 @@
+// Package grpcclient makes unary gRPC calls with messages which are only
+// known at runtime, described by a descriptor set or by server reflection.
+//
+// Calls are made with grpc-go, which handles the transport, e.g. flow
+// control, GOAWAY and keepalives. Only unary methods are supported, not
+// streaming ones, and only gzip compression. Connections use TLS without a
+// client certificate, or plaintext, and the default service config.
+package grpcclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	// Servers may compress responses with gzip.
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+// MaxMessageSize is the largest response message accepted, like gRPC's default.
+var MaxMessageSize = 4 << 20
+
+// Codes are the gRPC status codes, by number.
+var Codes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+const CodeUnimplemented = int(codes.Unimplemented)
+
+// StatusError is returned for calls which didn't end with the OK status.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	name := strconv.Itoa(e.Code)
+	if e.Code >= 0 && e.Code < len(Codes) {
+		name = Codes[e.Code]
+	}
+	return fmt.Sprintf("gRPC call failed with %s: %s", name, e.Message)
+}
+
+// Conn is a connection to a gRPC server.
+type Conn struct {
+	conn *grpc.ClientConn
+}
+
+// NewConn returns a Conn to the target, e.g. "localhost:50051", which connects
+// with TLS if config isn't nil, and in plaintext otherwise. It connects on the
+// first call.
+func NewConn(target string, config *tls.Config) (*Conn, error) {
+	creds := insecure.NewCredentials()
+	if config != nil {
+		creds = credentials.NewTLS(config)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn}, nil
+}
+
+// Close closes the connections to the server.
+func (c *Conn) Close() {
+	c.conn.Close()
+}
+
+// Invoke calls the method, e.g. "/helloworld.Greeter/SayHello", with the
+// encoded request message, and returns the encoded response message. The
+// context's deadline is sent to the server.
+func (c *Conn) Invoke(ctx context.Context, method string, request []byte, md map[string]string) ([]byte, error) {
+	if len(md) != 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(md))
+	}
+	var response []byte
+	err := c.conn.Invoke(ctx, method, &request, &response,
+		grpc.ForceCodec(rawCodec{}), grpc.MaxCallRecvMsgSize(MaxMessageSize))
+	if err != nil {
+		s := status.Convert(err)
+		return nil, &StatusError{int(s.Code()), s.Message()}
+	}
+	return response, nil
+}
+
+// rawCodec sends and receives messages which are already encoded, as they
+// are. It's named like the proto codec, as servers expect protobuf.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*[]byte), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*[]byte) = append([]byte{}, data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// SplitMethod splits e.g. "helloworld.Greeter/SayHello" or
+// "helloworld.Greeter.SayHello" into the service and the method name.
+func SplitMethod(method string) (service, name string, ok bool) {
+	method = strings.TrimPrefix(method, "/")
+	i := strings.LastIndex(method, "/")
+	if i < 0 {
+		i = strings.LastIndex(method, ".")
+	}
+	if i <= 0 || i == len(method)-1 {
+		return "", "", false
+	}
+	return method[:i], method[i+1:], true
+}
@@ -0,0 +1,162 @@
+package grpcclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// greeterFile describes test.Greeter/SayHello(HelloRequest{name}) returns (HelloReply{message}).
+var greeterFile = &descriptorpb.FileDescriptorProto{
+	Name:    proto.String("greeter.proto"),
+	Package: proto.String("test"),
+	Syntax:  proto.String("proto3"),
+	MessageType: []*descriptorpb.DescriptorProto{
+		{Name: proto.String("HelloRequest"), Field: []*descriptorpb.FieldDescriptorProto{stringField("name")}},
+		{Name: proto.String("HelloReply"), Field: []*descriptorpb.FieldDescriptorProto{stringField("message")}},
+	},
+	Service: []*descriptorpb.ServiceDescriptorProto{{
+		Name: proto.String("Greeter"),
+		Method: []*descriptorpb.MethodDescriptorProto{{
+			Name:       proto.String("SayHello"),
+			InputType:  proto.String(".test.HelloRequest"),
+			OutputType: proto.String(".test.HelloReply"),
+		}},
+	}},
+}
+
+func stringField(name string) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(1),
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+	}
+}
+
+// newGreeterServer serves the greeter with v1alpha server reflection, with
+// grpc-go. Messages are handled encoded, like the client does.
+func newGreeterServer(t *testing.T) (*grpc.Server, string) {
+	files, err := buildFiles([]*descriptorpb.FileDescriptorProto{greeterFile})
+	assert.NoError(t, err)
+	d, _ := files.FindDescriptorByName("test.HelloRequest")
+	requestType := d.(protoreflect.MessageDescriptor)
+	d, _ = files.FindDescriptorByName("test.HelloReply")
+	replyType := d.(protoreflect.MessageDescriptor)
+
+	handler := func(_ interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		var body []byte
+		if err := stream.RecvMsg(&body); err != nil {
+			return err
+		}
+
+		switch method {
+		case "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo":
+			fd, _ := proto.Marshal(greeterFile)
+			var files, res []byte
+			files = protowire.AppendTag(files, 1, protowire.BytesType)
+			files = protowire.AppendBytes(files, fd)
+			res = protowire.AppendTag(res, 4, protowire.BytesType)
+			res = protowire.AppendBytes(res, files)
+			return stream.SendMsg(&res)
+		case "/test.Greeter/SayHello":
+			_, ok := stream.Context().Deadline()
+			assert.True(t, ok)
+			md, _ := metadata.FromIncomingContext(stream.Context())
+			assert.Equal(t, []string{"secret"}, md.Get("x-api-key"))
+			req := dynamicpb.NewMessage(requestType)
+			assert.NoError(t, proto.Unmarshal(body, req))
+			name := req.Get(requestType.Fields().ByName("name")).String()
+			if name == "" {
+				return status.Error(codes.InvalidArgument, "name is required!")
+			}
+			// Replies are compressed, if the client accepts it.
+			assert.NoError(t, grpc.SetSendCompressor(stream.Context(), "gzip"))
+			reply := dynamicpb.NewMessage(replyType)
+			reply.Set(replyType.Fields().ByName("message"), protoreflect.ValueOfString("Hello "+name))
+			b, _ := proto.Marshal(reply)
+			return stream.SendMsg(&b)
+		}
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(handler))
+	go server.Serve(lis)
+	return server, lis.Addr().String()
+}
+
+func TestInvokeWithReflection(t *testing.T) {
+	server, addr := newGreeterServer(t)
+	defer server.Stop()
+	conn, err := NewConn(addr, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	files, err := conn.Reflect(ctx, "test.Greeter")
+	assert.NoError(t, err)
+	md, err := FindMethod(files, "test.Greeter", "SayHello")
+	assert.NoError(t, err)
+
+	req := dynamicpb.NewMessage(md.Input())
+	req.Set(md.Input().Fields().ByName("name"), protoreflect.ValueOfString("kala"))
+	b, _ := proto.Marshal(req)
+	meta := map[string]string{"x-api-key": "secret"}
+	b, err = conn.Invoke(ctx, "/test.Greeter/SayHello", b, meta)
+	assert.NoError(t, err)
+	reply := dynamicpb.NewMessage(md.Output())
+	assert.NoError(t, proto.Unmarshal(b, reply))
+	assert.Equal(t, "Hello kala", reply.Get(md.Output().Fields().ByName("message")).String())
+
+	_, err = conn.Invoke(ctx, "/test.Greeter/SayHello", nil, meta)
+	assert.Equal(t, &StatusError{3, "name is required!"}, err)
+	assert.EqualError(t, err, "gRPC call failed with INVALID_ARGUMENT: name is required!")
+
+	_, err = FindMethod(files, "test.Greeter", "SayGoodbye")
+	assert.Error(t, err)
+}
+
+func TestLoadDescriptorSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpcclient")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "greeter.pb")
+	b, _ := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{greeterFile}})
+	assert.NoError(t, ioutil.WriteFile(path, b, 0644))
+
+	files, err := LoadDescriptorSet(path)
+	assert.NoError(t, err)
+	_, err = FindMethod(files, "test.Greeter", "SayHello")
+	assert.NoError(t, err)
+}
+
+func TestSplitMethod(t *testing.T) {
+	for _, method := range []string{"test.Greeter/SayHello", "/test.Greeter/SayHello", "test.Greeter.SayHello"} {
+		service, name, ok := SplitMethod(method)
+		assert.True(t, ok)
+		assert.Equal(t, "test.Greeter", service)
+		assert.Equal(t, "SayHello", name)
+	}
+	_, _, ok := SplitMethod("SayHello")
+	assert.False(t, ok)
+}
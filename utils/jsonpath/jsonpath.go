@@ -0,0 +1,107 @@
+// Package jsonpath looks up values in decoded JSON documents with a subset of
+// JSONPath: "$.items[0].id", "$['content-type']" and "items[-1]".
+package jsonpath
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrBadPath = errors.New("Invalid JSONPath. Paths are made of .field, ['field'] and [index] selectors")
+
+type selector struct {
+	field string
+	index int
+	isIdx bool
+}
+
+// Path is a compiled JSONPath.
+type Path struct {
+	text      string
+	selectors []selector
+}
+
+// Compile parses the path.
+func Compile(path string) (*Path, error) {
+	p := &Path{text: path}
+	s := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	if s != "" && s[0] != '.' && s[0] != '[' {
+		s = "." + s
+	}
+	for s != "" {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, ErrBadPath
+			}
+			p.selectors = append(p.selectors, selector{field: s[:end]})
+			s = s[end:]
+		case '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, ErrBadPath
+			}
+			inner := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				p.selectors = append(p.selectors, selector{field: inner[1 : len(inner)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, ErrBadPath
+			}
+			p.selectors = append(p.selectors, selector{index: index, isIdx: true})
+		default:
+			return nil, ErrBadPath
+		}
+	}
+	return p, nil
+}
+
+// Get returns the value at the path in a document decoded by encoding/json.
+func (p *Path) Get(doc interface{}) (interface{}, error) {
+	v := doc
+	for _, sel := range p.selectors {
+		if sel.isIdx {
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: not an array", p.text)
+			}
+			i := sel.index
+			if i < 0 {
+				i += len(list)
+			}
+			if i < 0 || i >= len(list) {
+				return nil, fmt.Errorf("%s: index %d out of range", p.text, sel.index)
+			}
+			v = list[i]
+			continue
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: not an object", p.text)
+		}
+		v, ok = obj[sel.field]
+		if !ok {
+			return nil, fmt.Errorf("%s: no field %q", p.text, sel.field)
+		}
+	}
+	return v, nil
+}
+
+// Get compiles the path and returns the value at it.
+func Get(doc interface{}, path string) (interface{}, error) {
+	p, err := Compile(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.Get(doc)
+}
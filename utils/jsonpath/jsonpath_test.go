@@ -0,0 +1,41 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet(t *testing.T) {
+	var doc interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"token": "abc",
+		"items": [{"id": 1}, {"id": 2, "tags": ["a", "b"]}],
+		"content-type": "json"
+	}`), &doc))
+
+	for path, expected := range map[string]interface{}{
+		"$":                   doc,
+		"$.token":             "abc",
+		"token":               "abc",
+		"$.items[0].id":       float64(1),
+		"$.items[-1].tags[1]": "b",
+		"$['content-type']":   "json",
+		`$["items"][1]["id"]`: float64(2),
+	} {
+		v, err := Get(doc, path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, expected, v, path)
+	}
+
+	for _, path := range []string{"$.missing", "$.items[2]", "$.token[0]", "$.items.id"} {
+		_, err := Get(doc, path)
+		assert.Error(t, err, path)
+	}
+
+	for _, path := range []string{"$..token", "$.items[x]", "$.items[0"} {
+		_, err := Compile(path)
+		assert.Equal(t, ErrBadPath, err, path)
+	}
+}
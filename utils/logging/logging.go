@@ -0,0 +1,136 @@
+// Package logging provides the per-module loggers used throughout Kala,
+// and a single place to configure their format and levels.
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Modules which have their own logger, and thereby their own level.
+const (
+	API       = "api"
+	Cache     = "cache"
+	Runner    = "runner"
+	DB        = "db"
+	Notify    = "notify"
+	Lifecycle = "lifecycle"
+)
+
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+// Config holds the logging configuration.
+type Config struct {
+	// Either TextFormat or JSONFormat. Defaults to TextFormat.
+	Format string
+	// Level of every module without its own level, e.g. "warn". Defaults to "info".
+	Level string
+	// Levels by module name, e.g. {"api": "debug"}.
+	ModuleLevels map[string]string
+}
+
+var (
+	lock    sync.Mutex
+	loggers = map[string]*log.Logger{}
+
+	formatter    log.Formatter = &log.TextFormatter{}
+	level                      = log.InfoLevel
+	moduleLevels               = map[string]log.Level{}
+)
+
+// GetLogger returns the logger of a module. Every line it logs has a
+// "module" field.
+func GetLogger(module string) *log.Entry {
+	lock.Lock()
+	defer lock.Unlock()
+
+	l, ok := loggers[module]
+	if !ok {
+		l = log.New()
+		configureLogger(module, l)
+		loggers[module] = l
+	}
+	return l.WithField("module", module)
+}
+
+// Configure applies c to the loggers of every module, as well as to the
+// standard logrus logger.
+func Configure(c Config) error {
+	newFormatter, err := parseFormat(c.Format)
+	if err != nil {
+		return err
+	}
+
+	newLevel := log.InfoLevel
+	if c.Level != "" {
+		newLevel, err = log.ParseLevel(c.Level)
+		if err != nil {
+			return err
+		}
+	}
+
+	newModuleLevels := map[string]log.Level{}
+	for module, l := range c.ModuleLevels {
+		newModuleLevels[module], err = log.ParseLevel(l)
+		if err != nil {
+			return fmt.Errorf("Invalid level for module %s: %s", module, err)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	formatter = newFormatter
+	level = newLevel
+	moduleLevels = newModuleLevels
+
+	for module, l := range loggers {
+		configureLogger(module, l)
+	}
+	log.SetFormatter(formatter)
+	log.SetLevel(level)
+
+	return nil
+}
+
+// ParseModuleLevels parses a list of module levels such as "api=debug,db=error".
+func ParseModuleLevels(s string) (map[string]string, error) {
+	levels := map[string]string{}
+	if s == "" {
+		return levels, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Module levels not formatted correctly. Should look like: api=debug,db=error")
+		}
+		levels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return levels, nil
+}
+
+// configureLogger must be called with the lock held.
+func configureLogger(module string, l *log.Logger) {
+	l.Formatter = formatter
+	l.Level = level
+	if moduleLevel, ok := moduleLevels[module]; ok {
+		l.Level = moduleLevel
+	}
+}
+
+func parseFormat(format string) (log.Formatter, error) {
+	switch format {
+	case "", TextFormat:
+		return &log.TextFormatter{}, nil
+	case JSONFormat:
+		return &log.JSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown log format '%s', should be either '%s' or '%s'", format, TextFormat, JSONFormat)
+	}
+}
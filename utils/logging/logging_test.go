@@ -0,0 +1,60 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigure(t *testing.T) {
+	defer Configure(Config{})
+
+	apiLog := GetLogger(API)
+	dbLog := GetLogger(DB)
+
+	err := Configure(Config{
+		Format:       JSONFormat,
+		Level:        "warn",
+		ModuleLevels: map[string]string{DB: "debug"},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, log.WarnLevel, apiLog.Logger.Level)
+	assert.Equal(t, log.DebugLevel, dbLog.Logger.Level)
+	assert.Equal(t, log.WarnLevel, GetLogger(Runner).Logger.Level)
+	assert.Equal(t, log.WarnLevel, log.GetLevel())
+
+	buf := new(bytes.Buffer)
+	dbLog.Logger.Out = buf
+	dbLog.WithField("job_id", "1").Debug("saved")
+
+	line := map[string]string{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "db", line["module"])
+	assert.Equal(t, "1", line["job_id"])
+	assert.Equal(t, "saved", line["msg"])
+}
+
+func TestConfigureErrors(t *testing.T) {
+	assert.Error(t, Configure(Config{Format: "xml"}))
+	assert.Error(t, Configure(Config{Level: "loud"}))
+	assert.Error(t, Configure(Config{ModuleLevels: map[string]string{API: "loud"}}))
+}
+
+func TestParseModuleLevels(t *testing.T) {
+	levels, err := ParseModuleLevels("api=debug, db=error")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"api": "debug", "db": "error"}, levels)
+
+	levels, err = ParseModuleLevels("")
+	assert.NoError(t, err)
+	assert.Empty(t, levels)
+
+	_, err = ParseModuleLevels("api")
+	assert.Error(t, err)
+	_, err = ParseModuleLevels("=debug")
+	assert.Error(t, err)
+}
@@ -0,0 +1,231 @@
+// Package validation checks jobs created through the API, returning every
+// violation at once with the field it's about, rather than failing on the
+// first like job.Job.Init.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/notify"
+	"github.com/ajvb/kala/utils/iso8601"
+)
+
+// Violation is an invalid field of a job, e.g. {"schedule", "..."}.
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors are the violations of a job.
+type Errors []Violation
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, v := range e {
+		messages[i] = v.Field + ": " + v.Message
+	}
+	return "Invalid job: " + strings.Join(messages, "; ")
+}
+
+type validator struct {
+	errs Errors
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, Violation{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Job checks a job before it's created: the format of its schedule, of its
+// repetition count and of its durations, the urls of remote jobs, the contacts
+// of its team, that its parent jobs exist in the cache in its namespace, and
+// that it doesn't set fields which exclude each other. It returns Errors with
+// every violation, or nil.
+func Job(j *job.Job, cache job.JobCache) error {
+	return validate(j, cache, time.Now())
+}
+
+// Update checks the new settings of an existing job like Job, except that
+// its schedule may start in the past if it's unchanged, as the job has been
+// running from it. current should be a copy of the job, see job.Job.Copy.
+func Update(j, current *job.Job, cache job.JobCache) error {
+	now := time.Now()
+	if j.Schedule == current.Schedule {
+		now = time.Time{}
+	}
+	return validate(j, cache, now)
+}
+
+// validate checks the job, whose schedule mustn't start before now.
+func validate(j *job.Job, cache job.JobCache, now time.Time) error {
+	v := &validator{}
+	if j.Name == "" {
+		v.add("name", "is required")
+	}
+	if j.JobType == job.LocalJob && j.Command == "" {
+		v.add("command", "is required")
+	}
+	if !job.ValidNamespace(j.Namespace) {
+		v.add("namespace", "should be lowercase letters, digits and dashes, e.g. data-team, got %q", j.Namespace)
+	}
+	if !job.ValidGroup(j.Group) {
+		v.add("group", "should be names of lowercase letters, digits, dashes and underscores nested with dots, e.g. etl.nightly, got %q", j.Group)
+	}
+	v.schedule(j.Schedule, now)
+	if j.Epsilon != "" {
+		if _, err := iso8601.FromString(j.Epsilon); err != nil {
+			v.add("epsilon", "should be an ISO 8601 duration, e.g. PT1H, got %q", j.Epsilon)
+		}
+	}
+	if j.JobType == job.RemoteJob {
+		v.remote(&j.RemoteProperties)
+	}
+	if j.Ownership != nil {
+		v.ownership(j.Ownership)
+	}
+	v.dependencies(j, cache)
+	v.exclusive(j)
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// schedule checks schedules such as R/2014-03-08T20:00:00Z/PT2H, as parsed
+// by job.Job.InitDelayDuration.
+func (v *validator) schedule(schedule string, now time.Time) {
+	if schedule == "" {
+		return
+	}
+	parts := strings.Split(schedule, "/")
+	if len(parts) != 3 {
+		v.add("schedule", "should look like R/2014-03-08T20:00:00Z/PT2H, got %q", schedule)
+		return
+	}
+
+	repeat := int64(-1)
+	if parts[0] != "R" {
+		n, err := strconv.ParseInt(strings.TrimPrefix(parts[0], "R"), 10, 0)
+		if !strings.HasPrefix(parts[0], "R") || err != nil || n < 0 {
+			v.add("schedule", "the repetition count should be R for forever, or R followed by a number, e.g. R5, got %q", parts[0])
+		}
+		repeat = n
+	}
+
+	start, err := time.Parse(time.RFC3339, parts[1])
+	if err != nil {
+		start, err = time.Parse(job.RFC3339WithoutTimezone, parts[1])
+	}
+	if err != nil {
+		v.add("schedule", "the start should be an RFC 3339 time, e.g. 2014-03-08T20:00:00Z, got %q", parts[1])
+	} else if start.Before(now) {
+		v.add("schedule", "the start %s is in the past", parts[1])
+	}
+
+	if repeat != 0 {
+		d, err := iso8601.FromString(parts[2])
+		if err != nil {
+			v.add("schedule", "the interval should be an ISO 8601 duration, e.g. PT2H, got %q", parts[2])
+		} else if d.ToDuration() <= 0 {
+			v.add("schedule", "the interval of a repeating job can't be zero")
+		}
+	}
+}
+
+// remote checks the urls of remote jobs, which are templates in steps.
+func (v *validator) remote(p *job.RemoteProperties) {
+	if len(p.Steps) == 0 {
+		if p.Url == "" {
+			v.add("remote_properties.url", "is required")
+		} else if msg := invalidURL(p.Url); msg != "" {
+			v.add("remote_properties.url", "%s", msg)
+		}
+		return
+	}
+	for i, step := range p.Steps {
+		field := fmt.Sprintf("remote_properties.steps[%d].url", i)
+		if step.Url == "" {
+			v.add(field, "is required")
+		} else if !strings.Contains(step.Url, "{{") {
+			if msg := invalidURL(step.Url); msg != "" {
+				v.add(field, "%s", msg)
+			}
+		}
+	}
+}
+
+var slackPattern = regexp.MustCompile(`^[@#][^\s@#]+$`)
+
+// ownership checks the contacts of the team owning a job.
+func (v *validator) ownership(o *notify.Ownership) {
+	if o.Team == "" {
+		v.add("ownership.team", "is required")
+	}
+	if o.Email != "" {
+		if _, err := mail.ParseAddress(o.Email); err != nil {
+			v.add("ownership.email", "should be an email address, e.g. data-team@example.com, got %q", o.Email)
+		}
+	}
+	if o.Slack != "" && !slackPattern.MatchString(o.Slack) {
+		v.add("ownership.slack", "should be a Slack handle or channel, e.g. @data-oncall or #data, got %q", o.Slack)
+	}
+	if o.RunbookURL != "" {
+		if msg := invalidURL(o.RunbookURL); msg != "" {
+			v.add("ownership.runbook_url", "%s", msg)
+		}
+	}
+}
+
+func invalidURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Sprintf("should be an absolute http or https url, got %q", rawurl)
+	}
+	return ""
+}
+
+// dependencies checks that the jobs a job depends on exist, in its namespace.
+// The namespaces of jobs aren't changed by runs, so they're read without the
+// lock of the jobs.
+func (v *validator) dependencies(j *job.Job, cache job.JobCache) {
+	for i, id := range j.ParentJobs {
+		if parent, err := cache.Get(id); err != nil || parent == nil {
+			v.add(fmt.Sprintf("parent_jobs[%d]", i), "the job %s doesn't exist", id)
+		} else if parent.Namespace != j.Namespace {
+			v.add(fmt.Sprintf("parent_jobs[%d]", i), "the job %s is in another namespace", id)
+		}
+	}
+	if j.OnFailureJob != "" {
+		if failureJob, err := cache.Get(j.OnFailureJob); err != nil || failureJob == nil {
+			v.add("on_failure_job", "the job %s doesn't exist", j.OnFailureJob)
+		} else if failureJob.Namespace != j.Namespace {
+			v.add("on_failure_job", "the job %s is in another namespace", j.OnFailureJob)
+		}
+	}
+}
+
+// exclusive checks fields which exclude each other, since one of them would
+// be ignored.
+func (v *validator) exclusive(j *job.Job) {
+	if len(j.ParentJobs) != 0 {
+		if j.Schedule != "" {
+			v.add("schedule", "can't be set with parent_jobs, as dependent jobs run after their parents")
+		}
+		if j.TriggerSubject != "" {
+			v.add("trigger_subject", "can't be set with parent_jobs, as dependent jobs run after their parents")
+		}
+	}
+	if j.JobType == job.RemoteJob && len(j.RemoteProperties.Steps) != 0 && j.RemoteProperties.Url != "" {
+		v.add("remote_properties.url", "can't be set with steps, which have their own urls")
+	}
+	if j.JobType != job.LocalJob && j.Command != "" {
+		v.add("command", "can only be set for local jobs")
+	}
+}
@@ -0,0 +1,125 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ajvb/kala/job"
+	"github.com/ajvb/kala/notify"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fields(err error) []string {
+	fields := []string{}
+	for _, v := range err.(Errors) {
+		fields = append(fields, v.Field)
+	}
+	return fields
+}
+
+func TestValidJob(t *testing.T) {
+	cache := job.NewMockCache()
+	parent := job.GetMockJob()
+	assert.NoError(t, parent.Init(cache))
+
+	j := job.GetMockRecurringJobWithSchedule(time.Now().Add(time.Hour), "PT1H")
+	assert.NoError(t, Job(j, cache))
+
+	j = job.GetMockJob()
+	j.ParentJobs = []string{parent.Id}
+	assert.NoError(t, Job(j, cache))
+
+	remote := job.GetMockRemoteJob(job.RemoteProperties{Url: "https://example.com/ping"})
+	assert.NoError(t, Job(remote, cache))
+
+	remote = job.GetMockRemoteJob(job.RemoteProperties{Steps: []job.RemoteStep{
+		{Url: "https://example.com/login"},
+		{Url: "{{.Steps.step1.Values.next}}"},
+	}})
+	assert.NoError(t, Job(remote, cache))
+}
+
+func TestJobViolations(t *testing.T) {
+	cache := job.NewMockCache()
+
+	j := &job.Job{
+		Command:      "bash -c 'date'",
+		Schedule:     "R-1/2001-01-01T00:00:00Z/one hour",
+		Epsilon:      "1h",
+		ParentJobs:   []string{"not-a-real-id"},
+		OnFailureJob: "not-a-real-id",
+	}
+	err := Job(j, cache)
+	assert.Equal(t, []string{
+		"name",
+		"schedule", "schedule", "schedule",
+		"epsilon",
+		"parent_jobs[0]", "on_failure_job",
+		"schedule",
+	}, fields(err))
+	assert.Contains(t, err.Error(), "the start 2001-01-01T00:00:00Z is in the past")
+
+	assert.Equal(t, []string{"schedule"}, fields(Job(&job.Job{Name: "j", Command: "date", Schedule: "asdf"}, cache)))
+
+	remote := job.GetMockRemoteJob(job.RemoteProperties{Url: "example.com"})
+	remote.Command = "date"
+	assert.Equal(t, []string{"remote_properties.url", "command"}, fields(Job(remote, cache)))
+
+	remote = job.GetMockRemoteJob(job.RemoteProperties{Url: "https://example.com", Steps: []job.RemoteStep{{Url: "ftp://example.com"}}})
+	assert.Equal(t, []string{"remote_properties.steps[0].url", "remote_properties.url"}, fields(Job(remote, cache)))
+}
+
+func TestJobNamespaceViolations(t *testing.T) {
+	cache := job.NewMockCache()
+	parent := job.GetMockJob()
+	parent.Namespace = "data"
+	assert.NoError(t, parent.Init(cache))
+
+	j := job.GetMockJob()
+	j.Namespace = "data"
+	j.ParentJobs = []string{parent.Id}
+	assert.NoError(t, Job(j, cache))
+
+	j = job.GetMockJob()
+	j.Namespace = "Data Team"
+	j.ParentJobs = []string{parent.Id}
+	j.OnFailureJob = parent.Id
+	assert.Equal(t, []string{"namespace", "parent_jobs[0]", "on_failure_job"}, fields(Job(j, cache)))
+}
+
+func TestJobGroupViolations(t *testing.T) {
+	cache := job.NewMockCache()
+
+	j := job.GetMockJob()
+	j.Group = "etl.nightly"
+	assert.NoError(t, Job(j, cache))
+
+	for _, group := range []string{"ETL", "etl.", ".etl", "etl..nightly", "etl/nightly"} {
+		j.Group = group
+		assert.Equal(t, []string{"group"}, fields(Job(j, cache)), group)
+	}
+}
+
+func TestUpdateViolations(t *testing.T) {
+	cache := job.NewMockCache()
+	current := job.GetMockRecurringJobWithSchedule(time.Now().Add(-time.Hour), "PT1H")
+
+	// The job keeps running from its schedule, but can't be moved to the past.
+	j := job.GetMockRecurringJobWithSchedule(time.Now().Add(-time.Hour), "PT1H")
+	j.Schedule = current.Schedule
+	assert.NoError(t, Update(j, current, cache))
+	j = job.GetMockRecurringJobWithSchedule(time.Now().Add(-2*time.Hour), "PT1H")
+	assert.Equal(t, []string{"schedule"}, fields(Update(j, current, cache)))
+}
+
+func TestJobOwnershipViolations(t *testing.T) {
+	cache := job.NewMockCache()
+
+	j := job.GetMockJob()
+	j.Ownership = &notify.Ownership{Team: "data", Email: "data@example.com", Slack: "@data-oncall", RunbookURL: "https://wiki.example.com/backup"}
+	assert.NoError(t, Job(j, cache))
+
+	j.Ownership = &notify.Ownership{Email: "data team", Slack: "data oncall", RunbookURL: "wiki/backup"}
+	assert.Equal(t, []string{"ownership.team", "ownership.email", "ownership.slack", "ownership.runbook_url"}, fields(Job(j, cache)))
+}